@@ -13,12 +13,25 @@ type SentPacketHandler interface {
 	ReceivedAck(ackFrame *frames.AckFrame, withPacketNumber protocol.PacketNumber, recvTime time.Time) error
 
 	SendingAllowed() bool
+	// AckOnlyPacketAllowed says if a packet containing only an ACK may be sent.
+	// Such packets are not limited by the congestion window, but they still need to be tracked.
+	AckOnlyPacketAllowed() bool
+	// TimeUntilSend returns the time when the next packet may be sent, if pacing is enabled.
+	// It returns the zero value if pacing is disabled or no packet was sent yet.
+	TimeUntilSend() time.Time
 	GetStopWaitingFrame(force bool) *frames.StopWaitingFrame
 	DequeuePacketForRetransmission() (packet *Packet)
 	GetLeastUnacked() protocol.PacketNumber
+	// GetBytesInFlight returns the number of bytes sent, but neither acknowledged nor declared lost.
+	GetBytesInFlight() protocol.ByteCount
+
+	// SetHandshakeComplete is called when the crypto handshake completes.
+	// Until then, handshake packets are retransmitted using a separate timer, with exponential backoff.
+	SetHandshakeComplete()
 
 	GetAlarmTimeout() time.Time
-	OnAlarm()
+	// OnAlarm is called when the alarm fires. It returns an error if the connection should be closed.
+	OnAlarm() error
 }
 
 // ReceivedPacketHandler handles ACKs needed to send for incoming packets
@@ -14,6 +14,9 @@ type Packet struct {
 	Frames          []frames.Frame
 	Length          protocol.ByteCount
 	EncryptionLevel protocol.EncryptionLevel
+	// IsMTUProbe is set for probes sent by the path MTU discovery
+	// they are neither retransmitted nor counted as bytes in flight, so losing them doesn't affect the congestion controller
+	IsMTUProbe bool
 
 	SendTime time.Time
 }
@@ -35,7 +35,8 @@ type receivedPacketHandler struct {
 }
 
 // NewReceivedPacketHandler creates a new receivedPacketHandler
-func NewReceivedPacketHandler(ackAlarmResetCallback func(time.Time)) ReceivedPacketHandler {
+// ACKs for retransmittable packets are delayed by at most ackSendDelay
+func NewReceivedPacketHandler(ackAlarmResetCallback func(time.Time), ackSendDelay time.Duration) ReceivedPacketHandler {
 	// create a stopped timer, see https://github.com/golang/go/issues/12721#issuecomment-143010182
 	timer := time.NewTimer(0)
 	<-timer.C
@@ -43,7 +44,7 @@ func NewReceivedPacketHandler(ackAlarmResetCallback func(time.Time)) ReceivedPac
 	return &receivedPacketHandler{
 		packetHistory:         newReceivedPacketHistory(),
 		ackAlarmResetCallback: ackAlarmResetCallback,
-		ackSendDelay:          protocol.AckSendDelay,
+		ackSendDelay:          ackSendDelay,
 	}
 }
 
@@ -146,6 +147,10 @@ func (h *receivedPacketHandler) GetAckFrame() *frames.AckFrame {
 	}
 
 	ackRanges := h.packetHistory.GetAckRanges()
+	// keep the ACK frame small, by only reporting the ranges with the highest packet numbers
+	if len(ackRanges) > protocol.MaxAckFrameRanges {
+		ackRanges = ackRanges[:protocol.MaxAckFrameRanges]
+	}
 	ack := &frames.AckFrame{
 		LargestAcked:       h.largestObserved,
 		LowestAcked:        ackRanges[len(ackRanges)-1].FirstPacketNumber,
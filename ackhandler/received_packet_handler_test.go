@@ -1,6 +1,8 @@
 package ackhandler
 
 import (
+	"bytes"
+	"math/rand"
 	"time"
 
 	"github.com/lucas-clemente/quic-go/frames"
@@ -22,7 +24,7 @@ var _ = Describe("receivedPacketHandler", func() {
 
 	BeforeEach(func() {
 		ackAlarmCallbackCalled = false
-		handler = NewReceivedPacketHandler(ackAlarmCallback).(*receivedPacketHandler)
+		handler = NewReceivedPacketHandler(ackAlarmCallback, protocol.AckSendDelay).(*receivedPacketHandler)
 	})
 
 	Context("accepting packets", func() {
@@ -217,6 +219,31 @@ var _ = Describe("receivedPacketHandler", func() {
 				Expect(handler.ackQueued).To(BeTrue())
 			})
 
+			It("batches the ACKs for packets received in order", func() {
+				var numAcks int
+				for i := 1; i <= 100; i++ {
+					err := handler.ReceivedPacket(protocol.PacketNumber(i), true)
+					Expect(err).ToNot(HaveOccurred())
+					if handler.GetAckFrame() != nil {
+						numAcks++
+					}
+				}
+				// the first packet is acknowledged immediately, after that every second packet
+				Expect(numAcks).To(Equal(50))
+			})
+
+			It("uses the configured maximum ACK delay", func() {
+				handler = NewReceivedPacketHandler(ackAlarmCallback, 5*time.Millisecond).(*receivedPacketHandler)
+				receiveAndAck10Packets()
+				err := handler.ReceivedPacket(11, true)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(handler.ackQueued).To(BeFalse())
+				Expect(handler.ackAlarm).To(BeTemporally("~", time.Now().Add(5*time.Millisecond), time.Millisecond))
+				Expect(handler.GetAckFrame()).To(BeNil())
+				time.Sleep(5 * time.Millisecond)
+				Expect(handler.GetAckFrame()).ToNot(BeNil())
+			})
+
 			It("queues an ACK if it creates a new missing range", func() {
 				receiveAndAck10Packets()
 				for i := 11; i < 16; i++ {
@@ -274,6 +301,45 @@ var _ = Describe("receivedPacketHandler", func() {
 				Expect(ack.AckRanges[1]).To(Equal(frames.AckRange{FirstPacketNumber: 1, LastPacketNumber: 1}))
 			})
 
+			It("coalesces contiguous packet numbers into ACK ranges", func() {
+				// receive packets 1 to 1000 in random order, except for 100 and 500 to 510
+				for _, i := range rand.Perm(1000) {
+					p := protocol.PacketNumber(i + 1)
+					if p == 100 || (p >= 500 && p <= 510) {
+						continue
+					}
+					err := handler.ReceivedPacket(p, true)
+					Expect(err).ToNot(HaveOccurred())
+				}
+				ack := handler.GetAckFrame()
+				Expect(ack).ToNot(BeNil())
+				Expect(ack.LargestAcked).To(Equal(protocol.PacketNumber(1000)))
+				Expect(ack.LowestAcked).To(Equal(protocol.PacketNumber(1)))
+				Expect(ack.AckRanges).To(Equal([]frames.AckRange{
+					{FirstPacketNumber: 511, LastPacketNumber: 1000},
+					{FirstPacketNumber: 101, LastPacketNumber: 499},
+					{FirstPacketNumber: 1, LastPacketNumber: 99},
+				}))
+				// the ACK frame only needs a few bytes
+				b := &bytes.Buffer{}
+				Expect(ack.Write(b, protocol.VersionWhatever)).To(Succeed())
+				Expect(b.Len()).To(BeNumerically("<", 20))
+			})
+
+			It("limits the number of ACK ranges, omitting the ranges with the lowest packet numbers", func() {
+				for i := 1; i <= 2*protocol.MaxAckFrameRanges; i++ {
+					err := handler.ReceivedPacket(protocol.PacketNumber(2*i), true)
+					Expect(err).ToNot(HaveOccurred())
+				}
+				ack := handler.GetAckFrame()
+				Expect(ack).ToNot(BeNil())
+				Expect(ack.AckRanges).To(HaveLen(protocol.MaxAckFrameRanges))
+				Expect(ack.LargestAcked).To(Equal(protocol.PacketNumber(4 * protocol.MaxAckFrameRanges)))
+				Expect(ack.AckRanges[0]).To(Equal(frames.AckRange{FirstPacketNumber: 4 * protocol.MaxAckFrameRanges, LastPacketNumber: 4 * protocol.MaxAckFrameRanges}))
+				Expect(ack.LowestAcked).To(Equal(protocol.PacketNumber(2*protocol.MaxAckFrameRanges + 2)))
+				Expect(ack.AckRanges[protocol.MaxAckFrameRanges-1].FirstPacketNumber).To(Equal(ack.LowestAcked))
+			})
+
 			It("deletes packets from the packetHistory after receiving a StopWaiting, after continuously received packets", func() {
 				for i := 1; i <= 12; i++ {
 					err := handler.ReceivedPacket(protocol.PacketNumber(i), true)
@@ -16,12 +16,27 @@ const (
 	// Maximum reordering in time space before time based loss detection considers a packet lost.
 	// In fraction of an RTT.
 	timeReorderingFraction = 1.0 / 8
+	// The duplicate ACK threshold, as in TCP. If fewer packets are outstanding, early retransmit is used.
+	packetReorderingThreshold = 3
+	// When early retransmit is used, a packet is considered lost after this fraction of an RTT.
+	earlyRetransmitFraction = 1.0 / 4
 	// defaultRTOTimeout is the RTO time on new connections
 	defaultRTOTimeout = 500 * time.Millisecond
 	// Minimum time in the future an RTO alarm may be set for.
 	minRTOTimeout = 200 * time.Millisecond
 	// maxRTOTimeout is the maximum RTO time
 	maxRTOTimeout = 60 * time.Second
+	// maxTailLossProbes is the number of tail loss probes sent before an RTO
+	maxTailLossProbes = 2
+	// Minimum time in the future a tail loss probe alarm may be set for.
+	minTailLossProbeTimeout = 10 * time.Millisecond
+	// defaultInitialRTT is the RTT used for the handshake retransmission timeout, before an RTT sample is available
+	defaultInitialRTT = 100 * time.Millisecond
+	// Minimum time in the future a handshake retransmission alarm may be set for.
+	// Processing handshake messages takes time, and the ACK might be delayed, so this is larger than the minimum TLP timeout.
+	minHandshakeTimeout = 100 * time.Millisecond
+	// maxHandshakeRetransmissions is the number of times the outstanding handshake packets are retransmitted without receiving an ACK, before giving up
+	maxHandshakeRetransmissions = 8
 )
 
 var (
@@ -31,7 +46,9 @@ var (
 	ErrTooManyTrackedSentPackets = errors.New("Too many outstanding non-acked and non-retransmitted packets")
 	// ErrAckForSkippedPacket occurs when the client sent an ACK for a packet number that we intentionally skipped
 	ErrAckForSkippedPacket = qerr.Error(qerr.InvalidAckData, "Received an ACK for a skipped packet number")
-	errAckForUnsentPacket  = qerr.Error(qerr.InvalidAckData, "Received ACK for an unsent package")
+	// ErrTooManyHandshakeRetransmissions occurs when the handshake packets were retransmitted maxHandshakeRetransmissions times without receiving an ACK
+	ErrTooManyHandshakeRetransmissions = qerr.Error(qerr.HandshakeTimeout, "Crypto handshake packets were retransmitted too many times")
+	errAckForUnsentPacket              = qerr.Error(qerr.InvalidAckData, "Received ACK for an unsent package")
 )
 
 var errPacketNumberNotIncreasing = errors.New("Already sent a packet with a higher packet number")
@@ -39,6 +56,8 @@ var errPacketNumberNotIncreasing = errors.New("Already sent a packet with a high
 type sentPacketHandler struct {
 	lastSentPacketNumber protocol.PacketNumber
 	skippedPackets       []protocol.PacketNumber
+	// packet numbers of packets declared lost by the loss detection, used to detect spurious retransmissions
+	lostPackets []protocol.PacketNumber
 
 	LargestAcked protocol.PacketNumber
 
@@ -48,6 +67,11 @@ type sentPacketHandler struct {
 	stopWaitingManager stopWaitingManager
 
 	retransmissionQueue []*Packet
+	// tailLossProbe is a copy of the last packet sent, queued when the TLP alarm fires.
+	// It is not counted towards the tracked packets, since the packet is still in the packetHistory.
+	tailLossProbe *Packet
+	// The number of packets that may still be sent when the congestion window is full, to send TLPs and RTOs.
+	numProbesToSend int
 
 	bytesInFlight protocol.ByteCount
 
@@ -56,29 +80,33 @@ type sentPacketHandler struct {
 
 	// The number of times an RTO has been sent without receiving an ack.
 	rtoCount uint32
+	// The number of tail loss probes sent without receiving an ack.
+	tlpCount uint32
+	// The number of times the handshake packets have been retransmitted without receiving an ack.
+	handshakeCount uint32
+	// handshakeComplete is set when the crypto handshake completed. From then on, handshake packets are handled by the regular loss detection.
+	handshakeComplete bool
 
 	// The time at which the next packet will be considered lost based on early transmit or exceeding the reordering window in time.
 	lossTime time.Time
 
 	// The alarm timeout
 	alarm time.Time
-}
 
-// NewSentPacketHandler creates a new sentPacketHandler
-func NewSentPacketHandler(rttStats *congestion.RTTStats) SentPacketHandler {
-	congestion := congestion.NewCubicSender(
-		congestion.DefaultClock{},
-		rttStats,
-		false, /* don't use reno since chromium doesn't (why?) */
-		protocol.InitialCongestionWindow,
-		protocol.DefaultMaxCongestionWindow,
-	)
+	pacing bool
+	// The time at which the next packet may be sent, if pacing is enabled.
+	nextPacketSendTime time.Time
+}
 
+// NewSentPacketHandler creates a new sentPacketHandler, using sendAlgorithm for congestion control
+// If pacing is enabled, packets are spread evenly over one RTT, instead of sending the whole congestion window at once.
+func NewSentPacketHandler(rttStats *congestion.RTTStats, sendAlgorithm congestion.SendAlgorithm, pacing bool) SentPacketHandler {
 	return &sentPacketHandler{
 		packetHistory:      NewPacketList(),
 		stopWaitingManager: stopWaitingManager{},
 		rttStats:           rttStats,
-		congestion:         congestion,
+		congestion:         sendAlgorithm,
+		pacing:             pacing,
 	}
 }
 
@@ -94,7 +122,8 @@ func (h *sentPacketHandler) SentPacket(packet *Packet) error {
 		return errPacketNumberNotIncreasing
 	}
 
-	if protocol.PacketNumber(len(h.retransmissionQueue)+h.packetHistory.Len()+1) > protocol.MaxTrackedSentPackets {
+	// MTU probes are not tracked, so they can be sent even if the maximum number of tracked packets is reached
+	if !packet.IsMTUProbe && protocol.PacketNumber(len(h.retransmissionQueue)+h.packetHistory.Len()+1) > protocol.MaxTrackedSentPackets {
 		return ErrTooManyTrackedSentPackets
 	}
 
@@ -111,10 +140,16 @@ func (h *sentPacketHandler) SentPacket(packet *Packet) error {
 	if packet.Length == 0 {
 		return errors.New("SentPacketHandler: packet cannot be empty")
 	}
+	h.lastSentPacketNumber = packet.PacketNumber
+	if packet.IsMTUProbe {
+		return nil
+	}
 	h.bytesInFlight += packet.Length
 
-	h.lastSentPacketNumber = packet.PacketNumber
 	h.packetHistory.PushBack(*packet)
+	if h.numProbesToSend > 0 {
+		h.numProbesToSend--
+	}
 
 	h.congestion.OnPacketSent(
 		now,
@@ -124,6 +159,10 @@ func (h *sentPacketHandler) SentPacket(packet *Packet) error {
 		true, /* TODO: is retransmittable */
 	)
 
+	if h.pacing {
+		h.nextPacketSendTime = utils.MaxTime(h.nextPacketSendTime, now).Add(h.pacingDelay(packet.Length))
+	}
+
 	h.updateLossDetectionAlarm()
 
 	return nil
@@ -140,6 +179,9 @@ func (h *sentPacketHandler) ReceivedAck(ackFrame *frames.AckFrame, withPacketNum
 	}
 	h.largestReceivedPacketWithAck = withPacketNumber
 
+	// a reordered ACK might still ack packets that were already declared lost
+	h.detectSpuriousLosses(ackFrame)
+
 	// ignore repeated ACK (ACKs that don't have a higher LargestAcked than the last ACK)
 	if ackFrame.LargestAcked <= h.largestInOrderAcked() {
 		return nil
@@ -237,11 +279,15 @@ func (h *sentPacketHandler) updateLossDetectionAlarm() {
 		return
 	}
 
-	// TODO(#496): Handle handshake packets separately
-	// TODO(#497): TLP
-	if !h.lossTime.IsZero() {
+	if h.retransmitHandshakePackets() {
+		// Handshake packets are retransmitted with their own timer, and don't affect the congestion controller
+		h.alarm = time.Now().Add(h.computeHandshakeTimeout())
+	} else if !h.lossTime.IsZero() {
 		// Early retransmit timer or time loss detection.
 		h.alarm = h.lossTime
+	} else if h.shouldSendTailLossProbe() {
+		// TLP
+		h.alarm = time.Now().Add(h.computeTLPTimeout())
 	} else {
 		// RTO
 		h.alarm = time.Now().Add(h.computeRTOTimeout())
@@ -254,6 +300,11 @@ func (h *sentPacketHandler) detectLostPackets() {
 
 	maxRTT := float64(utils.MaxDuration(h.rttStats.LatestRTT(), h.rttStats.SmoothedRTT()))
 	delayUntilLost := time.Duration((1.0 + timeReorderingFraction) * maxRTT)
+	// Early retransmit: if the newest packet was acked, but too few packets are outstanding to ever be acked out of order packetReorderingThreshold times,
+	// use a shorter delay, instead of waiting for the RTO.
+	if h.LargestAcked == h.lastSentPacketNumber && h.packetHistory.Len() < packetReorderingThreshold {
+		delayUntilLost = time.Duration(earlyRetransmitFraction * maxRTT)
+	}
 
 	var lostPackets []*PacketElement
 	for el := h.packetHistory.Front(); el != nil; el = el.Next() {
@@ -274,25 +325,53 @@ func (h *sentPacketHandler) detectLostPackets() {
 
 	if len(lostPackets) > 0 {
 		for _, p := range lostPackets {
+			h.lostPackets = append(h.lostPackets, p.Value.PacketNumber)
+			if len(h.lostPackets) > protocol.MaxTrackedLostPackets {
+				h.lostPackets = h.lostPackets[1:]
+			}
 			h.queuePacketForRetransmission(p)
 			h.congestion.OnPacketLost(p.Value.PacketNumber, p.Value.Length, h.bytesInFlight)
 		}
 	}
 }
 
-func (h *sentPacketHandler) OnAlarm() {
-	// TODO(#496): Handle handshake packets separately
-	// TODO(#497): TLP
-	if !h.lossTime.IsZero() {
+// detectSpuriousLosses informs the congestion controller about packets that were declared lost, but are acked now
+func (h *sentPacketHandler) detectSpuriousLosses(ackFrame *frames.AckFrame) {
+	var stillLost []protocol.PacketNumber
+	for _, p := range h.lostPackets {
+		if ackFrame.AcksPacket(p) {
+			utils.Debugf("\tPacket 0x%x was acked after it was declared lost", p)
+			h.congestion.OnSpuriousLoss(p)
+			continue
+		}
+		stillLost = append(stillLost, p)
+	}
+	h.lostPackets = stillLost
+}
+
+func (h *sentPacketHandler) OnAlarm() error {
+	if h.retransmitHandshakePackets() {
+		if h.handshakeCount >= maxHandshakeRetransmissions {
+			return ErrTooManyHandshakeRetransmissions
+		}
+		h.queueHandshakePacketsForRetransmission()
+		h.handshakeCount++
+	} else if !h.lossTime.IsZero() {
 		// Early retransmit or time loss detection
 		h.detectLostPackets()
+	} else if h.shouldSendTailLossProbe() {
+		// TLP
+		h.queueTailLossProbe()
+		h.numProbesToSend = 1
+		h.tlpCount++
 	} else {
 		// RTO
-		h.retransmitOldestTwoPackets()
+		h.numProbesToSend = h.retransmitOldestTwoPackets()
 		h.rtoCount++
 	}
 
 	h.updateLossDetectionAlarm()
+	return nil
 }
 
 func (h *sentPacketHandler) GetAlarmTimeout() time.Time {
@@ -302,11 +381,17 @@ func (h *sentPacketHandler) GetAlarmTimeout() time.Time {
 func (h *sentPacketHandler) onPacketAcked(packetElement *PacketElement) {
 	h.bytesInFlight -= packetElement.Value.Length
 	h.rtoCount = 0
-	// TODO(#497): h.tlpCount = 0
+	h.tlpCount = 0
+	h.handshakeCount = 0
 	h.packetHistory.Remove(packetElement)
 }
 
 func (h *sentPacketHandler) DequeuePacketForRetransmission() *Packet {
+	if h.tailLossProbe != nil {
+		packet := h.tailLossProbe
+		h.tailLossProbe = nil
+		return packet
+	}
 	if len(h.retransmissionQueue) == 0 {
 		return nil
 	}
@@ -321,28 +406,58 @@ func (h *sentPacketHandler) GetLeastUnacked() protocol.PacketNumber {
 	return h.largestInOrderAcked() + 1
 }
 
+func (h *sentPacketHandler) GetBytesInFlight() protocol.ByteCount {
+	return h.bytesInFlight
+}
+
 func (h *sentPacketHandler) GetStopWaitingFrame(force bool) *frames.StopWaitingFrame {
 	return h.stopWaitingManager.GetStopWaitingFrame(force)
 }
 
 func (h *sentPacketHandler) SendingAllowed() bool {
 	congestionLimited := h.bytesInFlight > h.congestion.GetCongestionWindow()
-	maxTrackedLimited := protocol.PacketNumber(len(h.retransmissionQueue)+h.packetHistory.Len()) >= protocol.MaxTrackedSentPackets
 	if congestionLimited {
 		utils.Debugf("Congestion limited: bytes in flight %d, window %d",
 			h.bytesInFlight,
 			h.congestion.GetCongestionWindow())
 	}
-	return !(congestionLimited || maxTrackedLimited)
+	// TLPs and RTOs are sent even if the congestion window is full, otherwise the connection would stall
+	return !h.maxTrackedLimited() && (!congestionLimited || h.numProbesToSend > 0)
 }
 
-func (h *sentPacketHandler) retransmitOldestTwoPackets() {
-	if p := h.packetHistory.Front(); p != nil {
-		h.queueRTO(p)
+func (h *sentPacketHandler) AckOnlyPacketAllowed() bool {
+	return !h.maxTrackedLimited()
+}
+
+func (h *sentPacketHandler) maxTrackedLimited() bool {
+	return protocol.PacketNumber(len(h.retransmissionQueue)+h.packetHistory.Len()) >= protocol.MaxTrackedSentPackets
+}
+
+func (h *sentPacketHandler) TimeUntilSend() time.Time {
+	return h.nextPacketSendTime
+}
+
+// pacingDelay calculates the time it takes to send a packet of the given length, when the congestion window is sent evenly over one smoothed RTT
+func (h *sentPacketHandler) pacingDelay(length protocol.ByteCount) time.Duration {
+	srtt := h.rttStats.SmoothedRTT()
+	cwnd := h.congestion.GetCongestionWindow()
+	if srtt == 0 || cwnd == 0 {
+		return 0
 	}
-	if p := h.packetHistory.Front(); p != nil {
+	return time.Duration(int64(srtt) * int64(length) / int64(cwnd))
+}
+
+// retransmitOldestTwoPackets queues the two oldest outstanding packets for retransmission, and returns the number of packets queued
+func (h *sentPacketHandler) retransmitOldestTwoPackets() int {
+	var n int
+	for ; n < 2; n++ {
+		p := h.packetHistory.Front()
+		if p == nil {
+			break
+		}
 		h.queueRTO(p)
 	}
+	return n
 }
 
 func (h *sentPacketHandler) queueRTO(el *PacketElement) {
@@ -357,6 +472,54 @@ func (h *sentPacketHandler) queueRTO(el *PacketElement) {
 	h.congestion.OnRetransmissionTimeout(true)
 }
 
+// queueTailLossProbe retransmits the last packet sent, to elicit an ACK from the peer.
+// In contrast to an RTO, the packet is not declared lost: it stays outstanding, and the congestion controller and the StopWaitingManager are not informed.
+func (h *sentPacketHandler) queueTailLossProbe() {
+	el := h.packetHistory.Back()
+	if el == nil {
+		return
+	}
+	utils.Debugf("\tQueueing packet 0x%x for retransmission (TLP), %d outstanding", el.Value.PacketNumber, h.packetHistory.Len())
+	probe := el.Value
+	h.tailLossProbe = &probe
+}
+
+// queueHandshakePacketsForRetransmission retransmits all outstanding handshake packets.
+// In contrast to an RTO, the congestion controller is not informed.
+func (h *sentPacketHandler) queueHandshakePacketsForRetransmission() {
+	var next *PacketElement
+	for el := h.packetHistory.Front(); el != nil; el = next {
+		next = el.Next()
+		if isHandshakePacket(&el.Value) {
+			utils.Debugf("\tQueueing packet 0x%x for retransmission (handshake), %d outstanding", el.Value.PacketNumber, h.packetHistory.Len())
+			h.queuePacketForRetransmission(el)
+		}
+	}
+}
+
+func (h *sentPacketHandler) SetHandshakeComplete() {
+	h.handshakeComplete = true
+	h.updateLossDetectionAlarm()
+}
+
+// retransmitHandshakePackets says if the next alarm retransmits the handshake packets
+func (h *sentPacketHandler) retransmitHandshakePackets() bool {
+	if h.handshakeComplete {
+		return false
+	}
+	for el := h.packetHistory.Front(); el != nil; el = el.Next() {
+		if isHandshakePacket(&el.Value) {
+			return true
+		}
+	}
+	return false
+}
+
+// isHandshakePacket says if a packet was sent before the connection was forward-secure
+func isHandshakePacket(p *Packet) bool {
+	return p.EncryptionLevel == protocol.EncryptionUnencrypted || p.EncryptionLevel == protocol.EncryptionSecure
+}
+
 func (h *sentPacketHandler) queuePacketForRetransmission(packetElement *PacketElement) {
 	packet := &packetElement.Value
 	h.bytesInFlight -= packet.Length
@@ -365,6 +528,26 @@ func (h *sentPacketHandler) queuePacketForRetransmission(packetElement *PacketEl
 	h.stopWaitingManager.QueuedRetransmissionForPacketNumber(packet.PacketNumber)
 }
 
+// shouldSendTailLossProbe says if the next alarm is a TLP.
+// TLPs are only used when an RTT sample is available, since the timeout is based on the smoothed RTT.
+func (h *sentPacketHandler) shouldSendTailLossProbe() bool {
+	return h.tlpCount < maxTailLossProbes && h.rttStats.SmoothedRTT() != 0
+}
+
+func (h *sentPacketHandler) computeTLPTimeout() time.Duration {
+	return utils.MaxDuration(2*h.rttStats.SmoothedRTT(), minTailLossProbeTimeout)
+}
+
+func (h *sentPacketHandler) computeHandshakeTimeout() time.Duration {
+	duration := 2 * h.rttStats.SmoothedRTT()
+	if duration == 0 {
+		duration = 2 * defaultInitialRTT
+	}
+	duration = utils.MaxDuration(duration, minHandshakeTimeout)
+	// Exponential backoff
+	return duration << h.handshakeCount
+}
+
 func (h *sentPacketHandler) computeRTOTimeout() time.Duration {
 	rto := h.congestion.RetransmissionDelay()
 	if rto == 0 {
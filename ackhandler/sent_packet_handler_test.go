@@ -1,6 +1,8 @@
 package ackhandler
 
 import (
+	"bytes"
+	"fmt"
 	"time"
 
 	"github.com/lucas-clemente/quic-go/congestion"
@@ -17,6 +19,7 @@ type mockCongestion struct {
 	getCongestionWindow     bool
 	packetsAcked            [][]interface{}
 	packetsLost             [][]interface{}
+	packetsSpuriouslyLost   []protocol.PacketNumber
 }
 
 func (m *mockCongestion) TimeUntilSend(now time.Time, bytesInFlight protocol.ByteCount) time.Duration {
@@ -33,6 +36,9 @@ func (m *mockCongestion) GetCongestionWindow() protocol.ByteCount {
 	return protocol.DefaultTCPMSS
 }
 
+func (m *mockCongestion) GetSlowStartThreshold() protocol.ByteCount { panic("not implemented") }
+func (m *mockCongestion) InSlowStart() bool                         { panic("not implemented") }
+
 func (m *mockCongestion) MaybeExitSlowStart() {
 	m.maybeExitSlowStart = true
 }
@@ -57,6 +63,33 @@ func (m *mockCongestion) OnPacketLost(n protocol.PacketNumber, l protocol.ByteCo
 	m.packetsLost = append(m.packetsLost, []interface{}{n, l, bif})
 }
 
+func (m *mockCongestion) OnSpuriousLoss(n protocol.PacketNumber) {
+	m.packetsSpuriouslyLost = append(m.packetsSpuriouslyLost, n)
+}
+
+// recordingCongestion records the sequence of callbacks it receives
+type recordingCongestion struct {
+	mockCongestion
+	events []string
+}
+
+func (r *recordingCongestion) OnPacketSent(sentTime time.Time, bytesInFlight protocol.ByteCount, packetNumber protocol.PacketNumber, bytes protocol.ByteCount, isRetransmittable bool) bool {
+	r.events = append(r.events, fmt.Sprintf("sent %d", packetNumber))
+	return false
+}
+
+func (r *recordingCongestion) MaybeExitSlowStart() {
+	r.events = append(r.events, "maybe exit slow start")
+}
+
+func (r *recordingCongestion) OnPacketAcked(n protocol.PacketNumber, l protocol.ByteCount, bif protocol.ByteCount) {
+	r.events = append(r.events, fmt.Sprintf("acked %d", n))
+}
+
+func (r *recordingCongestion) OnPacketLost(n protocol.PacketNumber, l protocol.ByteCount, bif protocol.ByteCount) {
+	r.events = append(r.events, fmt.Sprintf("lost %d", n))
+}
+
 var _ = Describe("SentPacketHandler", func() {
 	var (
 		handler     *sentPacketHandler
@@ -65,7 +98,15 @@ var _ = Describe("SentPacketHandler", func() {
 
 	BeforeEach(func() {
 		rttStats := &congestion.RTTStats{}
-		handler = NewSentPacketHandler(rttStats).(*sentPacketHandler)
+		cong := congestion.NewCubicSender(
+			congestion.DefaultClock{},
+			rttStats,
+			false,
+			protocol.InitialCongestionWindow,
+			protocol.DefaultMaxCongestionWindow,
+			protocol.DefaultMinCongestionWindow,
+		)
+		handler = NewSentPacketHandler(rttStats, cong, false).(*sentPacketHandler)
 		streamFrame = frames.StreamFrame{
 			StreamID: 5,
 			Data:     []byte{0x13, 0x37},
@@ -126,6 +167,34 @@ var _ = Describe("SentPacketHandler", func() {
 			Expect(handler.bytesInFlight).To(Equal(protocol.ByteCount(1)))
 		})
 
+		It("doesn't track MTU probes", func() {
+			packet1 := Packet{PacketNumber: 1, Frames: []frames.Frame{&streamFrame}, Length: 1}
+			probe := Packet{PacketNumber: 2, Frames: []frames.Frame{&frames.PingFrame{}}, Length: 1400, IsMTUProbe: true}
+			err := handler.SentPacket(&packet1)
+			Expect(err).ToNot(HaveOccurred())
+			err = handler.SentPacket(&probe)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(handler.lastSentPacketNumber).To(Equal(protocol.PacketNumber(2)))
+			Expect(handler.packetHistory.Len()).To(Equal(1))
+			Expect(handler.bytesInFlight).To(Equal(protocol.ByteCount(1)))
+			Expect(handler.skippedPackets).To(BeEmpty())
+			// ACKs for probes are valid
+			err = handler.ReceivedAck(&frames.AckFrame{LargestAcked: 2, LowestAcked: 1}, 1, time.Now())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(handler.packetHistory.Len()).To(BeZero())
+			Expect(handler.bytesInFlight).To(BeZero())
+		})
+
+		It("sends MTU probes when the maximum number of tracked packets is reached", func() {
+			handler.retransmissionQueue = make([]*Packet, protocol.MaxTrackedSentPackets)
+			err := handler.SentPacket(&Packet{PacketNumber: 1, Frames: []frames.Frame{&streamFrame}, Length: 1})
+			Expect(err).To(MatchError(ErrTooManyTrackedSentPackets))
+			probe := Packet{PacketNumber: 2, Frames: []frames.Frame{&frames.PingFrame{}}, Length: 1400, IsMTUProbe: true}
+			err = handler.SentPacket(&probe)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(handler.lastSentPacketNumber).To(Equal(protocol.PacketNumber(2)))
+		})
+
 		It("stores the sent time", func() {
 			packet := Packet{PacketNumber: 1, Frames: []frames.Frame{&streamFrame}, Length: 1}
 			err := handler.SentPacket(&packet)
@@ -524,6 +593,27 @@ var _ = Describe("SentPacketHandler", func() {
 				Expect(err).NotTo(HaveOccurred())
 				Expect(handler.rttStats.LatestRTT()).To(BeNumerically("~", 5*time.Minute, 1*time.Second))
 			})
+
+			It("excludes the delay of an ACK that was delayed by the peer", func() {
+				const ackDelay = 100 * time.Millisecond
+				// the peer receives packet 1, and waits before sending the ACK
+				receivedPacketHandler := NewReceivedPacketHandler(func(time.Time) {}, protocol.AckSendDelay).(*receivedPacketHandler)
+				err := receivedPacketHandler.ReceivedPacket(1, true)
+				Expect(err).ToNot(HaveOccurred())
+				receivedPacketHandler.ackQueued = true
+				time.Sleep(ackDelay)
+				b := &bytes.Buffer{}
+				err = receivedPacketHandler.GetAckFrame().Write(b, protocol.VersionWhatever)
+				Expect(err).ToNot(HaveOccurred())
+				ack, err := frames.ParseAckFrame(bytes.NewReader(b.Bytes()), protocol.VersionWhatever)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(ack.DelayTime).To(BeNumerically("~", ackDelay, 20*time.Millisecond))
+				// the network RTT is 200ms, plus the delay of the ACK
+				getPacketElement(1).Value.SendTime = time.Now().Add(-200*time.Millisecond - ack.DelayTime)
+				err = handler.ReceivedAck(ack, 1, time.Now())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(handler.rttStats.LatestRTT()).To(BeNumerically("~", 200*time.Millisecond, 5*time.Millisecond))
+			})
 		})
 	})
 
@@ -666,17 +756,193 @@ var _ = Describe("SentPacketHandler", func() {
 			}))
 		})
 
+		It("informs the congestion controller when a lost packet is acked later", func() {
+			for i := 1; i <= 4; i++ {
+				err := handler.SentPacket(&Packet{PacketNumber: protocol.PacketNumber(i), Frames: []frames.Frame{&streamFrame}, Length: 1})
+				Expect(err).ToNot(HaveOccurred())
+			}
+			getPacketElement(1).Value.SendTime = time.Now().Add(-time.Hour)
+			err := handler.ReceivedAck(&frames.AckFrame{LargestAcked: 3, LowestAcked: 2}, 1, time.Now())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(handler.lostPackets).To(Equal([]protocol.PacketNumber{1}))
+			Expect(cong.packetsSpuriouslyLost).To(BeEmpty())
+			err = handler.ReceivedAck(&frames.AckFrame{LargestAcked: 4, LowestAcked: 1}, 2, time.Now())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cong.packetsSpuriouslyLost).To(Equal([]protocol.PacketNumber{1}))
+			Expect(handler.lostPackets).To(BeEmpty())
+		})
+
+		It("detects spurious losses in ACKs that don't ack any new packets", func() {
+			for i := 1; i <= 3; i++ {
+				err := handler.SentPacket(&Packet{PacketNumber: protocol.PacketNumber(i), Frames: []frames.Frame{&streamFrame}, Length: 1})
+				Expect(err).ToNot(HaveOccurred())
+			}
+			getPacketElement(1).Value.SendTime = time.Now().Add(-time.Hour)
+			err := handler.ReceivedAck(&frames.AckFrame{LargestAcked: 3, LowestAcked: 2}, 1, time.Now())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(handler.packetHistory.Len()).To(BeZero())
+			err = handler.ReceivedAck(&frames.AckFrame{LargestAcked: 3, LowestAcked: 1}, 2, time.Now())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cong.packetsSpuriouslyLost).To(Equal([]protocol.PacketNumber{1}))
+		})
+
+		It("limits the number of tracked lost packets", func() {
+			for i := 1; i <= protocol.MaxTrackedLostPackets+5; i++ {
+				err := handler.SentPacket(&Packet{PacketNumber: protocol.PacketNumber(i), Frames: []frames.Frame{&streamFrame}, Length: 1})
+				Expect(err).ToNot(HaveOccurred())
+				getPacketElement(protocol.PacketNumber(i)).Value.SendTime = time.Now().Add(-time.Hour)
+			}
+			err := handler.SentPacket(&Packet{PacketNumber: protocol.MaxTrackedLostPackets + 6, Frames: []frames.Frame{&streamFrame}, Length: 1})
+			Expect(err).ToNot(HaveOccurred())
+			err = handler.ReceivedAck(&frames.AckFrame{LargestAcked: protocol.MaxTrackedLostPackets + 6, LowestAcked: protocol.MaxTrackedLostPackets + 6}, 1, time.Now())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(handler.lostPackets).To(HaveLen(protocol.MaxTrackedLostPackets))
+			Expect(handler.lostPackets[0]).To(Equal(protocol.PacketNumber(6)))
+		})
+
+		It("restores the congestion window after a spurious loss", func() {
+			rttStats := &congestion.RTTStats{}
+			cubic := congestion.NewCubicSender(congestion.DefaultClock{}, rttStats, false, protocol.InitialCongestionWindow, protocol.DefaultMaxCongestionWindow, protocol.DefaultMinCongestionWindow)
+			handler = NewSentPacketHandler(rttStats, cubic, false).(*sentPacketHandler)
+			for i := 1; i <= 5; i++ {
+				err := handler.SentPacket(&Packet{PacketNumber: protocol.PacketNumber(i), Frames: []frames.Frame{&streamFrame}, Length: protocol.DefaultTCPMSS})
+				Expect(err).ToNot(HaveOccurred())
+			}
+			cwnd := handler.congestion.GetCongestionWindow()
+			// packet 1 is reordered, and arrives at the peer after packets 2 to 5
+			getPacketElement(1).Value.SendTime = time.Now().Add(-time.Hour)
+			err := handler.ReceivedAck(&frames.AckFrame{LargestAcked: 5, LowestAcked: 2}, 1, time.Now())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(handler.DequeuePacketForRetransmission().PacketNumber).To(Equal(protocol.PacketNumber(1)))
+			Expect(handler.congestion.GetCongestionWindow()).To(BeNumerically("<", cwnd))
+			// retransmission of packet 1
+			err = handler.SentPacket(&Packet{PacketNumber: 6, Frames: []frames.Frame{&streamFrame}, Length: protocol.DefaultTCPMSS})
+			Expect(err).ToNot(HaveOccurred())
+			err = handler.ReceivedAck(&frames.AckFrame{LargestAcked: 6, LowestAcked: 1}, 2, time.Now())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(handler.congestion.GetCongestionWindow()).To(Equal(cwnd))
+		})
+
+		It("uses the send algorithm passed to the constructor", func() {
+			alg := &recordingCongestion{}
+			rttStats := &congestion.RTTStats{}
+			handler = NewSentPacketHandler(rttStats, alg, false).(*sentPacketHandler)
+			for i := 1; i <= 3; i++ {
+				err := handler.SentPacket(&Packet{PacketNumber: protocol.PacketNumber(i), Frames: []frames.Frame{&streamFrame}, Length: 1})
+				Expect(err).ToNot(HaveOccurred())
+			}
+			// make packet 2 old enough to be declared lost when packet 3 is acked
+			getPacketElement(2).Value.SendTime = time.Now().Add(-time.Hour)
+			ack := &frames.AckFrame{
+				LargestAcked: 3,
+				LowestAcked:  1,
+				AckRanges: []frames.AckRange{
+					{FirstPacketNumber: 3, LastPacketNumber: 3},
+					{FirstPacketNumber: 1, LastPacketNumber: 1},
+				},
+			}
+			err := handler.ReceivedAck(ack, 1, time.Now())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(alg.events).To(Equal([]string{
+				"sent 1",
+				"sent 2",
+				"sent 3",
+				"maybe exit slow start",
+				"acked 1",
+				"acked 3",
+				"lost 2",
+			}))
+		})
+
 		It("allows or denies sending based on congestion", func() {
 			Expect(handler.SendingAllowed()).To(BeTrue())
 			err := handler.SentPacket(&Packet{PacketNumber: 1, Frames: []frames.Frame{}, Length: protocol.DefaultTCPMSS + 1})
 			Expect(err).NotTo(HaveOccurred())
 			Expect(handler.SendingAllowed()).To(BeFalse())
+			Expect(handler.AckOnlyPacketAllowed()).To(BeTrue())
+		})
+
+		It("allows sending a single TLP when congestion limited", func() {
+			handler.rttStats.UpdateRTT(100*time.Millisecond, 0, time.Now())
+			err := handler.SentPacket(&Packet{PacketNumber: 1, Frames: []frames.Frame{&streamFrame}, Length: protocol.DefaultTCPMSS + 1})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(handler.SendingAllowed()).To(BeFalse())
+			handler.OnAlarm() // TLP
+			Expect(handler.SendingAllowed()).To(BeTrue())
+			Expect(handler.DequeuePacketForRetransmission()).ToNot(BeNil())
+			err = handler.SentPacket(&Packet{PacketNumber: 2, Frames: []frames.Frame{&streamFrame}, Length: 1})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(handler.SendingAllowed()).To(BeFalse())
+		})
+
+		It("allows sending the two RTO packets when congestion limited", func() {
+			handler.tlpCount = maxTailLossProbes
+			for i := 1; i <= 4; i++ {
+				err := handler.SentPacket(&Packet{PacketNumber: protocol.PacketNumber(i), Frames: []frames.Frame{&streamFrame}, Length: protocol.DefaultTCPMSS})
+				Expect(err).NotTo(HaveOccurred())
+			}
+			handler.congestion = &mockCongestion{} // the congestion window is 1 MSS
+			Expect(handler.SendingAllowed()).To(BeFalse())
+			handler.OnAlarm() // RTO
+			Expect(handler.retransmissionQueue).To(HaveLen(2))
+			for i := 5; i <= 6; i++ {
+				Expect(handler.SendingAllowed()).To(BeTrue())
+				err := handler.SentPacket(&Packet{PacketNumber: protocol.PacketNumber(i), Frames: []frames.Frame{&streamFrame}, Length: protocol.DefaultTCPMSS})
+				Expect(err).NotTo(HaveOccurred())
+			}
+			Expect(handler.SendingAllowed()).To(BeFalse())
+		})
+
+		It("doesn't allow sending retransmissions of lost packets when congestion limited", func() {
+			err := handler.SentPacket(&Packet{PacketNumber: 1, Frames: []frames.Frame{}, Length: protocol.DefaultTCPMSS + 1})
+			Expect(err).NotTo(HaveOccurred())
+			handler.retransmissionQueue = []*Packet{{PacketNumber: 1}, {PacketNumber: 2}}
+			Expect(handler.SendingAllowed()).To(BeFalse())
 		})
 
 		It("allows or denies sending based on the number of tracked packets", func() {
 			Expect(handler.SendingAllowed()).To(BeTrue())
 			handler.retransmissionQueue = make([]*Packet, protocol.MaxTrackedSentPackets)
 			Expect(handler.SendingAllowed()).To(BeFalse())
+			Expect(handler.AckOnlyPacketAllowed()).To(BeFalse())
+		})
+	})
+
+	Context("pacing", func() {
+		var rttStats *congestion.RTTStats
+
+		BeforeEach(func() {
+			rttStats = &congestion.RTTStats{}
+			cong := congestion.NewCubicSender(congestion.DefaultClock{}, rttStats, false, protocol.InitialCongestionWindow, protocol.DefaultMaxCongestionWindow, protocol.DefaultMinCongestionWindow)
+			handler = NewSentPacketHandler(rttStats, cong, true).(*sentPacketHandler)
+		})
+
+		It("doesn't pace when pacing is disabled", func() {
+			handler.pacing = false
+			rttStats.UpdateRTT(100*time.Millisecond, 0, time.Now())
+			err := handler.SentPacket(&Packet{PacketNumber: 1, Frames: []frames.Frame{&streamFrame}, Length: 1000})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(handler.TimeUntilSend()).To(BeZero())
+		})
+
+		It("doesn't delay packets before the first RTT sample", func() {
+			err := handler.SentPacket(&Packet{PacketNumber: 1, Frames: []frames.Frame{&streamFrame}, Length: 1000})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(handler.TimeUntilSend()).To(BeTemporally("~", time.Now(), time.Millisecond))
+		})
+
+		It("spreads the congestion window over one RTT", func() {
+			rttStats.UpdateRTT(100*time.Millisecond, 0, time.Now())
+			cwnd := handler.congestion.GetCongestionWindow()
+			delay := time.Duration(int64(100*time.Millisecond) * 1000 / int64(cwnd))
+			Expect(delay).ToNot(BeZero())
+			err := handler.SentPacket(&Packet{PacketNumber: 1, Frames: []frames.Frame{&streamFrame}, Length: 1000})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(handler.TimeUntilSend()).To(BeTemporally("~", time.Now().Add(delay), time.Millisecond))
+			// packets sent before the next send time was reached are scheduled after the previous packet
+			err = handler.SentPacket(&Packet{PacketNumber: 2, Frames: []frames.Frame{&streamFrame}, Length: 1000})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(handler.TimeUntilSend()).To(BeTemporally("~", time.Now().Add(2*delay), time.Millisecond))
 		})
 	})
 
@@ -720,6 +986,9 @@ var _ = Describe("SentPacketHandler", func() {
 			Expect(err).NotTo(HaveOccurred())
 			err = handler.SentPacket(&Packet{PacketNumber: 2, Length: 1})
 			Expect(err).NotTo(HaveOccurred())
+			// packet 3 is still outstanding, so early retransmit is not used
+			err = handler.SentPacket(&Packet{PacketNumber: 3, Length: 1})
+			Expect(err).NotTo(HaveOccurred())
 			Expect(handler.lossTime.IsZero()).To(BeTrue())
 
 			err = handler.ReceivedAck(&frames.AckFrame{LargestAcked: 2, LowestAcked: 2}, 1, time.Now().Add(time.Hour))
@@ -736,6 +1005,69 @@ var _ = Describe("SentPacketHandler", func() {
 			Expect(handler.DequeuePacketForRetransmission()).NotTo(BeNil())
 		})
 
+		Context("early retransmit", func() {
+			BeforeEach(func() {
+				handler.rttStats.UpdateRTT(100*time.Millisecond, 0, time.Now())
+			})
+
+			It("quickly declares a packet lost if the newest packet was acked, and only few packets are outstanding", func() {
+				err := handler.SentPacket(&Packet{PacketNumber: 1, Length: 1})
+				Expect(err).NotTo(HaveOccurred())
+				err = handler.SentPacket(&Packet{PacketNumber: 2, Length: 1})
+				Expect(err).NotTo(HaveOccurred())
+				// less than 9/8 RTT, but more than 1/4 RTT ago
+				getPacketElement(1).Value.SendTime = time.Now().Add(-50 * time.Millisecond)
+				err = handler.ReceivedAck(&frames.AckFrame{LargestAcked: 2, LowestAcked: 2}, 1, time.Now())
+				Expect(err).NotTo(HaveOccurred())
+				packet := handler.DequeuePacketForRetransmission()
+				Expect(packet).ToNot(BeNil())
+				Expect(packet.PacketNumber).To(Equal(protocol.PacketNumber(1)))
+			})
+
+			It("sets the loss alarm to a fraction of the RTT", func() {
+				err := handler.SentPacket(&Packet{PacketNumber: 1, Length: 1})
+				Expect(err).NotTo(HaveOccurred())
+				err = handler.SentPacket(&Packet{PacketNumber: 2, Length: 1})
+				Expect(err).NotTo(HaveOccurred())
+				err = handler.ReceivedAck(&frames.AckFrame{LargestAcked: 2, LowestAcked: 2}, 1, time.Now())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(handler.DequeuePacketForRetransmission()).To(BeNil())
+				// the latest RTT sample is very small, so the delay is based on the smoothed RTT
+				Expect(handler.lossTime.Sub(time.Now())).To(BeNumerically("~", handler.rttStats.SmoothedRTT()/4, 10*time.Millisecond))
+			})
+
+			It("doesn't use early retransmit if enough packets are outstanding", func() {
+				for i := 1; i <= 5; i++ {
+					err := handler.SentPacket(&Packet{PacketNumber: protocol.PacketNumber(i), Length: 1})
+					Expect(err).NotTo(HaveOccurred())
+				}
+				getPacketElement(2).Value.SendTime = time.Now().Add(-50 * time.Millisecond)
+				ack := &frames.AckFrame{
+					LargestAcked: 5,
+					LowestAcked:  1,
+					AckRanges: []frames.AckRange{
+						{FirstPacketNumber: 5, LastPacketNumber: 5},
+						{FirstPacketNumber: 1, LastPacketNumber: 1},
+					},
+				}
+				err := handler.ReceivedAck(ack, 1, time.Now())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(handler.packetHistory.Len()).To(Equal(3))
+				Expect(handler.DequeuePacketForRetransmission()).To(BeNil())
+			})
+
+			It("doesn't use early retransmit if the newest packet wasn't acked", func() {
+				for i := 1; i <= 3; i++ {
+					err := handler.SentPacket(&Packet{PacketNumber: protocol.PacketNumber(i), Length: 1})
+					Expect(err).NotTo(HaveOccurred())
+				}
+				getPacketElement(1).Value.SendTime = time.Now().Add(-50 * time.Millisecond)
+				err := handler.ReceivedAck(&frames.AckFrame{LargestAcked: 2, LowestAcked: 2}, 1, time.Now())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(handler.DequeuePacketForRetransmission()).To(BeNil())
+			})
+		})
+
 		It("does not detect packets as lost without ACKs", func() {
 			err := handler.SentPacket(&Packet{PacketNumber: 1, Length: 1})
 			Expect(err).NotTo(HaveOccurred())
@@ -748,17 +1080,86 @@ var _ = Describe("SentPacketHandler", func() {
 			err = handler.ReceivedAck(&frames.AckFrame{LargestAcked: 1, LowestAcked: 1}, 1, time.Now().Add(time.Hour))
 			Expect(err).NotTo(HaveOccurred())
 			Expect(handler.lossTime.IsZero()).To(BeTrue())
-			Expect(handler.GetAlarmTimeout().Sub(time.Now())).To(BeNumerically("~", handler.computeRTOTimeout(), time.Minute))
+			Expect(handler.GetAlarmTimeout().Sub(time.Now())).To(BeNumerically("~", handler.computeTLPTimeout(), time.Minute))
 
 			// This means RTO, so both packets should be lost
+			handler.tlpCount = maxTailLossProbes
 			handler.OnAlarm()
 			Expect(handler.DequeuePacketForRetransmission()).ToNot(BeNil())
 			Expect(handler.DequeuePacketForRetransmission()).ToNot(BeNil())
 		})
 	})
 
+	Context("tail loss probes", func() {
+		BeforeEach(func() {
+			handler.rttStats.UpdateRTT(100*time.Millisecond, 0, time.Now())
+			for i := 1; i <= 3; i++ {
+				err := handler.SentPacket(&Packet{PacketNumber: protocol.PacketNumber(i), Frames: []frames.Frame{&streamFrame}, Length: 1})
+				Expect(err).ToNot(HaveOccurred())
+			}
+			// the ACK for packet 3 is lost
+			err := handler.ReceivedAck(&frames.AckFrame{LargestAcked: 2, LowestAcked: 1}, 1, time.Now())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(handler.lossTime.IsZero()).To(BeTrue())
+		})
+
+		It("sets the alarm for a TLP before the RTO", func() {
+			Expect(handler.computeTLPTimeout()).To(Equal(2 * handler.rttStats.SmoothedRTT()))
+			Expect(handler.computeTLPTimeout()).To(BeNumerically("<", handler.computeRTOTimeout()))
+			Expect(handler.GetAlarmTimeout().Sub(time.Now())).To(BeNumerically("~", handler.computeTLPTimeout(), 10*time.Millisecond))
+		})
+
+		It("retransmits the last packet, without declaring it lost", func() {
+			cong := &mockCongestion{}
+			handler.congestion = cong
+			handler.OnAlarm()
+			Expect(handler.tlpCount).To(BeEquivalentTo(1))
+			Expect(handler.rtoCount).To(BeZero())
+			packet := handler.DequeuePacketForRetransmission()
+			Expect(packet).ToNot(BeNil())
+			Expect(packet.PacketNumber).To(Equal(protocol.PacketNumber(3)))
+			Expect(cong.packetsLost).To(BeEmpty())
+			Expect(cong.onRetransmissionTimeout).To(BeFalse())
+			// the packet is still outstanding, and the peer still waits for it
+			Expect(getPacketElement(3)).ToNot(BeNil())
+			// it is only tracked once
+			Expect(handler.retransmissionQueue).To(BeEmpty())
+			Expect(handler.bytesInFlight).To(Equal(protocol.ByteCount(1)))
+			Expect(handler.GetStopWaitingFrame(false)).To(Equal(&frames.StopWaitingFrame{LeastUnacked: 3}))
+		})
+
+		It("sends an RTO after two TLPs", func() {
+			err := handler.SentPacket(&Packet{PacketNumber: 4, Frames: []frames.Frame{&streamFrame}, Length: 1})
+			Expect(err).ToNot(HaveOccurred())
+			err = handler.SentPacket(&Packet{PacketNumber: 5, Frames: []frames.Frame{&streamFrame}, Length: 1})
+			Expect(err).ToNot(HaveOccurred())
+			handler.OnAlarm()
+			handler.OnAlarm()
+			Expect(handler.tlpCount).To(BeEquivalentTo(2))
+			Expect(handler.GetAlarmTimeout().Sub(time.Now())).To(BeNumerically("~", handler.computeRTOTimeout(), 10*time.Millisecond))
+			handler.OnAlarm()
+			Expect(handler.rtoCount).To(BeEquivalentTo(1))
+		})
+
+		It("resets the TLP count when an ACK is received", func() {
+			handler.OnAlarm()
+			Expect(handler.tlpCount).To(BeEquivalentTo(1))
+			err := handler.SentPacket(&Packet{PacketNumber: 4, Frames: []frames.Frame{&streamFrame}, Length: 1})
+			Expect(err).ToNot(HaveOccurred())
+			err = handler.ReceivedAck(&frames.AckFrame{LargestAcked: 4, LowestAcked: 4}, 2, time.Now())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(handler.tlpCount).To(BeZero())
+		})
+
+		It("doesn't send TLPs before an RTT sample was taken", func() {
+			handler.rttStats = &congestion.RTTStats{}
+			Expect(handler.shouldSendTailLossProbe()).To(BeFalse())
+		})
+	})
+
 	Context("RTO retransmission", func() {
 		It("queues two packets if RTO expires", func() {
+			handler.tlpCount = maxTailLossProbes
 			err := handler.SentPacket(&Packet{PacketNumber: 1, Length: 1})
 			Expect(err).NotTo(HaveOccurred())
 			err = handler.SentPacket(&Packet{PacketNumber: 2, Length: 1})
@@ -775,4 +1176,101 @@ var _ = Describe("SentPacketHandler", func() {
 			Expect(handler.rtoCount).To(BeEquivalentTo(1))
 		})
 	})
+
+	Context("handshake retransmission", func() {
+		sendHandshakePacket := func(p protocol.PacketNumber) {
+			err := handler.SentPacket(&Packet{PacketNumber: p, Length: 1, EncryptionLevel: protocol.EncryptionUnencrypted})
+			ExpectWithOffset(1, err).NotTo(HaveOccurred())
+		}
+
+		It("uses the handshake timeout for handshake packets", func() {
+			sendHandshakePacket(1)
+			Expect(handler.computeHandshakeTimeout()).To(Equal(2 * defaultInitialRTT))
+			Expect(handler.GetAlarmTimeout().Sub(time.Now())).To(BeNumerically("~", 2*defaultInitialRTT, 10*time.Millisecond))
+		})
+
+		It("uses the RTT for the handshake timeout, if available", func() {
+			handler.rttStats.UpdateRTT(time.Second, 0, time.Now())
+			Expect(handler.computeHandshakeTimeout()).To(Equal(2 * time.Second))
+			handler.rttStats = &congestion.RTTStats{}
+			handler.rttStats.UpdateRTT(10*time.Millisecond, 0, time.Now())
+			Expect(handler.computeHandshakeTimeout()).To(Equal(minHandshakeTimeout))
+		})
+
+		It("retransmits all handshake packets, with exponential backoff", func() {
+			sendHandshakePacket(1)
+			sendHandshakePacket(2)
+			err := handler.SentPacket(&Packet{PacketNumber: 3, Length: 1, EncryptionLevel: protocol.EncryptionForwardSecure})
+			Expect(err).NotTo(HaveOccurred())
+			cwnd := handler.congestion.GetCongestionWindow()
+			var timeouts []time.Duration
+			p := protocol.PacketNumber(3)
+			for i := 0; i < 4; i++ {
+				timeouts = append(timeouts, handler.GetAlarmTimeout().Sub(time.Now()))
+				Expect(handler.OnAlarm()).To(Succeed())
+				var retransmitted []protocol.PacketNumber
+				for packet := handler.DequeuePacketForRetransmission(); packet != nil; packet = handler.DequeuePacketForRetransmission() {
+					Expect(packet.EncryptionLevel).To(Equal(protocol.EncryptionUnencrypted))
+					retransmitted = append(retransmitted, packet.PacketNumber)
+				}
+				Expect(retransmitted).To(HaveLen(2))
+				// the retransmissions are sent as new packets
+				p++
+				sendHandshakePacket(p)
+				p++
+				sendHandshakePacket(p)
+			}
+			Expect(handler.handshakeCount).To(BeEquivalentTo(4))
+			for i := 1; i < len(timeouts); i++ {
+				Expect(timeouts[i]).To(BeNumerically("~", 2*timeouts[i-1], 10*time.Millisecond))
+			}
+			// the forward-secure packet is still outstanding, and the congestion controller wasn't informed
+			Expect(handler.packetHistory.Front().Value.PacketNumber).To(Equal(protocol.PacketNumber(3)))
+			Expect(handler.congestion.GetCongestionWindow()).To(Equal(cwnd))
+			Expect(handler.rtoCount).To(BeZero())
+			Expect(handler.tlpCount).To(BeZero())
+		})
+
+		It("resets the backoff when a packet is acked", func() {
+			sendHandshakePacket(1)
+			Expect(handler.OnAlarm()).To(Succeed())
+			Expect(handler.DequeuePacketForRetransmission()).ToNot(BeNil())
+			sendHandshakePacket(2)
+			sendHandshakePacket(3)
+			Expect(handler.handshakeCount).To(BeEquivalentTo(1))
+			err := handler.ReceivedAck(&frames.AckFrame{LargestAcked: 2, LowestAcked: 2}, 1, time.Now())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(handler.handshakeCount).To(BeZero())
+		})
+
+		It("uses the regular loss detection after the handshake completed", func() {
+			handler.rttStats.UpdateRTT(time.Hour, 0, time.Now())
+			sendHandshakePacket(1)
+			Expect(handler.GetAlarmTimeout().Sub(time.Now())).To(BeNumerically("~", 2*time.Hour, time.Minute))
+			handler.SetHandshakeComplete()
+			Expect(handler.GetAlarmTimeout().Sub(time.Now())).To(BeNumerically("~", handler.computeTLPTimeout(), time.Minute))
+			handler.OnAlarm()
+			Expect(handler.handshakeCount).To(BeZero())
+			Expect(handler.tlpCount).To(BeEquivalentTo(1))
+		})
+
+		It("uses the regular loss detection after the handshake packets were acknowledged", func() {
+			sendHandshakePacket(1)
+			err := handler.SentPacket(&Packet{PacketNumber: 2, Length: 1, EncryptionLevel: protocol.EncryptionForwardSecure})
+			Expect(err).NotTo(HaveOccurred())
+			err = handler.ReceivedAck(&frames.AckFrame{LargestAcked: 1, LowestAcked: 1}, 1, time.Now())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(handler.GetAlarmTimeout().Sub(time.Now())).To(BeNumerically("~", handler.computeTLPTimeout(), 10*time.Millisecond))
+		})
+
+		It("gives up after too many retransmissions", func() {
+			sendHandshakePacket(1)
+			for i := 0; i < maxHandshakeRetransmissions; i++ {
+				Expect(handler.OnAlarm()).To(Succeed())
+				Expect(handler.DequeuePacketForRetransmission()).ToNot(BeNil())
+				sendHandshakePacket(protocol.PacketNumber(i + 2))
+			}
+			Expect(handler.OnAlarm()).To(MatchError(ErrTooManyHandshakeRetransmissions))
+		})
+	})
 })
@@ -0,0 +1,26 @@
+package quic
+
+import "github.com/lucas-clemente/quic-go/protocol"
+
+// An amplificationLimit limits the number of bytes the server sends to a client, as long as the client's address is not validated.
+// Otherwise, an attacker could spoof the source address of a small CHLO, and use the server to send a lot of data to the victim.
+// The address is validated as soon as the server receives a full CHLO, since that requires a valid source address token.
+type amplificationLimit struct {
+	bytesReceived protocol.ByteCount
+	bytesSent     protocol.ByteCount
+}
+
+// ReceivedBytes is called for every packet received from the client
+func (l *amplificationLimit) ReceivedBytes(size protocol.ByteCount) {
+	l.bytesReceived += size
+}
+
+// SentBytes is called for every packet sent to the client
+func (l *amplificationLimit) SentBytes(size protocol.ByteCount) {
+	l.bytesSent += size
+}
+
+// CanSend determines if size bytes can be sent to the client without exceeding the amplification limit
+func (l *amplificationLimit) CanSend(size protocol.ByteCount) bool {
+	return l.bytesSent+size <= protocol.AddressValidationAmplificationFactor*l.bytesReceived
+}
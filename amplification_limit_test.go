@@ -0,0 +1,35 @@
+package quic
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Amplification limit", func() {
+	var l *amplificationLimit
+
+	BeforeEach(func() {
+		l = &amplificationLimit{}
+	})
+
+	It("doesn't allow sending before any bytes were received", func() {
+		Expect(l.CanSend(1)).To(BeFalse())
+	})
+
+	It("allows sending 3 times the number of bytes received", func() {
+		l.ReceivedBytes(100)
+		Expect(l.CanSend(300)).To(BeTrue())
+		Expect(l.CanSend(301)).To(BeFalse())
+		l.SentBytes(250)
+		Expect(l.CanSend(50)).To(BeTrue())
+		Expect(l.CanSend(51)).To(BeFalse())
+	})
+
+	It("allows sending more when more bytes are received", func() {
+		l.ReceivedBytes(100)
+		l.SentBytes(300)
+		Expect(l.CanSend(1)).To(BeFalse())
+		l.ReceivedBytes(10)
+		Expect(l.CanSend(30)).To(BeTrue())
+	})
+})
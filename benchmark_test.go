@@ -2,6 +2,7 @@ package quic
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"fmt"
 	"io"
@@ -55,7 +56,7 @@ var _ = Describe("Benchmarks", func() {
 				addr := <-serverAddr
 				sess, err := DialAddr(addr.String(), conf)
 				Expect(err).ToNot(HaveOccurred())
-				str, err := sess.AcceptStream()
+				str, err := sess.AcceptStream(context.Background())
 				Expect(err).ToNot(HaveOccurred())
 
 				buf := &bytes.Buffer{}
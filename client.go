@@ -2,8 +2,11 @@ package quic
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"strings"
 	"sync"
@@ -27,8 +30,9 @@ type client struct {
 	config            *Config
 	versionNegotiated bool // has version negotiation completed yet
 
-	connectionID protocol.ConnectionID
-	version      protocol.VersionNumber
+	connectionID   protocol.ConnectionID
+	version        protocol.VersionNumber
+	initialVersion protocol.VersionNumber // the version offered in the first packet, before a version negotiation
 
 	session packetHandler
 }
@@ -40,15 +44,22 @@ var (
 // DialAddr establishes a new QUIC connection to a server.
 // The hostname for SNI is taken from the given address.
 func DialAddr(addr string, config *Config) (Session, error) {
+	return DialAddrContext(context.Background(), addr, config)
+}
+
+// DialAddrContext establishes a new QUIC connection to a server using the provided context.
+// If the context is cancelled before the handshake completes, the connection attempt is aborted and the context's error is returned.
+// The hostname for SNI is taken from the given address.
+func DialAddrContext(ctx context.Context, addr string, config *Config) (Session, error) {
 	udpAddr, err := net.ResolveUDPAddr("udp", addr)
 	if err != nil {
 		return nil, err
 	}
-	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+	udpConn, err := listenUDP(&net.UDPAddr{IP: net.IPv4zero, Port: 0})
 	if err != nil {
 		return nil, err
 	}
-	return Dial(udpConn, udpAddr, addr, config)
+	return DialContext(ctx, udpConn, udpAddr, addr, config)
 }
 
 // DialAddrNonFWSecure establishes a new QUIC connection to a server.
@@ -58,7 +69,7 @@ func DialAddrNonFWSecure(addr string, config *Config) (NonFWSession, error) {
 	if err != nil {
 		return nil, err
 	}
-	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+	udpConn, err := listenUDP(&net.UDPAddr{IP: net.IPv4zero, Port: 0})
 	if err != nil {
 		return nil, err
 	}
@@ -68,7 +79,12 @@ func DialAddrNonFWSecure(addr string, config *Config) (NonFWSession, error) {
 // DialNonFWSecure establishes a new non-forward-secure QUIC connection to a server using a net.PacketConn.
 // The host parameter is used for SNI.
 func DialNonFWSecure(pconn net.PacketConn, remoteAddr net.Addr, host string, config *Config) (NonFWSession, error) {
-	connID, err := utils.GenerateConnectionID()
+	return dialNonFWSecure(context.Background(), pconn, remoteAddr, host, config)
+}
+
+func dialNonFWSecure(ctx context.Context, pconn net.PacketConn, remoteAddr net.Addr, host string, config *Config) (NonFWSession, error) {
+	clientConfig := populateClientConfig(config)
+	connID, err := utils.GenerateConnectionID(clientConfig.Rand)
 	if err != nil {
 		return nil, err
 	}
@@ -78,14 +94,14 @@ func DialNonFWSecure(pconn net.PacketConn, remoteAddr net.Addr, host string, con
 		return nil, err
 	}
 
-	clientConfig := populateClientConfig(config)
 	c := &client{
-		conn:         &conn{pconn: pconn, currentAddr: remoteAddr},
-		connectionID: connID,
-		hostname:     hostname,
-		config:       clientConfig,
-		version:      clientConfig.Versions[0],
-		errorChan:    make(chan struct{}),
+		conn:           &conn{pconn: pconn, currentAddr: remoteAddr, batchWrites: clientConfig.BatchWrites},
+		connectionID:   connID,
+		hostname:       hostname,
+		config:         clientConfig,
+		version:        clientConfig.Versions[0],
+		initialVersion: clientConfig.Versions[0],
+		errorChan:      make(chan struct{}),
 	}
 
 	err = c.createNewSession(nil)
@@ -95,21 +111,44 @@ func DialNonFWSecure(pconn net.PacketConn, remoteAddr net.Addr, host string, con
 
 	utils.Infof("Starting new connection to %s (%s), connectionID %x, version %d", hostname, c.conn.RemoteAddr().String(), c.connectionID, c.version)
 
-	return c.session.(NonFWSession), c.establishSecureConnection()
+	if err := c.establishSecureConnection(ctx); err != nil {
+		// make sure that we don't leave a half-open session behind
+		c.session.Close(err)
+		return nil, err
+	}
+	return c.session.(NonFWSession), nil
 }
 
 // Dial establishes a new QUIC connection to a server using a net.PacketConn.
 // The host parameter is used for SNI.
+// It returns as soon as the handshake is complete. If the handshake fails, the error is returned, and the session is closed.
 func Dial(pconn net.PacketConn, remoteAddr net.Addr, host string, config *Config) (Session, error) {
-	sess, err := DialNonFWSecure(pconn, remoteAddr, host, config)
+	return DialContext(context.Background(), pconn, remoteAddr, host, config)
+}
+
+// DialContext establishes a new QUIC connection to a server using a net.PacketConn and the provided context.
+// If the context is cancelled before the handshake completes, the session is closed and the context's error is returned.
+// The host parameter is used for SNI.
+func DialContext(ctx context.Context, pconn net.PacketConn, remoteAddr net.Addr, host string, config *Config) (Session, error) {
+	sess, err := dialNonFWSecure(ctx, pconn, remoteAddr, host, config)
 	if err != nil {
 		return nil, err
 	}
-	err = sess.WaitUntilHandshakeComplete()
-	if err != nil {
-		return nil, err
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- sess.WaitUntilHandshakeComplete()
+	}()
+	select {
+	case err := <-errChan:
+		if err != nil {
+			return nil, err
+		}
+		return sess, nil
+	case <-ctx.Done():
+		// closing the session stops the retransmission of handshake packets, and closes the connection
+		sess.Close(ctx.Err())
+		return nil, ctx.Err()
 	}
-	return sess, nil
 }
 
 func populateClientConfig(config *Config) *Config {
@@ -117,29 +156,100 @@ func populateClientConfig(config *Config) *Config {
 	if len(versions) == 0 {
 		versions = protocol.SupportedVersions
 	}
+	initialCongestionWindow := protocol.PacketNumber(protocol.InitialCongestionWindow)
+	if config.InitialCongestionWindow != 0 {
+		initialCongestionWindow = utils.MinPacketNumber(config.InitialCongestionWindow, protocol.MaxInitialCongestionWindow)
+	}
+	minCongestionWindow := protocol.PacketNumber(protocol.DefaultMinCongestionWindow)
+	if config.MinCongestionWindow != 0 {
+		minCongestionWindow = config.MinCongestionWindow
+	}
+	minCongestionWindow = utils.MinPacketNumber(minCongestionWindow, initialCongestionWindow)
+	maxReceiveStreamFlowControlWindow := config.MaxReceiveStreamFlowControlWindow
+	if maxReceiveStreamFlowControlWindow == 0 {
+		maxReceiveStreamFlowControlWindow = uint64(protocol.MaxReceiveStreamFlowControlWindowClient)
+	}
+	maxReceiveConnectionFlowControlWindow := config.MaxReceiveConnectionFlowControlWindow
+	if maxReceiveConnectionFlowControlWindow == 0 {
+		maxReceiveConnectionFlowControlWindow = uint64(protocol.MaxReceiveConnectionFlowControlWindowClient)
+	}
+	idleTimeout := protocol.MaxIdleTimeoutClient
+	if config.IdleTimeout != 0 {
+		idleTimeout = config.IdleTimeout
+	}
+	handshakeTimeout := protocol.MaxTimeForCryptoHandshake
+	if config.HandshakeTimeout != 0 {
+		handshakeTimeout = config.HandshakeTimeout
+	}
+	maxAckDelay := protocol.AckSendDelay
+	if config.MaxAckDelay != 0 {
+		maxAckDelay = config.MaxAckDelay
+	}
+	var randReader io.Reader = rand.Reader
+	if config.Rand != nil {
+		// the reader is shared by all sessions using this config
+		randReader = utils.NewLockedReader(config.Rand)
+	}
+	maxPacketSize := protocol.MaxPacketSize
+	if config.MaxPacketSize != 0 {
+		maxPacketSize = utils.MaxByteCount(utils.MinByteCount(config.MaxPacketSize, protocol.MaxPacketSize), protocol.MinMaxPacketSize)
+	}
 
 	return &Config{
-		TLSConfig:                     config.TLSConfig,
-		Versions:                      versions,
-		RequestConnectionIDTruncation: config.RequestConnectionIDTruncation,
+		TLSConfig:                             config.TLSConfig,
+		Versions:                              versions,
+		RequestConnectionIDTruncation:         config.RequestConnectionIDTruncation,
+		MaxIncomingStreams:                    config.MaxIncomingStreams,
+		IdleTimeout:                           idleTimeout,
+		HandshakeTimeout:                      handshakeTimeout,
+		MaxAckDelay:                           maxAckDelay,
+		InitialCongestionWindow:               initialCongestionWindow,
+		MinCongestionWindow:                   minCongestionWindow,
+		MaxPacketSize:                         maxPacketSize,
+		MaxReceiveStreamFlowControlWindow:     maxReceiveStreamFlowControlWindow,
+		MaxReceiveConnectionFlowControlWindow: maxReceiveConnectionFlowControlWindow,
+		KeepAlive:                             config.KeepAlive,
+		EnablePacing:                          config.EnablePacing,
+		StreamScheduling:                      config.StreamScheduling,
+		BatchWrites:                           config.BatchWrites,
+		EnablePathMTUDiscovery:                config.EnablePathMTUDiscovery,
+		Tracer:                                config.Tracer,
+		Logger:                                config.Logger,
+		Rand:                                  randReader,
+		OnPublicReset:                         config.OnPublicReset,
+		TokenStore:                            config.TokenStore,
+		Disable0RTT:                           config.Disable0RTT,
 	}
 }
 
 // establishSecureConnection returns as soon as the connection is secure (as opposed to forward-secure)
-func (c *client) establishSecureConnection() error {
+func (c *client) establishSecureConnection(ctx context.Context) error {
 	go c.listen()
 
-	select {
-	case <-c.errorChan:
-		return c.listenErr
-	case ev := <-c.handshakeChan:
-		if ev.err != nil {
-			return ev.err
-		}
-		if ev.encLevel != protocol.EncryptionSecure {
-			return fmt.Errorf("Client BUG: Expected encryption level to be secure, was %s", ev.encLevel)
+	for {
+		// the handshakeChan is replaced when a new session is created after version negotiation
+		c.mutex.Lock()
+		handshakeChan := c.handshakeChan
+		c.mutex.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c.errorChan:
+			return c.listenErr
+		case ev := <-handshakeChan:
+			if ev.err == errCloseSessionForNewVersion {
+				// wait for the handshake of the new session
+				continue
+			}
+			if ev.err != nil {
+				return ev.err
+			}
+			if ev.encLevel != protocol.EncryptionSecure {
+				return fmt.Errorf("Client BUG: Expected encryption level to be secure, was %s", ev.encLevel)
+			}
+			return nil
 		}
-		return nil
 	}
 }
 
@@ -229,7 +339,7 @@ func (c *client) handlePacketWithVersionFlag(hdr *PublicHeader) error {
 	c.version = newVersion
 	c.versionNegotiated = true
 	var err error
-	c.connectionID, err = utils.GenerateConnectionID()
+	c.connectionID, err = utils.GenerateConnectionID(c.config.Rand)
 	if err != nil {
 		return err
 	}
@@ -245,6 +355,7 @@ func (c *client) createNewSession(negotiatedVersions []protocol.VersionNumber) e
 		c.conn,
 		c.hostname,
 		c.version,
+		c.initialVersion,
 		c.connectionID,
 		c.config,
 		negotiatedVersions,
@@ -264,6 +375,10 @@ func (c *client) createNewSession(negotiatedVersions []protocol.VersionNumber) e
 
 		utils.Infof("Connection %x closed.", c.connectionID)
 		c.conn.Close()
+
+		if quicErr, ok := err.(*qerr.QuicError); ok && quicErr.ErrorCode == qerr.PublicReset && c.config.OnPublicReset != nil {
+			c.config.OnPublicReset(c.session)
+		}
 	}()
 	return nil
 }
@@ -2,11 +2,16 @@ package quic
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
 	"errors"
+	mrand "math/rand"
 	"net"
+	"time"
 
 	"github.com/lucas-clemente/quic-go/protocol"
 	"github.com/lucas-clemente/quic-go/qerr"
+	"github.com/lucas-clemente/quic-go/utils"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -20,7 +25,7 @@ var _ = Describe("Client", func() {
 		packetConn *mockPacketConn
 		addr       net.Addr
 
-		originalClientSessConstructor func(conn connection, hostname string, v protocol.VersionNumber, connectionID protocol.ConnectionID, config *Config, negotiatedVersions []protocol.VersionNumber) (packetHandler, <-chan handshakeEvent, error)
+		originalClientSessConstructor func(conn connection, hostname string, v protocol.VersionNumber, initialVersion protocol.VersionNumber, connectionID protocol.ConnectionID, config *Config, negotiatedVersions []protocol.VersionNumber) (packetHandler, <-chan handshakeEvent, error)
 	)
 
 	BeforeEach(func() {
@@ -34,12 +39,13 @@ var _ = Describe("Client", func() {
 		}
 		addr = &net.UDPAddr{IP: net.IPv4(192, 168, 100, 200), Port: 1337}
 		cl = &client{
-			config:       config,
-			connectionID: 0x1337,
-			session:      sess,
-			version:      protocol.SupportedVersions[0],
-			conn:         &conn{pconn: packetConn, currentAddr: addr},
-			errorChan:    make(chan struct{}),
+			config:         populateClientConfig(config),
+			connectionID:   0x1337,
+			session:        sess,
+			version:        protocol.SupportedVersions[0],
+			initialVersion: protocol.SupportedVersions[0],
+			conn:           &conn{pconn: packetConn, currentAddr: addr},
+			errorChan:      make(chan struct{}),
 		}
 	})
 
@@ -60,6 +66,7 @@ var _ = Describe("Client", func() {
 				_ connection,
 				_ string,
 				_ protocol.VersionNumber,
+				_ protocol.VersionNumber,
 				_ protocol.ConnectionID,
 				_ *Config,
 				_ []protocol.VersionNumber,
@@ -111,12 +118,55 @@ var _ = Describe("Client", func() {
 			close(done)
 		})
 
+		It("transparently retries the handshake with the version chosen by version negotiation", func(done Done) {
+			config.Versions = protocol.SupportedVersions
+			// the server only supports the oldest version
+			serverVersion := protocol.SupportedVersions[len(protocol.SupportedVersions)-1]
+			Expect(config.Versions[0]).ToNot(Equal(serverVersion))
+			packetConn.dataToRead = composeVersionNegotiation(0x1337, []protocol.VersionNumber{serverVersion})
+			msess, _, _ := newMockSession(nil, 0, 0, nil, nil)
+			sess2 := msess.(*mockSession)
+			var versions []protocol.VersionNumber
+			newClientSession = func(
+				_ connection,
+				_ string,
+				v protocol.VersionNumber,
+				_ protocol.VersionNumber,
+				_ protocol.ConnectionID,
+				_ *Config,
+				_ []protocol.VersionNumber,
+			) (packetHandler, <-chan handshakeEvent, error) {
+				versions = append(versions, v)
+				if len(versions) == 1 {
+					return sess, sess.handshakeChan, nil
+				}
+				return sess2, sess2.handshakeChan, nil
+			}
+			var dialedSess Session
+			go func() {
+				defer GinkgoRecover()
+				var err error
+				dialedSess, err = Dial(packetConn, addr, "quic.clemente.io:1337", config)
+				Expect(err).ToNot(HaveOccurred())
+			}()
+			Eventually(func() bool { return sess.closed }).Should(BeTrue())
+			Expect(sess.closeReason).To(MatchError(errCloseSessionForNewVersion))
+			// the session reports the close on its handshake channel
+			sess.handshakeChan <- handshakeEvent{err: errCloseSessionForNewVersion}
+			sess2.handshakeChan <- handshakeEvent{encLevel: protocol.EncryptionSecure}
+			close(sess2.handshakeComplete)
+			Eventually(func() Session { return dialedSess }).Should(Equal(sess2))
+			Expect(versions).To(Equal([]protocol.VersionNumber{config.Versions[0], serverVersion}))
+			close(done)
+		})
+
 		It("resolves the address", func(done Done) {
 			var cconn connection
 			newClientSession = func(
 				conn connection,
 				_ string,
 				_ protocol.VersionNumber,
+				_ protocol.VersionNumber,
 				_ protocol.ConnectionID,
 				_ *Config,
 				_ []protocol.VersionNumber,
@@ -141,6 +191,21 @@ var _ = Describe("Client", func() {
 			close(done)
 		})
 
+		It("closes the session if the connection can't be secured", func(done Done) {
+			testErr := errors.New("early handshake error")
+			var dialErr error
+			var dialedSess Session
+			go func() {
+				dialedSess, dialErr = DialNonFWSecure(packetConn, addr, "quic.clemente.io:1337", config)
+			}()
+			sess.handshakeChan <- handshakeEvent{err: testErr}
+			Eventually(func() error { return dialErr }).Should(MatchError(testErr))
+			Expect(dialedSess).To(BeNil())
+			Expect(sess.closed).To(BeTrue())
+			Expect(sess.closeReason).To(MatchError(testErr))
+			close(done)
+		})
+
 		It("returns an error that occurs while waiting for the handshake to complete", func(done Done) {
 			testErr := errors.New("late handshake error")
 			var dialErr error
@@ -153,11 +218,123 @@ var _ = Describe("Client", func() {
 			close(done)
 		})
 
+		It("aborts dialing when the context is cancelled before the connection is secure", func(done Done) {
+			ctx, cancel := context.WithCancel(context.Background())
+			var dialErr error
+			go func() {
+				_, dialErr = DialContext(ctx, packetConn, addr, "quic.clemente.io:1337", config)
+			}()
+			Consistently(func() error { return dialErr }).ShouldNot(HaveOccurred())
+			cancel()
+			Eventually(func() error { return dialErr }).Should(MatchError(context.Canceled))
+			Expect(sess.closed).To(BeTrue())
+			Expect(sess.closeReason).To(MatchError(context.Canceled))
+			close(done)
+		})
+
+		It("aborts dialing when the context is cancelled before the handshake completes", func(done Done) {
+			ctx, cancel := context.WithCancel(context.Background())
+			var dialErr error
+			go func() {
+				_, dialErr = DialContext(ctx, packetConn, addr, "quic.clemente.io:1337", config)
+			}()
+			sess.handshakeChan <- handshakeEvent{encLevel: protocol.EncryptionSecure}
+			Consistently(func() error { return dialErr }).ShouldNot(HaveOccurred())
+			cancel()
+			Eventually(func() error { return dialErr }).Should(MatchError(context.Canceled))
+			Expect(sess.closed).To(BeTrue())
+			Expect(sess.closeReason).To(MatchError(context.Canceled))
+			close(sess.handshakeComplete)
+			close(done)
+		})
+
 		It("uses all supported versions, if none are specified in the quic.Config", func() {
 			c := populateClientConfig(&Config{})
 			Expect(c.Versions).To(Equal(protocol.SupportedVersions))
 		})
 
+		It("uses the default idle timeout, if none is specified in the quic.Config", func() {
+			c := populateClientConfig(&Config{})
+			Expect(c.IdleTimeout).To(Equal(protocol.MaxIdleTimeoutClient))
+			c = populateClientConfig(&Config{IdleTimeout: 42 * time.Second})
+			Expect(c.IdleTimeout).To(Equal(42 * time.Second))
+		})
+
+		It("uses crypto/rand, if no random source is specified in the quic.Config", func() {
+			c := populateClientConfig(&Config{})
+			Expect(c.Rand).To(Equal(rand.Reader))
+			r := mrand.New(mrand.NewSource(42))
+			c = populateClientConfig(&Config{Rand: r})
+			Expect(c.Rand).To(Equal(utils.NewLockedReader(r)))
+		})
+
+		It("uses the default maximum ACK delay, if none is specified in the quic.Config", func() {
+			c := populateClientConfig(&Config{})
+			Expect(c.MaxAckDelay).To(Equal(protocol.AckSendDelay))
+			c = populateClientConfig(&Config{MaxAckDelay: 5 * time.Millisecond})
+			Expect(c.MaxAckDelay).To(Equal(5 * time.Millisecond))
+		})
+
+		It("uses the stream scheduling from the quic.Config", func() {
+			c := populateClientConfig(&Config{})
+			Expect(c.StreamScheduling).To(Equal(StreamSchedulingRoundRobin))
+			c = populateClientConfig(&Config{StreamScheduling: StreamSchedulingInterleaved})
+			Expect(c.StreamScheduling).To(Equal(StreamSchedulingInterleaved))
+		})
+
+		It("copies the Disable0RTT option from the quic.Config", func() {
+			Expect(populateClientConfig(&Config{}).Disable0RTT).To(BeFalse())
+			Expect(populateClientConfig(&Config{Disable0RTT: true}).Disable0RTT).To(BeTrue())
+		})
+
+		It("uses the default handshake timeout, if none is specified in the quic.Config", func() {
+			c := populateClientConfig(&Config{})
+			Expect(c.HandshakeTimeout).To(Equal(protocol.MaxTimeForCryptoHandshake))
+			c = populateClientConfig(&Config{HandshakeTimeout: 42 * time.Second})
+			Expect(c.HandshakeTimeout).To(Equal(42 * time.Second))
+		})
+
+		It("uses the default maximum stream-level flow control window, if none is specified in the quic.Config", func() {
+			c := populateClientConfig(&Config{})
+			Expect(c.MaxReceiveStreamFlowControlWindow).To(BeEquivalentTo(protocol.MaxReceiveStreamFlowControlWindowClient))
+			c = populateClientConfig(&Config{MaxReceiveStreamFlowControlWindow: 1337})
+			Expect(c.MaxReceiveStreamFlowControlWindow).To(Equal(uint64(1337)))
+		})
+
+		It("uses the default maximum connection-level flow control window, if none is specified in the quic.Config", func() {
+			c := populateClientConfig(&Config{})
+			Expect(c.MaxReceiveConnectionFlowControlWindow).To(BeEquivalentTo(protocol.MaxReceiveConnectionFlowControlWindowClient))
+			c = populateClientConfig(&Config{MaxReceiveConnectionFlowControlWindow: 1337})
+			Expect(c.MaxReceiveConnectionFlowControlWindow).To(Equal(uint64(1337)))
+		})
+
+		It("limits the initial congestion window", func() {
+			c := populateClientConfig(&Config{})
+			Expect(c.InitialCongestionWindow).To(BeEquivalentTo(protocol.InitialCongestionWindow))
+			c = populateClientConfig(&Config{InitialCongestionWindow: protocol.MaxInitialCongestionWindow + 1})
+			Expect(c.InitialCongestionWindow).To(BeEquivalentTo(protocol.MaxInitialCongestionWindow))
+		})
+
+		It("limits the minimum congestion window to the initial congestion window", func() {
+			c := populateClientConfig(&Config{})
+			Expect(c.MinCongestionWindow).To(BeEquivalentTo(protocol.DefaultMinCongestionWindow))
+			c = populateClientConfig(&Config{MinCongestionWindow: 10})
+			Expect(c.MinCongestionWindow).To(Equal(protocol.PacketNumber(10)))
+			c = populateClientConfig(&Config{MinCongestionWindow: 50, InitialCongestionWindow: 20})
+			Expect(c.MinCongestionWindow).To(Equal(protocol.PacketNumber(20)))
+		})
+
+		It("limits the maximum packet size", func() {
+			c := populateClientConfig(&Config{})
+			Expect(c.MaxPacketSize).To(Equal(protocol.MaxPacketSize))
+			c = populateClientConfig(&Config{MaxPacketSize: 1250})
+			Expect(c.MaxPacketSize).To(Equal(protocol.ByteCount(1250)))
+			c = populateClientConfig(&Config{MaxPacketSize: protocol.MinMaxPacketSize - 1})
+			Expect(c.MaxPacketSize).To(Equal(protocol.MinMaxPacketSize))
+			c = populateClientConfig(&Config{MaxPacketSize: protocol.MaxPacketSize + 1})
+			Expect(c.MaxPacketSize).To(Equal(protocol.MaxPacketSize))
+		})
+
 		It("errors when receiving an invalid first packet from the server", func(done Done) {
 			packetConn.dataToRead = []byte{0xff}
 			_, err := Dial(packetConn, addr, "quic.clemente.io:1337", config)
@@ -173,12 +350,39 @@ var _ = Describe("Client", func() {
 			close(done)
 		})
 
+		It("generates the connection ID using the random source from the quic.Config", func() {
+			testErr := errors.New("stop dialing")
+			var connIDs []protocol.ConnectionID
+			newClientSession = func(
+				_ connection,
+				_ string,
+				_ protocol.VersionNumber,
+				_ protocol.VersionNumber,
+				connectionID protocol.ConnectionID,
+				_ *Config,
+				_ []protocol.VersionNumber,
+			) (packetHandler, <-chan handshakeEvent, error) {
+				connIDs = append(connIDs, connectionID)
+				return nil, nil, testErr
+			}
+			// dial twice, with readers using the same seed
+			for i := 0; i < 2; i++ {
+				config.Rand = mrand.New(mrand.NewSource(42))
+				_, err := DialNonFWSecure(packetConn, addr, "quic.clemente.io:1337", config)
+				Expect(err).To(MatchError(testErr))
+			}
+			Expect(connIDs).To(HaveLen(2))
+			Expect(connIDs[0]).ToNot(BeZero())
+			Expect(connIDs[0]).To(Equal(connIDs[1]))
+		})
+
 		It("errors if it can't create a session", func() {
 			testErr := errors.New("error creating session")
 			newClientSession = func(
 				_ connection,
 				_ string,
 				_ protocol.VersionNumber,
+				_ protocol.VersionNumber,
 				_ protocol.ConnectionID,
 				_ *Config,
 				_ []protocol.VersionNumber,
@@ -206,14 +410,17 @@ var _ = Describe("Client", func() {
 
 			It("changes the version after receiving a version negotiation packet", func() {
 				var negotiatedVersions []protocol.VersionNumber
+				var initialVersion protocol.VersionNumber
 				newClientSession = func(
 					_ connection,
 					_ string,
 					_ protocol.VersionNumber,
+					initialVersionP protocol.VersionNumber,
 					connectionID protocol.ConnectionID,
 					_ *Config,
 					negotiatedVersionsP []protocol.VersionNumber,
 				) (packetHandler, <-chan handshakeEvent, error) {
+					initialVersion = initialVersionP
 					negotiatedVersions = negotiatedVersionsP
 					return &mockSession{
 						connectionID: connectionID,
@@ -236,6 +443,8 @@ var _ = Describe("Client", func() {
 				// it didn't pass the version negoation packet to the old session (since it has no payload)
 				Expect(sess.packetCount).To(BeZero())
 				Expect(negotiatedVersions).To(Equal([]protocol.VersionNumber{newVersion}))
+				// the new session still uses the version offered in the first packet for the CHLO, to allow the server to detect downgrade attacks
+				Expect(initialVersion).To(Equal(protocol.SupportedVersions[0]))
 			})
 
 			It("errors if no matching version is found", func() {
@@ -257,6 +466,14 @@ var _ = Describe("Client", func() {
 				Expect(cl.version).To(Equal(config.Versions[1]))
 			})
 
+			It("changes to the highest version supported by both the quic.Config and the server", func() {
+				cl.config.Versions = protocol.SupportedVersions
+				Expect(cl.version).To(Equal(protocol.Version37))
+				err := cl.handlePacket(nil, composeVersionNegotiation(0x1337, []protocol.VersionNumber{1, protocol.Version35, protocol.Version36}))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(cl.version).To(Equal(protocol.Version36))
+			})
+
 			It("ignores delayed version negotiation packets", func() {
 				// if the version was not yet negotiated, handlePacket would return a VersionNegotiationMismatch error, see above test
 				cl.versionNegotiated = true
@@ -291,6 +508,7 @@ var _ = Describe("Client", func() {
 			connP connection,
 			hostnameP string,
 			versionP protocol.VersionNumber,
+			_ protocol.VersionNumber,
 			_ protocol.ConnectionID,
 			configP *Config,
 			_ []protocol.VersionNumber,
@@ -310,10 +528,47 @@ var _ = Describe("Client", func() {
 		Expect(cconn.(*conn).pconn).To(Equal(packetConn))
 		Expect(hostname).To(Equal("quic.clemente.io"))
 		Expect(version).To(Equal(cl.version))
-		Expect(conf).To(Equal(config))
+		Expect(conf).To(Equal(populateClientConfig(config)))
 		close(done)
 	})
 
+	Context("Public Resets", func() {
+		BeforeEach(func() {
+			newClientSession = func(
+				_ connection,
+				_ string,
+				_ protocol.VersionNumber,
+				_ protocol.VersionNumber,
+				_ protocol.ConnectionID,
+				_ *Config,
+				_ []protocol.VersionNumber,
+			) (packetHandler, <-chan handshakeEvent, error) {
+				return sess, sess.handshakeChan, nil
+			}
+		})
+
+		It("calls the OnPublicReset callback when the session is closed by a Public Reset", func() {
+			var resetSession Session
+			cl.config.OnPublicReset = func(s Session) { resetSession = s }
+			err := cl.createNewSession(nil)
+			Expect(err).ToNot(HaveOccurred())
+			sess.Close(qerr.Error(qerr.PublicReset, "public reset"))
+			Eventually(cl.errorChan).Should(BeClosed())
+			Eventually(func() Session { return resetSession }).Should(Equal(sess))
+			Expect(cl.listenErr).To(MatchError(qerr.Error(qerr.PublicReset, "public reset")))
+		})
+
+		It("doesn't call the OnPublicReset callback when the session is closed for another reason", func() {
+			var called bool
+			cl.config.OnPublicReset = func(Session) { called = true }
+			err := cl.createNewSession(nil)
+			Expect(err).ToNot(HaveOccurred())
+			sess.Close(qerr.Error(qerr.PeerGoingAway, "bye"))
+			Eventually(cl.errorChan).Should(BeClosed())
+			Consistently(func() bool { return called }).Should(BeFalse())
+		})
+	})
+
 	Context("handling packets", func() {
 		It("handles packets", func() {
 			ph := PublicHeader{
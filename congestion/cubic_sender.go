@@ -8,12 +8,12 @@ import (
 )
 
 const (
-	maxBurstBytes                                        = 3 * protocol.DefaultTCPMSS
-	defaultMinimumCongestionWindow protocol.PacketNumber = 2
-	renoBeta                       float32               = 0.7 // Reno backoff factor.
+	maxBurstBytes         = 3 * protocol.DefaultTCPMSS
+	renoBeta      float32 = 0.7 // Reno backoff factor.
 )
 
 type cubicSender struct {
+	clock           Clock
 	hybridSlowStart HybridSlowStart
 	prr             PrrSender
 	rttStats        *RTTStats
@@ -37,6 +37,18 @@ type cubicSender struct {
 	// Slow start congestion window in packets, aka ssthresh.
 	slowstartThreshold protocol.PacketNumber
 
+	// Congestion window, slow start threshold and cubic state before the last cutback.
+	// They are restored if all losses of the last loss event turn out to be spurious.
+	congestionWindowBeforeLastCutback   protocol.PacketNumber
+	slowstartThresholdBeforeLastCutback protocol.PacketNumber
+	cubicBeforeLastCutback              Cubic
+
+	// The lost packet that caused the last cutback.
+	// Losses of packets sent before this packet belong to an earlier loss event.
+	lostPacketAtLastCutback protocol.PacketNumber
+	// Number of packets declared lost in the last loss event that were not acked later.
+	lostPacketsSinceLastCutback int
+
 	// Whether the last loss event caused us to exit slowstart.
 	// Used for stats collection of slowstartPacketsLost
 	lastCutbackExitedSlowstart bool
@@ -61,13 +73,15 @@ type cubicSender struct {
 }
 
 // NewCubicSender makes a new cubic sender
-func NewCubicSender(clock Clock, rttStats *RTTStats, reno bool, initialCongestionWindow, initialMaxCongestionWindow protocol.PacketNumber) SendAlgorithmWithDebugInfo {
+// The congestion window is never reduced below minCongestionWindow.
+func NewCubicSender(clock Clock, rttStats *RTTStats, reno bool, initialCongestionWindow, initialMaxCongestionWindow, minCongestionWindow protocol.PacketNumber) SendAlgorithmWithDebugInfo {
 	return &cubicSender{
+		clock:                      clock,
 		rttStats:                   rttStats,
 		initialCongestionWindow:    initialCongestionWindow,
 		initialMaxCongestionWindow: initialMaxCongestionWindow,
 		congestionWindow:           initialCongestionWindow,
-		minCongestionWindow:        defaultMinimumCongestionWindow,
+		minCongestionWindow:        minCongestionWindow,
 		slowstartThreshold:         initialMaxCongestionWindow,
 		maxTCPCongestionWindow:     initialMaxCongestionWindow,
 		numConnections:             defaultNumConnections,
@@ -126,7 +140,7 @@ func (c *cubicSender) SlowstartThreshold() protocol.PacketNumber {
 }
 
 func (c *cubicSender) MaybeExitSlowStart() {
-	if c.InSlowStart() && c.hybridSlowStart.ShouldExitSlowStart(c.rttStats.LatestRTT(), c.rttStats.MinRTT(), c.GetCongestionWindow()/protocol.DefaultTCPMSS) {
+	if c.InSlowStart() && c.hybridSlowStart.ShouldExitSlowStart(c.clock.Now(), c.rttStats.LatestRTT(), c.rttStats.MinRTT(), c.GetCongestionWindow()/protocol.DefaultTCPMSS) {
 		c.ExitSlowstart()
 	}
 }
@@ -148,6 +162,9 @@ func (c *cubicSender) OnPacketLost(packetNumber protocol.PacketNumber, lostBytes
 	// TCP NewReno (RFC6582) says that once a loss occurs, any losses in packets
 	// already sent should be treated as a single loss event, since it's expected.
 	if packetNumber <= c.largestSentAtLastCutback {
+		if c.lostPacketsSinceLastCutback > 0 && packetNumber > c.lostPacketAtLastCutback {
+			c.lostPacketsSinceLastCutback++
+		}
 		if c.lastCutbackExitedSlowstart {
 			c.stats.slowstartPacketsLost++
 			c.stats.slowstartBytesLost += lostBytes
@@ -165,6 +182,11 @@ func (c *cubicSender) OnPacketLost(packetNumber protocol.PacketNumber, lostBytes
 	if c.InSlowStart() {
 		c.stats.slowstartPacketsLost++
 	}
+	c.congestionWindowBeforeLastCutback = c.congestionWindow
+	c.slowstartThresholdBeforeLastCutback = c.slowstartThreshold
+	c.cubicBeforeLastCutback = *c.cubic
+	c.lostPacketAtLastCutback = packetNumber
+	c.lostPacketsSinceLastCutback = 1
 
 	c.prr.OnPacketLost(bytesInFlight)
 
@@ -187,6 +209,26 @@ func (c *cubicSender) OnPacketLost(packetNumber protocol.PacketNumber, lostBytes
 	c.congestionWindowCount = 0
 }
 
+// OnSpuriousLoss is called when a packet that was declared lost is acked later.
+// Once all packets lost in the last loss event were acked, the cutback is undone.
+// Packets lost in an earlier loss event don't affect the last cutback.
+func (c *cubicSender) OnSpuriousLoss(packetNumber protocol.PacketNumber) {
+	if c.lostPacketsSinceLastCutback == 0 || packetNumber < c.lostPacketAtLastCutback || packetNumber > c.largestSentAtLastCutback {
+		return
+	}
+	c.lostPacketsSinceLastCutback--
+	if c.lostPacketsSinceLastCutback > 0 {
+		return
+	}
+	c.congestionWindow = utils.MaxPacketNumber(c.congestionWindow, c.congestionWindowBeforeLastCutback)
+	c.slowstartThreshold = c.slowstartThresholdBeforeLastCutback
+	*c.cubic = c.cubicBeforeLastCutback
+	c.lastCutbackExitedSlowstart = false
+	// leave recovery
+	c.largestSentAtLastCutback = 0
+	c.prr = PrrSender{}
+}
+
 func (c *cubicSender) RenoBeta() float32 {
 	// kNConnectionBeta is the backoff factor after loss for our N-connection
 	// emulation, which emulates the effective backoff of an ensemble of N
@@ -260,6 +302,8 @@ func (c *cubicSender) SetNumEmulatedConnections(n int) {
 // OnRetransmissionTimeout is called on an retransmission timeout
 func (c *cubicSender) OnRetransmissionTimeout(packetsRetransmitted bool) {
 	c.largestSentAtLastCutback = 0
+	// the reduction caused by an RTO is never undone
+	c.lostPacketsSinceLastCutback = 0
 	if !packetsRetransmitted {
 		return
 	}
@@ -276,6 +320,7 @@ func (c *cubicSender) OnConnectionMigration() {
 	c.largestSentPacketNumber = 0
 	c.largestAckedPacketNumber = 0
 	c.largestSentAtLastCutback = 0
+	c.lostPacketsSinceLastCutback = 0
 	c.lastCutbackExitedSlowstart = false
 	c.cubic.Reset()
 	c.congestionWindowCount = 0
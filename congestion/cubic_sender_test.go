@@ -39,7 +39,7 @@ var _ = Describe("Cubic Sender", func() {
 		ackedPacketNumber = 0
 		clock = mockClock{}
 		rttStats = NewRTTStats()
-		sender = NewCubicSender(&clock, rttStats, true /*reno*/, initialCongestionWindowPackets, MaxCongestionWindow)
+		sender = NewCubicSender(&clock, rttStats, true /*reno*/, initialCongestionWindowPackets, MaxCongestionWindow, protocol.DefaultMinCongestionWindow)
 	})
 
 	SendAvailableSendWindowLen := func(packetLength protocol.ByteCount) int {
@@ -371,6 +371,131 @@ var _ = Describe("Cubic Sender", func() {
 		}
 	})
 
+	It("exits slow start when the RTT increases", func() {
+		// the first ACKs establish a min RTT of 60ms
+		for i := 0; i < 10; i++ {
+			SendAvailableSendWindow()
+			AckNPackets(2)
+		}
+		Expect(sender.GetCongestionWindow()).To(BeNumerically(">=", hybridStartLowWindow*protocol.DefaultTCPMSS))
+		Expect(sender.SlowstartThreshold()).To(Equal(MaxCongestionWindow))
+
+		// queues build up, and the RTT increases
+		// the ACKs are spaced out, so they don't form an ACK train
+		for i := 0; i < 100 && sender.SlowstartThreshold() == MaxCongestionWindow; i++ {
+			SendAvailableSendWindow()
+			rttStats.UpdateRTT(80*time.Millisecond, 0, clock.Now())
+			sender.MaybeExitSlowStart()
+			ackedPacketNumber++
+			sender.OnPacketAcked(ackedPacketNumber, protocol.DefaultTCPMSS, bytesInFlight)
+			bytesInFlight -= protocol.DefaultTCPMSS
+			clock.Advance(10 * time.Millisecond)
+		}
+		// slow start was exited without losing any packets
+		Expect(sender.SlowstartThreshold()).To(BeNumerically("<", MaxCongestionWindow))
+		Expect(sender.InRecovery()).To(BeFalse())
+		Expect(sender.GetCongestionWindow()).To(BeNumerically(">=", protocol.ByteCount(sender.SlowstartThreshold())*protocol.DefaultTCPMSS))
+	})
+
+	It("reverts the cutback after a spurious loss", func() {
+		SendAvailableSendWindow()
+		AckNPackets(2)
+		SendAvailableSendWindow()
+		cwnd := sender.GetCongestionWindow()
+		ssthresh := sender.SlowstartThreshold()
+
+		LoseNPackets(1)
+		Expect(sender.GetCongestionWindow()).To(BeNumerically("<", cwnd))
+		Expect(sender.InRecovery()).To(BeTrue())
+
+		// packet 3 arrives late
+		sender.OnSpuriousLoss(3)
+		Expect(sender.GetCongestionWindow()).To(Equal(cwnd))
+		Expect(sender.SlowstartThreshold()).To(Equal(ssthresh))
+		Expect(sender.InRecovery()).To(BeFalse())
+	})
+
+	It("only reverts the cutback once all packets lost in the loss event were acked", func() {
+		SendAvailableSendWindow()
+		AckNPackets(2)
+		SendAvailableSendWindow()
+		cwnd := sender.GetCongestionWindow()
+
+		LoseNPackets(2)
+		reducedCwnd := sender.GetCongestionWindow()
+		Expect(reducedCwnd).To(BeNumerically("<", cwnd))
+		sender.OnSpuriousLoss(3)
+		Expect(sender.GetCongestionWindow()).To(Equal(reducedCwnd))
+		sender.OnSpuriousLoss(4)
+		Expect(sender.GetCongestionWindow()).To(Equal(cwnd))
+	})
+
+	It("doesn't revert the cutback when a packet lost in an earlier loss event is acked", func() {
+		SendAvailableSendWindow()
+		AckNPackets(2)
+		SendAvailableSendWindow()
+
+		// packet 3 is lost in the first loss event
+		LoseNPackets(1)
+		// leave recovery
+		SendAvailableSendWindow()
+		AckNPackets(int(packetNumber - 1 - ackedPacketNumber))
+		Expect(sender.InRecovery()).To(BeFalse())
+
+		SendAvailableSendWindow()
+		cwnd := sender.GetCongestionWindow()
+		LoseNPackets(1)
+		reducedCwnd := sender.GetCongestionWindow()
+		Expect(reducedCwnd).To(BeNumerically("<", cwnd))
+		Expect(sender.InRecovery()).To(BeTrue())
+
+		sender.OnSpuriousLoss(3)
+		Expect(sender.GetCongestionWindow()).To(Equal(reducedCwnd))
+		Expect(sender.InRecovery()).To(BeTrue())
+		// the packet lost in the second loss event arrives late
+		sender.OnSpuriousLoss(ackedPacketNumber)
+		Expect(sender.GetCongestionWindow()).To(Equal(cwnd))
+		Expect(sender.InRecovery()).To(BeFalse())
+	})
+
+	It("restores the cubic state after a spurious loss", func() {
+		sender = NewCubicSender(&clock, rttStats, false /*don't use reno*/, initialCongestionWindowPackets, MaxCongestionWindow, protocol.DefaultMinCongestionWindow)
+		SendAvailableSendWindow()
+		AckNPackets(2)
+		SendAvailableSendWindow()
+		cubicState := *sender.(*cubicSender).cubic
+
+		LoseNPackets(1)
+		Expect(*sender.(*cubicSender).cubic).ToNot(Equal(cubicState))
+		sender.OnSpuriousLoss(3)
+		Expect(*sender.(*cubicSender).cubic).To(Equal(cubicState))
+	})
+
+	It("doesn't revert the cutback of an RTO", func() {
+		SendAvailableSendWindow()
+		AckNPackets(2)
+		SendAvailableSendWindow()
+
+		LoseNPackets(1)
+		sender.OnRetransmissionTimeout(true)
+		sender.OnSpuriousLoss(3)
+		Expect(sender.GetCongestionWindow()).To(Equal(protocol.ByteCount(2 * protocol.DefaultTCPMSS)))
+	})
+
+	It("doesn't reduce the congestion window below the minimum congestion window", func() {
+		const minCongestionWindow = 5
+		sender = NewCubicSender(&clock, rttStats, true /*reno*/, initialCongestionWindowPackets, MaxCongestionWindow, minCongestionWindow)
+		// every packet is lost, and every loss is a new loss event
+		for i := 1; i <= 20; i++ {
+			sender.OnPacketSent(clock.Now(), 0, protocol.PacketNumber(i), protocol.DefaultTCPMSS, true)
+			sender.OnPacketLost(protocol.PacketNumber(i), protocol.DefaultTCPMSS, 0)
+			Expect(sender.GetCongestionWindow()).To(BeNumerically(">=", minCongestionWindow*protocol.DefaultTCPMSS))
+		}
+		Expect(sender.GetCongestionWindow()).To(Equal(minCongestionWindow * protocol.DefaultTCPMSS))
+		sender.OnRetransmissionTimeout(true)
+		Expect(sender.GetCongestionWindow()).To(Equal(minCongestionWindow * protocol.DefaultTCPMSS))
+	})
+
 	It("RTO congestion window", func() {
 		Expect(sender.GetCongestionWindow()).To(Equal(defaultWindowTCP))
 		Expect(sender.SlowstartThreshold()).To(Equal(MaxCongestionWindow))
@@ -421,7 +546,7 @@ var _ = Describe("Cubic Sender", func() {
 	It("slow start max send window", func() {
 		const kMaxCongestionWindowTCP = 50
 		const kNumberOfAcks = 100
-		sender = NewCubicSender(&clock, rttStats, false, initialCongestionWindowPackets, kMaxCongestionWindowTCP)
+		sender = NewCubicSender(&clock, rttStats, false, initialCongestionWindowPackets, kMaxCongestionWindowTCP, protocol.DefaultMinCongestionWindow)
 
 		for i := 0; i < kNumberOfAcks; i++ {
 			// Send our full send window.
@@ -435,7 +560,7 @@ var _ = Describe("Cubic Sender", func() {
 	It("tcp reno max congestion window", func() {
 		const kMaxCongestionWindowTCP = 50
 		const kNumberOfAcks = 1000
-		sender = NewCubicSender(&clock, rttStats, false, initialCongestionWindowPackets, kMaxCongestionWindowTCP)
+		sender = NewCubicSender(&clock, rttStats, false, initialCongestionWindowPackets, kMaxCongestionWindowTCP, protocol.DefaultMinCongestionWindow)
 
 		SendAvailableSendWindow()
 		AckNPackets(2)
@@ -457,7 +582,7 @@ var _ = Describe("Cubic Sender", func() {
 		// Set to 10000 to compensate for small cubic alpha.
 		const kNumberOfAcks = 10000
 
-		sender = NewCubicSender(&clock, rttStats, false, initialCongestionWindowPackets, kMaxCongestionWindowTCP)
+		sender = NewCubicSender(&clock, rttStats, false, initialCongestionWindowPackets, kMaxCongestionWindowTCP, protocol.DefaultMinCongestionWindow)
 
 		SendAvailableSendWindow()
 		AckNPackets(2)
@@ -477,7 +602,7 @@ var _ = Describe("Cubic Sender", func() {
 	It("tcp cubic reset epoch on quiescence", func() {
 		const kMaxCongestionWindow = 50
 		const kMaxCongestionWindowBytes = kMaxCongestionWindow * protocol.DefaultTCPMSS
-		sender = NewCubicSender(&clock, rttStats, false, initialCongestionWindowPackets, kMaxCongestionWindow)
+		sender = NewCubicSender(&clock, rttStats, false, initialCongestionWindowPackets, kMaxCongestionWindow, protocol.DefaultMinCongestionWindow)
 
 		num_sent := SendAvailableSendWindow()
 
@@ -517,7 +642,7 @@ var _ = Describe("Cubic Sender", func() {
 	It("tcp cubic shifted epoch on quiescence", func() {
 		const kMaxCongestionWindow = 50
 		const kMaxCongestionWindowBytes = kMaxCongestionWindow * protocol.DefaultTCPMSS
-		sender = NewCubicSender(&clock, rttStats, false, initialCongestionWindowPackets, kMaxCongestionWindow)
+		sender = NewCubicSender(&clock, rttStats, false, initialCongestionWindowPackets, kMaxCongestionWindow, protocol.DefaultMinCongestionWindow)
 
 		num_sent := SendAvailableSendWindow()
 
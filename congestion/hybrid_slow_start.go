@@ -11,6 +11,9 @@ import (
 // tcp_cubic.c.
 const hybridStartLowWindow = protocol.ByteCount(16)
 
+// ACKs arriving within this duration of each other are part of an ACK train.
+const hybridStartAckTrainDelta = 2 * time.Millisecond
+
 // Number of delay samples for detecting the increase of delay.
 const hybridStartMinSamples = uint32(8)
 
@@ -28,6 +31,10 @@ type HybridSlowStart struct {
 	currentMinRTT        time.Duration
 	rttSampleCount       uint32
 	hystartFound         bool
+	// the time the first ACK of the current round was received
+	roundStart time.Time
+	// the time the last ACK that was part of the ACK train was received
+	lastCloseAckTime time.Time
 }
 
 // StartReceiveRound is called for the start of each receive round (burst) in the slow start phase.
@@ -35,6 +42,7 @@ func (s *HybridSlowStart) StartReceiveRound(lastSent protocol.PacketNumber) {
 	s.endPacketNumber = lastSent
 	s.currentMinRTT = 0
 	s.rttSampleCount = 0
+	s.roundStart = time.Time{}
 	s.started = true
 }
 
@@ -45,10 +53,11 @@ func (s *HybridSlowStart) IsEndOfRound(ack protocol.PacketNumber) bool {
 
 // ShouldExitSlowStart should be called on every new ack frame, since a new
 // RTT measurement can be made then.
+// now: the time the ack frame was received.
 // rtt: the RTT for this ack packet.
 // minRTT: is the lowest delay (RTT) we have seen during the session.
 // congestionWindow: the congestion window in packets.
-func (s *HybridSlowStart) ShouldExitSlowStart(latestRTT time.Duration, minRTT time.Duration, congestionWindow protocol.ByteCount) bool {
+func (s *HybridSlowStart) ShouldExitSlowStart(now time.Time, latestRTT time.Duration, minRTT time.Duration, congestionWindow protocol.ByteCount) bool {
 	if !s.started {
 		// Time to start the hybrid slow start.
 		s.StartReceiveRound(s.lastSentPacketNumber)
@@ -56,6 +65,19 @@ func (s *HybridSlowStart) ShouldExitSlowStart(latestRTT time.Duration, minRTT ti
 	if s.hystartFound {
 		return true
 	}
+	// First detection parameter - ack train detection.
+	// In slow start, the whole congestion window is sent in a burst, so the
+	// ACKs of a round arrive closely spaced. If this train of ACKs lasts longer
+	// than half the minimum RTT, the congestion window exceeds the BDP.
+	if s.roundStart.IsZero() {
+		s.roundStart = now
+		s.lastCloseAckTime = now
+	} else if now.Sub(s.lastCloseAckTime) <= hybridStartAckTrainDelta {
+		s.lastCloseAckTime = now
+		if now.Sub(s.roundStart) >= minRTT/2 {
+			s.hystartFound = true
+		}
+	}
 	// Second detection parameter - delay increase detection.
 	// Compare the minimum delay (s.currentMinRTT) of the current
 	// burst of packets relative to the minimum delay during the session.
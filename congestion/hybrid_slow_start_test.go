@@ -52,6 +52,8 @@ var _ = Describe("Hybrid slow start", func() {
 		// We expect to detect the increase at +1/8 of the RTT; hence at a typical
 		// RTT of 60ms the detection will happen at 67.5 ms.
 		const kHybridStartMinSamples = 8 // Number of acks required to trigger.
+		// all acks arrive at the same time, so this doesn't count as an ack train
+		now := time.Now()
 
 		end_packet_number := protocol.PacketNumber(1)
 		end_packet_number++
@@ -60,16 +62,53 @@ var _ = Describe("Hybrid slow start", func() {
 		// Will not trigger since our lowest RTT in our burst is the same as the long
 		// term RTT provided.
 		for n := 0; n < kHybridStartMinSamples; n++ {
-			Expect(slowStart.ShouldExitSlowStart(rtt+time.Duration(n)*time.Millisecond, rtt, 100)).To(BeFalse())
+			Expect(slowStart.ShouldExitSlowStart(now, rtt+time.Duration(n)*time.Millisecond, rtt, 100)).To(BeFalse())
 		}
 		end_packet_number++
 		slowStart.StartReceiveRound(end_packet_number)
 		for n := 1; n < kHybridStartMinSamples; n++ {
-			Expect(slowStart.ShouldExitSlowStart(rtt+(time.Duration(n)+10)*time.Millisecond, rtt, 100)).To(BeFalse())
+			Expect(slowStart.ShouldExitSlowStart(now, rtt+(time.Duration(n)+10)*time.Millisecond, rtt, 100)).To(BeFalse())
 		}
 		// Expect to trigger since all packets in this burst was above the long term
 		// RTT provided.
-		Expect(slowStart.ShouldExitSlowStart(rtt+10*time.Millisecond, rtt, 100)).To(BeTrue())
+		Expect(slowStart.ShouldExitSlowStart(now, rtt+10*time.Millisecond, rtt, 100)).To(BeTrue())
+	})
+
+	It("works with an ack train", func() {
+		rtt := 60 * time.Millisecond
+		now := time.Now()
+		slowStart.StartReceiveRound(100)
+		// acks arrive every millisecond, the train is long enough after half an RTT
+		for i := 0; i < 30; i++ {
+			Expect(slowStart.ShouldExitSlowStart(now, rtt, rtt, 100)).To(BeFalse())
+			now = now.Add(time.Millisecond)
+		}
+		Expect(slowStart.ShouldExitSlowStart(now, rtt, rtt, 100)).To(BeTrue())
+	})
+
+	It("doesn't detect an ack train if the acks are spaced out", func() {
+		rtt := 60 * time.Millisecond
+		now := time.Now()
+		slowStart.StartReceiveRound(100)
+		for i := 0; i < 100; i++ {
+			Expect(slowStart.ShouldExitSlowStart(now, rtt, rtt, 100)).To(BeFalse())
+			now = now.Add(5 * time.Millisecond)
+		}
+	})
+
+	It("starts a new ack train in every round", func() {
+		rtt := 60 * time.Millisecond
+		now := time.Now()
+		slowStart.StartReceiveRound(100)
+		for i := 0; i < 20; i++ {
+			Expect(slowStart.ShouldExitSlowStart(now, rtt, rtt, 100)).To(BeFalse())
+			now = now.Add(time.Millisecond)
+		}
+		slowStart.StartReceiveRound(200)
+		for i := 0; i < 20; i++ {
+			Expect(slowStart.ShouldExitSlowStart(now, rtt, rtt, 100)).To(BeFalse())
+			now = now.Add(time.Millisecond)
+		}
 	})
 
 })
@@ -1,17 +1,43 @@
 package quic
 
 import (
+	"errors"
 	"net"
 	"sync"
+
+	"github.com/lucas-clemente/quic-go/utils"
 )
 
 type connection interface {
 	Write([]byte) error
+	WriteBatch([][]byte) error
+	WriteTo([]byte, net.Addr) error
 	Read([]byte) (int, net.Addr, error)
 	Close() error
 	LocalAddr() net.Addr
 	RemoteAddr() net.Addr
 	SetCurrentRemoteAddr(net.Addr)
+	SetPacketConn(net.PacketConn)
+	DontFragment() bool
+}
+
+var (
+	errBatchWritesNotSupported = errors.New("batched writes not supported")
+	errBatchReadsNotSupported  = errors.New("batched reads not supported")
+
+	errDontFragmentNotSupported = errors.New("setting the DF bit not supported")
+)
+
+// listenUDP creates a UDP connection for quic-go to use, and sets the DF bit on the packets sent on it, if possible
+func listenUDP(addr *net.UDPAddr) (*net.UDPConn, error) {
+	c, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := setDontFragment(c); err != nil {
+		utils.Debugf("Not setting the DF bit on packets sent from %s: %s", c.LocalAddr(), err)
+	}
+	return c, nil
 }
 
 type conn struct {
@@ -19,17 +45,82 @@ type conn struct {
 
 	pconn       net.PacketConn
 	currentAddr net.Addr
+
+	// if batchWrites is set, WriteBatch sends multiple packets with a single syscall, on platforms that support it
+	batchWrites bool
 }
 
 var _ connection = &conn{}
 
+// Write sends a packet to the current remote address.
+// The packet is not marked as ECN-capable: RFC 3168 only allows setting ECT if CE marks are reported back to the sender,
+// but the ACK frames of the supported gQUIC versions have no field for that.
 func (c *conn) Write(p []byte) error {
-	_, err := c.pconn.WriteTo(p, c.currentAddr)
+	return c.WriteTo(p, c.RemoteAddr())
+}
+
+// WriteTo sends a packet to an address other than the current remote address
+func (c *conn) WriteTo(p []byte, addr net.Addr) error {
+	c.mutex.RLock()
+	pconn := c.pconn
+	c.mutex.RUnlock()
+
+	_, err := pconn.WriteTo(p, addr)
 	return err
 }
 
+// WriteBatch sends multiple packets to the current remote address
+// If sending them with a single syscall is not possible, it falls back to sending them one by one.
+func (c *conn) WriteBatch(packets [][]byte) error {
+	addr := c.RemoteAddr()
+	if c.batchWrites && len(packets) > 1 {
+		c.mutex.RLock()
+		pconn := c.pconn
+		c.mutex.RUnlock()
+
+		n, err := writeBatch(pconn, packets, addr)
+		if err == nil {
+			return nil
+		}
+		// send the packets that were not sent yet one by one
+		packets = packets[n:]
+	}
+	for _, p := range packets {
+		if err := c.WriteTo(p, addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Read reads from the current packet conn
+// If the packet conn is replaced while reading, it continues reading from the new packet conn
 func (c *conn) Read(p []byte) (int, net.Addr, error) {
-	return c.pconn.ReadFrom(p)
+	for {
+		c.mutex.RLock()
+		pconn := c.pconn
+		c.mutex.RUnlock()
+
+		n, addr, err := pconn.ReadFrom(p)
+		if err != nil {
+			c.mutex.RLock()
+			replaced := c.pconn != pconn
+			c.mutex.RUnlock()
+			if replaced {
+				continue
+			}
+		}
+		return n, addr, err
+	}
+}
+
+// SetPacketConn replaces the packet conn, and closes the old one
+func (c *conn) SetPacketConn(pconn net.PacketConn) {
+	c.mutex.Lock()
+	oldPconn := c.pconn
+	c.pconn = pconn
+	c.mutex.Unlock()
+	oldPconn.Close()
 }
 
 func (c *conn) SetCurrentRemoteAddr(addr net.Addr) {
@@ -39,9 +130,18 @@ func (c *conn) SetCurrentRemoteAddr(addr net.Addr) {
 }
 
 func (c *conn) LocalAddr() net.Addr {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
 	return c.pconn.LocalAddr()
 }
 
+// DontFragment returns if the DF bit is set on the packets sent on the current packet conn
+func (c *conn) DontFragment() bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return dontFragmentSet(c.pconn)
+}
+
 func (c *conn) RemoteAddr() net.Addr {
 	c.mutex.RLock()
 	addr := c.currentAddr
@@ -50,5 +150,7 @@ func (c *conn) RemoteAddr() net.Addr {
 }
 
 func (c *conn) Close() error {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
 	return c.pconn.Close()
 }
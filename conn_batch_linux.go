@@ -0,0 +1,56 @@
+// +build linux,go1.9
+
+package quic
+
+import (
+	"net"
+
+	"golang.org/x/net/ipv4"
+)
+
+// writeBatch sends the packets using sendmmsg, which sends multiple packets with a single syscall.
+// It returns the number of packets that were sent.
+func writeBatch(pconn net.PacketConn, packets [][]byte, addr net.Addr) (int, error) {
+	udpConn, ok := pconn.(*net.UDPConn)
+	if !ok {
+		return 0, errBatchWritesNotSupported
+	}
+	msgs := make([]ipv4.Message, len(packets))
+	for i, p := range packets {
+		msgs[i] = ipv4.Message{Buffers: [][]byte{p}, Addr: addr}
+	}
+	pc := ipv4.NewPacketConn(udpConn)
+	var sent int
+	// sendmmsg might not send all messages at once
+	for sent < len(msgs) {
+		n, err := pc.WriteBatch(msgs[sent:], 0)
+		sent += n
+		if err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+// readBatch reads multiple packets into bufs using recvmmsg, which reads multiple packets with a single syscall.
+// The sizes and the remote addresses of the packets are stored in sizes and addrs.
+// It returns the number of packets that were read.
+func readBatch(pconn net.PacketConn, bufs [][]byte, sizes []int, addrs []net.Addr) (int, error) {
+	udpConn, ok := pconn.(*net.UDPConn)
+	if !ok {
+		return 0, errBatchReadsNotSupported
+	}
+	msgs := make([]ipv4.Message, len(bufs))
+	for i, b := range bufs {
+		msgs[i].Buffers = [][]byte{b}
+	}
+	n, err := ipv4.NewPacketConn(udpConn).ReadBatch(msgs, 0)
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < n; i++ {
+		sizes[i] = msgs[i].N
+		addrs[i] = msgs[i].Addr
+	}
+	return n, nil
+}
@@ -0,0 +1,59 @@
+// +build linux,go1.9
+
+package quic
+
+import (
+	"net"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Batched writes", func() {
+	var server, udpConn *net.UDPConn
+
+	BeforeEach(func() {
+		var err error
+		server, err = net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		Expect(err).ToNot(HaveOccurred())
+		udpConn, err = net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		server.Close()
+		udpConn.Close()
+	})
+
+	receive := func(num int) []string {
+		var received []string
+		b := make([]byte, 100)
+		server.SetReadDeadline(time.Now().Add(time.Second))
+		for i := 0; i < num; i++ {
+			n, err := server.Read(b)
+			Expect(err).ToNot(HaveOccurred())
+			received = append(received, string(b[:n]))
+		}
+		return received
+	}
+
+	It("sends multiple packets in one batched write", func() {
+		n, err := writeBatch(udpConn, [][]byte{[]byte("foo"), []byte("bar"), []byte("baz")}, server.LocalAddr())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(n).To(Equal(3))
+		Expect(receive(3)).To(Equal([]string{"foo", "bar", "baz"}))
+	})
+
+	It("sends multiple packets to the current remote address", func() {
+		c := &conn{pconn: udpConn, currentAddr: server.LocalAddr(), batchWrites: true}
+		err := c.WriteBatch([][]byte{[]byte("foo"), []byte("bar")})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(receive(2)).To(Equal([]string{"foo", "bar"}))
+	})
+
+	It("errors for connections that are not UDP connections", func() {
+		_, err := writeBatch(&mockPacketConn{}, [][]byte{[]byte("foo")}, server.LocalAddr())
+		Expect(err).To(MatchError(errBatchWritesNotSupported))
+	})
+})
@@ -0,0 +1,15 @@
+// +build !linux !go1.9
+
+package quic
+
+import "net"
+
+// writeBatch returns an error, since sending multiple packets with a single syscall is only supported on Linux
+func writeBatch(net.PacketConn, [][]byte, net.Addr) (int, error) {
+	return 0, errBatchWritesNotSupported
+}
+
+// readBatch returns an error, since reading multiple packets with a single syscall is only supported on Linux
+func readBatch(net.PacketConn, [][]byte, []int, []net.Addr) (int, error) {
+	return 0, errBatchReadsNotSupported
+}
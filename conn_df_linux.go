@@ -0,0 +1,52 @@
+// +build linux,go1.9
+
+package quic
+
+import (
+	"net"
+	"syscall"
+)
+
+// setDontFragment sets the DF bit on all packets sent on the connection, by setting the path MTU discovery mode of the socket to "do"
+// Both the IPv4 and the IPv6 option are set, since a socket listening on the wildcard address can send both IPv4 and IPv6 packets.
+func setDontFragment(c *net.UDPConn) error {
+	rawConn, err := c.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errIPv4, errIPv6 error
+	err = rawConn.Control(func(fd uintptr) {
+		errIPv4 = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_DO)
+		errIPv6 = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER, syscall.IPV6_PMTUDISC_DO)
+	})
+	if err != nil {
+		return err
+	}
+	// only one of the options can be set on sockets that can't send both IPv4 and IPv6 packets
+	if errIPv4 != nil && errIPv6 != nil {
+		return errIPv4
+	}
+	return nil
+}
+
+// dontFragmentSet returns if the DF bit is set on the packets sent on pconn
+func dontFragmentSet(pconn net.PacketConn) bool {
+	c, ok := pconn.(*net.UDPConn)
+	if !ok {
+		return false
+	}
+	rawConn, err := c.SyscallConn()
+	if err != nil {
+		return false
+	}
+	var set bool
+	err = rawConn.Control(func(fd uintptr) {
+		if val, err := syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER); err == nil && val == syscall.IP_PMTUDISC_DO {
+			set = true
+			return
+		}
+		val, err := syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER)
+		set = err == nil && val == syscall.IPV6_PMTUDISC_DO
+	})
+	return err == nil && set
+}
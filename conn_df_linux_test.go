@@ -0,0 +1,61 @@
+// +build linux,go1.9
+
+package quic
+
+import (
+	"crypto/tls"
+	"net"
+	"syscall"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DF bit", func() {
+	getMTUDiscover := func(c *net.UDPConn, level, opt int) int {
+		rawConn, err := c.SyscallConn()
+		Expect(err).ToNot(HaveOccurred())
+		var val int
+		var serr error
+		err = rawConn.Control(func(fd uintptr) {
+			val, serr = syscall.GetsockoptInt(int(fd), level, opt)
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(serr).ToNot(HaveOccurred())
+		return val
+	}
+
+	It("sets the DF bit for IPv4 connections", func() {
+		c, err := listenUDP(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		Expect(err).ToNot(HaveOccurred())
+		defer c.Close()
+		Expect(getMTUDiscover(c, syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER)).To(Equal(syscall.IP_PMTUDISC_DO))
+		Expect(dontFragmentSet(c)).To(BeTrue())
+	})
+
+	It("sets the DF bit for connections listening on the wildcard address", func() {
+		c, err := listenUDP(&net.UDPAddr{IP: net.IPv4zero})
+		Expect(err).ToNot(HaveOccurred())
+		defer c.Close()
+		Expect(dontFragmentSet(c)).To(BeTrue())
+	})
+
+	It("reports if the DF bit is not set", func() {
+		c, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		Expect(err).ToNot(HaveOccurred())
+		defer c.Close()
+		Expect(dontFragmentSet(c)).To(BeFalse())
+		Expect((&conn{pconn: c}).DontFragment()).To(BeFalse())
+	})
+
+	It("reports that the DF bit is not set for connections that are not UDP connections", func() {
+		Expect(dontFragmentSet(&mockPacketConn{})).To(BeFalse())
+	})
+
+	It("sets the DF bit when listening with ListenAddr", func() {
+		ln, err := ListenAddr("127.0.0.1:0", &Config{TLSConfig: &tls.Config{}})
+		Expect(err).ToNot(HaveOccurred())
+		defer ln.Close()
+		Expect(dontFragmentSet(ln.(*server).conn)).To(BeTrue())
+	})
+})
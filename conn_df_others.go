@@ -0,0 +1,15 @@
+// +build !linux !go1.9
+
+package quic
+
+import "net"
+
+// setDontFragment returns an error, since setting the DF bit is only supported on Linux
+func setDontFragment(*net.UDPConn) error {
+	return errDontFragmentNotSupported
+}
+
+// dontFragmentSet returns false, since setting the DF bit is only supported on Linux
+func dontFragmentSet(net.PacketConn) bool {
+	return false
+}
@@ -2,6 +2,7 @@ package quic
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"net"
 	"time"
@@ -67,6 +68,31 @@ var _ = Describe("Connection", func() {
 		Expect(packetConn.dataWrittenTo.String()).To(Equal("192.168.100.200:1337"))
 	})
 
+	It("writes to a different address", func() {
+		addr := &net.UDPAddr{IP: net.IPv4(192, 168, 100, 201), Port: 1338}
+		err := c.WriteTo([]byte("foobar"), addr)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(packetConn.dataWritten.Bytes()).To(Equal([]byte("foobar")))
+		Expect(packetConn.dataWrittenTo).To(Equal(addr))
+		Expect(c.RemoteAddr().String()).To(Equal("192.168.100.200:1337"))
+	})
+
+	Context("batched writes", func() {
+		It("sends the packets one by one, if batched writes are disabled", func() {
+			err := c.WriteBatch([][]byte{[]byte("foo"), []byte("bar")})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(packetConn.dataWritten.Bytes()).To(Equal([]byte("foobar")))
+			Expect(packetConn.dataWrittenTo.String()).To(Equal("192.168.100.200:1337"))
+		})
+
+		It("falls back to sending the packets one by one, if the connection doesn't support batched writes", func() {
+			c.batchWrites = true
+			err := c.WriteBatch([][]byte{[]byte("foo"), []byte("bar")})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(packetConn.dataWritten.Bytes()).To(Equal([]byte("foobar")))
+		})
+	})
+
 	It("reads", func() {
 		packetConn.dataToRead = []byte("foo")
 		packetConn.dataReadFrom = &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1336}
@@ -100,6 +126,51 @@ var _ = Describe("Connection", func() {
 		Expect(c.RemoteAddr().String()).To(Equal(addr.String()))
 	})
 
+	Context("replacing the packet conn", func() {
+		It("replaces the packet conn and closes the old one", func() {
+			newPacketConn := &mockPacketConn{}
+			c.SetPacketConn(newPacketConn)
+			Expect(packetConn.closed).To(BeTrue())
+			err := c.Write([]byte("foobar"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(packetConn.dataWritten.Len()).To(BeZero())
+			Expect(newPacketConn.dataWritten.Bytes()).To(Equal([]byte("foobar")))
+		})
+
+		It("continues reading from the new packet conn", func() {
+			oldUDPConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			Expect(err).ToNot(HaveOccurred())
+			newUDPConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			Expect(err).ToNot(HaveOccurred())
+			defer newUDPConn.Close()
+			c.pconn = oldUDPConn
+
+			read := make(chan []byte)
+			go func() {
+				defer GinkgoRecover()
+				p := make([]byte, 10)
+				n, _, err := c.Read(p)
+				Expect(err).ToNot(HaveOccurred())
+				read <- p[:n]
+			}()
+			Consistently(read).ShouldNot(Receive())
+			c.SetPacketConn(newUDPConn)
+			sender, err := net.DialUDP("udp", nil, newUDPConn.LocalAddr().(*net.UDPAddr))
+			Expect(err).ToNot(HaveOccurred())
+			defer sender.Close()
+			_, err = sender.Write([]byte("foo"))
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(read).Should(Receive(Equal([]byte("foo"))))
+		})
+
+		It("returns read errors if the packet conn wasn't replaced", func() {
+			testErr := errors.New("read failed")
+			packetConn.readErr = testErr
+			_, _, err := c.Read(make([]byte, 10))
+			Expect(err).To(MatchError(testErr))
+		})
+	})
+
 	It("closes", func() {
 		err := c.Close()
 		Expect(err).ToNot(HaveOccurred())
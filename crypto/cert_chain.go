@@ -2,6 +2,7 @@ package crypto
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"strings"
 )
@@ -11,6 +12,8 @@ type CertChain interface {
 	SignServerProof(sni string, chlo []byte, serverConfigData []byte) ([]byte, error)
 	GetCertsCompressed(sni string, commonSetHashes, cachedHashes []byte) ([]byte, error)
 	GetLeafCert(sni string) ([]byte, error)
+	GetNextProtos(sni string) ([]string, error)
+	GetClientAuth(sni string) (tls.ClientAuthType, *x509.CertPool, error)
 }
 
 // proofSource stores a key and a certificate for the server proof
@@ -55,6 +58,25 @@ func (c *certChain) GetLeafCert(sni string) ([]byte, error) {
 	return cert.Certificate[0], nil
 }
 
+// GetNextProtos gets the application protocols supported for the SNI, as set in tls.Config.NextProtos
+func (c *certChain) GetNextProtos(sni string) ([]string, error) {
+	config, err := maybeGetConfigForClient(c.config, sni)
+	if err != nil {
+		return nil, err
+	}
+	return config.NextProtos, nil
+}
+
+// GetClientAuth gets the client authentication policy and the CAs used to verify client certificates for the SNI,
+// as set in tls.Config.ClientAuth and tls.Config.ClientCAs
+func (c *certChain) GetClientAuth(sni string) (tls.ClientAuthType, *x509.CertPool, error) {
+	config, err := maybeGetConfigForClient(c.config, sni)
+	if err != nil {
+		return tls.NoClientCert, nil, err
+	}
+	return config.ClientAuth, config.ClientCAs, nil
+}
+
 func (cc *certChain) getCertForSNI(sni string) (*tls.Certificate, error) {
 	c := cc.config
 	c, err := maybeGetConfigForClient(c, sni)
@@ -5,6 +5,8 @@ import (
 	"compress/flate"
 	"compress/zlib"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"reflect"
 
 	"github.com/lucas-clemente/quic-go/testdata"
@@ -129,6 +131,23 @@ var _ = Describe("Proof", func() {
 			Expect(err).To(MatchError(errNoMatchingCertificate))
 		})
 
+		It("gets the application protocols", func() {
+			config.NextProtos = []string{"foo", "bar"}
+			protos, err := cc.GetNextProtos("quic.clemente.io")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(protos).To(Equal([]string{"foo", "bar"}))
+		})
+
+		It("gets the client authentication policy", func() {
+			pool := x509.NewCertPool()
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+			config.ClientCAs = pool
+			clientAuth, clientCAs, err := cc.GetClientAuth("quic.clemente.io")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clientAuth).To(Equal(tls.RequireAndVerifyClientCert))
+			Expect(clientCAs).To(BeIdenticalTo(pool))
+		})
+
 		It("respects GetConfigForClient", func() {
 			if !reflect.ValueOf(tls.Config{}).FieldByName("GetConfigForClient").IsValid() {
 				// Pre 1.8, we don't have to do anything
@@ -144,5 +163,50 @@ var _ = Describe("Proof", func() {
 			Expect(err).NotTo(HaveOccurred())
 			Expect(*resultCert).To(Equal(cert))
 		})
+
+		It("uses the original config if GetConfigForClient returns nil", func() {
+			if !reflect.ValueOf(tls.Config{}).FieldByName("GetConfigForClient").IsValid() {
+				// Pre 1.8, we don't have to do anything
+				return
+			}
+			config.Certificates = []tls.Certificate{cert}
+			l := func(*tls.ClientHelloInfo) (*tls.Config, error) { return nil, nil }
+			reflect.ValueOf(config).Elem().FieldByName("GetConfigForClient").Set(reflect.ValueOf(l))
+			resultCert, err := cc.getCertForSNI("quic.clemente.io")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*resultCert).To(Equal(cert))
+		})
+
+		It("returns the error of GetConfigForClient", func() {
+			if !reflect.ValueOf(tls.Config{}).FieldByName("GetConfigForClient").IsValid() {
+				// Pre 1.8, we don't have to do anything
+				return
+			}
+			testErr := errors.New("unknown SNI")
+			l := func(*tls.ClientHelloInfo) (*tls.Config, error) { return nil, testErr }
+			reflect.ValueOf(config).Elem().FieldByName("GetConfigForClient").Set(reflect.ValueOf(l))
+			_, err := cc.getCertForSNI("quic.clemente.io")
+			Expect(err).To(MatchError(testErr))
+		})
+
+		It("selects the certificate depending on the SNI, using GetConfigForClient", func() {
+			if !reflect.ValueOf(tls.Config{}).FieldByName("GetConfigForClient").IsValid() {
+				// Pre 1.8, we don't have to do anything
+				return
+			}
+			otherCert := tls.Certificate{Certificate: [][]byte{[]byte("other certificate")}}
+			configs := map[string]*tls.Config{
+				"quic.clemente.io": {Certificates: []tls.Certificate{cert}},
+				"www.example.org":  {Certificates: []tls.Certificate{otherCert}},
+			}
+			l := func(chi *tls.ClientHelloInfo) (*tls.Config, error) { return configs[chi.ServerName], nil }
+			reflect.ValueOf(config).Elem().FieldByName("GetConfigForClient").Set(reflect.ValueOf(l))
+			leafCert, err := cc.GetLeafCert("quic.clemente.io")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(leafCert).To(Equal(cert.Certificate[0]))
+			leafCert, err = cc.GetLeafCert("www.example.org")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(leafCert).To(Equal([]byte("other certificate")))
+		})
 	})
 })
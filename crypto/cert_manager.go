@@ -16,6 +16,7 @@ type CertManager interface {
 	GetCommonCertificateHashes() []byte
 	GetLeafCert() []byte
 	GetLeafCertHash() (uint64, error)
+	GetChain() []*x509.Certificate
 	VerifyServerProof(proof, chlo, serverConfigData []byte) bool
 	Verify(hostname string) error
 }
@@ -67,6 +68,12 @@ func (c *certManager) GetLeafCert() []byte {
 	return c.chain[0].Raw
 }
 
+// GetChain returns the certificate chain, starting with the leaf certificate
+// it returns nil if the certificate chain has not yet been set
+func (c *certManager) GetChain() []*x509.Certificate {
+	return c.chain
+}
+
 // GetLeafCertHash calculates the FNV1a_64 hash of the leaf certificate
 func (c *certManager) GetLeafCertHash() (uint64, error) {
 	leafCert := c.GetLeafCert()
@@ -92,29 +99,43 @@ func (c *certManager) VerifyServerProof(proof, chlo, serverConfigData []byte) bo
 	return verifyServerProof(proof, c.chain[0], chlo, serverConfigData)
 }
 
-// Verify verifies the certificate chain
+// Verify verifies the certificate chain, as configured by the client's tls.Config.
+// Unless InsecureSkipVerify is set, the chain has to be signed by one of the RootCAs (or a system root), and be valid for the ServerName (or the hostname, if no ServerName is set).
+// As in crypto/tls, VerifyPeerCertificate is called in any case.
 func (c *certManager) Verify(hostname string) error {
 	if len(c.chain) == 0 {
 		return errNoCertificateChain
 	}
 
-	if c.config != nil && c.config.InsecureSkipVerify {
+	var verifiedChains [][]*x509.Certificate
+	if c.config == nil || !c.config.InsecureSkipVerify {
+		var err error
+		verifiedChains, err = c.verifyChain(hostname)
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.config == nil {
 		return nil
 	}
+	return maybeVerifyPeerCertificate(c.config, c.chain, verifiedChains)
+}
 
+func (c *certManager) verifyChain(hostname string) ([][]*x509.Certificate, error) {
 	leafCert := c.chain[0]
 
-	var opts x509.VerifyOptions
+	opts := x509.VerifyOptions{DNSName: hostname}
 	if c.config != nil {
 		opts.Roots = c.config.RootCAs
-		opts.DNSName = c.config.ServerName
+		if c.config.ServerName != "" {
+			opts.DNSName = c.config.ServerName
+		}
 		if c.config.Time == nil {
 			opts.CurrentTime = time.Now()
 		} else {
 			opts.CurrentTime = c.config.Time()
 		}
-	} else {
-		opts.DNSName = hostname
 	}
 
 	// the first certificate is the leaf certificate, all others are intermediates
@@ -126,6 +147,5 @@ func (c *certManager) Verify(hostname string) error {
 		opts.Intermediates = intermediates
 	}
 
-	_, err := leafCert.Verify(opts)
-	return err
+	return leafCert.Verify(opts)
 }
@@ -7,7 +7,9 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/asn1"
+	"errors"
 	"math/big"
+	"reflect"
 	"runtime"
 	"time"
 
@@ -96,6 +98,17 @@ var _ = Describe("Cert Manager", func() {
 		})
 	})
 
+	It("gets the certificate chain", func() {
+		Expect(cm.GetChain()).To(BeNil())
+		compressed, err := compressChain([][]byte{cert1, cert2}, nil, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cm.SetData(compressed)).To(Succeed())
+		chain := cm.GetChain()
+		Expect(chain).To(HaveLen(2))
+		Expect(chain[0].Raw).To(Equal(cert1))
+		Expect(chain[1].Raw).To(Equal(cert2))
+	})
+
 	Context("getting the leaf cert hash", func() {
 		It("calculates the FVN1a 64 hash", func() {
 			cm.chain = make([]*x509.Certificate, 1)
@@ -324,6 +337,7 @@ var _ = Describe("Cert Manager", func() {
 				SerialNumber: big.NewInt(1),
 				NotBefore:    time.Now().Add(-25 * time.Hour),
 				NotAfter:     time.Now().Add(-23 * time.Hour),
+				DNSNames:     []string{"quic.clemente.io"},
 			}
 			_, leafCert := getCertificate(template)
 			cm.chain = []*x509.Certificate{leafCert}
@@ -390,5 +404,124 @@ var _ = Describe("Cert Manager", func() {
 			err = cm.Verify("quic.clemente.io")
 			Expect(err).ToNot(HaveOccurred())
 		})
+
+		Context("using RootCAs", func() {
+			var (
+				rootKey  *rsa.PrivateKey
+				rootCert *x509.Certificate
+			)
+
+			// getSignedCertificate generates a certificate for the hostname, signed by the root
+			getSignedCertificate := func(hostname string) *x509.Certificate {
+				template := &x509.Certificate{
+					SerialNumber: big.NewInt(1),
+					NotBefore:    time.Now().Add(-time.Hour),
+					NotAfter:     time.Now().Add(time.Hour),
+					DNSNames:     []string{hostname},
+				}
+				key, err := rsa.GenerateKey(rand.Reader, 1024)
+				Expect(err).ToNot(HaveOccurred())
+				return generateCertificate(template, rootCert, &key.PublicKey, rootKey)
+			}
+
+			BeforeEach(func() {
+				if runtime.GOOS == "windows" {
+					// certificate validation works different on windows, see https://golang.org/src/crypto/x509/verify.go line 238
+					Skip("windows")
+				}
+				rootKey, rootCert = getCertificate(&x509.Certificate{
+					SerialNumber:          big.NewInt(1),
+					NotBefore:             time.Now().Add(-time.Hour),
+					NotAfter:              time.Now().Add(time.Hour),
+					IsCA:                  true,
+					BasicConstraintsValid: true,
+				})
+			})
+
+			It("rejects a certificate that isn't signed by one of the RootCAs", func() {
+				_, otherRootCert := getCertificate(&x509.Certificate{
+					SerialNumber:          big.NewInt(2),
+					NotBefore:             time.Now().Add(-time.Hour),
+					NotAfter:              time.Now().Add(time.Hour),
+					IsCA:                  true,
+					BasicConstraintsValid: true,
+				})
+				rootCAPool := x509.NewCertPool()
+				rootCAPool.AddCert(otherRootCert)
+				cm.chain = []*x509.Certificate{getSignedCertificate("quic.clemente.io")}
+				cm.config = &tls.Config{RootCAs: rootCAPool}
+				err := cm.Verify("quic.clemente.io")
+				_, ok := err.(x509.UnknownAuthorityError)
+				Expect(ok).To(BeTrue())
+			})
+
+			It("verifies the hostname, if the client TLS config doesn't specify a ServerName", func() {
+				rootCAPool := x509.NewCertPool()
+				rootCAPool.AddCert(rootCert)
+				cm.config = &tls.Config{RootCAs: rootCAPool}
+				cm.chain = []*x509.Certificate{getSignedCertificate("quic.clemente.io")}
+				Expect(cm.Verify("quic.clemente.io")).To(Succeed())
+				cm.chain = []*x509.Certificate{getSignedCertificate("google.com")}
+				err := cm.Verify("quic.clemente.io")
+				_, ok := err.(x509.HostnameError)
+				Expect(ok).To(BeTrue())
+			})
+		})
+
+		Context("calling VerifyPeerCertificate", func() {
+			var (
+				rawCerts       [][]byte
+				verifiedChains [][]*x509.Certificate
+				verifyErr      error
+			)
+
+			BeforeEach(func() {
+				if !reflect.ValueOf(tls.Config{}).FieldByName("VerifyPeerCertificate").IsValid() {
+					Skip("VerifyPeerCertificate is only available in Go >= 1.8")
+				}
+				rawCerts = nil
+				verifiedChains = nil
+				verifyErr = nil
+				cm.config = &tls.Config{}
+				verify := func(raw [][]byte, chains [][]*x509.Certificate) error {
+					rawCerts = raw
+					verifiedChains = chains
+					return verifyErr
+				}
+				reflect.ValueOf(cm.config).Elem().FieldByName("VerifyPeerCertificate").Set(reflect.ValueOf(verify))
+			})
+
+			It("passes the certificates and the verified chains", func() {
+				cc := NewCertChain(testdata.GetTLSConfig()).(*certChain)
+				tlsCert, err := cc.getCertForSNI("quic.clemente.io")
+				Expect(err).ToNot(HaveOccurred())
+				for _, data := range tlsCert.Certificate {
+					cert, err := x509.ParseCertificate(data)
+					Expect(err).ToNot(HaveOccurred())
+					cm.chain = append(cm.chain, cert)
+				}
+				Expect(cm.Verify("quic.clemente.io")).To(Succeed())
+				Expect(rawCerts).To(Equal(tlsCert.Certificate))
+				Expect(verifiedChains).ToNot(BeEmpty())
+				Expect(verifiedChains[0][0]).To(Equal(cm.chain[0]))
+			})
+
+			It("is called with InsecureSkipVerify", func() {
+				_, leafCert := getCertificate(&x509.Certificate{SerialNumber: big.NewInt(1)})
+				cm.chain = []*x509.Certificate{leafCert}
+				cm.config.InsecureSkipVerify = true
+				Expect(cm.Verify("quic.clemente.io")).To(Succeed())
+				Expect(rawCerts).To(Equal([][]byte{leafCert.Raw}))
+				Expect(verifiedChains).To(BeNil())
+			})
+
+			It("returns the error", func() {
+				_, leafCert := getCertificate(&x509.Certificate{SerialNumber: big.NewInt(1)})
+				cm.chain = []*x509.Certificate{leafCert}
+				cm.config.InsecureSkipVerify = true
+				verifyErr = errors.New("untrusted certificate")
+				Expect(cm.Verify("quic.clemente.io")).To(MatchError(verifyErr))
+			})
+		})
 	})
 })
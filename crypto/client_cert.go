@@ -0,0 +1,78 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+
+	"github.com/lucas-clemente/quic-go/utils"
+)
+
+// The QUIC crypto handshake doesn't define client authentication, so we use our own (unofficial) extension:
+// The server requests a certificate in its REJ. The client then sends its certificate chain in the full CHLO,
+// together with a signature over its client nonce, its public key exchange value and the server config.
+// Since the signature covers the key exchange value, it can't be replayed in a different handshake.
+
+var errNoClientCertificate = errors.New("client certificate chain empty")
+
+// EncodeClientCertChain encodes the certificate chain sent by the client
+func EncodeClientCertChain(cert *tls.Certificate) ([]byte, error) {
+	return compressChain(cert.Certificate, nil, nil)
+}
+
+// ParseClientCertChain parses the certificate chain sent by the client
+func ParseClientCertChain(data []byte) ([]*x509.Certificate, error) {
+	byteChain, err := decompressChain(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(byteChain) == 0 {
+		return nil, errNoClientCertificate
+	}
+	chain := make([]*x509.Certificate, len(byteChain))
+	for i, data := range byteChain {
+		cert, err := x509.ParseCertificate(data)
+		if err != nil {
+			return nil, err
+		}
+		chain[i] = cert
+	}
+	return chain, nil
+}
+
+// SignClientProof signs the client nonce, the client's public key exchange value and the server config
+func SignClientProof(cert *tls.Certificate, nonce, pubs, serverConfigData []byte) ([]byte, error) {
+	return sign(cert, clientProofDigest(nonce, pubs, serverConfigData))
+}
+
+// VerifyClientProof verifies the signature created by SignClientProof, using the client's leaf certificate
+func VerifyClientProof(proof []byte, cert *x509.Certificate, nonce, pubs, serverConfigData []byte) bool {
+	return verifySignature(cert, clientProofDigest(nonce, pubs, serverConfigData), proof)
+}
+
+func clientProofDigest(nonce, pubs, serverConfigData []byte) []byte {
+	b := &bytes.Buffer{}
+	b.WriteString("QUIC client certificate signature\x00")
+	for _, data := range [][]byte{nonce, pubs, serverConfigData} {
+		utils.WriteUint32(b, uint32(len(data)))
+		b.Write(data)
+	}
+	digest := sha256.Sum256(b.Bytes())
+	return digest[:]
+}
+
+// VerifyClientCertChain verifies the certificate chain sent by the client, using the roots of the certificate authorities.
+// If roots is nil, the system roots are used.
+func VerifyClientCertChain(chain []*x509.Certificate, roots *x509.CertPool) ([][]*x509.Certificate, error) {
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	for _, cert := range chain[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	return chain[0].Verify(opts)
+}
@@ -0,0 +1,133 @@
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/testdata"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Client certificates", func() {
+	var (
+		nonce = []byte("client nonce")
+		pubs  = []byte("public value")
+		scfg  = []byte("server config")
+	)
+
+	// generateCert generates a certificate for client authentication, signed by the parent
+	// If parent is nil, the certificate is self-signed
+	generateCert := func(parent *x509.Certificate, parentKey *ecdsa.PrivateKey, isCA bool) (*tls.Certificate, *x509.Certificate, *ecdsa.PrivateKey) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			IsCA:                  isCA,
+			BasicConstraintsValid: true,
+		}
+		if parent == nil {
+			parent = template
+			parentKey = key
+		}
+		certDER, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+		Expect(err).ToNot(HaveOccurred())
+		cert, err := x509.ParseCertificate(certDER)
+		Expect(err).ToNot(HaveOccurred())
+		return &tls.Certificate{Certificate: [][]byte{certDER}, PrivateKey: key}, cert, key
+	}
+
+	Context("encoding the chain", func() {
+		It("encodes and parses a chain", func() {
+			tlsCert := testdata.GetCertificate()
+			data, err := EncodeClientCertChain(&tlsCert)
+			Expect(err).ToNot(HaveOccurred())
+			chain, err := ParseClientCertChain(data)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(chain).To(HaveLen(len(tlsCert.Certificate)))
+			for i, cert := range chain {
+				Expect(cert.Raw).To(Equal(tlsCert.Certificate[i]))
+			}
+		})
+
+		It("errors on an empty chain", func() {
+			data, err := EncodeClientCertChain(&tls.Certificate{})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = ParseClientCertChain(data)
+			Expect(err).To(MatchError(errNoClientCertificate))
+		})
+
+		It("errors on invalid data", func() {
+			_, err := ParseClientCertChain([]byte("foobar"))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("proofs", func() {
+		It("verifies a proof with an ECDSA key", func() {
+			tlsCert, cert, _ := generateCert(nil, nil, false)
+			proof, err := SignClientProof(tlsCert, nonce, pubs, scfg)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(VerifyClientProof(proof, cert, nonce, pubs, scfg)).To(BeTrue())
+		})
+
+		It("verifies a proof with an RSA key", func() {
+			tlsCert := testdata.GetCertificate()
+			cert, err := x509.ParseCertificate(tlsCert.Certificate[0])
+			Expect(err).ToNot(HaveOccurred())
+			proof, err := SignClientProof(&tlsCert, nonce, pubs, scfg)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(VerifyClientProof(proof, cert, nonce, pubs, scfg)).To(BeTrue())
+		})
+
+		It("rejects a proof for a different key exchange value", func() {
+			tlsCert, cert, _ := generateCert(nil, nil, false)
+			proof, err := SignClientProof(tlsCert, nonce, pubs, scfg)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(VerifyClientProof(proof, cert, nonce, []byte("other value"), scfg)).To(BeFalse())
+		})
+
+		It("rejects a proof signed by a different key", func() {
+			tlsCert, _, _ := generateCert(nil, nil, false)
+			_, otherCert, _ := generateCert(nil, nil, false)
+			proof, err := SignClientProof(tlsCert, nonce, pubs, scfg)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(VerifyClientProof(proof, otherCert, nonce, pubs, scfg)).To(BeFalse())
+		})
+
+		It("rejects an invalid proof", func() {
+			_, cert, _ := generateCert(nil, nil, false)
+			Expect(VerifyClientProof([]byte("foobar"), cert, nonce, pubs, scfg)).To(BeFalse())
+		})
+	})
+
+	Context("verifying the chain", func() {
+		It("verifies a chain signed by a trusted CA", func() {
+			_, caCert, caKey := generateCert(nil, nil, true)
+			_, intermediateCert, intermediateKey := generateCert(caCert, caKey, true)
+			_, leafCert, _ := generateCert(intermediateCert, intermediateKey, false)
+			roots := x509.NewCertPool()
+			roots.AddCert(caCert)
+			chains, err := VerifyClientCertChain([]*x509.Certificate{leafCert, intermediateCert}, roots)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(chains).To(HaveLen(1))
+			Expect(chains[0]).To(Equal([]*x509.Certificate{leafCert, intermediateCert, caCert}))
+		})
+
+		It("rejects a chain signed by an unknown CA", func() {
+			_, caCert, caKey := generateCert(nil, nil, true)
+			_, leafCert, _ := generateCert(caCert, caKey, false)
+			_, err := VerifyClientCertChain([]*x509.Certificate{leafCert}, x509.NewCertPool())
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -4,11 +4,20 @@ package crypto
 
 import "crypto/tls"
 
+// maybeGetConfigForClient returns the config returned by GetConfigForClient.
+// As in crypto/tls, the original config is used if GetConfigForClient is nil or returns nil.
 func maybeGetConfigForClient(c *tls.Config, sni string) (*tls.Config, error) {
 	if c.GetConfigForClient == nil {
 		return c, nil
 	}
-	return c.GetConfigForClient(&tls.ClientHelloInfo{
+	config, err := c.GetConfigForClient(&tls.ClientHelloInfo{
 		ServerName: sni,
 	})
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return c, nil
+	}
+	return config, nil
 }
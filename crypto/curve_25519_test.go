@@ -18,6 +18,27 @@ var _ = Describe("ProofRsa", func() {
 		Expect(sA).To(Equal(sB))
 	})
 
+	It("creates the same key from the same private key", func() {
+		secret := []byte("0123456789abcdef0123456789abcdef")
+		a, err := NewCurve25519KEXFromSecret(secret)
+		Expect(err).ToNot(HaveOccurred())
+		b, err := NewCurve25519KEXFromSecret(secret)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(a.PublicKey()).To(Equal(b.PublicKey()))
+		c, err := NewCurve25519KEX()
+		Expect(err).ToNot(HaveOccurred())
+		sA, err := a.CalculateSharedKey(c.PublicKey())
+		Expect(err).ToNot(HaveOccurred())
+		sC, err := c.CalculateSharedKey(b.PublicKey())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sA).To(Equal(sC))
+	})
+
+	It("rejects private keys of the wrong length", func() {
+		_, err := NewCurve25519KEXFromSecret([]byte("foobar"))
+		Expect(err).To(MatchError("Curve25519: expected private key of 32 byte"))
+	})
+
 	It("rejects short public keys", func() {
 		a, err := NewCurve25519KEX()
 		Expect(err).ToNot(HaveOccurred())
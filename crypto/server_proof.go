@@ -25,7 +25,11 @@ func signServerProof(cert *tls.Certificate, chlo []byte, serverConfigData []byte
 	hash.Write([]byte{32, 0, 0, 0})
 	hash.Write(chloHash[:])
 	hash.Write(serverConfigData)
+	return sign(cert, hash.Sum(nil))
+}
 
+// sign signs a SHA-256 digest with the private key of the certificate
+func sign(cert *tls.Certificate, digest []byte) ([]byte, error) {
 	key, ok := cert.PrivateKey.(crypto.Signer)
 	if !ok {
 		return nil, errors.New("expected PrivateKey to implement crypto.Signer")
@@ -37,7 +41,7 @@ func signServerProof(cert *tls.Certificate, chlo []byte, serverConfigData []byte
 		opts = &rsa.PSSOptions{SaltLength: 32, Hash: crypto.SHA256}
 	}
 
-	return key.Sign(rand.Reader, hash.Sum(nil), opts)
+	return key.Sign(rand.Reader, digest, opts)
 }
 
 // verifyServerProof verifies the server proof signature
@@ -48,19 +52,23 @@ func verifyServerProof(proof []byte, cert *x509.Certificate, chlo []byte, server
 	hash.Write([]byte{32, 0, 0, 0})
 	hash.Write(chloHash[:])
 	hash.Write(serverConfigData)
+	return verifySignature(cert, hash.Sum(nil), proof)
+}
 
-	// RSA
-	if cert.PublicKeyAlgorithm == x509.RSA {
+// verifySignature verifies a signature of a SHA-256 digest, created by sign
+func verifySignature(cert *x509.Certificate, digest []byte, signature []byte) bool {
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
 		opts := &rsa.PSSOptions{SaltLength: 32, Hash: crypto.SHA256}
-		err := rsa.VerifyPSS(cert.PublicKey.(*rsa.PublicKey), crypto.SHA256, hash.Sum(nil), proof, opts)
-		return err == nil
-	}
-
-	// ECDSA
-	signature := &ecdsaSignature{}
-	rest, err := asn1.Unmarshal(proof, signature)
-	if err != nil || len(rest) != 0 {
+		return rsa.VerifyPSS(pub, crypto.SHA256, digest, signature, opts) == nil
+	case *ecdsa.PublicKey:
+		sig := &ecdsaSignature{}
+		rest, err := asn1.Unmarshal(signature, sig)
+		if err != nil || len(rest) != 0 {
+			return false
+		}
+		return ecdsa.Verify(pub, digest, sig.R, sig.S)
+	default:
 		return false
 	}
-	return ecdsa.Verify(cert.PublicKey.(*ecdsa.PublicKey), hash.Sum(nil), signature.R, signature.S)
 }
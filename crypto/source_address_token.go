@@ -7,6 +7,10 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"io"
+	"sync"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/protocol"
 
 	"golang.org/x/crypto/hkdf"
 )
@@ -19,8 +23,16 @@ type StkSource interface {
 	DecodeToken([]byte) ([]byte, error)
 }
 
+// The secret used to encrypt the tokens is replaced every STKSecretRotationInterval, unless it was provided by the application.
+// Tokens encrypted with the previous secret can still be decoded.
 type stkSource struct {
-	aead cipher.AEAD
+	mutex sync.Mutex
+
+	aead          cipher.AEAD
+	previousAEAD  cipher.AEAD
+	secretCreated time.Time
+	// fixedSecret is set if the secret was provided by the application, it is never rotated
+	fixedSecret bool
 }
 
 const stkKeySize = 16
@@ -31,10 +43,33 @@ const stkNonceSize = 16
 
 // NewStkSource creates a source for source address tokens
 func NewStkSource() (StkSource, error) {
+	aead, err := newStkAEAD()
+	if err != nil {
+		return nil, err
+	}
+	return &stkSource{aead: aead, secretCreated: time.Now()}, nil
+}
+
+// NewStkSourceFromSecret creates a source for source address tokens, using the given secret.
+// The secret is never rotated, so a source created with the same secret (e.g. after a restart of the server) decodes the same tokens.
+func NewStkSourceFromSecret(secret []byte) (StkSource, error) {
+	aead, err := newStkAEADFromSecret(secret)
+	if err != nil {
+		return nil, err
+	}
+	return &stkSource{aead: aead, secretCreated: time.Now(), fixedSecret: true}, nil
+}
+
+// newStkAEAD creates an AEAD using a new random secret
+func newStkAEAD() (cipher.AEAD, error) {
 	secret := make([]byte, 32)
 	if _, err := rand.Read(secret); err != nil {
 		return nil, err
 	}
+	return newStkAEADFromSecret(secret)
+}
+
+func newStkAEADFromSecret(secret []byte) (cipher.AEAD, error) {
 	key, err := deriveKey(secret)
 	if err != nil {
 		return nil, err
@@ -43,14 +78,18 @@ func NewStkSource() (StkSource, error) {
 	if err != nil {
 		return nil, err
 	}
-	aead, err := cipher.NewGCMWithNonceSize(c, stkNonceSize)
-	if err != nil {
-		return nil, err
-	}
-	return &stkSource{aead: aead}, nil
+	return cipher.NewGCMWithNonceSize(c, stkNonceSize)
 }
 
 func (s *stkSource) NewToken(data []byte) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.fixedSecret && time.Since(s.secretCreated) >= protocol.STKSecretRotationInterval {
+		if err := s.rotateSecret(); err != nil {
+			return nil, err
+		}
+	}
 	nonce := make([]byte, stkNonceSize)
 	if _, err := rand.Read(nonce); err != nil {
 		return nil, err
@@ -62,8 +101,28 @@ func (s *stkSource) DecodeToken(p []byte) ([]byte, error) {
 	if len(p) < stkNonceSize {
 		return nil, fmt.Errorf("STK too short: %d", len(p))
 	}
+	s.mutex.Lock()
+	aead, previousAEAD := s.aead, s.previousAEAD
+	s.mutex.Unlock()
+
 	nonce := p[:stkNonceSize]
-	return s.aead.Open(nil, nonce, p[stkNonceSize:], nil)
+	data, err := aead.Open(nil, nonce, p[stkNonceSize:], nil)
+	if err != nil && previousAEAD != nil {
+		return previousAEAD.Open(nil, nonce, p[stkNonceSize:], nil)
+	}
+	return data, err
+}
+
+// rotateSecret replaces the secret used to encrypt new tokens. It must be called with the mutex held.
+func (s *stkSource) rotateSecret() error {
+	aead, err := newStkAEAD()
+	if err != nil {
+		return err
+	}
+	s.previousAEAD = s.aead
+	s.aead = aead
+	s.secretCreated = time.Now()
+	return nil
 }
 
 func deriveKey(secret []byte) ([]byte, error) {
@@ -1,6 +1,10 @@
 package crypto
 
 import (
+	"time"
+
+	"github.com/lucas-clemente/quic-go/protocol"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -37,5 +41,83 @@ var _ = Describe("Source Address Tokens", func() {
 			_, err := source.DecodeToken(nil)
 			Expect(err).To(MatchError("STK too short: 0"))
 		})
+
+		Context("rotating the secret", func() {
+			It("doesn't rotate the secret before the rotation interval", func() {
+				aead := source.aead
+				_, err := source.NewToken([]byte("foobar"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(source.aead).To(BeIdenticalTo(aead))
+				Expect(source.previousAEAD).To(BeNil())
+			})
+
+			It("rotates the secret when issuing a token after the rotation interval", func() {
+				aead := source.aead
+				source.secretCreated = time.Now().Add(-protocol.STKSecretRotationInterval)
+				_, err := source.NewToken([]byte("foobar"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(source.aead).ToNot(BeIdenticalTo(aead))
+				Expect(source.previousAEAD).To(BeIdenticalTo(aead))
+				Expect(source.secretCreated).To(BeTemporally("~", time.Now(), time.Second))
+			})
+
+			It("decodes tokens encrypted with the previous secret", func() {
+				token, err := source.NewToken([]byte("foobar"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(source.rotateSecret()).To(Succeed())
+				data, err := source.DecodeToken(token)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(data).To(Equal([]byte("foobar")))
+			})
+
+			It("rejects tokens encrypted with an older secret", func() {
+				token, err := source.NewToken([]byte("foobar"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(source.rotateSecret()).To(Succeed())
+				Expect(source.rotateSecret()).To(Succeed())
+				_, err = source.DecodeToken(token)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("message authentication failed"))
+			})
+		})
+	})
+
+	Context("tokens using a fixed secret", func() {
+		secret := []byte("a secret that survives a restart")
+
+		It("decodes tokens created by a source using the same secret", func() {
+			source, err := NewStkSourceFromSecret(secret)
+			Expect(err).ToNot(HaveOccurred())
+			token, err := source.NewToken([]byte("foobar"))
+			Expect(err).ToNot(HaveOccurred())
+			// restart, using the same secret
+			source, err = NewStkSourceFromSecret(secret)
+			Expect(err).ToNot(HaveOccurred())
+			data, err := source.DecodeToken(token)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data).To(Equal([]byte("foobar")))
+		})
+
+		It("rejects tokens created by a source using a different secret", func() {
+			source, err := NewStkSourceFromSecret(secret)
+			Expect(err).ToNot(HaveOccurred())
+			token, err := source.NewToken([]byte("foobar"))
+			Expect(err).ToNot(HaveOccurred())
+			source, err = NewStkSourceFromSecret([]byte("another secret"))
+			Expect(err).ToNot(HaveOccurred())
+			_, err = source.DecodeToken(token)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("doesn't rotate the secret", func() {
+			sourceI, err := NewStkSourceFromSecret(secret)
+			Expect(err).ToNot(HaveOccurred())
+			source := sourceI.(*stkSource)
+			aead := source.aead
+			source.secretCreated = time.Now().Add(-protocol.STKSecretRotationInterval)
+			_, err = source.NewToken([]byte("foobar"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(source.aead).To(BeIdenticalTo(aead))
+		})
 	})
 })
@@ -0,0 +1,21 @@
+// +build go1.8
+
+package crypto
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+)
+
+// maybeVerifyPeerCertificate calls VerifyPeerCertificate, if set.
+// verifiedChains is nil if the chain wasn't verified, i.e. if InsecureSkipVerify is set.
+func maybeVerifyPeerCertificate(c *tls.Config, chain []*x509.Certificate, verifiedChains [][]*x509.Certificate) error {
+	if c.VerifyPeerCertificate == nil {
+		return nil
+	}
+	rawCerts := make([][]byte, len(chain))
+	for i, cert := range chain {
+		rawCerts[i] = cert.Raw
+	}
+	return c.VerifyPeerCertificate(rawCerts, verifiedChains)
+}
@@ -0,0 +1,12 @@
+// +build !go1.8
+
+package crypto
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+)
+
+func maybeVerifyPeerCertificate(c *tls.Config, chain []*x509.Certificate, verifiedChains [][]*x509.Certificate) error {
+	return nil
+}
@@ -132,6 +132,34 @@ func (f *flowControlManager) AddBytesRead(streamID protocol.StreamID, n protocol
 	return nil
 }
 
+// DiscardUnreadData should be called when a stream was reset locally
+// it treats all data received on the stream, but not read yet, as read for connection-level flow control
+// streamID must not be 0 here
+func (f *flowControlManager) DiscardUnreadData(streamID protocol.StreamID) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	fc, err := f.getFlowController(streamID)
+	if err != nil {
+		return err
+	}
+
+	n := fc.highestReceived - fc.bytesRead
+	if n <= 0 {
+		return nil
+	}
+	// don't send a WindowUpdate for the stream, the peer won't send any more data on it
+	fc.bytesRead += n
+	fc.receiveWindow += n
+	if fc.ContributesToConnection() {
+		f.connFlowController.AddBytesRead(n)
+	}
+	return nil
+}
+
+// GetWindowUpdates gets the WindowUpdates that need to be sent
+// it should be called once for every packet sent, and returns at most one WindowUpdate per stream, for the latest offset
+// this way, reading in many small chunks doesn't generate a WindowUpdate per Read call
 func (f *flowControlManager) GetWindowUpdates() (res []WindowUpdate) {
 	f.mutex.Lock()
 	defer f.mutex.Unlock()
@@ -207,6 +235,22 @@ func (f *flowControlManager) RemainingConnectionWindowSize() protocol.ByteCount
 	return f.connFlowController.SendWindowSize()
 }
 
+// IsNewlyBlocked says if the stream is blocked by flow control at an offset it was not blocked at before
+// streamID may be 0 here, for the connection
+func (f *flowControlManager) IsNewlyBlocked(streamID protocol.StreamID) (bool, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if streamID == 0 {
+		return f.connFlowController.IsNewlyBlocked(), nil
+	}
+	fc, err := f.getFlowController(streamID)
+	if err != nil {
+		return false, err
+	}
+	return fc.IsNewlyBlocked(), nil
+}
+
 // streamID may be 0 here
 func (f *flowControlManager) UpdateWindow(streamID protocol.StreamID, offset protocol.ByteCount) (bool, error) {
 	f.mutex.Lock()
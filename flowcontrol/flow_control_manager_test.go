@@ -79,6 +79,18 @@ var _ = Describe("Flow Control Manager", func() {
 			Expect(fcm.connFlowController.highestReceived).To(Equal(protocol.ByteCount(100 + 50)))
 		})
 
+		It("doesn't count retransmitted data twice for the connection flow control window", func() {
+			err := fcm.UpdateHighestReceived(4, 100)
+			Expect(err).ToNot(HaveOccurred())
+			// a retransmission of data that was received already, and a reordered frame
+			err = fcm.UpdateHighestReceived(4, 100)
+			Expect(err).ToNot(HaveOccurred())
+			err = fcm.UpdateHighestReceived(4, 50)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fcm.streamFlowController[4].highestReceived).To(Equal(protocol.ByteCount(100)))
+			Expect(fcm.connFlowController.highestReceived).To(Equal(protocol.ByteCount(100)))
+		})
+
 		It("does not update the connection level flow controller if the stream does not contribute", func() {
 			err := fcm.UpdateHighestReceived(1, 100)
 			// fcm.streamFlowController[4].receiveWindow = 0x1000
@@ -149,6 +161,18 @@ var _ = Describe("Flow Control Manager", func() {
 				Expect(updates).ToNot(ContainElement(WindowUpdate{StreamID: 0, Offset: 200}))
 			})
 
+			It("coalesces window updates for many small reads", func() {
+				err := fcm.UpdateHighestReceived(4, 100)
+				Expect(err).ToNot(HaveOccurred())
+				for i := 0; i < 90; i++ {
+					err = fcm.AddBytesRead(4, 1)
+					Expect(err).ToNot(HaveOccurred())
+				}
+				updates := fcm.GetWindowUpdates()
+				Expect(updates).To(Equal([]WindowUpdate{{StreamID: 4, Offset: 190}}))
+				Expect(fcm.GetWindowUpdates()).To(BeEmpty())
+			})
+
 			It("errors when AddBytesRead is called for a stream doesn't exist", func() {
 				err := fcm.AddBytesRead(17, 1000)
 				Expect(err).To(MatchError(errMapAccess))
@@ -183,6 +207,72 @@ var _ = Describe("Flow Control Manager", func() {
 		})
 	})
 
+	Context("stream-level window auto-tuning", func() {
+		It("increases the window increment when data is read fast, up to the maximum", func() {
+			cpm = &mockConnectionParametersManager{
+				receiveStreamFlowControlWindow:        100,
+				maxReceiveStreamFlowControlWindow:     800,
+				receiveConnectionFlowControlWindow:    10000,
+				maxReceiveConnectionFlowControlWindow: 10000,
+			}
+			rttStats := &congestion.RTTStats{}
+			rttStats.UpdateRTT(10*time.Millisecond, 0, time.Now())
+			fcm = NewFlowControlManager(cpm, rttStats).(*flowControlManager)
+			fcm.NewStream(5, false)
+
+			var bytesRead protocol.ByteCount
+			var offsets []protocol.ByteCount
+			for i := 0; i < 4; i++ {
+				// read just enough data to trigger a window update for the stream
+				fc := fcm.streamFlowController[5]
+				n := fc.receiveWindow - bytesRead - fc.receiveWindowIncrement/2 + 1
+				err := fcm.UpdateHighestReceived(5, bytesRead+n)
+				Expect(err).ToNot(HaveOccurred())
+				err = fcm.AddBytesRead(5, n)
+				Expect(err).ToNot(HaveOccurred())
+				bytesRead += n
+				updates := fcm.GetWindowUpdates()
+				Expect(updates).To(HaveLen(1))
+				Expect(updates[0].StreamID).To(Equal(protocol.StreamID(5)))
+				offsets = append(offsets, updates[0].Offset)
+			}
+			// the window increment doubles with every WindowUpdate, until it reaches the maximum
+			Expect(offsets).To(Equal([]protocol.ByteCount{51 + 200, 152 + 400, 353 + 800, 754 + 800}))
+		})
+	})
+
+	Context("connection-level window auto-tuning", func() {
+		It("increases the window increment when data is consumed fast, up to the maximum", func() {
+			cpm = &mockConnectionParametersManager{
+				receiveStreamFlowControlWindow:        10000,
+				maxReceiveStreamFlowControlWindow:     10000,
+				receiveConnectionFlowControlWindow:    200,
+				maxReceiveConnectionFlowControlWindow: 800,
+			}
+			rttStats := &congestion.RTTStats{}
+			rttStats.UpdateRTT(10*time.Millisecond, 0, time.Now())
+			fcm = NewFlowControlManager(cpm, rttStats).(*flowControlManager)
+			fcm.NewStream(4, true)
+
+			var bytesRead protocol.ByteCount
+			var increments []protocol.ByteCount
+			for i := 0; i < 3; i++ {
+				// read just enough data to trigger a connection-level window update
+				n := fcm.connFlowController.receiveWindow - bytesRead - fcm.connFlowController.receiveWindowIncrement/2 + 1
+				err := fcm.UpdateHighestReceived(4, bytesRead+n)
+				Expect(err).ToNot(HaveOccurred())
+				err = fcm.AddBytesRead(4, n)
+				Expect(err).ToNot(HaveOccurred())
+				bytesRead += n
+				updates := fcm.GetWindowUpdates()
+				Expect(updates).To(HaveLen(1))
+				Expect(updates[0].StreamID).To(BeZero())
+				increments = append(increments, updates[0].Offset-bytesRead)
+			}
+			Expect(increments).To(Equal([]protocol.ByteCount{400, 800, 800}))
+		})
+	})
+
 	Context("resetting a stream", func() {
 		BeforeEach(func() {
 			fcm.NewStream(1, false)
@@ -232,6 +322,45 @@ var _ = Describe("Flow Control Manager", func() {
 		})
 	})
 
+	Context("discarding unread data", func() {
+		BeforeEach(func() {
+			fcm.NewStream(1, false)
+			fcm.NewStream(4, true)
+		})
+
+		It("treats the unread data as read for the connection", func() {
+			Expect(fcm.UpdateHighestReceived(4, 80)).To(Succeed())
+			Expect(fcm.AddBytesRead(4, 30)).To(Succeed())
+			Expect(fcm.DiscardUnreadData(4)).To(Succeed())
+			Expect(fcm.connFlowController.bytesRead).To(Equal(protocol.ByteCount(80)))
+			Expect(fcm.streamFlowController[4].bytesRead).To(Equal(protocol.ByteCount(80)))
+		})
+
+		It("doesn't count data twice", func() {
+			Expect(fcm.UpdateHighestReceived(4, 50)).To(Succeed())
+			Expect(fcm.DiscardUnreadData(4)).To(Succeed())
+			Expect(fcm.UpdateHighestReceived(4, 70)).To(Succeed())
+			Expect(fcm.DiscardUnreadData(4)).To(Succeed())
+			Expect(fcm.connFlowController.bytesRead).To(Equal(protocol.ByteCount(70)))
+		})
+
+		It("doesn't update the connection level flow controller if the stream does not contribute", func() {
+			Expect(fcm.UpdateHighestReceived(1, 50)).To(Succeed())
+			Expect(fcm.DiscardUnreadData(1)).To(Succeed())
+			Expect(fcm.connFlowController.bytesRead).To(BeZero())
+		})
+
+		It("doesn't queue a WindowUpdate for the stream", func() {
+			Expect(fcm.UpdateHighestReceived(4, 90)).To(Succeed())
+			Expect(fcm.DiscardUnreadData(4)).To(Succeed())
+			Expect(fcm.GetWindowUpdates()).To(BeEmpty())
+		})
+
+		It("returns an error when called with an unknown stream", func() {
+			Expect(fcm.DiscardUnreadData(1337)).To(MatchError(errMapAccess))
+		})
+	})
+
 	Context("sending data", func() {
 		It("adds bytes sent for all stream contributing to connection level flow control", func() {
 			fcm.NewStream(1, false)
@@ -293,6 +422,35 @@ var _ = Describe("Flow Control Manager", func() {
 				Expect(size).To(Equal(protocol.ByteCount(1000 - 500)))
 			})
 
+			It("says when a stream is newly blocked", func() {
+				fcm.NewStream(5, true)
+				_, err := fcm.UpdateWindow(0, 1000)
+				Expect(err).ToNot(HaveOccurred())
+				_, err = fcm.UpdateWindow(5, 500)
+				Expect(err).ToNot(HaveOccurred())
+				fcm.AddBytesSent(5, 500)
+				Expect(fcm.IsNewlyBlocked(0)).To(BeFalse())
+				Expect(fcm.IsNewlyBlocked(5)).To(BeTrue())
+				Expect(fcm.IsNewlyBlocked(5)).To(BeFalse())
+			})
+
+			It("says when the connection is newly blocked", func() {
+				fcm.NewStream(5, true)
+				_, err := fcm.UpdateWindow(0, 500)
+				Expect(err).ToNot(HaveOccurred())
+				_, err = fcm.UpdateWindow(5, 1000)
+				Expect(err).ToNot(HaveOccurred())
+				fcm.AddBytesSent(5, 500)
+				Expect(fcm.IsNewlyBlocked(5)).To(BeFalse())
+				Expect(fcm.IsNewlyBlocked(0)).To(BeTrue())
+				Expect(fcm.IsNewlyBlocked(0)).To(BeFalse())
+			})
+
+			It("errors when asked if a stream that doesn't exist is blocked", func() {
+				_, err := fcm.IsNewlyBlocked(17)
+				Expect(err).To(MatchError(errMapAccess))
+			})
+
 			It("erros when asked for the send window size of a stream that doesn't exist", func() {
 				_, err := fcm.SendWindowSize(17)
 				Expect(err).To(MatchError(errMapAccess))
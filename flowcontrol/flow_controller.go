@@ -19,6 +19,8 @@ type flowController struct {
 
 	bytesSent  protocol.ByteCount
 	sendWindow protocol.ByteCount
+	// lastBlockedAt is the send window offset at which we last reported being blocked
+	lastBlockedAt protocol.ByteCount
 
 	lastWindowUpdateTime time.Time
 
@@ -95,6 +97,20 @@ func (c *flowController) SendWindowOffset() protocol.ByteCount {
 	return c.getSendWindow()
 }
 
+// IsNewlyBlocked says if the send window is used up
+// it returns true only once for every offset, so that only one BLOCKED frame is sent until the peer increases the window
+func (c *flowController) IsNewlyBlocked() bool {
+	if c.SendWindowSize() != 0 {
+		return false
+	}
+	offset := c.getSendWindow()
+	if offset == c.lastBlockedAt {
+		return false
+	}
+	c.lastBlockedAt = offset
+	return true
+}
+
 // UpdateHighestReceived updates the highestReceived value, if the byteOffset is higher
 // Should **only** be used for the stream-level FlowController
 // it returns an ErrReceivedSmallerByteOffset if the received byteOffset is smaller than any byteOffset received before
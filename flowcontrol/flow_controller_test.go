@@ -154,6 +154,32 @@ var _ = Describe("Flow controller", func() {
 			Expect(controller.SendWindowSize()).To(Equal(protocol.ByteCount(20)))
 		})
 
+		Context("detecting when it's blocked", func() {
+			It("isn't blocked if there's space left in the window", func() {
+				controller.sendWindow = 12
+				controller.bytesSent = 11
+				Expect(controller.IsNewlyBlocked()).To(BeFalse())
+			})
+
+			It("reports being blocked only once for every offset", func() {
+				controller.sendWindow = 12
+				controller.bytesSent = 12
+				Expect(controller.IsNewlyBlocked()).To(BeTrue())
+				Expect(controller.IsNewlyBlocked()).To(BeFalse())
+			})
+
+			It("reports being blocked again after the window was increased", func() {
+				controller.sendWindow = 12
+				controller.bytesSent = 12
+				Expect(controller.IsNewlyBlocked()).To(BeTrue())
+				controller.UpdateSendWindow(20)
+				Expect(controller.IsNewlyBlocked()).To(BeFalse())
+				controller.AddBytesSent(8)
+				Expect(controller.IsNewlyBlocked()).To(BeTrue())
+				Expect(controller.IsNewlyBlocked()).To(BeFalse())
+			})
+		})
+
 		It("asks the ConnectionParametersManager for the stream flow control window size", func() {
 			controller.streamID = 5
 			Expect(controller.getSendWindow()).To(Equal(protocol.ByteCount(1000)))
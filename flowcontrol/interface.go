@@ -16,11 +16,14 @@ type FlowControlManager interface {
 	ResetStream(streamID protocol.StreamID, byteOffset protocol.ByteCount) error
 	UpdateHighestReceived(streamID protocol.StreamID, byteOffset protocol.ByteCount) error
 	AddBytesRead(streamID protocol.StreamID, n protocol.ByteCount) error
+	DiscardUnreadData(streamID protocol.StreamID) error
 	GetWindowUpdates() []WindowUpdate
 	GetReceiveWindow(streamID protocol.StreamID) (protocol.ByteCount, error)
 	// methods needed for sending data
 	AddBytesSent(streamID protocol.StreamID, n protocol.ByteCount) error
 	SendWindowSize(streamID protocol.StreamID) (protocol.ByteCount, error)
 	RemainingConnectionWindowSize() protocol.ByteCount
+	// IsNewlyBlocked says if a BLOCKED frame should be sent for the stream, or for the connection if streamID is 0
+	IsNewlyBlocked(streamID protocol.StreamID) (bool, error)
 	UpdateWindow(streamID protocol.StreamID, offset protocol.ByteCount) (bool, error)
 }
@@ -3,6 +3,7 @@ package frames
 import (
 	"bytes"
 	"errors"
+	"sync"
 
 	"github.com/lucas-clemente/quic-go/protocol"
 	"github.com/lucas-clemente/quic-go/qerr"
@@ -23,6 +24,15 @@ var (
 	errInvalidOffsetLen   = errors.New("StreamFrame: Invalid offset length")
 )
 
+// dataPool holds the buffers that the data of parsed StreamFrames is read into
+var dataPool sync.Pool
+
+func init() {
+	dataPool.New = func() interface{} {
+		return make([]byte, 0, protocol.MaxReceivePacketSize)
+	}
+}
+
 // ParseStreamFrame reads a stream frame. The type byte must not have been read yet.
 func ParseStreamFrame(r *bytes.Reader) (*StreamFrame, error) {
 	frame := &StreamFrame{}
@@ -69,7 +79,7 @@ func ParseStreamFrame(r *bytes.Reader) (*StreamFrame, error) {
 		dataLen = uint16(r.Len())
 	}
 	if dataLen != 0 {
-		frame.Data = make([]byte, dataLen)
+		frame.Data = dataPool.Get().([]byte)[:dataLen]
 		n, err := r.Read(frame.Data)
 		if n != int(dataLen) {
 			return nil, errors.New("BUG: StreamFrame could not read dataLen bytes")
@@ -90,6 +100,17 @@ func ParseStreamFrame(r *bytes.Reader) (*StreamFrame, error) {
 	return frame, nil
 }
 
+// PutBack returns the data buffer of a StreamFrame returned by ParseStreamFrame to the buffer pool.
+// It must only be called once the data was completely consumed, the frame must not be used afterwards.
+// It is a no-op for frames that were not parsed.
+func (f *StreamFrame) PutBack() {
+	if cap(f.Data) != int(protocol.MaxReceivePacketSize) {
+		return
+	}
+	dataPool.Put(f.Data[:0])
+	f.Data = nil
+}
+
 // WriteStreamFrame writes a stream frame.
 func (f *StreamFrame) Write(b *bytes.Buffer, version protocol.VersionNumber) error {
 	if len(f.Data) == 0 && !f.FinBit {
@@ -2,6 +2,7 @@ package frames
 
 import (
 	"bytes"
+	"testing"
 
 	"github.com/lucas-clemente/quic-go/protocol"
 	"github.com/lucas-clemente/quic-go/qerr"
@@ -103,6 +104,42 @@ var _ = Describe("StreamFrame", func() {
 				Expect(err).To(HaveOccurred())
 			}
 		})
+
+		Context("putting back the data buffer", func() {
+			data := []byte{0x80, 0x1, 'f', 'o', 'o', 'b', 'a', 'r'}
+
+			It("reads the data into a buffer of the max receive packet size", func() {
+				frame, err := ParseStreamFrame(bytes.NewReader(data))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(frame.Data).To(HaveCap(int(protocol.MaxReceivePacketSize)))
+			})
+
+			It("drops the data when putting back a parsed frame", func() {
+				frame, err := ParseStreamFrame(bytes.NewReader(data))
+				Expect(err).ToNot(HaveOccurred())
+				frame.PutBack()
+				Expect(frame.Data).To(BeNil())
+			})
+
+			It("doesn't modify frames that were not parsed", func() {
+				frame := &StreamFrame{Data: []byte("foobar")}
+				frame.PutBack()
+				Expect(frame.Data).To(Equal([]byte("foobar")))
+			})
+
+			Measure("allocations when parsing a frame", func(b Benchmarker) {
+				allocs := testing.AllocsPerRun(1000, func() {
+					frame, _ := ParseStreamFrame(bytes.NewReader(data))
+					frame.PutBack()
+				})
+				b.RecordValue("allocations per frame", allocs)
+				allocsWithoutPutBack := testing.AllocsPerRun(1000, func() {
+					ParseStreamFrame(bytes.NewReader(data))
+				})
+				b.RecordValue("allocations per frame, without putting back the buffer", allocsWithoutPutBack)
+				Expect(allocs).To(BeNumerically("<", allocsWithoutPutBack))
+			}, 5)
+		})
 	})
 
 	Context("when writing", func() {
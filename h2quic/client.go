@@ -1,14 +1,15 @@
 package h2quic
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
-	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"strings"
 	"sync"
+	"time"
 
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/hpack"
@@ -20,11 +21,13 @@ import (
 	"github.com/lucas-clemente/quic-go/utils"
 )
 
+var errClientClosed = errors.New("h2quic: client closed")
+
 // Client is a HTTP2 client doing QUIC requests
 type Client struct {
 	mutex sync.RWMutex
 
-	dialAddr func(hostname string, config *quic.Config) (quic.Session, error)
+	dialAddr func(ctx context.Context, hostname string, config *quic.Config) (quic.Session, error)
 	config   *quic.Config
 
 	t *QuicRoundTripper
@@ -33,13 +36,21 @@ type Client struct {
 	encryptionLevel protocol.EncryptionLevel
 	handshakeErr    error
 	dialChan        chan struct{} // will be closed once the handshake is complete and the header stream has been opened
+	cancelDial      context.CancelFunc
+	closed          bool
+	used            bool // set once the session was used for a request, reported as GotConnInfo.Reused
 
 	session       quic.Session
 	headerStream  quic.Stream
 	headerErr     *qerr.QuicError
 	requestWriter *requestWriter
 
+	serverSettings  *peerSettings
+	activeRequests  int           // number of requests that are counted against the server's SETTINGS_MAX_CONCURRENT_STREAMS
+	requestSlotChan chan struct{} // will be closed (and replaced) once a request finished, or the server's SETTINGS changed
+
 	responses map[protocol.StreamID]chan *http.Response
+	trailers  map[protocol.StreamID]chan http.Header // for responses that declared trailers
 }
 
 var _ h2quicClient = &Client{}
@@ -48,26 +59,45 @@ var _ h2quicClient = &Client{}
 func NewClient(t *QuicRoundTripper, tlsConfig *tls.Config, hostname string) *Client {
 	return &Client{
 		t:               t,
-		dialAddr:        quic.DialAddr,
+		dialAddr:        quic.DialAddrContext,
 		hostname:        authorityAddr("https", hostname),
 		responses:       make(map[protocol.StreamID]chan *http.Response),
+		trailers:        make(map[protocol.StreamID]chan http.Header),
 		encryptionLevel: protocol.EncryptionUnencrypted,
 		config: &quic.Config{
 			TLSConfig:                     tlsConfig,
 			RequestConnectionIDTruncation: true,
 		},
-		dialChan: make(chan struct{}),
+		dialChan:        make(chan struct{}),
+		serverSettings:  newPeerSettings(),
+		requestSlotChan: make(chan struct{}),
 	}
 }
 
 // Dial dials the connection
-func (c *Client) Dial() (err error) {
+func (c *Client) Dial() error {
+	return c.DialContext(context.Background())
+}
+
+// DialContext dials the connection. The handshake is aborted if the context is cancelled.
+func (c *Client) DialContext(ctx context.Context) (err error) {
 	defer func() {
+		if err != nil && c.session != nil {
+			_ = c.session.Close(err)
+		}
 		c.handshakeErr = err
 		close(c.dialChan)
 	}()
 
-	c.session, err = c.dialAddr(c.hostname, c.config)
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		return errClientClosed
+	}
+	ctx, c.cancelDial = context.WithCancel(ctx)
+	c.mutex.Unlock()
+
+	c.session, err = c.dialAddr(ctx, c.hostname, c.config)
 	if err != nil {
 		return err
 	}
@@ -80,13 +110,22 @@ func (c *Client) Dial() (err error) {
 	if c.headerStream.StreamID() != 3 {
 		return errors.New("h2quic Client BUG: StreamID of Header Stream is not 3")
 	}
+	// server push is enabled by default, and we only accept pushes if a PushHandler is set
+	enablePush := http2.Setting{ID: http2.SettingEnablePush, Val: 0}
+	if c.t.pushHandler() != nil {
+		enablePush.Val = 1
+	}
+	maxHeaderListSize := http2.Setting{ID: http2.SettingMaxHeaderListSize, Val: c.t.maxResponseHeaderBytes()}
+	if err = writeSettings(c.headerStream, 0, enablePush, maxHeaderListSize); err != nil {
+		return err
+	}
 	c.requestWriter = newRequestWriter(c.headerStream)
 	go c.handleHeaderStream()
 	return
 }
 
 func (c *Client) handleHeaderStream() {
-	decoder := hpack.NewDecoder(4096, func(hf hpack.HeaderField) {})
+	decoder := hpack.NewDecoder(headerTableSize, func(hf hpack.HeaderField) {})
 	h2framer := http2.NewFramer(nil, c.headerStream)
 
 	var lastStream protocol.StreamID
@@ -98,30 +137,67 @@ func (c *Client) handleHeaderStream() {
 			break
 		}
 		lastStream = protocol.StreamID(frame.Header().StreamID)
+		if settingsFrame, ok := frame.(*http2.SettingsFrame); ok {
+			if err := c.serverSettings.apply(settingsFrame); err != nil {
+				c.headerErr = qerr.Error(qerr.InvalidHeadersStreamData, err.Error())
+				break
+			}
+			c.requestWriter.setMaxHeaderTableSize(c.serverSettings.getHeaderTableSize())
+			// the server might have raised SETTINGS_MAX_CONCURRENT_STREAMS
+			c.mutex.Lock()
+			c.notifyRequestSlot()
+			c.mutex.Unlock()
+			continue
+		}
+		if ppframe, ok := frame.(*http2.PushPromiseFrame); ok {
+			if c.headerErr = c.handlePushPromise(decoder, ppframe); c.headerErr != nil {
+				break
+			}
+			continue
+		}
 		hframe, ok := frame.(*http2.HeadersFrame)
 		if !ok {
 			c.headerErr = qerr.Error(qerr.InvalidHeadersStreamData, "not a headers frame")
 			break
 		}
 		mhframe := &http2.MetaHeadersFrame{HeadersFrame: hframe}
-		mhframe.Fields, err = decoder.DecodeFull(hframe.HeaderBlockFragment())
+		var tooLarge bool
+		mhframe.Fields, tooLarge, err = decodeHeaderBlock(decoder, hframe.HeaderBlockFragment(), c.t.maxResponseHeaderBytes())
 		if err != nil {
 			c.headerErr = qerr.Error(qerr.InvalidHeadersStreamData, "cannot read header fields")
 			break
 		}
-
-		c.mutex.RLock()
-		headerChan, ok := c.responses[protocol.StreamID(hframe.StreamID)]
-		c.mutex.RUnlock()
-		if !ok {
-			c.headerErr = qerr.Error(qerr.InternalError, fmt.Sprintf("h2client BUG: response channel for stream %d not found", lastStream))
+		// we announced the limit in SETTINGS_MAX_HEADER_LIST_SIZE, so the server violated the protocol
+		if tooLarge {
+			c.headerErr = qerr.Error(qerr.InvalidHeadersStreamData, "response header too large")
 			break
 		}
 
+		c.mutex.Lock()
+		if trailerChan, ok := c.trailers[lastStream]; ok {
+			delete(c.trailers, lastStream)
+			c.mutex.Unlock()
+			trailerChan <- trailerFromHeaders(mhframe)
+			continue
+		}
+		headerChan, ok := c.responses[lastStream]
+		if !ok {
+			// this happens if the request was cancelled
+			c.mutex.Unlock()
+			utils.Debugf("Ignoring HEADERS for data stream %d, which is not waiting for a response", lastStream)
+			continue
+		}
 		rsp, err := responseFromHeaders(mhframe)
 		if err != nil {
 			c.headerErr = qerr.Error(qerr.InternalError, err.Error())
+		} else if rsp.Trailer != nil {
+			// the trailers will be sent in a second HEADERS frame
+			// Do sets the data stream as the body
+			trailerChan := make(chan http.Header, 1)
+			c.trailers[lastStream] = trailerChan
+			rsp.Body = &responseBody{trailer: rsp.Trailer, trailerChan: trailerChan}
 		}
+		c.mutex.Unlock()
 		headerChan <- rsp
 	}
 
@@ -131,9 +207,71 @@ func (c *Client) handleHeaderStream() {
 	for _, responseChan := range c.responses {
 		close(responseChan)
 	}
+	for _, trailerChan := range c.trailers {
+		close(trailerChan)
+	}
 	c.mutex.Unlock()
 }
 
+func (c *Client) handlePushPromise(decoder *hpack.Decoder, f *http2.PushPromiseFrame) *qerr.QuicError {
+	pushHandler := c.t.pushHandler()
+	if pushHandler == nil {
+		return qerr.Error(qerr.InvalidHeadersStreamData, "received a PUSH_PROMISE, but push is disabled")
+	}
+	fields, tooLarge, err := decodeHeaderBlock(decoder, f.HeaderBlockFragment(), c.t.maxResponseHeaderBytes())
+	if err != nil {
+		return qerr.Error(qerr.InvalidHeadersStreamData, "cannot read header fields")
+	}
+	if tooLarge {
+		return qerr.Error(qerr.InvalidHeadersStreamData, "promised request header too large")
+	}
+	req, err := requestFromHeaders(fields)
+	if err != nil {
+		return qerr.Error(qerr.InvalidHeadersStreamData, err.Error())
+	}
+	req.URL.Scheme = "https"
+	req.URL.Host = req.Host
+
+	promisedStreamID := protocol.StreamID(f.PromiseID)
+	utils.Debugf("Server promised %s%s on data stream %d", req.Host, req.RequestURI, promisedStreamID)
+	responseChan := make(chan *http.Response)
+	c.mutex.Lock()
+	c.responses[promisedStreamID] = responseChan
+	c.mutex.Unlock()
+	go c.handlePushedResponse(req, promisedStreamID, responseChan, pushHandler)
+	return nil
+}
+
+func (c *Client) handlePushedResponse(req *http.Request, id protocol.StreamID, responseChan chan *http.Response, pushHandler func(*http.Request, *http.Response)) {
+	res := <-responseChan
+	c.mutex.Lock()
+	delete(c.responses, id)
+	c.mutex.Unlock()
+	if res == nil { // an error occured on the header stream
+		return
+	}
+
+	dataStream, err := c.session.(streamCreator).GetOrOpenStream(id)
+	if err != nil || dataStream == nil {
+		utils.Debugf("Error getting the data stream %d for a pushed response: %v", id, err)
+		return
+	}
+	// pushed streams are unidirectional, the server doesn't expect any data
+	_ = dataStream.Close()
+
+	isHead := (req.Method == "HEAD")
+	res = setLength(res, isHead, false)
+	if isHead {
+		res.Body = noBody
+	} else if body, ok := res.Body.(*responseBody); ok { // the response declared trailers
+		body.dataStream = dataStream
+	} else {
+		res.Body = &responseBody{dataStream: dataStream}
+	}
+	res.Request = req
+	pushHandler(req, res)
+}
+
 // Do executes a request and returns a response
 func (c *Client) Do(req *http.Request) (*http.Response, error) {
 	// TODO: add port to address, if it doesn't have one
@@ -148,14 +286,27 @@ func (c *Client) Do(req *http.Request) (*http.Response, error) {
 	hasBody := (req.Body != nil)
 
 	// wait until the handshake is complete
-	<-c.dialChan
+	select {
+	case <-c.dialChan:
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
 	if c.handshakeErr != nil {
 		return nil, c.handshakeErr
 	}
+	c.mutex.Lock()
+	reused := c.used
+	c.used = true
+	c.mutex.Unlock()
+	traceGotConn(req, reused)
 
+	if err := c.acquireRequestSlot(req.Context()); err != nil {
+		return nil, err
+	}
 	responseChan := make(chan *http.Response)
 	dataStream, err := c.session.OpenStreamSync()
 	if err != nil {
+		c.releaseRequestSlot()
 		c.Close(err)
 		return nil, err
 	}
@@ -164,49 +315,111 @@ func (c *Client) Do(req *http.Request) (*http.Response, error) {
 	c.mutex.Unlock()
 
 	var requestedGzip bool
-	if !c.t.disableCompression() && req.Header.Get("Accept-Encoding") == "" && req.Header.Get("Range") == "" && req.Method != "HEAD" {
+	if !c.t.disableCompression() && req.Header.Get("Accept-Encoding") == "" && req.Header.Get("Range") == "" && req.Method != "HEAD" && req.Method != "CONNECT" {
 		requestedGzip = true
 	}
 	// TODO: add support for trailers
 	endStream := !hasBody
 	err = c.requestWriter.WriteRequest(req, dataStream.StreamID(), endStream, requestedGzip)
 	if err != nil {
+		traceWroteRequest(req, err)
+		c.releaseRequestSlot()
 		c.Close(err)
 		return nil, err
 	}
+	traceWroteHeaders(req)
+	if !hasBody {
+		traceWroteRequest(req, nil)
+	}
+
+	// with "Expect: 100-continue", the body is only sent once the server responded with 100 (Continue)
+	// if the server sends the final response instead, the body is not sent at all
+	expectContinue := hasBody && c.t.expectContinueTimeout() > 0 && strings.EqualFold(req.Header.Get("Expect"), "100-continue")
+	var continueChan, abortBodyChan chan struct{}
+	if expectContinue {
+		continueChan = make(chan struct{})
+		abortBodyChan = make(chan struct{})
+		traceWait100Continue(req)
+	}
 
 	resc := make(chan error, 1)
 	if hasBody {
 		go func() {
-			resc <- c.writeRequestBody(dataStream, req.Body)
+			var err error
+			if expectContinue && !c.waitForContinue(continueChan, abortBodyChan) {
+				err = c.abortRequestBody(dataStream, req.Body)
+			} else {
+				err = c.writeRequestBody(dataStream, req.Body)
+			}
+			traceWroteRequest(req, err)
+			resc <- err
 		}()
 	}
 
 	var res *http.Response
 
 	var receivedResponse bool
+	var receivedFirstByte bool
 	var bodySent bool
+	var receivedContinue bool
 
-	if !hasBody {
+	// for CONNECT requests, the body is the data sent through the tunnel
+	// it is sent while the response body is read, so we can't wait for it to be sent completely
+	if !hasBody || req.Method == "CONNECT" {
 		bodySent = true
 	}
 
 	for !(bodySent && receivedResponse) {
 		select {
 		case res = <-responseChan:
+			if res != nil && !receivedFirstByte {
+				receivedFirstByte = true
+				traceGotFirstResponseByte(req)
+			}
+			if res != nil && res.StatusCode >= 100 && res.StatusCode <= 199 {
+				// an interim response, the final response will follow
+				if err := gotInterimResponse(req.Context(), res); err != nil {
+					c.mutex.Lock()
+					delete(c.responses, dataStream.StreamID())
+					c.mutex.Unlock()
+					if expectContinue && !receivedContinue {
+						close(abortBodyChan)
+					}
+					dataStream.Reset(err)
+					c.releaseRequestSlot()
+					return nil, err
+				}
+				if res.StatusCode == 100 && expectContinue && !receivedContinue {
+					receivedContinue = true
+					close(continueChan)
+				}
+				continue
+			}
 			receivedResponse = true
+			if expectContinue && !receivedContinue {
+				close(abortBodyChan)
+			}
 			c.mutex.Lock()
 			delete(c.responses, dataStream.StreamID())
 			c.mutex.Unlock()
 			if res == nil { // an error occured on the header stream
+				c.releaseRequestSlot()
 				c.Close(c.headerErr)
 				return nil, c.headerErr
 			}
 		case err := <-resc:
 			bodySent = true
 			if err != nil {
+				c.releaseRequestSlot()
 				return nil, err
 			}
+		case <-req.Context().Done():
+			c.mutex.Lock()
+			delete(c.responses, dataStream.StreamID())
+			c.mutex.Unlock()
+			dataStream.Reset(req.Context().Err())
+			c.releaseRequestSlot()
+			return nil, req.Context().Err()
 		}
 	}
 
@@ -218,8 +431,15 @@ func (c *Client) Do(req *http.Request) (*http.Response, error) {
 
 	if streamEnded || isHead {
 		res.Body = noBody
+		c.releaseRequestSlot()
 	} else {
-		res.Body = dataStream
+		// the request counts against the server's SETTINGS_MAX_CONCURRENT_STREAMS until the body was read or closed
+		if body, ok := res.Body.(*responseBody); ok { // the response declared trailers
+			body.dataStream = dataStream
+			body.onDone = c.releaseRequestSlot
+		} else {
+			res.Body = &responseBody{dataStream: dataStream, onDone: c.releaseRequestSlot}
+		}
 		if requestedGzip && res.Header.Get("Content-Encoding") == "gzip" {
 			res.Header.Del("Content-Encoding")
 			res.Header.Del("Content-Length")
@@ -234,6 +454,62 @@ func (c *Client) Do(req *http.Request) (*http.Response, error) {
 	return res, nil
 }
 
+// acquireRequestSlot blocks until the number of active requests is below the server's SETTINGS_MAX_CONCURRENT_STREAMS
+func (c *Client) acquireRequestSlot(ctx context.Context) error {
+	for {
+		c.mutex.Lock()
+		maxRequests := c.serverSettings.getMaxConcurrentStreams()
+		if maxRequests == 0 || c.activeRequests < int(maxRequests) {
+			c.activeRequests++
+			c.mutex.Unlock()
+			return nil
+		}
+		slotChan := c.requestSlotChan
+		c.mutex.Unlock()
+
+		select {
+		case <-slotChan:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (c *Client) releaseRequestSlot() {
+	c.mutex.Lock()
+	c.activeRequests--
+	c.notifyRequestSlot()
+	c.mutex.Unlock()
+}
+
+// notifyRequestSlot wakes up all requests waiting in acquireRequestSlot
+// it must be called with the mutex held
+func (c *Client) notifyRequestSlot() {
+	close(c.requestSlotChan)
+	c.requestSlotChan = make(chan struct{})
+}
+
+// waitForContinue returns true if the request body should be sent,
+// either because the server responded with 100 (Continue), or because it didn't respond within the ExpectContinueTimeout.
+func (c *Client) waitForContinue(continueChan, abortBodyChan <-chan struct{}) bool {
+	timer := time.NewTimer(c.t.expectContinueTimeout())
+	defer timer.Stop()
+	select {
+	case <-continueChan:
+		return true
+	case <-timer.C:
+		return true
+	case <-abortBodyChan:
+		return false
+	}
+}
+
+// abortRequestBody closes the request body without sending it, and closes the data stream
+func (c *Client) abortRequestBody(dataStream quic.Stream, body io.ReadCloser) error {
+	_ = body.Close()
+	return dataStream.Close()
+}
+
 func (c *Client) writeRequestBody(dataStream quic.Stream, body io.ReadCloser) (err error) {
 	defer func() {
 		cerr := body.Close()
@@ -251,9 +527,46 @@ func (c *Client) writeRequestBody(dataStream quic.Stream, body io.ReadCloser) (e
 	return dataStream.Close()
 }
 
-// Close closes the client
+// Close closes the client. If the handshake is still running, it is aborted.
 func (c *Client) Close(e error) {
-	_ = c.session.Close(e)
+	c.mutex.Lock()
+	c.closed = true
+	cancelDial := c.cancelDial
+	c.mutex.Unlock()
+	if cancelDial != nil {
+		cancelDial()
+		<-c.dialChan
+	}
+	if c.session != nil {
+		_ = c.session.Close(e)
+	}
+}
+
+func (c *Client) handshakeComplete() bool {
+	select {
+	case <-c.dialChan:
+		return c.handshakeErr == nil
+	default:
+		return false
+	}
+}
+
+// isClosed returns true if the handshake failed or the session was closed
+func (c *Client) isClosed() bool {
+	select {
+	case <-c.dialChan:
+	default:
+		return false
+	}
+	if c.handshakeErr != nil {
+		return true
+	}
+	select {
+	case <-c.session.Context().Done():
+		return true
+	default:
+		return false
+	}
 }
 
 // copied from net/transport.go
@@ -3,9 +3,16 @@ package h2quic
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"crypto/tls"
 	"errors"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"time"
 
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/hpack"
@@ -53,7 +60,7 @@ var _ = Describe("Client", func() {
 	It("dials", func() {
 		client = NewClient(quicTransport, nil, "localhost")
 		session.streamToOpen = &mockStream{id: 3}
-		client.dialAddr = func(hostname string, conf *quic.Config) (quic.Session, error) {
+		client.dialAddr = func(_ context.Context, hostname string, conf *quic.Config) (quic.Session, error) {
 			return session, nil
 		}
 		err := client.Dial()
@@ -61,10 +68,82 @@ var _ = Describe("Client", func() {
 		Expect(client.session).To(Equal(session))
 	})
 
+	It("disables server push if no PushHandler is set", func() {
+		client = NewClient(quicTransport, nil, "localhost")
+		headerStream := &mockStream{id: 3}
+		session.streamToOpen = headerStream
+		client.dialAddr = func(_ context.Context, hostname string, conf *quic.Config) (quic.Session, error) {
+			return session, nil
+		}
+		Expect(client.Dial()).To(Succeed())
+		frame, err := http2.NewFramer(nil, &headerStream.dataWritten).ReadFrame()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(frame).To(BeAssignableToTypeOf(&http2.SettingsFrame{}))
+		val, ok := frame.(*http2.SettingsFrame).Value(http2.SettingEnablePush)
+		Expect(ok).To(BeTrue())
+		Expect(val).To(BeZero())
+	})
+
+	It("enables server push if a PushHandler is set", func() {
+		quicTransport.PushHandler = func(*http.Request, *http.Response) {}
+		client = NewClient(quicTransport, nil, "localhost")
+		headerStream := &mockStream{id: 3}
+		session.streamToOpen = headerStream
+		client.dialAddr = func(_ context.Context, hostname string, conf *quic.Config) (quic.Session, error) {
+			return session, nil
+		}
+		Expect(client.Dial()).To(Succeed())
+		frame, err := http2.NewFramer(nil, &headerStream.dataWritten).ReadFrame()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(frame).To(BeAssignableToTypeOf(&http2.SettingsFrame{}))
+		val, ok := frame.(*http2.SettingsFrame).Value(http2.SettingEnablePush)
+		Expect(ok).To(BeTrue())
+		Expect(val).To(BeEquivalentTo(1))
+	})
+
+	It("sends its SETTINGS", func() {
+		client = NewClient(quicTransport, nil, "localhost")
+		headerStream := &mockStream{id: 3}
+		session.streamToOpen = headerStream
+		client.dialAddr = func(_ context.Context, hostname string, conf *quic.Config) (quic.Session, error) {
+			return session, nil
+		}
+		Expect(client.Dial()).To(Succeed())
+		frame, err := http2.NewFramer(nil, &headerStream.dataWritten).ReadFrame()
+		Expect(err).ToNot(HaveOccurred())
+		settings := frame.(*http2.SettingsFrame)
+		val, ok := settings.Value(http2.SettingHeaderTableSize)
+		Expect(ok).To(BeTrue())
+		Expect(val).To(BeEquivalentTo(headerTableSize))
+		val, ok = settings.Value(http2.SettingInitialWindowSize)
+		Expect(ok).To(BeTrue())
+		Expect(val).To(BeEquivalentTo(protocol.ReceiveStreamFlowControlWindow))
+		_, ok = settings.Value(http2.SettingMaxConcurrentStreams)
+		Expect(ok).To(BeFalse())
+		val, ok = settings.Value(http2.SettingMaxHeaderListSize)
+		Expect(ok).To(BeTrue())
+		Expect(val).To(BeEquivalentTo(defaultMaxResponseHeaderBytes))
+	})
+
+	It("passes the context to the dialer", func() {
+		client = NewClient(quicTransport, nil, "localhost")
+		testErr := errors.New("dial aborted")
+		type ctxKey struct{}
+		ctx, cancel := context.WithCancel(context.WithValue(context.Background(), ctxKey{}, "foobar"))
+		cancel()
+		client.dialAddr = func(c context.Context, hostname string, conf *quic.Config) (quic.Session, error) {
+			Expect(c.Value(ctxKey{})).To(Equal("foobar"))
+			Expect(c.Err()).To(MatchError(context.Canceled))
+			return nil, testErr
+		}
+		err := client.DialContext(ctx)
+		Expect(err).To(MatchError(testErr))
+	})
+
 	It("errors when dialing fails", func() {
 		testErr := errors.New("handshake error")
 		client = NewClient(quicTransport, nil, "localhost")
-		client.dialAddr = func(hostname string, conf *quic.Config) (quic.Session, error) {
+		client.dialAddr = func(_ context.Context, hostname string, conf *quic.Config) (quic.Session, error) {
 			return nil, testErr
 		}
 		err := client.Dial()
@@ -74,7 +153,7 @@ var _ = Describe("Client", func() {
 	It("errors if the header stream has the wrong stream ID", func() {
 		client = NewClient(quicTransport, nil, "localhost")
 		session.streamToOpen = &mockStream{id: 2}
-		client.dialAddr = func(hostname string, conf *quic.Config) (quic.Session, error) {
+		client.dialAddr = func(_ context.Context, hostname string, conf *quic.Config) (quic.Session, error) {
 			return session, nil
 		}
 		err := client.Dial()
@@ -85,16 +164,17 @@ var _ = Describe("Client", func() {
 		testErr := errors.New("you shall not pass")
 		client = NewClient(quicTransport, nil, "localhost")
 		session.streamOpenErr = testErr
-		client.dialAddr = func(hostname string, conf *quic.Config) (quic.Session, error) {
+		client.dialAddr = func(_ context.Context, hostname string, conf *quic.Config) (quic.Session, error) {
 			return session, nil
 		}
 		err := client.Dial()
 		Expect(err).To(MatchError(testErr))
+		Expect(session.closedWithError).To(MatchError(testErr))
 	})
 
 	It("returns a request when dial fails", func() {
 		testErr := errors.New("dial error")
-		client.dialAddr = func(hostname string, conf *quic.Config) (quic.Session, error) {
+		client.dialAddr = func(_ context.Context, hostname string, conf *quic.Config) (quic.Session, error) {
 			return nil, testErr
 		}
 		request, err := http.NewRequest("https", "https://quic.clemente.io:1337/file1.dat", nil)
@@ -109,6 +189,58 @@ var _ = Describe("Client", func() {
 		Eventually(func() error { return doErr }).Should(MatchError(testErr))
 	})
 
+	Context("closing", func() {
+		It("closes the session", func() {
+			client.Close(nil)
+			Expect(session.closed).To(BeTrue())
+		})
+
+		It("aborts the handshake", func(done Done) {
+			client = NewClient(quicTransport, nil, "localhost")
+			client.dialAddr = func(ctx context.Context, _ string, _ *quic.Config) (quic.Session, error) {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}
+			dialErr := make(chan error)
+			go func() {
+				defer GinkgoRecover()
+				dialErr <- client.Dial()
+			}()
+			Consistently(dialErr).ShouldNot(Receive())
+			client.Close(nil)
+			Eventually(dialErr).Should(Receive(MatchError(context.Canceled)))
+			close(done)
+		})
+
+		It("doesn't dial after it was closed", func() {
+			client = NewClient(quicTransport, nil, "localhost")
+			client.dialAddr = func(context.Context, string, *quic.Config) (quic.Session, error) {
+				Fail("should not dial")
+				return nil, nil
+			}
+			client.Close(nil)
+			Expect(client.Dial()).To(MatchError(errClientClosed))
+		})
+
+		It("reports if the session was closed", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			session.ctx = ctx
+			Expect(client.isClosed()).To(BeFalse()) // still dialing
+			close(client.dialChan)
+			Expect(client.handshakeComplete()).To(BeTrue())
+			Expect(client.isClosed()).To(BeFalse())
+			cancel()
+			Expect(client.isClosed()).To(BeTrue())
+		})
+
+		It("reports a failed handshake as closed", func() {
+			client.handshakeErr = errors.New("handshake failed")
+			close(client.dialChan)
+			Expect(client.handshakeComplete()).To(BeFalse())
+			Expect(client.isClosed()).To(BeTrue())
+		})
+	})
+
 	Context("Doing requests", func() {
 		var request *http.Request
 		var dataStream *mockStream
@@ -139,7 +271,7 @@ var _ = Describe("Client", func() {
 			request, err = http.NewRequest("https", "https://quic.clemente.io:1337/file1.dat", nil)
 			Expect(err).ToNot(HaveOccurred())
 
-			dataStream = &mockStream{id: 5}
+			dataStream = newMockStream(5)
 			session.streamToOpen = dataStream
 			close(client.dialChan)
 		})
@@ -163,12 +295,129 @@ var _ = Describe("Client", func() {
 			Eventually(func() bool { return doReturned }).Should(BeTrue())
 			Expect(doErr).ToNot(HaveOccurred())
 			Expect(doRsp).To(Equal(rsp))
-			Expect(doRsp.Body).To(Equal(dataStream))
+			Expect(doRsp.Body).To(BeAssignableToTypeOf(&responseBody{}))
+			Expect(doRsp.Body.(*responseBody).dataStream).To(Equal(dataStream))
 			Expect(doRsp.ContentLength).To(BeEquivalentTo(-1))
 			Expect(doRsp.Request).To(Equal(request))
 			close(done)
 		})
 
+		It("skips interim responses, and calls the Got100Continue hook", func() {
+			var got100Continue bool
+			trace := &httptrace.ClientTrace{Got100Continue: func() { got100Continue = true }}
+			var doRsp *http.Response
+			var doErr error
+			var doReturned bool
+			go func() {
+				doRsp, doErr = client.Do(request.WithContext(httptrace.WithClientTrace(context.Background(), trace)))
+				doReturned = true
+			}()
+
+			Eventually(func() chan *http.Response { return client.responses[5] }).ShouldNot(BeNil())
+			client.responses[5] <- &http.Response{StatusCode: 100, Header: http.Header{}}
+			client.responses[5] <- &http.Response{StatusCode: 103, Header: http.Header{}}
+			Consistently(func() bool { return doReturned }).Should(BeFalse())
+			Expect(got100Continue).To(BeTrue())
+			rsp := &http.Response{StatusCode: 200}
+			client.responses[5] <- rsp
+			Eventually(func() bool { return doReturned }).Should(BeTrue())
+			Expect(doErr).ToNot(HaveOccurred())
+			Expect(doRsp).To(Equal(rsp))
+		})
+
+		Context("tracing", func() {
+			var (
+				events      []string
+				eventsMutex sync.Mutex
+				gotConnInfo []httptrace.GotConnInfo
+			)
+
+			addEvent := func(e string) {
+				eventsMutex.Lock()
+				events = append(events, e)
+				eventsMutex.Unlock()
+			}
+
+			getEvents := func() []string {
+				eventsMutex.Lock()
+				defer eventsMutex.Unlock()
+				return append([]string{}, events...)
+			}
+
+			BeforeEach(func() {
+				events = nil
+				gotConnInfo = nil
+				trace := &httptrace.ClientTrace{
+					GotConn: func(info httptrace.GotConnInfo) {
+						gotConnInfo = append(gotConnInfo, info)
+						addEvent("GotConn")
+					},
+					WroteHeaders: func() { addEvent("WroteHeaders") },
+					WroteRequest: func(info httptrace.WroteRequestInfo) {
+						if info.Err != nil {
+							addEvent("WroteRequest with error: " + info.Err.Error())
+							return
+						}
+						addEvent("WroteRequest")
+					},
+					GotFirstResponseByte: func() { addEvent("GotFirstResponseByte") },
+				}
+				request = request.WithContext(httptrace.WithClientTrace(context.Background(), trace))
+			})
+
+			It("calls the hooks in order", func() {
+				var doErr error
+				var doReturned bool
+				go func() {
+					_, doErr = client.Do(request)
+					doReturned = true
+				}()
+				Eventually(func() chan *http.Response { return client.responses[5] }).ShouldNot(BeNil())
+				Eventually(getEvents).Should(Equal([]string{"GotConn", "WroteHeaders", "WroteRequest"}))
+				client.responses[5] <- &http.Response{StatusCode: 200}
+				Eventually(func() bool { return doReturned }).Should(BeTrue())
+				Expect(doErr).ToNot(HaveOccurred())
+				Expect(getEvents()).To(Equal([]string{"GotConn", "WroteHeaders", "WroteRequest", "GotFirstResponseByte"}))
+			})
+
+			It("calls WroteRequest once the request body was sent", func() {
+				body := &mockBody{}
+				body.SetData([]byte("request body"))
+				request.Body = body
+				var doReturned bool
+				go func() {
+					client.Do(request)
+					doReturned = true
+				}()
+				Eventually(func() bool { return dataStream.closed }).Should(BeTrue())
+				Eventually(getEvents).Should(Equal([]string{"GotConn", "WroteHeaders", "WroteRequest"}))
+				client.responses[5] <- &http.Response{StatusCode: 200}
+				Eventually(func() bool { return doReturned }).Should(BeTrue())
+				Expect(getEvents()).To(HaveLen(4))
+				Expect(getEvents()[3]).To(Equal("GotFirstResponseByte"))
+			})
+
+			It("reports if the session is reused", func() {
+				for i := 0; i < 2; i++ {
+					var doReturned bool
+					go func() {
+						client.Do(request)
+						doReturned = true
+					}()
+					Eventually(func() chan *http.Response {
+						client.mutex.RLock()
+						defer client.mutex.RUnlock()
+						return client.responses[5]
+					}).ShouldNot(BeNil())
+					client.responses[5] <- &http.Response{StatusCode: 200}
+					Eventually(func() bool { return doReturned }).Should(BeTrue())
+				}
+				Expect(gotConnInfo).To(HaveLen(2))
+				Expect(gotConnInfo[0].Reused).To(BeFalse())
+				Expect(gotConnInfo[1].Reused).To(BeTrue())
+			})
+		})
+
 		It("closes the quic client when encountering an error on the header stream", func() {
 			var doRsp *http.Response
 			var doErr error
@@ -209,6 +458,105 @@ var _ = Describe("Client", func() {
 			Consistently(func() bool { return doReturned }).Should(BeFalse())
 		})
 
+		It("resets the stream when the request context is cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			errChan := make(chan error)
+			go func() {
+				_, err := client.Do(request.WithContext(ctx))
+				errChan <- err
+			}()
+
+			Eventually(func() []byte { return headerStream.dataWritten.Bytes() }).ShouldNot(BeEmpty())
+			cancel()
+			Eventually(errChan).Should(Receive(MatchError(context.Canceled)))
+			Expect(dataStream.reset).To(BeTrue())
+			client.mutex.RLock()
+			defer client.mutex.RUnlock()
+			Expect(client.responses).ToNot(HaveKey(protocol.StreamID(5)))
+		})
+
+		It("waits for the trailers at the end of the body, if the response declared trailers", func(done Done) {
+			var doRsp *http.Response
+			var doErr error
+			var doReturned bool
+			go func() {
+				doRsp, doErr = client.Do(request)
+				doReturned = true
+			}()
+
+			Eventually(func() []byte { return headerStream.dataWritten.Bytes() }).ShouldNot(BeEmpty())
+			trailer := http.Header{"Foo": nil}
+			trailerChan := make(chan http.Header, 1)
+			client.responses[5] <- &http.Response{
+				Trailer: trailer,
+				Body:    &responseBody{trailer: trailer, trailerChan: trailerChan},
+			}
+			Eventually(func() bool { return doReturned }).Should(BeTrue())
+			Expect(doErr).ToNot(HaveOccurred())
+			Expect(doRsp.Body.(*responseBody).dataStream).To(Equal(dataStream))
+			trailerChan <- http.Header{"Foo": []string{"bar"}}
+			_, err := ioutil.ReadAll(doRsp.Body)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(doRsp.Trailer).To(Equal(http.Header{"Foo": []string{"bar"}}))
+			close(done)
+		})
+
+		It("limits the number of parallel requests to the server's SETTINGS_MAX_CONCURRENT_STREAMS", func() {
+			client.serverSettings.maxConcurrentStreams = 1
+			rspChan := make(chan *http.Response)
+			go func() {
+				defer GinkgoRecover()
+				rsp, err := client.Do(request)
+				Expect(err).ToNot(HaveOccurred())
+				rspChan <- rsp
+			}()
+			Eventually(func() chan *http.Response {
+				client.mutex.RLock()
+				defer client.mutex.RUnlock()
+				return client.responses[5]
+			}).ShouldNot(BeNil())
+			client.responses[5] <- &http.Response{}
+			var rsp1 *http.Response
+			Eventually(rspChan).Should(Receive(&rsp1))
+
+			session.streamToOpen = newMockStream(7)
+			go func() {
+				defer GinkgoRecover()
+				rsp, err := client.Do(request)
+				Expect(err).ToNot(HaveOccurred())
+				rspChan <- rsp
+			}()
+			Consistently(func() int {
+				client.mutex.RLock()
+				defer client.mutex.RUnlock()
+				return len(client.responses)
+			}).Should(BeZero())
+			// closing the body of the first response allows the second request to be sent
+			Expect(rsp1.Body.Close()).To(Succeed())
+			Eventually(func() chan *http.Response {
+				client.mutex.RLock()
+				defer client.mutex.RUnlock()
+				return client.responses[7]
+			}).ShouldNot(BeNil())
+			client.responses[7] <- &http.Response{}
+			Eventually(rspChan).Should(Receive())
+		})
+
+		It("stops waiting for a request slot when the request context is cancelled", func() {
+			client.serverSettings.maxConcurrentStreams = 1
+			client.activeRequests = 1
+			ctx, cancel := context.WithCancel(context.Background())
+			errChan := make(chan error)
+			go func() {
+				_, err := client.Do(request.WithContext(ctx))
+				errChan <- err
+			}()
+			Consistently(errChan).ShouldNot(Receive())
+			cancel()
+			Eventually(errChan).Should(Receive(MatchError(context.Canceled)))
+			Expect(headerStream.dataWritten.Len()).To(BeZero())
+		})
+
 		Context("validating the address", func() {
 			It("refuses to do requests for the wrong host", func() {
 				req, err := http.NewRequest("https", "https://quic.clemente.io:1336/foobar.html", nil)
@@ -325,6 +673,98 @@ var _ = Describe("Client", func() {
 				Expect(doRsp).To(BeNil())
 				Expect(request.Body.(*mockBody).closed).To(BeTrue())
 			})
+
+			Context("Expect: 100-continue", func() {
+				BeforeEach(func() {
+					quicTransport.ExpectContinueTimeout = time.Hour
+					request.Header.Set("Expect", "100-continue")
+				})
+
+				It("sends the body after receiving a 100 (Continue)", func() {
+					var doRsp *http.Response
+					var doErr error
+					var doReturned bool
+					go func() {
+						doRsp, doErr = client.Do(request)
+						doReturned = true
+					}()
+					Eventually(func() chan *http.Response { return client.responses[5] }).ShouldNot(BeNil())
+					Consistently(func() bool { return request.Body.(*mockBody).closed }).Should(BeFalse())
+					Expect(dataStream.dataWritten.Len()).To(BeZero())
+					client.responses[5] <- &http.Response{StatusCode: 100}
+					Eventually(func() bool { return dataStream.closed }).Should(BeTrue())
+					Expect(dataStream.dataWritten.Bytes()).To(Equal(requestBody))
+					Expect(doReturned).To(BeFalse())
+					client.responses[5] <- response
+					Eventually(func() bool { return doReturned }).Should(BeTrue())
+					Expect(doErr).ToNot(HaveOccurred())
+					Expect(doRsp).To(Equal(response))
+				})
+
+				It("doesn't send the body if the server rejects the request", func() {
+					var doRsp *http.Response
+					var doErr error
+					var doReturned bool
+					go func() {
+						doRsp, doErr = client.Do(request)
+						doReturned = true
+					}()
+					Eventually(func() chan *http.Response { return client.responses[5] }).ShouldNot(BeNil())
+					rsp := &http.Response{StatusCode: http.StatusExpectationFailed}
+					client.responses[5] <- rsp
+					Eventually(func() bool { return doReturned }).Should(BeTrue())
+					Expect(doErr).ToNot(HaveOccurred())
+					Expect(doRsp).To(Equal(rsp))
+					Expect(request.Body.(*mockBody).closed).To(BeTrue())
+					Expect(dataStream.closed).To(BeTrue())
+					Expect(dataStream.dataWritten.Len()).To(BeZero())
+				})
+
+				It("sends the body when the ExpectContinueTimeout expires", func() {
+					quicTransport.ExpectContinueTimeout = 50 * time.Millisecond
+					go client.Do(request)
+					Eventually(func() chan *http.Response { return client.responses[5] }).ShouldNot(BeNil())
+					Eventually(func() bool { return dataStream.closed }).Should(BeTrue())
+					Expect(dataStream.dataWritten.Bytes()).To(Equal(requestBody))
+				})
+
+				It("sends the body immediately if no ExpectContinueTimeout is set", func() {
+					quicTransport.ExpectContinueTimeout = 0
+					go client.Do(request)
+					Eventually(func() bool { return dataStream.closed }).Should(BeTrue())
+					Expect(dataStream.dataWritten.Bytes()).To(Equal(requestBody))
+				})
+			})
+
+			It("returns the response of a CONNECT request before the body was sent", func() {
+				pr, pw := io.Pipe()
+				request, err := http.NewRequest("CONNECT", "https://quic.clemente.io:1337", pr)
+				Expect(err).ToNot(HaveOccurred())
+				request.Host = "www.example.com:443"
+				var doRsp *http.Response
+				var doErr error
+				var doReturned bool
+				go func() {
+					doRsp, doErr = client.Do(request)
+					doReturned = true
+				}()
+				Eventually(func() chan *http.Response { return client.responses[5] }).ShouldNot(BeNil())
+				fields := getHeaderFields(getRequest(headerStream.dataWritten.Bytes()))
+				Expect(fields).To(HaveKeyWithValue(":method", "CONNECT"))
+				Expect(fields).To(HaveKeyWithValue(":authority", "www.example.com:443"))
+				Expect(fields).ToNot(HaveKey(":path"))
+				Expect(fields).ToNot(HaveKey("accept-encoding"))
+				client.responses[5] <- response
+				Eventually(func() bool { return doReturned }).Should(BeTrue())
+				Expect(doErr).ToNot(HaveOccurred())
+				Expect(doRsp).To(Equal(response))
+				// the body is sent through the tunnel
+				_, err = pw.Write([]byte("foobar"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(pw.Close()).To(Succeed())
+				Eventually(func() bool { return dataStream.closed }).Should(BeTrue())
+				Expect(dataStream.dataWritten.Bytes()).To(Equal([]byte("foobar")))
+			})
 		})
 
 		Context("gzip compression", func() {
@@ -439,6 +879,164 @@ var _ = Describe("Client", func() {
 				Expect(rsp.Header).To(HaveKeyWithValue("Cache-Control", []string{"private"}))
 			})
 
+			It("applies the server's SETTINGS", func() {
+				h2framer.WriteSettings(http2.Setting{ID: http2.SettingMaxConcurrentStreams, Val: 10})
+				go client.handleHeaderStream()
+				Eventually(func() uint32 { return client.serverSettings.getMaxConcurrentStreams() }).Should(BeEquivalentTo(10))
+			})
+
+			It("errors on invalid SETTINGS", func() {
+				h2framer.WriteSettings(http2.Setting{ID: http2.SettingEnablePush, Val: 2})
+				go client.handleHeaderStream()
+				Eventually(client.responses[23]).Should(BeClosed())
+				Expect(client.headerErr.ErrorCode).To(Equal(qerr.InvalidHeadersStreamData))
+			})
+
+			It("errors if the response header is larger than MaxResponseHeaderBytes", func() {
+				quicTransport.MaxResponseHeaderBytes = 1000
+				var headers bytes.Buffer
+				enc := hpack.NewEncoder(&headers)
+				enc.WriteField(hpack.HeaderField{Name: ":status", Value: "200"})
+				for i := 0; i < 10; i++ {
+					enc.WriteField(hpack.HeaderField{Name: "foo", Value: strings.Repeat("a", i+200)})
+				}
+				h2framer.WriteHeaders(http2.HeadersFrameParam{
+					StreamID:      23,
+					EndHeaders:    true,
+					BlockFragment: headers.Bytes(),
+				})
+				go client.handleHeaderStream()
+				Eventually(client.responses[23]).Should(BeClosed())
+				Expect(client.headerErr).To(MatchError(qerr.Error(qerr.InvalidHeadersStreamData, "response header too large")))
+			})
+
+			It("ignores HEADERS for streams that are not waiting for a response", func() {
+				var headers bytes.Buffer
+				enc := hpack.NewEncoder(&headers)
+				enc.WriteField(hpack.HeaderField{Name: ":status", Value: "200"})
+				for _, id := range []uint32{25, 23} {
+					h2framer.WriteHeaders(http2.HeadersFrameParam{
+						StreamID:      id,
+						EndHeaders:    true,
+						BlockFragment: headers.Bytes(),
+					})
+				}
+				go client.handleHeaderStream()
+				var rsp *http.Response
+				Eventually(client.responses[23]).Should(Receive(&rsp))
+				Expect(rsp.StatusCode).To(Equal(200))
+				Expect(client.headerErr).To(BeNil())
+			})
+
+			Context("trailers", func() {
+				writeHeaders := func(endStream bool, fields ...hpack.HeaderField) {
+					var headers bytes.Buffer
+					enc := hpack.NewEncoder(&headers)
+					for _, f := range fields {
+						enc.WriteField(f)
+					}
+					h2framer.WriteHeaders(http2.HeadersFrameParam{
+						StreamID:      23,
+						EndHeaders:    true,
+						EndStream:     endStream,
+						BlockFragment: headers.Bytes(),
+					})
+				}
+
+				It("passes the trailers to the response body", func() {
+					writeHeaders(false,
+						hpack.HeaderField{Name: ":status", Value: "200"},
+						hpack.HeaderField{Name: "trailer", Value: "foo"},
+					)
+					writeHeaders(true, hpack.HeaderField{Name: "foo", Value: "bar"})
+					go client.handleHeaderStream()
+					var rsp *http.Response
+					Eventually(client.responses[23]).Should(Receive(&rsp))
+					Expect(rsp.Trailer).To(HaveKey("Foo"))
+					Expect(rsp.Body).To(BeAssignableToTypeOf(&responseBody{}))
+					body := rsp.Body.(*responseBody)
+					Expect(body.trailer).To(Equal(rsp.Trailer))
+					Eventually(body.trailerChan).Should(Receive(Equal(http.Header{"Foo": []string{"bar"}})))
+					client.mutex.RLock()
+					defer client.mutex.RUnlock()
+					Expect(client.trailers).To(BeEmpty())
+				})
+
+				It("doesn't wait for trailers if the response didn't declare any", func() {
+					writeHeaders(false, hpack.HeaderField{Name: ":status", Value: "200"})
+					go client.handleHeaderStream()
+					Eventually(client.responses[23]).Should(Receive())
+					client.mutex.RLock()
+					defer client.mutex.RUnlock()
+					Expect(client.trailers).To(BeEmpty())
+				})
+
+				It("closes the trailer channels when an error occurs on the header stream", func() {
+					trailerChan := make(chan http.Header, 1)
+					client.trailers[42] = trailerChan
+					h2framer.WritePing(true, [8]byte{0, 0, 0, 0, 0, 0, 0, 0})
+					go client.handleHeaderStream()
+					Eventually(trailerChan).Should(BeClosed())
+				})
+			})
+
+			Context("server push", func() {
+				writePushPromise := func() {
+					var headers bytes.Buffer
+					enc := hpack.NewEncoder(&headers)
+					enc.WriteField(hpack.HeaderField{Name: ":method", Value: "GET"})
+					enc.WriteField(hpack.HeaderField{Name: ":scheme", Value: "https"})
+					enc.WriteField(hpack.HeaderField{Name: ":authority", Value: "quic.clemente.io"})
+					enc.WriteField(hpack.HeaderField{Name: ":path", Value: "/style.css"})
+					h2framer.WritePushPromise(http2.PushPromiseParam{
+						StreamID:      23,
+						PromiseID:     2,
+						EndHeaders:    true,
+						BlockFragment: headers.Bytes(),
+					})
+				}
+
+				It("passes pushed responses to the PushHandler", func() {
+					type pushed struct {
+						req *http.Request
+						res *http.Response
+					}
+					pushChan := make(chan pushed, 1)
+					quicTransport.PushHandler = func(req *http.Request, res *http.Response) {
+						pushChan <- pushed{req: req, res: res}
+					}
+					pushStream := &mockStream{id: 2}
+					session.dataStream = pushStream
+					writePushPromise()
+					var headers bytes.Buffer
+					hpack.NewEncoder(&headers).WriteField(hpack.HeaderField{Name: ":status", Value: "200"})
+					h2framer.WriteHeaders(http2.HeadersFrameParam{
+						StreamID:      2,
+						EndHeaders:    true,
+						BlockFragment: headers.Bytes(),
+					})
+					go client.handleHeaderStream()
+					var p pushed
+					Eventually(pushChan).Should(Receive(&p))
+					Expect(p.req.Method).To(Equal("GET"))
+					Expect(p.req.URL.String()).To(Equal("https://quic.clemente.io/style.css"))
+					Expect(p.res.StatusCode).To(Equal(200))
+					Expect(p.res.Request).To(Equal(p.req))
+					Expect(p.res.Body).To(Equal(&responseBody{dataStream: pushStream}))
+					Expect(pushStream.closed).To(BeTrue())
+					client.mutex.RLock()
+					defer client.mutex.RUnlock()
+					Expect(client.responses).ToNot(HaveKey(protocol.StreamID(2)))
+				})
+
+				It("errors if push is disabled", func() {
+					writePushPromise()
+					go client.handleHeaderStream()
+					Eventually(client.responses[23]).Should(BeClosed())
+					Expect(client.headerErr).To(MatchError(qerr.Error(qerr.InvalidHeadersStreamData, "received a PUSH_PROMISE, but push is disabled")))
+				})
+			})
+
 			It("errors if the H2 frame is not a HeadersFrame", func() {
 				h2framer.WritePing(true, [8]byte{0, 0, 0, 0, 0, 0, 0, 0})
 
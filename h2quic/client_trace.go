@@ -0,0 +1,53 @@
+package h2quic
+
+import (
+	"net/http"
+	"net/http/httptrace"
+)
+
+// The httptrace hooks are called with QUIC sessions taking the place of connections:
+// GetConn is called before a session is looked up (or dialed), and GotConn once the handshake of that session completed.
+// A session is reused if it was previously used for another request.
+// Since a QUIC session is not a net.Conn, GotConnInfo.Conn is always nil.
+
+func traceGetConn(req *http.Request, hostPort string) {
+	trace := httptrace.ContextClientTrace(req.Context())
+	if trace != nil && trace.GetConn != nil {
+		trace.GetConn(hostPort)
+	}
+}
+
+func traceGotConn(req *http.Request, reused bool) {
+	trace := httptrace.ContextClientTrace(req.Context())
+	if trace != nil && trace.GotConn != nil {
+		trace.GotConn(httptrace.GotConnInfo{Reused: reused})
+	}
+}
+
+func traceWroteHeaders(req *http.Request) {
+	trace := httptrace.ContextClientTrace(req.Context())
+	if trace != nil && trace.WroteHeaders != nil {
+		trace.WroteHeaders()
+	}
+}
+
+func traceWait100Continue(req *http.Request) {
+	trace := httptrace.ContextClientTrace(req.Context())
+	if trace != nil && trace.Wait100Continue != nil {
+		trace.Wait100Continue()
+	}
+}
+
+func traceWroteRequest(req *http.Request, err error) {
+	trace := httptrace.ContextClientTrace(req.Context())
+	if trace != nil && trace.WroteRequest != nil {
+		trace.WroteRequest(httptrace.WroteRequestInfo{Err: err})
+	}
+}
+
+func traceGotFirstResponseByte(req *http.Request) {
+	trace := httptrace.ContextClientTrace(req.Context())
+	if trace != nil && trace.GotFirstResponseByte != nil {
+		trace.GotFirstResponseByte()
+	}
+}
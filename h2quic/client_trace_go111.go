@@ -0,0 +1,26 @@
+// +build go1.11
+
+package h2quic
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptrace"
+	"net/textproto"
+)
+
+// gotInterimResponse calls the Got100Continue and Got1xxResponse hooks of the request's httptrace.ClientTrace.
+// If Got1xxResponse returns an error, the request is aborted.
+func gotInterimResponse(ctx context.Context, res *http.Response) error {
+	trace := httptrace.ContextClientTrace(ctx)
+	if trace == nil {
+		return nil
+	}
+	if res.StatusCode == 100 && trace.Got100Continue != nil {
+		trace.Got100Continue()
+	}
+	if trace.Got1xxResponse != nil {
+		return trace.Got1xxResponse(res.StatusCode, textproto.MIMEHeader(res.Header))
+	}
+	return nil
+}
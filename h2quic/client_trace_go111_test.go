@@ -0,0 +1,65 @@
+// +build go1.11
+
+package h2quic
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptrace"
+	"net/textproto"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Client trace", func() {
+	It("calls the Got1xxResponse hook for interim responses", func() {
+		var statusCodes []int
+		var headers []textproto.MIMEHeader
+		trace := &httptrace.ClientTrace{
+			Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+				statusCodes = append(statusCodes, code)
+				headers = append(headers, header)
+				return nil
+			},
+		}
+		ctx := httptrace.WithClientTrace(context.Background(), trace)
+		rsp := &http.Response{
+			StatusCode: 103,
+			Header:     http.Header{"Link": {"</style.css>; rel=preload; as=style"}},
+		}
+		Expect(gotInterimResponse(ctx, rsp)).To(Succeed())
+		Expect(statusCodes).To(Equal([]int{103}))
+		Expect(headers[0].Get("Link")).To(Equal("</style.css>; rel=preload; as=style"))
+	})
+
+	It("calls both hooks for a 100 (Continue)", func() {
+		var got100Continue bool
+		var statusCodes []int
+		trace := &httptrace.ClientTrace{
+			Got100Continue: func() { got100Continue = true },
+			Got1xxResponse: func(code int, _ textproto.MIMEHeader) error {
+				statusCodes = append(statusCodes, code)
+				return nil
+			},
+		}
+		ctx := httptrace.WithClientTrace(context.Background(), trace)
+		Expect(gotInterimResponse(ctx, &http.Response{StatusCode: 100, Header: http.Header{}})).To(Succeed())
+		Expect(got100Continue).To(BeTrue())
+		Expect(statusCodes).To(Equal([]int{100}))
+	})
+
+	It("returns the error of the Got1xxResponse hook", func() {
+		testErr := errors.New("too many informational responses")
+		trace := &httptrace.ClientTrace{
+			Got1xxResponse: func(int, textproto.MIMEHeader) error { return testErr },
+		}
+		ctx := httptrace.WithClientTrace(context.Background(), trace)
+		Expect(gotInterimResponse(ctx, &http.Response{StatusCode: 103, Header: http.Header{}})).To(MatchError(testErr))
+	})
+
+	It("doesn't do anything if the request has no trace", func() {
+		Expect(gotInterimResponse(context.Background(), &http.Response{StatusCode: 103})).To(Succeed())
+	})
+})
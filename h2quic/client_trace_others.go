@@ -0,0 +1,19 @@
+// +build !go1.11
+
+package h2quic
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptrace"
+)
+
+// gotInterimResponse calls the Got100Continue hook of the request's httptrace.ClientTrace.
+// The Got1xxResponse hook is only available since Go 1.11.
+func gotInterimResponse(ctx context.Context, res *http.Response) error {
+	trace := httptrace.ContextClientTrace(ctx)
+	if trace != nil && res.StatusCode == 100 && trace.Got100Continue != nil {
+		trace.Got100Continue()
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package h2quic
+
+import "golang.org/x/net/http2/hpack"
+
+// decodeHeaderBlock decodes a header block, and reports if the header list is larger than maxSize.
+// The size of a header list is calculated as defined for SETTINGS_MAX_HEADER_LIST_SIZE in RFC 7540, section 6.5.2.
+// If the header list is too large, no header fields are returned.
+// However, the header block is still decoded completely, such that the dynamic table stays in sync with the peer's encoder.
+func decodeHeaderBlock(decoder *hpack.Decoder, block []byte, maxSize uint32) ([]hpack.HeaderField, bool, error) {
+	var fields []hpack.HeaderField
+	var size uint32
+	var tooLarge bool
+	decoder.SetEmitEnabled(true)
+	// prevent a single header field from allocating a huge string
+	decoder.SetMaxStringLength(int(maxSize))
+	decoder.SetEmitFunc(func(hf hpack.HeaderField) {
+		size += hf.Size()
+		if size > maxSize {
+			tooLarge = true
+			fields = nil
+			decoder.SetEmitEnabled(false)
+			return
+		}
+		fields = append(fields, hf)
+	})
+	defer decoder.SetEmitFunc(func(hpack.HeaderField) {})
+
+	if _, err := decoder.Write(block); err != nil {
+		return nil, false, err
+	}
+	if err := decoder.Close(); err != nil {
+		return nil, false, err
+	}
+	return fields, tooLarge, nil
+}
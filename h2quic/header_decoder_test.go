@@ -0,0 +1,92 @@
+package h2quic
+
+import (
+	"bytes"
+	"strings"
+
+	"golang.org/x/net/http2/hpack"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Header block decoding", func() {
+	var (
+		decoder *hpack.Decoder
+		encoder *hpack.Encoder
+		headers *bytes.Buffer
+	)
+
+	BeforeEach(func() {
+		decoder = hpack.NewDecoder(4096, nil)
+		headers = &bytes.Buffer{}
+		encoder = hpack.NewEncoder(headers)
+	})
+
+	encode := func(fields ...hpack.HeaderField) []byte {
+		headers.Reset()
+		for _, f := range fields {
+			Expect(encoder.WriteField(f)).To(Succeed())
+		}
+		return headers.Bytes()
+	}
+
+	It("decodes a header block", func() {
+		fields := []hpack.HeaderField{
+			{Name: ":status", Value: "200"},
+			{Name: "foo", Value: "bar"},
+		}
+		decoded, tooLarge, err := decodeHeaderBlock(decoder, encode(fields...), 1000)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tooLarge).To(BeFalse())
+		Expect(decoded).To(Equal(fields))
+	})
+
+	It("reports if the header list is too large", func() {
+		// the size of a header field is the length of name and value, plus 32 bytes
+		block := encode(
+			hpack.HeaderField{Name: "foo", Value: strings.Repeat("a", 100)},
+			hpack.HeaderField{Name: "bar", Value: strings.Repeat("b", 100)},
+		)
+		decoded, tooLarge, err := decodeHeaderBlock(decoder, block, 2*(3+100+32)-1)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tooLarge).To(BeTrue())
+		Expect(decoded).To(BeEmpty())
+	})
+
+	It("accepts a header list that is exactly as large as the limit", func() {
+		block := encode(
+			hpack.HeaderField{Name: "foo", Value: strings.Repeat("a", 100)},
+			hpack.HeaderField{Name: "bar", Value: strings.Repeat("b", 100)},
+		)
+		decoded, tooLarge, err := decodeHeaderBlock(decoder, block, 2*(3+100+32))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tooLarge).To(BeFalse())
+		Expect(decoded).To(HaveLen(2))
+	})
+
+	It("errors if a single header field is longer than the limit", func() {
+		_, _, err := decodeHeaderBlock(decoder, encode(hpack.HeaderField{Name: "foo", Value: strings.Repeat("a", 500)}), 200)
+		Expect(err).To(MatchError(hpack.ErrStringLength))
+	})
+
+	It("decodes the whole block if the header list is too large", func() {
+		_, tooLarge, err := decodeHeaderBlock(decoder, encode(
+			hpack.HeaderField{Name: "foo", Value: strings.Repeat("a", 100)},
+			hpack.HeaderField{Name: "bar", Value: strings.Repeat("b", 100)},
+		), 200)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tooLarge).To(BeTrue())
+		// the next header block references both entries in the dynamic table
+		decoded, tooLarge, err := decodeHeaderBlock(decoder, encode(
+			hpack.HeaderField{Name: "foo", Value: strings.Repeat("a", 100)},
+			hpack.HeaderField{Name: "bar", Value: strings.Repeat("b", 100)},
+		), 1000)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tooLarge).To(BeFalse())
+		Expect(decoded).To(Equal([]hpack.HeaderField{
+			{Name: "foo", Value: strings.Repeat("a", 100)},
+			{Name: "bar", Value: strings.Repeat("b", 100)},
+		}))
+	})
+})
@@ -37,17 +37,30 @@ func requestFromHeaders(headers []hpack.HeaderField) (*http.Request, error) {
 		httpHeaders.Set("Cookie", strings.Join(httpHeaders["Cookie"], "; "))
 	}
 
-	if len(path) == 0 || len(authority) == 0 || len(method) == 0 {
-		return nil, errors.New(":path, :authority and :method must not be empty")
-	}
-
-	u, err := url.Parse(path)
-	if err != nil {
-		return nil, err
+	var u *url.URL
+	var requestURI string
+	if method == "CONNECT" {
+		// CONNECT requests only contain the :authority of the tunnel's target, see RFC 7540, section 8.3
+		if len(path) != 0 || len(authority) == 0 {
+			return nil, errors.New(":authority must be set and :path must be omitted for CONNECT requests")
+		}
+		u = &url.URL{Host: authority}
+		requestURI = authority
+	} else {
+		if len(path) == 0 || len(authority) == 0 || len(method) == 0 {
+			return nil, errors.New(":path, :authority and :method must not be empty")
+		}
+		var err error
+		u, err = url.Parse(path)
+		if err != nil {
+			return nil, err
+		}
+		requestURI = path
 	}
 
 	var contentLength int64
 	if len(contentLengthStr) > 0 {
+		var err error
 		contentLength, err = strconv.ParseInt(contentLengthStr, 10, 64)
 		if err != nil {
 			return nil, err
@@ -64,12 +77,17 @@ func requestFromHeaders(headers []hpack.HeaderField) (*http.Request, error) {
 		Body:          nil,
 		ContentLength: contentLength,
 		Host:          authority,
-		RequestURI:    path,
+		RequestURI:    requestURI,
 		TLS:           &tls.ConnectionState{},
 	}, nil
 }
 
+// hostnameFromRequest returns the host that the request is sent to.
+// For CONNECT requests, this is the proxy given in the URL, and the Host is the target of the tunnel.
 func hostnameFromRequest(req *http.Request) string {
+	if req.Method == "CONNECT" && req.URL != nil && len(req.URL.Host) > 0 {
+		return req.URL.Host
+	}
 	if len(req.Host) > 0 {
 		return req.Host
 	}
@@ -6,9 +6,15 @@ import (
 	quic "github.com/lucas-clemente/quic-go"
 )
 
+// requestBody is the body of a request received by the server.
+// It reads directly from the data stream, without buffering.
+// The stream only grants the client additional flow control credit once data was read,
+// so a handler that reads the body slowly makes the client block, instead of the server buffering the whole body.
 type requestBody struct {
 	requestRead bool
 	dataStream  quic.Stream
+
+	sendContinue func() // if the client sent "Expect: 100-continue", this is called on the first Read
 }
 
 // make sure the requestBody can be used as a http.Request.Body
@@ -19,6 +25,9 @@ func newRequestBody(stream quic.Stream) *requestBody {
 }
 
 func (b *requestBody) Read(p []byte) (int, error) {
+	if !b.requestRead && b.sendContinue != nil {
+		b.sendContinue()
+	}
 	b.requestRead = true
 	return b.dataStream.Read(p)
 }
@@ -24,12 +24,30 @@ var _ = Describe("Request body", func() {
 		Expect(b[0:6]).To(Equal([]byte("foobar")))
 	})
 
+	It("only reads as much from the stream as requested", func() {
+		b := make([]byte, 4)
+		n, err := rb.Read(b)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(n).To(Equal(4))
+		Expect(b).To(Equal([]byte("foob")))
+		Expect(stream.dataToRead.Len()).To(Equal(2))
+	})
+
 	It("saves if the stream was read from", func() {
 		Expect(rb.requestRead).To(BeFalse())
 		rb.Read(make([]byte, 1))
 		Expect(rb.requestRead).To(BeTrue())
 	})
 
+	It("sends the 100 (Continue) on the first read", func() {
+		var continueSent int
+		rb.sendContinue = func() { continueSent++ }
+		rb.Read(make([]byte, 1))
+		Expect(continueSent).To(Equal(1))
+		rb.Read(make([]byte, 1))
+		Expect(continueSent).To(Equal(1))
+	})
+
 	It("doesn't close the stream when closing the request body", func() {
 		Expect(stream.closed).To(BeFalse())
 		err := rb.Close()
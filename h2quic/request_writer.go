@@ -35,9 +35,16 @@ func newRequestWriter(headerStream quic.Stream) *requestWriter {
 	return rw
 }
 
+// setMaxHeaderTableSize limits the HPACK dynamic table to the server's SETTINGS_HEADER_TABLE_SIZE.
+// If the table shrinks, the next header block starts with a dynamic table size update.
+func (w *requestWriter) setMaxHeaderTableSize(size uint32) {
+	w.mutex.Lock()
+	w.henc.SetMaxDynamicTableSizeLimit(size)
+	w.mutex.Unlock()
+}
+
 func (w *requestWriter) WriteRequest(req *http.Request, dataStreamID protocol.StreamID, endStream, requestGzip bool) error {
 	// TODO: add support for trailers
-	// TODO: add support for gzip compression
 	// TODO: write continuation frames, if the header frame is too long
 
 	w.mutex.Lock()
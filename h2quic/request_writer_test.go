@@ -10,6 +10,8 @@ import (
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/hpack"
 
+	"github.com/lucas-clemente/quic-go/protocol"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -110,4 +112,57 @@ var _ = Describe("Request", func() {
 		_, headerFields := decode(headerStream.dataWritten.Bytes())
 		Expect(headerFields).To(HaveKeyWithValue("cookie", "Cookie #1=Value #1; Cookie #2=Value #2"))
 	})
+
+	Context("dynamic table size", func() {
+		// the decoder of a server that announced a SETTINGS_HEADER_TABLE_SIZE of 100
+		BeforeEach(func() {
+			decoder = hpack.NewDecoder(100, func(hf hpack.HeaderField) {})
+		})
+
+		It("starts the next header block with a dynamic table size update", func() {
+			rw.setMaxHeaderTableSize(100)
+			req, err := http.NewRequest("GET", "https://quic.clemente.io/", nil)
+			Expect(err).ToNot(HaveOccurred())
+			rw.WriteRequest(req, 5, true, false)
+			frame, err := http2.NewFramer(nil, &headerStream.dataWritten).ReadFrame()
+			Expect(err).ToNot(HaveOccurred())
+			// a dynamic table size update starts with the bit pattern 001
+			Expect(frame.(*http2.HeadersFrame).HeaderBlockFragment()[0] & 0xe0).To(BeEquivalentTo(0x20))
+		})
+
+		It("round-trips several requests", func() {
+			rw.setMaxHeaderTableSize(100)
+			for i := 0; i < 10; i++ {
+				req, err := http.NewRequest("GET", "https://quic.clemente.io/file"+strconv.Itoa(i%3)+".html", nil)
+				Expect(err).ToNot(HaveOccurred())
+				req.Header.Set("X-Request-Number", strconv.Itoa(i))
+				req.Header.Set("X-Long-Header", strings.Repeat("foobar", 5))
+				rw.WriteRequest(req, protocol.StreamID(5+2*i), true, false)
+				headerFrame, headerFields := decode(headerStream.dataWritten.Bytes())
+				headerStream.dataWritten.Reset()
+				Expect(headerFrame.StreamID).To(BeEquivalentTo(5 + 2*i))
+				Expect(headerFields).To(HaveKeyWithValue(":path", "/file"+strconv.Itoa(i%3)+".html"))
+				Expect(headerFields).To(HaveKeyWithValue("x-request-number", strconv.Itoa(i)))
+				Expect(headerFields).To(HaveKeyWithValue("x-long-header", strings.Repeat("foobar", 5)))
+				Expect(headerFields).To(HaveKeyWithValue("user-agent", defaultUserAgent))
+			}
+		})
+
+		It("shrinks the table after requests were sent", func() {
+			decoder = hpack.NewDecoder(4096, func(hf hpack.HeaderField) {})
+			for i := 0; i < 10; i++ {
+				if i == 5 {
+					rw.setMaxHeaderTableSize(0)
+				}
+				req, err := http.NewRequest("GET", "https://quic.clemente.io/index.html", nil)
+				Expect(err).ToNot(HaveOccurred())
+				req.Header.Set("X-Request-Number", strconv.Itoa(i))
+				rw.WriteRequest(req, protocol.StreamID(5+2*i), true, false)
+				_, headerFields := decode(headerStream.dataWritten.Bytes())
+				headerStream.dataWritten.Reset()
+				Expect(headerFields).To(HaveKeyWithValue(":authority", "quic.clemente.io"))
+				Expect(headerFields).To(HaveKeyWithValue("x-request-number", strconv.Itoa(i)))
+			}
+		})
+	})
 })
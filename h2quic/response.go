@@ -33,16 +33,7 @@ func responseFromHeaders(f *http2.MetaHeadersFrame) (*http.Response, error) {
 		return nil, errors.New("malformed non-numeric status pseudo header")
 	}
 
-	if statusCode == 100 {
-		// TODO: handle this
-
-		// traceGot100Continue(cs.trace)
-		// if cs.on100 != nil {
-		// 	cs.on100() // forces any write delay timer to fire
-		// }
-		// cs.pastHeaders = false // do it all again
-		// return nil, nil
-	}
+	// interim responses (e.g. 100 Continue) are handled by Client.Do
 
 	header := make(http.Header)
 	res := &http.Response{
@@ -71,6 +62,16 @@ func responseFromHeaders(f *http2.MetaHeadersFrame) (*http.Response, error) {
 	return res, nil
 }
 
+// trailerFromHeaders parses the trailers sent in the final HEADERS frame of a response
+func trailerFromHeaders(f *http2.MetaHeadersFrame) http.Header {
+	trailer := make(http.Header)
+	for _, hf := range f.RegularFields() {
+		key := http.CanonicalHeaderKey(hf.Name)
+		trailer[key] = append(trailer[key], hf.Value)
+	}
+	return trailer
+}
+
 // continuation of the handleResponse function
 func setLength(res *http.Response, isHead, streamEnded bool) *http.Response {
 	if !streamEnded || isHead {
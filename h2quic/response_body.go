@@ -0,0 +1,69 @@
+package h2quic
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	quic "github.com/lucas-clemente/quic-go"
+)
+
+var errResponseBodyClosed = errors.New("h2quic: response body closed")
+
+// responseBody is the body of a response.
+// If it is closed before it was read completely, the data stream is reset, so that the server stops sending.
+//
+// If the response declared trailers, they are sent in a HEADERS frame on the header stream, which is not ordered with respect to the data stream.
+// Once the body was read completely, Read therefore blocks until the trailers were received.
+//
+// onDone is called once the body was read completely or closed.
+type responseBody struct {
+	dataStream quic.Stream
+	eof        bool // set when the data stream returned io.EOF
+	onDone     func()
+
+	trailer     http.Header // the Response.Trailer
+	trailerChan <-chan http.Header
+}
+
+var _ io.ReadCloser = &responseBody{}
+
+func (b *responseBody) Read(p []byte) (int, error) {
+	n, err := b.dataStream.Read(p)
+	if err == io.EOF {
+		b.eof = true
+	}
+	if err != io.EOF {
+		return n, err
+	}
+	defer b.done()
+	if b.trailerChan == nil {
+		return n, err
+	}
+	trailer, ok := <-b.trailerChan
+	b.trailerChan = nil
+	if !ok { // an error occurred on the header stream
+		return n, io.ErrUnexpectedEOF
+	}
+	for k, v := range trailer {
+		b.trailer[k] = v
+	}
+	return n, io.EOF
+}
+
+func (b *responseBody) Close() error {
+	// the stream's Close() closes the write side, which was already closed after sending the request
+	// tell the server to stop sending, if we didn't receive the whole body
+	if !b.eof {
+		b.dataStream.Reset(errResponseBodyClosed)
+	}
+	b.done()
+	return nil
+}
+
+func (b *responseBody) done() {
+	if b.onDone != nil {
+		b.onDone()
+		b.onDone = nil
+	}
+}
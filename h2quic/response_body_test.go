@@ -0,0 +1,117 @@
+package h2quic
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Response body", func() {
+	var (
+		stream      *mockStream
+		trailer     http.Header
+		trailerChan chan http.Header
+		body        *responseBody
+	)
+
+	BeforeEach(func() {
+		stream = &mockStream{}
+		stream.dataToRead.Write([]byte("foobar"))
+		trailer = http.Header{"Foo": nil}
+		trailerChan = make(chan http.Header, 1)
+		body = &responseBody{dataStream: stream, trailer: trailer, trailerChan: trailerChan}
+	})
+
+	It("reads the body if the response didn't declare trailers", func() {
+		body = &responseBody{dataStream: stream}
+		data, err := ioutil.ReadAll(body)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(data).To(Equal([]byte("foobar")))
+	})
+
+	It("resets the stream when closed before reading the whole body", func() {
+		_, err := body.Read(make([]byte, 3))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(body.Close()).To(Succeed())
+		Expect(stream.reset).To(BeTrue())
+	})
+
+	It("doesn't reset the stream when closed after reading the whole body", func() {
+		body = &responseBody{dataStream: stream}
+		_, err := ioutil.ReadAll(body)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(body.Close()).To(Succeed())
+		Expect(stream.reset).To(BeFalse())
+	})
+
+	It("waits for the trailers when reaching the end of the body", func(done Done) {
+		b := make([]byte, 6)
+		n, err := body.Read(b)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(n).To(Equal(6))
+		readErr := make(chan error)
+		go func() {
+			_, err := body.Read(b)
+			readErr <- err
+		}()
+		Consistently(readErr).ShouldNot(Receive())
+		trailerChan <- http.Header{"Foo": []string{"bar"}}
+		Eventually(readErr).Should(Receive(Equal(io.EOF)))
+		Expect(trailer).To(Equal(http.Header{"Foo": []string{"bar"}}))
+		close(done)
+	})
+
+	It("returns EOF on subsequent reads", func() {
+		trailerChan <- http.Header{"Foo": []string{"bar"}}
+		data, err := ioutil.ReadAll(body)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(data).To(Equal([]byte("foobar")))
+		_, err = body.Read(make([]byte, 1))
+		Expect(err).To(Equal(io.EOF))
+	})
+
+	It("errors if the trailers are not received", func() {
+		close(trailerChan)
+		_, err := ioutil.ReadAll(body)
+		Expect(err).To(MatchError(io.ErrUnexpectedEOF))
+	})
+
+	Context("calling onDone", func() {
+		var doneCount int
+
+		BeforeEach(func() {
+			doneCount = 0
+			body = &responseBody{dataStream: stream, onDone: func() { doneCount++ }}
+		})
+
+		It("calls it once the body was read completely", func() {
+			_, err := body.Read(make([]byte, 6))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(doneCount).To(BeZero())
+			_, err = ioutil.ReadAll(body)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(doneCount).To(Equal(1))
+			Expect(body.Close()).To(Succeed())
+			Expect(doneCount).To(Equal(1))
+		})
+
+		It("calls it when the body is closed", func() {
+			Expect(body.Close()).To(Succeed())
+			Expect(doneCount).To(Equal(1))
+			Expect(body.Close()).To(Succeed())
+			Expect(doneCount).To(Equal(1))
+		})
+
+		It("calls it after the trailers were received", func() {
+			body.trailer = trailer
+			body.trailerChan = trailerChan
+			trailerChan <- http.Header{"Foo": []string{"bar"}}
+			_, err := ioutil.ReadAll(body)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(doneCount).To(Equal(1))
+		})
+	})
+})
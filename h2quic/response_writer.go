@@ -2,7 +2,9 @@ package h2quic
 
 import (
 	"bytes"
+	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"sync"
@@ -24,6 +26,25 @@ type responseWriter struct {
 	header        http.Header
 	status        int // status code passed to WriteHeader
 	headerWritten bool
+	trailers      []string // the trailers declared in the Trailer header
+
+	authority      string
+	push           func([]hpack.HeaderField) error // nil for pushed responses
+	clientSettings *peerSettings                   // if nil, the default HPACK dynamic table size is used
+
+	hijacked bool
+}
+
+// A StreamHijacker allows a handler to take over the data stream of a request, similar to http.Hijacker.
+// The data stream can then be used as a bidirectional byte stream, e.g. as the tunnel of a CONNECT request,
+// or for protocols that switch away from HTTP after the response headers.
+// The http.ResponseWriter passed to the handler implements StreamHijacker.
+type StreamHijacker interface {
+	// HijackStream sends the response headers, if they haven't been sent yet, and returns the data stream.
+	// Reading from the stream returns the data sent by the client (the request body), and data written to it is sent to the client.
+	// After HijackStream was called, the server doesn't manage the stream as HTTP anymore: it won't send trailers, reset or close it.
+	// The handler is responsible for closing the stream. Closing the stream only closes the direction towards the client.
+	HijackStream() (quic.Stream, error)
 }
 
 func newResponseWriter(headerStream quic.Stream, headerStreamMutex *sync.Mutex, dataStream quic.Stream, dataStreamID protocol.StreamID) *responseWriter {
@@ -40,38 +61,123 @@ func (w *responseWriter) Header() http.Header {
 	return w.header
 }
 
+// WriteHeader sends the response headers.
+// Informational (1xx) status codes send an interim response with the current headers, e.g. 103 (Early Hints).
+// They can be sent multiple times, before the final response.
 func (w *responseWriter) WriteHeader(status int) {
 	if w.headerWritten {
 		return
 	}
+	if status >= 100 && status <= 199 {
+		utils.Infof("Sending interim response %d", status)
+		if err := w.writeHeaderFrame(w.encodeHeader(status, w.header), false); err != nil {
+			utils.Errorf("could not write h2 header: %s", err.Error())
+		}
+		return
+	}
 	w.headerWritten = true
 	w.status = status
 
+	headerBlock := w.encodeHeader(status, w.header)
+	for _, v := range w.header["Trailer"] {
+		foreachHeaderElement(v, func(key string) {
+			w.trailers = append(w.trailers, http.CanonicalHeaderKey(key))
+		})
+	}
+
+	utils.Infof("Responding with %d", status)
+	if err := w.writeHeaderFrame(headerBlock, false); err != nil {
+		utils.Errorf("could not write h2 header: %s", err.Error())
+	}
+}
+
+// encodeHeader encodes the status and the header fields, except for trailers set using http.TrailerPrefix
+func (w *responseWriter) encodeHeader(status int, header http.Header) []byte {
 	var headers bytes.Buffer
-	enc := hpack.NewEncoder(&headers)
+	enc := w.newHeaderEncoder(&headers)
 	enc.WriteField(hpack.HeaderField{Name: ":status", Value: strconv.Itoa(status)})
 
-	for k, v := range w.header {
+	for k, v := range header {
+		if strings.HasPrefix(k, http.TrailerPrefix) {
+			continue
+		}
 		for index := range v {
 			enc.WriteField(hpack.HeaderField{Name: strings.ToLower(k), Value: v[index]})
 		}
 	}
+	return headers.Bytes()
+}
 
-	utils.Infof("Responding with %d", status)
+// writeContinue sends an interim response with status 100 (Continue), telling the client to send the request body.
+// It doesn't do anything if the handler already sent the final response headers.
+func (w *responseWriter) writeContinue() {
+	if w.headerWritten {
+		return
+	}
+	if err := w.writeHeaderFrame(w.encodeHeader(100, nil), false); err != nil {
+		utils.Errorf("could not write h2 header: %s", err.Error())
+	}
+}
+
+// writeTrailers sends the trailers in a final HEADERS frame.
+// It must be called after the handler returned.
+// Since the client waits for this frame once the body was read, it is only sent if the response declared trailers in the Trailer header.
+// Trailers set using http.TrailerPrefix are only sent in that case as well.
+func (w *responseWriter) writeTrailers() {
+	if len(w.trailers) == 0 {
+		return
+	}
+
+	var headers bytes.Buffer
+	enc := w.newHeaderEncoder(&headers)
+	for _, k := range w.trailers {
+		for _, v := range w.header[k] {
+			enc.WriteField(hpack.HeaderField{Name: strings.ToLower(k), Value: v})
+		}
+	}
+	for k, vv := range w.header {
+		if !strings.HasPrefix(k, http.TrailerPrefix) {
+			continue
+		}
+		for _, v := range vv {
+			enc.WriteField(hpack.HeaderField{Name: strings.ToLower(strings.TrimPrefix(k, http.TrailerPrefix)), Value: v})
+		}
+	}
+
+	if err := w.writeHeaderFrame(headers.Bytes(), true); err != nil {
+		utils.Errorf("could not write h2 trailers: %s", err.Error())
+	}
+}
+
+// newHeaderEncoder creates an HPACK encoder for a header block sent on the header stream.
+// The encoders don't share any state, since the decoder of the client keeps the most recently inserted entries at the lowest indices.
+// However, its dynamic table must not grow larger than the client's SETTINGS_HEADER_TABLE_SIZE,
+// otherwise the client would already have evicted entries that the encoder still references.
+// If the client's table is smaller than the default size, the header block therefore starts with a dynamic table size update.
+func (w *responseWriter) newHeaderEncoder(headers *bytes.Buffer) *hpack.Encoder {
+	enc := hpack.NewEncoder(headers)
+	if w.clientSettings != nil {
+		enc.SetMaxDynamicTableSizeLimit(w.clientSettings.getHeaderTableSize())
+	}
+	return enc
+}
+
+func (w *responseWriter) writeHeaderFrame(headerBlock []byte, endStream bool) error {
 	w.headerStreamMutex.Lock()
 	defer w.headerStreamMutex.Unlock()
 	h2framer := http2.NewFramer(w.headerStream, nil)
-	err := h2framer.WriteHeaders(http2.HeadersFrameParam{
+	return h2framer.WriteHeaders(http2.HeadersFrameParam{
 		StreamID:      uint32(w.dataStreamID),
 		EndHeaders:    true,
-		BlockFragment: headers.Bytes(),
+		EndStream:     endStream,
+		BlockFragment: headerBlock,
 	})
-	if err != nil {
-		utils.Errorf("could not write h2 header: %s", err.Error())
-	}
 }
 
 func (w *responseWriter) Write(p []byte) (int, error) {
+	if w.hijacked {
+		return 0, http.ErrHijacked
+	}
 	if !w.headerWritten {
 		w.WriteHeader(200)
 	}
@@ -81,17 +187,91 @@ func (w *responseWriter) Write(p []byte) (int, error) {
 	return w.dataStream.Write(p)
 }
 
-func (w *responseWriter) Flush() {}
+// Flush sends the response headers, if they haven't been sent yet.
+// Data passed to Write is handed to the QUIC stream immediately, so there's no buffered data that would need to be flushed.
+func (w *responseWriter) Flush() {
+	if !w.headerWritten {
+		w.WriteHeader(200)
+	}
+}
+
+// HijackStream implements the StreamHijacker interface.
+// If the handler didn't call WriteHeader, the headers are sent with status 200, which tells the client of a CONNECT request that the tunnel was established.
+func (w *responseWriter) HijackStream() (quic.Stream, error) {
+	if w.hijacked {
+		return nil, http.ErrHijacked
+	}
+	if !w.headerWritten {
+		w.WriteHeader(200)
+	}
+	if w.status < 200 || w.status > 299 {
+		return nil, fmt.Errorf("h2quic: cannot hijack the data stream after responding with status %d", w.status)
+	}
+	w.hijacked = true
+	return w.dataStream, nil
+}
 
 // TODO: Implement a functional CloseNotify method.
 func (w *responseWriter) CloseNotify() <-chan bool { return make(<-chan bool) }
 
+// Push initiates a server push for the target, see http.Pusher.
+// The pushed request is handled by the server's handler.
+func (w *responseWriter) Push(target string, opts *http.PushOptions) error {
+	if w.push == nil {
+		return http.ErrNotSupported
+	}
+	if opts == nil {
+		opts = &http.PushOptions{}
+	}
+	method := opts.Method
+	if method == "" {
+		method = "GET"
+	}
+	if method != "GET" && method != "HEAD" {
+		return fmt.Errorf("h2quic: method %q must be GET or HEAD", method)
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "" {
+		if !strings.HasPrefix(target, "/") {
+			return fmt.Errorf("h2quic: target must be an absolute URL or an absolute path: %q", target)
+		}
+	} else if u.Scheme != "https" || u.Host != w.authority {
+		return fmt.Errorf("h2quic: cannot push %q for authority %q", target, w.authority)
+	}
+
+	fields := []hpack.HeaderField{
+		{Name: ":method", Value: method},
+		{Name: ":scheme", Value: "https"},
+		{Name: ":authority", Value: w.authority},
+		{Name: ":path", Value: u.RequestURI()},
+	}
+	for k, vv := range opts.Header {
+		switch strings.ToLower(k) {
+		case "content-length", "content-encoding", "trailer", "te", "expect", "host":
+			return fmt.Errorf("h2quic: promised request headers cannot include %q", k)
+		}
+		for _, v := range vv {
+			fields = append(fields, hpack.HeaderField{Name: strings.ToLower(k), Value: v})
+		}
+	}
+	return w.push(fields)
+}
+
 // test that we implement http.Flusher
 var _ http.Flusher = &responseWriter{}
 
 // test that we implement http.CloseNotifier
 var _ http.CloseNotifier = &responseWriter{}
 
+// test that we implement http.Pusher
+var _ http.Pusher = &responseWriter{}
+
+// test that we implement StreamHijacker
+var _ StreamHijacker = &responseWriter{}
+
 // copied from http2/http2.go
 // bodyAllowedForStatus reports whether a given response status code
 // permits a body. See RFC 2616, section 4.4.
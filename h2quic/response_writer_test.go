@@ -2,12 +2,18 @@ package h2quic
 
 import (
 	"bytes"
+	"context"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/hpack"
 
+	quic "github.com/lucas-clemente/quic-go"
 	"github.com/lucas-clemente/quic-go/protocol"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -20,16 +26,48 @@ type mockStream struct {
 	reset        bool
 	closed       bool
 	remoteClosed bool
+
+	ctx       context.Context
+	ctxCancel context.CancelFunc
+}
+
+func newMockStream(id protocol.StreamID) *mockStream {
+	s := &mockStream{id: id}
+	s.ctx, s.ctxCancel = context.WithCancel(context.Background())
+	return s
 }
 
 func (s *mockStream) Close() error                          { s.closed = true; return nil }
-func (s *mockStream) Reset(error)                           { s.reset = true }
+func (s *mockStream) CloseWrite() error                     { return s.Close() }
+func (s *mockStream) CloseRead() error                      { panic("not implemented") }
+func (s *mockStream) Reset(error)                           { s.reset = true; s.cancelContext() }
 func (s *mockStream) CloseRemote(offset protocol.ByteCount) { s.remoteClosed = true }
 func (s mockStream) StreamID() protocol.StreamID            { return s.id }
 
+func (s *mockStream) Context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+func (s *mockStream) cancelContext() {
+	if s.ctxCancel != nil {
+		s.ctxCancel()
+	}
+}
+
 func (s *mockStream) Read(p []byte) (int, error)  { return s.dataToRead.Read(p) }
 func (s *mockStream) Write(p []byte) (int, error) { return s.dataWritten.Write(p) }
 
+func (s *mockStream) WriteTo(w io.Writer) (int64, error)  { return s.dataToRead.WriteTo(w) }
+func (s *mockStream) ReadFrom(r io.Reader) (int64, error) { return s.dataWritten.ReadFrom(r) }
+
+func (s *mockStream) SetReadDeadline(time.Time) error  { panic("not implemented") }
+func (s *mockStream) SetWriteDeadline(time.Time) error { panic("not implemented") }
+func (s *mockStream) SetDeadline(time.Time) error      { panic("not implemented") }
+func (s *mockStream) SetPriority(quic.Priority)        { panic("not implemented") }
+
 var _ = Describe("Response Writer", func() {
 	var (
 		w            *responseWriter
@@ -43,25 +81,39 @@ var _ = Describe("Response Writer", func() {
 		w = newResponseWriter(headerStream, &sync.Mutex{}, dataStream, 5)
 	})
 
-	decodeHeaderFields := func() map[string][]string {
-		fields := make(map[string][]string)
+	decodeHeaderFrames := func() []*http2.MetaHeadersFrame {
+		var frames []*http2.MetaHeadersFrame
 		decoder := hpack.NewDecoder(4096, func(hf hpack.HeaderField) {})
 		h2framer := http2.NewFramer(nil, bytes.NewReader(headerStream.dataWritten.Bytes()))
+		for {
+			frame, err := h2framer.ReadFrame()
+			if err != nil {
+				return frames
+			}
+			Expect(frame).To(BeAssignableToTypeOf(&http2.HeadersFrame{}))
+			hframe := frame.(*http2.HeadersFrame)
+			mhframe := &http2.MetaHeadersFrame{HeadersFrame: hframe}
+			Expect(mhframe.StreamID).To(BeEquivalentTo(5))
+			mhframe.Fields, err = decoder.DecodeFull(hframe.HeaderBlockFragment())
+			Expect(err).ToNot(HaveOccurred())
+			frames = append(frames, mhframe)
+		}
+	}
 
-		frame, err := h2framer.ReadFrame()
-		Expect(err).ToNot(HaveOccurred())
-		Expect(frame).To(BeAssignableToTypeOf(&http2.HeadersFrame{}))
-		hframe := frame.(*http2.HeadersFrame)
-		mhframe := &http2.MetaHeadersFrame{HeadersFrame: hframe}
-		Expect(mhframe.StreamID).To(BeEquivalentTo(5))
-		mhframe.Fields, err = decoder.DecodeFull(hframe.HeaderBlockFragment())
-		Expect(err).ToNot(HaveOccurred())
-		for _, p := range mhframe.Fields {
+	getFields := func(f *http2.MetaHeadersFrame) map[string][]string {
+		fields := make(map[string][]string)
+		for _, p := range f.Fields {
 			fields[p.Name] = append(fields[p.Name], p.Value)
 		}
 		return fields
 	}
 
+	decodeHeaderFields := func() map[string][]string {
+		frames := decodeHeaderFrames()
+		Expect(frames).ToNot(BeEmpty())
+		return getFields(frames[0])
+	}
+
 	It("writes status", func() {
 		w.WriteHeader(http.StatusTeapot)
 		fields := decodeHeaderFields()
@@ -100,6 +152,19 @@ var _ = Describe("Response Writer", func() {
 		Expect(dataStream.dataWritten.Bytes()).To(Equal([]byte("foobar")))
 	})
 
+	It("sends informational responses before the final response", func() {
+		w.Header().Add("Link", "</style.css>; rel=preload; as=style")
+		w.WriteHeader(103)
+		w.WriteHeader(http.StatusOK)
+		frames := decodeHeaderFrames()
+		Expect(frames).To(HaveLen(2))
+		fields := getFields(frames[0])
+		Expect(fields).To(HaveKeyWithValue(":status", []string{"103"}))
+		Expect(fields).To(HaveKeyWithValue("link", []string{"</style.css>; rel=preload; as=style"}))
+		Expect(frames[0].StreamEnded()).To(BeFalse())
+		Expect(getFields(frames[1])).To(HaveKeyWithValue(":status", []string{"200"}))
+	})
+
 	It("writes data after WriteHeader is called", func() {
 		w.WriteHeader(http.StatusTeapot)
 		n, err := w.Write([]byte("foobar"))
@@ -120,6 +185,117 @@ var _ = Describe("Response Writer", func() {
 		Expect(fields).To(HaveKeyWithValue(":status", []string{"200"}))
 	})
 
+	Context("trailers", func() {
+		It("sends the declared trailers in a second HEADERS frame", func() {
+			w.Header().Set("Trailer", "Foo, bar")
+			w.WriteHeader(200)
+			w.Header().Set("Foo", "foobar")
+			w.Header().Add("Bar", "1")
+			w.Header().Add("Bar", "2")
+			w.writeTrailers()
+			frames := decodeHeaderFrames()
+			Expect(frames).To(HaveLen(2))
+			Expect(frames[0].StreamEnded()).To(BeFalse())
+			Expect(getFields(frames[0])).To(HaveKeyWithValue("trailer", []string{"Foo, bar"}))
+			Expect(frames[1].StreamEnded()).To(BeTrue())
+			Expect(getFields(frames[1])).To(Equal(map[string][]string{
+				"foo": {"foobar"},
+				"bar": {"1", "2"},
+			}))
+		})
+
+		It("sends trailers set using the TrailerPrefix", func() {
+			w.Header().Set("Trailer", "Foo")
+			w.Header().Set(http.TrailerPrefix+"Bar", "undeclared")
+			w.WriteHeader(200)
+			w.writeTrailers()
+			frames := decodeHeaderFrames()
+			Expect(frames).To(HaveLen(2))
+			Expect(getFields(frames[0])).ToNot(HaveKey("trailer:bar"))
+			Expect(getFields(frames[1])).To(Equal(map[string][]string{"bar": {"undeclared"}}))
+		})
+
+		It("doesn't send trailers if none were declared", func() {
+			w.WriteHeader(200)
+			w.Header().Set("Foo", "foobar")
+			w.writeTrailers()
+			Expect(decodeHeaderFrames()).To(HaveLen(1))
+		})
+	})
+
+	It("sends the headers when flushing", func() {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Flush()
+		fields := decodeHeaderFields()
+		Expect(fields).To(HaveKeyWithValue(":status", []string{"200"}))
+		Expect(fields).To(HaveKeyWithValue("content-type", []string{"text/event-stream"}))
+		Expect(dataStream.dataWritten.Len()).To(BeZero())
+	})
+
+	It("doesn't send the headers twice when flushing", func() {
+		w.WriteHeader(http.StatusTeapot)
+		w.Flush()
+		Expect(decodeHeaderFrames()).To(HaveLen(1))
+	})
+
+	Context("pushing", func() {
+		var pushedFields []hpack.HeaderField
+
+		BeforeEach(func() {
+			pushedFields = nil
+			w.authority = "www.example.com"
+			w.push = func(fields []hpack.HeaderField) error {
+				pushedFields = fields
+				return nil
+			}
+		})
+
+		It("pushes a resource", func() {
+			err := w.Push("/style.css?foo=bar", &http.PushOptions{Header: http.Header{"Accept-Encoding": {"gzip"}}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pushedFields).To(Equal([]hpack.HeaderField{
+				{Name: ":method", Value: "GET"},
+				{Name: ":scheme", Value: "https"},
+				{Name: ":authority", Value: "www.example.com"},
+				{Name: ":path", Value: "/style.css?foo=bar"},
+				{Name: "accept-encoding", Value: "gzip"},
+			}))
+		})
+
+		It("accepts absolute URLs for the same authority", func() {
+			err := w.Push("https://www.example.com/style.css", &http.PushOptions{Method: "HEAD"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pushedFields).To(ContainElement(hpack.HeaderField{Name: ":method", Value: "HEAD"}))
+			Expect(pushedFields).To(ContainElement(hpack.HeaderField{Name: ":path", Value: "/style.css"}))
+		})
+
+		It("doesn't push if pushing is not supported", func() {
+			w.push = nil
+			Expect(w.Push("/style.css", nil)).To(MatchError(http.ErrNotSupported))
+		})
+
+		It("rejects invalid methods", func() {
+			err := w.Push("/style.css", &http.PushOptions{Method: "POST"})
+			Expect(err).To(MatchError("h2quic: method \"POST\" must be GET or HEAD"))
+			Expect(pushedFields).To(BeNil())
+		})
+
+		It("rejects relative paths", func() {
+			err := w.Push("style.css", nil)
+			Expect(err).To(MatchError("h2quic: target must be an absolute URL or an absolute path: \"style.css\""))
+		})
+
+		It("rejects URLs for a different authority", func() {
+			err := w.Push("https://www.example.org/style.css", nil)
+			Expect(err).To(MatchError("h2quic: cannot push \"https://www.example.org/style.css\" for authority \"www.example.com\""))
+		})
+
+		It("rejects forbidden headers", func() {
+			err := w.Push("/style.css", &http.PushOptions{Header: http.Header{"Content-Length": {"42"}}})
+			Expect(err).To(MatchError("h2quic: promised request headers cannot include \"Content-Length\""))
+		})
+	})
+
 	It("doesn't allow writes if the status code doesn't allow a body", func() {
 		w.WriteHeader(304)
 		n, err := w.Write([]byte("foobar"))
@@ -127,4 +303,68 @@ var _ = Describe("Response Writer", func() {
 		Expect(err).To(MatchError(http.ErrBodyNotAllowed))
 		Expect(dataStream.dataWritten.Bytes()).To(HaveLen(0))
 	})
+
+	Context("hijacking the data stream", func() {
+		It("hijacks the data stream", func() {
+			str, err := w.HijackStream()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(str).To(Equal(dataStream))
+			fields := decodeHeaderFields()
+			Expect(fields).To(HaveKeyWithValue(":status", []string{"200"}))
+		})
+
+		It("doesn't allow writes after hijacking", func() {
+			_, err := w.HijackStream()
+			Expect(err).ToNot(HaveOccurred())
+			_, err = w.Write([]byte("foobar"))
+			Expect(err).To(MatchError(http.ErrHijacked))
+			Expect(dataStream.dataWritten.Len()).To(BeZero())
+		})
+
+		It("doesn't hijack twice", func() {
+			_, err := w.HijackStream()
+			Expect(err).ToNot(HaveOccurred())
+			_, err = w.HijackStream()
+			Expect(err).To(MatchError(http.ErrHijacked))
+		})
+
+		It("doesn't hijack after the handler responded with an error", func() {
+			w.WriteHeader(http.StatusForbidden)
+			_, err := w.HijackStream()
+			Expect(err).To(MatchError("h2quic: cannot hijack the data stream after responding with status 403"))
+		})
+
+		It("sends the status code set by the handler", func() {
+			w.WriteHeader(http.StatusAccepted)
+			_, err := w.HijackStream()
+			Expect(err).ToNot(HaveOccurred())
+			fields := decodeHeaderFields()
+			Expect(fields).To(HaveKeyWithValue(":status", []string{"202"}))
+		})
+	})
+
+	It("respects the client's SETTINGS_HEADER_TABLE_SIZE", func() {
+		clientSettings := newPeerSettings()
+		clientSettings.headerTableSize = 100
+		// the decoder of the client keeps its state across all responses
+		decoder := hpack.NewDecoder(100, func(hf hpack.HeaderField) {})
+		headerStreamMutex := &sync.Mutex{}
+		for i := 0; i < 10; i++ {
+			w = newResponseWriter(headerStream, headerStreamMutex, &mockStream{}, protocol.StreamID(5+2*i))
+			w.clientSettings = clientSettings
+			w.Header().Set("X-Response-Number", strconv.Itoa(i))
+			w.Header().Set("X-Long-Header", strings.Repeat("foobar", 5))
+			w.WriteHeader(http.StatusOK)
+			frame, err := http2.NewFramer(nil, &headerStream.dataWritten).ReadFrame()
+			Expect(err).ToNot(HaveOccurred())
+			block := frame.(*http2.HeadersFrame).HeaderBlockFragment()
+			// a dynamic table size update starts with the bit pattern 001
+			Expect(block[0] & 0xe0).To(BeEquivalentTo(0x20))
+			fields, err := decoder.DecodeFull(block)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fields).To(ContainElement(hpack.HeaderField{Name: ":status", Value: "200"}))
+			Expect(fields).To(ContainElement(hpack.HeaderField{Name: "x-response-number", Value: strconv.Itoa(i)}))
+			Expect(fields).To(ContainElement(hpack.HeaderField{Name: "x-long-header", Value: strings.Repeat("foobar", 5)}))
+		}
+	})
 })
@@ -1,19 +1,28 @@
 package h2quic
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"math"
 	"net/http"
 	"strings"
 	"sync"
+	"time"
 
 	"golang.org/x/net/lex/httplex"
 )
 
+// defaultMaxResponseHeaderBytes is the default limit for response headers, the same as in net/http
+const defaultMaxResponseHeaderBytes = 10 << 20
+
 type h2quicClient interface {
-	Dial() error
+	DialContext(context.Context) error
 	Do(*http.Request) (*http.Response, error)
+	Close(error)
+	handshakeComplete() bool
+	isClosed() bool
 }
 
 // QuicRoundTripper implements the http.RoundTripper interface
@@ -34,11 +43,39 @@ type QuicRoundTripper struct {
 	// tls.Client. If nil, the default configuration is used.
 	TLSClientConfig *tls.Config
 
-	clients map[string]h2quicClient
+	// MaxConnsPerHost limits the number of QUIC sessions to a single host.
+	// Since requests are multiplexed, a session that completed the handshake is always reused.
+	// Additional sessions are only dialed while the handshake of the existing ones is still running.
+	// If zero, a single session per host is used.
+	MaxConnsPerHost int
+
+	// ExpectContinueTimeout, if non-zero, specifies the amount of
+	// time to wait for a server's first response headers after fully
+	// writing the request headers if the request has an
+	// "Expect: 100-continue" header. Zero means no timeout and
+	// causes the body to be sent immediately, without
+	// waiting for the server to approve.
+	ExpectContinueTimeout time.Duration
+
+	// MaxResponseHeaderBytes specifies a limit on how many response bytes are allowed in the server's response header.
+	// Zero means to use a default limit.
+	MaxResponseHeaderBytes int64
+
+	// PushHandler is called in a new go routine for every response pushed by the server, together with the request promised by the server.
+	// It is responsible for closing the response body.
+	// If nil, server push is disabled.
+	PushHandler func(*http.Request, *http.Response)
+
+	clients map[string][]h2quicClient
+	closed  bool
+
+	newClient func(hostname string) h2quicClient // only set for testing
 }
 
 var _ http.RoundTripper = &QuicRoundTripper{}
 
+var errRoundTripperClosed = errors.New("quic: RoundTripper closed")
+
 // RoundTrip does a round trip
 func (r *QuicRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 	if req.URL == nil {
@@ -76,8 +113,10 @@ func (r *QuicRoundTripper) RoundTrip(req *http.Request) (*http.Response, error)
 	}
 
 	hostname := authorityAddr("https", hostnameFromRequest(req))
+	traceGetConn(req, hostname)
 	client, err := r.getClient(hostname)
 	if err != nil {
+		closeRequestBody(req)
 		return nil, err
 	}
 	return client.Do(req)
@@ -87,26 +126,114 @@ func (r *QuicRoundTripper) getClient(hostname string) (h2quicClient, error) {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
+	if r.closed {
+		return nil, errRoundTripperClosed
+	}
 	if r.clients == nil {
-		r.clients = make(map[string]h2quicClient)
+		r.clients = make(map[string][]h2quicClient)
 	}
 
-	client, ok := r.clients[hostname]
-	if !ok {
-		client = NewClient(r, r.TLSClientConfig, hostname)
-		err := client.Dial()
-		if err != nil {
-			return nil, err
+	// evict all clients whose session was closed
+	var clients []h2quicClient
+	for _, c := range r.clients[hostname] {
+		if !c.isClosed() {
+			clients = append(clients, c)
 		}
-		r.clients[hostname] = client
 	}
+	r.clients[hostname] = clients
+
+	for _, c := range clients {
+		if c.handshakeComplete() {
+			return c, nil
+		}
+	}
+	// all sessions are still in the handshake
+	// Do blocks until it completes
+	if len(clients) > 0 && len(clients) >= r.maxConnsPerHost() {
+		return clients[0], nil
+	}
+
+	var client h2quicClient
+	if r.newClient != nil {
+		client = r.newClient(hostname)
+	} else {
+		client = NewClient(r, r.TLSClientConfig, hostname)
+	}
+	r.clients[hostname] = append(clients, client)
+
+	// The handshake is shared by all requests to this host, so it must not be aborted when the request
+	// that triggered it is cancelled. It is only aborted by Close.
+	// Do waits for the handshake to complete, or for the context of the request to be cancelled.
+	go func() {
+		if err := client.DialContext(context.Background()); err != nil {
+			r.removeClient(hostname, client)
+		}
+	}()
 	return client, nil
 }
 
+func (r *QuicRoundTripper) removeClient(hostname string, client h2quicClient) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	clients := r.clients[hostname]
+	for i, c := range clients {
+		if c == client {
+			r.clients[hostname] = append(clients[:i], clients[i+1:]...)
+			break
+		}
+	}
+	if len(r.clients[hostname]) == 0 {
+		delete(r.clients, hostname)
+	}
+}
+
+// Close closes all sessions held by the RoundTripper.
+// After Close, the RoundTripper can't be used for new requests.
+func (r *QuicRoundTripper) Close() error {
+	r.mutex.Lock()
+	clients := r.clients
+	r.clients = nil
+	r.closed = true
+	r.mutex.Unlock()
+
+	for _, cs := range clients {
+		for _, c := range cs {
+			c.Close(nil)
+		}
+	}
+	return nil
+}
+
+func (r *QuicRoundTripper) maxConnsPerHost() int {
+	if r.MaxConnsPerHost <= 0 {
+		return 1
+	}
+	return r.MaxConnsPerHost
+}
+
 func (r *QuicRoundTripper) disableCompression() bool {
 	return r.DisableCompression
 }
 
+func (r *QuicRoundTripper) maxResponseHeaderBytes() uint32 {
+	if r.MaxResponseHeaderBytes <= 0 {
+		return defaultMaxResponseHeaderBytes
+	}
+	if r.MaxResponseHeaderBytes > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint32(r.MaxResponseHeaderBytes)
+}
+
+func (r *QuicRoundTripper) expectContinueTimeout() time.Duration {
+	return r.ExpectContinueTimeout
+}
+
+func (r *QuicRoundTripper) pushHandler() func(*http.Request, *http.Response) {
+	return r.PushHandler
+}
+
 func closeRequestBody(req *http.Request) {
 	if req.Body != nil {
 		req.Body.Close()
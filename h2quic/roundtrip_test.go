@@ -2,20 +2,73 @@ package h2quic
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"io"
 	"net/http"
+	"net/http/httptrace"
+	"sync"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
 
-type mockQuicRoundTripper struct{}
+type mockQuicRoundTripper struct {
+	mutex sync.Mutex
 
-func (m *mockQuicRoundTripper) Dial() error {
-	return nil
+	dialed     bool
+	dialCtx    context.Context
+	dialErr    error
+	dialChan   chan struct{} // if set, DialContext blocks until it is closed
+	dialedChan chan struct{}
+	closed     bool
+	requests   int
+}
+
+var _ h2quicClient = &mockQuicRoundTripper{}
+
+func newMockQuicRoundTripper() *mockQuicRoundTripper {
+	return &mockQuicRoundTripper{dialedChan: make(chan struct{})}
+}
+
+func (m *mockQuicRoundTripper) DialContext(ctx context.Context) error {
+	m.mutex.Lock()
+	m.dialCtx = ctx
+	m.mutex.Unlock()
+	if m.dialChan != nil {
+		<-m.dialChan
+	}
+	m.mutex.Lock()
+	m.dialed = true
+	m.mutex.Unlock()
+	close(m.dialedChan)
+	return m.dialErr
 }
 func (m *mockQuicRoundTripper) Do(req *http.Request) (*http.Response, error) {
-	return &http.Response{Request: req}, nil
+	select {
+	case <-m.dialedChan:
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+	m.mutex.Lock()
+	m.requests++
+	m.mutex.Unlock()
+	return &http.Response{Request: req}, m.dialErr
+}
+func (m *mockQuicRoundTripper) Close(error) {
+	m.mutex.Lock()
+	m.closed = true
+	m.mutex.Unlock()
+}
+func (m *mockQuicRoundTripper) handshakeComplete() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.dialed && m.dialErr == nil && !m.closed
+}
+func (m *mockQuicRoundTripper) isClosed() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.closed || (m.dialed && m.dialErr != nil)
 }
 
 type mockBody struct {
@@ -58,12 +111,175 @@ var _ = Describe("RoundTripper", func() {
 	})
 
 	It("reuses existing clients", func() {
-		rt.clients = make(map[string]h2quicClient)
-		rt.clients["www.example.org:443"] = &mockQuicRoundTripper{}
+		client := newMockQuicRoundTripper()
+		close(client.dialedChan)
+		client.dialed = true
+		rt.clients = map[string][]h2quicClient{"www.example.org:443": {client}}
 		rsp, err := rt.RoundTrip(req1)
 		Expect(err).ToNot(HaveOccurred())
 		Expect(rsp.Request).To(Equal(req1))
 		Expect(rt.clients).To(HaveLen(1))
+		Expect(client.requests).To(Equal(1))
+	})
+
+	It("calls the GetConn hook of the ClientTrace", func() {
+		client := newMockQuicRoundTripper()
+		close(client.dialedChan)
+		client.dialed = true
+		rt.clients = map[string][]h2quicClient{"www.example.org:443": {client}}
+		var hostPort string
+		trace := &httptrace.ClientTrace{GetConn: func(hp string) { hostPort = hp }}
+		_, err := rt.RoundTrip(req1.WithContext(httptrace.WithClientTrace(context.Background(), trace)))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(hostPort).To(Equal("www.example.org:443"))
+	})
+
+	Context("pooling sessions", func() {
+		var (
+			clients    []*mockQuicRoundTripper
+			dialChan   chan struct{}
+			clientsMut sync.Mutex
+		)
+
+		numClients := func() int {
+			clientsMut.Lock()
+			defer clientsMut.Unlock()
+			return len(clients)
+		}
+
+		BeforeEach(func() {
+			clients = nil
+			dialChan = make(chan struct{})
+			rt.newClient = func(hostname string) h2quicClient {
+				Expect(hostname).To(Equal("www.example.org:443"))
+				c := newMockQuicRoundTripper()
+				c.dialChan = dialChan
+				clientsMut.Lock()
+				clients = append(clients, c)
+				clientsMut.Unlock()
+				return c
+			}
+		})
+
+		It("uses a single session for concurrent requests", func() {
+			var wg sync.WaitGroup
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer GinkgoRecover()
+					defer wg.Done()
+					_, err := rt.RoundTrip(req1)
+					Expect(err).ToNot(HaveOccurred())
+				}()
+			}
+			Eventually(numClients).Should(Equal(1))
+			close(dialChan)
+			wg.Wait()
+			Expect(clients).To(HaveLen(1))
+			Expect(clients[0].requests).To(Equal(20))
+			Expect(rt.clients["www.example.org:443"]).To(HaveLen(1))
+		})
+
+		It("dials up to MaxConnsPerHost sessions while the handshake is running", func() {
+			rt.MaxConnsPerHost = 2
+			var wg sync.WaitGroup
+			for i := 0; i < 5; i++ {
+				wg.Add(1)
+				go func() {
+					defer GinkgoRecover()
+					defer wg.Done()
+					_, err := rt.RoundTrip(req1)
+					Expect(err).ToNot(HaveOccurred())
+				}()
+			}
+			Eventually(numClients).Should(Equal(2))
+			Consistently(numClients).Should(Equal(2))
+			close(dialChan)
+			wg.Wait()
+			Expect(clients[0].requests + clients[1].requests).To(Equal(5))
+			// once the handshake is complete, a session is reused
+			_, err := rt.RoundTrip(req1)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(clients).To(HaveLen(2))
+		})
+
+		It("evicts closed sessions", func() {
+			close(dialChan)
+			_, err := rt.RoundTrip(req1)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(clients).To(HaveLen(1))
+			clients[0].Close(nil)
+			_, err = rt.RoundTrip(req1)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(clients).To(HaveLen(2))
+			Expect(clients[1].requests).To(Equal(1))
+			Expect(rt.clients["www.example.org:443"]).To(Equal([]h2quicClient{clients[1]}))
+		})
+
+		It("removes a client when dialing fails", func() {
+			testErr := errors.New("handshake failed")
+			rt.newClient = func(string) h2quicClient {
+				c := newMockQuicRoundTripper()
+				c.dialErr = testErr
+				return c
+			}
+			_, err := rt.RoundTrip(req1)
+			Expect(err).To(MatchError(testErr))
+			Eventually(func() int {
+				rt.mutex.Lock()
+				defer rt.mutex.Unlock()
+				return len(rt.clients)
+			}).Should(BeZero())
+		})
+
+		It("doesn't abort the handshake when the request that started it is cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			rtErr := make(chan error)
+			go func() {
+				defer GinkgoRecover()
+				_, err := rt.RoundTrip(req1.WithContext(ctx))
+				rtErr <- err
+			}()
+			Eventually(numClients).Should(Equal(1))
+			cancel()
+			Eventually(rtErr).Should(Receive(MatchError(context.Canceled)))
+			clients[0].mutex.Lock()
+			Expect(clients[0].dialCtx.Err()).ToNot(HaveOccurred())
+			clients[0].mutex.Unlock()
+			// a second request uses the session once the handshake completes
+			close(dialChan)
+			_, err := rt.RoundTrip(req1)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(clients).To(HaveLen(1))
+			Expect(clients[0].requests).To(Equal(1))
+		})
+
+		It("closes all sessions", func() {
+			close(dialChan)
+			_, err := rt.RoundTrip(req1)
+			Expect(err).ToNot(HaveOccurred())
+			req2, err := http.NewRequest("GET", "https://quic.clemente.io/file2.html", nil)
+			Expect(err).ToNot(HaveOccurred())
+			rt.newClient = func(string) h2quicClient {
+				c := newMockQuicRoundTripper()
+				clients = append(clients, c)
+				return c
+			}
+			_, err = rt.RoundTrip(req2)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(clients).To(HaveLen(2))
+			Expect(rt.Close()).To(Succeed())
+			Expect(clients[0].closed).To(BeTrue())
+			Expect(clients[1].closed).To(BeTrue())
+			Expect(rt.clients).To(BeEmpty())
+		})
+
+		It("doesn't dial new sessions after it was closed", func() {
+			Expect(rt.Close()).To(Succeed())
+			_, err := rt.RoundTrip(req1)
+			Expect(err).To(MatchError(errRoundTripperClosed))
+			Expect(clients).To(BeEmpty())
+		})
 	})
 
 	It("disable compression", func() {
@@ -1,6 +1,8 @@
 package h2quic
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
@@ -8,6 +10,7 @@ import (
 	"net/http"
 	"runtime"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -29,10 +32,18 @@ type remoteCloser interface {
 	CloseRemote(protocol.ByteCount)
 }
 
+var errServerShuttingDown = errors.New("h2quic: server is shutting down")
+
 // Server is a HTTP2 server listening for QUIC connections.
+// The size of request headers is limited by the http.Server's MaxHeaderBytes.
 type Server struct {
 	*http.Server
 
+	// By providing a quic.Config, it is possible to set parameters of the QUIC connection.
+	// If nil, it uses reasonable default values.
+	// The TLSConfig is always taken from the http.Server.
+	QuicConfig *quic.Config
+
 	// Private flag for demo, do not use
 	CloseAfterFirstRequest bool
 
@@ -41,6 +52,11 @@ type Server struct {
 	listenerMutex sync.Mutex
 	listener      quic.Listener
 
+	// activeRequestsMutex protects closing, and makes sure that no new request is added to activeRequests once the server is shutting down
+	activeRequestsMutex sync.Mutex
+	activeRequests      sync.WaitGroup
+	closing             bool
+
 	supportedVersionsAsString string
 }
 
@@ -83,10 +99,11 @@ func (s *Server) serveImpl(tlsConfig *tls.Config, conn *net.UDPConn) error {
 		return errors.New("ListenAndServe may only be called once")
 	}
 
-	config := quic.Config{
-		TLSConfig: tlsConfig,
-		Versions:  protocol.SupportedVersions,
+	config := quic.Config{}
+	if s.QuicConfig != nil {
+		config = *s.QuicConfig
 	}
+	config.TLSConfig = tlsConfig
 
 	var ln quic.Listener
 	var err error
@@ -112,7 +129,16 @@ func (s *Server) serveImpl(tlsConfig *tls.Config, conn *net.UDPConn) error {
 }
 
 func (s *Server) handleHeaderStream(session streamCreator) {
-	stream, err := session.AcceptStream()
+	s.activeRequestsMutex.Lock()
+	closing := s.closing
+	s.activeRequestsMutex.Unlock()
+	if closing {
+		utils.Infof("Server is shutting down. Refusing new session")
+		session.Close(errServerShuttingDown)
+		return
+	}
+
+	stream, err := session.AcceptStream(context.Background())
 	if err != nil {
 		session.Close(qerr.Error(qerr.InvalidHeadersStreamData, err.Error()))
 		return
@@ -122,13 +148,24 @@ func (s *Server) handleHeaderStream(session streamCreator) {
 		return
 	}
 
-	hpackDecoder := hpack.NewDecoder(4096, nil)
+	var maxConcurrentStreams uint32
+	if s.QuicConfig != nil && s.QuicConfig.MaxIncomingStreams > 0 {
+		maxConcurrentStreams = uint32(s.QuicConfig.MaxIncomingStreams)
+	}
+	maxHeaderListSize := http2.Setting{ID: http2.SettingMaxHeaderListSize, Val: s.maxHeaderListSize()}
+	if err := writeSettings(stream, maxConcurrentStreams, maxHeaderListSize); err != nil {
+		session.Close(err)
+		return
+	}
+
+	hpackDecoder := hpack.NewDecoder(headerTableSize, nil)
 	h2framer := http2.NewFramer(nil, stream)
+	clientSettings := newPeerSettings()
 
 	go func() {
 		var headerStreamMutex sync.Mutex // Protects concurrent calls to Write()
 		for {
-			if err := s.handleRequest(session, stream, &headerStreamMutex, hpackDecoder, h2framer); err != nil {
+			if err := s.handleRequest(session, stream, &headerStreamMutex, hpackDecoder, h2framer, clientSettings); err != nil {
 				// QuicErrors must originate from stream.Read() returning an error.
 				// In this case, the session has already logged the error, so we don't
 				// need to log it again.
@@ -142,11 +179,18 @@ func (s *Server) handleHeaderStream(session streamCreator) {
 	}()
 }
 
-func (s *Server) handleRequest(session streamCreator, headerStream quic.Stream, headerStreamMutex *sync.Mutex, hpackDecoder *hpack.Decoder, h2framer *http2.Framer) error {
+func (s *Server) handleRequest(session streamCreator, headerStream quic.Stream, headerStreamMutex *sync.Mutex, hpackDecoder *hpack.Decoder, h2framer *http2.Framer, clientSettings *peerSettings) error {
 	h2frame, err := h2framer.ReadFrame()
 	if err != nil {
 		return qerr.Error(qerr.HeadersStreamDataDecompressFailure, "cannot read frame")
 	}
+	if settingsFrame, ok := h2frame.(*http2.SettingsFrame); ok {
+		// the client's SETTINGS_MAX_CONCURRENT_STREAMS limits pushed streams, which are already limited by QUIC's stream limit
+		if err := clientSettings.apply(settingsFrame); err != nil {
+			return qerr.Error(qerr.InvalidHeadersStreamData, err.Error())
+		}
+		return nil
+	}
 	h2headersFrame, ok := h2frame.(*http2.HeadersFrame)
 	if !ok {
 		return qerr.Error(qerr.InvalidHeadersStreamData, "expected a header frame")
@@ -154,11 +198,14 @@ func (s *Server) handleRequest(session streamCreator, headerStream quic.Stream,
 	if !h2headersFrame.HeadersEnded() {
 		return errors.New("http2 header continuation not implemented")
 	}
-	headers, err := hpackDecoder.DecodeFull(h2headersFrame.HeaderBlockFragment())
+	headers, tooLarge, err := decodeHeaderBlock(hpackDecoder, h2headersFrame.HeaderBlockFragment(), s.maxHeaderListSize())
 	if err != nil {
 		utils.Errorf("invalid http2 headers encoding: %s", err.Error())
 		return err
 	}
+	if tooLarge {
+		return s.rejectRequestHeaders(session, headerStream, headerStreamMutex, protocol.StreamID(h2headersFrame.StreamID), clientSettings, h2headersFrame.StreamEnded())
+	}
 
 	req, err := requestFromHeaders(headers)
 	if err != nil {
@@ -182,19 +229,135 @@ func (s *Server) handleRequest(session streamCreator, headerStream quic.Stream,
 		return nil
 	}
 
-	var streamEnded bool
-	if h2headersFrame.StreamEnded() {
+	responseWriter := newResponseWriter(headerStream, headerStreamMutex, dataStream, protocol.StreamID(h2headersFrame.StreamID))
+	responseWriter.authority = req.Host
+	responseWriter.clientSettings = clientSettings
+	responseWriter.push = func(fields []hpack.HeaderField) error {
+		if !clientSettings.isPushEnabled() {
+			return http.ErrNotSupported
+		}
+		return s.push(session, responseWriter, fields)
+	}
+	s.serveRequest(session, req, responseWriter, h2headersFrame.StreamEnded())
+	return nil
+}
+
+// rejectRequestHeaders responds with 431 (Request Header Fields Too Large) to a request whose header list exceeded the limit, without running the handler
+// If the client might still send a request body, the data stream is reset, since the body will never be read
+func (s *Server) rejectRequestHeaders(session streamCreator, headerStream quic.Stream, headerStreamMutex *sync.Mutex, id protocol.StreamID, clientSettings *peerSettings, streamEnded bool) error {
+	utils.Infof("Rejecting request on data stream %d: header list larger than %d bytes", id, s.maxHeaderListSize())
+	dataStream, err := session.GetOrOpenStream(id)
+	if err != nil {
+		return err
+	}
+	if dataStream == nil {
+		return nil
+	}
+	responseWriter := newResponseWriter(headerStream, headerStreamMutex, dataStream, id)
+	responseWriter.clientSettings = clientSettings
+	responseWriter.WriteHeader(http.StatusRequestHeaderFieldsTooLarge)
+	if !streamEnded {
+		dataStream.Reset(nil)
+	}
+	return dataStream.Close()
+}
+
+// maxHeaderListSize is the limit for the size of the request header list, derived from http.Server.MaxHeaderBytes.
+// Like in net/http, some slack is added, since the size of a header list includes an overhead of 32 bytes per header field.
+func (s *Server) maxHeaderListSize() uint32 {
+	n := http.DefaultMaxHeaderBytes
+	if s.Server != nil && s.MaxHeaderBytes > 0 {
+		n = s.MaxHeaderBytes
+	}
+	const perFieldOverhead = 32 // as defined in RFC 7540, section 6.5.2
+	const typicalHeaders = 10   // conservative
+	return uint32(n + typicalHeaders*perFieldOverhead)
+}
+
+// push sends a PUSH_PROMISE for the request described by the header fields, and serves the pushed request on a new stream
+func (s *Server) push(session streamCreator, associated *responseWriter, fields []hpack.HeaderField) error {
+	req, err := requestFromHeaders(fields)
+	if err != nil {
+		return err
+	}
+	req.RemoteAddr = session.RemoteAddr().String()
+
+	dataStream, err := session.OpenStream()
+	if err != nil {
+		return err
+	}
+
+	var headers bytes.Buffer
+	enc := associated.newHeaderEncoder(&headers)
+	for _, f := range fields {
+		enc.WriteField(f)
+	}
+	associated.headerStreamMutex.Lock()
+	h2framer := http2.NewFramer(associated.headerStream, nil)
+	err = h2framer.WritePushPromise(http2.PushPromiseParam{
+		StreamID:      uint32(associated.dataStreamID),
+		PromiseID:     uint32(dataStream.StreamID()),
+		BlockFragment: headers.Bytes(),
+		EndHeaders:    true,
+	})
+	associated.headerStreamMutex.Unlock()
+	if err != nil {
+		dataStream.Reset(err)
+		return err
+	}
+
+	utils.Infof("Pushing %s%s on data stream %d", req.Host, req.RequestURI, dataStream.StreamID())
+	// pushed streams are unidirectional, the client never sends any data
+	responseWriter := newResponseWriter(associated.headerStream, associated.headerStreamMutex, dataStream, dataStream.StreamID())
+	responseWriter.clientSettings = associated.clientSettings
+	s.serveRequest(session, req, responseWriter, true)
+	return nil
+}
+
+// tlsConnectionState converts the state of the QUIC handshake to a tls.ConnectionState, such that handlers can access it as http.Request.TLS
+func tlsConnectionState(state quic.ConnectionState) *tls.ConnectionState {
+	return &tls.ConnectionState{
+		HandshakeComplete:  state.HandshakeComplete,
+		ServerName:         state.ServerName,
+		NegotiatedProtocol: state.NegotiatedProtocol,
+		PeerCertificates:   state.PeerCertificates,
+		VerifiedChains:     state.VerifiedChains,
+	}
+}
+
+// serveRequest runs the handler for a request in a new go routine
+func (s *Server) serveRequest(session quic.Session, req *http.Request, responseWriter *responseWriter, streamEnded bool) {
+	dataStream := responseWriter.dataStream
+
+	s.activeRequestsMutex.Lock()
+	if s.closing {
+		s.activeRequestsMutex.Unlock()
+		utils.Infof("Server is shutting down. Refusing request on data stream %d", responseWriter.dataStreamID)
+		dataStream.Reset(errServerShuttingDown)
+		return
+	}
+	s.activeRequests.Add(1)
+	s.activeRequestsMutex.Unlock()
+
+	if streamEnded {
 		dataStream.(remoteCloser).CloseRemote(0)
-		streamEnded = true
 		_, _ = dataStream.Read([]byte{0}) // read the eof
 	}
 
 	reqBody := newRequestBody(dataStream)
+	// like net/http, tell the client to send the body once the handler starts reading it
+	if !streamEnded && strings.EqualFold(req.Header.Get("Expect"), "100-continue") {
+		reqBody.sendContinue = responseWriter.writeContinue
+	}
 	req.Body = reqBody
-
-	responseWriter := newResponseWriter(headerStream, headerStreamMutex, dataStream, protocol.StreamID(h2headersFrame.StreamID))
+	req.TLS = tlsConnectionState(session.ConnectionState())
+	// the context is cancelled when the client resets the stream, the session is closed, or the handler returns
+	ctx, cancel := context.WithCancel(dataStream.Context())
+	req = req.WithContext(ctx)
 
 	go func() {
+		defer s.activeRequests.Done()
+		defer cancel()
 		handler := s.Handler
 		if handler == nil {
 			handler = http.DefaultServeMux
@@ -213,11 +376,16 @@ func (s *Server) handleRequest(session streamCreator, headerStream quic.Stream,
 			}()
 			handler.ServeHTTP(responseWriter, req)
 		}()
+		if responseWriter.hijacked {
+			// the handler is now responsible for the data stream
+			return
+		}
 		if panicked {
 			responseWriter.WriteHeader(500)
 		} else {
 			responseWriter.WriteHeader(200)
 		}
+		responseWriter.writeTrailers()
 		if responseWriter.dataStream != nil {
 			if !streamEnded && !reqBody.requestRead {
 				responseWriter.dataStream.Reset(nil)
@@ -229,8 +397,6 @@ func (s *Server) handleRequest(session streamCreator, headerStream quic.Stream,
 			session.Close(nil)
 		}
 	}()
-
-	return nil
 }
 
 // Close the server immediately, aborting requests and sending CONNECTION_CLOSE frames to connected clients.
@@ -246,11 +412,61 @@ func (s *Server) Close() error {
 	return nil
 }
 
-// CloseGracefully shuts down the server gracefully. The server sends a GOAWAY frame first, then waits for either timeout to trigger, or for all running requests to complete.
-// CloseGracefully in combination with ListenAndServe() (instead of Serve()) may race if it is called before a UDP socket is established.
+// NumActiveSessions returns the number of QUIC sessions of the server that are not closed yet.
+// It returns 0 if the server is not listening.
+func (s *Server) NumActiveSessions() int {
+	s.listenerMutex.Lock()
+	ln := s.listener
+	s.listenerMutex.Unlock()
+	if ln == nil {
+		return 0
+	}
+	return ln.NumActiveSessions()
+}
+
+// Range calls f for every QUIC session of the server that is not closed yet, until f returns false.
+// It can be used to display statistics about the connections, or to close sessions.
+func (s *Server) Range(f func(quic.Session) bool) {
+	s.listenerMutex.Lock()
+	ln := s.listener
+	s.listenerMutex.Unlock()
+	if ln == nil {
+		return
+	}
+	ln.Range(f)
+}
+
+// Shutdown shuts down the server gracefully. New sessions and new requests are refused, and the server waits for all running requests to complete before closing all connections.
+// Unlike net/http, the listener can't be closed first: all sessions share its packet conn, and closing it closes every session.
+// Instead, sessions accepted after Shutdown was called are closed immediately.
+// If ctx is cancelled before all requests have completed, the server is closed immediately and ctx.Err() is returned.
+// Shutdown in combination with ListenAndServe() (instead of Serve()) may race if it is called before a UDP socket is established.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.activeRequestsMutex.Lock()
+	s.closing = true
+	s.activeRequestsMutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.activeRequests.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return s.Close()
+	case <-ctx.Done():
+		_ = s.Close()
+		return ctx.Err()
+	}
+}
+
+// CloseGracefully shuts down the server gracefully. It waits for either timeout to trigger, or for all running requests to complete.
+// See Shutdown for details.
 func (s *Server) CloseGracefully(timeout time.Duration) error {
-	// TODO: implement
-	return nil
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return s.Shutdown(ctx)
 }
 
 // SetQuicHeaders can be used to set the proper headers that announce that this server supports QUIC.
@@ -275,9 +491,13 @@ func (s *Server) SetQuicHeaders(hdr http.Header) error {
 	}
 
 	if s.supportedVersionsAsString == "" {
-		for i, v := range protocol.SupportedVersions {
+		versions := protocol.SupportedVersions
+		if s.QuicConfig != nil && len(s.QuicConfig.Versions) > 0 {
+			versions = s.QuicConfig.Versions
+		}
+		for i, v := range versions {
 			s.supportedVersionsAsString += strconv.Itoa(int(v))
-			if i != len(protocol.SupportedVersions)-1 {
+			if i != len(versions)-1 {
 				s.supportedVersionsAsString += ","
 			}
 		}
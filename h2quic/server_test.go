@@ -2,11 +2,17 @@ package h2quic
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
 	"runtime"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -31,12 +37,14 @@ type mockSession struct {
 	streamToOpen        quic.Stream
 	blockOpenStreamSync bool
 	streamOpenErr       error
+	ctx                 context.Context
+	connState           quic.ConnectionState
 }
 
 func (s *mockSession) GetOrOpenStream(id protocol.StreamID) (quic.Stream, error) {
 	return s.dataStream, nil
 }
-func (s *mockSession) AcceptStream() (quic.Stream, error) {
+func (s *mockSession) AcceptStream(ctx context.Context) (quic.Stream, error) {
 	return s.streamToAccept, nil
 }
 func (s *mockSession) OpenStream() (quic.Stream, error) {
@@ -56,12 +64,36 @@ func (s *mockSession) Close(e error) error {
 	s.closedWithError = e
 	return nil
 }
+func (s *mockSession) CloseWithError(code qerr.ErrorCode, reason string) error {
+	return s.Close(qerr.Error(code, reason))
+}
 func (s *mockSession) LocalAddr() net.Addr {
 	panic("not implemented")
 }
 func (s *mockSession) RemoteAddr() net.Addr {
 	return &net.UDPAddr{IP: []byte{127, 0, 0, 1}, Port: 42}
 }
+func (s *mockSession) MigrateTo(net.PacketConn) error {
+	panic("not implemented")
+}
+func (s *mockSession) Stats() quic.SessionStats {
+	panic("not implemented")
+}
+func (s *mockSession) GetRTTStats() quic.RTTStats {
+	panic("not implemented")
+}
+func (s *mockSession) HandshakeComplete() <-chan struct{} {
+	panic("not implemented")
+}
+func (s *mockSession) Context() context.Context {
+	return s.ctx
+}
+func (s *mockSession) GetVersion() protocol.VersionNumber {
+	panic("not implemented")
+}
+func (s *mockSession) ConnectionState() quic.ConnectionState {
+	return s.connState
+}
 
 var _ = Describe("H2 server", func() {
 	var (
@@ -76,21 +108,23 @@ var _ = Describe("H2 server", func() {
 				TLSConfig: testdata.GetTLSConfig(),
 			},
 		}
-		dataStream = &mockStream{}
+		dataStream = newMockStream(0)
 		session = &mockSession{dataStream: dataStream}
 	})
 
 	Context("handling requests", func() {
 		var (
-			h2framer     *http2.Framer
-			hpackDecoder *hpack.Decoder
-			headerStream *mockStream
+			h2framer       *http2.Framer
+			hpackDecoder   *hpack.Decoder
+			headerStream   *mockStream
+			clientSettings *peerSettings
 		)
 
 		BeforeEach(func() {
 			headerStream = &mockStream{}
 			hpackDecoder = hpack.NewDecoder(4096, nil)
 			h2framer = http2.NewFramer(nil, headerStream)
+			clientSettings = newPeerSettings()
 		})
 
 		It("handles a sample GET request", func() {
@@ -106,13 +140,141 @@ var _ = Describe("H2 server", func() {
 				// Taken from https://http2.github.io/http2-spec/compression.html#request.examples.with.huffman.coding
 				0x82, 0x86, 0x84, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff,
 			})
-			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer, clientSettings)
 			Expect(err).NotTo(HaveOccurred())
 			Eventually(func() bool { return handlerCalled }).Should(BeTrue())
 			Expect(dataStream.remoteClosed).To(BeTrue())
 			Expect(dataStream.reset).To(BeFalse())
 		})
 
+		It("exposes the connection state, including the client certificate", func() {
+			clientCert := &x509.Certificate{Raw: []byte("client cert")}
+			session.connState = quic.ConnectionState{
+				HandshakeComplete:  true,
+				ServerName:         "www.example.com",
+				NegotiatedProtocol: "h2",
+				PeerCertificates:   []*x509.Certificate{clientCert},
+				VerifiedChains:     [][]*x509.Certificate{{clientCert}},
+			}
+			reqChan := make(chan *http.Request, 1)
+			s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				reqChan <- r
+			})
+			headerStream.dataToRead.Write([]byte{
+				0x0, 0x0, 0x11, 0x1, 0x5, 0x0, 0x0, 0x0, 0x5,
+				// Taken from https://http2.github.io/http2-spec/compression.html#request.examples.with.huffman.coding
+				0x82, 0x86, 0x84, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff,
+			})
+			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer, clientSettings)
+			Expect(err).NotTo(HaveOccurred())
+			var req *http.Request
+			Eventually(reqChan).Should(Receive(&req))
+			Expect(req.TLS).ToNot(BeNil())
+			Expect(req.TLS.HandshakeComplete).To(BeTrue())
+			Expect(req.TLS.ServerName).To(Equal("www.example.com"))
+			Expect(req.TLS.NegotiatedProtocol).To(Equal("h2"))
+			Expect(req.TLS.PeerCertificates).To(Equal([]*x509.Certificate{clientCert}))
+			Expect(req.TLS.VerifiedChains).To(Equal([][]*x509.Certificate{{clientCert}}))
+		})
+
+		Context("server push", func() {
+			var pushStream *mockStream
+
+			BeforeEach(func() {
+				pushStream = &mockStream{id: 2}
+				session.streamToOpen = pushStream
+				// a GET request for / on stream 5
+				headerStream.dataToRead.Write([]byte{
+					0x0, 0x0, 0x11, 0x1, 0x5, 0x0, 0x0, 0x0, 0x5,
+					// Taken from https://http2.github.io/http2-spec/compression.html#request.examples.with.huffman.coding
+					0x82, 0x86, 0x84, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff,
+				})
+			})
+
+			It("pushes a resource", func() {
+				pushedReqChan := make(chan *http.Request, 1)
+				s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					defer GinkgoRecover()
+					if r.URL.Path == "/style.css" {
+						pushedReqChan <- r
+						w.Write([]byte("foobar"))
+						return
+					}
+					err := w.(http.Pusher).Push("/style.css", &http.PushOptions{Header: http.Header{"Foo": {"bar"}}})
+					Expect(err).ToNot(HaveOccurred())
+				})
+				err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer, clientSettings)
+				Expect(err).NotTo(HaveOccurred())
+				var pushedReq *http.Request
+				Eventually(pushedReqChan).Should(Receive(&pushedReq))
+				Expect(pushedReq.Method).To(Equal("GET"))
+				Expect(pushedReq.Host).To(Equal("www.example.com"))
+				Expect(pushedReq.Header.Get("Foo")).To(Equal("bar"))
+				Eventually(func() bool { return pushStream.closed }).Should(BeTrue())
+				Expect(pushStream.remoteClosed).To(BeTrue())
+				Expect(pushStream.dataWritten.Bytes()).To(Equal([]byte("foobar")))
+
+				// the first frame on the header stream is the PUSH_PROMISE
+				framer := http2.NewFramer(nil, bytes.NewReader(headerStream.dataWritten.Bytes()))
+				frame, err := framer.ReadFrame()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(frame).To(BeAssignableToTypeOf(&http2.PushPromiseFrame{}))
+				ppframe := frame.(*http2.PushPromiseFrame)
+				Expect(ppframe.StreamID).To(BeEquivalentTo(5))
+				Expect(ppframe.PromiseID).To(BeEquivalentTo(2))
+				fields, err := hpack.NewDecoder(4096, nil).DecodeFull(ppframe.HeaderBlockFragment())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(fields).To(ContainElement(hpack.HeaderField{Name: ":path", Value: "/style.css"}))
+				Expect(fields).To(ContainElement(hpack.HeaderField{Name: ":authority", Value: "www.example.com"}))
+			})
+
+			It("doesn't push if the client disabled push", func() {
+				pushErr := make(chan error, 1)
+				s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					pushErr <- w.(http.Pusher).Push("/style.css", nil)
+				})
+				var settings bytes.Buffer
+				Expect(http2.NewFramer(&settings, nil).WriteSettings(http2.Setting{ID: http2.SettingEnablePush, Val: 0})).To(Succeed())
+				headerStream.dataToRead.Reset()
+				headerStream.dataToRead.Write(settings.Bytes())
+				err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer, clientSettings)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(clientSettings.isPushEnabled()).To(BeFalse())
+				headerStream.dataToRead.Write([]byte{
+					0x0, 0x0, 0x11, 0x1, 0x5, 0x0, 0x0, 0x0, 0x5,
+					0x82, 0x86, 0x84, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff,
+				})
+				err = s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer, clientSettings)
+				Expect(err).NotTo(HaveOccurred())
+				Eventually(pushErr).Should(Receive(Equal(http.ErrNotSupported)))
+				Expect(pushStream.dataWritten.Len()).To(BeZero())
+			})
+
+			It("errors on invalid SETTINGS", func() {
+				var settings bytes.Buffer
+				Expect(http2.NewFramer(&settings, nil).WriteSettings(http2.Setting{ID: http2.SettingEnablePush, Val: 2})).To(Succeed())
+				headerStream.dataToRead.Reset()
+				headerStream.dataToRead.Write(settings.Bytes())
+				err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer, clientSettings)
+				Expect(err).To(HaveOccurred())
+				Expect(err.(*qerr.QuicError).ErrorCode).To(Equal(qerr.InvalidHeadersStreamData))
+			})
+
+			It("doesn't push from a pushed response", func() {
+				pushErr := make(chan error, 1)
+				s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if r.URL.Path == "/style.css" {
+						pushErr <- w.(http.Pusher).Push("/image.png", nil)
+						return
+					}
+					w.(http.Pusher).Push("/style.css", nil)
+				})
+				err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer, clientSettings)
+				Expect(err).NotTo(HaveOccurred())
+				Eventually(pushErr).Should(Receive(Equal(http.ErrNotSupported)))
+			})
+		})
+
 		It("returns 200 with an empty handler", func() {
 			s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
 			headerStream.dataToRead.Write([]byte{
@@ -120,7 +282,7 @@ var _ = Describe("H2 server", func() {
 				// Taken from https://http2.github.io/http2-spec/compression.html#request.examples.with.huffman.coding
 				0x82, 0x86, 0x84, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff,
 			})
-			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer, clientSettings)
 			Expect(err).NotTo(HaveOccurred())
 			Eventually(func() []byte {
 				return headerStream.dataWritten.Bytes()
@@ -136,7 +298,7 @@ var _ = Describe("H2 server", func() {
 				// Taken from https://http2.github.io/http2-spec/compression.html#request.examples.with.huffman.coding
 				0x82, 0x86, 0x84, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff,
 			})
-			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer, clientSettings)
 			Expect(err).NotTo(HaveOccurred())
 			Eventually(func() []byte {
 				return headerStream.dataWritten.Bytes()
@@ -154,7 +316,7 @@ var _ = Describe("H2 server", func() {
 				// Taken from https://http2.github.io/http2-spec/compression.html#request.examples.with.huffman.coding
 				0x82, 0x86, 0x84, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff,
 			})
-			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer, clientSettings)
 			Expect(err).NotTo(HaveOccurred())
 			Eventually(func() bool { return handlerCalled }).Should(BeTrue())
 			Eventually(func() bool { return dataStream.reset }).Should(BeTrue())
@@ -169,13 +331,51 @@ var _ = Describe("H2 server", func() {
 				handlerCalled = true
 			})
 			headerStream.dataToRead.Write([]byte{0x0, 0x0, 0x20, 0x1, 0x24, 0x0, 0x0, 0x0, 0x5, 0x0, 0x0, 0x0, 0x0, 0xff, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff, 0x83, 0x84, 0x87, 0x5c, 0x1, 0x37, 0x7a, 0x85, 0xed, 0x69, 0x88, 0xb4, 0xc7})
-			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer, clientSettings)
 			Expect(err).NotTo(HaveOccurred())
 			Eventually(func() bool { return dataStream.reset }).Should(BeTrue())
 			Consistently(func() bool { return dataStream.remoteClosed }).Should(BeFalse())
 			Expect(handlerCalled).To(BeTrue())
 		})
 
+		It("cancels the request context when the client resets the data stream", func() {
+			handlerCalled := make(chan struct{})
+			ctxDone := make(chan struct{})
+			s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				close(handlerCalled)
+				<-r.Context().Done()
+				close(ctxDone)
+			})
+			headerStream.dataToRead.Write([]byte{
+				0x0, 0x0, 0x11, 0x1, 0x5, 0x0, 0x0, 0x0, 0x5,
+				// Taken from https://http2.github.io/http2-spec/compression.html#request.examples.with.huffman.coding
+				0x82, 0x86, 0x84, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff,
+			})
+			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer, clientSettings)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(handlerCalled).Should(BeClosed())
+			Consistently(ctxDone).ShouldNot(BeClosed())
+			dataStream.cancelContext()
+			Eventually(ctxDone).Should(BeClosed())
+		})
+
+		It("cancels the request context when the handler returns", func() {
+			ctxChan := make(chan context.Context, 1)
+			s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ctxChan <- r.Context()
+			})
+			headerStream.dataToRead.Write([]byte{
+				0x0, 0x0, 0x11, 0x1, 0x5, 0x0, 0x0, 0x0, 0x5,
+				// Taken from https://http2.github.io/http2-spec/compression.html#request.examples.with.huffman.coding
+				0x82, 0x86, 0x84, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff,
+			})
+			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer, clientSettings)
+			Expect(err).NotTo(HaveOccurred())
+			var ctx context.Context
+			Eventually(ctxChan).Should(Receive(&ctx))
+			Eventually(ctx.Done()).Should(BeClosed())
+		})
+
 		It("handles a request for which the client immediately resets the data stream", func() {
 			session.dataStream = nil
 			var handlerCalled bool
@@ -187,7 +387,7 @@ var _ = Describe("H2 server", func() {
 				// Taken from https://http2.github.io/http2-spec/compression.html#request.examples.with.huffman.coding
 				0x82, 0x86, 0x84, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff,
 			})
-			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer, clientSettings)
 			Expect(err).NotTo(HaveOccurred())
 			Consistently(func() bool { return handlerCalled }).Should(BeFalse())
 		})
@@ -202,7 +402,7 @@ var _ = Describe("H2 server", func() {
 				handlerCalled = true
 			})
 			headerStream.dataToRead.Write([]byte{0x0, 0x0, 0x20, 0x1, 0x24, 0x0, 0x0, 0x0, 0x5, 0x0, 0x0, 0x0, 0x0, 0xff, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff, 0x83, 0x84, 0x87, 0x5c, 0x1, 0x37, 0x7a, 0x85, 0xed, 0x69, 0x88, 0xb4, 0xc7})
-			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer, clientSettings)
 			Expect(err).NotTo(HaveOccurred())
 			Eventually(func() bool { return dataStream.reset }).Should(BeTrue())
 			Consistently(func() bool { return dataStream.remoteClosed }).Should(BeFalse())
@@ -222,18 +422,271 @@ var _ = Describe("H2 server", func() {
 			})
 			headerStream.dataToRead.Write([]byte{0x0, 0x0, 0x20, 0x1, 0x24, 0x0, 0x0, 0x0, 0x5, 0x0, 0x0, 0x0, 0x0, 0xff, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff, 0x83, 0x84, 0x87, 0x5c, 0x1, 0x37, 0x7a, 0x85, 0xed, 0x69, 0x88, 0xb4, 0xc7})
 			dataStream.dataToRead.Write([]byte("foo=bar"))
-			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer, clientSettings)
 			Expect(err).NotTo(HaveOccurred())
 			Eventually(func() bool { return handlerCalled }).Should(BeTrue())
 			Expect(dataStream.reset).To(BeFalse())
 		})
 
+		It("hands the data stream of a CONNECT request to the handler, if it hijacks it", func() {
+			hijacked := make(chan quic.Stream, 1)
+			handlerReturned := make(chan struct{})
+			s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				defer GinkgoRecover()
+				defer close(handlerReturned)
+				Expect(r.Method).To(Equal("CONNECT"))
+				Expect(r.Host).To(Equal("www.example.com:443"))
+				str, err := w.(StreamHijacker).HijackStream()
+				Expect(err).ToNot(HaveOccurred())
+				hijacked <- str
+			})
+			var headers bytes.Buffer
+			enc := hpack.NewEncoder(&headers)
+			enc.WriteField(hpack.HeaderField{Name: ":method", Value: "CONNECT"})
+			enc.WriteField(hpack.HeaderField{Name: ":authority", Value: "www.example.com:443"})
+			Expect(http2.NewFramer(&headerStream.dataToRead, nil).WriteHeaders(http2.HeadersFrameParam{
+				StreamID:      5,
+				EndHeaders:    true,
+				BlockFragment: headers.Bytes(),
+			})).To(Succeed())
+			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer, clientSettings)
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(hijacked).Should(Receive(Equal(dataStream)))
+			Eventually(handlerReturned).Should(BeClosed())
+			// the server doesn't close the stream after the handler returned
+			Consistently(func() bool { return dataStream.closed }).Should(BeFalse())
+			Expect(dataStream.reset).To(BeFalse())
+		})
+
+		Context("Expect: 100-continue", func() {
+			BeforeEach(func() {
+				var headers bytes.Buffer
+				enc := hpack.NewEncoder(&headers)
+				enc.WriteField(hpack.HeaderField{Name: ":method", Value: "POST"})
+				enc.WriteField(hpack.HeaderField{Name: ":scheme", Value: "https"})
+				enc.WriteField(hpack.HeaderField{Name: ":authority", Value: "www.example.com"})
+				enc.WriteField(hpack.HeaderField{Name: ":path", Value: "/upload"})
+				enc.WriteField(hpack.HeaderField{Name: "expect", Value: "100-continue"})
+				Expect(http2.NewFramer(&headerStream.dataToRead, nil).WriteHeaders(http2.HeadersFrameParam{
+					StreamID:      5,
+					EndHeaders:    true,
+					BlockFragment: headers.Bytes(),
+				})).To(Succeed())
+				dataStream.dataToRead.Write([]byte("foobar"))
+			})
+
+			getStatusCodes := func() []string {
+				var statusCodes []string
+				decoder := hpack.NewDecoder(4096, nil)
+				h2framer := http2.NewFramer(nil, bytes.NewReader(headerStream.dataWritten.Bytes()))
+				for {
+					frame, err := h2framer.ReadFrame()
+					if err != nil {
+						return statusCodes
+					}
+					fields, err := decoder.DecodeFull(frame.(*http2.HeadersFrame).HeaderBlockFragment())
+					Expect(err).ToNot(HaveOccurred())
+					for _, f := range fields {
+						if f.Name == ":status" {
+							statusCodes = append(statusCodes, f.Value)
+						}
+					}
+				}
+			}
+
+			It("sends a 100 (Continue) when the handler reads the body", func() {
+				handlerReturned := make(chan struct{})
+				s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					defer GinkgoRecover()
+					defer close(handlerReturned)
+					body, err := ioutil.ReadAll(r.Body)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(body).To(Equal([]byte("foobar")))
+				})
+				err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer, clientSettings)
+				Expect(err).ToNot(HaveOccurred())
+				Eventually(handlerReturned).Should(BeClosed())
+				Eventually(getStatusCodes).Should(Equal([]string{"100", "200"}))
+			})
+
+			It("doesn't send a 100 (Continue) if the handler rejects the request without reading the body", func() {
+				handlerReturned := make(chan struct{})
+				s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					defer close(handlerReturned)
+					w.WriteHeader(http.StatusExpectationFailed)
+				})
+				err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer, clientSettings)
+				Expect(err).ToNot(HaveOccurred())
+				Eventually(handlerReturned).Should(BeClosed())
+				Eventually(getStatusCodes).Should(Equal([]string{"417"}))
+			})
+		})
+
+		It("stops managing the data stream once the handler hijacked it", func() {
+			handlerReturned := make(chan struct{})
+			s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				defer GinkgoRecover()
+				defer close(handlerReturned)
+				w.Header().Set("X-Protocol", "echo")
+				str, err := w.(StreamHijacker).HijackStream()
+				Expect(err).ToNot(HaveOccurred())
+				// echo all data sent by the client
+				data, err := ioutil.ReadAll(str)
+				Expect(err).ToNot(HaveOccurred())
+				_, err = str.Write(data)
+				Expect(err).ToNot(HaveOccurred())
+				// the ResponseWriter can't be used anymore
+				_, err = w.Write([]byte("foobar"))
+				Expect(err).To(MatchError(http.ErrHijacked))
+			})
+			headerStream.dataToRead.Write([]byte{
+				0x0, 0x0, 0x11, 0x1, 0x4, 0x0, 0x0, 0x0, 0x5,
+				// Taken from https://http2.github.io/http2-spec/compression.html#request.examples.with.huffman.coding
+				0x82, 0x86, 0x84, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff,
+			})
+			dataStream.dataToRead.Write([]byte("echo this"))
+			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer, clientSettings)
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(handlerReturned).Should(BeClosed())
+			Expect(dataStream.dataWritten.Bytes()).To(Equal([]byte("echo this")))
+			// the server neither resets nor closes a hijacked stream
+			Consistently(func() bool { return dataStream.reset || dataStream.closed }).Should(BeFalse())
+			// only the response headers were sent, no trailers
+			frame, err := http2.NewFramer(nil, &headerStream.dataWritten).ReadFrame()
+			Expect(err).ToNot(HaveOccurred())
+			fields, err := hpack.NewDecoder(4096, nil).DecodeFull(frame.(*http2.HeadersFrame).HeaderBlockFragment())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fields).To(ContainElement(hpack.HeaderField{Name: ":status", Value: "200"}))
+			Expect(fields).To(ContainElement(hpack.HeaderField{Name: "x-protocol", Value: "echo"}))
+			Expect(headerStream.dataWritten.Len()).To(BeZero())
+		})
+
+		Context("limiting the size of request headers", func() {
+			var handlerCalled bool
+
+			BeforeEach(func() {
+				handlerCalled = false
+				s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					handlerCalled = true
+				})
+			})
+
+			writeRequestWithEndStream := func(endStream bool, fields ...hpack.HeaderField) {
+				var headers bytes.Buffer
+				enc := hpack.NewEncoder(&headers)
+				enc.WriteField(hpack.HeaderField{Name: ":method", Value: "GET"})
+				enc.WriteField(hpack.HeaderField{Name: ":scheme", Value: "https"})
+				enc.WriteField(hpack.HeaderField{Name: ":authority", Value: "www.example.com"})
+				enc.WriteField(hpack.HeaderField{Name: ":path", Value: "/"})
+				for _, f := range fields {
+					enc.WriteField(f)
+				}
+				Expect(http2.NewFramer(&headerStream.dataToRead, nil).WriteHeaders(http2.HeadersFrameParam{
+					StreamID:      5,
+					EndHeaders:    true,
+					EndStream:     endStream,
+					BlockFragment: headers.Bytes(),
+				})).To(Succeed())
+			}
+
+			writeRequest := func(fields ...hpack.HeaderField) {
+				writeRequestWithEndStream(true, fields...)
+			}
+
+			expectRejected := func() {
+				Consistently(func() bool { return handlerCalled }).Should(BeFalse())
+				frame, err := http2.NewFramer(nil, &headerStream.dataWritten).ReadFrame()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(frame.Header().StreamID).To(BeEquivalentTo(5))
+				fields, err := hpack.NewDecoder(4096, nil).DecodeFull(frame.(*http2.HeadersFrame).HeaderBlockFragment())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(fields).To(ContainElement(hpack.HeaderField{Name: ":status", Value: "431"}))
+				Expect(dataStream.closed).To(BeTrue())
+			}
+
+			It("rejects requests with headers larger than MaxHeaderBytes", func() {
+				s.MaxHeaderBytes = 1000
+				var fields []hpack.HeaderField
+				for i := 0; i < 10; i++ {
+					fields = append(fields, hpack.HeaderField{Name: "foo" + strconv.Itoa(i), Value: strings.Repeat("a", 200)})
+				}
+				writeRequest(fields...)
+				err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer, clientSettings)
+				Expect(err).ToNot(HaveOccurred())
+				expectRejected()
+				Expect(dataStream.reset).To(BeFalse())
+			})
+
+			It("resets the data stream when rejecting a request that has a body", func() {
+				s.MaxHeaderBytes = 1000
+				var fields []hpack.HeaderField
+				for i := 0; i < 10; i++ {
+					fields = append(fields, hpack.HeaderField{Name: "foo" + strconv.Itoa(i), Value: strings.Repeat("a", 200)})
+				}
+				writeRequestWithEndStream(false, fields...)
+				err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer, clientSettings)
+				Expect(err).ToNot(HaveOccurred())
+				expectRejected()
+				Expect(dataStream.reset).To(BeTrue())
+			})
+
+			It("rejects header blocks that decode to a huge header list", func() {
+				// every repetition of the header field is encoded as a 1 byte reference to the dynamic table
+				// the decoded header list would be about 40 MB large
+				fields := make([]hpack.HeaderField, 10000)
+				for i := range fields {
+					fields[i] = hpack.HeaderField{Name: "foo", Value: strings.Repeat("a", 4000)}
+				}
+				writeRequest(fields...)
+				Expect(headerStream.dataToRead.Len()).To(BeNumerically("<", 20000))
+				err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer, clientSettings)
+				Expect(err).ToNot(HaveOccurred())
+				expectRejected()
+			})
+
+			It("keeps the HPACK state in sync after rejecting a request", func() {
+				s.MaxHeaderBytes = 1000
+				s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					defer GinkgoRecover()
+					Expect(r.Header.Get("foo")).To(Equal("bar"))
+					handlerCalled = true
+				})
+				var headers bytes.Buffer
+				enc := hpack.NewEncoder(&headers)
+				writeHeaders := func(id uint32, fields ...hpack.HeaderField) {
+					headers.Reset()
+					enc.WriteField(hpack.HeaderField{Name: ":method", Value: "GET"})
+					enc.WriteField(hpack.HeaderField{Name: ":scheme", Value: "https"})
+					enc.WriteField(hpack.HeaderField{Name: ":authority", Value: "www.example.com"})
+					enc.WriteField(hpack.HeaderField{Name: ":path", Value: "/"})
+					for _, f := range fields {
+						enc.WriteField(f)
+					}
+					Expect(http2.NewFramer(&headerStream.dataToRead, nil).WriteHeaders(http2.HeadersFrameParam{
+						StreamID:      id,
+						EndHeaders:    true,
+						EndStream:     true,
+						BlockFragment: headers.Bytes(),
+					})).To(Succeed())
+				}
+				writeHeaders(5, hpack.HeaderField{Name: "foo", Value: "bar"}, hpack.HeaderField{Name: "large", Value: strings.Repeat("a", 1200)})
+				err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer, clientSettings)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(handlerCalled).To(BeFalse())
+				// the second request references the entries that the first request inserted into the dynamic table
+				writeHeaders(7, hpack.HeaderField{Name: "foo", Value: "bar"})
+				err = s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer, clientSettings)
+				Expect(err).ToNot(HaveOccurred())
+				Eventually(func() bool { return handlerCalled }).Should(BeTrue())
+			})
+		})
+
 		It("errors when non-header frames are received", func() {
 			headerStream.dataToRead.Write([]byte{
 				0x0, 0x0, 0x06, 0x0, 0x0, 0x0, 0x0, 0x0, 0x5,
 				'f', 'o', 'o', 'b', 'a', 'r',
 			})
-			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer, clientSettings)
 			Expect(err).To(MatchError("InvalidHeadersStreamData: expected a header frame"))
 		})
 	})
@@ -255,6 +708,26 @@ var _ = Describe("H2 server", func() {
 		Eventually(func() bool { return handlerCalled }).Should(BeTrue())
 	})
 
+	It("sends its SETTINGS on the header stream", func() {
+		s.QuicConfig = &quic.Config{MaxIncomingStreams: 42}
+		headerStream := &mockStream{id: 3}
+		session.streamToAccept = headerStream
+		s.handleHeaderStream(session)
+		frame, err := http2.NewFramer(nil, &headerStream.dataWritten).ReadFrame()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(frame).To(BeAssignableToTypeOf(&http2.SettingsFrame{}))
+		settings := frame.(*http2.SettingsFrame)
+		val, ok := settings.Value(http2.SettingMaxConcurrentStreams)
+		Expect(ok).To(BeTrue())
+		Expect(val).To(BeEquivalentTo(42))
+		val, ok = settings.Value(http2.SettingHeaderTableSize)
+		Expect(ok).To(BeTrue())
+		Expect(val).To(BeEquivalentTo(headerTableSize))
+		val, ok = settings.Value(http2.SettingMaxHeaderListSize)
+		Expect(ok).To(BeTrue())
+		Expect(val).To(Equal(s.maxHeaderListSize()))
+	})
+
 	It("closes the connection if it encounters an error on the header stream", func() {
 		var handlerCalled bool
 		s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -344,6 +817,18 @@ var _ = Describe("H2 server", func() {
 			Expect(hdr).To(Equal(expected))
 		})
 
+		It("uses the versions from the QUIC config", func() {
+			s.Server.Addr = ":443"
+			s.QuicConfig = &quic.Config{Versions: []protocol.VersionNumber{protocol.Version36, protocol.Version35}}
+			hdr := http.Header{}
+			err := s.SetQuicHeaders(hdr)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hdr).To(Equal(http.Header{
+				"Alt-Svc":            {`quic=":443"; ma=2592000; v="36,35"`},
+				"Alternate-Protocol": {`443:quic`},
+			}))
+		})
+
 		It("works multiple times", func() {
 			s.Server.Addr = ":https"
 			hdr := http.Header{}
@@ -429,11 +914,190 @@ var _ = Describe("H2 server", func() {
 		}, 0.5)
 	})
 
+	Context("active sessions", func() {
+		var serverAddr string
+
+		BeforeEach(func() {
+			conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			Expect(err).ToNot(HaveOccurred())
+			serverAddr = conn.LocalAddr().String()
+			go func() {
+				defer GinkgoRecover()
+				_ = s.Serve(conn)
+			}()
+			Eventually(func() quic.Listener {
+				s.listenerMutex.Lock()
+				defer s.listenerMutex.Unlock()
+				return s.listener
+			}).ShouldNot(BeNil())
+		})
+
+		AfterEach(func() {
+			Expect(s.Close()).To(Succeed())
+		})
+
+		It("returns 0 if the server is not listening", func() {
+			Expect((&Server{}).NumActiveSessions()).To(BeZero())
+			var called bool
+			(&Server{}).Range(func(quic.Session) bool {
+				called = true
+				return true
+			})
+			Expect(called).To(BeFalse())
+		})
+
+		It("counts the active sessions", func() {
+			var sessions []quic.Session
+			for i := 0; i < 3; i++ {
+				sess, err := quic.DialAddr(serverAddr, &quic.Config{TLSConfig: &tls.Config{InsecureSkipVerify: true}})
+				Expect(err).ToNot(HaveOccurred())
+				sessions = append(sessions, sess)
+			}
+			Eventually(s.NumActiveSessions).Should(Equal(3))
+			var n int
+			s.Range(func(quic.Session) bool {
+				n++
+				return true
+			})
+			Expect(n).To(Equal(3))
+
+			Expect(sessions[0].Close(nil)).To(Succeed())
+			Eventually(s.NumActiveSessions).Should(Equal(2))
+			Consistently(s.NumActiveSessions).Should(Equal(2))
+
+			for _, sess := range sessions[1:] {
+				Expect(sess.Close(nil)).To(Succeed())
+			}
+		})
+
+		It("closes sessions while ranging", func() {
+			for i := 0; i < 3; i++ {
+				_, err := quic.DialAddr(serverAddr, &quic.Config{TLSConfig: &tls.Config{InsecureSkipVerify: true}})
+				Expect(err).ToNot(HaveOccurred())
+			}
+			Eventually(s.NumActiveSessions).Should(Equal(3))
+			s.Range(func(sess quic.Session) bool {
+				Expect(sess.Close(nil)).To(Succeed())
+				return true
+			})
+			Eventually(s.NumActiveSessions).Should(BeZero())
+		})
+	})
+
+	It("stops sending when the client closes the response body early", func(done Done) {
+		writeErr := make(chan error, 1)
+		s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			data := bytes.Repeat([]byte("foobar"), 1000)
+			for {
+				if _, err := w.Write(data); err != nil {
+					writeErr <- err
+					return
+				}
+			}
+		})
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		Expect(err).ToNot(HaveOccurred())
+		go func() {
+			defer GinkgoRecover()
+			_ = s.Serve(conn)
+		}()
+		defer s.Close()
+
+		rt := &QuicRoundTripper{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+		defer rt.Close()
+		rsp, err := (&http.Client{Transport: rt}).Get("https://" + conn.LocalAddr().String() + "/")
+		Expect(err).ToNot(HaveOccurred())
+		_, err = io.ReadFull(rsp.Body, make([]byte, 10000))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rsp.Body.Close()).To(Succeed())
+		var err2 error
+		Eventually(writeErr, 2).Should(Receive(&err2))
+		Expect(err2).To(BeAssignableToTypeOf(&quic.StreamResetError{}))
+		close(done)
+	}, 5)
+
 	It("closes gracefully", func() {
-		err := s.CloseGracefully(0)
+		err := s.CloseGracefully(time.Second)
 		Expect(err).NotTo(HaveOccurred())
 	})
 
+	Context("shutting down", func() {
+		var (
+			h2framer     *http2.Framer
+			hpackDecoder *hpack.Decoder
+			headerStream *mockStream
+		)
+
+		BeforeEach(func() {
+			headerStream = &mockStream{}
+			hpackDecoder = hpack.NewDecoder(4096, nil)
+			h2framer = http2.NewFramer(nil, headerStream)
+			headerStream.dataToRead.Write([]byte{
+				0x0, 0x0, 0x11, 0x1, 0x5, 0x0, 0x0, 0x0, 0x5,
+				// Taken from https://http2.github.io/http2-spec/compression.html#request.examples.with.huffman.coding
+				0x82, 0x86, 0x84, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff,
+			})
+		})
+
+		It("refuses new requests", func() {
+			var handlerCalled bool
+			s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				handlerCalled = true
+			})
+			err := s.Shutdown(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			err = s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer, newPeerSettings())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dataStream.reset).To(BeTrue())
+			Consistently(func() bool { return handlerCalled }).Should(BeFalse())
+		})
+
+		It("refuses new sessions", func() {
+			err := s.Shutdown(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			session.streamToAccept = &mockStream{id: 3}
+			s.handleHeaderStream(session)
+			Expect(session.closed).To(BeTrue())
+			Expect(session.closedWithError).To(MatchError(errServerShuttingDown))
+		})
+
+		It("waits for running requests to complete", func() {
+			handlerChan := make(chan struct{})
+			s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				<-handlerChan
+				w.Write([]byte("foobar"))
+			})
+			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer, newPeerSettings())
+			Expect(err).ToNot(HaveOccurred())
+			var shutdownReturned bool
+			go func() {
+				defer GinkgoRecover()
+				err := s.Shutdown(context.Background())
+				Expect(err).ToNot(HaveOccurred())
+				shutdownReturned = true
+			}()
+			Consistently(func() bool { return shutdownReturned }).Should(BeFalse())
+			close(handlerChan)
+			Eventually(func() bool { return shutdownReturned }).Should(BeTrue())
+			Expect(dataStream.dataWritten.Bytes()).To(Equal([]byte("foobar")))
+			Expect(dataStream.reset).To(BeFalse())
+		})
+
+		It("returns when the context is cancelled", func() {
+			handlerChan := make(chan struct{})
+			defer close(handlerChan)
+			s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				<-handlerChan
+			})
+			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer, newPeerSettings())
+			Expect(err).ToNot(HaveOccurred())
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			err = s.Shutdown(ctx)
+			Expect(err).To(MatchError(context.DeadlineExceeded))
+		})
+	})
+
 	It("at least errors in global ListenAndServeQUIC", func() {
 		// It's quite hard to test this, since we cannot properly shutdown the server
 		// once it's started. So, we open a socket on the same port before the test,
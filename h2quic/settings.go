@@ -0,0 +1,89 @@
+package h2quic
+
+import (
+	"io"
+	"sync"
+
+	"golang.org/x/net/http2"
+
+	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/utils"
+)
+
+// headerTableSize is the size of the HPACK dynamic table of our decoders, announced in SETTINGS_HEADER_TABLE_SIZE
+const headerTableSize = 4096
+
+// peerSettings are the values the peer sent in its SETTINGS frames.
+// Flow control is done by QUIC, so SETTINGS_INITIAL_WINDOW_SIZE is accepted, but doesn't have any effect.
+type peerSettings struct {
+	mutex sync.RWMutex
+
+	headerTableSize      uint32
+	pushEnabled          bool
+	maxConcurrentStreams uint32 // 0 if the peer didn't set a limit
+	initialWindowSize    uint32
+}
+
+// newPeerSettings creates the settings that apply until the peer sent its SETTINGS
+func newPeerSettings() *peerSettings {
+	return &peerSettings{
+		headerTableSize:   4096,
+		pushEnabled:       true,
+		initialWindowSize: 65535,
+	}
+}
+
+// apply applies the values of a SETTINGS frame received from the peer
+func (s *peerSettings) apply(f *http2.SettingsFrame) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return f.ForeachSetting(func(setting http2.Setting) error {
+		if err := setting.Valid(); err != nil {
+			return err
+		}
+		utils.Debugf("Peer set %s", setting)
+		switch setting.ID {
+		case http2.SettingHeaderTableSize:
+			s.headerTableSize = setting.Val
+		case http2.SettingEnablePush:
+			s.pushEnabled = setting.Val != 0
+		case http2.SettingMaxConcurrentStreams:
+			s.maxConcurrentStreams = setting.Val
+		case http2.SettingInitialWindowSize:
+			s.initialWindowSize = setting.Val
+		}
+		return nil
+	})
+}
+
+func (s *peerSettings) getHeaderTableSize() uint32 {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.headerTableSize
+}
+
+func (s *peerSettings) isPushEnabled() bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.pushEnabled
+}
+
+func (s *peerSettings) getMaxConcurrentStreams() uint32 {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.maxConcurrentStreams
+}
+
+// writeSettings sends our SETTINGS on the header stream
+// SETTINGS_MAX_CONCURRENT_STREAMS is only sent if maxConcurrentStreams is not 0
+func writeSettings(headerStream io.Writer, maxConcurrentStreams uint32, additional ...http2.Setting) error {
+	settings := []http2.Setting{
+		{ID: http2.SettingHeaderTableSize, Val: headerTableSize},
+		{ID: http2.SettingInitialWindowSize, Val: uint32(protocol.ReceiveStreamFlowControlWindow)},
+	}
+	if maxConcurrentStreams > 0 {
+		settings = append(settings, http2.Setting{ID: http2.SettingMaxConcurrentStreams, Val: maxConcurrentStreams})
+	}
+	settings = append(settings, additional...)
+	return http2.NewFramer(headerStream, nil).WriteSettings(settings...)
+}
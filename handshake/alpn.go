@@ -0,0 +1,53 @@
+package handshake
+
+import (
+	"bytes"
+
+	"github.com/lucas-clemente/quic-go/qerr"
+)
+
+var errInvalidALPN = qerr.Error(qerr.InvalidCryptoMessageParameter, "invalid ALPN")
+
+// encodeALPN encodes the list of protocols for the ALPN tag.
+// It uses the same format as the TLS extension (RFC 7301): each protocol name is prefixed by its length (1 byte).
+// Empty protocol names and names longer than 255 bytes are skipped.
+func encodeALPN(protos []string) []byte {
+	b := &bytes.Buffer{}
+	for _, p := range protos {
+		if len(p) == 0 || len(p) > 255 {
+			continue
+		}
+		b.WriteByte(uint8(len(p)))
+		b.WriteString(p)
+	}
+	return b.Bytes()
+}
+
+// decodeALPN decodes the list of protocols sent in the ALPN tag
+func decodeALPN(data []byte) ([]string, error) {
+	var protos []string
+	for len(data) > 0 {
+		l := int(data[0])
+		data = data[1:]
+		if l == 0 || l > len(data) {
+			return nil, errInvalidALPN
+		}
+		protos = append(protos, string(data[:l]))
+		data = data[l:]
+	}
+	return protos, nil
+}
+
+// selectALPN returns the first protocol of the server's list that is also supported by the client.
+// As in crypto/tls, the server's preference wins.
+// It returns an empty string if there's no protocol supported by both.
+func selectALPN(serverProtos, clientProtos []string) string {
+	for _, s := range serverProtos {
+		for _, c := range clientProtos {
+			if s == c {
+				return s
+			}
+		}
+	}
+	return ""
+}
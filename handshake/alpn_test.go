@@ -0,0 +1,45 @@
+package handshake
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ALPN", func() {
+	It("encodes and decodes a list of protocols", func() {
+		protos := []string{"h2", "foo/1.0"}
+		data := encodeALPN(protos)
+		Expect(data).To(Equal([]byte("\x02h2\x07foo/1.0")))
+		decoded, err := decodeALPN(data)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(decoded).To(Equal(protos))
+	})
+
+	It("skips empty and too long protocol names when encoding", func() {
+		data := encodeALPN([]string{"", strings.Repeat("a", 256), "foo"})
+		Expect(data).To(Equal([]byte("\x03foo")))
+	})
+
+	It("errors on protocol names that are longer than the remaining data", func() {
+		_, err := decodeALPN([]byte("\x05foo"))
+		Expect(err).To(MatchError(errInvalidALPN))
+	})
+
+	It("errors on empty protocol names", func() {
+		_, err := decodeALPN([]byte("\x03foo\x00"))
+		Expect(err).To(MatchError(errInvalidALPN))
+	})
+
+	Context("selecting a protocol", func() {
+		It("uses the server's preference", func() {
+			Expect(selectALPN([]string{"foo", "bar"}, []string{"bar", "foo"})).To(Equal("foo"))
+		})
+
+		It("returns an empty string if there's no overlap", func() {
+			Expect(selectALPN([]string{"foo"}, []string{"bar"})).To(BeEmpty())
+			Expect(selectALPN(nil, []string{"bar"})).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,57 @@
+package handshake
+
+import (
+	"bytes"
+	"errors"
+)
+
+// tagCachedState is the tag of the handshake message used to serialize the cached server state (unofficial tag by us :)
+const tagCachedState Tag = 'Q' + 'C'<<8 + 'S'<<16 + 'T'<<24
+
+var errInvalidCachedState = errors.New("invalid cached server state")
+
+// cachedServerState is the state a client needs to perform a 0-RTT handshake with a server it has talked to before
+type cachedServerState struct {
+	scfg []byte // the raw server config
+	stk  []byte // the source-address token
+	cert []byte // the (compressed) certificate chain, as sent in the REJ
+	// the server proof, and the CHLO it was calculated for
+	proof []byte
+	chlo  []byte
+}
+
+func parseCachedServerState(data []byte) (*cachedServerState, error) {
+	message, err := ParseHandshakeMessage(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if message.Tag != tagCachedState {
+		return nil, errInvalidCachedState
+	}
+	state := &cachedServerState{
+		scfg:  message.Data[TagSCFG],
+		stk:   message.Data[TagSTK],
+		cert:  message.Data[TagCERT],
+		proof: message.Data[TagPROF],
+		chlo:  message.Data[TagCHLO],
+	}
+	if len(state.scfg) == 0 || len(state.cert) == 0 || len(state.proof) == 0 || len(state.chlo) == 0 {
+		return nil, errInvalidCachedState
+	}
+	return state, nil
+}
+
+func (s *cachedServerState) marshal() []byte {
+	b := &bytes.Buffer{}
+	data := map[Tag][]byte{
+		TagSCFG: s.scfg,
+		TagCERT: s.cert,
+		TagPROF: s.proof,
+		TagCHLO: s.chlo,
+	}
+	if len(s.stk) > 0 {
+		data[TagSTK] = s.stk
+	}
+	HandshakeMessage{Tag: tagCachedState, Data: data}.Write(b)
+	return b.Bytes()
+}
@@ -0,0 +1,49 @@
+package handshake
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Cached server state", func() {
+	var state *cachedServerState
+
+	BeforeEach(func() {
+		state = &cachedServerState{
+			scfg:  []byte("server config"),
+			stk:   []byte("source-address token"),
+			cert:  []byte("certificate chain"),
+			proof: []byte("proof"),
+			chlo:  []byte("client hello"),
+		}
+	})
+
+	It("serializes and parses the state", func() {
+		parsed, err := parseCachedServerState(state.marshal())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(parsed).To(Equal(state))
+	})
+
+	It("serializes and parses the state without an STK", func() {
+		state.stk = nil
+		parsed, err := parseCachedServerState(state.marshal())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(parsed).To(Equal(state))
+	})
+
+	It("errors if a value is missing", func() {
+		state.proof = nil
+		_, err := parseCachedServerState(state.marshal())
+		Expect(err).To(MatchError(errInvalidCachedState))
+	})
+
+	It("errors on other handshake messages", func() {
+		_, err := parseCachedServerState(sampleCHLO)
+		Expect(err).To(MatchError(errInvalidCachedState))
+	})
+
+	It("errors on invalid data", func() {
+		_, err := parseCachedServerState([]byte("foobar"))
+		Expect(err).To(HaveOccurred())
+	})
+})
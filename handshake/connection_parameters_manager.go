@@ -42,6 +42,8 @@ type connectionParametersManager struct {
 
 	flowControlNegotiated bool
 
+	idleTimeout time.Duration // the maximum idle timeout, as configured locally
+
 	truncateConnectionID                   bool
 	maxStreamsPerConnection                uint32
 	maxIncomingDynamicStreamsPerConnection uint32
@@ -50,6 +52,8 @@ type connectionParametersManager struct {
 	sendConnectionFlowControlWindow        protocol.ByteCount
 	receiveStreamFlowControlWindow         protocol.ByteCount
 	receiveConnectionFlowControlWindow     protocol.ByteCount
+	maxReceiveStreamFlowControlWindow      protocol.ByteCount
+	maxReceiveConnectionFlowControlWindow  protocol.ByteCount
 }
 
 var _ ConnectionParametersManager = &connectionParametersManager{}
@@ -61,22 +65,33 @@ var (
 )
 
 // NewConnectionParamatersManager creates a new connection parameters manager
-func NewConnectionParamatersManager(pers protocol.Perspective, v protocol.VersionNumber) ConnectionParametersManager {
+// The receive flow control windows are auto-tuned up to maxReceiveStreamFlowControlWindow and maxReceiveConnectionFlowControlWindow.
+// The negotiated idle timeout will never be larger than idleTimeout.
+func NewConnectionParamatersManager(
+	pers protocol.Perspective,
+	v protocol.VersionNumber,
+	maxReceiveStreamFlowControlWindow protocol.ByteCount,
+	maxReceiveConnectionFlowControlWindow protocol.ByteCount,
+	idleTimeout time.Duration,
+) ConnectionParametersManager {
 	h := &connectionParametersManager{
-		perspective:                        pers,
-		version:                            v,
-		sendStreamFlowControlWindow:        protocol.InitialStreamFlowControlWindow,     // can only be changed by the client
-		sendConnectionFlowControlWindow:    protocol.InitialConnectionFlowControlWindow, // can only be changed by the client
-		receiveStreamFlowControlWindow:     protocol.ReceiveStreamFlowControlWindow,
-		receiveConnectionFlowControlWindow: protocol.ReceiveConnectionFlowControlWindow,
+		perspective:                           pers,
+		version:                               v,
+		idleTimeout:                           idleTimeout,
+		sendStreamFlowControlWindow:           protocol.InitialStreamFlowControlWindow,     // can only be changed by the client
+		sendConnectionFlowControlWindow:       protocol.InitialConnectionFlowControlWindow, // can only be changed by the client
+		receiveStreamFlowControlWindow:        utils.MinByteCount(protocol.ReceiveStreamFlowControlWindow, maxReceiveStreamFlowControlWindow),
+		receiveConnectionFlowControlWindow:    utils.MinByteCount(protocol.ReceiveConnectionFlowControlWindow, maxReceiveConnectionFlowControlWindow),
+		maxReceiveStreamFlowControlWindow:     maxReceiveStreamFlowControlWindow,
+		maxReceiveConnectionFlowControlWindow: maxReceiveConnectionFlowControlWindow,
 	}
 
 	if h.perspective == protocol.PerspectiveServer {
-		h.idleConnectionStateLifetime = protocol.DefaultIdleTimeout
+		h.idleConnectionStateLifetime = utils.MinDuration(protocol.DefaultIdleTimeout, idleTimeout)
 		h.maxStreamsPerConnection = protocol.MaxStreamsPerConnection                // this is the value negotiated based on what the client sent
 		h.maxIncomingDynamicStreamsPerConnection = protocol.MaxStreamsPerConnection // "incoming" seen from the client's perspective
 	} else {
-		h.idleConnectionStateLifetime = protocol.MaxIdleTimeoutClient
+		h.idleConnectionStateLifetime = idleTimeout
 		h.maxStreamsPerConnection = protocol.MaxStreamsPerConnection                // this is the value negotiated based on what the client sent
 		h.maxIncomingDynamicStreamsPerConnection = protocol.MaxStreamsPerConnection // "incoming" seen from the server's perspective
 	}
@@ -156,10 +171,7 @@ func (h *connectionParametersManager) negotiateMaxIncomingDynamicStreamsPerConne
 }
 
 func (h *connectionParametersManager) negotiateIdleConnectionStateLifetime(clientValue time.Duration) time.Duration {
-	if h.perspective == protocol.PerspectiveServer {
-		return utils.MinDuration(clientValue, protocol.MaxIdleTimeoutServer)
-	}
-	return utils.MinDuration(clientValue, protocol.MaxIdleTimeoutClient)
+	return utils.MinDuration(clientValue, h.idleTimeout)
 }
 
 // GetHelloMap gets all parameters needed for the Hello message
@@ -205,12 +217,9 @@ func (h *connectionParametersManager) GetReceiveStreamFlowControlWindow() protoc
 	return h.receiveStreamFlowControlWindow
 }
 
-// GetMaxReceiveStreamFlowControlWindow gets the maximum size of the stream-level flow control window for sending data
+// GetMaxReceiveStreamFlowControlWindow gets the maximum size of the stream-level flow control window for receiving data
 func (h *connectionParametersManager) GetMaxReceiveStreamFlowControlWindow() protocol.ByteCount {
-	if h.perspective == protocol.PerspectiveServer {
-		return protocol.MaxReceiveStreamFlowControlWindowServer
-	}
-	return protocol.MaxReceiveStreamFlowControlWindowClient
+	return h.maxReceiveStreamFlowControlWindow
 }
 
 // GetReceiveConnectionFlowControlWindow gets the size of the stream-level flow control window for receiving data
@@ -220,12 +229,9 @@ func (h *connectionParametersManager) GetReceiveConnectionFlowControlWindow() pr
 	return h.receiveConnectionFlowControlWindow
 }
 
-// GetMaxReceiveConnectionFlowControlWindow gets the maximum size of the stream-level flow control window for sending data
+// GetMaxReceiveConnectionFlowControlWindow gets the maximum size of the connection-level flow control window for receiving data
 func (h *connectionParametersManager) GetMaxReceiveConnectionFlowControlWindow() protocol.ByteCount {
-	if h.perspective == protocol.PerspectiveServer {
-		return protocol.MaxReceiveConnectionFlowControlWindowServer
-	}
-	return protocol.MaxReceiveConnectionFlowControlWindowClient
+	return h.maxReceiveConnectionFlowControlWindow
 }
 
 // GetMaxOutgoingStreams gets the maximum number of outgoing streams per connection
@@ -14,8 +14,8 @@ var _ = Describe("ConnectionsParameterManager", func() {
 	var cpmClient *connectionParametersManager
 
 	BeforeEach(func() {
-		cpm = NewConnectionParamatersManager(protocol.PerspectiveServer, protocol.Version36).(*connectionParametersManager)
-		cpmClient = NewConnectionParamatersManager(protocol.PerspectiveClient, protocol.Version36).(*connectionParametersManager)
+		cpm = NewConnectionParamatersManager(protocol.PerspectiveServer, protocol.Version36, protocol.MaxReceiveStreamFlowControlWindowServer, protocol.MaxReceiveConnectionFlowControlWindowServer, protocol.MaxIdleTimeoutServer).(*connectionParametersManager)
+		cpmClient = NewConnectionParamatersManager(protocol.PerspectiveClient, protocol.Version36, protocol.MaxReceiveStreamFlowControlWindowClient, protocol.MaxReceiveConnectionFlowControlWindowClient, protocol.MaxIdleTimeoutClient).(*connectionParametersManager)
 	})
 
 	Context("SHLO", func() {
@@ -143,6 +143,28 @@ var _ = Describe("ConnectionsParameterManager", func() {
 			Expect(cpmClient.GetMaxReceiveConnectionFlowControlWindow()).To(Equal(protocol.MaxReceiveConnectionFlowControlWindowClient))
 		})
 
+		It("uses the configured maximum connection-level flow control window", func() {
+			cpm = NewConnectionParamatersManager(protocol.PerspectiveServer, protocol.Version36, protocol.MaxReceiveStreamFlowControlWindowServer, 10*(1<<20), protocol.DefaultIdleTimeout).(*connectionParametersManager)
+			Expect(cpm.GetMaxReceiveConnectionFlowControlWindow()).To(Equal(protocol.ByteCount(10 * (1 << 20))))
+			Expect(cpm.GetReceiveConnectionFlowControlWindow()).To(Equal(protocol.ReceiveConnectionFlowControlWindow))
+		})
+
+		It("uses the configured maximum stream-level flow control window", func() {
+			cpm = NewConnectionParamatersManager(protocol.PerspectiveServer, protocol.Version36, 5*(1<<20), protocol.MaxReceiveConnectionFlowControlWindowServer, protocol.DefaultIdleTimeout).(*connectionParametersManager)
+			Expect(cpm.GetMaxReceiveStreamFlowControlWindow()).To(Equal(protocol.ByteCount(5 * (1 << 20))))
+			Expect(cpm.GetReceiveStreamFlowControlWindow()).To(Equal(protocol.ReceiveStreamFlowControlWindow))
+		})
+
+		It("doesn't use a stream-level flow control window larger than the configured maximum", func() {
+			cpm = NewConnectionParamatersManager(protocol.PerspectiveServer, protocol.Version36, 1000, protocol.MaxReceiveConnectionFlowControlWindowServer, protocol.DefaultIdleTimeout).(*connectionParametersManager)
+			Expect(cpm.GetReceiveStreamFlowControlWindow()).To(Equal(protocol.ByteCount(1000)))
+		})
+
+		It("doesn't use a connection-level flow control window larger than the configured maximum", func() {
+			cpm = NewConnectionParamatersManager(protocol.PerspectiveServer, protocol.Version36, protocol.MaxReceiveStreamFlowControlWindowServer, 1000, protocol.DefaultIdleTimeout).(*connectionParametersManager)
+			Expect(cpm.GetReceiveConnectionFlowControlWindow()).To(Equal(protocol.ByteCount(1000)))
+		})
+
 		It("sets a new stream-level flow control window for sending", func() {
 			values := map[Tag][]byte{TagSFCW: {0xDE, 0xAD, 0xBE, 0xEF}}
 			err := cpm.SetFromMap(values)
@@ -204,6 +226,17 @@ var _ = Describe("ConnectionsParameterManager", func() {
 			Expect(cpmClient.negotiateIdleConnectionStateLifetime(protocol.MaxIdleTimeoutClient - 1*time.Second)).To(Equal(protocol.MaxIdleTimeoutClient - 1*time.Second))
 		})
 
+		It("uses the configured idle timeout", func() {
+			cpm = NewConnectionParamatersManager(protocol.PerspectiveServer, protocol.Version36, protocol.MaxReceiveStreamFlowControlWindowServer, protocol.MaxReceiveConnectionFlowControlWindowServer, 15*time.Second).(*connectionParametersManager)
+			Expect(cpm.GetIdleConnectionStateLifetime()).To(Equal(15 * time.Second))
+			err := cpm.SetFromMap(map[Tag][]byte{TagICSL: {20, 0, 0, 0}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cpm.GetIdleConnectionStateLifetime()).To(Equal(15 * time.Second))
+			err = cpm.SetFromMap(map[Tag][]byte{TagICSL: {10, 0, 0, 0}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cpm.GetIdleConnectionStateLifetime()).To(Equal(10 * time.Second))
+		})
+
 		It("sets the negotiated lifetime", func() {
 			// this test only works if the value given here is smaller than protocol.MaxIdleConnectionStateLifetime
 			values := map[Tag][]byte{
@@ -23,6 +23,7 @@ type cryptoSetupClient struct {
 	hostname           string
 	connID             protocol.ConnectionID
 	version            protocol.VersionNumber
+	initialVersion     protocol.VersionNumber // the version offered before a version negotiation, sent in the CHLO
 	negotiatedVersions []protocol.VersionNumber
 
 	cryptoStream io.ReadWriter
@@ -35,8 +36,15 @@ type cryptoSetupClient struct {
 	proof            []byte
 	chloForSignature []byte
 	lastSentCHLO     []byte
+	certData         []byte
 	certManager      crypto.CertManager
 
+	tokenStore TokenStore
+	// is set if the server state was restored from the tokenStore, and a 0-RTT handshake is attempted
+	zeroRTT bool
+	// if set, the restored server state is only used to send a full CHLO, but no data is sealed with the 0-RTT keys
+	disable0RTT bool
+
 	divNonceChan         chan []byte
 	diversificationNonce []byte
 
@@ -49,10 +57,19 @@ type cryptoSetupClient struct {
 	nullAEAD             crypto.AEAD
 	secureAEAD           crypto.AEAD
 	forwardSecureAEAD    crypto.AEAD
+	zeroRTTAEAD          crypto.AEAD // seals packets sent before the diversification nonce is received in a 0-RTT handshake. Never used for opening.
 	aeadChanged          chan<- protocol.EncryptionLevel
 
 	params               *TransportParameters
 	connectionParameters ConnectionParametersManager
+
+	nextProtos []string // offered in the ALPN tag, taken from tls.Config.NextProtos
+	connState  ConnectionState
+
+	clientCert          *tls.Certificate // sent if the server requests a client certificate, taken from tls.Config.Certificates
+	clientCertRequested bool
+
+	logger *utils.Logger
 }
 
 var _ CryptoSetup = &cryptoSetupClient{}
@@ -68,17 +85,30 @@ func NewCryptoSetupClient(
 	hostname string,
 	connID protocol.ConnectionID,
 	version protocol.VersionNumber,
+	initialVersion protocol.VersionNumber,
 	cryptoStream io.ReadWriter,
 	tlsConfig *tls.Config,
 	connectionParameters ConnectionParametersManager,
 	aeadChanged chan<- protocol.EncryptionLevel,
 	params *TransportParameters,
 	negotiatedVersions []protocol.VersionNumber,
+	tokenStore TokenStore,
+	disable0RTT bool,
+	logger *utils.Logger,
 ) (CryptoSetup, error) {
+	var nextProtos []string
+	var clientCert *tls.Certificate
+	if tlsConfig != nil {
+		nextProtos = tlsConfig.NextProtos
+		if len(tlsConfig.Certificates) > 0 {
+			clientCert = &tlsConfig.Certificates[0]
+		}
+	}
 	return &cryptoSetupClient{
 		hostname:             hostname,
 		connID:               connID,
 		version:              version,
+		initialVersion:       initialVersion,
 		cryptoStream:         cryptoStream,
 		certManager:          crypto.NewCertManager(tlsConfig),
 		connectionParameters: connectionParameters,
@@ -89,6 +119,11 @@ func NewCryptoSetupClient(
 		negotiatedVersions:   negotiatedVersions,
 		divNonceChan:         make(chan []byte),
 		params:               params,
+		tokenStore:           tokenStore,
+		disable0RTT:          disable0RTT,
+		nextProtos:           nextProtos,
+		clientCert:           clientCert,
+		logger:               logger,
 	}, nil
 }
 
@@ -107,6 +142,10 @@ func (h *cryptoSetupClient) HandleCryptoStream() error {
 		}
 	}()
 
+	if err := h.restoreServerState(); err != nil {
+		h.logger.Debugf("Not using the cached server state for %s: %s", h.hostname, err.Error())
+	}
+
 	for {
 		err := h.maybeUpgradeCrypto()
 		if err != nil {
@@ -122,6 +161,10 @@ func (h *cryptoSetupClient) HandleCryptoStream() error {
 			if err != nil {
 				return err
 			}
+			err = h.maybeDeriveZeroRTTKeys()
+			if err != nil {
+				return err
+			}
 		}
 
 		var message HandshakeMessage
@@ -138,7 +181,7 @@ func (h *cryptoSetupClient) HandleCryptoStream() error {
 			return err
 		}
 
-		utils.Debugf("Got %s", message)
+		h.logger.Debugf("Got %s", message)
 		switch message.Tag {
 		case TagREJ:
 			err = h.handleREJMessage(message.Data)
@@ -164,6 +207,10 @@ func (h *cryptoSetupClient) handleREJMessage(cryptoData map[Tag][]byte) error {
 		h.sno = sno
 	}
 
+	if _, ok := cryptoData[TagCREQ]; ok {
+		h.clientCertRequested = true
+	}
+
 	// TODO: what happens if the server sends a different server config in two packets?
 	if scfg, ok := cryptoData[TagSCFG]; ok {
 		h.serverConfig, err = parseServerConfig(scfg)
@@ -189,7 +236,12 @@ func (h *cryptoSetupClient) handleREJMessage(cryptoData map[Tag][]byte) error {
 		h.chloForSignature = h.lastSentCHLO
 	}
 
+	if h.zeroRTT {
+		h.logger.Debugf("Server rejected the 0-RTT handshake")
+	}
+
 	if crt, ok := cryptoData[TagCERT]; ok {
+		h.certData = crt
 		err := h.certManager.SetData(crt)
 		if err != nil {
 			return qerr.Error(qerr.InvalidCryptoMessageParameter, "Certificate data invalid")
@@ -197,7 +249,7 @@ func (h *cryptoSetupClient) handleREJMessage(cryptoData map[Tag][]byte) error {
 
 		err = h.certManager.Verify(h.hostname)
 		if err != nil {
-			utils.Infof("Certificate validation failed: %s", err.Error())
+			h.logger.Infof("Certificate validation failed: %s", err.Error())
 			return qerr.ProofInvalid
 		}
 	}
@@ -205,7 +257,7 @@ func (h *cryptoSetupClient) handleREJMessage(cryptoData map[Tag][]byte) error {
 	if h.serverConfig != nil && len(h.proof) != 0 && h.certManager.GetLeafCert() != nil {
 		validProof := h.certManager.VerifyServerProof(h.proof, h.chloForSignature, h.serverConfig.Get())
 		if !validProof {
-			utils.Infof("Server proof verification failed")
+			h.logger.Infof("Server proof verification failed")
 			return qerr.ProofInvalid
 		}
 
@@ -269,12 +321,34 @@ func (h *cryptoSetupClient) handleSHLOMessage(cryptoData map[Tag][]byte) error {
 		return qerr.InvalidCryptoMessageParameter
 	}
 
+	if alpn, ok := cryptoData[TagALPN]; ok {
+		if !h.offeredProtocol(string(alpn)) {
+			return qerr.Error(qerr.InvalidCryptoMessageParameter, "server selected an application protocol that was not offered")
+		}
+		h.connState.NegotiatedProtocol = string(alpn)
+	}
+	h.connState.ServerName = h.hostname
+	h.connState.PeerCertificates = h.certManager.GetChain()
+	h.connState.AEAD = "AESG"
+	h.connState.KeyExchange = "C255"
+
+	h.storeServerState()
+
 	h.aeadChanged <- protocol.EncryptionForwardSecure
 	close(h.aeadChanged)
 
 	return nil
 }
 
+func (h *cryptoSetupClient) offeredProtocol(proto string) bool {
+	for _, p := range h.nextProtos {
+		if p == proto {
+			return true
+		}
+	}
+	return false
+}
+
 func (h *cryptoSetupClient) validateVersionList(verTags []byte) bool {
 	if len(h.negotiatedVersions) == 0 {
 		return true
@@ -337,6 +411,8 @@ func (h *cryptoSetupClient) GetSealer() (protocol.EncryptionLevel, Sealer) {
 		return protocol.EncryptionForwardSecure, h.sealForwardSecure
 	} else if h.secureAEAD != nil {
 		return protocol.EncryptionSecure, h.sealSecure
+	} else if h.zeroRTTAEAD != nil {
+		return protocol.EncryptionSecure, h.sealZeroRTT
 	} else {
 		return protocol.EncryptionUnencrypted, h.sealUnencrypted
 	}
@@ -350,10 +426,13 @@ func (h *cryptoSetupClient) GetSealerWithEncryptionLevel(encLevel protocol.Encry
 	case protocol.EncryptionUnencrypted:
 		return h.sealUnencrypted, nil
 	case protocol.EncryptionSecure:
-		if h.secureAEAD == nil {
-			return nil, errors.New("CryptoSetupClient: no secureAEAD")
+		if h.secureAEAD != nil {
+			return h.sealSecure, nil
 		}
-		return h.sealSecure, nil
+		if h.zeroRTTAEAD != nil {
+			return h.sealZeroRTT, nil
+		}
+		return nil, errors.New("CryptoSetupClient: no secureAEAD")
 	case protocol.EncryptionForwardSecure:
 		if h.forwardSecureAEAD == nil {
 			return nil, errors.New("CryptoSetupClient: no forwardSecureAEAD")
@@ -371,6 +450,10 @@ func (h *cryptoSetupClient) sealSecure(dst, src []byte, packetNumber protocol.Pa
 	return h.secureAEAD.Seal(dst, src, packetNumber, associatedData)
 }
 
+func (h *cryptoSetupClient) sealZeroRTT(dst, src []byte, packetNumber protocol.PacketNumber, associatedData []byte) []byte {
+	return h.zeroRTTAEAD.Seal(dst, src, packetNumber, associatedData)
+}
+
 func (h *cryptoSetupClient) sealForwardSecure(dst, src []byte, packetNumber protocol.PacketNumber, associatedData []byte) []byte {
 	return h.forwardSecureAEAD.Seal(dst, src, packetNumber, associatedData)
 }
@@ -383,6 +466,13 @@ func (h *cryptoSetupClient) SetDiversificationNonce(data []byte) {
 	h.divNonceChan <- data
 }
 
+// ConnectionState returns details about the handshake
+func (h *cryptoSetupClient) ConnectionState() ConnectionState {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.connState
+}
+
 func (h *cryptoSetupClient) sendCHLO() error {
 	h.clientHelloCounter++
 	if h.clientHelloCounter > protocol.MaxClientHellos {
@@ -401,7 +491,7 @@ func (h *cryptoSetupClient) sendCHLO() error {
 		Data: tags,
 	}
 
-	utils.Debugf("Sending %s", message)
+	h.logger.Debugf("Sending %s", message)
 	message.Write(b)
 
 	_, err = h.cryptoStream.Write(b.Bytes())
@@ -428,12 +518,16 @@ func (h *cryptoSetupClient) getTags() (map[Tag][]byte, error) {
 	}
 
 	versionTag := make([]byte, 4)
-	binary.LittleEndian.PutUint32(versionTag, protocol.VersionNumberToTag(h.version))
+	// the server uses this to detect if an attacker forced a downgrade by forging a version negotiation packet
+	binary.LittleEndian.PutUint32(versionTag, protocol.VersionNumberToTag(h.initialVersion))
 	tags[TagVER] = versionTag
 
 	if h.params.RequestConnectionIDTruncation {
 		tags[TagTCID] = []byte{0, 0, 0, 0}
 	}
+	if alpn := encodeALPN(h.nextProtos); len(alpn) > 0 {
+		tags[TagALPN] = alpn
+	}
 	if len(h.stk) > 0 {
 		tags[TagSTK] = h.stk
 	}
@@ -455,6 +549,19 @@ func (h *cryptoSetupClient) getTags() (map[Tag][]byte, error) {
 			tags[TagKEXS] = []byte("C255")
 			tags[TagAEAD] = []byte("AESG")
 			tags[TagPUBS] = h.serverConfig.kex.PublicKey() // TODO: check if 3 bytes need to be prepended
+
+			if h.clientCertRequested && h.clientCert != nil {
+				chain, err := crypto.EncodeClientCertChain(h.clientCert)
+				if err != nil {
+					return nil, err
+				}
+				proof, err := crypto.SignClientProof(h.clientCert, h.nonc, tags[TagPUBS], h.serverConfig.Get())
+				if err != nil {
+					return nil, err
+				}
+				tags[TagCCHN] = chain
+				tags[TagCPRF] = proof
+			}
 		}
 	}
 
@@ -484,34 +591,126 @@ func (h *cryptoSetupClient) maybeUpgradeCrypto() error {
 	leafCert := h.certManager.GetLeafCert()
 	if h.secureAEAD == nil && (h.serverConfig != nil && len(h.serverConfig.sharedSecret) > 0 && len(h.nonc) > 0 && len(leafCert) > 0 && len(h.diversificationNonce) > 0 && len(h.lastSentCHLO) > 0) {
 		var err error
-		var nonce []byte
-		if h.sno == nil {
-			nonce = h.nonc
-		} else {
-			nonce = append(h.nonc, h.sno...)
-		}
-
-		h.secureAEAD, err = h.keyDerivation(
-			false,
-			h.serverConfig.sharedSecret,
-			nonce,
-			h.connID,
-			h.lastSentCHLO,
-			h.serverConfig.Get(),
-			leafCert,
-			h.diversificationNonce,
-			protocol.PerspectiveClient,
-		)
+		h.secureAEAD, err = h.deriveSecureAEAD(leafCert, h.diversificationNonce)
 		if err != nil {
 			return err
 		}
 
+		// when doing a 0-RTT handshake, this is the second time the encryption level changes to secure
+		// the session needs to know nevertheless, such that it can decrypt queued packets
 		h.aeadChanged <- protocol.EncryptionSecure
 	}
 
 	return nil
 }
 
+// maybeDeriveZeroRTTKeys derives the keys used to seal packets right after sending the CHLO,
+// if a 0-RTT handshake is attempted using the cached server state.
+// If 0-RTT is disabled, the cached server state is only used to send a full CHLO.
+func (h *cryptoSetupClient) maybeDeriveZeroRTTKeys() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if !h.zeroRTT || h.disable0RTT || h.zeroRTTAEAD != nil || h.secureAEAD != nil {
+		return nil
+	}
+	leafCert := h.certManager.GetLeafCert()
+	if h.serverConfig == nil || len(h.serverConfig.sharedSecret) == 0 || len(h.nonc) == 0 || len(leafCert) == 0 {
+		return nil
+	}
+
+	// the client's keys don't depend on the diversification nonce
+	var err error
+	h.zeroRTTAEAD, err = h.deriveSecureAEAD(leafCert, nil)
+	if err != nil {
+		return err
+	}
+
+	h.aeadChanged <- protocol.EncryptionSecure
+	return nil
+}
+
+func (h *cryptoSetupClient) deriveSecureAEAD(leafCert []byte, divNonce []byte) (crypto.AEAD, error) {
+	var nonce []byte
+	if h.sno == nil {
+		nonce = h.nonc
+	} else {
+		nonce = append(h.nonc, h.sno...)
+	}
+
+	return h.keyDerivation(
+		false,
+		h.serverConfig.sharedSecret,
+		nonce,
+		h.connID,
+		h.lastSentCHLO,
+		h.serverConfig.Get(),
+		leafCert,
+		divNonce,
+		protocol.PerspectiveClient,
+	)
+}
+
+// restoreServerState restores the server state from the token store
+// if it is valid, the next CHLO is a full CHLO, allowing a 0-RTT handshake
+func (h *cryptoSetupClient) restoreServerState() error {
+	if h.tokenStore == nil {
+		return nil
+	}
+	data, ok := h.tokenStore.Get(h.hostname)
+	if !ok {
+		return nil
+	}
+	state, err := parseCachedServerState(data)
+	if err != nil {
+		return err
+	}
+
+	scfg, err := parseServerConfig(state.scfg)
+	if err != nil {
+		return err
+	}
+	if scfg.IsExpired() {
+		return qerr.CryptoServerConfigExpired
+	}
+	if err := h.certManager.SetData(state.cert); err != nil {
+		return err
+	}
+	if err := h.certManager.Verify(h.hostname); err != nil {
+		return err
+	}
+	if !h.certManager.VerifyServerProof(state.proof, state.chlo, scfg.Get()) {
+		return errors.New("server proof invalid")
+	}
+
+	h.serverConfig = scfg
+	h.stk = state.stk
+	h.certData = state.cert
+	h.proof = state.proof
+	h.chloForSignature = state.chlo
+	if err := h.generateClientNonce(); err != nil {
+		return err
+	}
+	h.serverVerified = true
+	h.zeroRTT = true
+	return nil
+}
+
+// storeServerState stores the server state in the token store, such that it can be used for 0-RTT handshakes in the future
+func (h *cryptoSetupClient) storeServerState() {
+	if h.tokenStore == nil || h.serverConfig == nil || len(h.certData) == 0 || len(h.proof) == 0 {
+		return
+	}
+	state := &cachedServerState{
+		scfg:  h.serverConfig.Get(),
+		stk:   h.stk,
+		cert:  h.certData,
+		proof: h.proof,
+		chlo:  h.chloForSignature,
+	}
+	h.tokenStore.Put(h.hostname, state.marshal())
+}
+
 func (h *cryptoSetupClient) generateClientNonce() error {
 	if len(h.nonc) > 0 {
 		return errClientNonceAlreadyExists
@@ -2,6 +2,8 @@ package handshake
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -10,6 +12,7 @@ import (
 	"github.com/lucas-clemente/quic-go/crypto"
 	"github.com/lucas-clemente/quic-go/protocol"
 	"github.com/lucas-clemente/quic-go/qerr"
+	"github.com/lucas-clemente/quic-go/testdata"
 	"github.com/lucas-clemente/quic-go/utils"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -42,6 +45,8 @@ type mockCertManager struct {
 
 	verifyError  error
 	verifyCalled bool
+
+	chain []*x509.Certificate
 }
 
 func (m *mockCertManager) SetData(data []byte) error {
@@ -49,6 +54,10 @@ func (m *mockCertManager) SetData(data []byte) error {
 	return m.setDataError
 }
 
+func (m *mockCertManager) GetChain() []*x509.Certificate {
+	return m.chain
+}
+
 func (m *mockCertManager) GetCommonCertificateHashes() []byte {
 	return m.commonCertificateHashes
 }
@@ -71,6 +80,21 @@ func (m *mockCertManager) Verify(hostname string) error {
 	return m.verifyError
 }
 
+type mockTokenStore struct {
+	data map[string][]byte
+}
+
+var _ TokenStore = &mockTokenStore{}
+
+func (s *mockTokenStore) Get(hostname string) ([]byte, bool) {
+	data, ok := s.data[hostname]
+	return data, ok
+}
+
+func (s *mockTokenStore) Put(hostname string, data []byte) {
+	s.data[hostname] = data
+}
+
 var _ = Describe("Client Crypto Setup", func() {
 	var (
 		cs                      *cryptoSetupClient
@@ -109,12 +133,16 @@ var _ = Describe("Client Crypto Setup", func() {
 			"hostname",
 			0,
 			version,
+			version,
 			stream,
 			nil,
-			NewConnectionParamatersManager(protocol.PerspectiveClient, version),
+			NewConnectionParamatersManager(protocol.PerspectiveClient, version, protocol.MaxReceiveStreamFlowControlWindowClient, protocol.MaxReceiveConnectionFlowControlWindowClient, protocol.MaxIdleTimeoutClient),
 			aeadChanged,
 			&TransportParameters{},
 			nil,
+			nil,
+			false,
+			utils.DefaultLogger,
 		)
 		Expect(err).ToNot(HaveOccurred())
 		cs = csInt.(*cryptoSetupClient)
@@ -183,6 +211,16 @@ var _ = Describe("Client Crypto Setup", func() {
 			Expect(cs.sno).To(Equal(nonc))
 		})
 
+		It("saves if the server requested a client certificate", func() {
+			err := cs.handleREJMessage(tagMap)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cs.clientCertRequested).To(BeFalse())
+			tagMap[TagCREQ] = []byte{}
+			err = cs.handleREJMessage(tagMap)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cs.clientCertRequested).To(BeTrue())
+		})
+
 		Context("validating the Version list", func() {
 			It("doesn't care about the version list if there was no version negotiation", func() {
 				Expect(cs.validateVersionList([]byte{0})).To(BeTrue())
@@ -438,6 +476,40 @@ var _ = Describe("Client Crypto Setup", func() {
 			err := cs.handleSHLOMessage(shloMap)
 			Expect(err).To(MatchError(qerr.InvalidCryptoMessageParameter))
 		})
+
+		It("reads the negotiated application protocol", func() {
+			cs.nextProtos = []string{"foo", "bar"}
+			shloMap[TagALPN] = []byte("bar")
+			err := cs.handleSHLOMessage(shloMap)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cs.ConnectionState().NegotiatedProtocol).To(Equal("bar"))
+		})
+
+		It("doesn't set an application protocol if the server didn't select one", func() {
+			cs.nextProtos = []string{"foo", "bar"}
+			err := cs.handleSHLOMessage(shloMap)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cs.ConnectionState().NegotiatedProtocol).To(BeEmpty())
+		})
+
+		It("sets the connection state", func() {
+			cs.hostname = "quic.clemente.io"
+			certManager.chain = []*x509.Certificate{{Raw: []byte("leaf")}}
+			err := cs.handleSHLOMessage(shloMap)
+			Expect(err).ToNot(HaveOccurred())
+			state := cs.ConnectionState()
+			Expect(state.ServerName).To(Equal("quic.clemente.io"))
+			Expect(state.PeerCertificates).To(Equal(certManager.chain))
+			Expect(state.AEAD).To(Equal("AESG"))
+			Expect(state.KeyExchange).To(Equal("C255"))
+		})
+
+		It("errors if the server selected an application protocol that was not offered", func() {
+			cs.nextProtos = []string{"foo"}
+			shloMap[TagALPN] = []byte("bar")
+			err := cs.handleSHLOMessage(shloMap)
+			Expect(err).To(MatchError(qerr.Error(qerr.InvalidCryptoMessageParameter, "server selected an application protocol that was not offered")))
+		})
 	})
 
 	Context("CHLO generation", func() {
@@ -481,6 +553,29 @@ var _ = Describe("Client Crypto Setup", func() {
 			Expect(tags).ToNot(HaveKey(TagTCID))
 		})
 
+		It("sends the initially offered version after a version negotiation", func() {
+			// the server detects a downgrade attack if it supports the initial version
+			cs.version = protocol.Version35
+			cs.initialVersion = protocol.Version37
+			cs.negotiatedVersions = []protocol.VersionNumber{protocol.Version35}
+			tags, err := cs.getTags()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tags[TagVER]).To(Equal([]byte("Q037")))
+		})
+
+		It("offers the application protocols", func() {
+			cs.nextProtos = []string{"foo", "bar"}
+			tags, err := cs.getTags()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tags).To(HaveKeyWithValue(TagALPN, []byte("\x03foo\x03bar")))
+		})
+
+		It("doesn't send the ALPN tag if there are no application protocols", func() {
+			tags, err := cs.getTags()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tags).ToNot(HaveKey(TagALPN))
+		})
+
 		It("requests to truncate the connection ID", func() {
 			cs.params.RequestConnectionIDTruncation = true
 			tags, err := cs.getTags()
@@ -560,6 +655,52 @@ var _ = Describe("Client Crypto Setup", func() {
 			Expect(tags[TagAEAD]).To(Equal([]byte("AESG")))
 		})
 
+		Context("client certificates", func() {
+			var clientCert tls.Certificate
+
+			BeforeEach(func() {
+				clientCert = testdata.GetCertificate()
+				certManager.leafCert = []byte("leafcert")
+				cs.nonc = []byte("client-nonce")
+				kex, err := crypto.NewCurve25519KEX()
+				Expect(err).ToNot(HaveOccurred())
+				cs.serverConfig = &serverConfigClient{kex: kex, raw: []byte("server config")}
+			})
+
+			It("takes the client certificate from the tls.Config", func() {
+				csInt, err := NewCryptoSetupClient("hostname", 0, protocol.Version36, protocol.Version36, stream, &tls.Config{Certificates: []tls.Certificate{clientCert}}, nil, nil, &TransportParameters{}, nil, nil, false, utils.DefaultLogger)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(*csInt.(*cryptoSetupClient).clientCert).To(Equal(clientCert))
+			})
+
+			It("sends the certificate chain and a proof, if the server requested a certificate", func() {
+				cs.clientCert = &clientCert
+				cs.clientCertRequested = true
+				tags, err := cs.getTags()
+				Expect(err).ToNot(HaveOccurred())
+				chain, err := crypto.ParseClientCertChain(tags[TagCCHN])
+				Expect(err).ToNot(HaveOccurred())
+				Expect(chain[0].Raw).To(Equal(clientCert.Certificate[0]))
+				Expect(crypto.VerifyClientProof(tags[TagCPRF], chain[0], cs.nonc, tags[TagPUBS], []byte("server config"))).To(BeTrue())
+			})
+
+			It("doesn't send a certificate if the server didn't request one", func() {
+				cs.clientCert = &clientCert
+				tags, err := cs.getTags()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(tags).ToNot(HaveKey(TagCCHN))
+				Expect(tags).ToNot(HaveKey(TagCPRF))
+			})
+
+			It("doesn't send a certificate if it doesn't have one", func() {
+				cs.clientCertRequested = true
+				tags, err := cs.getTags()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(tags).ToNot(HaveKey(TagCCHN))
+				Expect(tags).ToNot(HaveKey(TagCPRF))
+			})
+		})
+
 		It("doesn't send more than MaxClientHellos CHLOs", func() {
 			Expect(cs.clientHelloCounter).To(BeZero())
 			for i := 1; i <= protocol.MaxClientHellos; i++ {
@@ -803,6 +944,204 @@ var _ = Describe("Client Crypto Setup", func() {
 		})
 	})
 
+	Context("0-RTT", func() {
+		var (
+			tokenStore *mockTokenStore
+			state      *cachedServerState
+			scfg       map[Tag][]byte
+		)
+
+		BeforeEach(func() {
+			tokenStore = &mockTokenStore{data: make(map[string][]byte)}
+			cs.tokenStore = tokenStore
+			scfg = getDefaultServerConfigClient()
+			b := &bytes.Buffer{}
+			HandshakeMessage{Tag: TagSCFG, Data: scfg}.Write(b)
+			state = &cachedServerState{
+				scfg:  b.Bytes(),
+				stk:   []byte("stk"),
+				cert:  []byte("cert"),
+				proof: []byte("proof"),
+				chlo:  []byte("chlo"),
+			}
+			certManager.leafCert = []byte("leafCert")
+			certManager.verifyServerProofResult = true
+		})
+
+		Context("restoring the server state", func() {
+			It("restores the server state", func() {
+				tokenStore.Put("hostname", state.marshal())
+				err := cs.restoreServerState()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(cs.serverConfig.ID).To(Equal(scfg[TagSCID]))
+				Expect(cs.stk).To(Equal([]byte("stk")))
+				Expect(cs.proof).To(Equal([]byte("proof")))
+				Expect(cs.chloForSignature).To(Equal([]byte("chlo")))
+				Expect(certManager.setDataCalledWith).To(Equal([]byte("cert")))
+				Expect(certManager.verifyCalled).To(BeTrue())
+				Expect(certManager.verifyServerProofCalled).To(BeTrue())
+				Expect(cs.nonc).To(HaveLen(32))
+				Expect(cs.serverVerified).To(BeTrue())
+				Expect(cs.zeroRTT).To(BeTrue())
+			})
+
+			It("doesn't do anything if no server state was stored", func() {
+				err := cs.restoreServerState()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(cs.serverConfig).To(BeNil())
+				Expect(cs.zeroRTT).To(BeFalse())
+			})
+
+			It("doesn't use the server state of a different host", func() {
+				tokenStore.Put("otherhost", state.marshal())
+				err := cs.restoreServerState()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(cs.zeroRTT).To(BeFalse())
+			})
+
+			It("rejects invalid server state", func() {
+				tokenStore.Put("hostname", []byte("foobar"))
+				err := cs.restoreServerState()
+				Expect(err).To(HaveOccurred())
+				Expect(cs.zeroRTT).To(BeFalse())
+			})
+
+			It("rejects expired server configs", func() {
+				scfg[TagEXPY] = []byte{0x80, 0x54, 0x72, 0x4F, 0, 0, 0, 0} // 2012-03-28
+				b := &bytes.Buffer{}
+				HandshakeMessage{Tag: TagSCFG, Data: scfg}.Write(b)
+				state.scfg = b.Bytes()
+				tokenStore.Put("hostname", state.marshal())
+				err := cs.restoreServerState()
+				Expect(err).To(MatchError(qerr.CryptoServerConfigExpired))
+				Expect(cs.serverConfig).To(BeNil())
+				Expect(cs.zeroRTT).To(BeFalse())
+			})
+
+			It("rejects the server state if the certificate chain is not valid", func() {
+				testErr := errors.New("cert invalid")
+				certManager.verifyError = testErr
+				tokenStore.Put("hostname", state.marshal())
+				err := cs.restoreServerState()
+				Expect(err).To(MatchError(testErr))
+				Expect(cs.serverVerified).To(BeFalse())
+				Expect(cs.zeroRTT).To(BeFalse())
+			})
+
+			It("rejects the server state if the proof is not valid", func() {
+				certManager.verifyServerProofResult = false
+				tokenStore.Put("hostname", state.marshal())
+				err := cs.restoreServerState()
+				Expect(err).To(MatchError("server proof invalid"))
+				Expect(cs.serverVerified).To(BeFalse())
+				Expect(cs.zeroRTT).To(BeFalse())
+			})
+		})
+
+		Context("storing the server state", func() {
+			BeforeEach(func() {
+				var err error
+				cs.serverConfig, err = parseServerConfig(state.scfg)
+				Expect(err).ToNot(HaveOccurred())
+				cs.stk = state.stk
+				cs.proof = state.proof
+				cs.chloForSignature = state.chlo
+				cs.receivedSecurePacket = true
+			})
+
+			It("saves the certificate data received in the REJ", func() {
+				err := cs.handleREJMessage(map[Tag][]byte{TagCERT: []byte("cert")})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(cs.certData).To(Equal([]byte("cert")))
+			})
+
+			It("stores the server state when receiving the SHLO", func() {
+				cs.certData = state.cert
+				err := cs.handleSHLOMessage(shloMap)
+				Expect(err).ToNot(HaveOccurred())
+				data, ok := tokenStore.Get("hostname")
+				Expect(ok).To(BeTrue())
+				Expect(data).To(Equal(state.marshal()))
+			})
+
+			It("doesn't store anything if the certificate data is missing", func() {
+				err := cs.handleSHLOMessage(shloMap)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(tokenStore.data).To(BeEmpty())
+			})
+		})
+
+		Context("sending data", func() {
+			BeforeEach(func() {
+				tokenStore.Put("hostname", state.marshal())
+				go cs.HandleCryptoStream()
+				Eventually(aeadChanged).Should(Receive(Equal(protocol.EncryptionSecure)))
+			})
+
+			It("sends a full CHLO right away", func() {
+				chlo, err := ParseHandshakeMessage(&stream.dataWritten)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(chlo.Tag).To(Equal(TagCHLO))
+				Expect(chlo.Data).To(HaveKeyWithValue(TagSCID, scfg[TagSCID]))
+				Expect(chlo.Data).To(HaveKeyWithValue(TagSTK, []byte("stk")))
+				Expect(chlo.Data).To(HaveKeyWithValue(TagNONC, cs.nonc))
+				Expect(chlo.Data).To(HaveKey(TagPUBS))
+				Expect(chlo.Data).To(HaveKey(TagXLCT))
+			})
+
+			It("seals packets with the 0-RTT keys before receiving the diversification nonce", func() {
+				Expect(keyDerivationCalledWith.forwardSecure).To(BeFalse())
+				Expect(keyDerivationCalledWith.chlo).To(Equal(cs.lastSentCHLO))
+				Expect(keyDerivationCalledWith.divNonce).To(BeNil())
+				Expect(cs.secureAEAD).To(BeNil())
+				enc, seal := cs.GetSealer()
+				Expect(enc).To(Equal(protocol.EncryptionSecure))
+				Expect(seal(nil, []byte("foobar"), 0, []byte{})).To(Equal([]byte("foobar  normal sec")))
+				seal, err := cs.GetSealerWithEncryptionLevel(protocol.EncryptionSecure)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(seal(nil, []byte("foobar"), 0, []byte{})).To(Equal([]byte("foobar  normal sec")))
+			})
+
+			It("derives the secure keys after receiving the diversification nonce", func() {
+				cs.SetDiversificationNonce([]byte("divnonce"))
+				Eventually(aeadChanged).Should(Receive(Equal(protocol.EncryptionSecure)))
+				Expect(cs.secureAEAD).ToNot(BeNil())
+				Expect(keyDerivationCalledWith.divNonce).To(Equal([]byte("divnonce")))
+				Expect(keyDerivationCalledWith.chlo).To(Equal(cs.lastSentCHLO))
+				Expect(aeadChanged).ToNot(Receive())
+			})
+		})
+
+		Context("with 0-RTT disabled", func() {
+			BeforeEach(func() {
+				cs.disable0RTT = true
+				tokenStore.Put("hostname", state.marshal())
+				go cs.HandleCryptoStream()
+			})
+
+			It("sends a full CHLO, but doesn't seal packets with the 0-RTT keys", func() {
+				Consistently(aeadChanged).ShouldNot(Receive())
+				chlo, err := ParseHandshakeMessage(&stream.dataWritten)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(chlo.Tag).To(Equal(TagCHLO))
+				Expect(chlo.Data).To(HaveKeyWithValue(TagSCID, scfg[TagSCID]))
+				Expect(chlo.Data).To(HaveKeyWithValue(TagSTK, []byte("stk")))
+				Expect(cs.zeroRTTAEAD).To(BeNil())
+				enc, _ := cs.GetSealer()
+				Expect(enc).To(Equal(protocol.EncryptionUnencrypted))
+				_, err = cs.GetSealerWithEncryptionLevel(protocol.EncryptionSecure)
+				Expect(err).To(HaveOccurred())
+			})
+
+			It("derives the secure keys after receiving the diversification nonce", func() {
+				cs.SetDiversificationNonce([]byte("divnonce"))
+				Eventually(aeadChanged).Should(Receive(Equal(protocol.EncryptionSecure)))
+				Expect(cs.secureAEAD).ToNot(BeNil())
+				Expect(keyDerivationCalledWith.divNonce).To(Equal([]byte("divnonce")))
+			})
+		})
+	})
+
 	Context("Diversification Nonces", func() {
 		It("sets a diversification nonce", func() {
 			go cs.HandleCryptoStream()
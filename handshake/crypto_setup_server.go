@@ -3,6 +3,7 @@ package handshake
 import (
 	"bytes"
 	"crypto/rand"
+	"crypto/tls"
 	"encoding/binary"
 	"errors"
 	"io"
@@ -25,14 +26,16 @@ type KeyExchangeFunction func() crypto.KeyExchange
 type cryptoSetupServer struct {
 	connID               protocol.ConnectionID
 	remoteAddr           net.Addr
-	scfg                 *ServerConfig
+	scfgs                *ServerConfigManager
+	scfg                 *ServerConfig // the server config used by the client, or the current server config
 	stkGenerator         *STKGenerator
 	diversificationNonce []byte
 
 	version           protocol.VersionNumber
 	supportedVersions []protocol.VersionNumber
 
-	acceptSTKCallback func(net.Addr, *STK) bool
+	acceptSTKCallback         func(net.Addr, *STK) bool
+	acceptClientHelloCallback func(*ClientHelloInfo) bool
 
 	nullAEAD                    crypto.AEAD
 	secureAEAD                  crypto.AEAD
@@ -40,8 +43,11 @@ type cryptoSetupServer struct {
 	receivedForwardSecurePacket bool
 	sentSHLO                    bool
 	receivedSecurePacket        bool
+	sentClientCertRequest       bool
 	aeadChanged                 chan<- protocol.EncryptionLevel
 
+	connState ConnectionState
+
 	keyDerivation KeyDerivationFunction
 	keyExchange   KeyExchangeFunction
 
@@ -49,6 +55,8 @@ type cryptoSetupServer struct {
 
 	connectionParameters ConnectionParametersManager
 
+	logger *utils.Logger
+
 	mutex sync.RWMutex
 }
 
@@ -64,32 +72,32 @@ func NewCryptoSetup(
 	connID protocol.ConnectionID,
 	remoteAddr net.Addr,
 	version protocol.VersionNumber,
-	scfg *ServerConfig,
+	scfgs *ServerConfigManager,
 	cryptoStream io.ReadWriter,
 	connectionParametersManager ConnectionParametersManager,
 	supportedVersions []protocol.VersionNumber,
 	acceptSTK func(net.Addr, *STK) bool,
+	acceptClientHello func(*ClientHelloInfo) bool,
 	aeadChanged chan<- protocol.EncryptionLevel,
+	logger *utils.Logger,
 ) (CryptoSetup, error) {
-	stkGenerator, err := NewSTKGenerator()
-	if err != nil {
-		return nil, err
-	}
-
 	return &cryptoSetupServer{
-		connID:               connID,
-		remoteAddr:           remoteAddr,
-		version:              version,
-		supportedVersions:    supportedVersions,
-		scfg:                 scfg,
-		stkGenerator:         stkGenerator,
-		keyDerivation:        crypto.DeriveKeysAESGCM,
-		keyExchange:          getEphermalKEX,
-		nullAEAD:             crypto.NewNullAEAD(protocol.PerspectiveServer, version),
-		cryptoStream:         cryptoStream,
-		connectionParameters: connectionParametersManager,
-		acceptSTKCallback:    acceptSTK,
-		aeadChanged:          aeadChanged,
+		connID:                    connID,
+		remoteAddr:                remoteAddr,
+		version:                   version,
+		supportedVersions:         supportedVersions,
+		scfgs:                     scfgs,
+		scfg:                      scfgs.Current(),
+		stkGenerator:              scfgs.Current().stkGenerator,
+		keyDerivation:             crypto.DeriveKeysAESGCM,
+		keyExchange:               getEphermalKEX,
+		nullAEAD:                  crypto.NewNullAEAD(protocol.PerspectiveServer, version),
+		cryptoStream:              cryptoStream,
+		connectionParameters:      connectionParametersManager,
+		acceptSTKCallback:         acceptSTK,
+		acceptClientHelloCallback: acceptClientHello,
+		aeadChanged:               aeadChanged,
+		logger:                    logger,
 	}, nil
 }
 
@@ -105,7 +113,7 @@ func (h *cryptoSetupServer) HandleCryptoStream() error {
 			return qerr.InvalidCryptoMessageType
 		}
 
-		utils.Debugf("Got %s", message)
+		h.logger.Debugf("Got %s", message)
 		done, err := h.handleMessage(chloData.Bytes(), message.Data)
 		if err != nil {
 			return err
@@ -146,6 +154,11 @@ func (h *cryptoSetupServer) handleMessage(chloData []byte, cryptoData map[Tag][]
 		return false, qerr.Error(qerr.VersionNegotiationMismatch, "Downgrade attack detected")
 	}
 
+	// Let the application reject the handshake, before sending the certificate chain
+	if err := h.acceptClientHello(sni, cryptoData); err != nil {
+		return false, err
+	}
+
 	var reply []byte
 	var err error
 
@@ -154,7 +167,20 @@ func (h *cryptoSetupServer) handleMessage(chloData []byte, cryptoData map[Tag][]
 		return false, err
 	}
 
-	if !h.isInchoateCHLO(cryptoData, certUncompressed) {
+	clientAuth, _, err := h.scfg.certChain.GetClientAuth(sni)
+	if err != nil {
+		return false, err
+	}
+
+	// After a rotation, the client may still use the previous server config, as long as it is accepted
+	if scfg := h.scfgs.Get(cryptoData[TagSCID]); scfg != nil {
+		h.scfg = scfg
+	} else {
+		h.scfg = h.scfgs.Current()
+	}
+
+	// If we need a client certificate, the client has to receive our request first
+	if !h.isInchoateCHLO(cryptoData, certUncompressed) && (clientAuth == tls.NoClientCert || h.sentClientCertRequest) {
 		// We have a CHLO with a proper server config ID, do a 0-RTT handshake
 		reply, err = h.handleCHLO(sni, chloData, cryptoData)
 		if err != nil {
@@ -281,12 +307,33 @@ func (h *cryptoSetupServer) isInchoateCHLO(cryptoData map[Tag][]byte, cert []byt
 func (h *cryptoSetupServer) acceptSTK(token []byte) bool {
 	stk, err := h.stkGenerator.DecodeToken(token)
 	if err != nil {
-		utils.Debugf("STK invalid: %s", err.Error())
+		h.logger.Debugf("STK invalid: %s", err.Error())
 		return false
 	}
 	return h.acceptSTKCallback(h.remoteAddr, stk)
 }
 
+func (h *cryptoSetupServer) acceptClientHello(sni string, cryptoData map[Tag][]byte) error {
+	if h.acceptClientHelloCallback == nil {
+		return nil
+	}
+	info := &ClientHelloInfo{
+		RemoteAddr: h.remoteAddr,
+		ServerName: sni,
+	}
+	if alpn, ok := cryptoData[TagALPN]; ok {
+		protos, err := decodeALPN(alpn)
+		if err != nil {
+			return err
+		}
+		info.SupportedProtos = protos
+	}
+	if !h.acceptClientHelloCallback(info) {
+		return qerr.Error(qerr.HandshakeFailed, "client hello rejected")
+	}
+	return nil
+}
+
 func (h *cryptoSetupServer) handleInchoateCHLO(sni string, chlo []byte, cryptoData map[Tag][]byte) ([]byte, error) {
 	if len(chlo) < protocol.ClientHelloMinimumSize {
 		return nil, qerr.Error(qerr.CryptoInvalidValueLength, "CHLO too small")
@@ -297,14 +344,25 @@ func (h *cryptoSetupServer) handleInchoateCHLO(sni string, chlo []byte, cryptoDa
 		return nil, err
 	}
 
+	// always send the current server config
+	scfg := h.scfgs.Current()
 	replyMap := map[Tag][]byte{
-		TagSCFG: h.scfg.Get(),
+		TagSCFG: scfg.Get(),
 		TagSTK:  token,
 		TagSVID: []byte("quic-go"),
 	}
 
+	clientAuth, _, err := h.scfg.certChain.GetClientAuth(sni)
+	if err != nil {
+		return nil, err
+	}
+	if clientAuth != tls.NoClientCert {
+		replyMap[TagCREQ] = []byte{}
+		h.sentClientCertRequest = true
+	}
+
 	if h.acceptSTK(cryptoData[TagSTK]) {
-		proof, err := h.scfg.Sign(sni, chlo)
+		proof, err := scfg.Sign(sni, chlo)
 		if err != nil {
 			return nil, err
 		}
@@ -312,7 +370,7 @@ func (h *cryptoSetupServer) handleInchoateCHLO(sni string, chlo []byte, cryptoDa
 		commonSetHashes := cryptoData[TagCCS]
 		cachedCertsHashes := cryptoData[TagCCRT]
 
-		certCompressed, err := h.scfg.GetCertsCompressed(sni, commonSetHashes, cachedCertsHashes)
+		certCompressed, err := scfg.GetCertsCompressed(sni, commonSetHashes, cachedCertsHashes)
 		if err != nil {
 			return nil, err
 		}
@@ -328,7 +386,7 @@ func (h *cryptoSetupServer) handleInchoateCHLO(sni string, chlo []byte, cryptoDa
 
 	var serverReply bytes.Buffer
 	message.Write(&serverReply)
-	utils.Debugf("Sending %s", message)
+	h.logger.Debugf("Sending %s", message)
 	return serverReply.Bytes(), nil
 }
 
@@ -373,6 +431,10 @@ func (h *cryptoSetupServer) handleCHLO(sni string, data []byte, cryptoData map[T
 		return nil, qerr.Error(qerr.CryptoNoSupport, "Unsupported AEAD or KEXS")
 	}
 
+	if err = h.verifyClientCert(sni, cryptoData); err != nil {
+		return nil, err
+	}
+
 	h.secureAEAD, err = h.keyDerivation(
 		false,
 		sharedSecret,
@@ -424,6 +486,23 @@ func (h *cryptoSetupServer) handleCHLO(sni string, data []byte, cryptoData map[T
 	if err != nil {
 		return nil, err
 	}
+	if alpn, ok := cryptoData[TagALPN]; ok {
+		clientProtos, err := decodeALPN(alpn)
+		if err != nil {
+			return nil, err
+		}
+		serverProtos, err := h.scfg.certChain.GetNextProtos(sni)
+		if err != nil {
+			return nil, err
+		}
+		h.connState.NegotiatedProtocol = selectALPN(serverProtos, clientProtos)
+		if h.connState.NegotiatedProtocol != "" {
+			replyMap[TagALPN] = []byte(h.connState.NegotiatedProtocol)
+		}
+	}
+	h.connState.ServerName = sni
+	h.connState.AEAD = string(aead)
+	h.connState.KeyExchange = string(kexs)
 	// add crypto parameters
 	verTag := &bytes.Buffer{}
 	for _, v := range h.supportedVersions {
@@ -440,13 +519,56 @@ func (h *cryptoSetupServer) handleCHLO(sni string, data []byte, cryptoData map[T
 	}
 	var reply bytes.Buffer
 	message.Write(&reply)
-	utils.Debugf("Sending %s", message)
+	h.logger.Debugf("Sending %s", message)
 
 	h.aeadChanged <- protocol.EncryptionForwardSecure
 
 	return reply.Bytes(), nil
 }
 
+// verifyClientCert checks the certificate chain and the proof sent by the client, as required by tls.Config.ClientAuth.
+// It must be called with the mutex held.
+func (h *cryptoSetupServer) verifyClientCert(sni string, cryptoData map[Tag][]byte) error {
+	clientAuth, clientCAs, err := h.scfg.certChain.GetClientAuth(sni)
+	if err != nil {
+		return err
+	}
+	if clientAuth == tls.NoClientCert {
+		return nil
+	}
+
+	chainData, ok := cryptoData[TagCCHN]
+	if !ok {
+		if clientAuth == tls.RequireAnyClientCert || clientAuth == tls.RequireAndVerifyClientCert {
+			return qerr.Error(qerr.CryptoMessageParameterNotFound, "client certificate required")
+		}
+		return nil
+	}
+	chain, err := crypto.ParseClientCertChain(chainData)
+	if err != nil {
+		return qerr.Error(qerr.InvalidCryptoMessageParameter, "invalid client certificate chain")
+	}
+	if !crypto.VerifyClientProof(cryptoData[TagCPRF], chain[0], cryptoData[TagNONC], cryptoData[TagPUBS], h.scfg.Get()) {
+		return qerr.Error(qerr.ProofInvalid, "client certificate proof invalid")
+	}
+	if clientAuth == tls.VerifyClientCertIfGiven || clientAuth == tls.RequireAndVerifyClientCert {
+		verifiedChains, err := crypto.VerifyClientCertChain(chain, clientCAs)
+		if err != nil {
+			return qerr.Error(qerr.ProofInvalid, "client certificate invalid: "+err.Error())
+		}
+		h.connState.VerifiedChains = verifiedChains
+	}
+	h.connState.PeerCertificates = chain
+	return nil
+}
+
+// ConnectionState returns details about the handshake
+func (h *cryptoSetupServer) ConnectionState() ConnectionState {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.connState
+}
+
 // DiversificationNonce returns the diversification nonce
 func (h *cryptoSetupServer) DiversificationNonce() []byte {
 	return h.diversificationNonce
@@ -2,9 +2,16 @@ package handshake
 
 import (
 	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/binary"
 	"errors"
+	"math/big"
 	"net"
+	"time"
 
 	"github.com/lucas-clemente/quic-go/crypto"
 	"github.com/lucas-clemente/quic-go/protocol"
@@ -38,7 +45,10 @@ func (m *mockKEX) CalculateSharedKey(otherPublic []byte) ([]byte, error) {
 }
 
 type mockSigner struct {
-	gotCHLO bool
+	gotCHLO    bool
+	nextProtos []string
+	clientAuth tls.ClientAuthType
+	clientCAs  *x509.CertPool
 }
 
 func (s *mockSigner) SignServerProof(sni string, chlo []byte, serverConfigData []byte) ([]byte, error) {
@@ -53,6 +63,12 @@ func (*mockSigner) GetCertsCompressed(sni string, common, cached []byte) ([]byte
 func (*mockSigner) GetLeafCert(sni string) ([]byte, error) {
 	return []byte("certuncompressed"), nil
 }
+func (s *mockSigner) GetNextProtos(sni string) ([]string, error) {
+	return s.nextProtos, nil
+}
+func (s *mockSigner) GetClientAuth(sni string) (tls.ClientAuthType, *x509.CertPool, error) {
+	return s.clientAuth, s.clientCAs, nil
+}
 
 type mockAEAD struct {
 	forwardSecure bool
@@ -151,6 +167,7 @@ var _ = Describe("Server Crypto Setup", func() {
 		kex               *mockKEX
 		signer            *mockSigner
 		scfg              *ServerConfig
+		scfgs             *ServerConfigManager
 		cs                *cryptoSetupServer
 		stream            *mockStream
 		cpm               ConnectionParametersManager
@@ -175,6 +192,7 @@ var _ = Describe("Server Crypto Setup", func() {
 		kex = &mockKEX{}
 		signer = &mockSigner{}
 		scfg, err = NewServerConfig(kex, signer)
+		scfgs = NewServerConfigManager(scfg, time.Hour)
 		nonce32 = make([]byte, 32)
 		aead = []byte("AESG")
 		kexs = []byte("C255")
@@ -184,17 +202,19 @@ var _ = Describe("Server Crypto Setup", func() {
 		Expect(err).NotTo(HaveOccurred())
 		version = protocol.SupportedVersions[len(protocol.SupportedVersions)-1]
 		supportedVersions = []protocol.VersionNumber{version, 98, 99}
-		cpm = NewConnectionParamatersManager(protocol.PerspectiveServer, protocol.VersionWhatever)
+		cpm = NewConnectionParamatersManager(protocol.PerspectiveServer, protocol.VersionWhatever, protocol.MaxReceiveStreamFlowControlWindowServer, protocol.MaxReceiveConnectionFlowControlWindowServer, protocol.MaxIdleTimeoutServer)
 		csInt, err := NewCryptoSetup(
 			protocol.ConnectionID(42),
 			remoteAddr,
 			version,
-			scfg,
+			scfgs,
 			stream,
 			cpm,
 			supportedVersions,
 			nil,
+			nil,
 			aeadChanged,
+			utils.DefaultLogger,
 		)
 		Expect(err).NotTo(HaveOccurred())
 		cs = csInt.(*cryptoSetupServer)
@@ -320,6 +340,223 @@ var _ = Describe("Server Crypto Setup", func() {
 			Expect(cs.forwardSecureAEAD.(*mockAEAD).forwardSecure).To(BeTrue())
 		})
 
+		It("sets the connection state", func() {
+			_, err := cs.handleCHLO("quic.clemente.io", []byte("chlo-data"), map[Tag][]byte{
+				TagPUBS: []byte("pubs-c"),
+				TagNONC: nonce32,
+				TagAEAD: aead,
+				TagKEXS: kexs,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			state := cs.ConnectionState()
+			Expect(state.ServerName).To(Equal("quic.clemente.io"))
+			Expect(state.PeerCertificates).To(BeEmpty())
+			Expect(state.AEAD).To(Equal("AESG"))
+			Expect(state.KeyExchange).To(Equal("C255"))
+		})
+
+		Context("negotiating the application protocol", func() {
+			var chlo map[Tag][]byte
+
+			BeforeEach(func() {
+				chlo = map[Tag][]byte{
+					TagPUBS: []byte("pubs-c"),
+					TagNONC: nonce32,
+					TagAEAD: aead,
+					TagKEXS: kexs,
+				}
+			})
+
+			It("selects the first of its protocols that the client offered", func() {
+				signer.nextProtos = []string{"foo", "bar", "baz"}
+				chlo[TagALPN] = encodeALPN([]string{"baz", "bar"})
+				response, err := cs.handleCHLO("", []byte("chlo-data"), chlo)
+				Expect(err).ToNot(HaveOccurred())
+				message, err := ParseHandshakeMessage(bytes.NewReader(response))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(message.Data).To(HaveKeyWithValue(TagALPN, []byte("bar")))
+				Expect(cs.ConnectionState().NegotiatedProtocol).To(Equal("bar"))
+			})
+
+			It("doesn't select a protocol if there's no overlap", func() {
+				signer.nextProtos = []string{"foo"}
+				chlo[TagALPN] = encodeALPN([]string{"bar"})
+				response, err := cs.handleCHLO("", []byte("chlo-data"), chlo)
+				Expect(err).ToNot(HaveOccurred())
+				message, err := ParseHandshakeMessage(bytes.NewReader(response))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(message.Data).ToNot(HaveKey(TagALPN))
+				Expect(cs.ConnectionState().NegotiatedProtocol).To(BeEmpty())
+			})
+
+			It("doesn't select a protocol if the client didn't offer any", func() {
+				signer.nextProtos = []string{"foo"}
+				response, err := cs.handleCHLO("", []byte("chlo-data"), chlo)
+				Expect(err).ToNot(HaveOccurred())
+				message, err := ParseHandshakeMessage(bytes.NewReader(response))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(message.Data).ToNot(HaveKey(TagALPN))
+			})
+
+			It("errors if the ALPN tag is invalid", func() {
+				chlo[TagALPN] = []byte{5, 'f', 'o', 'o'}
+				_, err := cs.handleCHLO("", []byte("chlo-data"), chlo)
+				Expect(err).To(MatchError(errInvalidALPN))
+			})
+		})
+
+		Context("client certificates", func() {
+			var (
+				chlo     map[Tag][]byte
+				caCert   *x509.Certificate
+				caKey    *ecdsa.PrivateKey
+				tlsCert  *tls.Certificate
+				leafCert *x509.Certificate
+			)
+
+			// generateCert generates a certificate signed by the parent, or a self-signed certificate if parent is nil
+			generateCert := func(parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*tls.Certificate, *x509.Certificate, *ecdsa.PrivateKey) {
+				key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+				Expect(err).ToNot(HaveOccurred())
+				template := &x509.Certificate{
+					SerialNumber:          big.NewInt(1),
+					NotBefore:             time.Now().Add(-time.Hour),
+					NotAfter:              time.Now().Add(time.Hour),
+					ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+					IsCA:                  parent == nil,
+					BasicConstraintsValid: true,
+				}
+				if parent == nil {
+					parent = template
+					parentKey = key
+				}
+				certDER, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+				Expect(err).ToNot(HaveOccurred())
+				cert, err := x509.ParseCertificate(certDER)
+				Expect(err).ToNot(HaveOccurred())
+				return &tls.Certificate{Certificate: [][]byte{certDER}, PrivateKey: key}, cert, key
+			}
+
+			addClientCert := func(cert *tls.Certificate) {
+				chain, err := crypto.EncodeClientCertChain(cert)
+				Expect(err).ToNot(HaveOccurred())
+				proof, err := crypto.SignClientProof(cert, chlo[TagNONC], chlo[TagPUBS], scfg.Get())
+				Expect(err).ToNot(HaveOccurred())
+				chlo[TagCCHN] = chain
+				chlo[TagCPRF] = proof
+			}
+
+			BeforeEach(func() {
+				chlo = map[Tag][]byte{
+					TagPUBS: []byte("pubs-c"),
+					TagNONC: nonce32,
+					TagAEAD: aead,
+					TagKEXS: kexs,
+				}
+				_, caCert, caKey = generateCert(nil, nil)
+				tlsCert, leafCert, _ = generateCert(caCert, caKey)
+				signer.clientCAs = x509.NewCertPool()
+				signer.clientCAs.AddCert(caCert)
+			})
+
+			It("doesn't request a client certificate by default", func() {
+				sourceAddrValid = false
+				response, err := cs.handleInchoateCHLO("", bytes.Repeat([]byte{'a'}, protocol.ClientHelloMinimumSize), nil)
+				Expect(err).ToNot(HaveOccurred())
+				message, err := ParseHandshakeMessage(bytes.NewReader(response))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(message.Data).ToNot(HaveKey(TagCREQ))
+			})
+
+			It("requests a client certificate in the REJ", func() {
+				signer.clientAuth = tls.RequestClientCert
+				sourceAddrValid = false
+				response, err := cs.handleInchoateCHLO("", bytes.Repeat([]byte{'a'}, protocol.ClientHelloMinimumSize), nil)
+				Expect(err).ToNot(HaveOccurred())
+				message, err := ParseHandshakeMessage(bytes.NewReader(response))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(message.Data).To(HaveKey(TagCREQ))
+				Expect(cs.sentClientCertRequest).To(BeTrue())
+			})
+
+			It("rejects a 0-RTT handshake if it didn't request a client certificate yet", func() {
+				signer.clientAuth = tls.RequireAnyClientCert
+				done, err := cs.handleMessage(bytes.Repeat([]byte{'a'}, protocol.ClientHelloMinimumSize), fullCHLO)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(done).To(BeFalse())
+				Expect(stream.dataWritten.Bytes()).To(HavePrefix("REJ"))
+				Expect(stream.dataWritten.Bytes()).To(ContainSubstring("CREQ"))
+				Expect(aeadChanged).ToNot(Receive())
+			})
+
+			It("errors if a required client certificate is missing", func() {
+				signer.clientAuth = tls.RequireAndVerifyClientCert
+				_, err := cs.handleCHLO("", []byte("chlo-data"), chlo)
+				Expect(err).To(MatchError(qerr.Error(qerr.CryptoMessageParameterNotFound, "client certificate required")))
+				Expect(cs.secureAEAD).To(BeNil())
+			})
+
+			It("accepts a missing client certificate if it's optional", func() {
+				signer.clientAuth = tls.VerifyClientCertIfGiven
+				_, err := cs.handleCHLO("", []byte("chlo-data"), chlo)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(cs.ConnectionState().PeerCertificates).To(BeEmpty())
+			})
+
+			It("accepts a client certificate without verifying it", func() {
+				signer.clientAuth = tls.RequireAnyClientCert
+				signer.clientCAs = nil
+				addClientCert(tlsCert)
+				_, err := cs.handleCHLO("", []byte("chlo-data"), chlo)
+				Expect(err).ToNot(HaveOccurred())
+				state := cs.ConnectionState()
+				Expect(state.PeerCertificates).To(Equal([]*x509.Certificate{leafCert}))
+				Expect(state.VerifiedChains).To(BeEmpty())
+			})
+
+			It("verifies the client certificate", func() {
+				signer.clientAuth = tls.RequireAndVerifyClientCert
+				addClientCert(tlsCert)
+				_, err := cs.handleCHLO("", []byte("chlo-data"), chlo)
+				Expect(err).ToNot(HaveOccurred())
+				state := cs.ConnectionState()
+				Expect(state.PeerCertificates).To(Equal([]*x509.Certificate{leafCert}))
+				Expect(state.VerifiedChains).To(Equal([][]*x509.Certificate{{leafCert, caCert}}))
+			})
+
+			It("rejects a client certificate signed by an unknown CA", func() {
+				signer.clientAuth = tls.RequireAndVerifyClientCert
+				untrustedCert, _, _ := generateCert(nil, nil)
+				addClientCert(untrustedCert)
+				_, err := cs.handleCHLO("", []byte("chlo-data"), chlo)
+				Expect(err).To(HaveOccurred())
+				Expect(err.(*qerr.QuicError).ErrorCode).To(Equal(qerr.ProofInvalid))
+			})
+
+			It("rejects an invalid proof", func() {
+				signer.clientAuth = tls.RequireAndVerifyClientCert
+				addClientCert(tlsCert)
+				chlo[TagCPRF] = []byte("foobar")
+				_, err := cs.handleCHLO("", []byte("chlo-data"), chlo)
+				Expect(err).To(MatchError(qerr.Error(qerr.ProofInvalid, "client certificate proof invalid")))
+			})
+
+			It("rejects a proof for a different key exchange value", func() {
+				signer.clientAuth = tls.RequireAnyClientCert
+				addClientCert(tlsCert)
+				chlo[TagPUBS] = []byte("other pubs")
+				_, err := cs.handleCHLO("", []byte("chlo-data"), chlo)
+				Expect(err).To(MatchError(qerr.Error(qerr.ProofInvalid, "client certificate proof invalid")))
+			})
+
+			It("errors on an invalid certificate chain", func() {
+				signer.clientAuth = tls.RequireAnyClientCert
+				chlo[TagCCHN] = []byte("foobar")
+				_, err := cs.handleCHLO("", []byte("chlo-data"), chlo)
+				Expect(err).To(MatchError(qerr.Error(qerr.InvalidCryptoMessageParameter, "invalid client certificate chain")))
+			})
+		})
+
 		It("handles long handshake", func() {
 			HandshakeMessage{
 				Tag: TagCHLO,
@@ -373,6 +610,62 @@ var _ = Describe("Server Crypto Setup", func() {
 			Expect(aeadChanged).To(Receive(Equal(protocol.EncryptionForwardSecure)))
 		})
 
+		Context("after rotating the server config", func() {
+			BeforeEach(func() {
+				Expect(scfgs.Rotate()).To(Succeed())
+			})
+
+			It("accepts a full CHLO for the previous server config", func() {
+				done, err := cs.handleMessage(bytes.Repeat([]byte{'a'}, protocol.ClientHelloMinimumSize), fullCHLO)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(done).To(BeTrue())
+				Expect(stream.dataWritten.Bytes()).To(HavePrefix("SHLO"))
+				// the shared secret is calculated using the key exchange of the previous server config
+				Expect(cs.secureAEAD.(*mockAEAD).sharedSecret).To(Equal([]byte("shared key")))
+			})
+
+			It("sends the current server config in the REJ, if the previous server config is not accepted anymore", func() {
+				scfgs.previous[0].expires = time.Now().Add(-time.Nanosecond)
+				done, err := cs.handleMessage(bytes.Repeat([]byte{'a'}, protocol.ClientHelloMinimumSize), fullCHLO)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(done).To(BeFalse())
+				message, err := ParseHandshakeMessage(&stream.dataWritten)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(message.Tag).To(Equal(TagREJ))
+				Expect(message.Data).To(HaveKeyWithValue(TagSCFG, scfgs.Current().Get()))
+			})
+
+			It("sends the current server config in the REJ for an inchoate CHLO", func() {
+				sourceAddrValid = false
+				response, err := cs.handleInchoateCHLO("", bytes.Repeat([]byte{'a'}, protocol.ClientHelloMinimumSize), nil)
+				Expect(err).ToNot(HaveOccurred())
+				message, err := ParseHandshakeMessage(bytes.NewReader(response))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(message.Data).To(HaveKeyWithValue(TagSCFG, scfgs.Current().Get()))
+			})
+		})
+
+		It("rejects an STK replayed from a different source address", func() {
+			cs.acceptSTKCallback = func(clientAddr net.Addr, stk *STK) bool {
+				return stk != nil && stk.RemoteAddr == clientAddr.(*net.UDPAddr).IP.String()
+			}
+			stk, err := cs.stkGenerator.NewToken(&net.UDPAddr{IP: net.IPv4(5, 6, 7, 8), Port: 1234})
+			Expect(err).ToNot(HaveOccurred())
+			fullCHLO[TagSTK] = stk
+			done, err := cs.handleMessage(bytes.Repeat([]byte{'a'}, protocol.ClientHelloMinimumSize), fullCHLO)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(done).To(BeFalse())
+			message, err := ParseHandshakeMessage(&stream.dataWritten)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(message.Tag).To(Equal(TagREJ))
+			// the REJ contains a new STK for the actual source address, but not the proof
+			newSTK, err := cs.stkGenerator.DecodeToken(message.Data[TagSTK])
+			Expect(err).ToNot(HaveOccurred())
+			Expect(newSTK.RemoteAddr).To(Equal("1.2.3.4"))
+			Expect(message.Data).ToNot(HaveKey(TagPROF))
+			Expect(aeadChanged).ToNot(Receive())
+		})
+
 		It("recognizes inchoate CHLOs missing SCID", func() {
 			delete(fullCHLO, TagSCID)
 			Expect(cs.isInchoateCHLO(fullCHLO, cert)).To(BeTrue())
@@ -510,6 +803,50 @@ var _ = Describe("Server Crypto Setup", func() {
 		Expect(err).To(MatchError("CryptoMessageParameterNotFound: SNI required"))
 	})
 
+	Context("accepting the client hello", func() {
+		var chlo map[Tag][]byte
+
+		BeforeEach(func() {
+			chlo = map[Tag][]byte{
+				TagSNI: []byte("quic.clemente.io"),
+				TagSTK: validSTK,
+				TagPAD: bytes.Repeat([]byte{'a'}, protocol.ClientHelloMinimumSize),
+				TagVER: versionTag,
+			}
+		})
+
+		It("passes the parameters of the client hello to the callback", func() {
+			var info *ClientHelloInfo
+			cs.acceptClientHelloCallback = func(chi *ClientHelloInfo) bool {
+				info = chi
+				return true
+			}
+			chlo[TagALPN] = encodeALPN([]string{"foo", "bar"})
+			done, err := cs.handleMessage(bytes.Repeat([]byte{'a'}, protocol.ClientHelloMinimumSize), chlo)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(done).To(BeFalse())
+			Expect(stream.dataWritten.Bytes()).To(HavePrefix("REJ"))
+			Expect(info).ToNot(BeNil())
+			Expect(info.RemoteAddr).To(Equal(cs.remoteAddr))
+			Expect(info.ServerName).To(Equal("quic.clemente.io"))
+			Expect(info.SupportedProtos).To(Equal([]string{"foo", "bar"}))
+		})
+
+		It("rejects the handshake if the callback doesn't accept the client hello", func() {
+			cs.acceptClientHelloCallback = func(chi *ClientHelloInfo) bool { return chi.ServerName == "quic.example.com" }
+			_, err := cs.handleMessage(bytes.Repeat([]byte{'a'}, protocol.ClientHelloMinimumSize), chlo)
+			Expect(err).To(MatchError(qerr.Error(qerr.HandshakeFailed, "client hello rejected")))
+			Expect(stream.dataWritten.Len()).To(BeZero())
+		})
+
+		It("errors if the ALPN tag is invalid", func() {
+			cs.acceptClientHelloCallback = func(*ClientHelloInfo) bool { return true }
+			chlo[TagALPN] = []byte{0x5, 'f', 'o', 'o'}
+			_, err := cs.handleMessage(bytes.Repeat([]byte{'a'}, protocol.ClientHelloMinimumSize), chlo)
+			Expect(err).To(MatchError(errInvalidALPN))
+		})
+	})
+
 	It("errors with invalid message", func() {
 		stream.dataToRead.Write([]byte("invalid message"))
 		err := cs.HandleCryptoStream()
@@ -714,5 +1051,26 @@ var _ = Describe("Server Crypto Setup", func() {
 			Expect(err).ToNot(HaveOccurred())
 			Expect(done).To(BeFalse())
 		})
+
+		It("uses the same STKGenerator for all sessions, such that STKs are valid for subsequent connections", func() {
+			cs2, err := NewCryptoSetup(
+				protocol.ConnectionID(1337),
+				&net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 4321},
+				version,
+				scfgs,
+				newMockStream(),
+				cpm,
+				supportedVersions,
+				nil,
+				nil,
+				aeadChanged,
+				utils.DefaultLogger,
+			)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cs2.(*cryptoSetupServer).stkGenerator).To(BeIdenticalTo(cs.stkGenerator))
+			stk, err := cs2.(*cryptoSetupServer).stkGenerator.DecodeToken(validSTK)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(stk.RemoteAddr).To(Equal("1.2.3.4"))
+		})
 	})
 })
@@ -16,6 +16,12 @@ var (
 	kexMutex       sync.RWMutex
 )
 
+// PrecomputeEphermalKEX generates the ephermal KEX, if there's no currently active KEX,
+// such that the first handshake doesn't have to wait for the key generation
+func PrecomputeEphermalKEX() {
+	getEphermalKEX()
+}
+
 // getEphermalKEX returns the currently active KEX, which changes every protocol.EphermalKeyLifetime
 // See the explanation from the QUIC crypto doc:
 //
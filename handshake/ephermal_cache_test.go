@@ -18,6 +18,16 @@ var _ = Describe("Ephermal KEX", func() {
 		Expect(kex1).To(Equal(kex2))
 	})
 
+	It("precomputes the KEX", func() {
+		kexMutex.Lock()
+		kexCurrent = nil
+		kexMutex.Unlock()
+		PrecomputeEphermalKEX()
+		kexMutex.RLock()
+		defer kexMutex.RUnlock()
+		Expect(kexCurrent).ToNot(BeNil())
+	})
+
 	It("changes KEX", func() {
 		kexLifetime = time.Millisecond
 		defer func() {
@@ -1,6 +1,11 @@
 package handshake
 
-import "github.com/lucas-clemente/quic-go/protocol"
+import (
+	"crypto/x509"
+	"net"
+
+	"github.com/lucas-clemente/quic-go/protocol"
+)
 
 // Sealer seals a packet
 type Sealer func(dst, src []byte, packetNumber protocol.PacketNumber, associatedData []byte) []byte
@@ -15,9 +20,47 @@ type CryptoSetup interface {
 
 	GetSealer() (protocol.EncryptionLevel, Sealer)
 	GetSealerWithEncryptionLevel(protocol.EncryptionLevel) (Sealer, error)
+
+	ConnectionState() ConnectionState
+}
+
+// ConnectionState records basic details about the handshake.
+// The values are set once the server sent (or the client received) the SHLO.
+type ConnectionState struct {
+	// ServerName is the SNI sent by the client
+	ServerName string
+	// NegotiatedProtocol is the application protocol negotiated using ALPN.
+	// It is empty if no protocol was negotiated.
+	NegotiatedProtocol string
+	// PeerCertificates is the certificate chain sent by the peer, starting with the leaf certificate.
+	// For the server, it is only set if the client sent a certificate.
+	PeerCertificates []*x509.Certificate
+	// VerifiedChains are the chains built from the client certificate.
+	// It is only set for the server, if the client certificate was verified.
+	VerifiedChains [][]*x509.Certificate
+	// AEAD is the tag of the AEAD algorithm used, e.g. AESG
+	AEAD string
+	// KeyExchange is the tag of the key exchange algorithm used, e.g. C255
+	KeyExchange string
+}
+
+// ClientHelloInfo contains the parameters of a CHLO, that are used to decide if the server accepts the handshake
+type ClientHelloInfo struct {
+	RemoteAddr      net.Addr
+	ServerName      string
+	SupportedProtos []string // the application protocols offered by the client using ALPN
 }
 
 // TransportParameters are parameters sent to the peer during the handshake
 type TransportParameters struct {
 	RequestConnectionIDTruncation bool
 }
+
+// A TokenStore stores the state received from servers during the handshake, which is needed for 0-RTT handshakes.
+// The stored data is opaque.
+type TokenStore interface {
+	// Get returns the data stored for the server, if any
+	Get(hostname string) ([]byte, bool)
+	// Put stores the data for the server, replacing data that was previously stored
+	Put(hostname string, data []byte)
+}
@@ -3,16 +3,33 @@ package handshake
 import (
 	"bytes"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
 
 	"github.com/lucas-clemente/quic-go/crypto"
+
+	"golang.org/x/crypto/hkdf"
 )
 
+// minServerSecretLength is the minimum length of the secret that a server config is derived from
+const minServerSecretLength = 32
+
 // ServerConfig is a server config
 type ServerConfig struct {
 	kex       crypto.KeyExchange
 	certChain crypto.CertChain
 	ID        []byte
 	obit      []byte
+	// raw is the binary representation, it is serialized when the server config is created
+	raw []byte
+	// the STKGenerator is shared by all sessions, such that STKs can be used for subsequent connections
+	stkGenerator *STKGenerator
+	// secret is only set if the server config was derived from a secret
+	secret []byte
+	// generation is the number of times the server config was rotated, starting from the one derived from the secret
+	generation uint64
 }
 
 // NewServerConfig creates a new server config
@@ -28,16 +45,75 @@ func NewServerConfig(kex crypto.KeyExchange, certChain crypto.CertChain) (*Serve
 		return nil, err
 	}
 
-	return &ServerConfig{
-		kex:       kex,
-		certChain: certChain,
-		ID:        id,
-		obit:      obit,
-	}, nil
+	stkGenerator, err := NewSTKGenerator()
+	if err != nil {
+		return nil, err
+	}
+	return newServerConfig(kex, certChain, id, obit, stkGenerator), nil
+}
+
+// NewServerConfigFromSecret creates a new server config, deriving the key exchange value, the ID and the OBIT from the secret.
+// The STKs are encrypted using the secret as well.
+// A server config created from the same secret (e.g. after a restart of the server) accepts the STKs,
+// and allows clients that cached the server config to perform a 0-RTT handshake.
+func NewServerConfigFromSecret(secret []byte, certChain crypto.CertChain) (*ServerConfig, error) {
+	if len(secret) < minServerSecretLength {
+		return nil, errors.New("ServerConfig: secret too short")
+	}
+	stkGenerator, err := NewSTKGeneratorFromSecret(secret)
+	if err != nil {
+		return nil, err
+	}
+	return newServerConfigFromSecret(secret, 0, certChain, stkGenerator)
+}
+
+// newServerConfigFromSecret derives the server config of the given generation from the secret
+func newServerConfigFromSecret(secret []byte, generation uint64, certChain crypto.CertChain, stkGenerator *STKGenerator) (*ServerConfig, error) {
+	info := append([]byte("QUIC server config"), make([]byte, 8)...)
+	binary.BigEndian.PutUint64(info[len(info)-8:], generation)
+	r := hkdf.New(sha256.New, secret, nil, info)
+	kexSecret := make([]byte, 32)
+	id := make([]byte, 16)
+	obit := make([]byte, 8)
+	for _, b := range [][]byte{kexSecret, id, obit} {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+	}
+	kex, err := crypto.NewCurve25519KEXFromSecret(kexSecret)
+	if err != nil {
+		return nil, err
+	}
+	scfg := newServerConfig(kex, certChain, id, obit, stkGenerator)
+	scfg.secret = secret
+	scfg.generation = generation
+	return scfg, nil
+}
+
+func newServerConfig(kex crypto.KeyExchange, certChain crypto.CertChain, id, obit []byte, stkGenerator *STKGenerator) *ServerConfig {
+	scfg := &ServerConfig{
+		kex:          kex,
+		certChain:    certChain,
+		ID:           id,
+		obit:         obit,
+		stkGenerator: stkGenerator,
+	}
+	scfg.raw = scfg.serialize()
+	return scfg
 }
 
 // Get the server config binary representation
+// It is shared by all sessions using this server config, and must not be modified.
 func (s *ServerConfig) Get() []byte {
+	return s.raw
+}
+
+// STKGenerator returns the STKGenerator used to issue and verify the source address tokens
+func (s *ServerConfig) STKGenerator() *STKGenerator {
+	return s.stkGenerator
+}
+
+func (s *ServerConfig) serialize() []byte {
 	var serverConfig bytes.Buffer
 	msg := HandshakeMessage{
 		Tag: TagSCFG,
@@ -51,7 +127,9 @@ func (s *ServerConfig) Get() []byte {
 		},
 	}
 	msg.Write(&serverConfig)
-	return serverConfig.Bytes()
+	b := serverConfig.Bytes()
+	// limit the capacity, so that appending to the server config doesn't write to the shared buffer
+	return b[:len(b):len(b)]
 }
 
 // Sign the server config and CHLO with the server's keyData
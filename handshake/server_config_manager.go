@@ -0,0 +1,103 @@
+package handshake
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/crypto"
+)
+
+// A ServerConfigManager holds the server config used for new handshakes.
+// When the server config is rotated, the previous server config is still accepted for the overlap period,
+// such that clients that cached it can still complete a 0-RTT handshake.
+type ServerConfigManager struct {
+	overlap time.Duration
+
+	mutex    sync.RWMutex
+	current  *ServerConfig
+	previous []*previousServerConfig
+}
+
+type previousServerConfig struct {
+	scfg    *ServerConfig
+	expires time.Time
+}
+
+// NewServerConfigManager creates a new ServerConfigManager, starting with the given server config
+func NewServerConfigManager(scfg *ServerConfig, overlap time.Duration) *ServerConfigManager {
+	return &ServerConfigManager{
+		overlap: overlap,
+		current: scfg,
+	}
+}
+
+// Current returns the server config that is sent to clients
+func (m *ServerConfigManager) Current() *ServerConfig {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.current
+}
+
+// Get returns the server config with the given ID, if it is either the current server config,
+// or if it was rotated less than the overlap period ago.
+// It returns nil if there's no such server config.
+func (m *ServerConfigManager) Get(id []byte) *ServerConfig {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	if bytes.Equal(m.current.ID, id) {
+		return m.current
+	}
+	now := time.Now()
+	for _, p := range m.previous {
+		if bytes.Equal(p.scfg.ID, id) && now.Before(p.expires) {
+			return p.scfg
+		}
+	}
+	return nil
+}
+
+// Rotate replaces the current server config with a new server config, using a new key exchange value.
+// If the current server config was derived from a secret, the new one is derived from the same secret,
+// such that servers sharing the secret derive the same server config for the same number of rotations.
+// Source address tokens issued before are still accepted.
+func (m *ServerConfigManager) Rotate() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	scfg, err := m.nextServerConfig()
+	if err != nil {
+		return err
+	}
+
+	// drop the server configs that have expired
+	now := time.Now()
+	previous := m.previous[:0]
+	for _, p := range m.previous {
+		if now.Before(p.expires) {
+			previous = append(previous, p)
+		}
+	}
+	m.previous = append(previous, &previousServerConfig{scfg: m.current, expires: now.Add(m.overlap)})
+	m.current = scfg
+	return nil
+}
+
+// nextServerConfig creates the server config that replaces the current one
+// it must be called with the mutex held
+func (m *ServerConfigManager) nextServerConfig() (*ServerConfig, error) {
+	if m.current.secret != nil {
+		return newServerConfigFromSecret(m.current.secret, m.current.generation+1, m.current.certChain, m.current.stkGenerator)
+	}
+	kex, err := crypto.NewCurve25519KEX()
+	if err != nil {
+		return nil, err
+	}
+	scfg, err := NewServerConfig(kex, m.current.certChain)
+	if err != nil {
+		return nil, err
+	}
+	scfg.stkGenerator = m.current.stkGenerator
+	return scfg, nil
+}
@@ -0,0 +1,101 @@
+package handshake
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/crypto"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ServerConfigManager", func() {
+	var (
+		scfg    *ServerConfig
+		manager *ServerConfigManager
+	)
+
+	BeforeEach(func() {
+		kex, err := crypto.NewCurve25519KEX()
+		Expect(err).NotTo(HaveOccurred())
+		scfg, err = NewServerConfig(kex, &mockSigner{})
+		Expect(err).NotTo(HaveOccurred())
+		manager = NewServerConfigManager(scfg, time.Hour)
+	})
+
+	It("returns the current server config", func() {
+		Expect(manager.Current()).To(BeIdenticalTo(scfg))
+		Expect(manager.Get(scfg.ID)).To(BeIdenticalTo(scfg))
+	})
+
+	It("doesn't return unknown server configs", func() {
+		Expect(manager.Get([]byte("foobar"))).To(BeNil())
+		Expect(manager.Get(nil)).To(BeNil())
+	})
+
+	Context("rotating", func() {
+		It("creates a new server config", func() {
+			Expect(manager.Rotate()).To(Succeed())
+			current := manager.Current()
+			Expect(current).ToNot(BeIdenticalTo(scfg))
+			Expect(current.ID).ToNot(Equal(scfg.ID))
+			Expect(current.obit).ToNot(Equal(scfg.obit))
+			Expect(current.kex.PublicKey()).ToNot(Equal(scfg.kex.PublicKey()))
+			Expect(current.certChain).To(Equal(scfg.certChain))
+		})
+
+		It("keeps the STKGenerator, such that STKs issued before are still valid", func() {
+			Expect(manager.Rotate()).To(Succeed())
+			Expect(manager.Current().stkGenerator).To(BeIdenticalTo(scfg.stkGenerator))
+		})
+
+		It("accepts the previous server config during the overlap", func() {
+			Expect(manager.Rotate()).To(Succeed())
+			Expect(manager.Get(scfg.ID)).To(BeIdenticalTo(scfg))
+			Expect(manager.Get(manager.Current().ID)).To(BeIdenticalTo(manager.Current()))
+		})
+
+		It("doesn't accept the previous server config after the overlap", func() {
+			Expect(manager.Rotate()).To(Succeed())
+			manager.previous[0].expires = time.Now().Add(-time.Nanosecond)
+			Expect(manager.Get(scfg.ID)).To(BeNil())
+		})
+
+		It("accepts multiple previous server configs", func() {
+			Expect(manager.Rotate()).To(Succeed())
+			second := manager.Current()
+			Expect(manager.Rotate()).To(Succeed())
+			Expect(manager.Get(scfg.ID)).To(BeIdenticalTo(scfg))
+			Expect(manager.Get(second.ID)).To(BeIdenticalTo(second))
+		})
+
+		It("drops expired server configs", func() {
+			Expect(manager.Rotate()).To(Succeed())
+			manager.previous[0].expires = time.Now().Add(-time.Nanosecond)
+			Expect(manager.Rotate()).To(Succeed())
+			Expect(manager.previous).To(HaveLen(1))
+			Expect(manager.previous[0].scfg.ID).ToNot(Equal(scfg.ID))
+		})
+
+		It("derives the new server config from the secret, if the current one was derived from a secret", func() {
+			secret := bytes.Repeat([]byte("secret"), 6)
+			newManager := func() *ServerConfigManager {
+				scfg, err := NewServerConfigFromSecret(secret, &mockSigner{})
+				Expect(err).ToNot(HaveOccurred())
+				return NewServerConfigManager(scfg, time.Hour)
+			}
+			manager1 := newManager()
+			manager2 := newManager()
+			first := manager1.Current()
+			for i := 0; i < 2; i++ {
+				Expect(manager1.Rotate()).To(Succeed())
+				Expect(manager2.Rotate()).To(Succeed())
+				Expect(manager1.Current().ID).ToNot(Equal(first.ID))
+				Expect(manager1.Current().Get()).To(Equal(manager2.Current().Get()))
+			}
+			Expect(manager1.Current().ID).ToNot(Equal(manager1.previous[1].scfg.ID))
+			Expect(manager1.Current().stkGenerator).To(BeIdenticalTo(first.stkGenerator))
+		})
+	})
+})
@@ -2,6 +2,7 @@ package handshake
 
 import (
 	"bytes"
+	"net"
 
 	"github.com/lucas-clemente/quic-go/crypto"
 
@@ -29,6 +30,12 @@ var _ = Describe("ServerConfig", func() {
 		Expect(scfg1.obit).ToNot(Equal(scfg2.obit))
 	})
 
+	It("creates an STKGenerator", func() {
+		scfg, err := NewServerConfig(kex, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(scfg.stkGenerator).ToNot(BeNil())
+	})
+
 	It("gets the proper binary representation", func() {
 		scfg, err := NewServerConfig(kex, nil)
 		Expect(err).NotTo(HaveOccurred())
@@ -41,4 +48,55 @@ var _ = Describe("ServerConfig", func() {
 		expected.Write([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
 		Expect(scfg.Get()).To(Equal(expected.Bytes()))
 	})
+
+	It("serializes the server config when it is created", func() {
+		scfg, err := NewServerConfig(kex, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(scfg.raw).ToNot(BeEmpty())
+		Expect(scfg.Get()).To(Equal(scfg.raw))
+		// appending to the server config doesn't modify the shared buffer
+		Expect(cap(scfg.Get())).To(Equal(len(scfg.raw)))
+	})
+	Context("derived from a secret", func() {
+		secret := bytes.Repeat([]byte("secret"), 6)
+
+		It("derives the same server config from the same secret", func() {
+			scfg1, err := NewServerConfigFromSecret(secret, nil)
+			Expect(err).ToNot(HaveOccurred())
+			scfg2, err := NewServerConfigFromSecret(secret, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(scfg1.ID).To(HaveLen(16))
+			Expect(scfg1.ID).To(Equal(scfg2.ID))
+			Expect(scfg1.obit).To(Equal(scfg2.obit))
+			Expect(scfg1.kex.PublicKey()).To(Equal(scfg2.kex.PublicKey()))
+			Expect(scfg1.Get()).To(Equal(scfg2.Get()))
+		})
+
+		It("derives different server configs from different secrets", func() {
+			scfg1, err := NewServerConfigFromSecret(secret, nil)
+			Expect(err).ToNot(HaveOccurred())
+			scfg2, err := NewServerConfigFromSecret(bytes.Repeat([]byte("foobar"), 6), nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(scfg1.ID).ToNot(Equal(scfg2.ID))
+			Expect(scfg1.kex.PublicKey()).ToNot(Equal(scfg2.kex.PublicKey()))
+		})
+
+		It("accepts STKs issued before a restart", func() {
+			scfg, err := NewServerConfigFromSecret(secret, nil)
+			Expect(err).ToNot(HaveOccurred())
+			token, err := scfg.stkGenerator.NewToken(&net.UDPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 1337})
+			Expect(err).ToNot(HaveOccurred())
+			// restart, using the same secret
+			scfg, err = NewServerConfigFromSecret(secret, nil)
+			Expect(err).ToNot(HaveOccurred())
+			stk, err := scfg.stkGenerator.DecodeToken(token)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(stk.RemoteAddr).To(Equal("192.168.0.1"))
+		})
+
+		It("rejects short secrets", func() {
+			_, err := NewServerConfigFromSecret([]byte("foobar"), nil)
+			Expect(err).To(MatchError("ServerConfig: secret too short"))
+		})
+	})
 })
@@ -42,6 +42,17 @@ func NewSTKGenerator() (*STKGenerator, error) {
 	}, nil
 }
 
+// NewSTKGeneratorFromSecret initializes a new STKGenerator, that encrypts the STKs using the given secret
+func NewSTKGeneratorFromSecret(secret []byte) (*STKGenerator, error) {
+	stkSource, err := crypto.NewStkSourceFromSecret(secret)
+	if err != nil {
+		return nil, err
+	}
+	return &STKGenerator{
+		stkSource: stkSource,
+	}, nil
+}
+
 // NewToken generates a new STK token for a given source address
 func (g *STKGenerator) NewToken(raddr net.Addr) ([]byte, error) {
 	data, err := asn1.Marshal(token{
@@ -49,6 +49,18 @@ const (
 	TagCFCW Tag = 'C' + 'F'<<8 + 'C'<<16 + 'W'<<24
 	// TagSFCW is the initial stream flow control receive window.
 	TagSFCW Tag = 'S' + 'F'<<8 + 'C'<<16 + 'W'<<24
+	// TagALPN is the list of application protocols offered by the client (in the CHLO), or the protocol selected by the server (in the SHLO)
+	TagALPN Tag = 'A' + 'L'<<8 + 'P'<<16 + 'N'<<24
+
+	// TagCREQ is sent by the server in the REJ to request a client certificate.
+	// This tag is not part of the QUIC crypto handshake, and only understood by quic-go.
+	TagCREQ Tag = 'C' + 'R'<<8 + 'E'<<16 + 'Q'<<24
+	// TagCCHN is the compressed certificate chain sent by the client.
+	// This tag is not part of the QUIC crypto handshake, and only understood by quic-go.
+	TagCCHN Tag = 'C' + 'C'<<8 + 'H'<<16 + 'N'<<24
+	// TagCPRF is the client's signature over its nonce, its public value and the server config.
+	// This tag is not part of the QUIC crypto handshake, and only understood by quic-go.
+	TagCPRF Tag = 'C' + 'P'<<8 + 'R'<<16 + 'F'<<24
 
 	// TagFHL2 forces head of line blocking.
 	// Chrome experiment (see https://codereview.chromium.org/2115033002)
@@ -0,0 +1,64 @@
+package integrationtests
+
+import (
+	"crypto/tls"
+	"net"
+	"strconv"
+
+	quic "github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/qerr"
+	"github.com/lucas-clemente/quic-go/testdata"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Accepting client hellos", func() {
+	var ln quic.Listener
+
+	BeforeEach(func() {
+		allowed := map[string]bool{"quic.clemente.io": true}
+		var err error
+		ln, err = quic.ListenAddr("127.0.0.1:0", &quic.Config{
+			TLSConfig: testdata.GetTLSConfig(),
+			AcceptClientHello: func(chi *quic.ClientHelloInfo) bool {
+				return allowed[chi.ServerName]
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		go func() {
+			for {
+				if _, err := ln.Accept(); err != nil {
+					return
+				}
+			}
+		}()
+	})
+
+	AfterEach(func() {
+		Expect(ln.Close()).To(Succeed())
+	})
+
+	dial := func(host string) (quic.Session, error) {
+		udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+		Expect(err).ToNot(HaveOccurred())
+		port := ln.Addr().(*net.UDPAddr).Port
+		return quic.Dial(udpConn, ln.Addr(), net.JoinHostPort(host, strconv.Itoa(port)), &quic.Config{
+			TLSConfig: &tls.Config{InsecureSkipVerify: true},
+		})
+	}
+
+	It("accepts the handshake for an allowed server name", func(done Done) {
+		sess, err := dial("quic.clemente.io")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sess.Close(nil)).To(Succeed())
+		close(done)
+	}, 5)
+
+	It("rejects the handshake for a server name that isn't allowed", func(done Done) {
+		_, err := dial("quic.example.com")
+		Expect(err).To(HaveOccurred())
+		Expect(err.(*qerr.QuicError).ErrorCode).To(Equal(qerr.HandshakeFailed))
+		close(done)
+	}, 5)
+})
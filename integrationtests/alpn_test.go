@@ -0,0 +1,65 @@
+package integrationtests
+
+import (
+	"crypto/tls"
+
+	quic "github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/testdata"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ALPN", func() {
+	var (
+		ln       quic.Listener
+		sessChan chan quic.Session
+	)
+
+	BeforeEach(func() {
+		tlsConf := testdata.GetTLSConfig()
+		tlsConf.NextProtos = []string{"custom-proto/2", "custom-proto/1"}
+		var err error
+		ln, err = quic.ListenAddr("localhost:0", &quic.Config{TLSConfig: tlsConf})
+		Expect(err).ToNot(HaveOccurred())
+		sessChan = make(chan quic.Session, 1)
+		go func() {
+			defer GinkgoRecover()
+			sess, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			sessChan <- sess
+		}()
+	})
+
+	AfterEach(func() {
+		Expect(ln.Close()).To(Succeed())
+	})
+
+	It("negotiates a custom application protocol on a raw session", func(done Done) {
+		sess, err := quic.DialAddr(ln.Addr().String(), &quic.Config{
+			TLSConfig: &tls.Config{
+				InsecureSkipVerify: true,
+				NextProtos:         []string{"custom-proto/1", "custom-proto/2"},
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer sess.Close(nil)
+		Expect(sess.ConnectionState().NegotiatedProtocol).To(Equal("custom-proto/2"))
+		var serverSess quic.Session
+		Eventually(sessChan).Should(Receive(&serverSess))
+		Expect(serverSess.ConnectionState().NegotiatedProtocol).To(Equal("custom-proto/2"))
+		close(done)
+	}, 5)
+
+	It("doesn't negotiate a protocol if the client doesn't offer any", func(done Done) {
+		sess, err := quic.DialAddr(ln.Addr().String(), &quic.Config{
+			TLSConfig: &tls.Config{InsecureSkipVerify: true},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer sess.Close(nil)
+		Expect(sess.ConnectionState().NegotiatedProtocol).To(BeEmpty())
+		close(done)
+	}, 5)
+})
@@ -0,0 +1,183 @@
+package integrationtests
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	quic "github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/h2quic"
+	"github.com/lucas-clemente/quic-go/testdata"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Client certificates", func() {
+	var (
+		clientCAs  *x509.CertPool
+		clientCert tls.Certificate
+	)
+
+	// generateCert generates a certificate signed by the parent, or a self-signed certificate if parent is nil
+	generateCert := func(commonName string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (tls.Certificate, *x509.Certificate, *ecdsa.PrivateKey) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: commonName},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			IsCA:                  parent == nil,
+			BasicConstraintsValid: true,
+		}
+		if parent == nil {
+			parent = template
+			parentKey = key
+		}
+		certDER, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+		Expect(err).ToNot(HaveOccurred())
+		cert, err := x509.ParseCertificate(certDER)
+		Expect(err).ToNot(HaveOccurred())
+		return tls.Certificate{Certificate: [][]byte{certDER}, PrivateKey: key}, cert, key
+	}
+
+	BeforeEach(func() {
+		_, caCert, caKey := generateCert("client CA", nil, nil)
+		clientCAs = x509.NewCertPool()
+		clientCAs.AddCert(caCert)
+		clientCert, _, _ = generateCert("client", caCert, caKey)
+	})
+
+	// serverTLSConfig returns a tls.Config that requires a client certificate signed by the client CA
+	serverTLSConfig := func() *tls.Config {
+		conf := testdata.GetTLSConfig()
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+		conf.ClientCAs = clientCAs
+		return conf
+	}
+
+	Context("establishing a session", func() {
+		var (
+			ln       quic.Listener
+			sessChan chan quic.Session
+		)
+
+		BeforeEach(func() {
+			var err error
+			ln, err = quic.ListenAddr("127.0.0.1:0", &quic.Config{TLSConfig: serverTLSConfig()})
+			Expect(err).ToNot(HaveOccurred())
+			sessChan = make(chan quic.Session, 1)
+			go func() {
+				for {
+					sess, err := ln.Accept()
+					if err != nil {
+						return
+					}
+					sessChan <- sess
+				}
+			}()
+		})
+
+		AfterEach(func() {
+			Expect(ln.Close()).To(Succeed())
+		})
+
+		dial := func(certs []tls.Certificate) (quic.Session, error) {
+			udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+			Expect(err).ToNot(HaveOccurred())
+			port := ln.Addr().(*net.UDPAddr).Port
+			return quic.Dial(udpConn, ln.Addr(), net.JoinHostPort("quic.clemente.io", strconv.Itoa(port)), &quic.Config{
+				TLSConfig: &tls.Config{
+					InsecureSkipVerify: true,
+					Certificates:       certs,
+				},
+			})
+		}
+
+		It("rejects a client without a certificate", func(done Done) {
+			_, err := dial(nil)
+			Expect(err).To(HaveOccurred())
+			Expect(sessChan).ToNot(Receive())
+			close(done)
+		}, 5)
+
+		It("rejects a client with a certificate that isn't signed by the client CA", func(done Done) {
+			untrustedCert, _, _ := generateCert("client", nil, nil)
+			_, err := dial([]tls.Certificate{untrustedCert})
+			Expect(err).To(HaveOccurred())
+			Expect(sessChan).ToNot(Receive())
+			close(done)
+		}, 5)
+
+		It("accepts a client with a valid certificate", func(done Done) {
+			sess, err := dial([]tls.Certificate{clientCert})
+			Expect(err).ToNot(HaveOccurred())
+			defer sess.Close(nil)
+
+			var serverSess quic.Session
+			Eventually(sessChan).Should(Receive(&serverSess))
+			state := serverSess.ConnectionState()
+			Expect(state.PeerCertificates).To(HaveLen(1))
+			Expect(state.PeerCertificates[0].Subject.CommonName).To(Equal("client"))
+			Expect(state.VerifiedChains).To(HaveLen(1))
+			Expect(state.VerifiedChains[0][1].Subject.CommonName).To(Equal("client CA"))
+			close(done)
+		}, 5)
+	})
+
+	Context("using h2quic", func() {
+		var (
+			server     *h2quic.Server
+			serverPort string
+		)
+
+		BeforeEach(func() {
+			err := os.Setenv("HOSTALIASES", "quic.clemente.io 127.0.0.1")
+			Expect(err).ToNot(HaveOccurred())
+
+			server = &h2quic.Server{
+				Server: &http.Server{
+					TLSConfig: serverTLSConfig(),
+					Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+					}),
+				},
+			}
+			conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+			Expect(err).ToNot(HaveOccurred())
+			serverPort = strconv.Itoa(conn.LocalAddr().(*net.UDPAddr).Port)
+			go server.Serve(conn)
+		})
+
+		AfterEach(func() {
+			Expect(server.Close()).To(Succeed())
+		})
+
+		It("exposes the client certificate to the handler", func(done Done) {
+			client := &http.Client{
+				Transport: &h2quic.QuicRoundTripper{
+					TLSClientConfig: &tls.Config{Certificates: []tls.Certificate{clientCert}},
+				},
+			}
+			rsp, err := client.Get("https://quic.clemente.io:" + serverPort + "/")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rsp.StatusCode).To(Equal(200))
+			body, err := ioutil.ReadAll(rsp.Body)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(body)).To(Equal("client"))
+			close(done)
+		}, 5)
+	})
+})
@@ -2,11 +2,16 @@ package integrationtests
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
 
 	"github.com/lucas-clemente/quic-go/h2quic"
 	"github.com/lucas-clemente/quic-go/protocol"
@@ -86,6 +91,134 @@ var _ = Describe("Client tests", func() {
 				Expect(body).To(Equal(dataMan.GetData()))
 				close(done)
 			}, 5)
+
+			It("blocks the upload until the handler reads the request body", func(done Done) {
+				const uploadLen = 5 * 1024 * 1024 // much larger than the flow control window
+				body := &countingReader{r: bytes.NewReader(make([]byte, uploadLen))}
+				rspChan := make(chan *http.Response)
+				go func() {
+					defer GinkgoRecover()
+					resp, err := client.Post("https://quic.clemente.io:"+port+"/slowupload", "application/octet-stream", body)
+					Expect(err).ToNot(HaveOccurred())
+					rspChan <- resp
+				}()
+				Eventually(body.BytesRead).ShouldNot(BeZero())
+				// the client can only send as much as the flow control window allows, plus what's buffered in the stream
+				Consistently(body.BytesRead, 500*time.Millisecond).Should(BeNumerically("<", 4*protocol.ReceiveStreamFlowControlWindow))
+				slowUploadRead <- struct{}{}
+				var resp *http.Response
+				Eventually(rspChan, 10*time.Second).Should(Receive(&resp))
+				Expect(resp.StatusCode).To(Equal(200))
+				rspBody, err := ioutil.ReadAll(resp.Body)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(rspBody)).To(Equal(strconv.Itoa(uploadLen)))
+				close(done)
+			}, 20)
+
+			It("receives flushed data before the handler returns", func(done Done) {
+				resp, err := client.Get("https://quic.clemente.io:" + port + "/flush")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(200))
+				start := time.Now()
+				b := make([]byte, 3)
+				_, err = io.ReadFull(resp.Body, b)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(b)).To(Equal("foo"))
+				// the handler sleeps for 1s before writing the rest of the body
+				Expect(time.Since(start)).To(BeNumerically("<", 500*time.Millisecond))
+				body, err := ioutil.ReadAll(resp.Body)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(body)).To(Equal("bar"))
+				close(done)
+			}, 3)
+
+			It("receives pushed responses", func(done Done) {
+				pushed := make(chan *http.Response, 1)
+				client.Transport = &h2quic.QuicRoundTripper{
+					PushHandler: func(_ *http.Request, rsp *http.Response) { pushed <- rsp },
+				}
+				resp, err := client.Get("https://quic.clemente.io:" + port + "/push")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(200))
+				body, err := ioutil.ReadAll(resp.Body)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(body)).To(Equal("Hello, World!\n"))
+				var pushedRsp *http.Response
+				Eventually(pushed).Should(Receive(&pushedRsp))
+				Expect(pushedRsp.Request.URL.Path).To(Equal("/style.css"))
+				Expect(pushedRsp.StatusCode).To(Equal(200))
+				body, err = ioutil.ReadAll(pushedRsp.Body)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(body)).To(Equal("body { color: black; }\n"))
+				close(done)
+			}, 3)
+
+			It("cancels the request context on the server when the client cancels the request", func(done Done) {
+				req, err := http.NewRequest("GET", "https://quic.clemente.io:"+port+"/cancel", nil)
+				Expect(err).ToNot(HaveOccurred())
+				ctx, cancel := context.WithCancel(context.Background())
+				go func() {
+					time.Sleep(200 * time.Millisecond)
+					cancel()
+				}()
+				_, err = client.Do(req.WithContext(ctx))
+				Expect(err).To(HaveOccurred())
+				Eventually(cancelledRequests).Should(Receive())
+				close(done)
+			}, 3)
+
+			It("receives trailers", func(done Done) {
+				resp, err := client.Get("https://quic.clemente.io:" + port + "/trailer")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(200))
+				Expect(resp.Trailer).To(HaveKey("Grpc-Status"))
+				body, err := ioutil.ReadAll(resp.Body)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(body)).To(Equal("Hello, World!\n"))
+				Expect(resp.Trailer.Get("Grpc-Status")).To(Equal("0"))
+				close(done)
+			}, 3)
+
+			It("transparently decompresses gzipped responses", func(done Done) {
+				resp, err := client.Get("https://quic.clemente.io:" + port + "/gzipped/hello")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(200))
+				Expect(resp.Uncompressed).To(BeTrue())
+				Expect(resp.Header.Get("Content-Encoding")).To(BeEmpty())
+				Expect(resp.ContentLength).To(BeEquivalentTo(-1))
+				body, err := ioutil.ReadAll(resp.Body)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(body)).To(Equal("Hello, World!\n"))
+				close(done)
+			}, 3)
+
+			It("doesn't request gzip if compression is disabled", func(done Done) {
+				client.Transport.(*h2quic.QuicRoundTripper).DisableCompression = true
+				resp, err := client.Get("https://quic.clemente.io:" + port + "/gzipped/hello")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(200))
+				Expect(resp.Uncompressed).To(BeFalse())
+				body, err := ioutil.ReadAll(resp.Body)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(body)).To(Equal("Hello, World!\n"))
+				close(done)
+			}, 3)
 		})
 	}
 })
+
+// countingReader counts the bytes read from the underlying reader
+type countingReader struct {
+	r         io.Reader
+	bytesRead int64 // accessed atomically
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	atomic.AddInt64(&r.bytesRead, int64(n))
+	return n, err
+}
+
+func (r *countingReader) BytesRead() int64 {
+	return atomic.LoadInt64(&r.bytesRead)
+}
@@ -0,0 +1,75 @@
+package integrationtests
+
+import (
+	"context"
+	"crypto/tls"
+
+	quic "github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/qerr"
+	"github.com/lucas-clemente/quic-go/testdata"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Closing sessions", func() {
+	const (
+		errorCode = qerr.ErrorCode(0x1337)
+		reason    = "application went away"
+	)
+
+	var ln quic.Listener
+
+	BeforeEach(func() {
+		var err error
+		ln, err = quic.ListenAddr("localhost:0", &quic.Config{TLSConfig: testdata.GetTLSConfig()})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(ln.Close()).To(Succeed())
+	})
+
+	It("sends the application error code to the client", func(done Done) {
+		go func() {
+			defer GinkgoRecover()
+			sess, err := ln.Accept()
+			Expect(err).ToNot(HaveOccurred())
+			// wait for the client to open a stream, so that we know that the client completed the handshake
+			str, err := sess.AcceptStream(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			_, err = str.Read(make([]byte, 6))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sess.CloseWithError(errorCode, reason)).To(Succeed())
+		}()
+
+		sess, err := quic.DialAddr(ln.Addr().String(), &quic.Config{TLSConfig: &tls.Config{InsecureSkipVerify: true}})
+		Expect(err).ToNot(HaveOccurred())
+		str, err := sess.OpenStreamSync()
+		Expect(err).ToNot(HaveOccurred())
+		_, err = str.Write([]byte("foobar"))
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(sess.Context().Done()).Should(BeClosed())
+		_, err = sess.AcceptStream(context.Background())
+		Expect(err).To(MatchError(qerr.Error(errorCode, reason)))
+		close(done)
+	}, 5)
+
+	It("sends the application error code to the server", func(done Done) {
+		errChan := make(chan error, 1)
+		go func() {
+			defer GinkgoRecover()
+			sess, err := ln.Accept()
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(sess.Context().Done()).Should(BeClosed())
+			_, err = sess.AcceptStream(context.Background())
+			errChan <- err
+		}()
+
+		sess, err := quic.DialAddr(ln.Addr().String(), &quic.Config{TLSConfig: &tls.Config{InsecureSkipVerify: true}})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sess.CloseWithError(errorCode, reason)).To(Succeed())
+		Expect(<-errChan).To(MatchError(qerr.Error(errorCode, reason)))
+		close(done)
+	}, 5)
+})
@@ -0,0 +1,126 @@
+package integrationtests
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/lucas-clemente/quic-go/h2quic"
+	"github.com/lucas-clemente/quic-go/testdata"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CONNECT tunneling", func() {
+	var (
+		proxy     *h2quic.Server
+		proxyPort string
+		echoLn    net.Listener
+		client    *http.Client
+	)
+
+	BeforeEach(func() {
+		err := os.Setenv("HOSTALIASES", "quic.clemente.io 127.0.0.1")
+		Expect(err).ToNot(HaveOccurred())
+
+		// the target of the tunnel echoes all data it receives
+		echoLn, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		go func() {
+			for {
+				conn, err := echoLn.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					defer conn.Close()
+					io.Copy(conn, conn)
+				}()
+			}
+		}()
+
+		// a forward proxy, that opens a TCP connection to the target of the CONNECT request
+		proxy = &h2quic.Server{
+			Server: &http.Server{
+				TLSConfig: testdata.GetTLSConfig(),
+				Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					defer GinkgoRecover()
+					Expect(r.Method).To(Equal("CONNECT"))
+					conn, err := net.Dial("tcp", r.Host)
+					if err != nil {
+						w.WriteHeader(http.StatusBadGateway)
+						return
+					}
+					str, err := w.(h2quic.StreamHijacker).HijackStream()
+					Expect(err).ToNot(HaveOccurred())
+					go func() {
+						io.Copy(conn, str)
+						conn.(*net.TCPConn).CloseWrite()
+					}()
+					go func() {
+						io.Copy(str, conn)
+						str.Close()
+						conn.Close()
+					}()
+				}),
+			},
+		}
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+		Expect(err).ToNot(HaveOccurred())
+		proxyPort = strconv.Itoa(conn.LocalAddr().(*net.UDPAddr).Port)
+		go proxy.Serve(conn)
+
+		client = &http.Client{Transport: &h2quic.QuicRoundTripper{}}
+	})
+
+	AfterEach(func() {
+		Expect(proxy.Close()).To(Succeed())
+		Expect(echoLn.Close()).To(Succeed())
+	})
+
+	It("tunnels data through a CONNECT request", func(done Done) {
+		data := make([]byte, 200*1024)
+		_, err := rand.Read(data)
+		Expect(err).ToNot(HaveOccurred())
+
+		pr, pw := io.Pipe()
+		req, err := http.NewRequest("CONNECT", "https://quic.clemente.io:"+proxyPort, pr)
+		Expect(err).ToNot(HaveOccurred())
+		req.Host = echoLn.Addr().String()
+		rsp, err := client.Do(req)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rsp.StatusCode).To(Equal(200))
+
+		go func() {
+			defer GinkgoRecover()
+			_, err := io.Copy(pw, bytes.NewReader(data))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pw.Close()).To(Succeed())
+		}()
+		received := make([]byte, len(data))
+		_, err = io.ReadFull(rsp.Body, received)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(received).To(Equal(data))
+		Expect(rsp.Body.Close()).To(Succeed())
+		close(done)
+	}, 10)
+
+	It("doesn't establish a tunnel if the proxy can't reach the target", func(done Done) {
+		// get an address that nobody listens on
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ln.Close()).To(Succeed())
+		req, err := http.NewRequest("CONNECT", "https://quic.clemente.io:"+proxyPort, nil)
+		Expect(err).ToNot(HaveOccurred())
+		req.Host = ln.Addr().String()
+		rsp, err := client.Do(req)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rsp.StatusCode).To(Equal(http.StatusBadGateway))
+		close(done)
+	}, 10)
+})
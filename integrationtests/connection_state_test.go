@@ -0,0 +1,66 @@
+package integrationtests
+
+import (
+	"crypto/tls"
+	"net"
+	"strconv"
+
+	quic "github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/testdata"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Connection state", func() {
+	var (
+		ln       quic.Listener
+		sessChan chan quic.Session
+	)
+
+	BeforeEach(func() {
+		var err error
+		ln, err = quic.ListenAddr("127.0.0.1:0", &quic.Config{TLSConfig: testdata.GetTLSConfig()})
+		Expect(err).ToNot(HaveOccurred())
+		sessChan = make(chan quic.Session, 1)
+		go func() {
+			defer GinkgoRecover()
+			sess, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			sessChan <- sess
+		}()
+	})
+
+	AfterEach(func() {
+		Expect(ln.Close()).To(Succeed())
+	})
+
+	It("exposes the details of the handshake", func(done Done) {
+		udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+		Expect(err).ToNot(HaveOccurred())
+		port := ln.Addr().(*net.UDPAddr).Port
+		sess, err := quic.Dial(udpConn, ln.Addr(), net.JoinHostPort("quic.clemente.io", strconv.Itoa(port)), &quic.Config{
+			TLSConfig: &tls.Config{InsecureSkipVerify: true},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer sess.Close(nil)
+
+		state := sess.ConnectionState()
+		Expect(state.HandshakeComplete).To(BeTrue())
+		Expect(state.ServerName).To(Equal("quic.clemente.io"))
+		Expect(state.PeerCertificates).ToNot(BeEmpty())
+		Expect(state.PeerCertificates[0].Subject.CommonName).To(Equal("quic.clemente.io"))
+		Expect(state.AEAD).To(Equal("AESG"))
+		Expect(state.KeyExchange).To(Equal("C255"))
+
+		var serverSess quic.Session
+		Eventually(sessChan).Should(Receive(&serverSess))
+		serverState := serverSess.ConnectionState()
+		Expect(serverState.ServerName).To(Equal("quic.clemente.io"))
+		Expect(serverState.PeerCertificates).To(BeEmpty())
+		Expect(serverState.AEAD).To(Equal("AESG"))
+		close(done)
+	}, 5)
+})
@@ -0,0 +1,73 @@
+package integrationtests
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"net"
+	"runtime"
+	"time"
+
+	quic "github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/qerr"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// quicGoroutines returns the number of goroutines running code of the quic package
+func quicGoroutines() int {
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+	var n int
+	for _, g := range bytes.Split(buf, []byte("\n\n")) {
+		if bytes.Contains(g, []byte("github.com/lucas-clemente/quic-go.")) {
+			n++
+		}
+	}
+	return n
+}
+
+var _ = Describe("Dialing", func() {
+	It("aborts the handshake when the context is cancelled", func(done Done) {
+		// a UDP socket that never responds to the client's handshake packets
+		blackhole, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+		Expect(err).ToNot(HaveOccurred())
+		defer blackhole.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			cancel()
+		}()
+		start := time.Now()
+		_, err = quic.DialAddrContext(ctx, blackhole.LocalAddr().String(), &quic.Config{TLSConfig: &tls.Config{InsecureSkipVerify: true}})
+		Expect(err).To(MatchError(context.Canceled))
+		Expect(time.Since(start)).To(BeNumerically("<", 500*time.Millisecond))
+		// all goroutines started for the dial attempt must have returned
+		Eventually(quicGoroutines).Should(BeZero())
+		close(done)
+	}, 5)
+
+	It("times out the handshake after the configured handshake timeout", func(done Done) {
+		// a UDP socket that never responds to the client's handshake packets
+		blackhole, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+		Expect(err).ToNot(HaveOccurred())
+		defer blackhole.Close()
+
+		start := time.Now()
+		_, err = quic.DialAddr(
+			blackhole.LocalAddr().String(),
+			&quic.Config{
+				TLSConfig:        &tls.Config{InsecureSkipVerify: true},
+				HandshakeTimeout: 200 * time.Millisecond,
+			},
+		)
+		Expect(err).To(HaveOccurred())
+		Expect(err.(*qerr.QuicError).ErrorCode).To(Equal(qerr.HandshakeTimeout))
+		Expect(time.Since(start)).To(BeNumerically("~", 200*time.Millisecond, 100*time.Millisecond))
+		// the session must not keep retransmitting handshake packets
+		Eventually(quicGoroutines).Should(BeZero())
+		close(done)
+	}, 5)
+})
@@ -0,0 +1,47 @@
+// +build go1.11
+
+package integrationtests
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptrace"
+	"net/textproto"
+	"os"
+
+	"github.com/lucas-clemente/quic-go/h2quic"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Early Hints", func() {
+	It("receives a 103 (Early Hints) before the final response", func(done Done) {
+		err := os.Setenv("HOSTALIASES", "quic.clemente.io 127.0.0.1")
+		Expect(err).ToNot(HaveOccurred())
+		client := &http.Client{Transport: &h2quic.QuicRoundTripper{}}
+
+		var statusCodes []int
+		var links []string
+		trace := &httptrace.ClientTrace{
+			Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+				statusCodes = append(statusCodes, code)
+				links = append(links, header.Get("Link"))
+				return nil
+			},
+		}
+		req, err := http.NewRequest("GET", "https://quic.clemente.io:"+port+"/earlyhints", nil)
+		Expect(err).ToNot(HaveOccurred())
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+		rsp, err := client.Do(req)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rsp.StatusCode).To(Equal(200))
+		Expect(rsp.Header.Get("Link")).To(BeEmpty())
+		body, err := ioutil.ReadAll(rsp.Body)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(body)).To(Equal("Hello, World!\n"))
+		Expect(statusCodes).To(Equal([]int{103}))
+		Expect(links).To(Equal([]string{"</style.css>; rel=preload; as=style"}))
+		close(done)
+	}, 5)
+})
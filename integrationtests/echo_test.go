@@ -0,0 +1,142 @@
+package integrationtests
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	"time"
+
+	quic "github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/integrationtests/proxy"
+	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/testdata"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Raw QUIC streams", func() {
+	var (
+		ln         quic.Listener
+		serverAddr string
+	)
+
+	BeforeEach(func() {
+		var err error
+		ln, err = quic.ListenAddr("localhost:0", &quic.Config{TLSConfig: testdata.GetTLSConfig()})
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr = ln.Addr().String()
+
+		// echo all data on the streams opened by the client
+		go func() {
+			defer GinkgoRecover()
+			sess, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			for {
+				str, err := sess.AcceptStream(context.Background())
+				if err != nil {
+					return
+				}
+				go func() {
+					defer GinkgoRecover()
+					_, err := io.Copy(str, str)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(str.Close()).To(Succeed())
+				}()
+			}
+		}()
+	})
+
+	AfterEach(func() {
+		Expect(ln.Close()).To(Succeed())
+	})
+
+	It("echoes data on a stream", func(done Done) {
+		sess, err := quic.DialAddr(serverAddr, &quic.Config{TLSConfig: &tls.Config{InsecureSkipVerify: true}})
+		Expect(err).ToNot(HaveOccurred())
+		defer sess.Close(nil)
+		str, err := sess.OpenStreamSync()
+		Expect(err).ToNot(HaveOccurred())
+		data := bytes.Repeat([]byte("foobar"), 10000)
+		go func() {
+			defer GinkgoRecover()
+			_, err := str.Write(data)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(str.Close()).To(Succeed())
+		}()
+		echoed, err := ioutil.ReadAll(str)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(echoed).To(Equal(data))
+		close(done)
+	}, 5)
+
+	It("echoes data using a session dialed on a net.PacketConn", func(done Done) {
+		udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+		Expect(err).ToNot(HaveOccurred())
+		udpAddr, err := net.ResolveUDPAddr("udp", serverAddr)
+		Expect(err).ToNot(HaveOccurred())
+		sess, err := quic.Dial(udpConn, udpAddr, "quic.clemente.io:1337", &quic.Config{TLSConfig: &tls.Config{InsecureSkipVerify: true}})
+		Expect(err).ToNot(HaveOccurred())
+		defer sess.Close(nil)
+		str, err := sess.OpenStreamSync()
+		Expect(err).ToNot(HaveOccurred())
+		_, err = str.Write([]byte("foobar"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(str.Close()).To(Succeed())
+		echoed, err := ioutil.ReadAll(str)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(echoed).To(Equal([]byte("foobar")))
+		close(done)
+	}, 5)
+
+	It("echoes data on multiple streams", func(done Done) {
+		sess, err := quic.DialAddr(serverAddr, &quic.Config{TLSConfig: &tls.Config{InsecureSkipVerify: true}})
+		Expect(err).ToNot(HaveOccurred())
+		defer sess.Close(nil)
+		for i := 0; i < 3; i++ {
+			str, err := sess.OpenStreamSync()
+			Expect(err).ToNot(HaveOccurred())
+			data := bytes.Repeat([]byte{byte(i)}, 1000)
+			_, err = str.Write(data)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(str.Close()).To(Succeed())
+			echoed, err := ioutil.ReadAll(str)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(echoed).To(Equal(data))
+		}
+		close(done)
+	}, 5)
+
+	It("measures the RTT", func(done Done) {
+		rtt := 100 * time.Millisecond
+		proxy, err := quicproxy.NewQuicProxy("localhost:0", quicproxy.Opts{
+			RemoteAddr: serverAddr,
+			DelayPacket: func(quicproxy.Direction, protocol.PacketNumber) time.Duration {
+				return rtt / 2
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer proxy.Close()
+		sess, err := quic.DialAddr(proxy.LocalAddr().String(), &quic.Config{TLSConfig: &tls.Config{InsecureSkipVerify: true}})
+		Expect(err).ToNot(HaveOccurred())
+		defer sess.Close(nil)
+		str, err := sess.OpenStreamSync()
+		Expect(err).ToNot(HaveOccurred())
+		_, err = str.Write([]byte("foobar"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(str.Close()).To(Succeed())
+		_, err = ioutil.ReadAll(str)
+		Expect(err).ToNot(HaveOccurred())
+		stats := sess.GetRTTStats()
+		Expect(stats.LatestRTT).To(BeNumerically(">=", rtt))
+		Expect(stats.MinRTT).To(BeNumerically(">=", rtt))
+		Expect(stats.SmoothedRTT).To(BeNumerically(">=", rtt))
+		Expect(stats.SmoothedRTT).To(BeNumerically("<", 2*rtt))
+		close(done)
+	}, 5)
+})
@@ -0,0 +1,92 @@
+package integrationtests
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+
+	quic "github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/testdata"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Half-closing streams", func() {
+	var ln quic.Listener
+
+	BeforeEach(func() {
+		var err error
+		ln, err = quic.ListenAddr("localhost:0", &quic.Config{TLSConfig: testdata.GetTLSConfig()})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(ln.Close()).To(Succeed())
+	})
+
+	It("reads the response after closing the write direction", func(done Done) {
+		request := bytes.Repeat([]byte("request"), 1000)
+		response := bytes.Repeat([]byte("response"), 100000)
+		go func() {
+			defer GinkgoRecover()
+			sess, err := ln.Accept()
+			Expect(err).ToNot(HaveOccurred())
+			str, err := sess.AcceptStream(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			// the server only responds once the whole request was received
+			req, err := ioutil.ReadAll(str)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(req).To(Equal(request))
+			_, err = str.Write(response)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(str.CloseWrite()).To(Succeed())
+		}()
+
+		sess, err := quic.DialAddr(ln.Addr().String(), &quic.Config{TLSConfig: &tls.Config{InsecureSkipVerify: true}})
+		Expect(err).ToNot(HaveOccurred())
+		defer sess.Close(nil)
+		str, err := sess.OpenStreamSync()
+		Expect(err).ToNot(HaveOccurred())
+		_, err = str.Write(request)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(str.CloseWrite()).To(Succeed())
+		rsp, err := ioutil.ReadAll(str)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rsp).To(Equal(response))
+		close(done)
+	}, 10)
+
+	It("lets the peer finish sending after closing the read direction", func(done Done) {
+		// more data than fits into the initial flow control window
+		data := bytes.Repeat([]byte("foobar"), 100000)
+		serverDone := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			defer close(serverDone)
+			sess, err := ln.Accept()
+			Expect(err).ToNot(HaveOccurred())
+			str, err := sess.AcceptStream(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			_, err = str.Write(data)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(str.Close()).To(Succeed())
+		}()
+
+		sess, err := quic.DialAddr(ln.Addr().String(), &quic.Config{TLSConfig: &tls.Config{InsecureSkipVerify: true}})
+		Expect(err).ToNot(HaveOccurred())
+		defer sess.Close(nil)
+		str, err := sess.OpenStreamSync()
+		Expect(err).ToNot(HaveOccurred())
+		_, err = str.Write([]byte("request"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(str.CloseRead()).To(Succeed())
+		_, err = io.Copy(ioutil.Discard, str)
+		Expect(err).To(HaveOccurred())
+		// the data is discarded, but the server can still write all of it
+		Eventually(serverDone, 5).Should(BeClosed())
+		close(done)
+	}, 10)
+})
@@ -0,0 +1,114 @@
+package integrationtests
+
+import (
+	"crypto/tls"
+	"fmt"
+	"sync"
+	"time"
+
+	quic "github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/integrationtests/proxy"
+	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/testdata"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Handshake retransmissions", func() {
+	It("retransmits the first flight with increasing intervals", func(done Done) {
+		ln, err := quic.ListenAddr("localhost:0", &quic.Config{TLSConfig: testdata.GetTLSConfig()})
+		Expect(err).ToNot(HaveOccurred())
+		defer ln.Close()
+		go func() {
+			defer GinkgoRecover()
+			_, err := ln.Accept()
+			Expect(err).ToNot(HaveOccurred())
+		}()
+
+		const numDropped = 3
+		var mutex sync.Mutex
+		var sendTimes []time.Time
+		proxy, err := quicproxy.NewQuicProxy("localhost:0", quicproxy.Opts{
+			RemoteAddr: ln.Addr().String(),
+			DropPacket: func(d quicproxy.Direction, p protocol.PacketNumber) bool {
+				if d != quicproxy.DirectionIncoming || p > numDropped+1 {
+					return false
+				}
+				mutex.Lock()
+				sendTimes = append(sendTimes, time.Now())
+				mutex.Unlock()
+				return p <= numDropped
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer proxy.Close()
+
+		sess, err := quic.DialAddr(
+			fmt.Sprintf("localhost:%d", proxy.LocalPort()),
+			&quic.Config{TLSConfig: &tls.Config{InsecureSkipVerify: true}},
+		)
+		Expect(err).ToNot(HaveOccurred())
+		defer sess.Close(nil)
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		// the CHLO was sent numDropped times before making it through
+		Expect(sendTimes).To(HaveLen(numDropped + 1))
+		for i := 2; i < len(sendTimes); i++ {
+			interval := sendTimes[i].Sub(sendTimes[i-1])
+			previousInterval := sendTimes[i-1].Sub(sendTimes[i-2])
+			Expect(interval).To(BeNumerically("~", 2*previousInterval, previousInterval/2))
+		}
+		close(done)
+	}, 10)
+	It("retransmits the CHLO with increasing intervals while the server's packets are lost", func(done Done) {
+		ln, err := quic.ListenAddr("localhost:0", &quic.Config{TLSConfig: testdata.GetTLSConfig()})
+		Expect(err).ToNot(HaveOccurred())
+		defer ln.Close()
+		go func() {
+			defer GinkgoRecover()
+			_, err := ln.Accept()
+			Expect(err).ToNot(HaveOccurred())
+		}()
+
+		const numRetransmissions = 3
+		var mutex sync.Mutex
+		var sendTimes []time.Time
+		proxy, err := quicproxy.NewQuicProxy("localhost:0", quicproxy.Opts{
+			RemoteAddr: ln.Addr().String(),
+			DropPacket: func(d quicproxy.Direction, _ protocol.PacketNumber) bool {
+				mutex.Lock()
+				defer mutex.Unlock()
+				if d == quicproxy.DirectionIncoming {
+					if len(sendTimes) <= numRetransmissions {
+						sendTimes = append(sendTimes, time.Now())
+					}
+					return false
+				}
+				// drop all packets sent by the server, including the ACKs for the CHLO,
+				// until the client retransmitted the CHLO numRetransmissions times
+				return len(sendTimes) <= numRetransmissions
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer proxy.Close()
+
+		sess, err := quic.DialAddr(
+			fmt.Sprintf("localhost:%d", proxy.LocalPort()),
+			&quic.Config{TLSConfig: &tls.Config{InsecureSkipVerify: true}},
+		)
+		Expect(err).ToNot(HaveOccurred())
+		defer sess.Close(nil)
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		Expect(sendTimes).To(HaveLen(numRetransmissions + 1))
+		for i := 2; i < len(sendTimes); i++ {
+			interval := sendTimes[i].Sub(sendTimes[i-1])
+			previousInterval := sendTimes[i-1].Sub(sendTimes[i-2])
+			Expect(interval).To(BeNumerically("~", 2*previousInterval, previousInterval/2))
+		}
+		close(done)
+	}, 10)
+})
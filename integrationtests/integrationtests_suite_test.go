@@ -2,6 +2,7 @@ package integrationtests
 
 import (
 	"bytes"
+	"compress/gzip"
 	"crypto/md5"
 	"encoding/hex"
 	"flag"
@@ -17,6 +18,7 @@ import (
 	"path"
 	"path/filepath"
 	"runtime"
+	"strings"
 
 	"strconv"
 	"time"
@@ -48,6 +50,9 @@ var (
 	clientPath string // path of the quic_client
 	serverPath string // path of the quic_server
 
+	cancelledRequests = make(chan struct{}, 10) // the /cancel handler sends on this channel when the request context is cancelled
+	slowUploadRead    = make(chan struct{})     // the /slowupload handler only starts reading the request body after receiving from this channel
+
 	logFileName string // the log file set in the ginkgo flags
 	logFile     *os.File
 
@@ -144,6 +149,78 @@ func setupHTTPHandlers() {
 		Expect(err).NotTo(HaveOccurred())
 	})
 
+	http.HandleFunc("/trailer", func(w http.ResponseWriter, r *http.Request) {
+		defer GinkgoRecover()
+		w.Header().Set("Trailer", "Grpc-Status")
+		_, err := io.WriteString(w, "Hello, World!\n")
+		Expect(err).NotTo(HaveOccurred())
+		w.Header().Set("Grpc-Status", "0")
+	})
+
+	http.HandleFunc("/flush", func(w http.ResponseWriter, r *http.Request) {
+		defer GinkgoRecover()
+		_, err := io.WriteString(w, "foo")
+		Expect(err).NotTo(HaveOccurred())
+		w.(http.Flusher).Flush()
+		time.Sleep(time.Second)
+		_, err = io.WriteString(w, "bar")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	http.HandleFunc("/push", func(w http.ResponseWriter, r *http.Request) {
+		defer GinkgoRecover()
+		err := w.(http.Pusher).Push("/style.css", nil)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = io.WriteString(w, "Hello, World!\n")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	http.HandleFunc("/style.css", func(w http.ResponseWriter, r *http.Request) {
+		defer GinkgoRecover()
+		_, err := io.WriteString(w, "body { color: black; }\n")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	// gzips the response, if the client accepts gzip
+	http.HandleFunc("/gzipped/hello", func(w http.ResponseWriter, r *http.Request) {
+		defer GinkgoRecover()
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			_, err := io.WriteString(w, "Hello, World!\n")
+			Expect(err).NotTo(HaveOccurred())
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		gw := gzip.NewWriter(w)
+		_, err := io.WriteString(gw, "Hello, World!\n")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(gw.Close()).To(Succeed())
+	})
+
+	// sends a 103 (Early Hints) before the final response
+	http.HandleFunc("/earlyhints", func(w http.ResponseWriter, r *http.Request) {
+		defer GinkgoRecover()
+		w.Header().Add("Link", "</style.css>; rel=preload; as=style")
+		w.WriteHeader(103)
+		w.Header().Del("Link")
+		_, err := io.WriteString(w, "Hello, World!\n")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	// responds with the length of the request body
+	http.HandleFunc("/slowupload", func(w http.ResponseWriter, r *http.Request) {
+		defer GinkgoRecover()
+		<-slowUploadRead
+		n, err := io.Copy(ioutil.Discard, r.Body)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = io.WriteString(w, strconv.FormatInt(n, 10))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	http.HandleFunc("/cancel", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		cancelledRequests <- struct{}{}
+	})
+
 	// requires the num GET parameter, e.g. /uploadform?num=2
 	// will create num input fields for uploading files
 	http.HandleFunc("/uploadform", func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,87 @@
+package integrationtests
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+
+	quic "github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/testdata"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Connection migration", func() {
+	var (
+		ln           quic.Listener
+		serverSess   chan quic.Session
+		transferData = bytes.Repeat([]byte("foobar"), 200000)
+	)
+
+	BeforeEach(func() {
+		var err error
+		ln, err = quic.ListenAddr("localhost:0", &quic.Config{TLSConfig: testdata.GetTLSConfig()})
+		Expect(err).ToNot(HaveOccurred())
+
+		// send data on the first stream opened by the client
+		serverSess = make(chan quic.Session, 1)
+		go func() {
+			defer GinkgoRecover()
+			sess, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			serverSess <- sess
+			str, err := sess.AcceptStream(context.Background())
+			if err != nil {
+				return
+			}
+			// the client might close the session before Write returns
+			if _, err := str.Write(transferData); err != nil {
+				return
+			}
+			str.Close()
+		}()
+	})
+
+	AfterEach(func() {
+		Expect(ln.Close()).To(Succeed())
+	})
+
+	It("continues a transfer after the client switched to a new socket", func(done Done) {
+		udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+		Expect(err).ToNot(HaveOccurred())
+		udpAddr, err := net.ResolveUDPAddr("udp", ln.Addr().String())
+		Expect(err).ToNot(HaveOccurred())
+		sess, err := quic.Dial(udpConn, udpAddr, ln.Addr().String(), &quic.Config{TLSConfig: &tls.Config{InsecureSkipVerify: true}})
+		Expect(err).ToNot(HaveOccurred())
+		defer sess.Close(nil)
+		str, err := sess.OpenStreamSync()
+		Expect(err).ToNot(HaveOccurred())
+		_, err = str.Write([]byte("GET"))
+		Expect(err).ToNot(HaveOccurred())
+
+		// read the first part of the data on the old socket
+		data := make([]byte, len(transferData)/4)
+		_, err = io.ReadFull(str, data)
+		Expect(err).ToNot(HaveOccurred())
+
+		newUDPConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sess.MigrateTo(newUDPConn)).To(Succeed())
+		Expect(sess.LocalAddr()).To(Equal(newUDPConn.LocalAddr()))
+
+		rest, err := ioutil.ReadAll(str)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(append(data, rest...)).To(Equal(transferData))
+
+		var ssess quic.Session
+		Eventually(serverSess).Should(Receive(&ssess))
+		Eventually(ssess.RemoteAddr).Should(Equal(newUDPConn.LocalAddr()))
+		close(done)
+	}, 10)
+})
@@ -0,0 +1,190 @@
+package integrationtests
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+
+	quic "github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/testdata"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// a sizeRecordingConn records the size of the largest packet written
+type sizeRecordingConn struct {
+	net.PacketConn
+
+	mutex          sync.Mutex
+	maxPacketSize  int
+	packetsWritten int
+}
+
+func (c *sizeRecordingConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	c.mutex.Lock()
+	if len(p) > c.maxPacketSize {
+		c.maxPacketSize = len(p)
+	}
+	c.packetsWritten++
+	c.mutex.Unlock()
+	return c.PacketConn.WriteTo(p, addr)
+}
+
+func (c *sizeRecordingConn) getMaxPacketSize() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.maxPacketSize
+}
+
+// an mtuLimitingConn simulates a path MTU by dropping all packets larger than mtu
+// it counts the packets larger than the default packet size that made it through
+type mtuLimitingConn struct {
+	net.PacketConn
+	mtu int
+
+	mutex        sync.Mutex
+	largePackets int
+}
+
+func (c *mtuLimitingConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if len(p) > c.mtu {
+		return len(p), nil
+	}
+	if len(p) > int(protocol.MaxPacketSize) {
+		c.mutex.Lock()
+		c.largePackets++
+		c.mutex.Unlock()
+	}
+	return c.PacketConn.WriteTo(p, addr)
+}
+
+func (c *mtuLimitingConn) getLargePackets() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.largePackets
+}
+
+var _ = Describe("Maximum packet size", func() {
+	const maxPacketSize = 1200
+
+	It("never sends packets larger than the configured size", func(done Done) {
+		serverUDPConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+		Expect(err).ToNot(HaveOccurred())
+		serverConn := &sizeRecordingConn{PacketConn: serverUDPConn}
+		ln, err := quic.Listen(serverConn, &quic.Config{
+			TLSConfig:     testdata.GetTLSConfig(),
+			MaxPacketSize: maxPacketSize,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer ln.Close()
+
+		// echo all data on the first stream opened by the client
+		go func() {
+			defer GinkgoRecover()
+			sess, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			str, err := sess.AcceptStream(context.Background())
+			if err != nil {
+				return
+			}
+			if _, err := io.Copy(str, str); err != nil {
+				return
+			}
+			str.Close()
+		}()
+
+		clientUDPConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+		Expect(err).ToNot(HaveOccurred())
+		clientConn := &sizeRecordingConn{PacketConn: clientUDPConn}
+		sess, err := quic.Dial(clientConn, serverUDPConn.LocalAddr(), serverUDPConn.LocalAddr().String(), &quic.Config{
+			TLSConfig:     &tls.Config{InsecureSkipVerify: true},
+			MaxPacketSize: maxPacketSize,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer sess.Close(nil)
+		str, err := sess.OpenStreamSync()
+		Expect(err).ToNot(HaveOccurred())
+		data := bytes.Repeat([]byte("foobar"), 10000)
+		go func() {
+			defer GinkgoRecover()
+			_, err := str.Write(data)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(str.Close()).To(Succeed())
+		}()
+		echoed, err := ioutil.ReadAll(str)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(echoed).To(Equal(data))
+
+		Expect(clientConn.getMaxPacketSize()).To(BeNumerically("<=", maxPacketSize))
+		Expect(serverConn.getMaxPacketSize()).To(BeNumerically("<=", maxPacketSize))
+		// make sure that the packets are not much smaller than allowed
+		Expect(clientConn.getMaxPacketSize()).To(BeNumerically(">", maxPacketSize-50))
+		Expect(serverConn.getMaxPacketSize()).To(BeNumerically(">", maxPacketSize-50))
+		close(done)
+	}, 10)
+
+	It("increases the packet size if the path supports larger packets", func(done Done) {
+		const pathMTU = 1400
+		serverUDPConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+		Expect(err).ToNot(HaveOccurred())
+		serverConn := &mtuLimitingConn{PacketConn: serverUDPConn, mtu: pathMTU}
+		ln, err := quic.Listen(serverConn, &quic.Config{
+			TLSConfig:              testdata.GetTLSConfig(),
+			EnablePathMTUDiscovery: true,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer ln.Close()
+
+		// echo all data on the first stream opened by the client
+		go func() {
+			defer GinkgoRecover()
+			sess, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			str, err := sess.AcceptStream(context.Background())
+			if err != nil {
+				return
+			}
+			io.Copy(str, str)
+		}()
+
+		clientUDPConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+		Expect(err).ToNot(HaveOccurred())
+		clientConn := &mtuLimitingConn{PacketConn: clientUDPConn, mtu: pathMTU}
+		sess, err := quic.Dial(clientConn, serverUDPConn.LocalAddr(), serverUDPConn.LocalAddr().String(), &quic.Config{
+			TLSConfig:              &tls.Config{InsecureSkipVerify: true},
+			EnablePathMTUDiscovery: true,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer sess.Close(nil)
+		str, err := sess.OpenStreamSync()
+		Expect(err).ToNot(HaveOccurred())
+
+		// keep sending data until the discovery found the larger packet size, in both directions
+		// only a handful of probes are sent, so most of the large packets are data packets
+		data := bytes.Repeat([]byte("foobar"), 10000)
+		echoed := make([]byte, len(data))
+		Eventually(func() int {
+			_, err := str.Write(data)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = io.ReadFull(str, echoed)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(echoed).To(Equal(data))
+			if n := serverConn.getLargePackets(); n < clientConn.getLargePackets() {
+				return n
+			}
+			return clientConn.getLargePackets()
+		}, 5*time.Second, time.Millisecond).Should(BeNumerically(">", 30))
+		close(done)
+	}, 15)
+})
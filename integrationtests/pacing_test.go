@@ -0,0 +1,101 @@
+package integrationtests
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	quic "github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/integrationtests/proxy"
+	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/testdata"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Pacing", func() {
+	const rtt = 40 * time.Millisecond
+
+	var (
+		ln    quic.Listener
+		proxy *quicproxy.QuicProxy
+
+		mutex        sync.Mutex
+		packetTimes  []time.Time // the times the packets sent by the server arrived at the proxy
+		transferData = bytes.Repeat([]byte("foobar"), 50000)
+	)
+
+	BeforeEach(func() {
+		var err error
+		ln, err = quic.ListenAddr("localhost:0", &quic.Config{
+			TLSConfig:    testdata.GetTLSConfig(),
+			EnablePacing: true,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		// send data on the first stream opened by the client
+		go func() {
+			defer GinkgoRecover()
+			sess, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			str, err := sess.AcceptStream(context.Background())
+			if err != nil {
+				return
+			}
+			_, err = str.Write(transferData)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(str.Close()).To(Succeed())
+		}()
+
+		packetTimes = nil
+		proxy, err = quicproxy.NewQuicProxy("localhost:0", quicproxy.Opts{
+			RemoteAddr: ln.Addr().String(),
+			DelayPacket: func(d quicproxy.Direction, _ protocol.PacketNumber) time.Duration {
+				if d == quicproxy.DirectionOutgoing {
+					mutex.Lock()
+					packetTimes = append(packetTimes, time.Now())
+					mutex.Unlock()
+				}
+				return rtt / 2
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(proxy.Close()).To(Succeed())
+		Expect(ln.Close()).To(Succeed())
+	})
+
+	It("spaces the packets of a bulk transfer", func(done Done) {
+		sess, err := quic.DialAddr(proxy.LocalAddr().String(), &quic.Config{TLSConfig: &tls.Config{InsecureSkipVerify: true}})
+		Expect(err).ToNot(HaveOccurred())
+		defer sess.Close(nil)
+		str, err := sess.OpenStreamSync()
+		Expect(err).ToNot(HaveOccurred())
+		_, err = str.Write([]byte("GET"))
+		Expect(err).ToNot(HaveOccurred())
+		data, err := ioutil.ReadAll(str)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(data).To(Equal(transferData))
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		Expect(len(packetTimes)).To(BeNumerically(">", 100))
+		// without pacing, most packets are sent back-to-back
+		var spaced int
+		for i := 1; i < len(packetTimes); i++ {
+			if packetTimes[i].Sub(packetTimes[i-1]) > 50*time.Microsecond {
+				spaced++
+			}
+		}
+		Expect(spaced).To(BeNumerically(">", len(packetTimes)/2))
+		close(done)
+	}, 10)
+})
@@ -0,0 +1,114 @@
+package integrationtests
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"net"
+	"time"
+
+	quic "github.com/lucas-clemente/quic-go"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Certificate selection by SNI", func() {
+	const (
+		hostA = "a.quic.example"
+		hostB = "b.quic.example"
+	)
+
+	var (
+		ln         quic.Listener
+		serverAddr *net.UDPAddr
+		certs      map[string]tls.Certificate
+		roots      map[string]*x509.CertPool
+	)
+
+	// generateCert generates a self-signed certificate for the hostname
+	generateCert := func(hostname string) (tls.Certificate, *x509.Certificate) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			DNSNames:              []string{hostname},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+		}
+		certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		Expect(err).ToNot(HaveOccurred())
+		cert, err := x509.ParseCertificate(certDER)
+		Expect(err).ToNot(HaveOccurred())
+		return tls.Certificate{Certificate: [][]byte{certDER}, PrivateKey: key}, cert
+	}
+
+	BeforeEach(func() {
+		certs = make(map[string]tls.Certificate)
+		roots = make(map[string]*x509.CertPool)
+		for _, host := range []string{hostA, hostB} {
+			tlsCert, cert := generateCert(host)
+			certs[host] = tlsCert
+			roots[host] = x509.NewCertPool()
+			roots[host].AddCert(cert)
+		}
+
+		tlsConf := &tls.Config{
+			GetCertificate: func(chi *tls.ClientHelloInfo) (*tls.Certificate, error) {
+				cert, ok := certs[chi.ServerName]
+				if !ok {
+					return nil, nil
+				}
+				return &cert, nil
+			},
+		}
+		var err error
+		ln, err = quic.ListenAddr("127.0.0.1:0", &quic.Config{TLSConfig: tlsConf})
+		Expect(err).ToNot(HaveOccurred())
+		serverAddr = ln.Addr().(*net.UDPAddr)
+		go func() {
+			for {
+				if _, err := ln.Accept(); err != nil {
+					return
+				}
+			}
+		}()
+	})
+
+	AfterEach(func() {
+		Expect(ln.Close()).To(Succeed())
+	})
+
+	// dial uses host for SNI, and only trusts the certificates in rootCAs
+	dial := func(host string, rootCAs *x509.CertPool) (quic.Session, error) {
+		udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+		Expect(err).ToNot(HaveOccurred())
+		return quic.Dial(udpConn, serverAddr, net.JoinHostPort(host, "443"), &quic.Config{
+			TLSConfig: &tls.Config{
+				RootCAs:    rootCAs,
+				ServerName: host,
+			},
+		})
+	}
+
+	It("presents the certificate for the SNI the client dialed", func(done Done) {
+		for _, host := range []string{hostA, hostB} {
+			// the handshake fails if the server presents the certificate of the other host
+			sess, err := dial(host, roots[host])
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sess.Close(nil)).To(Succeed())
+		}
+		close(done)
+	}, 10)
+
+	It("fails the handshake if the client doesn't trust the certificate for the SNI", func(done Done) {
+		_, err := dial(hostA, roots[hostB])
+		Expect(err).To(HaveOccurred())
+		close(done)
+	}, 10)
+})
@@ -0,0 +1,79 @@
+package integrationtests
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+
+	quic "github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/qerr"
+	"github.com/lucas-clemente/quic-go/testdata"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Stream resets", func() {
+	var ln quic.Listener
+
+	BeforeEach(func() {
+		var err error
+		ln, err = quic.ListenAddr("localhost:0", &quic.Config{TLSConfig: testdata.GetTLSConfig()})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(ln.Close()).To(Succeed())
+	})
+
+	It("resets a single stream without closing the connection", func(done Done) {
+		data := bytes.Repeat([]byte("foobar"), 10000)
+		readErrChan := make(chan error, 1)
+		go func() {
+			defer GinkgoRecover()
+			sess, err := ln.Accept()
+			Expect(err).ToNot(HaveOccurred())
+			// the first stream is reset by the client
+			str, err := sess.AcceptStream(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			_, err = io.Copy(ioutil.Discard, str)
+			readErrChan <- err
+			// the second stream is echoed
+			str, err = sess.AcceptStream(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			_, err = io.Copy(str, str)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(str.Close()).To(Succeed())
+		}()
+
+		sess, err := quic.DialAddr(ln.Addr().String(), &quic.Config{TLSConfig: &tls.Config{InsecureSkipVerify: true}})
+		Expect(err).ToNot(HaveOccurred())
+		defer sess.Close(nil)
+		str, err := sess.OpenStreamSync()
+		Expect(err).ToNot(HaveOccurred())
+		_, err = str.Write(data[:len(data)/2])
+		Expect(err).ToNot(HaveOccurred())
+		str.Reset(qerr.Error(0x42, "aborted"))
+		_, err = str.Write(data[len(data)/2:])
+		Expect(err).To(MatchError(qerr.Error(0x42, "aborted")))
+
+		var readErr error
+		Eventually(readErrChan).Should(Receive(&readErr))
+		Expect(readErr).To(Equal(&quic.StreamResetError{ErrorCode: 0x42}))
+
+		str, err = sess.OpenStreamSync()
+		Expect(err).ToNot(HaveOccurred())
+		go func() {
+			defer GinkgoRecover()
+			_, err := str.Write(data)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(str.Close()).To(Succeed())
+		}()
+		echoed, err := ioutil.ReadAll(str)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(echoed).To(Equal(data))
+		close(done)
+	}, 10)
+})
@@ -0,0 +1,98 @@
+package integrationtests
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"sync"
+
+	quic "github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/testdata"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type recordingTracer struct {
+	mutex    sync.Mutex
+	sent     []quic.FrameInfo
+	received []quic.FrameInfo
+}
+
+func (t *recordingTracer) SentFrame(f quic.FrameInfo) {
+	t.mutex.Lock()
+	t.sent = append(t.sent, f)
+	t.mutex.Unlock()
+}
+
+func (t *recordingTracer) ReceivedFrame(f quic.FrameInfo) {
+	t.mutex.Lock()
+	t.received = append(t.received, f)
+	t.mutex.Unlock()
+}
+
+func (t *recordingTracer) getSent() []quic.FrameInfo {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return append([]quic.FrameInfo(nil), t.sent...)
+}
+
+func (t *recordingTracer) getReceived() []quic.FrameInfo {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return append([]quic.FrameInfo(nil), t.received...)
+}
+
+func frameTypes(fs []quic.FrameInfo) []string {
+	types := make([]string, len(fs))
+	for i, f := range fs {
+		types[i] = f.Type
+	}
+	return types
+}
+
+var _ = Describe("Tracer", func() {
+	It("traces the frames sent and received", func(done Done) {
+		ln, err := quic.ListenAddr("localhost:0", &quic.Config{TLSConfig: testdata.GetTLSConfig()})
+		Expect(err).ToNot(HaveOccurred())
+		defer ln.Close()
+
+		go func() {
+			defer GinkgoRecover()
+			sess, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			str, err := sess.OpenStream()
+			Expect(err).ToNot(HaveOccurred())
+			_, err = str.Write([]byte("foobar"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(str.Close()).To(Succeed())
+		}()
+
+		tracer := &recordingTracer{}
+		sess, err := quic.DialAddr(
+			ln.Addr().String(),
+			&quic.Config{TLSConfig: &tls.Config{InsecureSkipVerify: true}, Tracer: tracer},
+		)
+		Expect(err).ToNot(HaveOccurred())
+		defer sess.Close(nil)
+		str, err := sess.AcceptStream(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		data, err := ioutil.ReadAll(str)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(data).To(Equal([]byte("foobar")))
+
+		var streamFrame *quic.FrameInfo
+		for _, f := range tracer.getReceived() {
+			if f.Type == "STREAM" && f.StreamID == str.StreamID() {
+				streamFrame = &f
+				break
+			}
+		}
+		Expect(streamFrame).ToNot(BeNil())
+		Expect(streamFrame.PacketNumber).ToNot(BeZero())
+		Eventually(func() []string { return frameTypes(tracer.getSent()) }).Should(ContainElement("ACK"))
+		close(done)
+	}, 5)
+})
@@ -0,0 +1,129 @@
+package integrationtests
+
+import (
+	"bytes"
+	"crypto/tls"
+	"net"
+	"sync"
+
+	quic "github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/qerr"
+	"github.com/lucas-clemente/quic-go/testdata"
+	"github.com/lucas-clemente/quic-go/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// a vnForgingConn drops the first packet sent by the client,
+// and lets an on-path attacker reply with a forged version negotiation packet
+type vnForgingConn struct {
+	net.PacketConn
+	attacker net.PacketConn
+	versions []protocol.VersionNumber
+
+	once sync.Once
+}
+
+func (c *vnForgingConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	var forged bool
+	var err error
+	c.once.Do(func() {
+		forged = true
+		err = c.forgeVersionNegotiation(p)
+	})
+	if forged {
+		return len(p), err
+	}
+	return c.PacketConn.WriteTo(p, addr)
+}
+
+func (c *vnForgingConn) forgeVersionNegotiation(p []byte) error {
+	hdr, err := quic.ParsePublicHeader(bytes.NewReader(p), protocol.PerspectiveClient)
+	if err != nil {
+		return err
+	}
+	b := &bytes.Buffer{}
+	vn := quic.PublicHeader{
+		ConnectionID: hdr.ConnectionID,
+		PacketNumber: 1,
+		VersionFlag:  true,
+	}
+	if err := vn.Write(b, protocol.VersionWhatever, protocol.PerspectiveServer); err != nil {
+		return err
+	}
+	for _, v := range c.versions {
+		utils.WriteUint32(b, protocol.VersionNumberToTag(v))
+	}
+	_, err = c.attacker.WriteTo(b.Bytes(), c.LocalAddr())
+	return err
+}
+
+var _ = Describe("Version negotiation", func() {
+	It("negotiates down to a version supported by the server", func(done Done) {
+		serverVersion := protocol.SupportedVersions[len(protocol.SupportedVersions)-1]
+		clientVersions := protocol.SupportedVersions
+		Expect(clientVersions[0]).ToNot(Equal(serverVersion))
+
+		ln, err := quic.ListenAddr("localhost:0", &quic.Config{
+			TLSConfig: testdata.GetTLSConfig(),
+			Versions:  []protocol.VersionNumber{serverVersion},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer ln.Close()
+
+		serverSessChan := make(chan quic.Session)
+		go func() {
+			defer GinkgoRecover()
+			sess, err := ln.Accept()
+			Expect(err).ToNot(HaveOccurred())
+			serverSessChan <- sess
+		}()
+
+		sess, err := quic.DialAddr(ln.Addr().String(), &quic.Config{
+			TLSConfig: &tls.Config{InsecureSkipVerify: true},
+			Versions:  clientVersions,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer sess.Close(nil)
+		Expect(sess.GetVersion()).To(Equal(serverVersion))
+		var serverSess quic.Session
+		Eventually(serverSessChan).Should(Receive(&serverSess))
+		Expect(serverSess.GetVersion()).To(Equal(serverVersion))
+		close(done)
+	}, 5)
+	It("detects a downgrade by a forged version negotiation packet", func(done Done) {
+		ln, err := quic.ListenAddr("127.0.0.1:0", &quic.Config{TLSConfig: testdata.GetTLSConfig()})
+		Expect(err).ToNot(HaveOccurred())
+		defer ln.Close()
+		go func() {
+			for {
+				if _, err := ln.Accept(); err != nil {
+					return
+				}
+			}
+		}()
+
+		clientConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+		Expect(err).ToNot(HaveOccurred())
+		attacker, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+		Expect(err).ToNot(HaveOccurred())
+		defer attacker.Close()
+		// the attacker claims that the server only supports the oldest version
+		oldestVersion := protocol.SupportedVersions[len(protocol.SupportedVersions)-1]
+		Expect(protocol.SupportedVersions[0]).ToNot(Equal(oldestVersion))
+		conn := &vnForgingConn{
+			PacketConn: clientConn,
+			attacker:   attacker,
+			versions:   []protocol.VersionNumber{oldestVersion},
+		}
+		_, err = quic.Dial(conn, ln.Addr(), ln.Addr().String(), &quic.Config{
+			TLSConfig: &tls.Config{InsecureSkipVerify: true},
+		})
+		Expect(err).To(HaveOccurred())
+		Expect(err).To(BeAssignableToTypeOf(&qerr.QuicError{}))
+		Expect(err.(*qerr.QuicError).ErrorCode).To(Equal(qerr.VersionNegotiationMismatch))
+		close(done)
+	}, 5)
+})
@@ -0,0 +1,149 @@
+package integrationtests
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	quic "github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/integrationtests/proxy"
+	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/testdata"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type tokenStore struct {
+	mutex sync.Mutex
+	data  map[string][]byte
+}
+
+var _ quic.TokenStore = &tokenStore{}
+
+func (s *tokenStore) Get(hostname string) ([]byte, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	data, ok := s.data[hostname]
+	return data, ok
+}
+
+func (s *tokenStore) Put(hostname string, data []byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.data[hostname] = data
+}
+
+var _ = Describe("0-RTT", func() {
+	// the delay applied to all packets sent by the server
+	const delay = 100 * time.Millisecond
+
+	var (
+		ln       quic.Listener
+		store    *tokenStore
+		received chan []byte
+	)
+
+	BeforeEach(func() {
+		var err error
+		ln, err = quic.ListenAddr("localhost:0", &quic.Config{TLSConfig: testdata.GetTLSConfig()})
+		Expect(err).ToNot(HaveOccurred())
+		store = &tokenStore{data: make(map[string][]byte)}
+		received = make(chan []byte, 2)
+
+		go func() {
+			defer GinkgoRecover()
+			for {
+				sess, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					defer GinkgoRecover()
+					str, err := sess.AcceptStream(context.Background())
+					if err != nil {
+						return
+					}
+					data, err := ioutil.ReadAll(str)
+					Expect(err).ToNot(HaveOccurred())
+					received <- data
+				}()
+			}
+		}()
+	})
+
+	AfterEach(func() {
+		Expect(ln.Close()).To(Succeed())
+	})
+
+	// dial connects to the server via a proxy, and sends data on a stream as soon as possible
+	// it returns the number of packets the client sent before it received the first packet from the server
+	dial := func(data []byte) int {
+		var mutex sync.Mutex
+		var clientPacketTimes []time.Time
+		var firstServerPacketTime time.Time
+		proxy, err := quicproxy.NewQuicProxy("localhost:0", quicproxy.Opts{
+			RemoteAddr: ln.Addr().String(),
+			DelayPacket: func(d quicproxy.Direction, _ protocol.PacketNumber) time.Duration {
+				mutex.Lock()
+				defer mutex.Unlock()
+				if d == quicproxy.DirectionIncoming {
+					clientPacketTimes = append(clientPacketTimes, time.Now())
+					return 0
+				}
+				if firstServerPacketTime.IsZero() {
+					firstServerPacketTime = time.Now()
+				}
+				return delay
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer proxy.Close()
+
+		sess, err := quic.DialAddrNonFWSecure(
+			fmt.Sprintf("localhost:%d", proxy.LocalPort()),
+			&quic.Config{
+				TLSConfig:  &tls.Config{InsecureSkipVerify: true},
+				TokenStore: store,
+			},
+		)
+		Expect(err).ToNot(HaveOccurred())
+		defer sess.Close(nil)
+		str, err := sess.OpenStreamSync()
+		Expect(err).ToNot(HaveOccurred())
+		_, err = str.Write(data)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(str.Close()).To(Succeed())
+		Eventually(received).Should(Receive(Equal(data)))
+		Expect(sess.WaitUntilHandshakeComplete()).To(Succeed())
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		var firstFlight int
+		for _, t := range clientPacketTimes {
+			if t.Before(firstServerPacketTime.Add(delay)) {
+				firstFlight++
+			}
+		}
+		return firstFlight
+	}
+
+	It("sends data in the first flight when reconnecting", func() {
+		// the first connection only sends the inchoate CHLO in the first flight
+		Expect(dial([]byte("foobar"))).To(Equal(1))
+		_, ok := store.Get("localhost")
+		Expect(ok).To(BeTrue())
+		// the second connection sends the CHLO and the data in the first flight
+		Expect(dial([]byte("raboof"))).To(BeNumerically(">=", 2))
+	})
+
+	It("accepts the previous server config after the server config was rotated", func() {
+		Expect(dial([]byte("foobar"))).To(Equal(1))
+		Expect(ln.RotateServerConfig()).To(Succeed())
+		// the client still uses the cached server config, which is accepted during the overlap period
+		Expect(dial([]byte("raboof"))).To(BeNumerically(">=", 2))
+	})
+})
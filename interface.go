@@ -1,29 +1,94 @@
 package quic
 
 import (
+	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"io"
 	"net"
 	"time"
 
+	"github.com/lucas-clemente/quic-go/handshake"
 	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/qerr"
+	"github.com/lucas-clemente/quic-go/utils"
+)
+
+// A Priority is the relative priority of a stream.
+// When multiple streams have data to send, the data of the streams with the highest priority is sent first.
+// Streams with the same priority share the available bandwidth.
+type Priority int8
+
+// DefaultPriority is the priority of a new stream.
+const DefaultPriority Priority = 0
+
+// StreamScheduling determines how the data of multiple streams that have data to send is scheduled.
+// In both cases, streams with a higher Priority are scheduled first.
+type StreamScheduling uint8
+
+const (
+	// StreamSchedulingRoundRobin lets the streams take turns: a packet is filled with the data of one stream, before the next stream gets its turn.
+	StreamSchedulingRoundRobin StreamScheduling = iota
+	// StreamSchedulingInterleaved splits every packet evenly between the streams that have data to send.
+	// This reduces the latency when sending on many streams at the same time, at the cost of a few more bytes for the STREAM frame headers.
+	StreamSchedulingInterleaved
 )
 
 // Stream is the interface implemented by QUIC streams
 type Stream interface {
 	io.Reader
+	// Write blocks until the data was packed into packets. It doesn't wait for the peer to acknowledge the data.
+	// If the peer's flow control window is used up, Write blocks until the peer increases it.
+	// Only a bounded amount of data is buffered at any time, independent of the length of the slice passed to Write.
 	io.Writer
+	// Close closes the write direction of the stream, see CloseWrite.
+	// The stream is only released once both directions are done: the FIN was sent, and all data was read up to io.EOF, or discarded using CloseRead.
 	io.Closer
+	// CloseWrite sends a FIN after all data written to the stream, without affecting the read direction.
+	// Data can still be read from the stream, until the peer closes its write direction.
+	// It is equivalent to calling Close.
+	CloseWrite() error
+	// CloseRead stops accepting incoming data on the stream.
+	// Data that is queued or received afterwards is discarded, and the peer's flow control window is still increased, such that the peer can finish sending.
+	// Blocked Read and WriteTo calls return, and all future calls return an error.
+	// The write direction is not affected.
+	CloseRead() error
+	// WriteTo writes the data received on the stream to w, until the peer closes the stream.
+	// The data is passed to w without being copied into an intermediate buffer.
+	io.WriterTo
+	// ReadFrom sends the data read from r on the stream, until r returns io.EOF.
+	// The buffers that r is read into are sent without copying them.
+	io.ReaderFrom
 	StreamID() protocol.StreamID
 	// Reset closes the stream with an error.
 	Reset(error)
+	// SetReadDeadline sets the deadline for future Read calls and any currently-blocked Read call.
+	// A zero value for t means Read will not time out.
+	SetReadDeadline(t time.Time) error
+	// SetWriteDeadline sets the deadline for future Write calls and any currently-blocked Write call.
+	// Even if write times out, it may return n > 0, indicating that some of the data was successfully written.
+	// A zero value for t means Write will not time out.
+	SetWriteDeadline(t time.Time) error
+	// SetDeadline sets the read and write deadlines associated with the stream.
+	// It is equivalent to calling both SetReadDeadline and SetWriteDeadline.
+	SetDeadline(t time.Time) error
+	// Context returns a context that is cancelled when the stream is reset, either locally or by the peer, or when the session is closed.
+	Context() context.Context
+	// SetPriority sets the priority of the stream, see Priority.
+	// The crypto stream and the headers stream are always sent first, regardless of the priority.
+	SetPriority(Priority)
 }
 
 // A Session is a QUIC connection between two peers.
+// All streams are bidirectional: the gQUIC versions supported don't have unidirectional streams, and there's no way to tell the peer that a stream is only used in one direction.
+// To use a stream in one direction only, the receiving side can Close it right after accepting it.
+// This only sends a FIN, the receiving side still has to read the stream until io.EOF, otherwise the stream is never released.
+// The sending side Closes the stream when it is done writing, and calls CloseRead, or reads the stream until io.EOF, which it receives as soon as the peer's FIN arrives.
 type Session interface {
 	// AcceptStream returns the next stream opened by the peer, blocking until one is available.
+	// If the context is cancelled before, it returns ctx.Err(). If the session is closed, it returns the error the session was closed with.
 	// Since stream 1 is reserved for the crypto stream, the first stream is either 2 (for a client) or 3 (for a server).
-	AcceptStream() (Stream, error)
+	AcceptStream(ctx context.Context) (Stream, error)
 	// OpenStream opens a new QUIC stream, returning a special error when the peeer's concurrent stream limit is reached.
 	// New streams always have the smallest possible stream ID.
 	// TODO: Enable testing for the special error
@@ -34,9 +99,36 @@ type Session interface {
 	// LocalAddr returns the local address.
 	LocalAddr() net.Addr
 	// RemoteAddr returns the address of the peer.
+	// If the client's address changes, the server only uses the new address after validating it.
 	RemoteAddr() net.Addr
+	// MigrateTo continues the session on a new packet conn, e.g. after the client switched networks.
+	// The connection ID stays the same, and the old packet conn is closed.
+	// gQUIC has no path validation frames. Instead, the server only switches to a new address after it received an authenticated packet from that address.
+	// Only clients can migrate.
+	MigrateTo(net.PacketConn) error
+	// Stats returns statistics about the session.
+	Stats() SessionStats
+	// GetRTTStats returns a snapshot of the round-trip time statistics of the session.
+	// The values are zero until the first RTT sample was taken.
+	GetRTTStats() RTTStats
+	// HandshakeComplete returns a channel that is closed as soon as the crypto handshake completes successfully,
+	// i.e. when the forward-secure keys are available. It is never closed if the handshake fails.
+	HandshakeComplete() <-chan struct{}
+	// Context returns a context that is cancelled when the session is closed.
+	// The error that caused the session to close is returned by AcceptStream, OpenStream and the streams' Read and Write.
+	// If the peer closed the session, this is a *qerr.QuicError containing the error code and the reason phrase it sent.
+	// Once the context is cancelled, its Err method returns this error as a *qerr.QuicError, e.g. the code passed to CloseWithError.
+	Context() context.Context
+	// GetVersion returns the QUIC version in use, after any version negotiation.
+	GetVersion() protocol.VersionNumber
+	// ConnectionState returns basic details about the handshake, e.g. the server name, the server's certificates and the application protocol negotiated using ALPN.
+	// The values are only complete once the handshake completed.
+	ConnectionState() ConnectionState
 	// Close closes the connection. The error will be sent to the remote peer in a CONNECTION_CLOSE frame. An error value of nil is allowed and will cause a normal PeerGoingAway to be sent.
 	Close(error) error
+	// CloseWithError closes the connection with an application-defined error code and reason phrase, which are sent to the peer in a CONNECTION_CLOSE frame.
+	// The peer's AcceptStream, OpenStream and streams return a *qerr.QuicError carrying the code and the reason.
+	CloseWithError(code qerr.ErrorCode, reason string) error
 }
 
 // A NonFWSession is a QUIC connection between two peers half-way through the handshake.
@@ -58,13 +150,110 @@ type STK struct {
 	sentTime time.Time
 }
 
+// ClientHelloInfo contains the parameters of a client's CHLO.
+// It is passed to Config.AcceptClientHello.
+type ClientHelloInfo struct {
+	// RemoteAddr is the address of the client.
+	RemoteAddr net.Addr
+	// ServerName is the server name indication (SNI) sent by the client.
+	ServerName string
+	// SupportedProtos are the application protocols offered by the client using ALPN.
+	// It is empty if the client didn't offer any protocols.
+	SupportedProtos []string
+}
+
+// A TokenStore stores the state received from servers during the handshake, keyed by the hostname.
+// It is used by the client to perform 0-RTT handshakes. For the client, the stored data is an opaque blob.
+type TokenStore = handshake.TokenStore
+
+// A Tracer is informed about every frame sent and received by a session.
+// Its methods are called from the session's run loop, so they should return quickly.
+// When used for a server, the same Tracer is called for all sessions, possibly from multiple goroutines at the same time.
+type Tracer interface {
+	// SentFrame is called for every frame packed into a packet that is about to be sent.
+	SentFrame(FrameInfo)
+	// ReceivedFrame is called for every frame of a packet that was received and decrypted successfully.
+	ReceivedFrame(FrameInfo)
+}
+
+// FrameInfo describes a frame sent or received.
+type FrameInfo struct {
+	// Type is the name of the frame type, e.g. STREAM, ACK or WINDOW_UPDATE.
+	Type string
+	// StreamID is the ID of the stream that the frame belongs to.
+	// It is only set for STREAM, RST_STREAM, WINDOW_UPDATE and BLOCKED frames, and it is 0 for connection-level WINDOW_UPDATE and BLOCKED frames.
+	StreamID protocol.StreamID
+	// PacketNumber is the number of the packet that contains the frame.
+	PacketNumber protocol.PacketNumber
+}
+
+// SessionStats contains statistics about a session.
+// All byte counts include the packet overhead (Public Header, frame headers and encryption).
+type SessionStats struct {
+	BytesSent       protocol.ByteCount
+	BytesReceived   protocol.ByteCount
+	PacketsSent     uint64
+	PacketsReceived uint64
+	// PacketsRetransmitted is the number of packets that were declared lost, and whose frames were queued for retransmission.
+	PacketsRetransmitted uint64
+	// StreamsOpened is the number of streams opened by both peers. The crypto stream is not counted.
+	StreamsOpened uint64
+	// DontFragment is true if the DF bit is set on the packets sent.
+	// It is set on Linux for the connections created by quic-go, i.e. when using DialAddr or ListenAddr.
+	// Without the DF bit, packets larger than the path MTU might be fragmented instead of being dropped, and path MTU discovery can't detect the MTU.
+	DontFragment bool
+	// CongestionWindow is the current congestion window of the congestion controller.
+	CongestionWindow protocol.ByteCount
+	// BytesInFlight is the number of bytes sent, but neither acknowledged nor declared lost.
+	// No new packets are sent while it exceeds the congestion window.
+	BytesInFlight protocol.ByteCount
+	// InSlowStart is true while the congestion controller is in slow start, and false in congestion avoidance.
+	InSlowStart bool
+	// SlowStartThreshold is the congestion window at which slow start is exited (ssthresh).
+	SlowStartThreshold protocol.ByteCount
+}
+
+// ConnectionState records basic details about the handshake of a session.
+type ConnectionState struct {
+	// HandshakeComplete is true once the handshake completed.
+	HandshakeComplete bool
+	// ServerName is the server name indication (SNI) sent by the client.
+	ServerName string
+	// NegotiatedProtocol is the application protocol negotiated using ALPN.
+	// The client offers the protocols in its tls.Config.NextProtos, and the server selects the first protocol in its tls.Config.NextProtos that the client offered.
+	// It is empty if no protocol was negotiated.
+	NegotiatedProtocol string
+	// PeerCertificates is the certificate chain presented by the peer, starting with the leaf certificate.
+	// For the server, it is only set if the client sent a certificate, see tls.Config.ClientAuth.
+	PeerCertificates []*x509.Certificate
+	// VerifiedChains is a list of one or more chains built from the client certificate, where the last element of each chain is a certificate in tls.Config.ClientCAs.
+	// It is only set for the server, if ClientAuth is VerifyClientCertIfGiven or RequireAndVerifyClientCert.
+	VerifiedChains [][]*x509.Certificate
+	// AEAD is the tag of the AEAD used to encrypt packets (e.g. "AESG" for AES-128-GCM).
+	AEAD string
+	// KeyExchange is the tag of the key exchange algorithm (e.g. "C255" for Curve25519).
+	KeyExchange string
+}
+
+// RTTStats contains the round-trip time statistics of a session.
+type RTTStats struct {
+	// SmoothedRTT is the exponentially weighted moving average of the RTT samples.
+	SmoothedRTT time.Duration
+	// LatestRTT is the most recent RTT sample.
+	LatestRTT time.Duration
+	// MinRTT is the smallest RTT sample seen on the connection.
+	MinRTT time.Duration
+	// MeanDeviation is the mean deviation of the RTT samples.
+	MeanDeviation time.Duration
+}
+
 // Config contains all configuration data needed for a QUIC server or client.
 // More config parameters (such as timeouts) will be added soon, see e.g. https://github.com/lucas-clemente/quic-go/issues/441.
 type Config struct {
 	TLSConfig *tls.Config
 	// The QUIC versions that can be negotiated.
+	// The versions are ordered by preference: the client offers the first one, and picks the first version that is also supported by the server when it receives a version negotiation packet.
 	// If not set, it uses all versions available.
-	// Warning: This API should not be considered stable and will change soon.
 	Versions []protocol.VersionNumber
 	// Ask the server to truncate the connection ID sent in the Public Header.
 	// If not set, the default checks if
@@ -76,6 +265,129 @@ type Config struct {
 	// If not set, it verifies that the address matches, and that the STK was issued within the last 24 hours
 	// This option is only valid for the server.
 	AcceptSTK func(clientAddr net.Addr, stk *STK) bool
+	// AcceptClientHello determines if a handshake is accepted, based on the parameters of the client's CHLO.
+	// It is called for every CHLO received, before the server sends its certificate chain, so that the server can refuse a handshake before committing any resources to it.
+	// If it returns false, the handshake fails, and the session is closed.
+	// If not set, all handshakes are accepted.
+	// This option is only valid for the server.
+	AcceptClientHello func(*ClientHelloInfo) bool
+	// MaxIncomingStreams is the maximum number of streams that the peer is allowed to have open at the same time.
+	// Streams opened beyond this limit are refused by sending a RST_STREAM.
+	// If not set, only the limit negotiated during the handshake applies.
+	MaxIncomingStreams int
+	// IdleTimeout is the maximum duration that may pass without any incoming network activity.
+	// This value is negotiated with the peer during the handshake, the smaller of both values is used.
+	// Until the handshake completes, a shorter timeout is used.
+	// If not set, it uses 1 minute for the server, and 2 minutes for the client.
+	IdleTimeout time.Duration
+	// HandshakeTimeout is the maximum duration that the crypto handshake may take.
+	// If the connection is not forward-secure after this time, the session is closed with a HandshakeTimeout error.
+	// If not set, it uses 10 seconds.
+	HandshakeTimeout time.Duration
+	// MaxAckDelay is the maximum duration that an ACK for a packet is delayed, in order to acknowledge multiple packets with a single ACK frame.
+	// ACKs are sent without delay for every second packet, and when packets are received out of order.
+	// If not set, it uses 25 milliseconds.
+	MaxAckDelay time.Duration
+	// KeepAlive defines whether this peer will periodically send PING frames to keep the connection alive.
+	// A PING frame is sent when no packet was received for half the idle timeout.
+	KeepAlive bool
+	// StreamScheduling determines how the data of multiple streams is scheduled, see StreamScheduling.
+	// If not set, the streams take turns (StreamSchedulingRoundRobin).
+	StreamScheduling StreamScheduling
+	// EnablePacing enables pacing of outgoing packets.
+	// Instead of sending the whole congestion window at once, packets are spread evenly over one RTT.
+	EnablePacing bool
+	// BatchWrites sends the packets that are ready to be sent at the same time with a single syscall (sendmmsg).
+	// This is only supported on Linux, and only for connections using a *net.UDPConn.
+	// On other platforms, and if the batched write fails, packets are sent one by one.
+	BatchWrites bool
+	// BatchReads makes the server read multiple packets with a single syscall (recvmmsg).
+	// This is only supported on Linux, and only for servers using a *net.UDPConn.
+	// On other platforms, packets are read one by one.
+	// It only applies to servers.
+	BatchReads bool
+	// MaxPacketSize is the maximum size of the packets sent, including the public header.
+	// It should be set if the path MTU is smaller than the default, otherwise packets might be dropped by the network.
+	// Values smaller than 1200 bytes are increased to 1200 bytes, and values larger than the default are reduced to the default.
+	// If path MTU discovery is enabled, this is the packet size that the discovery starts from.
+	// If not set, it uses 1350 bytes.
+	MaxPacketSize protocol.ByteCount
+	// EnablePathMTUDiscovery enables probing for a packet size larger than MaxPacketSize, up to 1452 bytes.
+	// After the handshake completes, padded probe packets are sent, and the packet size is increased whenever a probe is acknowledged.
+	// Probes are not subject to congestion control, so a lost probe doesn't reduce the congestion window.
+	EnablePathMTUDiscovery bool
+	// InitialCongestionWindow is the initial congestion window, in packets.
+	// Values larger than 200 packets are reduced to 200 packets.
+	// If not set, it uses 32 packets.
+	InitialCongestionWindow protocol.PacketNumber
+	// MinCongestionWindow is the minimum congestion window, in packets.
+	// The congestion window is never reduced below this value, no matter how many packets are lost.
+	// Values larger than the initial congestion window are reduced to the initial congestion window.
+	// If not set, it uses 2 packets.
+	MinCongestionWindow protocol.PacketNumber
+	// MaxReceiveStreamFlowControlWindow is the maximum stream-level flow control window for receiving data.
+	// The window starts small, and is increased whenever the application reads data from a stream fast enough that window updates are sent more often than every two RTTs.
+	// If not set, it uses 1 MB for the server and 6 MB for the client.
+	MaxReceiveStreamFlowControlWindow uint64
+	// MaxReceiveConnectionFlowControlWindow is the maximum connection-level flow control window for receiving data.
+	// The window starts small, and is increased whenever the peer sends data fast enough that window updates are sent more often than every two RTTs.
+	// If not set, it uses 1.5 MB for the server and 15 MB for the client.
+	MaxReceiveConnectionFlowControlWindow uint64
+	// OnPublicReset is called when a Public Reset for the session is received.
+	// The session is closed with a *qerr.QuicError with the error code qerr.PublicReset, so the application can decide to reconnect.
+	// Public Resets are only accepted if they reject a packet number that was actually sent.
+	// This option is only valid for the client.
+	OnPublicReset func(Session)
+	// TokenStore is used to cache the server config and the source-address token received from a server.
+	// When connecting to the same host again, the cached state allows sending application data in the first flight (0-RTT).
+	// To make use of this, the session needs to be dialed using DialNonFWSecure or DialAddrNonFWSecure.
+	// If not set, a full handshake is performed for every connection.
+	// This option is only valid for the client.
+	TokenStore TokenStore
+	// Disable0RTT prevents sending application data in the first flight, even if the TokenStore contains the state for the server.
+	// The cached state is still used to send a full CHLO, saving the round trip for the REJ.
+	// This option is only valid for the client.
+	Disable0RTT bool
+	// Tracer is called for every frame sent and received.
+	// If not set, frames are only logged by the utils logger, if the log level is debug.
+	Tracer Tracer
+	// Logger is used for the log messages of the session, allowing a different log level or writer per connection.
+	// Every line is prefixed with the connection ID.
+	// If not set, the utils.DefaultLogger is used.
+	Logger *utils.Logger
+	// Rand is the source of randomness for the connection ID, and for choosing the packet numbers that are skipped to detect optimistic ACKs.
+	// Using a seeded reader makes these values reproducible, which is useful for debugging tests.
+	// The keys and nonces of the crypto handshake are always generated using crypto/rand.
+	// Public Resets and the probes used for path validation don't use Rand either.
+	// The reads from Rand are serialized for all sessions of a server, or for a dialed session,
+	// so a reader that is not safe for concurrent use, like a *math/rand.Rand, can be used.
+	// If the same Config is used for multiple concurrent calls to Dial, Rand must be safe for concurrent use.
+	// If not set, it uses crypto/rand.Reader.
+	Rand io.Reader
+	// StatelessResetEnabled determines if the server sends a Public Reset when it receives a packet for an unknown connection ID,
+	// e.g. for a session whose state was lost when the server was restarted. If not set, these packets are dropped.
+	// Note that this is a change of the default behavior: previously, the server always sent a Public Reset in this case.
+	// This option is only valid for the server.
+	StatelessResetEnabled bool
+	// ServerConfigOverlap is the time that the previous server config is still accepted after Listener.RotateServerConfig was called.
+	// Clients that cached the previous server config can still complete a 0-RTT handshake during this time.
+	// If not set, it uses 1 hour.
+	// This option is only valid for the server.
+	ServerConfigOverlap time.Duration
+	// ServerSecret is the secret that the initial server config and the key for the source-address tokens are derived from.
+	// It must be at least 32 bytes long, and it should be kept secret and stable across restarts of the server.
+	// After a restart, the server then accepts the source-address tokens it issued before,
+	// and clients that cached its server config can still complete a 0-RTT handshake.
+	// Listener.RotateServerConfig derives the new server config from the secret as well, so servers using the same secret that rotated the same number of times use the same server config.
+	// If not set, the server config is generated randomly, and the key for the source-address tokens is rotated regularly.
+	// This option is only valid for the server.
+	ServerSecret []byte
+	// MaxConnsPerIP is the maximum number of sessions from a single IP address that the server handles at the same time, including sessions that haven't completed the handshake.
+	// The port is not taken into account, so clients behind a NAT share this limit.
+	// Handshakes beyond this limit are refused by sending a Public Reset.
+	// If not set, the number of sessions per IP address is not limited.
+	// This option is only valid for the server.
+	MaxConnsPerIP int
 }
 
 // A Listener for incoming QUIC connections
@@ -86,4 +398,12 @@ type Listener interface {
 	Addr() net.Addr
 	// Accept returns new sessions. It should be called in a loop.
 	Accept() (Session, error)
+	// NumActiveSessions returns the number of sessions that are not closed yet, including sessions that haven't completed the handshake.
+	NumActiveSessions() int
+	// Range calls f for every session that is not closed yet, until f returns false.
+	// It is safe to close sessions from f.
+	Range(f func(Session) bool)
+	// RotateServerConfig replaces the server config, such that new handshakes use a new key exchange value.
+	// The previous server config is still accepted for the Config.ServerConfigOverlap.
+	RotateServerConfig() error
 }
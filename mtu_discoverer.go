@@ -0,0 +1,100 @@
+package quic
+
+import (
+	"time"
+
+	"github.com/lucas-clemente/quic-go/frames"
+	"github.com/lucas-clemente/quic-go/protocol"
+)
+
+// An mtuDiscoverer searches for the largest packet size that the path supports, similar to DPLPMTUD (RFC 8899).
+// It sends probe packets that are padded to the size being probed, one probe at a time.
+// A size is confirmed as soon as its probe is acknowledged.
+// If MaxMTUProbes probes of the same size are lost, the size is considered unsupported, and the search continues with smaller sizes.
+// The search is a binary search between the largest confirmed size and the largest size that might be supported.
+type mtuDiscoverer struct {
+	start, max protocol.ByteCount
+
+	// current is the largest size that was confirmed
+	current protocol.ByteCount
+	// upperBound is the largest size that might be supported by the path
+	upperBound protocol.ByteCount
+	// lostProbes is the number of lost probes of the size currently probed
+	lostProbes int
+
+	// probeNumber, probeSize and probeSentTime describe the outstanding probe, if probeSize is not 0
+	probeNumber   protocol.PacketNumber
+	probeSize     protocol.ByteCount
+	probeSentTime time.Time
+}
+
+func newMTUDiscoverer(start, max protocol.ByteCount) *mtuDiscoverer {
+	d := &mtuDiscoverer{start: start, max: max}
+	d.Reset()
+	return d
+}
+
+// Reset restarts the search from the initial size, e.g. when the path changed
+func (d *mtuDiscoverer) Reset() {
+	d.current = d.start
+	d.upperBound = d.max
+	d.lostProbes = 0
+	d.probeSize = 0
+}
+
+// CurrentSize is the largest packet size that was confirmed to be supported by the path
+func (d *mtuDiscoverer) CurrentSize() protocol.ByteCount {
+	return d.current
+}
+
+// ShouldSendProbe says if a probe should be sent now
+// a new probe is only sent once the previous one was acknowledged or declared lost
+func (d *mtuDiscoverer) ShouldSendProbe() bool {
+	return d.probeSize == 0 && d.upperBound-d.current >= protocol.MTUSearchGranularity
+}
+
+// NextProbeSize returns the size of the next probe
+func (d *mtuDiscoverer) NextProbeSize() protocol.ByteCount {
+	return (d.current + d.upperBound + 1) / 2
+}
+
+// SentProbe is called when a probe was sent
+func (d *mtuDiscoverer) SentProbe(p protocol.PacketNumber, size protocol.ByteCount, now time.Time) {
+	d.probeNumber = p
+	d.probeSize = size
+	d.probeSentTime = now
+}
+
+// ReceivedAck is called for every ACK frame received
+// it returns true if the ACK acknowledges the outstanding probe, i.e. if the CurrentSize increased
+func (d *mtuDiscoverer) ReceivedAck(ack *frames.AckFrame) bool {
+	if d.probeSize == 0 || !ack.AcksPacket(d.probeNumber) {
+		return false
+	}
+	d.current = d.probeSize
+	d.lostProbes = 0
+	d.probeSize = 0
+	return true
+}
+
+// ProbeDeadline returns the time when the outstanding probe will be declared lost
+// it returns the zero time if no probe is outstanding
+func (d *mtuDiscoverer) ProbeDeadline(timeout time.Duration) time.Time {
+	if d.probeSize == 0 {
+		return time.Time{}
+	}
+	return d.probeSentTime.Add(timeout)
+}
+
+// MaybeDeclareLost declares the outstanding probe lost, if it was sent more than timeout ago
+func (d *mtuDiscoverer) MaybeDeclareLost(now time.Time, timeout time.Duration) {
+	if d.probeSize == 0 || now.Before(d.probeSentTime.Add(timeout)) {
+		return
+	}
+	d.lostProbes++
+	if d.lostProbes >= protocol.MaxMTUProbes {
+		d.upperBound = d.probeSize - 1
+		d.lostProbes = 0
+	}
+	d.probeSize = 0
+}
@@ -0,0 +1,116 @@
+package quic
+
+import (
+	"time"
+
+	"github.com/lucas-clemente/quic-go/frames"
+	"github.com/lucas-clemente/quic-go/protocol"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("MTU discoverer", func() {
+	const (
+		start   = protocol.ByteCount(1200)
+		max     = protocol.ByteCount(1400)
+		timeout = 100 * time.Millisecond
+	)
+
+	var d *mtuDiscoverer
+
+	// sendProbe sends the next probe and returns its size
+	sendProbe := func(p protocol.PacketNumber, now time.Time) protocol.ByteCount {
+		ExpectWithOffset(1, d.ShouldSendProbe()).To(BeTrue())
+		size := d.NextProbeSize()
+		d.SentProbe(p, size, now)
+		return size
+	}
+
+	BeforeEach(func() {
+		d = newMTUDiscoverer(start, max)
+	})
+
+	It("starts with the initial size", func() {
+		Expect(d.CurrentSize()).To(Equal(start))
+		Expect(d.ShouldSendProbe()).To(BeTrue())
+		Expect(d.NextProbeSize()).To(Equal(protocol.ByteCount(1300)))
+	})
+
+	It("only has one probe outstanding at a time", func() {
+		sendProbe(10, time.Now())
+		Expect(d.ShouldSendProbe()).To(BeFalse())
+	})
+
+	It("increases the size when a probe is acknowledged", func() {
+		size := sendProbe(10, time.Now())
+		Expect(d.ReceivedAck(&frames.AckFrame{LargestAcked: 9, LowestAcked: 1})).To(BeFalse())
+		Expect(d.CurrentSize()).To(Equal(start))
+		Expect(d.ReceivedAck(&frames.AckFrame{LargestAcked: 10, LowestAcked: 1})).To(BeTrue())
+		Expect(d.CurrentSize()).To(Equal(size))
+		Expect(d.ShouldSendProbe()).To(BeTrue())
+		Expect(d.NextProbeSize()).To(BeNumerically(">", size))
+	})
+
+	It("ignores ACKs when no probe is outstanding", func() {
+		sendProbe(10, time.Now())
+		Expect(d.ReceivedAck(&frames.AckFrame{LargestAcked: 10, LowestAcked: 1})).To(BeTrue())
+		Expect(d.ReceivedAck(&frames.AckFrame{LargestAcked: 11, LowestAcked: 1})).To(BeFalse())
+	})
+
+	It("declares probes lost after the timeout", func() {
+		now := time.Now()
+		size := sendProbe(10, now)
+		Expect(d.ProbeDeadline(timeout)).To(Equal(now.Add(timeout)))
+		d.MaybeDeclareLost(now.Add(timeout-time.Nanosecond), timeout)
+		Expect(d.ShouldSendProbe()).To(BeFalse())
+		d.MaybeDeclareLost(now.Add(timeout), timeout)
+		Expect(d.ShouldSendProbe()).To(BeTrue())
+		Expect(d.ProbeDeadline(timeout)).To(BeZero())
+		// a single lost probe doesn't mean that the size is unsupported
+		Expect(d.NextProbeSize()).To(Equal(size))
+		Expect(d.ReceivedAck(&frames.AckFrame{LargestAcked: 10, LowestAcked: 1})).To(BeFalse())
+	})
+
+	It("backs off when multiple probes of the same size are lost", func() {
+		now := time.Now()
+		var size protocol.ByteCount
+		for i := 0; i < protocol.MaxMTUProbes; i++ {
+			size = sendProbe(protocol.PacketNumber(10+i), now)
+			now = now.Add(timeout)
+			d.MaybeDeclareLost(now, timeout)
+		}
+		Expect(d.CurrentSize()).To(Equal(start))
+		Expect(d.NextProbeSize()).To(BeNumerically("<", size))
+		Expect(d.NextProbeSize()).To(BeNumerically(">", start))
+	})
+
+	It("finds the largest size supported by the path", func() {
+		const pathMTU = protocol.ByteCount(1337)
+		now := time.Now()
+		var p protocol.PacketNumber
+		for d.ShouldSendProbe() {
+			p++
+			size := sendProbe(p, now)
+			now = now.Add(timeout)
+			if size <= pathMTU {
+				Expect(d.ReceivedAck(&frames.AckFrame{LargestAcked: p, LowestAcked: p})).To(BeTrue())
+			} else {
+				d.MaybeDeclareLost(now, timeout)
+			}
+			Expect(p).To(BeNumerically("<", 100))
+		}
+		Expect(d.CurrentSize()).To(BeNumerically("<=", pathMTU))
+		Expect(d.CurrentSize()).To(BeNumerically(">", pathMTU-protocol.MTUSearchGranularity))
+	})
+
+	It("resets", func() {
+		sendProbe(10, time.Now())
+		Expect(d.ReceivedAck(&frames.AckFrame{LargestAcked: 10, LowestAcked: 1})).To(BeTrue())
+		sendProbe(11, time.Now())
+		d.Reset()
+		Expect(d.CurrentSize()).To(Equal(start))
+		Expect(d.ShouldSendProbe()).To(BeTrue())
+		Expect(d.ReceivedAck(&frames.AckFrame{LargestAcked: 11, LowestAcked: 1})).To(BeFalse())
+	})
+})
@@ -1,7 +1,7 @@
 package quic
 
 import (
-	"crypto/rand"
+	"io"
 	"math"
 
 	"github.com/lucas-clemente/quic-go/protocol"
@@ -12,15 +12,17 @@ import (
 // it is guarantued to never skip two consecutive packet numbers
 type packetNumberGenerator struct {
 	averagePeriod protocol.PacketNumber
+	rand          io.Reader
 
 	next       protocol.PacketNumber
 	nextToSkip protocol.PacketNumber
 }
 
-func newPacketNumberGenerator(averagePeriod protocol.PacketNumber) *packetNumberGenerator {
+func newPacketNumberGenerator(averagePeriod protocol.PacketNumber, rand io.Reader) *packetNumberGenerator {
 	return &packetNumberGenerator{
 		next:          1,
 		averagePeriod: averagePeriod,
+		rand:          rand,
 	}
 }
 
@@ -55,11 +57,11 @@ func (p *packetNumberGenerator) generateNewSkip() error {
 	return nil
 }
 
-// getRandomNumber() generates a cryptographically secure random number between 0 and MaxUint16 (= 65535)
+// getRandomNumber() generates a random number between 0 and MaxUint16 (= 65535)
 // The expectation value is 65535/2
 func (p *packetNumberGenerator) getRandomNumber() (uint16, error) {
 	b := make([]byte, 2)
-	_, err := rand.Read(b)
+	_, err := io.ReadFull(p.rand, b)
 	if err != nil {
 		return 0, err
 	}
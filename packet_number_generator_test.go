@@ -1,7 +1,9 @@
 package quic
 
 import (
+	"crypto/rand"
 	"math"
+	mrand "math/rand"
 
 	"github.com/lucas-clemente/quic-go/protocol"
 	. "github.com/onsi/ginkgo"
@@ -12,7 +14,7 @@ var _ = Describe("Packet Number Generator", func() {
 	var png packetNumberGenerator
 
 	BeforeEach(func() {
-		png = *newPacketNumberGenerator(100)
+		png = *newPacketNumberGenerator(100, rand.Reader)
 	})
 
 	It("gets 1 as the first packet number", func() {
@@ -79,4 +81,14 @@ var _ = Describe("Packet Number Generator", func() {
 		Expect(largest).To(BeNumerically(">", math.MaxUint16-300))
 		Expect(sum / uint64(rep)).To(BeNumerically("==", uint64(math.MaxUint16/2), 1000))
 	})
+
+	It("skips the same packet numbers when using the same random source", func() {
+		png1 := newPacketNumberGenerator(100, mrand.New(mrand.NewSource(42)))
+		png2 := newPacketNumberGenerator(100, mrand.New(mrand.NewSource(42)))
+		png1.generateNewSkip()
+		png2.generateNewSkip()
+		for i := 0; i < 1000; i++ {
+			Expect(png1.Pop()).To(Equal(png2.Pop()))
+		}
+	})
 })
@@ -4,12 +4,14 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
 
 	"github.com/lucas-clemente/quic-go/ackhandler"
 	"github.com/lucas-clemente/quic-go/frames"
 	"github.com/lucas-clemente/quic-go/handshake"
 	"github.com/lucas-clemente/quic-go/protocol"
 	"github.com/lucas-clemente/quic-go/qerr"
+	"github.com/lucas-clemente/quic-go/utils"
 )
 
 type packedPacket struct {
@@ -17,6 +19,7 @@ type packedPacket struct {
 	raw             []byte
 	frames          []frames.Frame
 	encryptionLevel protocol.EncryptionLevel
+	isMTUProbe      bool
 }
 
 type packetPacker struct {
@@ -26,6 +29,8 @@ type packetPacker struct {
 	cryptoSetup  handshake.CryptoSetup
 	// as long as packets are not sent with forward-secure encryption, we limit the MaxPacketSize such that they can be retransmitted as a whole
 	isForwardSecure bool
+	// maxPacketSize is the maximum size of a packet, including the public header and the crypto signature
+	maxPacketSize protocol.ByteCount
 
 	packetNumberGenerator *packetNumberGenerator
 
@@ -33,17 +38,27 @@ type packetPacker struct {
 
 	streamFramer  *streamFramer
 	controlFrames []frames.Frame
+	// onlyFrames is set while packing a packet that must not contain any other frames (except for a StopWaitingFrame)
+	onlyFrames []frames.Frame
+	// paddedSize is set while packing an MTU probe
+	paddedSize protocol.ByteCount
+
+	tracer Tracer
+	logger *utils.Logger
 }
 
-func newPacketPacker(connectionID protocol.ConnectionID, cryptoSetup handshake.CryptoSetup, connectionParameters handshake.ConnectionParametersManager, streamFramer *streamFramer, perspective protocol.Perspective, version protocol.VersionNumber) *packetPacker {
+func newPacketPacker(connectionID protocol.ConnectionID, cryptoSetup handshake.CryptoSetup, connectionParameters handshake.ConnectionParametersManager, streamFramer *streamFramer, perspective protocol.Perspective, version protocol.VersionNumber, maxPacketSize protocol.ByteCount, tracer Tracer, rand io.Reader, logger *utils.Logger) *packetPacker {
 	return &packetPacker{
+		tracer:                tracer,
+		logger:                logger,
+		maxPacketSize:         maxPacketSize,
 		cryptoSetup:           cryptoSetup,
 		connectionID:          connectionID,
 		connectionParameters:  connectionParameters,
 		perspective:           perspective,
 		version:               version,
 		streamFramer:          streamFramer,
-		packetNumberGenerator: newPacketNumberGenerator(protocol.SkipPacketAveragePeriodLength),
+		packetNumberGenerator: newPacketNumberGenerator(protocol.SkipPacketAveragePeriodLength, rand),
 	}
 }
 
@@ -55,6 +70,44 @@ func (p *packetPacker) PackConnectionClose(ccf *frames.ConnectionCloseFrame, lea
 	return p.packPacket(nil, leastUnacked, nil)
 }
 
+// PackPing packs a packet that ONLY contains a PingFrame
+// queued control frames are not affected, and will be sent in one of the next packets
+func (p *packetPacker) PackPing(leastUnacked protocol.PacketNumber) (*packedPacket, error) {
+	p.onlyFrames = []frames.Frame{&frames.PingFrame{}}
+	defer func() { p.onlyFrames = nil }()
+	return p.packPacket(nil, leastUnacked, nil)
+}
+
+// PackAckPacket packs a packet that ONLY contains an AckFrame, and the StopWaitingFrame, if any
+// queued control frames are not affected, and will be sent in one of the next packets
+func (p *packetPacker) PackAckPacket(stopWaitingFrame *frames.StopWaitingFrame, ack *frames.AckFrame, leastUnacked protocol.PacketNumber) (*packedPacket, error) {
+	p.onlyFrames = []frames.Frame{ack}
+	defer func() { p.onlyFrames = nil }()
+	return p.packPacket(stopWaitingFrame, leastUnacked, nil)
+}
+
+// PackMTUProbe packs a packet that ONLY contains a PingFrame, padded to the given size
+// queued control frames are not affected, and will be sent in one of the next packets
+func (p *packetPacker) PackMTUProbe(size protocol.ByteCount, leastUnacked protocol.PacketNumber) (*packedPacket, error) {
+	p.onlyFrames = []frames.Frame{&frames.PingFrame{}}
+	p.paddedSize = size
+	defer func() {
+		p.onlyFrames = nil
+		p.paddedSize = 0
+	}()
+	packet, err := p.packPacket(nil, leastUnacked, nil)
+	if err != nil {
+		return nil, err
+	}
+	packet.isMTUProbe = true
+	return packet, nil
+}
+
+// SetMaxPacketSize sets the maximum size of the packets packed, e.g. when the path MTU discovery found a larger packet size
+func (p *packetPacker) SetMaxPacketSize(size protocol.ByteCount) {
+	p.maxPacketSize = size
+}
+
 //  RetransmitNonForwardSecurePacket retransmits a handshake packet, that was sent with less than forward-secure encryption
 func (p *packetPacker) RetransmitNonForwardSecurePacket(stopWaitingFrame *frames.StopWaitingFrame, packet *ackhandler.Packet) (*packedPacket, error) {
 	if packet.EncryptionLevel == protocol.EncryptionForwardSecure {
@@ -71,7 +124,9 @@ func (p *packetPacker) RetransmitNonForwardSecurePacket(stopWaitingFrame *frames
 // the stopWaitingFrame is *guaranteed* to be included in the next packet
 // the other controlFrames are sent in the next packet, but might be queued and sent in the next packet if the packet would overflow MaxPacketSize otherwise
 func (p *packetPacker) PackPacket(stopWaitingFrame *frames.StopWaitingFrame, controlFrames []frames.Frame, leastUnacked protocol.PacketNumber) (*packedPacket, error) {
-	p.controlFrames = append(p.controlFrames, controlFrames...)
+	for _, f := range controlFrames {
+		p.queueControlFrame(f)
+	}
 	return p.packPacket(stopWaitingFrame, leastUnacked, nil)
 }
 
@@ -81,6 +136,7 @@ func (p *packetPacker) packPacket(stopWaitingFrame *frames.StopWaitingFrame, lea
 
 	var sealFunc handshake.Sealer
 	var encLevel protocol.EncryptionLevel
+	var isCryptoPacket bool
 
 	if isHandshakeRetransmission {
 		var err error
@@ -91,6 +147,18 @@ func (p *packetPacker) packPacket(stopWaitingFrame *frames.StopWaitingFrame, lea
 		}
 	} else {
 		encLevel, sealFunc = p.cryptoSetup.GetSealer()
+		// when doing a 0-RTT handshake, the client already has initial encryption keys when sending the CHLO
+		// the server can only read the CHLO if it is sent unencrypted
+		if p.perspective == protocol.PerspectiveClient && encLevel == protocol.EncryptionSecure && p.streamFramer.HasCryptoStreamFrame() {
+			var err error
+			isCryptoPacket = true
+			encLevel = protocol.EncryptionUnencrypted
+			p.logger.Debugf("Sending the crypto stream data unencrypted, since the server can't decrypt 0-RTT packets yet")
+			sealFunc, err = p.cryptoSetup.GetSealerWithEncryptionLevel(encLevel)
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	currentPacketNumber := p.packetNumberGenerator.Peek()
@@ -142,12 +210,21 @@ func (p *packetPacker) packPacket(stopWaitingFrame *frames.StopWaitingFrame, lea
 		}
 	} else if isConnectionClose {
 		payloadFrames = []frames.Frame{p.controlFrames[0]}
+	} else if p.onlyFrames != nil {
+		if stopWaitingFrame != nil {
+			payloadFrames = append(payloadFrames, stopWaitingFrame)
+		}
+		payloadFrames = append(payloadFrames, p.onlyFrames...)
 	} else {
-		maxSize := protocol.MaxFrameAndPublicHeaderSize - publicHeaderLength
+		maxSize := p.maxPacketSize - 12 /*crypto signature*/ - publicHeaderLength
 		if !p.isForwardSecure {
 			maxSize -= protocol.NonForwardSecurePacketSizeReduction
 		}
-		payloadFrames, err = p.composeNextPacket(stopWaitingFrame, maxSize)
+		if isCryptoPacket {
+			payloadFrames, err = p.composeCryptoPacket(stopWaitingFrame, maxSize)
+		} else {
+			payloadFrames, err = p.composeNextPacket(stopWaitingFrame, maxSize)
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -172,9 +249,16 @@ func (p *packetPacker) packPacket(stopWaitingFrame *frames.StopWaitingFrame, lea
 	payloadStartIndex := buffer.Len()
 
 	var hasNonCryptoStreamData bool // does this frame contain any stream frame on a stream > 1
+	var hasCryptoStreamData bool    // does this frame contain a stream frame on the crypto stream
+	var lastFrameStartIndex int
 	for _, frame := range payloadFrames {
-		if sf, ok := frame.(*frames.StreamFrame); ok && sf.StreamID != 1 {
-			hasNonCryptoStreamData = true
+		lastFrameStartIndex = buffer.Len()
+		if sf, ok := frame.(*frames.StreamFrame); ok {
+			if sf.StreamID == 1 {
+				hasCryptoStreamData = true
+			} else {
+				hasNonCryptoStreamData = true
+			}
 		}
 		err = frame.Write(buffer, p.version)
 		if err != nil {
@@ -182,7 +266,30 @@ func (p *packetPacker) packPacket(stopWaitingFrame *frames.StopWaitingFrame, lea
 		}
 	}
 
-	if protocol.ByteCount(buffer.Len()+12) > protocol.MaxPacketSize {
+	maxPacketSize := p.maxPacketSize
+	paddedSize := p.paddedSize
+	if paddedSize > 0 {
+		maxPacketSize = paddedSize
+	} else if p.perspective == protocol.PerspectiveClient && encLevel == protocol.EncryptionUnencrypted && hasCryptoStreamData {
+		// pad the packets carrying a CHLO, so that the server can't be used for amplification attacks
+		paddedSize = utils.MinByteCount(protocol.MinInitialPacketSize, p.maxPacketSize)
+	}
+	if protocol.ByteCount(buffer.Len()+12) < paddedSize {
+		// A StreamFrame without the DataLen extends to the end of the packet, so the padding would be read as stream data.
+		// Write the DataLen of the last frame before calculating the padding, so that the packet is never smaller than the padded size.
+		if sf, ok := payloadFrames[len(payloadFrames)-1].(*frames.StreamFrame); ok && !sf.DataLenPresent {
+			buffer.Truncate(lastFrameStartIndex)
+			sf.DataLenPresent = true
+			if err := sf.Write(buffer, p.version); err != nil {
+				return nil, err
+			}
+		}
+	}
+	// PADDING frames are just zero bytes, and fill the rest of the packet
+	if padding := int(paddedSize) - 12 - buffer.Len(); padding > 0 {
+		buffer.Write(make([]byte, padding))
+	}
+	if protocol.ByteCount(buffer.Len()+12) > maxPacketSize {
 		return nil, errors.New("PacketPacker BUG: packet too large")
 	}
 
@@ -199,6 +306,12 @@ func (p *packetPacker) packPacket(stopWaitingFrame *frames.StopWaitingFrame, lea
 		return nil, errors.New("PacketPacker BUG: Peeked and Popped packet numbers do not match.")
 	}
 
+	if p.tracer != nil {
+		for _, f := range payloadFrames {
+			p.tracer.SentFrame(newFrameInfo(f, currentPacketNumber))
+		}
+	}
+
 	return &packedPacket{
 		number:          currentPacketNumber,
 		raw:             raw,
@@ -257,7 +370,43 @@ func (p *packetPacker) composeNextPacket(stopWaitingFrame *frames.StopWaitingFra
 	return payloadFrames, nil
 }
 
+// composeCryptoPacket composes a packet that only contains data sent on the crypto stream
+func (p *packetPacker) composeCryptoPacket(stopWaitingFrame *frames.StopWaitingFrame, maxFrameSize protocol.ByteCount) ([]frames.Frame, error) {
+	var payloadFrames []frames.Frame
+
+	if stopWaitingFrame != nil {
+		payloadFrames = append(payloadFrames, stopWaitingFrame)
+		minLength, err := stopWaitingFrame.MinLength(p.version)
+		if err != nil {
+			return nil, err
+		}
+		maxFrameSize -= minLength
+	}
+
+	if f := p.streamFramer.PopCryptoStreamFrame(maxFrameSize); f != nil {
+		payloadFrames = append(payloadFrames, f)
+	}
+	return payloadFrames, nil
+}
+
 func (p *packetPacker) QueueControlFrameForNextPacket(f frames.Frame) {
+	p.queueControlFrame(f)
+}
+
+// queueControlFrame adds a control frame to the queue
+// a WindowUpdateFrame replaces all queued WindowUpdateFrames for the same stream, so that only the latest offset is sent
+func (p *packetPacker) queueControlFrame(f frames.Frame) {
+	if wuf, ok := f.(*frames.WindowUpdateFrame); ok {
+		for i, queued := range p.controlFrames {
+			if queuedWuf, ok := queued.(*frames.WindowUpdateFrame); ok && queuedWuf.StreamID == wuf.StreamID {
+				if queuedWuf.ByteOffset >= wuf.ByteOffset {
+					return
+				}
+				p.controlFrames[i] = wuf
+				return
+			}
+		}
+	}
 	p.controlFrames = append(p.controlFrames, f)
 }
 
@@ -2,12 +2,14 @@ package quic
 
 import (
 	"bytes"
+	"crypto/rand"
 
 	"github.com/lucas-clemente/quic-go/ackhandler"
 	"github.com/lucas-clemente/quic-go/frames"
 	"github.com/lucas-clemente/quic-go/handshake"
 	"github.com/lucas-clemente/quic-go/protocol"
 	"github.com/lucas-clemente/quic-go/qerr"
+	"github.com/lucas-clemente/quic-go/utils"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -16,6 +18,7 @@ type mockCryptoSetup struct {
 	handleErr    error
 	divNonce     []byte
 	encLevelSeal protocol.EncryptionLevel
+	connState    handshake.ConnectionState
 }
 
 func (m *mockCryptoSetup) HandleCryptoStream() error {
@@ -36,6 +39,9 @@ func (m *mockCryptoSetup) GetSealerWithEncryptionLevel(protocol.EncryptionLevel)
 }
 func (m *mockCryptoSetup) DiversificationNonce() []byte            { return m.divNonce }
 func (m *mockCryptoSetup) SetDiversificationNonce(divNonce []byte) { m.divNonce = divNonce }
+func (m *mockCryptoSetup) ConnectionState() handshake.ConnectionState {
+	return m.connState
+}
 
 var _ handshake.CryptoSetup = &mockCryptoSetup{}
 
@@ -54,15 +60,17 @@ var _ = Describe("Packet packer", func() {
 		fcm.sendWindowSizes[7] = protocol.MaxByteCount
 
 		cpm := &mockConnectionParametersManager{}
-		streamFramer = newStreamFramer(newStreamsMap(nil, protocol.PerspectiveServer, cpm), fcm)
+		streamFramer = newStreamFramer(newStreamsMap(nil, nil, protocol.PerspectiveServer, cpm, 0), fcm, StreamSchedulingRoundRobin)
 
 		packer = &packetPacker{
 			cryptoSetup:           &mockCryptoSetup{encLevelSeal: protocol.EncryptionForwardSecure},
 			connectionParameters:  cpm,
 			connectionID:          0x1337,
-			packetNumberGenerator: newPacketNumberGenerator(protocol.SkipPacketAveragePeriodLength),
+			packetNumberGenerator: newPacketNumberGenerator(protocol.SkipPacketAveragePeriodLength, rand.Reader),
 			streamFramer:          streamFramer,
 			perspective:           protocol.PerspectiveServer,
+			maxPacketSize:         protocol.MaxPacketSize,
+			logger:                utils.DefaultLogger,
 		}
 		publicHeaderLen = 1 + 8 + 2 // 1 flag byte, 8 connection ID, 2 packet number
 		maxFrameSize = protocol.MaxFrameAndPublicHeaderSize - publicHeaderLen
@@ -91,6 +99,23 @@ var _ = Describe("Packet packer", func() {
 		Expect(p.raw).To(ContainSubstring(string(b.Bytes())))
 	})
 
+	It("informs the tracer about sent frames", func() {
+		tracer := &recordingTracer{}
+		packer.tracer = tracer
+		packer.packetNumberGenerator.next = 15
+		streamFramer.AddFrameForRetransmission(&frames.StreamFrame{
+			StreamID: 5,
+			Data:     []byte("foobar"),
+		})
+		p, err := packer.PackPacket(nil, []frames.Frame{&frames.AckFrame{LargestAcked: 10, LowestAcked: 1}}, 0)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(p.number).To(Equal(protocol.PacketNumber(15)))
+		Expect(tracer.sent).To(Equal([]FrameInfo{
+			{Type: "ACK", PacketNumber: 15},
+			{Type: "STREAM", StreamID: 5, PacketNumber: 15},
+		}))
+	})
+
 	It("stores the encryption level a packet was sealed with", func() {
 		packer.cryptoSetup.(*mockCryptoSetup).encLevelSeal = protocol.EncryptionSecure
 		f := &frames.StreamFrame{
@@ -173,6 +198,62 @@ var _ = Describe("Packet packer", func() {
 		Expect(p.frames[0]).To(Equal(&ccf))
 	})
 
+	It("packs a PING", func() {
+		p, err := packer.PackPing(0)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(p.frames).To(Equal([]frames.Frame{&frames.PingFrame{}}))
+	})
+
+	It("doesn't send any other frames when sending a PING, and keeps the queued control frames", func() {
+		wuf := &frames.WindowUpdateFrame{StreamID: 37}
+		packer.controlFrames = []frames.Frame{wuf}
+		streamFramer.AddFrameForRetransmission(&frames.StreamFrame{
+			StreamID: 5,
+			Data:     []byte("foobar"),
+		})
+		p, err := packer.PackPing(0)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(p.frames).To(Equal([]frames.Frame{&frames.PingFrame{}}))
+		p, err = packer.PackPacket(nil, nil, 0)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(p.frames).To(ContainElement(wuf))
+		Expect(p.frames).To(HaveLen(2))
+	})
+
+	It("packs MTU probes, and keeps the queued control frames", func() {
+		wuf := &frames.WindowUpdateFrame{StreamID: 37}
+		packer.controlFrames = []frames.Frame{wuf}
+		p, err := packer.PackMTUProbe(1400, 0)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(p.isMTUProbe).To(BeTrue())
+		Expect(p.frames).To(Equal([]frames.Frame{&frames.PingFrame{}}))
+		Expect(p.raw).To(HaveLen(1400))
+		p, err = packer.PackPacket(nil, nil, 0)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(p.isMTUProbe).To(BeFalse())
+		Expect(p.frames).To(Equal([]frames.Frame{wuf}))
+	})
+
+	It("packs an ACK-only packet, and keeps the queued control frames", func() {
+		packer.packetNumberGenerator.next = 15
+		wuf := &frames.WindowUpdateFrame{StreamID: 37}
+		packer.controlFrames = []frames.Frame{wuf}
+		streamFramer.AddFrameForRetransmission(&frames.StreamFrame{
+			StreamID: 5,
+			Data:     []byte("foobar"),
+		})
+		swf := &frames.StopWaitingFrame{LeastUnacked: 10}
+		ack := &frames.AckFrame{LargestAcked: 42, LowestAcked: 1}
+		p, err := packer.PackAckPacket(swf, ack, 0)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(p.frames).To(Equal([]frames.Frame{swf, ack}))
+		Expect(swf.PacketNumber).To(Equal(protocol.PacketNumber(15)))
+		p, err = packer.PackPacket(nil, nil, 0)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(p.frames).To(ContainElement(wuf))
+		Expect(p.frames).To(HaveLen(2))
+	})
+
 	It("packs only control frames", func() {
 		p, err := packer.PackPacket(nil, []frames.Frame{&frames.RstStreamFrame{}, &frames.WindowUpdateFrame{}}, 0)
 		Expect(p).ToNot(BeNil())
@@ -364,6 +445,41 @@ var _ = Describe("Packet packer", func() {
 			Expect(p.raw).To(HaveLen(int(protocol.MaxPacketSize - protocol.NonForwardSecurePacketSizeReduction)))
 		})
 
+		It("respects the configured maximum packet size", func() {
+			packer.maxPacketSize = protocol.MinMaxPacketSize
+			streamFramer.AddFrameForRetransmission(&frames.StreamFrame{
+				StreamID: 3,
+				Data:     bytes.Repeat([]byte{'f'}, 5000),
+			})
+			packer.controlFrames = []frames.Frame{&frames.RstStreamFrame{StreamID: 5}, &frames.WindowUpdateFrame{StreamID: 7}}
+			var dataLen protocol.ByteCount
+			for {
+				p, err := packer.PackPacket(nil, nil, 0)
+				Expect(err).ToNot(HaveOccurred())
+				if p == nil {
+					break
+				}
+				Expect(len(p.raw)).To(BeNumerically("<=", protocol.MinMaxPacketSize))
+				for _, f := range p.frames {
+					if sf, ok := f.(*frames.StreamFrame); ok {
+						dataLen += sf.DataLen()
+					}
+				}
+			}
+			Expect(dataLen).To(Equal(protocol.ByteCount(5000)))
+		})
+
+		It("packs larger packets when the maximum packet size is increased", func() {
+			packer.SetMaxPacketSize(protocol.MaxReceivePacketSize)
+			streamFramer.AddFrameForRetransmission(&frames.StreamFrame{
+				StreamID: 3,
+				Data:     bytes.Repeat([]byte{'f'}, 5000),
+			})
+			p, err := packer.PackPacket(nil, nil, 0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p.raw).To(HaveLen(int(protocol.MaxReceivePacketSize)))
+		})
+
 		It("packs multiple small stream frames into single packet", func() {
 			f1 := &frames.StreamFrame{
 				StreamID: 5,
@@ -537,6 +653,177 @@ var _ = Describe("Packet packer", func() {
 		})
 	})
 
+	Context("0-RTT", func() {
+		var cryptoStream *stream
+
+		BeforeEach(func() {
+			packer.perspective = protocol.PerspectiveClient
+			packer.isForwardSecure = false
+			packer.cryptoSetup.(*mockCryptoSetup).encLevelSeal = protocol.EncryptionSecure
+			cryptoStream = &stream{streamID: 1}
+			streamFramer.streamsMap.putStream(cryptoStream)
+			streamFramer.flowControlManager.(*mockFlowControlHandler).sendWindowSizes[1] = protocol.MaxByteCount
+		})
+
+		It("sends the CHLO unencrypted, even if initial encryption keys are available", func() {
+			cryptoStream.dataForWriting = []byte("CHLO")
+			f := &frames.StreamFrame{
+				StreamID: 5,
+				Data:     []byte("foobar"),
+			}
+			streamFramer.AddFrameForRetransmission(f)
+			p, err := packer.PackPacket(nil, nil, 0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p.encryptionLevel).To(Equal(protocol.EncryptionUnencrypted))
+			Expect(p.frames).To(HaveLen(1))
+			Expect(p.frames[0].(*frames.StreamFrame).StreamID).To(Equal(protocol.StreamID(1)))
+			Expect(p.frames[0].(*frames.StreamFrame).Data).To(Equal([]byte("CHLO")))
+			// the stream data is sent in the next packet, with initial encryption
+			p, err = packer.PackPacket(nil, nil, 0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p.encryptionLevel).To(Equal(protocol.EncryptionSecure))
+			Expect(p.frames).To(Equal([]frames.Frame{f}))
+		})
+
+		It("includes the StopWaitingFrame in the CHLO packet", func() {
+			cryptoStream.dataForWriting = []byte("CHLO")
+			packer.packetNumberGenerator.next = 15
+			swf := &frames.StopWaitingFrame{LeastUnacked: 10}
+			p, err := packer.PackPacket(swf, nil, 0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p.encryptionLevel).To(Equal(protocol.EncryptionUnencrypted))
+			Expect(p.frames).To(HaveLen(2))
+			Expect(p.frames[0]).To(Equal(swf))
+		})
+
+		It("doesn't pack control frames into the CHLO packet", func() {
+			cryptoStream.dataForWriting = []byte("CHLO")
+			ping := &frames.PingFrame{}
+			p, err := packer.PackPacket(nil, []frames.Frame{ping}, 0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p.encryptionLevel).To(Equal(protocol.EncryptionUnencrypted))
+			Expect(p.frames).To(HaveLen(1))
+			p, err = packer.PackPacket(nil, nil, 0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p.encryptionLevel).To(Equal(protocol.EncryptionSecure))
+			Expect(p.frames).To(Equal([]frames.Frame{ping}))
+		})
+
+		It("uses the initial encryption if no data is queued on the crypto stream", func() {
+			f := &frames.StreamFrame{
+				StreamID: 5,
+				Data:     []byte("foobar"),
+			}
+			streamFramer.AddFrameForRetransmission(f)
+			p, err := packer.PackPacket(nil, nil, 0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p.encryptionLevel).To(Equal(protocol.EncryptionSecure))
+		})
+	})
+
+	Context("padding the CHLO", func() {
+		var cryptoFrame *frames.StreamFrame
+
+		BeforeEach(func() {
+			packer.perspective = protocol.PerspectiveClient
+			packer.isForwardSecure = false
+			packer.cryptoSetup.(*mockCryptoSetup).encLevelSeal = protocol.EncryptionUnencrypted
+			cryptoFrame = &frames.StreamFrame{
+				StreamID: 1,
+				Data:     []byte("CHLO"),
+			}
+		})
+
+		// unpack parses a packet sent by the client, and returns the frames it contains
+		unpack := func(raw []byte) []frames.Frame {
+			r := bytes.NewReader(raw)
+			hdr, err := ParsePublicHeader(r, protocol.PerspectiveClient)
+			Expect(err).ToNot(HaveOccurred())
+			hdrBin := raw[:len(raw)-r.Len()]
+			// the mockCryptoSetup appends 12 zero bytes instead of sealing the packet
+			payload := raw[len(hdrBin) : len(raw)-12]
+			data, _ := (&mockAEAD{}).Seal(nil, payload, hdr.PacketNumber, hdrBin)
+			unpacker := &packetUnpacker{aead: &mockAEAD{}, version: packer.version, logger: utils.DefaultLogger}
+			packet, err := unpacker.Unpack(hdrBin, hdr, data)
+			Expect(err).ToNot(HaveOccurred())
+			return packet.frames
+		}
+
+		It("pads the packet containing the CHLO, without changing the CHLO", func() {
+			streamFramer.AddFrameForRetransmission(cryptoFrame)
+			p, err := packer.PackPacket(nil, nil, 0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p.encryptionLevel).To(Equal(protocol.EncryptionUnencrypted))
+			Expect(p.raw).To(HaveLen(int(protocol.MinInitialPacketSize)))
+			fs := unpack(p.raw)
+			Expect(fs).To(HaveLen(1))
+			Expect(fs[0]).To(BeAssignableToTypeOf(&frames.StreamFrame{}))
+			Expect(fs[0].(*frames.StreamFrame).StreamID).To(Equal(protocol.StreamID(1)))
+			Expect(fs[0].(*frames.StreamFrame).Data).To(Equal([]byte("CHLO")))
+		})
+
+		It("pads retransmissions of the CHLO, without changing the CHLO", func() {
+			packet := &ackhandler.Packet{
+				EncryptionLevel: protocol.EncryptionUnencrypted,
+				Frames:          []frames.Frame{cryptoFrame},
+			}
+			p, err := packer.RetransmitNonForwardSecurePacket(&frames.StopWaitingFrame{LeastUnacked: 1}, packet)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p.raw).To(HaveLen(int(protocol.MinInitialPacketSize)))
+			fs := unpack(p.raw)
+			Expect(fs).To(HaveLen(2))
+			Expect(fs[0]).To(BeAssignableToTypeOf(&frames.StopWaitingFrame{}))
+			Expect(fs[1]).To(BeAssignableToTypeOf(&frames.StreamFrame{}))
+			Expect(fs[1].(*frames.StreamFrame).Data).To(Equal([]byte("CHLO")))
+		})
+
+		It("writes the DataLen of the last StreamFrame, if there's only room for 1 byte of padding", func() {
+			pn := packer.packetNumberGenerator.Peek()
+			pnLen := protocol.GetPacketNumberLengthForPublicHeader(pn, 0)
+			hdr := &PublicHeader{
+				ConnectionID:    packer.connectionID,
+				PacketNumber:    pn,
+				PacketNumberLen: pnLen,
+				VersionFlag:     true,
+				VersionNumber:   packer.version,
+			}
+			hdrLen, err := hdr.GetLength(protocol.PerspectiveClient)
+			Expect(err).ToNot(HaveOccurred())
+			swf := &frames.StopWaitingFrame{LeastUnacked: 1, PacketNumber: pn, PacketNumberLen: pnLen}
+			swfLen, err := swf.MinLength(packer.version)
+			Expect(err).ToNot(HaveOccurred())
+			frameHeaderLen, err := cryptoFrame.MinLength(packer.version)
+			Expect(err).ToNot(HaveOccurred())
+			// without the DataLen, the packet would be 1 byte smaller than the minimum initial packet size
+			cryptoFrame.Data = bytes.Repeat([]byte{'f'}, int(protocol.MinInitialPacketSize-1-12-hdrLen-swfLen-frameHeaderLen))
+			packet := &ackhandler.Packet{
+				EncryptionLevel: protocol.EncryptionUnencrypted,
+				Frames:          []frames.Frame{cryptoFrame},
+			}
+			p, err := packer.RetransmitNonForwardSecurePacket(swf, packet)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(p.raw)).To(BeNumerically(">=", protocol.MinInitialPacketSize))
+			fs := unpack(p.raw)
+			Expect(fs).To(HaveLen(2))
+			Expect(fs[1].(*frames.StreamFrame).Data).To(Equal(cryptoFrame.Data))
+		})
+
+		It("doesn't pad unencrypted packets that don't contain crypto stream data", func() {
+			ack := &frames.AckFrame{LargestAcked: 10, LowestAcked: 1}
+			p, err := packer.PackAckPacket(nil, ack, 0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(p.raw)).To(BeNumerically("<", protocol.MinInitialPacketSize))
+		})
+
+		It("doesn't pad packets of the server", func() {
+			packer.perspective = protocol.PerspectiveServer
+			streamFramer.AddFrameForRetransmission(cryptoFrame)
+			p, err := packer.PackPacket(nil, nil, 0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(p.raw)).To(BeNumerically("<", protocol.MinInitialPacketSize))
+		})
+	})
+
 	Context("Blocked frames", func() {
 		It("queues a BLOCKED frame", func() {
 			length := 100
@@ -607,6 +894,25 @@ var _ = Describe("Packet packer", func() {
 		Expect(p.frames[0]).To(Equal(wuf))
 	})
 
+	It("only sends the latest WindowUpdate for a stream", func() {
+		packer.QueueControlFrameForNextPacket(&frames.WindowUpdateFrame{StreamID: 5, ByteOffset: 100})
+		packer.QueueControlFrameForNextPacket(&frames.WindowUpdateFrame{StreamID: 7, ByteOffset: 100})
+		wuf := &frames.WindowUpdateFrame{StreamID: 5, ByteOffset: 200}
+		p, err := packer.PackPacket(nil, []frames.Frame{wuf}, 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(p.frames).To(HaveLen(2))
+		Expect(p.frames).To(ContainElement(wuf))
+		Expect(p.frames).To(ContainElement(&frames.WindowUpdateFrame{StreamID: 7, ByteOffset: 100}))
+	})
+
+	It("doesn't replace a WindowUpdate with one for a smaller offset", func() {
+		wuf := &frames.WindowUpdateFrame{StreamID: 5, ByteOffset: 200}
+		packer.QueueControlFrameForNextPacket(wuf)
+		p, err := packer.PackPacket(nil, []frames.Frame{&frames.WindowUpdateFrame{StreamID: 5, ByteOffset: 100}}, 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(p.frames).To(Equal([]frames.Frame{wuf}))
+	})
+
 	Context("retransmitting of handshake packets", func() {
 		swf := &frames.StopWaitingFrame{LeastUnacked: 1}
 		sf := &frames.StreamFrame{
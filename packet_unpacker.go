@@ -8,6 +8,7 @@ import (
 	"github.com/lucas-clemente/quic-go/frames"
 	"github.com/lucas-clemente/quic-go/protocol"
 	"github.com/lucas-clemente/quic-go/qerr"
+	"github.com/lucas-clemente/quic-go/utils"
 )
 
 type quicAEAD interface {
@@ -17,13 +18,19 @@ type quicAEAD interface {
 type packetUnpacker struct {
 	version protocol.VersionNumber
 	aead    quicAEAD
+	tracer  Tracer
+	logger  *utils.Logger
 }
 
+// Unpack decrypts the packet and parses its frames.
+// The Public Header doesn't have a length field, so the payload extends to the end of the datagram,
+// and a datagram always contains a single packet.
 func (u *packetUnpacker) Unpack(publicHeaderBinary []byte, hdr *PublicHeader, data []byte) (*unpackedPacket, error) {
 	buf := getPacketBuffer()
 	defer putPacketBuffer(buf)
 	decrypted, encryptionLevel, err := u.aead.Open(buf, data, hdr.PacketNumber, publicHeaderBinary)
 	if err != nil {
+		u.logger.Debugf("Failed to decrypt packet 0x%x: %s", hdr.PacketNumber, err.Error())
 		// Wrap err in quicError so that public reset is sent by session
 		return nil, qerr.Error(qerr.DecryptionFailure, err.Error())
 	}
@@ -107,6 +114,12 @@ func (u *packetUnpacker) Unpack(publicHeaderBinary []byte, hdr *PublicHeader, da
 		}
 	}
 
+	if u.tracer != nil {
+		for _, f := range fs {
+			u.tracer.ReceivedFrame(newFrameInfo(f, hdr.PacketNumber))
+		}
+	}
+
 	return &unpackedPacket{
 		encryptionLevel: encryptionLevel,
 		frames:          fs,
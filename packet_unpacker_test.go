@@ -7,6 +7,7 @@ import (
 	"github.com/lucas-clemente/quic-go/frames"
 	"github.com/lucas-clemente/quic-go/protocol"
 	"github.com/lucas-clemente/quic-go/qerr"
+	"github.com/lucas-clemente/quic-go/utils"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -43,7 +44,7 @@ var _ = Describe("Packet unpacker", func() {
 			PacketNumberLen: 1,
 		}
 		hdrBin = []byte{0x04, 0x4c, 0x01}
-		unpacker = &packetUnpacker{aead: &mockAEAD{}}
+		unpacker = &packetUnpacker{aead: &mockAEAD{}, logger: utils.DefaultLogger}
 		data = nil
 		buf = &bytes.Buffer{}
 	})
@@ -117,6 +118,34 @@ var _ = Describe("Packet unpacker", func() {
 		Expect(packet.frames).To(HaveLen(2))
 	})
 
+	It("informs the tracer about received frames", func() {
+		tracer := &recordingTracer{}
+		unpacker.tracer = tracer
+		err := (&frames.RstStreamFrame{StreamID: 5}).Write(buf, protocol.VersionWhatever)
+		Expect(err).ToNot(HaveOccurred())
+		err = (&frames.PingFrame{}).Write(buf, protocol.VersionWhatever)
+		Expect(err).ToNot(HaveOccurred())
+		setData(buf.Bytes())
+		_, err = unpacker.Unpack(hdrBin, hdr, data)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tracer.received).To(Equal([]FrameInfo{
+			{Type: "RST_STREAM", StreamID: 5, PacketNumber: 10},
+			{Type: "PING", PacketNumber: 10},
+		}))
+	})
+
+	It("doesn't inform the tracer if the packet can't be parsed", func() {
+		tracer := &recordingTracer{}
+		unpacker.tracer = tracer
+		err := (&frames.PingFrame{}).Write(buf, protocol.VersionWhatever)
+		Expect(err).ToNot(HaveOccurred())
+		buf.WriteByte(0x20) // CONGESTION_FEEDBACK
+		setData(buf.Bytes())
+		_, err = unpacker.Unpack(hdrBin, hdr, data)
+		Expect(err).To(HaveOccurred())
+		Expect(tracer.received).To(BeEmpty())
+	})
+
 	It("unpacks RST_STREAM frames", func() {
 		setData([]byte{0x01, 0xEF, 0xBE, 0xAD, 0xDE, 0x44, 0x33, 0x22, 0x11, 0xAD, 0xFB, 0xCA, 0xDE, 0x34, 0x12, 0x37, 0x13})
 		packet, err := unpacker.Unpack(hdrBin, hdr, data)
@@ -0,0 +1,68 @@
+package quic
+
+import (
+	"net"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/frames"
+	"github.com/lucas-clemente/quic-go/protocol"
+)
+
+// A pathValidator validates a new address of the client, before the server starts sending to it.
+// gQUIC doesn't have PATH_CHALLENGE and PATH_RESPONSE frames.
+// Instead, the server sends a packet that only contains a PING frame to the new address, and the packet number of this probe serves as the challenge.
+// Since packets are authenticated, only the client can acknowledge the probe, and it can only do so if it actually received it.
+// The address is validated as soon as an ACK for one of the probes is received from the new address.
+type pathValidator struct {
+	addr net.Addr
+
+	probes        []protocol.PacketNumber
+	lastProbeTime time.Time
+
+	// used to limit the number of bytes sent to the address, before it is validated
+	bytesReceived protocol.ByteCount
+	bytesSent     protocol.ByteCount
+}
+
+// maxPathProbeSize is an upper bound for the size of a probe:
+// a Public Header with an 8 byte connection ID, a diversification nonce and a 6 byte packet number, a PING frame and the 12 byte AEAD tag
+const maxPathProbeSize protocol.ByteCount = 1 + 8 + 32 + 6 + 1 + 12
+
+func newPathValidator(addr net.Addr) *pathValidator {
+	return &pathValidator{addr: addr}
+}
+
+// ReceivedPacket is called for every authenticated packet received from the address that is being validated
+// it returns true if the packet acknowledges one of the probes, i.e. if the address is validated
+func (v *pathValidator) ReceivedPacket(size protocol.ByteCount, fs []frames.Frame) bool {
+	v.bytesReceived += size
+	for _, f := range fs {
+		ack, ok := f.(*frames.AckFrame)
+		if !ok {
+			continue
+		}
+		for _, p := range v.probes {
+			if ack.AcksPacket(p) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ShouldSendProbe determines if a new probe should be sent, i.e. if the last probe was sent more than interval ago
+func (v *pathValidator) ShouldSendProbe(now time.Time, interval time.Duration) bool {
+	return v.lastProbeTime.IsZero() || now.Sub(v.lastProbeTime) >= interval
+}
+
+// CanSend determines if size bytes can be sent to the address without exceeding the amplification limit
+func (v *pathValidator) CanSend(size protocol.ByteCount) bool {
+	return v.bytesSent+size <= protocol.PathValidationAmplificationFactor*v.bytesReceived
+}
+
+// SentProbe is called when a probe was sent to the address that is being validated
+func (v *pathValidator) SentProbe(p protocol.PacketNumber, size protocol.ByteCount, now time.Time) {
+	v.probes = append(v.probes, p)
+	v.bytesSent += size
+	v.lastProbeTime = now
+}
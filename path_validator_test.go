@@ -0,0 +1,49 @@
+package quic
+
+import (
+	"net"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/frames"
+	"github.com/lucas-clemente/quic-go/protocol"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Path validator", func() {
+	var v *pathValidator
+
+	BeforeEach(func() {
+		v = newPathValidator(&net.UDPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 1337})
+	})
+
+	It("validates the address when a probe is acknowledged", func() {
+		v.SentProbe(10, 50, time.Now())
+		v.SentProbe(20, 50, time.Now())
+		Expect(v.ReceivedPacket(100, []frames.Frame{&frames.PingFrame{}})).To(BeFalse())
+		Expect(v.ReceivedPacket(100, []frames.Frame{&frames.AckFrame{LargestAcked: 15, LowestAcked: 11}})).To(BeFalse())
+		Expect(v.ReceivedPacket(100, []frames.Frame{&frames.AckFrame{LargestAcked: 10, LowestAcked: 1}})).To(BeTrue())
+	})
+
+	It("doesn't validate the address before a probe was sent", func() {
+		Expect(v.ReceivedPacket(100, []frames.Frame{&frames.AckFrame{LargestAcked: 10, LowestAcked: 1}})).To(BeFalse())
+	})
+
+	It("sends probes at most once per interval", func() {
+		now := time.Now()
+		Expect(v.ShouldSendProbe(now, time.Second)).To(BeTrue())
+		v.SentProbe(10, 50, now)
+		Expect(v.ShouldSendProbe(now.Add(999*time.Millisecond), time.Second)).To(BeFalse())
+		Expect(v.ShouldSendProbe(now.Add(time.Second), time.Second)).To(BeTrue())
+	})
+
+	It("limits the number of bytes sent", func() {
+		Expect(v.CanSend(1)).To(BeFalse())
+		v.ReceivedPacket(100, nil)
+		Expect(v.CanSend(protocol.PathValidationAmplificationFactor * 100)).To(BeTrue())
+		v.SentProbe(10, 250, time.Now())
+		Expect(v.CanSend(50)).To(BeTrue())
+		Expect(v.CanSend(51)).To(BeFalse())
+	})
+})
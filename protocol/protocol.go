@@ -22,6 +22,10 @@ const (
 )
 
 // A ConnectionID in QUIC
+// The gQUIC Public Header only has a single flag bit for the connection ID: it is either sent with 8 bytes, or omitted (truncated).
+// Other lengths can't be encoded.
+// It is chosen randomly by the client, so a load balancer in front of the servers can't use it to encode the backend.
+// The server dispatches packets to its sessions by the full connection ID.
 type ConnectionID uint64
 
 // A StreamID in QUIC
@@ -6,9 +6,30 @@ import "time"
 // This is the value used by Chromium for a QUIC packet sent using IPv6 (for IPv4 it would be 1370)
 const MaxPacketSize ByteCount = 1350
 
+// MinMaxPacketSize is the smallest value that the maximum packet size can be configured to
+const MinMaxPacketSize ByteCount = 1200
+
+// MinInitialPacketSize is the size that packets sent by the client containing a CHLO are padded to
+const MinInitialPacketSize ByteCount = 1200
+
+// MTUSearchGranularity is the precision of the path MTU discovery: it stops probing once the largest supported packet size is known up to this many bytes
+const MTUSearchGranularity ByteCount = 10
+
+// MaxMTUProbes is the number of probes of the same size that must be lost before the path MTU discovery considers that size unsupported
+const MaxMTUProbes = 3
+
+// MinMTUProbeTimeout is the minimum time after which an unacknowledged MTU probe is declared lost
+const MinMTUProbeTimeout = 100 * time.Millisecond
+
 // MaxFrameAndPublicHeaderSize is the maximum size of a QUIC frame plus PublicHeader
 const MaxFrameAndPublicHeaderSize = MaxPacketSize - 12 /*crypto signature*/
 
+// MaxBatchWritePackets is the maximum number of packets sent in one batched write
+const MaxBatchWritePackets = 64
+
+// MaxBatchReadPackets is the maximum number of packets the server reads in one batched read
+const MaxBatchReadPackets = 64
+
 // NonForwardSecurePacketSizeReduction is the number of bytes a non forward-secure packet has to be smaller than a forward-secure packet
 // This makes sure that those packets can always be retransmitted without splitting the contained StreamFrames
 const NonForwardSecurePacketSizeReduction = 50
@@ -19,6 +40,12 @@ const DefaultMaxCongestionWindow = 1000
 // InitialCongestionWindow is the initial congestion window in QUIC packets
 const InitialCongestionWindow = 32
 
+// MaxInitialCongestionWindow is the largest initial congestion window in QUIC packets that can be configured
+const MaxInitialCongestionWindow = 200
+
+// DefaultMinCongestionWindow is the default for the minimum congestion window in QUIC packets
+const DefaultMinCongestionWindow = 2
+
 // MaxUndecryptablePackets limits the number of undecryptable packets that a
 // session queues for later until it sends a public reset.
 const MaxUndecryptablePackets = 10
@@ -84,9 +111,16 @@ const SkipPacketAveragePeriodLength PacketNumber = 500
 // MaxTrackedSkippedPackets is the maximum number of skipped packet numbers the SentPacketHandler keep track of for Optimistic ACK attack mitigation
 const MaxTrackedSkippedPackets = 10
 
+// MaxTrackedLostPackets is the maximum number of packet numbers of lost packets the SentPacketHandler keeps track of for detecting spurious retransmissions
+const MaxTrackedLostPackets = 50
+
 // STKExpiryTime is the valid time of a source address token
 const STKExpiryTime = 24 * time.Hour
 
+// STKSecretRotationInterval is the time after which the secret used to encrypt source address tokens is replaced.
+// Tokens encrypted with the previous secret are still accepted, so this must not be shorter than the STKExpiryTime.
+const STKSecretRotationInterval = STKExpiryTime
+
 // MaxTrackedSentPackets is maximum number of sent packets saved for either later retransmission or entropy calculation
 const MaxTrackedSentPackets = 2 * DefaultMaxCongestionWindow
 
@@ -96,6 +130,10 @@ const MaxTrackedReceivedPackets = 2 * DefaultMaxCongestionWindow
 // MaxTrackedReceivedAckRanges is the maximum number of ACK ranges tracked
 const MaxTrackedReceivedAckRanges = DefaultMaxCongestionWindow
 
+// MaxAckFrameRanges is the maximum number of ACK ranges sent in a single ACK frame
+// If more ranges are tracked, the ranges with the lowest packet numbers are omitted, since they were most likely reported in previous ACK frames already.
+const MaxAckFrameRanges = 32
+
 // MaxPacketsReceivedBeforeAckSend is the number of packets that can be received before an ACK frame is sent
 const MaxPacketsReceivedBeforeAckSend = 20
 
@@ -122,12 +160,15 @@ const InitialIdleTimeout = 5 * time.Second
 // DefaultIdleTimeout is the default idle timeout, for the server
 const DefaultIdleTimeout = 30 * time.Second
 
-// MaxIdleTimeoutServer is the maximum idle timeout that can be negotiated, for the server
+// MaxIdleTimeoutServer is the default maximum idle timeout that can be negotiated, for the server
 const MaxIdleTimeoutServer = 1 * time.Minute
 
-// MaxIdleTimeoutClient is the idle timeout that the client suggests to the server
+// MaxIdleTimeoutClient is the default idle timeout that the client suggests to the server
 const MaxIdleTimeoutClient = 2 * time.Minute
 
+// DefaultServerConfigOverlap is the default time that the previous server config is still accepted after a rotation
+const DefaultServerConfigOverlap = time.Hour
+
 // MaxTimeForCryptoHandshake is the default timeout for a connection until the crypto handshake succeeds.
 const MaxTimeForCryptoHandshake = 10 * time.Second
 
@@ -137,3 +178,19 @@ const ClosedSessionDeleteTimeout = time.Minute
 
 // NumCachedCertificates is the number of cached compressed certificate chains, each taking ~1K space
 const NumCachedCertificates = 128
+
+// PathValidationAmplificationFactor limits the number of bytes sent to an address that is being validated, relative to the number of bytes received from it
+const PathValidationAmplificationFactor = 3
+
+// AddressValidationAmplificationFactor limits the number of bytes the server sends to a client before its address is validated, relative to the number of bytes received from it
+const AddressValidationAmplificationFactor = 3
+
+// MinPathProbeInterval is the minimum time between two probes sent to an address that is being validated
+const MinPathProbeInterval = 10 * time.Millisecond
+
+// StreamReadFromBufferSize is the size of the buffers that Stream.ReadFrom reads into
+const StreamReadFromBufferSize = (1 << 10) * 32 // 32 kB
+
+// MaxStreamSendBufferSize is the maximum number of bytes buffered by a stream for sending
+// Stream.Write blocks until the data in the buffer was packed into packets, before it copies the next chunk of data
+const MaxStreamSendBufferSize = (1 << 10) * 64 // 64 kB
@@ -25,7 +25,7 @@ type PublicHeader struct {
 	ResetFlag            bool
 	TruncateConnectionID bool
 	PacketNumberLen      protocol.PacketNumberLen
-	PacketNumber         protocol.PacketNumber
+	PacketNumber         protocol.PacketNumber    // sent in plaintext, none of the supported versions use header protection
 	VersionNumber        protocol.VersionNumber   // VersionNumber sent by the client
 	SupportedVersions    []protocol.VersionNumber // VersionNumbers sent by the server
 	DiversificationNonce []byte
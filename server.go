@@ -2,7 +2,9 @@ package quic
 
 import (
 	"bytes"
+	"crypto/rand"
 	"errors"
+	"io"
 	"net"
 	"sync"
 	"time"
@@ -28,9 +30,12 @@ type server struct {
 	conn net.PacketConn
 
 	certChain crypto.CertChain
-	scfg      *handshake.ServerConfig
+	scfg      *handshake.ServerConfigManager
 
-	sessions                  map[protocol.ConnectionID]packetHandler
+	sessions map[protocol.ConnectionID]packetHandler
+	// connsPerIP counts the sessions per client IP, if Config.MaxConnsPerIP is set
+	// it is protected by the sessionsMutex
+	connsPerIP                map[string]int
 	sessionsMutex             sync.RWMutex
 	deleteClosedSessionsAfter time.Duration
 
@@ -38,7 +43,7 @@ type server struct {
 	sessionQueue chan Session
 	errorChan    chan struct{}
 
-	newSession func(conn connection, v protocol.VersionNumber, connectionID protocol.ConnectionID, sCfg *handshake.ServerConfig, config *Config) (packetHandler, <-chan handshakeEvent, error)
+	newSession func(conn connection, v protocol.VersionNumber, connectionID protocol.ConnectionID, scfg *handshake.ServerConfigManager, config *Config) (packetHandler, <-chan handshakeEvent, error)
 }
 
 var _ Listener = &server{}
@@ -50,7 +55,7 @@ func ListenAddr(addr string, config *Config) (Listener, error) {
 	if err != nil {
 		return nil, err
 	}
-	conn, err := net.ListenUDP("udp", udpAddr)
+	conn, err := listenUDP(udpAddr)
 	if err != nil {
 		return nil, err
 	}
@@ -61,21 +66,33 @@ func ListenAddr(addr string, config *Config) (Listener, error) {
 // The listener is not active until Serve() is called.
 func Listen(conn net.PacketConn, config *Config) (Listener, error) {
 	certChain := crypto.NewCertChain(config.TLSConfig)
-	kex, err := crypto.NewCurve25519KEX()
-	if err != nil {
-		return nil, err
-	}
-	scfg, err := handshake.NewServerConfig(kex, certChain)
-	if err != nil {
-		return nil, err
+	var scfg *handshake.ServerConfig
+	if config.ServerSecret != nil {
+		var err error
+		scfg, err = handshake.NewServerConfigFromSecret(config.ServerSecret, certChain)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		kex, err := crypto.NewCurve25519KEX()
+		if err != nil {
+			return nil, err
+		}
+		scfg, err = handshake.NewServerConfig(kex, certChain)
+		if err != nil {
+			return nil, err
+		}
 	}
+	config = populateServerConfig(config)
+	handshake.PrecomputeEphermalKEX()
 
 	s := &server{
 		conn:                      conn,
-		config:                    populateServerConfig(config),
+		config:                    config,
 		certChain:                 certChain,
-		scfg:                      scfg,
+		scfg:                      handshake.NewServerConfigManager(scfg, config.ServerConfigOverlap),
 		sessions:                  map[protocol.ConnectionID]packetHandler{},
+		connsPerIP:                map[string]int{},
 		newSession:                newSession,
 		deleteClosedSessionsAfter: protocol.ClosedSessionDeleteTimeout,
 		sessionQueue:              make(chan Session, 5),
@@ -110,16 +127,84 @@ func populateServerConfig(config *Config) *Config {
 	if config.AcceptSTK != nil {
 		vsa = config.AcceptSTK
 	}
+	initialCongestionWindow := protocol.PacketNumber(protocol.InitialCongestionWindow)
+	if config.InitialCongestionWindow != 0 {
+		initialCongestionWindow = utils.MinPacketNumber(config.InitialCongestionWindow, protocol.MaxInitialCongestionWindow)
+	}
+	minCongestionWindow := protocol.PacketNumber(protocol.DefaultMinCongestionWindow)
+	if config.MinCongestionWindow != 0 {
+		minCongestionWindow = config.MinCongestionWindow
+	}
+	minCongestionWindow = utils.MinPacketNumber(minCongestionWindow, initialCongestionWindow)
+	maxReceiveStreamFlowControlWindow := config.MaxReceiveStreamFlowControlWindow
+	if maxReceiveStreamFlowControlWindow == 0 {
+		maxReceiveStreamFlowControlWindow = uint64(protocol.MaxReceiveStreamFlowControlWindowServer)
+	}
+	maxReceiveConnectionFlowControlWindow := config.MaxReceiveConnectionFlowControlWindow
+	if maxReceiveConnectionFlowControlWindow == 0 {
+		maxReceiveConnectionFlowControlWindow = uint64(protocol.MaxReceiveConnectionFlowControlWindowServer)
+	}
+	idleTimeout := protocol.MaxIdleTimeoutServer
+	if config.IdleTimeout != 0 {
+		idleTimeout = config.IdleTimeout
+	}
+	handshakeTimeout := protocol.MaxTimeForCryptoHandshake
+	if config.HandshakeTimeout != 0 {
+		handshakeTimeout = config.HandshakeTimeout
+	}
+	maxAckDelay := protocol.AckSendDelay
+	if config.MaxAckDelay != 0 {
+		maxAckDelay = config.MaxAckDelay
+	}
+	serverConfigOverlap := protocol.DefaultServerConfigOverlap
+	if config.ServerConfigOverlap != 0 {
+		serverConfigOverlap = config.ServerConfigOverlap
+	}
+	var randReader io.Reader = rand.Reader
+	if config.Rand != nil {
+		// the reader is shared by all sessions using this config
+		randReader = utils.NewLockedReader(config.Rand)
+	}
+	maxPacketSize := protocol.MaxPacketSize
+	if config.MaxPacketSize != 0 {
+		maxPacketSize = utils.MaxByteCount(utils.MinByteCount(config.MaxPacketSize, protocol.MaxPacketSize), protocol.MinMaxPacketSize)
+	}
 
 	return &Config{
-		TLSConfig: config.TLSConfig,
-		Versions:  versions,
-		AcceptSTK: vsa,
+		TLSConfig:                             config.TLSConfig,
+		Versions:                              versions,
+		AcceptSTK:                             vsa,
+		AcceptClientHello:                     config.AcceptClientHello,
+		MaxIncomingStreams:                    config.MaxIncomingStreams,
+		IdleTimeout:                           idleTimeout,
+		HandshakeTimeout:                      handshakeTimeout,
+		MaxAckDelay:                           maxAckDelay,
+		InitialCongestionWindow:               initialCongestionWindow,
+		MinCongestionWindow:                   minCongestionWindow,
+		MaxPacketSize:                         maxPacketSize,
+		MaxReceiveStreamFlowControlWindow:     maxReceiveStreamFlowControlWindow,
+		MaxReceiveConnectionFlowControlWindow: maxReceiveConnectionFlowControlWindow,
+		KeepAlive:                             config.KeepAlive,
+		EnablePacing:                          config.EnablePacing,
+		StreamScheduling:                      config.StreamScheduling,
+		BatchWrites:                           config.BatchWrites,
+		BatchReads:                            config.BatchReads,
+		EnablePathMTUDiscovery:                config.EnablePathMTUDiscovery,
+		Tracer:                                config.Tracer,
+		Logger:                                config.Logger,
+		Rand:                                  randReader,
+		StatelessResetEnabled:                 config.StatelessResetEnabled,
+		ServerConfigOverlap:                   serverConfigOverlap,
+		ServerSecret:                          config.ServerSecret,
+		MaxConnsPerIP:                         config.MaxConnsPerIP,
 	}
 }
 
 // serve listens on an existing PacketConn
 func (s *server) serve() {
+	if s.config.BatchReads && s.serveBatched() {
+		return
+	}
 	for {
 		data := getPacketBuffer()
 		data = data[:protocol.MaxReceivePacketSize]
@@ -139,6 +224,42 @@ func (s *server) serve() {
 	}
 }
 
+// serveBatched listens on an existing PacketConn, reading multiple packets with a single syscall
+// It returns false if batched reads are not supported for this PacketConn
+func (s *server) serveBatched() bool {
+	bufs := make([][]byte, protocol.MaxBatchReadPackets)
+	sizes := make([]int, protocol.MaxBatchReadPackets)
+	addrs := make([]net.Addr, protocol.MaxBatchReadPackets)
+	for {
+		for i := range bufs {
+			// buffers are handed to the sessions, get new ones for the packets read
+			if bufs[i] == nil {
+				bufs[i] = getPacketBuffer()[:protocol.MaxReceivePacketSize]
+			}
+		}
+		n, err := readBatch(s.conn, bufs, sizes, addrs)
+		if err == errBatchReadsNotSupported {
+			for _, b := range bufs {
+				putPacketBuffer(b)
+			}
+			return false
+		}
+		if err != nil {
+			s.serverError = err
+			close(s.errorChan)
+			_ = s.Close()
+			return true
+		}
+		for i := 0; i < n; i++ {
+			data := bufs[i][:sizes[i]]
+			bufs[i] = nil
+			if err := s.handlePacket(s.conn, addrs[i], data); err != nil {
+				utils.Errorf("error handling packet: %s", err.Error())
+			}
+		}
+	}
+}
+
 // Accept returns newly openend sessions
 func (s *server) Accept() (Session, error) {
 	var sess Session
@@ -150,6 +271,45 @@ func (s *server) Accept() (Session, error) {
 	}
 }
 
+// NumActiveSessions returns the number of sessions that are not closed yet
+func (s *server) NumActiveSessions() int {
+	s.sessionsMutex.RLock()
+	defer s.sessionsMutex.RUnlock()
+	var n int
+	for _, session := range s.sessions {
+		// closed sessions are kept in the map, with a nil value
+		if session != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// Range calls f for every session that is not closed yet, until f returns false
+func (s *server) Range(f func(Session) bool) {
+	// f might close sessions, which then need to be removed from the map
+	// copy the sessions, so that the mutex isn't held while calling f
+	s.sessionsMutex.RLock()
+	sessions := make([]Session, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		if session != nil {
+			sessions = append(sessions, session)
+		}
+	}
+	s.sessionsMutex.RUnlock()
+
+	for _, session := range sessions {
+		if !f(session) {
+			return
+		}
+	}
+}
+
+// RotateServerConfig replaces the server config used for new handshakes
+func (s *server) RotateServerConfig() error {
+	return s.scfg.Rotate()
+}
+
 // Close the server
 func (s *server) Close() error {
 	s.sessionsMutex.Lock()
@@ -193,7 +353,7 @@ func (s *server) handlePacket(pconn net.PacketConn, remoteAddr net.Addr, packet
 			var pr *publicReset
 			pr, err = parsePublicReset(r)
 			if err != nil {
-				utils.Infof("Received a Public Reset for connection %x. An error occurred parsing the packet.")
+				utils.Infof("Received a Public Reset for connection %x. An error occurred parsing the packet.", hdr.ConnectionID)
 			} else {
 				utils.Infof("Received a Public Reset for connection %x, rejected packet number: 0x%x.", hdr.ConnectionID, pr.rejectedPacketNumber)
 			}
@@ -223,6 +383,11 @@ func (s *server) handlePacket(pconn net.PacketConn, remoteAddr net.Addr, packet
 
 	if !ok {
 		if !hdr.VersionFlag {
+			if !s.config.StatelessResetEnabled {
+				utils.Infof("Dropping packet for unknown connection %x.", hdr.ConnectionID)
+				return nil
+			}
+			utils.Infof("Sending a Public Reset for unknown connection %x.", hdr.ConnectionID)
 			_, err = pconn.WriteTo(writePublicReset(hdr.ConnectionID, hdr.PacketNumber, 0), remoteAddr)
 			return err
 		}
@@ -231,16 +396,29 @@ func (s *server) handlePacket(pconn net.PacketConn, remoteAddr net.Addr, packet
 			return errors.New("Server BUG: negotiated version not supported")
 		}
 
+		var ip string
+		if s.config.MaxConnsPerIP > 0 {
+			ip = ipOfAddr(remoteAddr)
+			if !s.addConnForIP(ip) {
+				utils.Infof("Refusing connection %x from %v: too many connections from this IP.", hdr.ConnectionID, remoteAddr)
+				_, err = pconn.WriteTo(writePublicReset(hdr.ConnectionID, hdr.PacketNumber, 0), remoteAddr)
+				return err
+			}
+		}
+
 		utils.Infof("Serving new connection: %x, version %d from %v", hdr.ConnectionID, version, remoteAddr)
 		var handshakeChan <-chan handshakeEvent
 		session, handshakeChan, err = s.newSession(
-			&conn{pconn: pconn, currentAddr: remoteAddr},
+			&conn{pconn: pconn, currentAddr: remoteAddr, batchWrites: s.config.BatchWrites},
 			version,
 			hdr.ConnectionID,
 			s.scfg,
 			s.config,
 		)
 		if err != nil {
+			if s.config.MaxConnsPerIP > 0 {
+				s.removeConnForIP(ip)
+			}
 			return err
 		}
 		s.sessionsMutex.Lock()
@@ -251,6 +429,9 @@ func (s *server) handlePacket(pconn net.PacketConn, remoteAddr net.Addr, packet
 			// session.run() returns as soon as the session is closed
 			_ = session.run()
 			s.removeConnection(hdr.ConnectionID)
+			if s.config.MaxConnsPerIP > 0 {
+				s.removeConnForIP(ip)
+			}
 		}()
 
 		go func() {
@@ -291,6 +472,36 @@ func (s *server) removeConnection(id protocol.ConnectionID) {
 	})
 }
 
+// addConnForIP counts a new session from the IP
+// it returns false if the server already handles Config.MaxConnsPerIP sessions from this IP
+func (s *server) addConnForIP(ip string) bool {
+	s.sessionsMutex.Lock()
+	defer s.sessionsMutex.Unlock()
+	if s.connsPerIP[ip] >= s.config.MaxConnsPerIP {
+		return false
+	}
+	s.connsPerIP[ip]++
+	return true
+}
+
+func (s *server) removeConnForIP(ip string) {
+	s.sessionsMutex.Lock()
+	defer s.sessionsMutex.Unlock()
+	s.connsPerIP[ip]--
+	if s.connsPerIP[ip] <= 0 {
+		delete(s.connsPerIP, ip)
+	}
+}
+
+// ipOfAddr returns the IP of a client address, without the port
+// for addresses that are not UDP addresses, the whole address is used
+func ipOfAddr(addr net.Addr) string {
+	if udpAddr, ok := addr.(*net.UDPAddr); ok {
+		return udpAddr.IP.String()
+	}
+	return addr.String()
+}
+
 func composeVersionNegotiation(connectionID protocol.ConnectionID, versions []protocol.VersionNumber) []byte {
 	fullReply := &bytes.Buffer{}
 	responsePublicHeader := PublicHeader{
@@ -0,0 +1,148 @@
+// +build linux,go1.9
+
+package quic
+
+import (
+	"bytes"
+	"net"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/utils"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Server, with batched reads", func() {
+	var (
+		serv            *server
+		sender, udpConn *net.UDPConn
+	)
+
+	// composePacket composes a packet for a connection. The first packet of a connection has the VersionFlag set.
+	composePacket := func(connID protocol.ConnectionID, first bool) []byte {
+		b := &bytes.Buffer{}
+		if first {
+			b.WriteByte(0x09)
+		} else {
+			b.WriteByte(0x08)
+		}
+		utils.WriteUint64(b, uint64(connID))
+		if first {
+			utils.WriteUint32(b, protocol.VersionNumberToTag(protocol.SupportedVersions[0]))
+		}
+		b.WriteByte(0x01) // packet number
+		return b.Bytes()
+	}
+
+	BeforeEach(func() {
+		var err error
+		udpConn, err = net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		Expect(err).ToNot(HaveOccurred())
+		sender, err = net.DialUDP("udp", nil, udpConn.LocalAddr().(*net.UDPAddr))
+		Expect(err).ToNot(HaveOccurred())
+		serv = &server{
+			sessions:     make(map[protocol.ConnectionID]packetHandler),
+			newSession:   newMockSession,
+			conn:         udpConn,
+			config:       &Config{Versions: protocol.SupportedVersions, BatchReads: true},
+			sessionQueue: make(chan Session, 5),
+			errorChan:    make(chan struct{}),
+		}
+	})
+
+	AfterEach(func() {
+		sender.Close()
+		serv.Close()
+	})
+
+	It("dispatches all packets to the sessions", func() {
+		const numSessions = 3
+		const packetsPerSession = 20
+		for i := 0; i < packetsPerSession; i++ {
+			for j := 0; j < numSessions; j++ {
+				_, err := sender.Write(composePacket(protocol.ConnectionID(j+1), i == 0))
+				Expect(err).ToNot(HaveOccurred())
+			}
+		}
+		go serv.serve()
+		for j := 0; j < numSessions; j++ {
+			connID := protocol.ConnectionID(j + 1)
+			Eventually(func() int {
+				serv.sessionsMutex.RLock()
+				defer serv.sessionsMutex.RUnlock()
+				sess, ok := serv.sessions[connID]
+				if !ok {
+					return 0
+				}
+				return sess.(*mockSession).packetCount
+			}).Should(Equal(packetsPerSession))
+		}
+	})
+
+	It("returns read errors", func(done Done) {
+		go serv.serve()
+		udpConn.Close()
+		_, err := serv.Accept()
+		Expect(err).To(HaveOccurred())
+		close(done)
+	})
+
+	Measure("reading packets", func(b Benchmarker) {
+		// the packets have to fit into the socket's receive buffer
+		const numPackets = 100
+		packet := bytes.Repeat([]byte{'f'}, 1000)
+		fillBuffer := func() {
+			for i := 0; i < numPackets; i++ {
+				_, err := sender.Write(packet)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			// give the kernel some time to deliver the packets
+			time.Sleep(10 * time.Millisecond)
+		}
+		buf := make([]byte, protocol.MaxReceivePacketSize)
+		bufs := make([][]byte, protocol.MaxBatchReadPackets)
+		for i := range bufs {
+			bufs[i] = make([]byte, protocol.MaxReceivePacketSize)
+		}
+		sizes := make([]int, protocol.MaxBatchReadPackets)
+		addrs := make([]net.Addr, protocol.MaxBatchReadPackets)
+
+		// Packets might be dropped by the kernel. Stop reading once no more packets arrive, instead of blocking forever.
+		isTimeout := func(err error) bool {
+			nerr, ok := err.(net.Error)
+			return ok && nerr.Timeout()
+		}
+
+		fillBuffer()
+		var readUnbatched int
+		udpConn.SetReadDeadline(time.Now().Add(time.Second))
+		unbatched := b.Time("reading without batching", func() {
+			for readUnbatched < numPackets {
+				_, _, err := udpConn.ReadFrom(buf)
+				if isTimeout(err) {
+					break
+				}
+				Expect(err).ToNot(HaveOccurred())
+				readUnbatched++
+			}
+		})
+		fillBuffer()
+		var readBatched int
+		udpConn.SetReadDeadline(time.Now().Add(time.Second))
+		batched := b.Time("reading with batching", func() {
+			for readBatched < numPackets {
+				n, err := readBatch(udpConn, bufs, sizes, addrs)
+				if isTimeout(err) {
+					break
+				}
+				Expect(err).ToNot(HaveOccurred())
+				readBatched += n
+			}
+		})
+		Expect(readUnbatched).ToNot(BeZero())
+		Expect(readBatched).ToNot(BeZero())
+		b.RecordValue("packets per second, without batching", float64(readUnbatched)/unbatched.Seconds())
+		b.RecordValue("packets per second, with batching", float64(readBatched)/batched.Seconds())
+	}, 10)
+})
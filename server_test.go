@@ -2,7 +2,10 @@ package quic
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
 	"crypto/tls"
+	"encoding/binary"
 	"errors"
 	"net"
 	"reflect"
@@ -48,7 +51,10 @@ func (s *mockSession) Close(e error) error {
 	close(s.stopRunLoop)
 	return nil
 }
-func (s *mockSession) AcceptStream() (Stream, error) {
+func (s *mockSession) CloseWithError(code qerr.ErrorCode, reason string) error {
+	return s.Close(qerr.Error(code, reason))
+}
+func (s *mockSession) AcceptStream(ctx context.Context) (Stream, error) {
 	panic("not implemented")
 }
 func (s *mockSession) OpenStream() (Stream, error) {
@@ -63,6 +69,27 @@ func (s *mockSession) LocalAddr() net.Addr {
 func (s *mockSession) RemoteAddr() net.Addr {
 	panic("not implemented")
 }
+func (s *mockSession) MigrateTo(net.PacketConn) error {
+	panic("not implemented")
+}
+func (s *mockSession) Stats() SessionStats {
+	panic("not implemented")
+}
+func (s *mockSession) GetRTTStats() RTTStats {
+	panic("not implemented")
+}
+func (s *mockSession) HandshakeComplete() <-chan struct{} {
+	panic("not implemented")
+}
+func (s *mockSession) Context() context.Context {
+	panic("not implemented")
+}
+func (s *mockSession) GetVersion() protocol.VersionNumber {
+	panic("not implemented")
+}
+func (s *mockSession) ConnectionState() ConnectionState {
+	panic("not implemented")
+}
 
 var _ Session = &mockSession{}
 var _ NonFWSession = &mockSession{}
@@ -71,7 +98,7 @@ func newMockSession(
 	_ connection,
 	_ protocol.VersionNumber,
 	connectionID protocol.ConnectionID,
-	_ *handshake.ServerConfig,
+	_ *handshake.ServerConfigManager,
 	_ *Config,
 ) (packetHandler, <-chan handshakeEvent, error) {
 	s := mockSession{
@@ -108,6 +135,7 @@ var _ = Describe("Server", func() {
 		BeforeEach(func() {
 			serv = &server{
 				sessions:     make(map[protocol.ConnectionID]packetHandler),
+				connsPerIP:   make(map[string]int),
 				newSession:   newMockSession,
 				conn:         conn,
 				config:       config,
@@ -180,6 +208,14 @@ var _ = Describe("Server", func() {
 			close(done)
 		})
 
+		// firstPacketFor returns a valid first packet for a new connection with the connection ID
+		firstPacketFor := func(id protocol.ConnectionID) []byte {
+			p := make([]byte, len(firstPacket))
+			copy(p, firstPacket)
+			binary.LittleEndian.PutUint64(p[1:9], uint64(id))
+			return p
+		}
+
 		It("assigns packets to existing sessions", func() {
 			err := serv.handlePacket(nil, nil, firstPacket)
 			Expect(err).ToNot(HaveOccurred())
@@ -190,6 +226,33 @@ var _ = Describe("Server", func() {
 			Expect(serv.sessions[connID].(*mockSession).packetCount).To(Equal(2))
 		})
 
+		It("dispatches packets by the full connection ID", func() {
+			// the IDs only differ in the most significant byte
+			var connIDs []protocol.ConnectionID
+			for backend := uint64(1); backend <= 3; backend++ {
+				connIDs = append(connIDs, protocol.ConnectionID(backend<<56|0x1337))
+			}
+			for _, id := range connIDs {
+				err := serv.handlePacket(nil, nil, firstPacketFor(id))
+				Expect(err).ToNot(HaveOccurred())
+			}
+			Expect(serv.sessions).To(HaveLen(3))
+			// send i additional packets to the i-th session
+			for i, id := range connIDs {
+				for j := 0; j < i; j++ {
+					p := []byte{0x08, 0, 0, 0, 0, 0, 0, 0, 0, 0x01}
+					binary.LittleEndian.PutUint64(p[1:9], uint64(id))
+					err := serv.handlePacket(nil, nil, p)
+					Expect(err).ToNot(HaveOccurred())
+				}
+			}
+			for i, id := range connIDs {
+				sess := serv.sessions[id].(*mockSession)
+				Expect(sess.connectionID).To(Equal(id))
+				Expect(sess.packetCount).To(Equal(i + 1))
+			}
+		})
+
 		It("closes and deletes sessions", func() {
 			serv.deleteClosedSessionsAfter = time.Second // make sure that the nil value for the closed session doesn't get deleted in this test
 			nullAEAD := crypto.NewNullAEAD(protocol.PerspectiveServer, protocol.VersionWhatever)
@@ -230,6 +293,114 @@ var _ = Describe("Server", func() {
 			Expect(conn.closed).To(BeTrue())
 		})
 
+		It("counts the active sessions", func() {
+			Expect(serv.NumActiveSessions()).To(BeZero())
+			for i := 1; i <= 3; i++ {
+				session, _, _ := newMockSession(nil, 0, protocol.ConnectionID(i), nil, nil)
+				serv.sessions[protocol.ConnectionID(i)] = session
+			}
+			Expect(serv.NumActiveSessions()).To(Equal(3))
+			// closed sessions are not counted
+			serv.sessions[2] = nil
+			Expect(serv.NumActiveSessions()).To(Equal(2))
+		})
+
+		It("ranges over the active sessions", func() {
+			for i := 1; i <= 3; i++ {
+				session, _, _ := newMockSession(nil, 0, protocol.ConnectionID(i), nil, nil)
+				serv.sessions[protocol.ConnectionID(i)] = session
+			}
+			serv.sessions[4] = nil
+			var ids []protocol.ConnectionID
+			serv.Range(func(sess Session) bool {
+				ids = append(ids, sess.(*mockSession).connectionID)
+				return true
+			})
+			Expect(ids).To(ConsistOf(protocol.ConnectionID(1), protocol.ConnectionID(2), protocol.ConnectionID(3)))
+		})
+
+		It("stops ranging when the function returns false", func() {
+			for i := 1; i <= 3; i++ {
+				session, _, _ := newMockSession(nil, 0, protocol.ConnectionID(i), nil, nil)
+				serv.sessions[protocol.ConnectionID(i)] = session
+			}
+			var calls int
+			serv.Range(func(Session) bool {
+				calls++
+				return false
+			})
+			Expect(calls).To(Equal(1))
+		})
+
+		It("allows removing sessions while ranging", func(done Done) {
+			for i := 1; i <= 3; i++ {
+				session, _, _ := newMockSession(nil, 0, protocol.ConnectionID(i), nil, nil)
+				serv.sessions[protocol.ConnectionID(i)] = session
+			}
+			serv.Range(func(sess Session) bool {
+				s := sess.(*mockSession)
+				Expect(s.Close(nil)).To(Succeed())
+				serv.removeConnection(s.connectionID)
+				return true
+			})
+			Expect(serv.NumActiveSessions()).To(BeZero())
+			close(done)
+		}, 0.5)
+
+		Context("limiting the number of connections per IP", func() {
+			BeforeEach(func() {
+				serv.config.MaxConnsPerIP = 2
+			})
+
+			It("refuses new sessions from an IP that already has the maximum number of sessions", func() {
+				for i := 1; i <= 2; i++ {
+					addr := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 1000 + i}
+					err := serv.handlePacket(conn, addr, firstPacketFor(protocol.ConnectionID(i)))
+					Expect(err).ToNot(HaveOccurred())
+				}
+				Expect(serv.sessions).To(HaveLen(2))
+				Expect(conn.dataWritten.Len()).To(BeZero())
+				// the port doesn't matter
+				addr := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 1003}
+				err := serv.handlePacket(conn, addr, firstPacketFor(3))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(serv.sessions).To(HaveLen(2))
+				Expect(serv.sessions).ToNot(HaveKey(protocol.ConnectionID(3)))
+				Expect(conn.dataWrittenTo).To(Equal(addr))
+				Expect(conn.dataWritten.Bytes()[0] & 0x02).ToNot(BeZero()) // check that the ResetFlag is set
+			})
+
+			It("accepts sessions from a different IP", func() {
+				for i := 1; i <= 2; i++ {
+					err := serv.handlePacket(conn, &net.UDPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 1000 + i}, firstPacketFor(protocol.ConnectionID(i)))
+					Expect(err).ToNot(HaveOccurred())
+				}
+				err := serv.handlePacket(conn, &net.UDPAddr{IP: net.IPv4(192, 168, 0, 2), Port: 1001}, firstPacketFor(3))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(serv.sessions).To(HaveLen(3))
+				Expect(conn.dataWritten.Len()).To(BeZero())
+			})
+
+			It("accepts a new session once a session from the IP is closed", func() {
+				for i := 1; i <= 2; i++ {
+					err := serv.handlePacket(conn, &net.UDPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 1000 + i}, firstPacketFor(protocol.ConnectionID(i)))
+					Expect(err).ToNot(HaveOccurred())
+				}
+				serv.sessions[1].(*mockSession).stopRunLoop <- struct{}{}
+				Eventually(func() int {
+					serv.sessionsMutex.RLock()
+					defer serv.sessionsMutex.RUnlock()
+					return serv.connsPerIP["192.168.0.1"]
+				}).Should(Equal(1))
+				err := serv.handlePacket(conn, &net.UDPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 1003}, firstPacketFor(3))
+				Expect(err).ToNot(HaveOccurred())
+				serv.sessionsMutex.RLock()
+				defer serv.sessionsMutex.RUnlock()
+				Expect(serv.sessions).To(HaveKey(protocol.ConnectionID(3)))
+				Expect(serv.sessions[3]).ToNot(BeNil())
+			})
+		})
+
 		It("ignores packets for closed sessions", func() {
 			serv.sessions[connID] = nil
 			err := serv.handlePacket(nil, nil, []byte{0x08, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0x01})
@@ -276,6 +447,18 @@ var _ = Describe("Server", func() {
 			Expect(serv.Close()).To(Succeed())
 		})
 
+		It("falls back to reading packets one by one, if batched reads are not supported", func() {
+			config.BatchReads = true
+			conn.dataToRead = firstPacket
+			conn.dataReadFrom = udpAddr
+			go serv.serve()
+			Eventually(func() packetHandler {
+				serv.sessionsMutex.RLock()
+				defer serv.sessionsMutex.RUnlock()
+				return serv.sessions[connID]
+			}).ShouldNot(BeNil())
+		})
+
 		It("ignores delayed packets with mismatching versions", func() {
 			err := serv.handlePacket(nil, nil, firstPacket)
 			Expect(err).ToNot(HaveOccurred())
@@ -344,10 +527,18 @@ var _ = Describe("Server", func() {
 	It("setups with the right values", func() {
 		supportedVersions := []protocol.VersionNumber{1, 3, 5}
 		acceptSTK := func(_ net.Addr, _ *STK) bool { return true }
+		acceptClientHello := func(*ClientHelloInfo) bool { return true }
 		config := Config{
-			TLSConfig: &tls.Config{},
-			Versions:  supportedVersions,
-			AcceptSTK: acceptSTK,
+			TLSConfig:             &tls.Config{},
+			Versions:              supportedVersions,
+			AcceptSTK:             acceptSTK,
+			AcceptClientHello:     acceptClientHello,
+			IdleTimeout:           42 * time.Hour,
+			HandshakeTimeout:      1337 * time.Minute,
+			StatelessResetEnabled: true,
+			MaxConnsPerIP:         10,
+			StreamScheduling:      StreamSchedulingInterleaved,
+			MaxAckDelay:           5 * time.Millisecond,
 		}
 		ln, err := Listen(conn, &config)
 		Expect(err).ToNot(HaveOccurred())
@@ -355,8 +546,17 @@ var _ = Describe("Server", func() {
 		Expect(server.deleteClosedSessionsAfter).To(Equal(protocol.ClosedSessionDeleteTimeout))
 		Expect(server.sessions).ToNot(BeNil())
 		Expect(server.scfg).ToNot(BeNil())
+		// the server config is generated before the first connection arrives
+		Expect(server.scfg.Current().Get()).ToNot(BeEmpty())
 		Expect(server.config.Versions).To(Equal(supportedVersions))
 		Expect(reflect.ValueOf(server.config.AcceptSTK)).To(Equal(reflect.ValueOf(acceptSTK)))
+		Expect(reflect.ValueOf(server.config.AcceptClientHello)).To(Equal(reflect.ValueOf(acceptClientHello)))
+		Expect(server.config.IdleTimeout).To(Equal(42 * time.Hour))
+		Expect(server.config.HandshakeTimeout).To(Equal(1337 * time.Minute))
+		Expect(server.config.StatelessResetEnabled).To(BeTrue())
+		Expect(server.config.MaxConnsPerIP).To(Equal(10))
+		Expect(server.config.StreamScheduling).To(Equal(StreamSchedulingInterleaved))
+		Expect(server.config.MaxAckDelay).To(Equal(5 * time.Millisecond))
 	})
 
 	It("fills in default values if options are not set in the Config", func() {
@@ -366,6 +566,85 @@ var _ = Describe("Server", func() {
 		server := ln.(*server)
 		Expect(server.config.Versions).To(Equal(protocol.SupportedVersions))
 		Expect(reflect.ValueOf(server.config.AcceptSTK)).To(Equal(reflect.ValueOf(defaultAcceptSTK)))
+		Expect(server.config.IdleTimeout).To(Equal(protocol.MaxIdleTimeoutServer))
+		Expect(server.config.HandshakeTimeout).To(Equal(protocol.MaxTimeForCryptoHandshake))
+		Expect(server.config.MaxAckDelay).To(Equal(protocol.AckSendDelay))
+		Expect(server.config.InitialCongestionWindow).To(BeEquivalentTo(protocol.InitialCongestionWindow))
+		Expect(server.config.MinCongestionWindow).To(BeEquivalentTo(protocol.DefaultMinCongestionWindow))
+		Expect(server.config.Rand).To(Equal(rand.Reader))
+		Expect(server.config.MaxPacketSize).To(Equal(protocol.MaxPacketSize))
+		Expect(server.config.MaxReceiveStreamFlowControlWindow).To(BeEquivalentTo(protocol.MaxReceiveStreamFlowControlWindowServer))
+		Expect(server.config.MaxReceiveConnectionFlowControlWindow).To(BeEquivalentTo(protocol.MaxReceiveConnectionFlowControlWindowServer))
+		Expect(server.config.ServerConfigOverlap).To(Equal(protocol.DefaultServerConfigOverlap))
+	})
+
+	It("rotates the server config", func() {
+		ln, err := Listen(conn, &Config{TLSConfig: &tls.Config{}})
+		Expect(err).ToNot(HaveOccurred())
+		server := ln.(*server)
+		scfg := server.scfg.Current()
+		Expect(ln.RotateServerConfig()).To(Succeed())
+		Expect(server.scfg.Current().ID).ToNot(Equal(scfg.ID))
+		Expect(server.scfg.Get(scfg.ID)).To(BeIdenticalTo(scfg))
+	})
+
+	It("derives the server config from the ServerSecret", func() {
+		config := &Config{
+			TLSConfig:    &tls.Config{},
+			ServerSecret: bytes.Repeat([]byte("secret"), 6),
+		}
+		ln1, err := Listen(conn, config)
+		Expect(err).ToNot(HaveOccurred())
+		defer ln1.Close()
+		serv1 := ln1.(*server)
+		Expect(serv1.config.ServerSecret).To(Equal(config.ServerSecret))
+		scfg := serv1.scfg.Current()
+		remoteAddr := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 1337}
+		token, err := scfg.STKGenerator().NewToken(remoteAddr)
+		Expect(err).ToNot(HaveOccurred())
+		// restart, using the same secret
+		ln2, err := Listen(conn, config)
+		Expect(err).ToNot(HaveOccurred())
+		defer ln2.Close()
+		serv2 := ln2.(*server)
+		Expect(serv2.scfg.Current().Get()).To(Equal(scfg.Get()))
+		// the STK issued before the restart is still valid
+		stk, err := serv2.scfg.Current().STKGenerator().DecodeToken(token)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stk.RemoteAddr).To(Equal("192.168.0.1"))
+	})
+
+	It("errors if the ServerSecret is too short", func() {
+		_, err := Listen(conn, &Config{
+			TLSConfig:    &tls.Config{},
+			ServerSecret: []byte("foobar"),
+		})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("limits the initial congestion window", func() {
+		config := populateServerConfig(&Config{InitialCongestionWindow: 100})
+		Expect(config.InitialCongestionWindow).To(Equal(protocol.PacketNumber(100)))
+		config = populateServerConfig(&Config{InitialCongestionWindow: protocol.MaxInitialCongestionWindow + 1})
+		Expect(config.InitialCongestionWindow).To(BeEquivalentTo(protocol.MaxInitialCongestionWindow))
+	})
+
+	It("limits the minimum congestion window to the initial congestion window", func() {
+		config := populateServerConfig(&Config{MinCongestionWindow: 10})
+		Expect(config.MinCongestionWindow).To(Equal(protocol.PacketNumber(10)))
+		config = populateServerConfig(&Config{MinCongestionWindow: 50, InitialCongestionWindow: 20})
+		Expect(config.MinCongestionWindow).To(Equal(protocol.PacketNumber(20)))
+		config = populateServerConfig(&Config{MinCongestionWindow: protocol.InitialCongestionWindow + 1})
+		Expect(config.MinCongestionWindow).To(BeEquivalentTo(protocol.InitialCongestionWindow))
+	})
+
+	It("limits the maximum packet size", func() {
+		config := populateServerConfig(&Config{MaxPacketSize: 1250})
+		Expect(config.MaxPacketSize).To(Equal(protocol.ByteCount(1250)))
+		config = populateServerConfig(&Config{MaxPacketSize: protocol.MinMaxPacketSize - 1})
+		Expect(config.MaxPacketSize).To(Equal(protocol.MinMaxPacketSize))
+		config = populateServerConfig(&Config{MaxPacketSize: protocol.MaxPacketSize + 1})
+		Expect(config.MaxPacketSize).To(Equal(protocol.MaxPacketSize))
 	})
 
 	It("listens on a given address", func() {
@@ -422,7 +701,36 @@ var _ = Describe("Server", func() {
 		Consistently(func() bool { return returned }).Should(BeFalse())
 	})
 
+	It("lists the versions from the quic.Config in the version negotiation packet", func() {
+		config.Versions = []protocol.VersionNumber{protocol.Version36, protocol.Version35}
+		b := &bytes.Buffer{}
+		hdr := PublicHeader{
+			VersionFlag:     true,
+			ConnectionID:    0x1337,
+			PacketNumber:    1,
+			PacketNumberLen: protocol.PacketNumberLen2,
+		}
+		hdr.Write(b, protocol.Version37, protocol.PerspectiveClient)
+		b.Write(bytes.Repeat([]byte{0}, protocol.ClientHelloMinimumSize)) // add a fake CHLO
+		conn.dataToRead = b.Bytes()
+		conn.dataReadFrom = udpAddr
+		ln, err := Listen(conn, config)
+		Expect(err).ToNot(HaveOccurred())
+		defer ln.Close()
+
+		Eventually(func() int { return conn.dataWritten.Len() }).ShouldNot(BeZero())
+		Expect(conn.dataWrittenTo).To(Equal(udpAddr))
+		r := bytes.NewReader(conn.dataWritten.Bytes())
+		vnHdr, err := ParsePublicHeader(r, protocol.PerspectiveServer)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(vnHdr.VersionFlag).To(BeTrue())
+		Expect(vnHdr.ConnectionID).To(Equal(protocol.ConnectionID(0x1337)))
+		Expect(vnHdr.SupportedVersions).To(Equal(config.Versions))
+		Expect(r.Len()).To(BeZero())
+	})
+
 	It("sends a PublicReset for new connections that don't have the VersionFlag set", func() {
+		config.StatelessResetEnabled = true
 		conn.dataReadFrom = udpAddr
 		conn.dataToRead = []byte{0x08, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0x01}
 		ln, err := Listen(conn, config)
@@ -438,6 +746,22 @@ var _ = Describe("Server", func() {
 		Expect(conn.dataWritten.Bytes()[0] & 0x02).ToNot(BeZero()) // check that the ResetFlag is set
 		Expect(ln.(*server).sessions).To(BeEmpty())
 	})
+
+	It("doesn't send a PublicReset for unknown connections if stateless resets are disabled", func() {
+		config.StatelessResetEnabled = false
+		conn.dataReadFrom = udpAddr
+		conn.dataToRead = []byte{0x08, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0x01}
+		ln, err := Listen(conn, config)
+		Expect(err).ToNot(HaveOccurred())
+		defer ln.Close()
+		go func() {
+			defer GinkgoRecover()
+			_, _ = ln.Accept()
+		}()
+
+		Consistently(func() int { return conn.dataWritten.Len() }).Should(BeZero())
+		Expect(ln.(*server).sessions).To(BeEmpty())
+	})
 })
 
 var _ = Describe("default source address verification", func() {
@@ -482,6 +806,19 @@ var _ = Describe("default source address verification", func() {
 		Expect(defaultAcceptSTK(remoteAddr, stk)).To(BeFalse())
 	})
 
+	It("rejects a token replayed from a different source address", func() {
+		stkGenerator, err := handshake.NewSTKGenerator()
+		Expect(err).ToNot(HaveOccurred())
+		token, err := stkGenerator.NewToken(&net.UDPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 1337})
+		Expect(err).ToNot(HaveOccurred())
+		hstk, err := stkGenerator.DecodeToken(token)
+		Expect(err).ToNot(HaveOccurred())
+		stk := &STK{remoteAddr: hstk.RemoteAddr, sentTime: hstk.SentTime}
+		// the token is bound to the IP, not to the port
+		Expect(defaultAcceptSTK(&net.UDPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 4242}, stk)).To(BeTrue())
+		Expect(defaultAcceptSTK(&net.UDPAddr{IP: net.IPv4(192, 168, 0, 2), Port: 1337}, stk)).To(BeFalse())
+	})
+
 	It("rejects an expired token", func() {
 		remoteAddr := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 1)}
 		stk := &STK{
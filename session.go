@@ -1,9 +1,12 @@
 package quic
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"net"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -32,6 +35,7 @@ var (
 	errRstStreamOnInvalidStream   = errors.New("RST_STREAM received for unknown stream")
 	errWindowUpdateOnClosedStream = errors.New("WINDOW_UPDATE received for an already closed stream")
 	errSessionAlreadyClosed       = errors.New("cannot close session; it was already closed before")
+	errMigrationNotAllowed        = errors.New("only clients can migrate the connection")
 )
 
 var (
@@ -49,18 +53,36 @@ type closeError struct {
 	remote bool
 }
 
+// sessionContext is the context returned by Session.Context.
+// Once it is cancelled, Err returns the *qerr.QuicError that closed the session.
+type sessionContext struct {
+	context.Context
+	// closeErr is set before the context is cancelled
+	closeErr *qerr.QuicError
+}
+
+func (c *sessionContext) Err() error {
+	if c.Context.Err() == nil {
+		return nil
+	}
+	return c.closeErr
+}
+
 // A Session is a QUIC session
 type session struct {
 	connectionID protocol.ConnectionID
 	perspective  protocol.Perspective
 	version      protocol.VersionNumber
 	config       *Config
+	logger       *utils.Logger
 
 	conn connection
 
 	streamsMap *streamsMap
 
 	rttStats *congestion.RTTStats
+	// sendAlgorithm is the congestion control algorithm. If nil, Cubic is used.
+	sendAlgorithm congestion.SendAlgorithm
 
 	sentPacketHandler     ackhandler.SentPacketHandler
 	receivedPacketHandler ackhandler.ReceivedPacketHandler
@@ -75,6 +97,8 @@ type session struct {
 
 	receivedPackets  chan *receivedPacket
 	sendingScheduled chan struct{}
+	// migrated receives a value when the client migrated to a new packet conn
+	migrated chan struct{}
 	// closeChan is used to notify the run loop that it should terminate.
 	closeChan chan closeError
 	runClosed chan struct{}
@@ -92,9 +116,16 @@ type session struct {
 	// will be closed as soon as the handshake completes, and receive any error that might occur until then
 	// it is used to block WaitUntilHandshakeComplete()
 	handshakeCompleteChan chan error
+	// handshakeCompleteNotify is closed as soon as the handshake completes successfully
+	// it is returned by HandshakeComplete()
+	handshakeCompleteNotify chan struct{}
+
+	// ctx is cancelled as soon as the run loop exits
+	ctx       *sessionContext
+	ctxCancel context.CancelFunc
 	// handshakeChan receives handshake events and is closed as soon the handshake completes
 	// the receiving end of this channel is passed to the creator of the session
-	// it receives at most 3 handshake events: 2 when the encryption level changes, and one error
+	// it receives at most 4 handshake events: 3 when the encryption level changes (the client changes to secure twice when doing a 0-RTT handshake), and one error
 	handshakeChan chan<- handshakeEvent
 
 	nextAckScheduledTime time.Time
@@ -105,13 +136,37 @@ type session struct {
 	// Used to calculate the next packet number from the truncated wire
 	// representation, and sent back in public reset packets
 	largestRcvdPacketNumber protocol.PacketNumber
+	// Used to verify that a Public Reset rejects a packet that was actually sent
+	largestSentPacketNumber protocol.PacketNumber
 
 	sessionCreationTime     time.Time
 	lastNetworkActivityTime time.Time
 
+	statsMutex sync.Mutex
+	stats      SessionStats
+	// rttSnapshot is a copy of the rttStats, updated whenever an ACK frame is received
+	rttSnapshot RTTStats
+
+	// keepAlivePingSent is set when a PING frame was sent to keep the connection alive, and reset when a packet is received
+	keepAlivePingSent bool
+
 	timer           *time.Timer
 	currentDeadline time.Time
-	timerRead       bool
+	// pathValidator is set while the server validates a new address of the client
+	pathValidator *pathValidator
+	// amplificationLimit is set on the server, until the client's address is validated
+	amplificationLimit *amplificationLimit
+	// mtuDiscoverer is only set if path MTU discovery is enabled
+	mtuDiscoverer *mtuDiscoverer
+
+	// pacingDeadline is set when sending was stopped by the pacer
+	pacingDeadline time.Time
+	// packetsToWrite holds the packets queued for a batched write, if batched writes are enabled
+	packetsToWrite [][]byte
+	timerRead      bool
+
+	// lastLoggedCongestionWindow is the congestion window written to the structured log most recently
+	lastLoggedCongestionWindow protocol.ByteCount
 }
 
 var _ Session = &session{}
@@ -121,7 +176,7 @@ func newSession(
 	conn connection,
 	v protocol.VersionNumber,
 	connectionID protocol.ConnectionID,
-	sCfg *handshake.ServerConfig,
+	scfg *handshake.ServerConfigManager,
 	config *Config,
 ) (packetHandler, <-chan handshakeEvent, error) {
 	s := &session{
@@ -131,15 +186,23 @@ func newSession(
 		version:      v,
 		config:       config,
 
-		connectionParameters: handshake.NewConnectionParamatersManager(protocol.PerspectiveServer, v),
+		amplificationLimit: &amplificationLimit{},
+
+		connectionParameters: handshake.NewConnectionParamatersManager(
+			protocol.PerspectiveServer,
+			v,
+			protocol.ByteCount(config.MaxReceiveStreamFlowControlWindow),
+			protocol.ByteCount(config.MaxReceiveConnectionFlowControlWindow),
+			config.IdleTimeout,
+		),
 	}
 
 	s.setup()
 	cryptoStream, _ := s.GetOrOpenStream(1)
-	_, _ = s.AcceptStream() // don't expose the crypto stream
+	_, _ = s.AcceptStream(context.Background()) // don't expose the crypto stream
 	aeadChanged := make(chan protocol.EncryptionLevel, 2)
 	s.aeadChanged = aeadChanged
-	handshakeChan := make(chan handshakeEvent, 3)
+	handshakeChan := make(chan handshakeEvent, 4)
 	s.handshakeChan = handshakeChan
 	verifySourceAddr := func(clientAddr net.Addr, hstk *handshake.STK) bool {
 		if hstk == nil {
@@ -150,24 +213,36 @@ func newSession(
 			&STK{remoteAddr: hstk.RemoteAddr, sentTime: hstk.SentTime},
 		)
 	}
+	var acceptClientHello func(*handshake.ClientHelloInfo) bool
+	if config.AcceptClientHello != nil {
+		acceptClientHello = func(chi *handshake.ClientHelloInfo) bool {
+			return config.AcceptClientHello(&ClientHelloInfo{
+				RemoteAddr:      chi.RemoteAddr,
+				ServerName:      chi.ServerName,
+				SupportedProtos: chi.SupportedProtos,
+			})
+		}
+	}
 	var err error
 	s.cryptoSetup, err = newCryptoSetup(
 		connectionID,
 		conn.RemoteAddr(),
 		v,
-		sCfg,
+		scfg,
 		cryptoStream,
 		s.connectionParameters,
 		config.Versions,
 		verifySourceAddr,
+		acceptClientHello,
 		aeadChanged,
+		s.logger,
 	)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	s.packer = newPacketPacker(connectionID, s.cryptoSetup, s.connectionParameters, s.streamFramer, s.perspective, s.version)
-	s.unpacker = &packetUnpacker{aead: s.cryptoSetup, version: s.version}
+	s.packer = newPacketPacker(connectionID, s.cryptoSetup, s.connectionParameters, s.streamFramer, s.perspective, s.version, config.MaxPacketSize, config.Tracer, config.Rand, s.logger)
+	s.unpacker = &packetUnpacker{aead: s.cryptoSetup, version: s.version, tracer: config.Tracer, logger: s.logger}
 
 	return s, handshakeChan, err
 }
@@ -177,6 +252,7 @@ var newClientSession = func(
 	conn connection,
 	hostname string,
 	v protocol.VersionNumber,
+	initialVersion protocol.VersionNumber,
 	connectionID protocol.ConnectionID,
 	config *Config,
 	negotiatedVersions []protocol.VersionNumber,
@@ -188,15 +264,21 @@ var newClientSession = func(
 		version:      v,
 		config:       config,
 
-		connectionParameters: handshake.NewConnectionParamatersManager(protocol.PerspectiveClient, v),
+		connectionParameters: handshake.NewConnectionParamatersManager(
+			protocol.PerspectiveClient,
+			v,
+			protocol.ByteCount(config.MaxReceiveStreamFlowControlWindow),
+			protocol.ByteCount(config.MaxReceiveConnectionFlowControlWindow),
+			config.IdleTimeout,
+		),
 	}
 
-	s.receivedPacketHandler = ackhandler.NewReceivedPacketHandler(s.ackAlarmChanged)
+	s.receivedPacketHandler = ackhandler.NewReceivedPacketHandler(s.ackAlarmChanged, s.config.MaxAckDelay)
 	s.setup()
 
 	aeadChanged := make(chan protocol.EncryptionLevel, 2)
 	s.aeadChanged = aeadChanged
-	handshakeChan := make(chan handshakeEvent, 3)
+	handshakeChan := make(chan handshakeEvent, 4)
 	s.handshakeChan = handshakeChan
 	cryptoStream, _ := s.OpenStream()
 	var err error
@@ -204,50 +286,77 @@ var newClientSession = func(
 		hostname,
 		connectionID,
 		v,
+		initialVersion,
 		cryptoStream,
 		config.TLSConfig,
 		s.connectionParameters,
 		aeadChanged,
 		&handshake.TransportParameters{RequestConnectionIDTruncation: config.RequestConnectionIDTruncation},
 		negotiatedVersions,
+		config.TokenStore,
+		config.Disable0RTT,
+		s.logger,
 	)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	s.packer = newPacketPacker(connectionID, s.cryptoSetup, s.connectionParameters, s.streamFramer, s.perspective, s.version)
-	s.unpacker = &packetUnpacker{aead: s.cryptoSetup, version: s.version}
+	s.packer = newPacketPacker(connectionID, s.cryptoSetup, s.connectionParameters, s.streamFramer, s.perspective, s.version, config.MaxPacketSize, config.Tracer, config.Rand, s.logger)
+	s.unpacker = &packetUnpacker{aead: s.cryptoSetup, version: s.version, tracer: config.Tracer, logger: s.logger}
 
 	return s, handshakeChan, err
 }
 
 // setup is called from newSession and newClientSession and initializes values that are independent of the perspective
 func (s *session) setup() {
+	logger := s.config.Logger
+	if logger == nil {
+		logger = utils.DefaultLogger
+	}
+	s.logger = logger.WithPrefix(fmt.Sprintf("[%x] ", s.connectionID))
 	s.rttStats = &congestion.RTTStats{}
 	flowControlManager := flowcontrol.NewFlowControlManager(s.connectionParameters, s.rttStats)
 
-	sentPacketHandler := ackhandler.NewSentPacketHandler(s.rttStats)
+	if s.sendAlgorithm == nil {
+		s.sendAlgorithm = congestion.NewCubicSender(
+			congestion.DefaultClock{},
+			s.rttStats,
+			false, /* don't use reno since chromium doesn't (why?) */
+			s.config.InitialCongestionWindow,
+			protocol.DefaultMaxCongestionWindow,
+			s.config.MinCongestionWindow,
+		)
+	}
+	sentPacketHandler := ackhandler.NewSentPacketHandler(s.rttStats, s.sendAlgorithm, s.config.EnablePacing)
 
 	now := time.Now()
 
 	s.sentPacketHandler = sentPacketHandler
 	s.flowControlManager = flowControlManager
-	s.receivedPacketHandler = ackhandler.NewReceivedPacketHandler(s.ackAlarmChanged)
+	s.receivedPacketHandler = ackhandler.NewReceivedPacketHandler(s.ackAlarmChanged, s.config.MaxAckDelay)
 
 	s.receivedPackets = make(chan *receivedPacket, protocol.MaxSessionUnprocessedPackets)
 	s.closeChan = make(chan closeError, 1)
 	s.sendingScheduled = make(chan struct{}, 1)
+	s.migrated = make(chan struct{}, 1)
 	s.undecryptablePackets = make([]*receivedPacket, 0, protocol.MaxUndecryptablePackets)
 	s.aeadChanged = make(chan protocol.EncryptionLevel, 2)
 	s.runClosed = make(chan struct{})
 	s.handshakeCompleteChan = make(chan error, 1)
+	s.handshakeCompleteNotify = make(chan struct{})
+	s.ctx = &sessionContext{}
+	s.ctx.Context, s.ctxCancel = context.WithCancel(context.Background())
+
+	if s.config.EnablePathMTUDiscovery {
+		s.mtuDiscoverer = newMTUDiscoverer(s.config.MaxPacketSize, protocol.MaxReceivePacketSize)
+	}
 
 	s.timer = time.NewTimer(0)
 	s.lastNetworkActivityTime = now
 	s.sessionCreationTime = now
 
-	s.streamsMap = newStreamsMap(s.newStream, s.perspective, s.connectionParameters)
-	s.streamFramer = newStreamFramer(s.streamsMap, s.flowControlManager)
+	s.streamsMap = newStreamsMap(s.newStream, s.refuseStream, s.perspective, s.connectionParameters, uint32(s.config.MaxIncomingStreams))
+	s.streamFramer = newStreamFramer(s.streamsMap, s.flowControlManager, s.config.StreamScheduling)
 }
 
 // run the session main loop
@@ -259,6 +368,14 @@ func (s *session) run() error {
 		}
 	}()
 
+	if utils.StructuredLogging() {
+		s.logEvent("connection_started", map[string]interface{}{
+			"perspective": s.perspective.String(),
+			"version":     s.version,
+			"remote_addr": s.conn.RemoteAddr().String(),
+		})
+	}
+
 	var closeErr closeError
 	aeadChanged := s.aeadChanged
 
@@ -283,6 +400,10 @@ runLoop:
 		case <-s.sendingScheduled:
 			// We do all the interesting stuff after the switch statement, so
 			// nothing to see here.
+		case <-s.migrated:
+			// send a PING on the new path right away, so that the server learns the new address
+			s.packer.QueueControlFrameForNextPacket(&frames.PingFrame{})
+			s.restartMTUDiscovery()
 		case p := <-s.receivedPackets:
 			err := s.handlePacketImpl(p)
 			if err != nil {
@@ -299,12 +420,17 @@ runLoop:
 		case l, ok := <-aeadChanged:
 			if !ok { // the aeadChanged chan was closed. This means that the handshake is completed.
 				s.handshakeComplete = true
+				s.amplificationLimit = nil
+				s.sentPacketHandler.SetHandshakeComplete()
 				aeadChanged = nil // prevent this case from ever being selected again
 				close(s.handshakeChan)
 				close(s.handshakeCompleteChan)
+				close(s.handshakeCompleteNotify)
 			} else {
 				if l == protocol.EncryptionForwardSecure {
 					s.packer.SetForwardSecure()
+					// the server only derives the forward-secure keys after receiving a full CHLO, which requires a valid source address token
+					s.amplificationLimit = nil
 				}
 				s.tryDecryptingQueuedPackets()
 				s.handshakeChan <- handshakeEvent{encLevel: l}
@@ -312,23 +438,35 @@ runLoop:
 		}
 
 		now := time.Now()
+		if s.config.KeepAlive && s.handshakeComplete && !s.keepAlivePingSent && now.Sub(s.lastNetworkActivityTime) >= s.idleTimeout()/2 {
+			// send a PING frame, since there was no activity in the session for a while
+			s.packer.QueueControlFrameForNextPacket(&frames.PingFrame{})
+			s.keepAlivePingSent = true
+		}
 		if s.sentPacketHandler.GetAlarmTimeout().Before(now) {
 			// This could cause packets to be retransmitted, so check it before trying
 			// to send packets.
-			s.sentPacketHandler.OnAlarm()
+			if err := s.sentPacketHandler.OnAlarm(); err != nil {
+				s.close(err)
+			}
+			s.maybeLogCongestionWindow()
+			s.updateCongestionStats()
 		}
 
 		if err := s.sendPacket(); err != nil {
 			s.close(err)
 		}
+		if err := s.maybeSendMTUProbe(now); err != nil {
+			s.close(err)
+		}
 		if !s.receivedTooManyUndecrytablePacketsTime.IsZero() && s.receivedTooManyUndecrytablePacketsTime.Add(protocol.PublicResetTimeout).Before(now) && len(s.undecryptablePackets) != 0 {
 			s.close(qerr.Error(qerr.DecryptionFailure, "too many undecryptable packets received"))
 		}
 		if now.Sub(s.lastNetworkActivityTime) >= s.idleTimeout() {
 			s.close(qerr.Error(qerr.NetworkIdleTimeout, "No recent network activity."))
 		}
-		if !s.handshakeComplete && now.Sub(s.sessionCreationTime) >= protocol.MaxTimeForCryptoHandshake {
-			s.close(qerr.Error(qerr.NetworkIdleTimeout, "Crypto handshake did not complete in time."))
+		if !s.handshakeComplete && now.Sub(s.sessionCreationTime) >= s.config.HandshakeTimeout {
+			s.close(qerr.Error(qerr.HandshakeTimeout, "Crypto handshake did not complete in time."))
 		}
 		s.garbageCollectStreams()
 	}
@@ -340,12 +478,19 @@ runLoop:
 		s.handshakeChan <- handshakeEvent{err: closeErr.err}
 	}
 	s.handleCloseError(closeErr)
+	s.ctx.closeErr = qerr.ToQuicError(closeErr.err)
+	s.ctxCancel()
 	close(s.runClosed)
 	return closeErr.err
 }
 
 func (s *session) maybeResetTimer() {
-	nextDeadline := s.lastNetworkActivityTime.Add(s.idleTimeout())
+	var nextDeadline time.Time
+	if s.config.KeepAlive && s.handshakeComplete && !s.keepAlivePingSent {
+		nextDeadline = s.lastNetworkActivityTime.Add(s.idleTimeout() / 2)
+	} else {
+		nextDeadline = s.lastNetworkActivityTime.Add(s.idleTimeout())
+	}
 
 	if !s.nextAckScheduledTime.IsZero() {
 		nextDeadline = utils.MinTime(nextDeadline, s.nextAckScheduledTime)
@@ -354,12 +499,20 @@ func (s *session) maybeResetTimer() {
 		nextDeadline = utils.MinTime(nextDeadline, lossTime)
 	}
 	if !s.handshakeComplete {
-		handshakeDeadline := s.sessionCreationTime.Add(protocol.MaxTimeForCryptoHandshake)
+		handshakeDeadline := s.sessionCreationTime.Add(s.config.HandshakeTimeout)
 		nextDeadline = utils.MinTime(nextDeadline, handshakeDeadline)
 	}
 	if !s.receivedTooManyUndecrytablePacketsTime.IsZero() {
 		nextDeadline = utils.MinTime(nextDeadline, s.receivedTooManyUndecrytablePacketsTime.Add(protocol.PublicResetTimeout))
 	}
+	if !s.pacingDeadline.IsZero() {
+		nextDeadline = utils.MinTime(nextDeadline, s.pacingDeadline)
+	}
+	if s.mtuDiscoverer != nil {
+		if probeDeadline := s.mtuDiscoverer.ProbeDeadline(s.mtuProbeTimeout()); !probeDeadline.IsZero() {
+			nextDeadline = utils.MinTime(nextDeadline, probeDeadline)
+		}
+	}
 
 	if nextDeadline.Equal(s.currentDeadline) {
 		// No need to reset the timer
@@ -381,7 +534,8 @@ func (s *session) idleTimeout() time.Duration {
 	if s.handshakeComplete {
 		return s.connectionParameters.GetIdleConnectionStateLifetime()
 	}
-	return protocol.InitialIdleTimeout
+	// use a shorter timeout until the handshake completes, but never more than the configured idle timeout
+	return utils.MinDuration(protocol.InitialIdleTimeout, s.config.IdleTimeout)
 }
 
 func (s *session) handlePacketImpl(p *receivedPacket) error {
@@ -397,7 +551,12 @@ func (s *session) handlePacketImpl(p *receivedPacket) error {
 		p.rcvTime = time.Now()
 	}
 
+	if p.publicHeader.ResetFlag {
+		return s.handlePublicReset(p)
+	}
+
 	s.lastNetworkActivityTime = p.rcvTime
+	s.keepAlivePingSent = false
 	hdr := p.publicHeader
 	data := p.data
 
@@ -409,11 +568,11 @@ func (s *session) handlePacketImpl(p *receivedPacket) error {
 	)
 
 	packet, err := s.unpacker.Unpack(hdr.Raw, hdr, data)
-	if utils.Debug() {
+	if s.logger.Debug() {
 		if err != nil {
-			utils.Debugf("<- Reading packet 0x%x (%d bytes) for connection %x", hdr.PacketNumber, len(data)+len(hdr.Raw), hdr.ConnectionID)
+			s.logger.Debugf("<- Reading packet 0x%x (%d bytes)", hdr.PacketNumber, len(data)+len(hdr.Raw))
 		} else {
-			utils.Debugf("<- Reading packet 0x%x (%d bytes) for connection %x, %s", hdr.PacketNumber, len(data)+len(hdr.Raw), hdr.ConnectionID, packet.encryptionLevel)
+			s.logger.Debugf("<- Reading packet 0x%x (%d bytes), %s", hdr.PacketNumber, len(data)+len(hdr.Raw), packet.encryptionLevel)
 		}
 	}
 	// if the decryption failed, this might be a packet sent by an attacker
@@ -421,13 +580,25 @@ func (s *session) handlePacketImpl(p *receivedPacket) error {
 	if quicErr, ok := err.(*qerr.QuicError); ok && quicErr.ErrorCode == qerr.DecryptionFailure {
 		return err
 	}
-	if s.perspective == protocol.PerspectiveServer {
-		// update the remote address, even if unpacking failed for any other reason than a decryption error
-		s.conn.SetCurrentRemoteAddr(p.remoteAddr)
-	}
 	if err != nil {
 		return err
 	}
+	if utils.StructuredLogging() {
+		s.logEvent("packet_received", map[string]interface{}{
+			"packet_number":    hdr.PacketNumber,
+			"size":             len(hdr.Raw) + len(data),
+			"encryption_level": packet.encryptionLevel.String(),
+			"frames":           frameTypes(packet.frames),
+		})
+	}
+
+	s.statsMutex.Lock()
+	s.stats.PacketsReceived++
+	s.stats.BytesReceived += protocol.ByteCount(len(hdr.Raw) + len(data))
+	s.statsMutex.Unlock()
+	if s.amplificationLimit != nil {
+		s.amplificationLimit.ReceivedBytes(protocol.ByteCount(len(hdr.Raw) + len(data)))
+	}
 
 	s.lastRcvdPacketNumber = hdr.PacketNumber
 	// Only do this after decrypting, so we are sure the packet is not attacker-controlled
@@ -436,12 +607,12 @@ func (s *session) handlePacketImpl(p *receivedPacket) error {
 	err = s.receivedPacketHandler.ReceivedPacket(hdr.PacketNumber, packet.IsRetransmittable())
 	// ignore duplicate packets
 	if err == ackhandler.ErrDuplicatePacket {
-		utils.Infof("Ignoring packet 0x%x due to ErrDuplicatePacket", hdr.PacketNumber)
+		s.logger.Infof("Ignoring packet 0x%x due to ErrDuplicatePacket", hdr.PacketNumber)
 		return nil
 	}
 	// ignore packets with packet numbers smaller than the LeastUnacked of a StopWaiting
 	if err == ackhandler.ErrPacketSmallerThanLastStopWaiting {
-		utils.Infof("Ignoring packet 0x%x due to ErrPacketSmallerThanLastStopWaiting", hdr.PacketNumber)
+		s.logger.Infof("Ignoring packet 0x%x due to ErrPacketSmallerThanLastStopWaiting", hdr.PacketNumber)
 		return nil
 	}
 
@@ -449,13 +620,119 @@ func (s *session) handlePacketImpl(p *receivedPacket) error {
 		return err
 	}
 
+	if s.perspective == protocol.PerspectiveServer && p.remoteAddr != nil && p.remoteAddr.String() != s.conn.RemoteAddr().String() {
+		if err := s.handlePacketFromNewAddr(p.remoteAddr, protocol.ByteCount(len(hdr.Raw)+len(data)), packet.frames); err != nil {
+			return err
+		}
+	}
+
 	return s.handleFrames(packet.frames)
 }
 
+// handlePacketFromNewAddr handles an authenticated packet that was received from an address other than the current remote address
+// the server only switches to the new address once it is validated, see pathValidator
+func (s *session) handlePacketFromNewAddr(addr net.Addr, size protocol.ByteCount, fs []frames.Frame) error {
+	if s.pathValidator == nil || s.pathValidator.addr.String() != addr.String() {
+		s.logger.Infof("Received a packet from a new address: %s. Validating it.", addr)
+		s.pathValidator = newPathValidator(addr)
+	}
+	if s.pathValidator.ReceivedPacket(size, fs) {
+		s.logger.Infof("Validated the new address %s.", addr)
+		s.conn.SetCurrentRemoteAddr(addr)
+		s.pathValidator = nil
+		s.restartMTUDiscovery()
+		return nil
+	}
+	return s.maybeSendPathProbe()
+}
+
+func (s *session) maybeSendPathProbe() error {
+	now := time.Now()
+	interval := utils.MaxDuration(2*s.rttStats.SmoothedRTT(), protocol.MinPathProbeInterval)
+	if !s.pathValidator.ShouldSendProbe(now, interval) {
+		return nil
+	}
+	// check the amplification limit before packing, so that no packet number is used up if the probe can't be sent
+	if !s.pathValidator.CanSend(maxPathProbeSize) {
+		return nil
+	}
+	packet, err := s.packer.PackPing(s.sentPacketHandler.GetLeastUnacked())
+	if err != nil {
+		return err
+	}
+	size := protocol.ByteCount(len(packet.raw))
+	if err := s.registerSentPacket(packet); err != nil {
+		return err
+	}
+	s.pathValidator.SentProbe(packet.number, size, now)
+	err = s.conn.WriteTo(packet.raw, s.pathValidator.addr)
+	putPacketBuffer(packet.raw)
+	return err
+}
+
+// maybeSendMTUProbe sends an MTU probe, if path MTU discovery is enabled and a probe is due
+// probes are only sent after the handshake completed, and not while a new address of the client is being validated
+func (s *session) maybeSendMTUProbe(now time.Time) error {
+	if s.mtuDiscoverer == nil || !s.handshakeComplete || s.pathValidator != nil {
+		return nil
+	}
+	s.mtuDiscoverer.MaybeDeclareLost(now, s.mtuProbeTimeout())
+	if !s.mtuDiscoverer.ShouldSendProbe() {
+		return nil
+	}
+	size := s.mtuDiscoverer.NextProbeSize()
+	packet, err := s.packer.PackMTUProbe(size, s.sentPacketHandler.GetLeastUnacked())
+	if err != nil {
+		return err
+	}
+	if err := s.registerSentPacket(packet); err != nil {
+		return err
+	}
+	s.mtuDiscoverer.SentProbe(packet.number, size, now)
+	if err := s.conn.Write(packet.raw); err != nil {
+		// the probe might be too large for the local interface
+		// don't close the session, the probe will be declared lost
+		s.logger.Debugf("Error sending MTU probe of %d bytes: %s", size, err.Error())
+	}
+	putPacketBuffer(packet.raw)
+	return nil
+}
+
+// mtuProbeTimeout is the time after which an MTU probe is declared lost
+func (s *session) mtuProbeTimeout() time.Duration {
+	return utils.MaxDuration(3*s.rttStats.SmoothedRTT(), protocol.MinMTUProbeTimeout)
+}
+
+// restartMTUDiscovery is called when the path changed, since the new path might not support the packet size found for the old path
+func (s *session) restartMTUDiscovery() {
+	if s.mtuDiscoverer == nil {
+		return
+	}
+	s.mtuDiscoverer.Reset()
+	s.packer.SetMaxPacketSize(s.mtuDiscoverer.CurrentSize())
+}
+
+func (s *session) handlePublicReset(p *receivedPacket) error {
+	pr, err := parsePublicReset(bytes.NewReader(p.data))
+	if err != nil {
+		s.logger.Infof("Received a Public Reset. An error occurred parsing the packet: %s", err.Error())
+		return nil
+	}
+	// the Public Reset has to reject a packet that we actually sent
+	// otherwise this might be an attacker trying to inject a Public Reset to kill the connection
+	if pr.rejectedPacketNumber == 0 || pr.rejectedPacketNumber > s.largestSentPacketNumber {
+		s.logger.Infof("Received a Public Reset, rejecting packet number 0x%x, which was never sent. Ignoring.", pr.rejectedPacketNumber)
+		return nil
+	}
+	s.logger.Infof("Received a Public Reset, rejected packet number: 0x%x.", pr.rejectedPacketNumber)
+	s.registerClose(qerr.Error(qerr.PublicReset, fmt.Sprintf("Received a Public Reset for packet number 0x%x", pr.rejectedPacketNumber)), true)
+	return nil
+}
+
 func (s *session) handleFrames(fs []frames.Frame) error {
 	for _, ff := range fs {
 		var err error
-		frames.LogFrame(ff, false)
+		frames.LogFrame(s.logger, ff, false)
 		switch frame := ff.(type) {
 		case *frames.StreamFrame:
 			err = s.handleStreamFrame(frame)
@@ -483,7 +760,7 @@ func (s *session) handleFrames(fs []frames.Frame) error {
 				// Can happen e.g. when packets thought missing arrive late
 			case errRstStreamOnInvalidStream:
 				// Can happen when RST_STREAMs arrive early or late (?)
-				utils.Errorf("Ignoring error in session: %s", err.Error())
+				s.logger.Errorf("Ignoring error in session: %s", err.Error())
 			case errWindowUpdateOnClosedStream:
 				// Can happen when we already sent the last StreamFrame with the FinBit, but the client already sent a WindowUpdate for this Stream
 			default:
@@ -512,6 +789,7 @@ func (s *session) handleStreamFrame(frame *frames.StreamFrame) error {
 	if str == nil {
 		// Stream is closed and already garbage collected
 		// ignore this StreamFrame
+		frame.PutBack()
 		return nil
 	}
 	return str.AddStreamFrame(frame)
@@ -540,12 +818,37 @@ func (s *session) handleRstStreamFrame(frame *frames.RstStreamFrame) error {
 		return errRstStreamOnInvalidStream
 	}
 
-	str.RegisterRemoteError(fmt.Errorf("RST_STREAM received with code %d", frame.ErrorCode))
-	return s.flowControlManager.ResetStream(frame.StreamID, frame.ByteOffset)
+	str.RegisterRemoteError(&StreamResetError{ErrorCode: qerr.ErrorCode(frame.ErrorCode)})
+	if err := s.flowControlManager.ResetStream(frame.StreamID, frame.ByteOffset); err != nil {
+		return err
+	}
+	// if the stream was also reset locally, the data up to the final offset will never be read
+	if str.resetLocally.Get() {
+		return s.flowControlManager.DiscardUnreadData(frame.StreamID)
+	}
+	return nil
 }
 
 func (s *session) handleAckFrame(frame *frames.AckFrame) error {
-	return s.sentPacketHandler.ReceivedAck(frame, s.lastRcvdPacketNumber, s.lastNetworkActivityTime)
+	if err := s.sentPacketHandler.ReceivedAck(frame, s.lastRcvdPacketNumber, s.lastNetworkActivityTime); err != nil {
+		return err
+	}
+	s.maybeLogCongestionWindow()
+	s.updateCongestionStats()
+	if s.mtuDiscoverer != nil && s.mtuDiscoverer.ReceivedAck(frame) {
+		s.logger.Infof("Increasing the maximum packet size to %d bytes", s.mtuDiscoverer.CurrentSize())
+		s.packer.SetMaxPacketSize(s.mtuDiscoverer.CurrentSize())
+	}
+	// the rttStats are updated by the run loop, GetRTTStats may be called from any goroutine
+	s.statsMutex.Lock()
+	s.rttSnapshot = RTTStats{
+		SmoothedRTT:   s.rttStats.SmoothedRTT(),
+		LatestRTT:     s.rttStats.LatestRTT(),
+		MinRTT:        s.rttStats.MinRTT(),
+		MeanDeviation: s.rttStats.MeanDeviation(),
+	}
+	s.statsMutex.Unlock()
+	return nil
 }
 
 func (s *session) registerClose(e error, remoteClose bool) error {
@@ -580,6 +883,12 @@ func (s *session) Close(e error) error {
 	return err
 }
 
+// CloseWithError closes the connection, sending a CONNECTION_CLOSE with the given error code and reason phrase.
+// It waits until the run loop has stopped before returning
+func (s *session) CloseWithError(code qerr.ErrorCode, reason string) error {
+	return s.Close(qerr.Error(code, reason))
+}
+
 // close the connection. Use this when called from the run loop
 func (s *session) close(e error) error {
 	err := s.registerClose(e, false)
@@ -597,9 +906,16 @@ func (s *session) handleCloseError(closeErr closeError) error {
 	}
 	// Don't log 'normal' reasons
 	if quicErr.ErrorCode == qerr.PeerGoingAway || quicErr.ErrorCode == qerr.NetworkIdleTimeout {
-		utils.Infof("Closing connection %x", s.connectionID)
+		s.logger.Infof("Closing connection")
 	} else {
-		utils.Errorf("Closing session with error: %s", closeErr.err.Error())
+		s.logger.Errorf("Closing session with error: %s", closeErr.err.Error())
+	}
+	if utils.StructuredLogging() {
+		s.logEvent("connection_closed", map[string]interface{}{
+			"error_code": quicErr.ErrorCode,
+			"error":      quicErr.ErrorMessage,
+			"remote":     closeErr.remote,
+		})
 	}
 
 	if closeErr.err == errCloseSessionForNewVersion {
@@ -628,11 +944,31 @@ func (s *session) closeStreamsWithError(err error) {
 }
 
 func (s *session) sendPacket() error {
+	err := s.packAndSendPackets()
+	// the packets queued for a batched write have already been registered as sent
+	if ferr := s.flushWrites(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+func (s *session) packAndSendPackets() error {
+	s.pacingDeadline = time.Time{}
 	// Repeatedly try sending until we don't have any more data, or run out of the congestion window
 	for {
-		if !s.sentPacketHandler.SendingAllowed() {
+		// until the client's address is validated, data that would exceed the amplification limit is deferred
+		if s.amplificationLimited() {
+			s.deferAckForAmplificationLimit()
 			return nil
 		}
+		if !s.sentPacketHandler.SendingAllowed() {
+			return s.maybeSendAckOnlyPacket()
+		}
+		// if pacing is enabled, the timer wakes up the run loop when the next packet may be sent
+		if t := s.sentPacketHandler.TimeUntilSend(); t.After(time.Now()) {
+			s.pacingDeadline = t
+			return s.maybeSendAckOnlyPacket()
+		}
 
 		var controlFrames []frames.Frame
 
@@ -645,14 +981,27 @@ func (s *session) sendPacket() error {
 
 		// check for retransmissions first
 		for {
+			if s.amplificationLimited() {
+				s.deferAckForAmplificationLimit()
+				return nil
+			}
 			retransmitPacket := s.sentPacketHandler.DequeuePacketForRetransmission()
 			if retransmitPacket == nil {
 				break
 			}
-			utils.Debugf("\tDequeueing retransmission for packet 0x%x", retransmitPacket.PacketNumber)
+			s.logger.Debugf("\tDequeueing retransmission for packet 0x%x", retransmitPacket.PacketNumber)
+			if utils.StructuredLogging() {
+				s.logEvent("packet_lost", map[string]interface{}{
+					"packet_number":    retransmitPacket.PacketNumber,
+					"encryption_level": retransmitPacket.EncryptionLevel.String(),
+				})
+			}
+			s.statsMutex.Lock()
+			s.stats.PacketsRetransmitted++
+			s.statsMutex.Unlock()
 
 			if retransmitPacket.EncryptionLevel != protocol.EncryptionForwardSecure {
-				utils.Debugf("\tDequeueing handshake retransmission for packet 0x%x", retransmitPacket.PacketNumber)
+				s.logger.Debugf("\tDequeueing handshake retransmission for packet 0x%x", retransmitPacket.PacketNumber)
 				stopWaitingFrame := s.sentPacketHandler.GetStopWaitingFrame(true)
 				var packet *packedPacket
 				packet, err := s.packer.RetransmitNonForwardSecurePacket(stopWaitingFrame, retransmitPacket)
@@ -717,22 +1066,109 @@ func (s *session) sendPacket() error {
 	}
 }
 
+// maybeSendAckOnlyPacket sends an ACK, if one is due, when no other packets may be sent right now.
+// ACKs are not subject to congestion control: the congestion window only opens up again when ACKs are exchanged.
+func (s *session) maybeSendAckOnlyPacket() error {
+	if !s.sentPacketHandler.AckOnlyPacketAllowed() {
+		// the ACK is sent with the next packet, once the peer acknowledged some of the outstanding packets
+		s.nextAckScheduledTime = time.Time{}
+		return nil
+	}
+	ack := s.receivedPacketHandler.GetAckFrame()
+	if ack == nil {
+		return nil
+	}
+	packet, err := s.packer.PackAckPacket(s.sentPacketHandler.GetStopWaitingFrame(false), ack, s.sentPacketHandler.GetLeastUnacked())
+	if err != nil {
+		return err
+	}
+	if err := s.sendPackedPacket(packet); err != nil {
+		return err
+	}
+	s.nextAckScheduledTime = time.Time{}
+	return nil
+}
+
+// amplificationLimited determines if sending another packet would exceed the amplification limit for a client address that is not validated yet
+func (s *session) amplificationLimited() bool {
+	return s.amplificationLimit != nil && !s.amplificationLimit.CanSend(s.config.MaxPacketSize)
+}
+
+// deferAckForAmplificationLimit is called when an amplification limited session stops sending.
+// A due ACK is sent with the next packet, once the client sent more data.
+// Otherwise the ACK alarm would stay in the past, and the timer wouldn't be reset for the other deadlines.
+func (s *session) deferAckForAmplificationLimit() {
+	s.nextAckScheduledTime = time.Time{}
+}
+
 func (s *session) sendPackedPacket(packet *packedPacket) error {
+	// Packets are registered when they are queued for a batched write, so that congestion control and pacing account for them.
+	// If the batched write fails, the error closes the session, just as for an unbatched write.
+	if err := s.registerSentPacket(packet); err != nil {
+		return err
+	}
+	if s.amplificationLimit != nil {
+		s.amplificationLimit.SentBytes(protocol.ByteCount(len(packet.raw)))
+	}
+	if s.config.BatchWrites {
+		s.packetsToWrite = append(s.packetsToWrite, packet.raw)
+		if len(s.packetsToWrite) >= protocol.MaxBatchWritePackets {
+			return s.flushWrites()
+		}
+		return nil
+	}
+	err := s.conn.Write(packet.raw)
+	putPacketBuffer(packet.raw)
+	return err
+}
+
+// flushWrites sends the packets queued for a batched write
+func (s *session) flushWrites() error {
+	if len(s.packetsToWrite) == 0 {
+		return nil
+	}
+	err := s.conn.WriteBatch(s.packetsToWrite)
+	if err != nil {
+		s.logger.Debugf("Batched write of %d packets failed: %s", len(s.packetsToWrite), err)
+	}
+	for i, p := range s.packetsToWrite {
+		putPacketBuffer(p)
+		s.packetsToWrite[i] = nil
+	}
+	s.packetsToWrite = s.packetsToWrite[:0]
+	return err
+}
+
+func (s *session) registerSentPacket(packet *packedPacket) error {
 	err := s.sentPacketHandler.SentPacket(&ackhandler.Packet{
 		PacketNumber:    packet.number,
 		Frames:          packet.frames,
 		Length:          protocol.ByteCount(len(packet.raw)),
 		EncryptionLevel: packet.encryptionLevel,
+		IsMTUProbe:      packet.isMTUProbe,
 	})
 	if err != nil {
 		return err
 	}
 
 	s.logPacket(packet)
+	if utils.StructuredLogging() {
+		s.logEvent("packet_sent", map[string]interface{}{
+			"packet_number":    packet.number,
+			"size":             len(packet.raw),
+			"encryption_level": packet.encryptionLevel.String(),
+			"frames":           frameTypes(packet.frames),
+			"mtu_probe":        packet.isMTUProbe,
+		})
+	}
+	s.largestSentPacketNumber = utils.MaxPacketNumber(s.largestSentPacketNumber, packet.number)
 
-	err = s.conn.Write(packet.raw)
-	putPacketBuffer(packet.raw)
-	return err
+	s.statsMutex.Lock()
+	s.stats.PacketsSent++
+	s.stats.BytesSent += protocol.ByteCount(len(packet.raw))
+	s.statsMutex.Unlock()
+	s.updateCongestionStats()
+	return nil
 }
 
 func (s *session) sendConnectionClose(quicErr *qerr.QuicError) error {
@@ -748,18 +1184,51 @@ func (s *session) sendConnectionClose(quicErr *qerr.QuicError) error {
 }
 
 func (s *session) logPacket(packet *packedPacket) {
-	if !utils.Debug() {
+	if !s.logger.Debug() {
 		// We don't need to allocate the slices for calling the format functions
 		return
 	}
-	if utils.Debug() {
-		utils.Debugf("-> Sending packet 0x%x (%d bytes), %s", packet.number, len(packet.raw), packet.encryptionLevel)
+	if s.logger.Debug() {
+		s.logger.Debugf("-> Sending packet 0x%x (%d bytes), %s", packet.number, len(packet.raw), packet.encryptionLevel)
 		for _, frame := range packet.frames {
-			frames.LogFrame(frame, true)
+			frames.LogFrame(s.logger, frame, true)
 		}
 	}
 }
 
+// logEvent writes an event for this session to the structured log
+// Callers should check utils.StructuredLogging() before collecting the fields.
+func (s *session) logEvent(name string, fields map[string]interface{}) {
+	fields["connection_id"] = fmt.Sprintf("%x", s.connectionID)
+	utils.LogEvent(name, fields)
+}
+
+// updateCongestionStats copies the state of the congestion controller to the stats
+// it is called by the run loop, Stats may be called from any goroutine
+func (s *session) updateCongestionStats() {
+	s.statsMutex.Lock()
+	s.stats.CongestionWindow = s.sendAlgorithm.GetCongestionWindow()
+	s.stats.BytesInFlight = s.sentPacketHandler.GetBytesInFlight()
+	s.stats.InSlowStart = s.sendAlgorithm.InSlowStart()
+	s.stats.SlowStartThreshold = s.sendAlgorithm.GetSlowStartThreshold()
+	s.statsMutex.Unlock()
+}
+
+// maybeLogCongestionWindow writes the congestion window to the structured log, if it changed since it was last logged
+func (s *session) maybeLogCongestionWindow() {
+	if !utils.StructuredLogging() {
+		return
+	}
+	cwnd := s.sendAlgorithm.GetCongestionWindow()
+	if cwnd == s.lastLoggedCongestionWindow {
+		return
+	}
+	s.lastLoggedCongestionWindow = cwnd
+	s.logEvent("congestion_window_updated", map[string]interface{}{
+		"congestion_window": cwnd,
+	})
+}
+
 // GetOrOpenStream either returns an existing stream, a newly opened stream, or nil if a stream with the provided ID is already closed.
 // Newly opened streams should only originate from the client. To open a stream from the server, OpenStream should be used.
 func (s *session) GetOrOpenStream(id protocol.StreamID) (Stream, error) {
@@ -772,8 +1241,8 @@ func (s *session) GetOrOpenStream(id protocol.StreamID) (Stream, error) {
 }
 
 // AcceptStream returns the next stream openend by the peer
-func (s *session) AcceptStream() (Stream, error) {
-	return s.streamsMap.AcceptStream()
+func (s *session) AcceptStream(ctx context.Context) (Stream, error) {
+	return s.streamsMap.AcceptStream(ctx)
 }
 
 // OpenStream opens a stream
@@ -789,14 +1258,56 @@ func (s *session) WaitUntilHandshakeComplete() error {
 	return <-s.handshakeCompleteChan
 }
 
-func (s *session) queueResetStreamFrame(id protocol.StreamID, offset protocol.ByteCount) {
+func (s *session) HandshakeComplete() <-chan struct{} {
+	return s.handshakeCompleteNotify
+}
+
+func (s *session) Context() context.Context {
+	return s.ctx
+}
+
+func (s *session) GetVersion() protocol.VersionNumber {
+	return s.version
+}
+
+func (s *session) ConnectionState() ConnectionState {
+	var handshakeComplete bool
+	select {
+	case <-s.handshakeCompleteNotify:
+		handshakeComplete = true
+	default:
+	}
+	state := s.cryptoSetup.ConnectionState()
+	return ConnectionState{
+		HandshakeComplete:  handshakeComplete,
+		ServerName:         state.ServerName,
+		NegotiatedProtocol: state.NegotiatedProtocol,
+		PeerCertificates:   state.PeerCertificates,
+		VerifiedChains:     state.VerifiedChains,
+		AEAD:               state.AEAD,
+		KeyExchange:        state.KeyExchange,
+	}
+}
+
+func (s *session) queueResetStreamFrame(id protocol.StreamID, offset protocol.ByteCount, errorCode qerr.ErrorCode) {
 	s.packer.QueueControlFrameForNextPacket(&frames.RstStreamFrame{
 		StreamID:   id,
+		ErrorCode:  uint32(errorCode),
 		ByteOffset: offset,
 	})
 	s.scheduleSending()
 }
 
+// refuseStream is called by the streamsMap when the peer opens more streams than allowed by Config.MaxIncomingStreams
+func (s *session) refuseStream(id protocol.StreamID) {
+	s.logger.Infof("Refusing stream %d: too many open streams", id)
+	s.packer.QueueControlFrameForNextPacket(&frames.RstStreamFrame{
+		StreamID:  id,
+		ErrorCode: uint32(qerr.TooManyOpenStreams),
+	})
+	s.scheduleSending()
+}
+
 func (s *session) newStream(id protocol.StreamID) (*stream, error) {
 	stream, err := newStream(id, s.scheduleSending, s.queueResetStreamFrame, s.flowControlManager)
 	if err != nil {
@@ -810,14 +1321,24 @@ func (s *session) newStream(id protocol.StreamID) (*stream, error) {
 		s.flowControlManager.NewStream(id, true)
 	}
 
+	if id != 1 {
+		s.statsMutex.Lock()
+		s.stats.StreamsOpened++
+		s.statsMutex.Unlock()
+	}
+
 	return stream, nil
 }
 
 // garbageCollectStreams goes through all streams and removes EOF'ed streams
 // from the streams map.
+// Streams reset by the peer before being accepted are kept, so that the application can still read their data and the error.
 func (s *session) garbageCollectStreams() {
 	s.streamsMap.Iterate(func(str *stream) (bool, error) {
 		id := str.StreamID()
+		if str.resetRemotely.Get() && !s.streamsMap.wasAccepted(id) {
+			return true, nil
+		}
 		if str.finished() {
 			err := s.streamsMap.RemoveStream(id)
 			if err != nil {
@@ -830,7 +1351,7 @@ func (s *session) garbageCollectStreams() {
 }
 
 func (s *session) sendPublicReset(rejectedPacketNumber protocol.PacketNumber) error {
-	utils.Infof("Sending public reset for connection %x, packet number %d", s.connectionID, rejectedPacketNumber)
+	s.logger.Infof("Sending public reset, packet number %d", rejectedPacketNumber)
 	return s.conn.Write(writePublicReset(s.connectionID, rejectedPacketNumber, 0))
 }
 
@@ -852,10 +1373,10 @@ func (s *session) tryQueueingUndecryptablePacket(p *receivedPacket) {
 			s.receivedTooManyUndecrytablePacketsTime = time.Now()
 			s.maybeResetTimer()
 		}
-		utils.Infof("Dropping undecrytable packet 0x%x (undecryptable packet queue full)", p.publicHeader.PacketNumber)
+		s.logger.Infof("Dropping undecrytable packet 0x%x (undecryptable packet queue full)", p.publicHeader.PacketNumber)
 		return
 	}
-	utils.Infof("Queueing packet 0x%x for later decryption", p.publicHeader.PacketNumber)
+	s.logger.Infof("Queueing packet 0x%x for later decryption", p.publicHeader.PacketNumber)
 	s.undecryptablePackets = append(s.undecryptablePackets, p)
 }
 
@@ -880,11 +1401,42 @@ func (s *session) ackAlarmChanged(t time.Time) {
 	s.maybeResetTimer()
 }
 
+// LocalAddr returns the local address
 func (s *session) LocalAddr() net.Addr {
 	return s.conn.LocalAddr()
 }
 
-// RemoteAddr returns the net.Addr of the client
+// Stats returns a snapshot of the statistics of this session
+func (s *session) Stats() SessionStats {
+	s.statsMutex.Lock()
+	stats := s.stats
+	s.statsMutex.Unlock()
+	stats.DontFragment = s.conn.DontFragment()
+	return stats
+}
+
+func (s *session) GetRTTStats() RTTStats {
+	s.statsMutex.Lock()
+	defer s.statsMutex.Unlock()
+	return s.rttSnapshot
+}
+
+// MigrateTo continues the session on a new packet conn, and closes the old one
+func (s *session) MigrateTo(pconn net.PacketConn) error {
+	if s.perspective == protocol.PerspectiveServer {
+		return errMigrationNotAllowed
+	}
+	s.logger.Infof("Migrating the connection to %s", pconn.LocalAddr())
+	s.conn.SetPacketConn(pconn)
+	select {
+	case s.migrated <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// RemoteAddr returns the net.Addr of the peer
+// For the server, this is the last address of the client that was validated
 func (s *session) RemoteAddr() net.Addr {
 	return s.conn.RemoteAddr()
 }
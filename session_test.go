@@ -2,8 +2,12 @@ package quic
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net"
 	"runtime/pprof"
@@ -15,18 +19,23 @@ import (
 	. "github.com/onsi/gomega"
 
 	"github.com/lucas-clemente/quic-go/ackhandler"
+	"github.com/lucas-clemente/quic-go/congestion"
 	"github.com/lucas-clemente/quic-go/crypto"
 	"github.com/lucas-clemente/quic-go/frames"
 	"github.com/lucas-clemente/quic-go/handshake"
 	"github.com/lucas-clemente/quic-go/protocol"
 	"github.com/lucas-clemente/quic-go/qerr"
 	"github.com/lucas-clemente/quic-go/testdata"
+	"github.com/lucas-clemente/quic-go/utils"
 )
 
 type mockConnection struct {
 	remoteAddr net.Addr
 	localAddr  net.Addr
 	written    [][]byte
+	writtenTo  []net.Addr // the addresses passed to WriteTo
+	batches    []int      // the number of packets passed to every call of WriteBatch
+	batchErr   error      // if set, WriteBatch fails with this error
 }
 
 func (m *mockConnection) Write(p []byte) error {
@@ -35,17 +44,36 @@ func (m *mockConnection) Write(p []byte) error {
 	m.written = append(m.written, b)
 	return nil
 }
+func (m *mockConnection) WriteBatch(packets [][]byte) error {
+	m.batches = append(m.batches, len(packets))
+	if m.batchErr != nil {
+		return m.batchErr
+	}
+	for _, p := range packets {
+		m.Write(p)
+	}
+	return nil
+}
+func (m *mockConnection) WriteTo(p []byte, addr net.Addr) error {
+	m.writtenTo = append(m.writtenTo, addr)
+	return m.Write(p)
+}
 func (m *mockConnection) Read([]byte) (int, net.Addr, error) { panic("not implemented") }
 
 func (m *mockConnection) SetCurrentRemoteAddr(addr net.Addr) {
 	m.remoteAddr = addr
 }
+func (m *mockConnection) SetPacketConn(pconn net.PacketConn) {
+	m.localAddr = pconn.LocalAddr()
+}
+func (m *mockConnection) DontFragment() bool   { return false }
 func (m *mockConnection) LocalAddr() net.Addr  { return m.localAddr }
 func (m *mockConnection) RemoteAddr() net.Addr { return m.remoteAddr }
 func (*mockConnection) Close() error           { panic("not implemented") }
 
 type mockUnpacker struct {
 	unpackErr error
+	frames    []frames.Frame
 }
 
 func (m *mockUnpacker) Unpack(publicHeaderBinary []byte, hdr *PublicHeader, data []byte) (*unpackedPacket, error) {
@@ -53,7 +81,7 @@ func (m *mockUnpacker) Unpack(publicHeaderBinary []byte, hdr *PublicHeader, data
 		return nil, m.unpackErr
 	}
 	return &unpackedPacket{
-		frames: nil,
+		frames: m.frames,
 	}, nil
 }
 
@@ -61,10 +89,17 @@ type mockSentPacketHandler struct {
 	retransmissionQueue  []*ackhandler.Packet
 	sentPackets          []*ackhandler.Packet
 	congestionLimited    bool
+	maxTrackedLimited    bool
 	requestedStopWaiting bool
+	bytesInFlight        protocol.ByteCount
+	nextPacketSendTime   time.Time
+	leastUnacked         protocol.PacketNumber
 }
 
 func (h *mockSentPacketHandler) SentPacket(packet *ackhandler.Packet) error {
+	if h.maxTrackedLimited {
+		return ackhandler.ErrTooManyTrackedSentPackets
+	}
 	h.sentPackets = append(h.sentPackets, packet)
 	return nil
 }
@@ -73,14 +108,20 @@ func (h *mockSentPacketHandler) ReceivedAck(ackFrame *frames.AckFrame, withPacke
 	return nil
 }
 
-func (h *mockSentPacketHandler) GetLeastUnacked() protocol.PacketNumber { return 1 }
-func (h *mockSentPacketHandler) GetAlarmTimeout() time.Time             { panic("not implemented") }
-func (h *mockSentPacketHandler) OnAlarm()                               { panic("not implemented") }
-func (h *mockSentPacketHandler) SendingAllowed() bool                   { return !h.congestionLimited }
+func (h *mockSentPacketHandler) GetLeastUnacked() protocol.PacketNumber { return h.leastUnacked }
+func (h *mockSentPacketHandler) GetBytesInFlight() protocol.ByteCount   { return h.bytesInFlight }
+func (h *mockSentPacketHandler) SetHandshakeComplete()                  {}
+func (h *mockSentPacketHandler) GetAlarmTimeout() time.Time             { return time.Time{} }
+func (h *mockSentPacketHandler) OnAlarm() error                         { panic("not implemented") }
+func (h *mockSentPacketHandler) SendingAllowed() bool {
+	return !(h.congestionLimited || h.maxTrackedLimited)
+}
+func (h *mockSentPacketHandler) AckOnlyPacketAllowed() bool { return !h.maxTrackedLimited }
+func (h *mockSentPacketHandler) TimeUntilSend() time.Time   { return h.nextPacketSendTime }
 
 func (h *mockSentPacketHandler) GetStopWaitingFrame(force bool) *frames.StopWaitingFrame {
 	h.requestedStopWaiting = true
-	return &frames.StopWaitingFrame{LeastUnacked: 0x1337}
+	return &frames.StopWaitingFrame{LeastUnacked: h.leastUnacked}
 }
 
 func (h *mockSentPacketHandler) DequeuePacketForRetransmission() *ackhandler.Packet {
@@ -93,7 +134,7 @@ func (h *mockSentPacketHandler) DequeuePacketForRetransmission() *ackhandler.Pac
 }
 
 func newMockSentPacketHandler() ackhandler.SentPacketHandler {
-	return &mockSentPacketHandler{}
+	return &mockSentPacketHandler{leastUnacked: 1}
 }
 
 var _ ackhandler.SentPacketHandler = &mockSentPacketHandler{}
@@ -125,7 +166,7 @@ func areSessionsRunning() bool {
 var _ = Describe("Session", func() {
 	var (
 		sess          *session
-		scfg          *handshake.ServerConfig
+		scfg          *handshake.ServerConfigManager
 		mconn         *mockConnection
 		cpm           *mockConnectionParametersManager
 		cryptoSetup   *mockCryptoSetup
@@ -141,12 +182,14 @@ var _ = Describe("Session", func() {
 			_ protocol.ConnectionID,
 			_ net.Addr,
 			_ protocol.VersionNumber,
-			_ *handshake.ServerConfig,
+			_ *handshake.ServerConfigManager,
 			_ io.ReadWriter,
 			_ handshake.ConnectionParametersManager,
 			_ []protocol.VersionNumber,
 			_ func(net.Addr, *handshake.STK) bool,
+			_ func(*handshake.ClientHelloInfo) bool,
 			aeadChangedP chan<- protocol.EncryptionLevel,
+			_ *utils.Logger,
 		) (handshake.CryptoSetup, error) {
 			aeadChanged = aeadChangedP
 			return cryptoSetup, nil
@@ -158,8 +201,9 @@ var _ = Describe("Session", func() {
 		certChain := crypto.NewCertChain(testdata.GetTLSConfig())
 		kex, err := crypto.NewCurve25519KEX()
 		Expect(err).NotTo(HaveOccurred())
-		scfg, err = handshake.NewServerConfig(kex, certChain)
+		serverConfig, err := handshake.NewServerConfig(kex, certChain)
 		Expect(err).NotTo(HaveOccurred())
+		scfg = handshake.NewServerConfigManager(serverConfig, protocol.DefaultServerConfigOverlap)
 		var pSess Session
 		pSess, handshakeChan, err = newSession(
 			mconn,
@@ -174,6 +218,9 @@ var _ = Describe("Session", func() {
 
 		cpm = &mockConnectionParametersManager{idleTime: 60 * time.Second}
 		sess.connectionParameters = cpm
+		// most tests send packets without receiving any from the client
+		Expect(sess.amplificationLimit).ToNot(BeNil())
+		sess.amplificationLimit = nil
 	})
 
 	AfterEach(func() {
@@ -181,6 +228,10 @@ var _ = Describe("Session", func() {
 		Eventually(areSessionsRunning).Should(BeFalse())
 	})
 
+	It("uses Cubic for congestion control by default", func() {
+		Expect(sess.sendAlgorithm).To(BeAssignableToTypeOf(congestion.NewCubicSender(congestion.DefaultClock{}, &congestion.RTTStats{}, false, 1, 1, 1)))
+	})
+
 	Context("source address validation", func() {
 		var (
 			stkVerify       func(net.Addr, *handshake.STK) bool
@@ -194,12 +245,14 @@ var _ = Describe("Session", func() {
 				_ protocol.ConnectionID,
 				_ net.Addr,
 				_ protocol.VersionNumber,
-				_ *handshake.ServerConfig,
+				_ *handshake.ServerConfigManager,
 				_ io.ReadWriter,
 				_ handshake.ConnectionParametersManager,
 				_ []protocol.VersionNumber,
 				stkFunc func(net.Addr, *handshake.STK) bool,
+				_ func(*handshake.ClientHelloInfo) bool,
 				_ chan<- protocol.EncryptionLevel,
+				_ *utils.Logger,
 			) (handshake.CryptoSetup, error) {
 				stkVerify = stkFunc
 				return cryptoSetup, nil
@@ -239,6 +292,59 @@ var _ = Describe("Session", func() {
 		})
 	})
 
+	Context("accepting the client hello", func() {
+		var acceptClientHello func(*handshake.ClientHelloInfo) bool
+
+		BeforeEach(func() {
+			newCryptoSetup = func(
+				_ protocol.ConnectionID,
+				_ net.Addr,
+				_ protocol.VersionNumber,
+				_ *handshake.ServerConfigManager,
+				_ io.ReadWriter,
+				_ handshake.ConnectionParametersManager,
+				_ []protocol.VersionNumber,
+				_ func(net.Addr, *handshake.STK) bool,
+				acceptCHLOFunc func(*handshake.ClientHelloInfo) bool,
+				_ chan<- protocol.EncryptionLevel,
+				_ *utils.Logger,
+			) (handshake.CryptoSetup, error) {
+				acceptClientHello = acceptCHLOFunc
+				return cryptoSetup, nil
+			}
+		})
+
+		It("doesn't set a callback if the config doesn't have one", func() {
+			_, _, err := newSession(mconn, protocol.Version35, 0, scfg, populateServerConfig(&Config{}))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(acceptClientHello).To(BeNil())
+		})
+
+		It("calls the callback with the parameters of the client hello", func() {
+			var info *ClientHelloInfo
+			conf := populateServerConfig(&Config{
+				AcceptClientHello: func(chi *ClientHelloInfo) bool {
+					info = chi
+					return false
+				},
+			})
+			_, _, err := newSession(mconn, protocol.Version35, 0, scfg, conf)
+			Expect(err).ToNot(HaveOccurred())
+			remoteAddr := &net.UDPAddr{IP: net.IPv4(192, 168, 13, 37), Port: 1000}
+			accepted := acceptClientHello(&handshake.ClientHelloInfo{
+				RemoteAddr:      remoteAddr,
+				ServerName:      "quic.clemente.io",
+				SupportedProtos: []string{"foo"},
+			})
+			Expect(accepted).To(BeFalse())
+			Expect(info).To(Equal(&ClientHelloInfo{
+				RemoteAddr:      remoteAddr,
+				ServerName:      "quic.clemente.io",
+				SupportedProtos: []string{"foo"},
+			}))
+		})
+	})
+
 	Context("when handling stream frames", func() {
 		It("makes new streams", func() {
 			sess.handleStreamFrame(&frames.StreamFrame{
@@ -284,6 +390,26 @@ var _ = Describe("Session", func() {
 			Expect(p).To(Equal([]byte{0xde, 0xca, 0xfb, 0xad}))
 		})
 
+		It("refuses streams when the peer opens too many streams", func() {
+			sess.streamsMap.maxIncomingStreams = 1
+			err := sess.handleStreamFrame(&frames.StreamFrame{
+				StreamID: 3,
+				Data:     []byte{0xde, 0xca, 0xfb, 0xad},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			err = sess.handleStreamFrame(&frames.StreamFrame{
+				StreamID: 5,
+				Data:     []byte{0xde, 0xca, 0xfb, 0xad},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sess.streamsMap.streams).ToNot(HaveKey(protocol.StreamID(5)))
+			Expect(sess.packer.controlFrames).To(HaveLen(1))
+			Expect(sess.packer.controlFrames[0]).To(Equal(&frames.RstStreamFrame{
+				StreamID:  5,
+				ErrorCode: uint32(qerr.TooManyOpenStreams),
+			}))
+		})
+
 		It("does not delete streams with Close()", func() {
 			str, err := sess.GetOrOpenStream(5)
 			Expect(err).ToNot(HaveOccurred())
@@ -344,6 +470,25 @@ var _ = Describe("Session", func() {
 			Expect(err).To(MatchError("Error accessing the flowController map."))
 		})
 
+		It("doesn't delete streams that were reset by the peer before they were accepted", func() {
+			_, err := sess.GetOrOpenStream(3)
+			Expect(err).ToNot(HaveOccurred())
+			err = sess.handleRstStreamFrame(&frames.RstStreamFrame{
+				StreamID:  3,
+				ErrorCode: 42,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			sess.garbageCollectStreams()
+			Expect(sess.streamsMap.streams).To(HaveKey(protocol.StreamID(3)))
+			str, err := sess.AcceptStream(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(str.StreamID()).To(Equal(protocol.StreamID(3)))
+			_, err = str.Read([]byte{0})
+			Expect(err).To(Equal(&StreamResetError{ErrorCode: 42}))
+			sess.garbageCollectStreams()
+			Expect(sess.streamsMap.streams).ToNot(HaveKey(protocol.StreamID(3)))
+		})
+
 		It("cancels streams with error", func() {
 			sess.garbageCollectStreams()
 			testErr := errors.New("test")
@@ -423,6 +568,7 @@ var _ = Describe("Session", func() {
 			n, err := s.Write([]byte{0})
 			Expect(n).To(BeZero())
 			Expect(err).To(MatchError("RST_STREAM received with code 42"))
+			Expect(err).To(Equal(&StreamResetError{ErrorCode: 42}))
 		})
 
 		It("doesn't close the stream for reading", func() {
@@ -519,6 +665,30 @@ var _ = Describe("Session", func() {
 			Expect(str.finished()).To(BeFalse())
 		})
 
+		It("queues a RST_STREAM with the error code of the error", func() {
+			str, err := sess.streamsMap.GetOrOpenStream(5)
+			Expect(err).ToNot(HaveOccurred())
+			str.Reset(qerr.Error(0x42, "foobar"))
+			Expect(sess.packer.controlFrames).To(HaveLen(1))
+			Expect(sess.packer.controlFrames[0]).To(Equal(&frames.RstStreamFrame{
+				StreamID:  5,
+				ErrorCode: 0x42,
+			}))
+		})
+
+		It("discards the unread data when receiving an RST_STREAM for a stream that was reset locally", func() {
+			str, err := sess.streamsMap.GetOrOpenStream(5)
+			Expect(err).ToNot(HaveOccurred())
+			str.Reset(errors.New("testErr"))
+			sess.flowControlManager = newMockFlowControlHandler()
+			err = sess.handleRstStreamFrame(&frames.RstStreamFrame{
+				StreamID:   5,
+				ByteOffset: 0x1337,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sess.flowControlManager.(*mockFlowControlHandler).discardedUnreadData).To(BeTrue())
+		})
+
 		It("doesn't queue another RST_STREAM, when it receives an RST_STREAM as a response for the first", func() {
 			testErr := errors.New("testErr")
 			str, err := sess.streamsMap.GetOrOpenStream(5)
@@ -621,6 +791,36 @@ var _ = Describe("Session", func() {
 		close(done)
 	})
 
+	It("doesn't return an error from the context before the session is closed", func() {
+		Expect(sess.Context().Err()).ToNot(HaveOccurred())
+	})
+
+	It("passes the error code and reason phrase of a CONNECTION_CLOSE to the application", func(done Done) {
+		go sess.run()
+		str, err := sess.GetOrOpenStream(5)
+		Expect(err).ToNot(HaveOccurred())
+		err = sess.handleFrames([]frames.Frame{&frames.ConnectionCloseFrame{ErrorCode: qerr.InternalError, ReasonPhrase: "foobar"}})
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(sess.Context().Done()).Should(BeClosed())
+		_, err = str.Write([]byte("foobar"))
+		Expect(err).To(BeAssignableToTypeOf(&qerr.QuicError{}))
+		Expect(err.(*qerr.QuicError).ErrorCode).To(Equal(qerr.InternalError))
+		Expect(err.(*qerr.QuicError).ErrorMessage).To(Equal("foobar"))
+		_, err = sess.AcceptStream(context.Background())
+		Expect(err).To(MatchError(qerr.Error(qerr.InternalError, "foobar")))
+		_, err = sess.OpenStream()
+		Expect(err).To(MatchError(qerr.Error(qerr.InternalError, "foobar")))
+		Expect(sess.Context().Err()).To(MatchError(qerr.Error(qerr.InternalError, "foobar")))
+		close(done)
+	})
+
+	It("cancels the context when the session is closed", func() {
+		go sess.run()
+		Expect(sess.Context().Done()).ToNot(BeClosed())
+		Expect(sess.Close(nil)).To(Succeed())
+		Expect(sess.Context().Done()).To(BeClosed())
+	})
+
 	Context("waiting until the handshake completes", func() {
 		It("waits until the handshake is complete", func(done Done) {
 			go sess.run()
@@ -664,6 +864,46 @@ var _ = Describe("Session", func() {
 			close(done)
 		})
 
+		It("closes the HandshakeComplete channel when the handshake completes", func() {
+			go sess.run()
+			defer sess.Close(nil)
+			aeadChanged <- protocol.EncryptionSecure
+			Consistently(sess.HandshakeComplete()).ShouldNot(BeClosed())
+			aeadChanged <- protocol.EncryptionForwardSecure
+			Consistently(sess.HandshakeComplete()).ShouldNot(BeClosed())
+			close(aeadChanged)
+			Eventually(sess.HandshakeComplete()).Should(BeClosed())
+		})
+
+		It("doesn't close the HandshakeComplete channel if the handshake fails", func() {
+			sess.cryptoSetup = &mockCryptoSetup{handleErr: errors.New("crypto error")}
+			go sess.run()
+			Eventually(sess.runClosed).Should(BeClosed())
+			Expect(sess.HandshakeComplete()).ToNot(BeClosed())
+		})
+
+		It("returns the connection state", func() {
+			cert := &x509.Certificate{Raw: []byte("leaf")}
+			cryptoSetup.connState = handshake.ConnectionState{
+				ServerName:         "quic.clemente.io",
+				NegotiatedProtocol: "foo",
+				PeerCertificates:   []*x509.Certificate{cert},
+				AEAD:               "AESG",
+				KeyExchange:        "C255",
+			}
+			go sess.run()
+			defer sess.Close(nil)
+			state := sess.ConnectionState()
+			Expect(state.HandshakeComplete).To(BeFalse())
+			Expect(state.ServerName).To(Equal("quic.clemente.io"))
+			Expect(state.NegotiatedProtocol).To(Equal("foo"))
+			Expect(state.PeerCertificates).To(Equal([]*x509.Certificate{cert}))
+			Expect(state.AEAD).To(Equal("AESG"))
+			Expect(state.KeyExchange).To(Equal("C255"))
+			close(aeadChanged)
+			Eventually(func() bool { return sess.ConnectionState().HandshakeComplete }).Should(BeTrue())
+		})
+
 		It("doesn't wait if the handshake is already completed", func(done Done) {
 			go sess.run()
 			close(aeadChanged)
@@ -680,7 +920,7 @@ var _ = Describe("Session", func() {
 			go func() {
 				defer GinkgoRecover()
 				var err error
-				str, err = sess.AcceptStream()
+				str, err = sess.AcceptStream(context.Background())
 				Expect(err).ToNot(HaveOccurred())
 			}()
 			Consistently(func() Stream { return str }).Should(BeNil())
@@ -695,7 +935,7 @@ var _ = Describe("Session", func() {
 			testErr := errors.New("testErr")
 			var err error
 			go func() {
-				_, err = sess.AcceptStream()
+				_, err = sess.AcceptStream(context.Background())
 			}()
 			go sess.run()
 			Consistently(func() error { return err }).ShouldNot(HaveOccurred())
@@ -704,10 +944,18 @@ var _ = Describe("Session", func() {
 			Expect(err).To(MatchError(qerr.ToQuicError(testErr)))
 		})
 
+		It("stops accepting when the context is cancelled", func(done Done) {
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			_, err := sess.AcceptStream(ctx)
+			Expect(err).To(MatchError(context.DeadlineExceeded))
+			close(done)
+		}, 0.5)
+
 		It("stops accepting when the session is closed after version negotiation", func() {
 			var err error
 			go func() {
-				_, err = sess.AcceptStream()
+				_, err = sess.AcceptStream(context.Background())
 			}()
 			go sess.run()
 			Consistently(func() error { return err }).ShouldNot(HaveOccurred())
@@ -757,6 +1005,21 @@ var _ = Describe("Session", func() {
 			Expect(sess.runClosed).To(BeClosed())
 		})
 
+		It("closes with an application error code and reason", func() {
+			s, err := sess.GetOrOpenStream(5)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sess.CloseWithError(0x1337, "application error")).To(Succeed())
+			Eventually(areSessionsRunning).Should(BeFalse())
+			Expect(mconn.written).To(HaveLen(1))
+			Expect(mconn.written[0]).To(ContainSubstring(string([]byte{0x02, 0x37, 0x13, 0, 0, 17, 0})))
+			Expect(mconn.written[0]).To(ContainSubstring("application error"))
+			_, err = s.Read([]byte{0})
+			Expect(err).To(MatchError(qerr.Error(0x1337, "application error")))
+			Expect(sess.Context().Done()).To(BeClosed())
+			Expect(sess.Context().Err()).To(BeAssignableToTypeOf(&qerr.QuicError{}))
+			Expect(sess.Context().Err().(*qerr.QuicError).ErrorCode).To(Equal(qerr.ErrorCode(0x1337)))
+		})
+
 		It("closes the session in order to replace it with another QUIC version", func() {
 			sess.Close(errCloseSessionForNewVersion)
 			Eventually(areSessionsRunning).Should(BeFalse())
@@ -831,48 +1094,112 @@ var _ = Describe("Session", func() {
 			Expect(err).ToNot(HaveOccurred())
 		})
 
-		Context("updating the remote address", func() {
-			It("sets the remote address", func() {
-				remoteIP := &net.IPAddr{IP: net.IPv4(192, 168, 0, 100)}
-				Expect(sess.conn.(*mockConnection).remoteAddr).ToNot(Equal(remoteIP))
-				p := receivedPacket{
-					remoteAddr:   remoteIP,
-					publicHeader: &PublicHeader{PacketNumber: 1337},
-				}
-				err := sess.handlePacketImpl(&p)
+		Context("validating a new remote address", func() {
+			var remoteAddr, newAddr net.Addr
+
+			BeforeEach(func() {
+				remoteAddr = &net.UDPAddr{IP: net.IPv4(192, 168, 0, 100), Port: 1337}
+				newAddr = &net.UDPAddr{IP: net.IPv4(192, 168, 0, 101), Port: 1337}
+				mconn.remoteAddr = remoteAddr
+			})
+
+			receivePacket := func(pn protocol.PacketNumber, addr net.Addr) error {
+				return sess.handlePacketImpl(&receivedPacket{
+					remoteAddr:   addr,
+					publicHeader: &PublicHeader{PacketNumber: pn, PacketNumberLen: protocol.PacketNumberLen6},
+					data:         make([]byte, 500),
+				})
+			}
+
+			It("doesn't change the remote address before the new address is validated", func() {
+				err := receivePacket(1, newAddr)
 				Expect(err).ToNot(HaveOccurred())
-				Expect(sess.conn.(*mockConnection).remoteAddr).To(Equal(remoteIP))
+				Expect(mconn.remoteAddr).To(Equal(remoteAddr))
+				// a probe was sent to the new address
+				Expect(mconn.writtenTo).To(Equal([]net.Addr{newAddr}))
+				Expect(sess.pathValidator.probes).To(HaveLen(1))
 			})
 
-			It("doesn't change the remote address if authenticating the packet fails", func() {
-				remoteIP := &net.IPAddr{IP: net.IPv4(192, 168, 0, 100)}
-				attackerIP := &net.IPAddr{IP: net.IPv4(192, 168, 0, 102)}
-				sess.conn.(*mockConnection).remoteAddr = remoteIP
+			It("changes the remote address when the probe is acknowledged from the new address", func() {
+				err := receivePacket(1, newAddr)
+				Expect(err).ToNot(HaveOccurred())
+				probe := sess.pathValidator.probes[0]
+				sess.unpacker.(*mockUnpacker).frames = []frames.Frame{&frames.AckFrame{LargestAcked: probe, LowestAcked: probe}}
+				err = receivePacket(2, newAddr)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(mconn.remoteAddr).To(Equal(newAddr))
+				Expect(sess.pathValidator).To(BeNil())
+			})
+
+			It("doesn't change the remote address if the ACK doesn't acknowledge the probe", func() {
+				err := sess.sendPacket() // make sure there's a packet that can be acknowledged
+				Expect(err).ToNot(HaveOccurred())
+				sess.packer.QueueControlFrameForNextPacket(&frames.PingFrame{})
+				err = sess.sendPacket()
+				Expect(err).ToNot(HaveOccurred())
+				pn := sess.largestSentPacketNumber
+				err = receivePacket(1, newAddr)
+				Expect(err).ToNot(HaveOccurred())
+				sess.unpacker.(*mockUnpacker).frames = []frames.Frame{&frames.AckFrame{LargestAcked: pn, LowestAcked: pn}}
+				err = receivePacket(2, newAddr)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(mconn.remoteAddr).To(Equal(remoteAddr))
+			})
+
+			It("doesn't change the remote address if the probe is acknowledged from a different address", func() {
+				err := receivePacket(1, newAddr)
+				Expect(err).ToNot(HaveOccurred())
+				probe := sess.pathValidator.probes[0]
+				sess.unpacker.(*mockUnpacker).frames = []frames.Frame{&frames.AckFrame{LargestAcked: probe, LowestAcked: probe}}
+				err = receivePacket(2, &net.UDPAddr{IP: net.IPv4(192, 168, 0, 102), Port: 1337})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(mconn.remoteAddr).To(Equal(remoteAddr))
+			})
+
+			It("doesn't send more probes within the probe interval", func() {
+				err := receivePacket(1, newAddr)
+				Expect(err).ToNot(HaveOccurred())
+				err = receivePacket(2, newAddr)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(mconn.writtenTo).To(HaveLen(1))
+				sess.pathValidator.lastProbeTime = time.Now().Add(-time.Second)
+				err = receivePacket(3, newAddr)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(mconn.writtenTo).To(HaveLen(2))
+			})
+
+			It("limits the number of bytes sent to the new address", func() {
+				pn := sess.packer.packetNumberGenerator.Peek()
+				err := sess.handlePacketImpl(&receivedPacket{
+					remoteAddr:   newAddr,
+					publicHeader: &PublicHeader{PacketNumber: 1, PacketNumberLen: protocol.PacketNumberLen6},
+					data:         make([]byte, 5),
+				})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(mconn.written).To(BeEmpty())
+				Expect(mconn.remoteAddr).To(Equal(remoteAddr))
+				// no packet was packed, so no packet number was skipped
+				Expect(sess.packer.packetNumberGenerator.Peek()).To(Equal(pn))
+			})
+
+			It("doesn't validate the address if authenticating the packet fails", func() {
 				// use the real packetUnpacker here, to make sure this test fails if the error code for failed decryption changes
-				sess.unpacker = &packetUnpacker{}
+				sess.unpacker = &packetUnpacker{logger: utils.DefaultLogger}
 				sess.unpacker.(*packetUnpacker).aead = &mockAEAD{}
-				p := receivedPacket{
-					remoteAddr:   attackerIP,
-					publicHeader: &PublicHeader{PacketNumber: 1337},
-				}
-				err := sess.handlePacketImpl(&p)
+				err := receivePacket(1, newAddr)
 				quicErr := err.(*qerr.QuicError)
 				Expect(quicErr.ErrorCode).To(Equal(qerr.DecryptionFailure))
-				Expect(sess.conn.(*mockConnection).remoteAddr).To(Equal(remoteIP))
+				Expect(mconn.remoteAddr).To(Equal(remoteAddr))
+				Expect(mconn.written).To(BeEmpty())
 			})
 
-			It("sets the remote address, if the packet is authenticated, but unpacking fails for another reason", func() {
+			It("doesn't validate the address if unpacking the packet fails", func() {
 				testErr := errors.New("testErr")
-				remoteIP := &net.IPAddr{IP: net.IPv4(192, 168, 0, 100)}
-				Expect(sess.conn.(*mockConnection).remoteAddr).ToNot(Equal(remoteIP))
-				p := receivedPacket{
-					remoteAddr:   remoteIP,
-					publicHeader: &PublicHeader{PacketNumber: 1337},
-				}
 				sess.unpacker.(*mockUnpacker).unpackErr = testErr
-				err := sess.handlePacketImpl(&p)
+				err := receivePacket(1, newAddr)
 				Expect(err).To(MatchError(testErr))
-				Expect(sess.conn.(*mockConnection).remoteAddr).To(Equal(remoteIP))
+				Expect(mconn.remoteAddr).To(Equal(remoteAddr))
+				Expect(mconn.written).To(BeEmpty())
 			})
 		})
 	})
@@ -902,6 +1229,166 @@ var _ = Describe("Session", func() {
 			Expect(mconn.written[1]).To(ContainSubstring(string([]byte{0x04, 0x05, 0, 0, 0})))
 		})
 
+		It("writes sent packets to the structured log", func() {
+			b := &bytes.Buffer{}
+			utils.SetStructuredLogWriter(b)
+			defer utils.SetStructuredLogWriter(nil)
+			sess.packer.QueueControlFrameForNextPacket(&frames.PingFrame{})
+			err := sess.sendPacket()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mconn.written).To(HaveLen(1))
+			var event map[string]interface{}
+			err = json.Unmarshal(b.Bytes(), &event)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(event).To(HaveKeyWithValue("event", "packet_sent"))
+			Expect(event).To(HaveKeyWithValue("connection_id", fmt.Sprintf("%x", sess.connectionID)))
+			Expect(event).To(HaveKeyWithValue("packet_number", float64(1)))
+			Expect(event).To(HaveKeyWithValue("size", float64(len(mconn.written[0]))))
+			Expect(event["frames"]).To(ContainElement("PING"))
+		})
+
+		It("doesn't send packets before the pacer allows it", func() {
+			sph := newMockSentPacketHandler().(*mockSentPacketHandler)
+			sph.nextPacketSendTime = time.Now().Add(10 * time.Millisecond)
+			sess.sentPacketHandler = sph
+			sess.packer.QueueControlFrameForNextPacket(&frames.PingFrame{})
+			err := sess.sendPacket()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mconn.written).To(BeEmpty())
+			Expect(sess.pacingDeadline).To(Equal(sph.nextPacketSendTime))
+			sess.maybeResetTimer()
+			Expect(sess.currentDeadline).To(Equal(sph.nextPacketSendTime))
+			sph.nextPacketSendTime = time.Now()
+			err = sess.sendPacket()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mconn.written).To(HaveLen(1))
+			Expect(sess.pacingDeadline).To(BeZero())
+		})
+
+		Context("with batched writes", func() {
+			BeforeEach(func() {
+				sess.config.BatchWrites = true
+				sess.sentPacketHandler = newMockSentPacketHandler()
+				sess.packer.cryptoSetup = &mockCryptoSetup{encLevelSeal: protocol.EncryptionForwardSecure}
+				_, err := sess.GetOrOpenStream(5)
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("sends multiple packets in one batched write", func() {
+				sess.streamFramer.AddFrameForRetransmission(&frames.StreamFrame{
+					StreamID: 5,
+					Data:     bytes.Repeat([]byte{'f'}, int(2.5*float32(protocol.MaxPacketSize))),
+				})
+				err := sess.sendPacket()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(mconn.batches).To(Equal([]int{3}))
+				Expect(mconn.written).To(HaveLen(3))
+				Expect(sess.packetsToWrite).To(BeEmpty())
+			})
+
+			It("doesn't write anything if no packet was sent", func() {
+				err := sess.sendPacket()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(mconn.batches).To(BeEmpty())
+			})
+
+			It("limits the number of packets in one batched write", func() {
+				sess.streamFramer.AddFrameForRetransmission(&frames.StreamFrame{
+					StreamID: 5,
+					Data:     bytes.Repeat([]byte{'f'}, int(protocol.MaxBatchWritePackets+1)*int(protocol.MaxPacketSize)),
+				})
+				err := sess.sendPacket()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(mconn.batches).To(HaveLen(2))
+				Expect(mconn.batches[0]).To(Equal(protocol.MaxBatchWritePackets))
+			})
+
+			It("returns the error if the batched write fails", func() {
+				testErr := errors.New("write failed")
+				mconn.batchErr = testErr
+				sess.streamFramer.AddFrameForRetransmission(&frames.StreamFrame{
+					StreamID: 5,
+					Data:     bytes.Repeat([]byte{'f'}, int(2.5*float32(protocol.MaxPacketSize))),
+				})
+				err := sess.sendPacket()
+				Expect(err).To(MatchError(testErr))
+				Expect(mconn.batches).To(Equal([]int{3}))
+				Expect(mconn.written).To(BeEmpty())
+				Expect(sess.packetsToWrite).To(BeEmpty())
+			})
+		})
+
+		Context("when congestion limited", func() {
+			var sph *mockSentPacketHandler
+
+			BeforeEach(func() {
+				sph = newMockSentPacketHandler().(*mockSentPacketHandler)
+				sph.congestionLimited = true
+				sess.sentPacketHandler = sph
+				sess.packer.packetNumberGenerator.next = 0x1337 + 9
+				sess.packer.QueueControlFrameForNextPacket(&frames.PingFrame{})
+			})
+
+			It("doesn't send any packets", func() {
+				err := sess.sendPacket()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(mconn.written).To(BeEmpty())
+			})
+
+			It("sends ACK-only packets", func() {
+				sess.nextAckScheduledTime = time.Now().Add(-time.Millisecond)
+				err := sess.receivedPacketHandler.ReceivedPacket(0x42, true)
+				Expect(err).ToNot(HaveOccurred())
+				err = sess.sendPacket()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(mconn.written).To(HaveLen(1))
+				Expect(sph.sentPackets).To(HaveLen(1))
+				fs := sph.sentPackets[0].Frames
+				Expect(fs).To(HaveLen(2))
+				Expect(fs[0]).To(BeAssignableToTypeOf(&frames.StopWaitingFrame{}))
+				Expect(fs[1]).To(BeAssignableToTypeOf(&frames.AckFrame{}))
+				Expect(fs[1].(*frames.AckFrame).LargestAcked).To(Equal(protocol.PacketNumber(0x42)))
+				Expect(sess.nextAckScheduledTime).To(BeZero())
+				// the PING is sent as soon as the congestion window allows it
+				sph.congestionLimited = false
+				err = sess.sendPacket()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(sph.sentPackets).To(HaveLen(2))
+				Expect(sph.sentPackets[1].Frames).To(ContainElement(&frames.PingFrame{}))
+			})
+
+			It("sends ACK-only packets when the pacer doesn't allow sending", func() {
+				sph.congestionLimited = false
+				sph.nextPacketSendTime = time.Now().Add(time.Hour)
+				err := sess.receivedPacketHandler.ReceivedPacket(0x42, true)
+				Expect(err).ToNot(HaveOccurred())
+				err = sess.sendPacket()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(sph.sentPackets).To(HaveLen(1))
+				Expect(sph.sentPackets[0].Frames).ToNot(ContainElement(&frames.PingFrame{}))
+				Expect(sess.pacingDeadline).To(Equal(sph.nextPacketSendTime))
+			})
+
+			It("doesn't send ACK-only packets when too many packets are tracked", func() {
+				sph.maxTrackedLimited = true
+				sess.nextAckScheduledTime = time.Now().Add(-time.Millisecond)
+				err := sess.receivedPacketHandler.ReceivedPacket(0x42, true)
+				Expect(err).ToNot(HaveOccurred())
+				err = sess.sendPacket()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(mconn.written).To(BeEmpty())
+				Expect(sess.nextAckScheduledTime).To(BeZero())
+				// the ACK is sent with the next packet
+				sph.maxTrackedLimited = false
+				sph.congestionLimited = false
+				err = sess.sendPacket()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(sph.sentPackets).To(HaveLen(1))
+				Expect(sph.sentPackets[0].Frames).To(ContainElement(&frames.PingFrame{}))
+				Expect(sph.sentPackets[0].Frames).To(ContainElement(BeAssignableToTypeOf(&frames.AckFrame{})))
+			})
+		})
+
 		It("sends public reset", func() {
 			err := sess.sendPublicReset(1)
 			Expect(err).NotTo(HaveOccurred())
@@ -932,12 +1419,99 @@ var _ = Describe("Session", func() {
 		})
 	})
 
+	Context("limiting amplification before the client's address is validated", func() {
+		BeforeEach(func() {
+			sess.amplificationLimit = &amplificationLimit{}
+			sess.unpacker = &mockUnpacker{}
+			// the server's response to a CHLO is sent on the crypto stream
+			sess.streamFramer.AddFrameForRetransmission(&frames.StreamFrame{
+				StreamID: 1,
+				Data:     bytes.Repeat([]byte{'f'}, 10*int(protocol.MaxPacketSize)),
+			})
+		})
+
+		receivePacket := func(pn protocol.PacketNumber, size int) {
+			err := sess.handlePacketImpl(&receivedPacket{
+				publicHeader: &PublicHeader{PacketNumber: pn, PacketNumberLen: protocol.PacketNumberLen6},
+				data:         make([]byte, size),
+			})
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		bytesWritten := func() int {
+			var n int
+			for _, p := range mconn.written {
+				n += len(p)
+			}
+			return n
+		}
+
+		It("doesn't send anything before a packet was received", func() {
+			err := sess.sendPacket()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mconn.written).To(BeEmpty())
+		})
+
+		It("doesn't send more than 3 times the bytes received", func() {
+			receivePacket(1, 1000)
+			err := sess.sendPacket()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mconn.written).ToNot(BeEmpty())
+			Expect(bytesWritten()).To(BeNumerically("<=", 3*1000))
+		})
+
+		It("defers the whole response to a tiny packet", func() {
+			receivePacket(1, 50)
+			err := sess.sendPacket()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(bytesWritten()).To(BeNumerically("<=", 3*50))
+		})
+
+		It("sends more when more bytes are received", func() {
+			receivePacket(1, 1000)
+			err := sess.sendPacket()
+			Expect(err).ToNot(HaveOccurred())
+			n := len(mconn.written)
+			receivePacket(2, 1000)
+			err = sess.sendPacket()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(mconn.written)).To(BeNumerically(">", n))
+			Expect(bytesWritten()).To(BeNumerically("<=", 3*2000))
+		})
+
+		It("times out the handshake while an ACK is waiting for the amplification limit", func(done Done) {
+			sess.config.HandshakeTimeout = 100 * time.Millisecond
+			sess.sessionCreationTime = time.Now()
+			receivePacket(1, 50)
+			// the ACK can't be sent, since 3 times 50 bytes is not enough for a packet
+			sess.nextAckScheduledTime = time.Now()
+			err := sess.run() // Would normally not return
+			Expect(err).To(HaveOccurred())
+			Expect(err.(*qerr.QuicError).ErrorCode).To(Equal(qerr.HandshakeTimeout))
+			close(done)
+		})
+
+		It("sends the deferred data once the client's address is validated", func(done Done) {
+			receivePacket(1, 1000)
+			go sess.run()
+			aeadChanged <- protocol.EncryptionSecure
+			Consistently(func() int { return len(mconn.written) }).Should(BeNumerically("<", 10))
+			aeadChanged <- protocol.EncryptionForwardSecure
+			Eventually(func() int { return len(mconn.written) }).Should(BeNumerically(">=", 10))
+			Expect(sess.Close(nil)).To(Succeed())
+			// the run loop has stopped, so the session can be inspected without a race
+			Expect(sess.amplificationLimit).To(BeNil())
+			close(done)
+		})
+	})
+
 	Context("retransmissions", func() {
 		var sph *mockSentPacketHandler
 		BeforeEach(func() {
 			// a StopWaitingFrame is added, so make sure the packet number of the new package is higher than the packet number of the retransmitted packet
 			sess.packer.packetNumberGenerator.next = 0x1337 + 10
 			sph = newMockSentPacketHandler().(*mockSentPacketHandler)
+			sph.leastUnacked = 0x1337
 			sess.sentPacketHandler = sph
 			sess.packer.cryptoSetup = &mockCryptoSetup{encLevelSeal: protocol.EncryptionForwardSecure}
 		})
@@ -1102,6 +1676,11 @@ var _ = Describe("Session", func() {
 			It("doesn't retransmit WindowUpdates for closed streams", func() {
 				str, err := sess.GetOrOpenStream(5)
 				Expect(err).ToNot(HaveOccurred())
+				// accept streams 3 and 5, streams reset before being accepted are not deleted
+				for i := 0; i < 2; i++ {
+					_, err = sess.AcceptStream(context.Background())
+					Expect(err).ToNot(HaveOccurred())
+				}
 				// close the stream
 				str.(*stream).sentFin()
 				str.Close()
@@ -1384,7 +1963,7 @@ var _ = Describe("Session", func() {
 	})
 
 	It("does not block if an error occurs", func(done Done) {
-		// this test basically tests that the handshakeChan has a capacity of 3
+		// this test basically tests that the handshakeChan has a capacity of 4
 		// The session needs to run (and close) properly, even if no one is receiving from the handshakeChan
 		go sess.run()
 		aeadChanged <- protocol.EncryptionSecure
@@ -1410,6 +1989,32 @@ var _ = Describe("Session", func() {
 			close(done)
 		})
 
+		It("closes the session after the configured handshake timeout", func(done Done) {
+			sess.config.HandshakeTimeout = 100 * time.Millisecond
+			start := time.Now()
+			sess.sessionCreationTime = start
+			// the handshake never completes
+			err := sess.run() // Would normally not return
+			Expect(err).To(HaveOccurred())
+			Expect(err.(*qerr.QuicError).ErrorCode).To(Equal(qerr.HandshakeTimeout))
+			Expect(time.Since(start)).To(BeNumerically("~", 100*time.Millisecond, 50*time.Millisecond))
+			Expect(mconn.written[0]).To(ContainSubstring("Crypto handshake did not complete in time."))
+			Expect(handshakeChan).To(Receive(&handshakeEvent{err: err}))
+			Expect(sess.runClosed).To(BeClosed())
+			close(done)
+		})
+
+		It("doesn't time out the handshake after it completed", func(done Done) {
+			sess.config.HandshakeTimeout = 50 * time.Millisecond
+			sess.config.IdleTimeout = 150 * time.Millisecond
+			sess.connectionParameters = handshake.NewConnectionParamatersManager(protocol.PerspectiveServer, sess.version, protocol.MaxReceiveStreamFlowControlWindowServer, protocol.MaxReceiveConnectionFlowControlWindowServer, sess.config.IdleTimeout)
+			sess.packer.connectionParameters = sess.connectionParameters
+			close(aeadChanged)
+			err := sess.run() // Would normally not return
+			Expect(err.(*qerr.QuicError).ErrorCode).To(Equal(qerr.NetworkIdleTimeout))
+			close(done)
+		})
+
 		It("does not use ICSL before handshake", func(done Done) {
 			sess.lastNetworkActivityTime = time.Now().Add(-time.Minute)
 			cpm.idleTime = 99999 * time.Second
@@ -1420,6 +2025,20 @@ var _ = Describe("Session", func() {
 			close(done)
 		})
 
+		It("closes the session after the configured idle timeout", func(done Done) {
+			sess.config.IdleTimeout = 100 * time.Millisecond
+			sess.connectionParameters = handshake.NewConnectionParamatersManager(protocol.PerspectiveServer, sess.version, protocol.MaxReceiveStreamFlowControlWindowServer, protocol.MaxReceiveConnectionFlowControlWindowServer, sess.config.IdleTimeout)
+			sess.packer.connectionParameters = sess.connectionParameters
+			close(aeadChanged)
+			start := time.Now()
+			sess.lastNetworkActivityTime = start
+			sess.run() // Would normally not return
+			Expect(time.Since(start)).To(BeNumerically("~", 100*time.Millisecond, 50*time.Millisecond))
+			Expect(mconn.written[0]).To(ContainSubstring("No recent network activity."))
+			Expect(sess.runClosed).To(BeClosed())
+			close(done)
+		})
+
 		It("uses ICSL after handshake", func(done Done) {
 			close(aeadChanged)
 			cpm.idleTime = 0 * time.Millisecond
@@ -1431,6 +2050,319 @@ var _ = Describe("Session", func() {
 		})
 	})
 
+	Context("path MTU discovery", func() {
+		BeforeEach(func() {
+			sess.mtuDiscoverer = newMTUDiscoverer(protocol.MaxPacketSize, protocol.MaxReceivePacketSize)
+			sess.handshakeComplete = true
+			sess.packer.packetNumberGenerator.next = 0x1337
+		})
+
+		It("sends MTU probes", func() {
+			sph := newMockSentPacketHandler().(*mockSentPacketHandler)
+			sph.congestionLimited = true
+			sess.sentPacketHandler = sph
+			size := sess.mtuDiscoverer.NextProbeSize()
+			err := sess.maybeSendMTUProbe(time.Now())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mconn.written).To(HaveLen(1))
+			Expect(mconn.written[0]).To(HaveLen(int(size)))
+			Expect(sph.sentPackets).To(HaveLen(1))
+			Expect(sph.sentPackets[0].IsMTUProbe).To(BeTrue())
+			// only one probe is sent at a time
+			err = sess.maybeSendMTUProbe(time.Now())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mconn.written).To(HaveLen(1))
+		})
+
+		It("doesn't send MTU probes before the handshake completes", func() {
+			sess.handshakeComplete = false
+			err := sess.maybeSendMTUProbe(time.Now())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mconn.written).To(BeEmpty())
+		})
+
+		It("doesn't send MTU probes while validating a new address", func() {
+			sess.pathValidator = newPathValidator(&net.UDPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 1337})
+			err := sess.maybeSendMTUProbe(time.Now())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mconn.written).To(BeEmpty())
+		})
+
+		It("doesn't send MTU probes if path MTU discovery is disabled", func() {
+			sess.mtuDiscoverer = nil
+			err := sess.maybeSendMTUProbe(time.Now())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mconn.written).To(BeEmpty())
+		})
+
+		It("increases the packet size when a probe is acknowledged", func() {
+			size := sess.mtuDiscoverer.NextProbeSize()
+			err := sess.maybeSendMTUProbe(time.Now())
+			Expect(err).ToNot(HaveOccurred())
+			sess.lastRcvdPacketNumber = 1
+			err = sess.handleAckFrame(&frames.AckFrame{LargestAcked: 0x1337, LowestAcked: 0x1337})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sess.packer.maxPacketSize).To(Equal(size))
+			Expect(sess.mtuDiscoverer.CurrentSize()).To(Equal(size))
+		})
+
+		It("starts over when the path changes", func() {
+			sess.packer.SetMaxPacketSize(protocol.MaxReceivePacketSize)
+			sess.restartMTUDiscovery()
+			Expect(sess.packer.maxPacketSize).To(Equal(protocol.MaxPacketSize))
+		})
+	})
+
+	Context("keep-alives", func() {
+		It("sends a PING after half the idle timeout", func() {
+			sess.handshakeComplete = true
+			sess.config.KeepAlive = true
+			sess.lastNetworkActivityTime = time.Now().Add(-sess.idleTimeout() / 2)
+			sess.packer.connectionID = 0x1337
+			go sess.run()
+			defer sess.Close(nil)
+			Eventually(func() int { return len(mconn.written) }).ShouldNot(BeZero())
+			r := bytes.NewReader(mconn.written[0])
+			_, err := ParsePublicHeader(r, protocol.PerspectiveServer)
+			Expect(err).ToNot(HaveOccurred())
+			// the mockCryptoSetup appends 12 zero bytes when sealing
+			payload := make([]byte, r.Len()-12)
+			r.Read(payload)
+			r = bytes.NewReader(payload)
+			// the PING frame is the only frame in the packet
+			frame, err := frames.ParsePingFrame(r)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(frame).ToNot(BeNil())
+			Expect(r.Len()).To(BeZero())
+			Expect(sess.keepAlivePingSent).To(BeTrue())
+		})
+
+		It("doesn't send a PING before half the idle timeout has passed", func() {
+			sess.handshakeComplete = true
+			sess.config.KeepAlive = true
+			sess.lastNetworkActivityTime = time.Now().Add(-sess.idleTimeout()/2 + time.Second)
+			go sess.run()
+			defer sess.Close(nil)
+			Consistently(func() int { return len(mconn.written) }).Should(BeZero())
+		})
+
+		It("doesn't send a PING if keep-alives are disabled", func() {
+			sess.handshakeComplete = true
+			sess.config.KeepAlive = false
+			sess.lastNetworkActivityTime = time.Now().Add(-sess.idleTimeout() / 2)
+			go sess.run()
+			defer sess.Close(nil)
+			Consistently(func() int { return len(mconn.written) }).Should(BeZero())
+		})
+
+		It("doesn't send a PING if the handshake isn't completed yet", func() {
+			sess.handshakeComplete = false
+			sess.config.KeepAlive = true
+			sess.lastNetworkActivityTime = time.Now().Add(-sess.idleTimeout() / 2)
+			go sess.run()
+			defer sess.Close(nil)
+			Consistently(func() int { return len(mconn.written) }).Should(BeZero())
+		})
+
+		It("resets the keep-alive state when a packet is received", func() {
+			sess.keepAlivePingSent = true
+			sess.unpacker = &mockUnpacker{}
+			err := sess.handlePacketImpl(&receivedPacket{publicHeader: &PublicHeader{PacketNumber: 1, PacketNumberLen: protocol.PacketNumberLen6}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sess.keepAlivePingSent).To(BeFalse())
+		})
+	})
+
+	Context("congestion control", func() {
+		It("uses the initial congestion window from the config", func() {
+			conf := populateServerConfig(&Config{InitialCongestionWindow: 100})
+			pSess, _, err := newSession(mconn, protocol.Version35, 0, scfg, conf)
+			Expect(err).NotTo(HaveOccurred())
+			s := pSess.(*session)
+			Expect(s.sendAlgorithm.GetCongestionWindow()).To(Equal(100 * protocol.DefaultTCPMSS))
+		})
+
+		It("uses the default initial congestion window", func() {
+			Expect(sess.sendAlgorithm.GetCongestionWindow()).To(Equal(protocol.InitialCongestionWindow * protocol.DefaultTCPMSS))
+		})
+	})
+
+	Context("logging", func() {
+		var b *bytes.Buffer
+
+		BeforeEach(func() {
+			b = &bytes.Buffer{}
+		})
+
+		AfterEach(func() {
+			utils.SetLogWriter(nil)
+			utils.SetLogLevel(utils.LogLevelNothing)
+		})
+
+		It("uses the default logger", func() {
+			utils.SetLogWriter(b)
+			utils.SetLogTimeFormat("")
+			utils.SetLogLevel(utils.LogLevelInfo)
+			sess.handleCloseError(closeError{err: qerr.Error(qerr.PeerGoingAway, ""), remote: true})
+			Expect(b.String()).To(Equal("[0] Closing connection\n"))
+		})
+
+		It("uses the logger from the config", func() {
+			logger := utils.NewLogger().WithPrefix("server: ")
+			logger.SetLogWriter(b)
+			logger.SetLogTimeFormat("")
+			logger.SetLogLevel(utils.LogLevelInfo)
+			conf := populateServerConfig(&Config{Logger: logger})
+			pSess, _, err := newSession(mconn, protocol.Version35, 0x1337, scfg, conf)
+			Expect(err).NotTo(HaveOccurred())
+			s := pSess.(*session)
+			s.handleCloseError(closeError{err: qerr.Error(qerr.PeerGoingAway, ""), remote: true})
+			Expect(b.String()).To(Equal("server: [1337] Closing connection\n"))
+		})
+
+		It("prefixes the log lines of every session with its connection ID", func() {
+			utils.SetLogWriter(b)
+			utils.SetLogTimeFormat("")
+			utils.SetLogLevel(utils.LogLevelDebug)
+			var sessions []*session
+			for _, connID := range []protocol.ConnectionID{0xdecafbad, 0xdeadbeef} {
+				pSess, _, err := newSession(mconn, protocol.Version35, connID, scfg, populateServerConfig(&Config{}))
+				Expect(err).NotTo(HaveOccurred())
+				s := pSess.(*session)
+				// these sessions send packets without receiving any from the client
+				s.amplificationLimit = nil
+				sessions = append(sessions, s)
+			}
+			for i := 0; i < 3; i++ {
+				for _, s := range sessions {
+					s.packer.QueueControlFrameForNextPacket(&frames.PingFrame{})
+					Expect(s.sendPacket()).To(Succeed())
+				}
+			}
+			lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+			var numDecafbad, numDeadbeef int
+			for _, line := range lines {
+				switch {
+				case strings.HasPrefix(line, "[decafbad] "):
+					numDecafbad++
+				case strings.HasPrefix(line, "[deadbeef] "):
+					numDeadbeef++
+				default:
+					Fail("unexpected log line: " + line)
+				}
+			}
+			// every packet is logged, as well as its frames
+			Expect(numDecafbad).To(BeNumerically(">=", 6))
+			Expect(numDeadbeef).To(Equal(numDecafbad))
+		})
+	})
+
+	Context("statistics", func() {
+		It("counts sent packets and bytes", func() {
+			sess.sentPacketHandler = newMockSentPacketHandler()
+			sess.packer.packetNumberGenerator.next = 0x1337 + 9
+			sess.packer.cryptoSetup = &mockCryptoSetup{encLevelSeal: protocol.EncryptionSecure}
+			data := bytes.Repeat([]byte{'f'}, 500)
+			sess.streamFramer.AddFrameForRetransmission(&frames.StreamFrame{StreamID: 5, Data: data})
+			_, err := sess.GetOrOpenStream(5)
+			Expect(err).ToNot(HaveOccurred())
+			err = sess.sendPacket()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mconn.written).To(HaveLen(1))
+			stats := sess.Stats()
+			Expect(stats.PacketsSent).To(Equal(uint64(1)))
+			Expect(stats.BytesSent).To(BeEquivalentTo(len(mconn.written[0])))
+			// the packet contains the data, plus the Public Header, the frame header and the encryption overhead
+			Expect(stats.BytesSent).To(BeNumerically(">", len(data)))
+			Expect(stats.BytesSent).To(BeNumerically("<", len(data)+50))
+		})
+
+		It("counts received packets and bytes", func() {
+			sess.unpacker = &mockUnpacker{}
+			hdr := &PublicHeader{PacketNumber: 1, PacketNumberLen: protocol.PacketNumberLen6, Raw: make([]byte, 15)}
+			err := sess.handlePacketImpl(&receivedPacket{publicHeader: hdr, data: make([]byte, 500)})
+			Expect(err).ToNot(HaveOccurred())
+			stats := sess.Stats()
+			Expect(stats.PacketsReceived).To(Equal(uint64(1)))
+			Expect(stats.BytesReceived).To(Equal(protocol.ByteCount(515)))
+		})
+
+		It("doesn't count packets that can't be decrypted", func() {
+			sess.unpacker = &mockUnpacker{unpackErr: qerr.Error(qerr.DecryptionFailure, "")}
+			hdr := &PublicHeader{PacketNumber: 1, PacketNumberLen: protocol.PacketNumberLen6}
+			err := sess.handlePacketImpl(&receivedPacket{publicHeader: hdr, data: make([]byte, 500)})
+			Expect(err).To(HaveOccurred())
+			Expect(sess.Stats().PacketsReceived).To(BeZero())
+			Expect(sess.Stats().BytesReceived).To(BeZero())
+		})
+
+		It("counts retransmissions", func() {
+			sess.packer.packetNumberGenerator.next = 0x1337 + 10
+			sph := newMockSentPacketHandler().(*mockSentPacketHandler)
+			sess.sentPacketHandler = sph
+			sess.packer.cryptoSetup = &mockCryptoSetup{encLevelSeal: protocol.EncryptionForwardSecure}
+			sess.packer.SetForwardSecure()
+			sph.retransmissionQueue = []*ackhandler.Packet{{
+				PacketNumber:    0x1337,
+				Frames:          []frames.Frame{&frames.StreamFrame{StreamID: 5, Data: []byte("foobar")}},
+				EncryptionLevel: protocol.EncryptionForwardSecure,
+			}}
+			err := sess.sendPacket()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sess.Stats().PacketsRetransmitted).To(Equal(uint64(1)))
+			Expect(sess.Stats().PacketsSent).To(Equal(uint64(1)))
+		})
+
+		It("returns a snapshot of the RTT stats when an ACK is received", func() {
+			sess.sentPacketHandler = newMockSentPacketHandler()
+			Expect(sess.GetRTTStats()).To(BeZero())
+			sess.rttStats.UpdateRTT(30*time.Millisecond, 0, time.Now())
+			sess.rttStats.UpdateRTT(10*time.Millisecond, 0, time.Now())
+			err := sess.handleAckFrame(&frames.AckFrame{LargestAcked: 1})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sess.GetRTTStats()).To(Equal(RTTStats{
+				SmoothedRTT:   sess.rttStats.SmoothedRTT(),
+				LatestRTT:     10 * time.Millisecond,
+				MinRTT:        10 * time.Millisecond,
+				MeanDeviation: sess.rttStats.MeanDeviation(),
+			}))
+			Expect(sess.GetRTTStats().SmoothedRTT).To(BeNumerically(">", 10*time.Millisecond))
+		})
+
+		It("reports the state of the congestion controller", func() {
+			sess.packer.cryptoSetup = &mockCryptoSetup{encLevelSeal: protocol.EncryptionForwardSecure}
+			sess.packer.SetForwardSecure()
+			// more data than fits into the initial congestion window
+			sess.streamFramer.AddFrameForRetransmission(&frames.StreamFrame{
+				StreamID: 5,
+				Data:     bytes.Repeat([]byte{'f'}, 2*protocol.InitialCongestionWindow*int(protocol.MaxPacketSize)),
+			})
+			err := sess.sendPacket()
+			Expect(err).NotTo(HaveOccurred())
+			stats := sess.Stats()
+			Expect(stats.CongestionWindow).To(Equal(protocol.InitialCongestionWindow * protocol.DefaultTCPMSS))
+			// packets are sent until the bytes in flight exceed the congestion window
+			Expect(stats.BytesInFlight).To(BeNumerically(">", stats.CongestionWindow))
+			Expect(stats.BytesInFlight).To(BeNumerically("<=", stats.CongestionWindow+protocol.MaxPacketSize))
+			var bytesSent int
+			for _, p := range mconn.written {
+				bytesSent += len(p)
+			}
+			Expect(stats.BytesInFlight).To(BeEquivalentTo(bytesSent))
+			Expect(stats.InSlowStart).To(BeTrue())
+			Expect(stats.SlowStartThreshold).To(Equal(protocol.DefaultMaxCongestionWindow * protocol.DefaultTCPMSS))
+		})
+
+		It("counts opened streams, but not the crypto stream", func() {
+			Expect(sess.Stats().StreamsOpened).To(BeZero())
+			_, err := sess.GetOrOpenStream(3)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = sess.OpenStream()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sess.Stats().StreamsOpened).To(Equal(uint64(2)))
+		})
+	})
+
 	It("stores up to MaxSessionUnprocessedPackets packets", func(done Done) {
 		// Nothing here should block
 		for i := protocol.PacketNumber(0); i < protocol.MaxSessionUnprocessedPackets+10; i++ {
@@ -1533,6 +2465,10 @@ var _ = Describe("Session", func() {
 		})
 	})
 
+	It("returns the version", func() {
+		Expect(sess.GetVersion()).To(Equal(protocol.Version35))
+	})
+
 	It("returns the local address", func() {
 		addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1337}
 		mconn.localAddr = addr
@@ -1544,6 +2480,31 @@ var _ = Describe("Session", func() {
 		mconn.remoteAddr = addr
 		Expect(sess.RemoteAddr()).To(Equal(addr))
 	})
+
+	It("keeps sending to the old address until the new address is validated", func() {
+		localAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1337}
+		oldAddr := &net.UDPAddr{IP: net.IPv4(1, 2, 7, 1), Port: 7331}
+		newAddr := &net.UDPAddr{IP: net.IPv4(1, 2, 7, 2), Port: 7332}
+		packetConn := &mockPacketConn{addr: localAddr}
+		sess.conn = &conn{pconn: packetConn, currentAddr: oldAddr}
+		sess.unpacker = &mockUnpacker{}
+		Expect(sess.LocalAddr()).To(Equal(localAddr))
+		Expect(sess.RemoteAddr()).To(Equal(oldAddr))
+		err := sess.handlePacketImpl(&receivedPacket{
+			remoteAddr:   newAddr,
+			publicHeader: &PublicHeader{PacketNumber: 1},
+			data:         make([]byte, 500),
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(packetConn.dataWrittenTo).To(Equal(newAddr)) // the probe
+		Expect(sess.RemoteAddr()).To(Equal(oldAddr))
+		Expect(sess.LocalAddr()).To(Equal(localAddr))
+	})
+
+	It("doesn't allow the server to migrate", func() {
+		err := sess.MigrateTo(&mockPacketConn{})
+		Expect(err).To(MatchError(errMigrationNotAllowed))
+	})
 })
 
 var _ = Describe("Client Session", func() {
@@ -1563,12 +2524,16 @@ var _ = Describe("Client Session", func() {
 			_ string,
 			_ protocol.ConnectionID,
 			_ protocol.VersionNumber,
+			_ protocol.VersionNumber,
 			_ io.ReadWriter,
 			_ *tls.Config,
 			_ handshake.ConnectionParametersManager,
 			aeadChangedP chan<- protocol.EncryptionLevel,
 			_ *handshake.TransportParameters,
 			_ []protocol.VersionNumber,
+			_ handshake.TokenStore,
+			_ bool,
+			_ *utils.Logger,
 		) (handshake.CryptoSetup, error) {
 			aeadChanged = aeadChangedP
 			return cryptoSetup, nil
@@ -1581,6 +2546,7 @@ var _ = Describe("Client Session", func() {
 			mconn,
 			"hostname",
 			protocol.Version35,
+			protocol.Version35,
 			0,
 			populateClientConfig(&Config{}),
 			nil,
@@ -1613,10 +2579,86 @@ var _ = Describe("Client Session", func() {
 		})
 	})
 
+	Context("receiving Public Resets", func() {
+		var hdr *PublicHeader
+
+		// the Public Reset, without the Public Header
+		getPublicReset := func(rejectedPacketNumber protocol.PacketNumber) []byte {
+			b := writePublicReset(sess.connectionID, rejectedPacketNumber, 0)
+			return b[9:] // 1 byte public flags, 8 bytes connection ID
+		}
+
+		BeforeEach(func() {
+			hdr = &PublicHeader{ResetFlag: true, ConnectionID: sess.connectionID}
+			sess.largestSentPacketNumber = 10
+		})
+
+		It("closes the session with a Public Reset error", func(done Done) {
+			go sess.run()
+			str, err := sess.GetOrOpenStream(2)
+			Expect(err).ToNot(HaveOccurred())
+			err = sess.handlePacketImpl(&receivedPacket{publicHeader: hdr, data: getPublicReset(10)})
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(sess.runClosed).Should(BeClosed())
+			_, err = str.Read([]byte{0})
+			Expect(err).To(BeAssignableToTypeOf(&qerr.QuicError{}))
+			Expect(err.(*qerr.QuicError).ErrorCode).To(Equal(qerr.PublicReset))
+			// no CONNECTION_CLOSE is sent in response
+			Expect(mconn.written).To(BeEmpty())
+			close(done)
+		})
+
+		It("ignores Public Resets for packets that were never sent", func() {
+			go sess.run()
+			err := sess.handlePacketImpl(&receivedPacket{publicHeader: hdr, data: getPublicReset(11)})
+			Expect(err).ToNot(HaveOccurred())
+			Consistently(sess.runClosed).ShouldNot(BeClosed())
+			Expect(sess.Close(nil)).To(Succeed())
+		})
+
+		It("ignores Public Resets that can't be parsed", func() {
+			go sess.run()
+			err := sess.handlePacketImpl(&receivedPacket{publicHeader: hdr, data: []byte("foobar")})
+			Expect(err).ToNot(HaveOccurred())
+			Consistently(sess.runClosed).ShouldNot(BeClosed())
+			Expect(sess.Close(nil)).To(Succeed())
+		})
+	})
+
+	It("migrates to a new packet conn", func() {
+		addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1337}
+		err := sess.MigrateTo(&mockPacketConn{addr: addr})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sess.LocalAddr()).To(Equal(addr))
+	})
+
+	It("sends a PING after migrating", func(done Done) {
+		go sess.run()
+		err := sess.MigrateTo(&mockPacketConn{})
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(func() [][]byte { return mconn.written }).Should(HaveLen(1))
+		Expect(sess.Close(nil)).To(Succeed())
+		close(done)
+	})
+
+	It("remembers the largest packet number sent, to verify Public Resets", func() {
+		sess.packer.packetNumberGenerator.next = 0x1337
+		err := sess.sendPacket()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sess.largestSentPacketNumber).To(BeZero())
+		sess.packer.QueueControlFrameForNextPacket(&frames.PingFrame{})
+		err = sess.sendPacket()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mconn.written).To(HaveLen(1))
+		Expect(sess.largestSentPacketNumber).To(Equal(protocol.PacketNumber(0x1337)))
+	})
+
 	It("does not block if an error occurs", func(done Done) {
-		// this test basically tests that the handshakeChan has a capacity of 3
+		// this test basically tests that the handshakeChan has a capacity of 4
 		// The session needs to run (and close) properly, even if no one is receiving from the handshakeChan
 		go sess.run()
+		// when doing a 0-RTT handshake, the encryption level changes to secure twice
+		aeadChanged <- protocol.EncryptionSecure
 		aeadChanged <- protocol.EncryptionSecure
 		aeadChanged <- protocol.EncryptionForwardSecure
 		Expect(sess.Close(nil)).To(Succeed())
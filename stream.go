@@ -1,16 +1,43 @@
 package quic
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"sync"
+	"time"
 
 	"github.com/lucas-clemente/quic-go/flowcontrol"
 	"github.com/lucas-clemente/quic-go/frames"
 	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/qerr"
 	"github.com/lucas-clemente/quic-go/utils"
 )
 
+type deadlineError struct{}
+
+func (deadlineError) Error() string   { return "deadline exceeded" }
+func (deadlineError) Temporary() bool { return true }
+func (deadlineError) Timeout() bool   { return true }
+
+var errDeadline net.Error = &deadlineError{}
+
+var errStreamResetLocally = errors.New("stream reset")
+
+var errStreamReadClosed = errors.New("read direction of the stream closed")
+
+// A StreamResetError is returned by Read and Write on a stream that was reset by the peer.
+type StreamResetError struct {
+	// ErrorCode is the error code the peer sent in the RST_STREAM frame
+	ErrorCode qerr.ErrorCode
+}
+
+func (e *StreamResetError) Error() string {
+	return fmt.Sprintf("RST_STREAM received with code %d", e.ErrorCode)
+}
+
 // A Stream assembles the data from StreamFrames and provides a super-convenient Read-Interface
 //
 // Read() and Write() may be called concurrently, but multiple calls to Read() or Write() individually must be synchronized manually.
@@ -20,7 +47,7 @@ type stream struct {
 	streamID protocol.StreamID
 	onData   func()
 	// onReset is a callback that should send a RST_STREAM
-	onReset func(protocol.StreamID, protocol.ByteCount)
+	onReset func(protocol.StreamID, protocol.ByteCount, qerr.ErrorCode)
 
 	readPosInFrame int
 	writeOffset    protocol.ByteCount
@@ -35,6 +62,8 @@ type stream struct {
 	finishedReading utils.AtomicBool
 	// finisedWriting is set once Close() is called
 	finishedWriting utils.AtomicBool
+	// readClosed is set once CloseRead() is called
+	readClosed utils.AtomicBool
 	// resetLocally is set if Reset() is called
 	resetLocally utils.AtomicBool
 	// resetRemotely is set if RegisterRemoteError() is called
@@ -42,17 +71,29 @@ type stream struct {
 
 	frameQueue        *streamFrameSorter
 	newFrameOrErrCond sync.Cond
+	readDeadline      time.Time
+	// readDeadlineTimer wakes up a blocked Read when the read deadline expires
+	readDeadlineTimer *time.Timer
 
 	dataForWriting       []byte
 	finSent              utils.AtomicBool
 	rstSent              utils.AtomicBool
 	doneWritingOrErrCond sync.Cond
+	writeDeadline        time.Time
+	// writeDeadlineTimer wakes up a blocked Write when the write deadline expires
+	writeDeadlineTimer *time.Timer
+
+	priority Priority
 
 	flowControlManager flowcontrol.FlowControlManager
+
+	// ctx is cancelled when the stream is reset or cancelled
+	ctx       context.Context
+	ctxCancel context.CancelFunc
 }
 
 // newStream creates a new Stream
-func newStream(StreamID protocol.StreamID, onData func(), onReset func(protocol.StreamID, protocol.ByteCount), flowControlManager flowcontrol.FlowControlManager) (*stream, error) {
+func newStream(StreamID protocol.StreamID, onData func(), onReset func(protocol.StreamID, protocol.ByteCount, qerr.ErrorCode), flowControlManager flowcontrol.FlowControlManager) (*stream, error) {
 	s := &stream{
 		onData:             onData,
 		onReset:            onReset,
@@ -63,46 +104,35 @@ func newStream(StreamID protocol.StreamID, onData func(), onReset func(protocol.
 
 	s.newFrameOrErrCond.L = &s.mutex
 	s.doneWritingOrErrCond.L = &s.mutex
+	s.ctx, s.ctxCancel = context.WithCancel(context.Background())
 
 	return s, nil
 }
 
 // Read implements io.Reader. It is not thread safe!
 func (s *stream) Read(p []byte) (int, error) {
+	// once all data was read, every call returns io.EOF, even if the stream is closed afterwards
+	if s.finishedReading.Get() {
+		return 0, io.EOF
+	}
 	s.mutex.Lock()
 	err := s.err
 	s.mutex.Unlock()
 	if s.cancelled.Get() || s.resetLocally.Get() {
 		return 0, err
 	}
-	if s.finishedReading.Get() {
-		return 0, io.EOF
+	if s.readClosed.Get() {
+		return 0, errStreamReadClosed
 	}
 
 	bytesRead := 0
 	for bytesRead < len(p) {
 		s.mutex.Lock()
-		frame := s.frameQueue.Head()
-
-		if frame == nil && bytesRead > 0 {
+		if s.frameQueue.Head() == nil && bytesRead > 0 {
 			s.mutex.Unlock()
 			return bytesRead, s.err
 		}
-
-		var err error
-		for {
-			// Stop waiting on errors
-			if s.resetLocally.Get() || s.cancelled.Get() {
-				err = s.err
-				break
-			}
-			if frame != nil {
-				s.readPosInFrame = int(s.readOffset - frame.Offset)
-				break
-			}
-			s.newFrameOrErrCond.Wait()
-			frame = s.frameQueue.Head()
-		}
+		frame, err := s.waitForFrame()
 		s.mutex.Unlock()
 
 		if err != nil {
@@ -118,32 +148,120 @@ func (s *stream) Read(p []byte) (int, error) {
 			return bytesRead, fmt.Errorf("BUG: readPosInFrame (%d) > frame.DataLen (%d) in stream.Read", s.readPosInFrame, frame.DataLen())
 		}
 		copy(p[bytesRead:], frame.Data[s.readPosInFrame:])
-
-		s.readPosInFrame += m
 		bytesRead += m
-		s.readOffset += protocol.ByteCount(m)
 
-		// when a RST_STREAM was received, the was already informed about the final byteOffset for this stream
-		if !s.resetRemotely.Get() {
-			s.flowControlManager.AddBytesRead(s.streamID, protocol.ByteCount(m))
+		if fin := s.frameRead(frame, m); fin {
+			return bytesRead, io.EOF
 		}
-		s.onData() // so that a possible WINDOW_UPDATE is sent
+	}
 
-		if s.readPosInFrame >= int(frame.DataLen()) {
-			fin := frame.FinBit
-			s.mutex.Lock()
-			s.frameQueue.Pop()
-			s.mutex.Unlock()
-			if fin {
-				s.finishedReading.Set(true)
-				return bytesRead, io.EOF
+	return bytesRead, nil
+}
+
+// WriteTo implements io.WriterTo. It is not thread safe!
+// The data of received StreamFrames is passed to w directly, without copying it into an intermediate buffer.
+// It returns when the peer closed the stream, or when an error occurs.
+func (s *stream) WriteTo(w io.Writer) (int64, error) {
+	s.mutex.Lock()
+	err := s.err
+	s.mutex.Unlock()
+	if s.finishedReading.Get() {
+		return 0, nil
+	}
+	if s.cancelled.Get() || s.resetLocally.Get() {
+		return 0, err
+	}
+	if s.readClosed.Get() {
+		return 0, errStreamReadClosed
+	}
+
+	var written int64
+	for {
+		s.mutex.Lock()
+		frame, err := s.waitForFrame()
+		s.mutex.Unlock()
+
+		if err != nil {
+			return written, err
+		}
+
+		data := frame.Data[s.readPosInFrame:]
+		var n int
+		if len(data) > 0 {
+			n, err = w.Write(data)
+			if err == nil && n != len(data) {
+				err = io.ErrShortWrite
 			}
 		}
+		written += int64(n)
+
+		fin := s.frameRead(frame, n)
+		if err != nil {
+			return written, err
+		}
+		if fin {
+			return written, nil
+		}
 	}
+}
 
-	return bytesRead, nil
+// waitForFrame blocks until the next StreamFrame can be read, or an error occurs
+// it must be called with the mutex held
+func (s *stream) waitForFrame() (*frames.StreamFrame, error) {
+	frame := s.frameQueue.Head()
+	for {
+		// Stop waiting on errors
+		if s.resetLocally.Get() || s.cancelled.Get() {
+			return nil, s.err
+		}
+		if s.readClosed.Get() {
+			return nil, errStreamReadClosed
+		}
+		if frame != nil {
+			s.readPosInFrame = int(s.readOffset - frame.Offset)
+			return frame, nil
+		}
+		// the peer won't send any more data after resetting the stream
+		if s.resetRemotely.Get() {
+			return nil, s.err
+		}
+		if !s.readDeadline.IsZero() && !time.Now().Before(s.readDeadline) {
+			return nil, errDeadline
+		}
+		s.newFrameOrErrCond.Wait()
+		frame = s.frameQueue.Head()
+	}
+}
+
+// frameRead must be called after n bytes of the frame returned by waitForFrame were consumed
+// it returns true if the frame carried the FIN bit and was consumed completely
+func (s *stream) frameRead(frame *frames.StreamFrame, n int) bool {
+	s.readPosInFrame += n
+	s.readOffset += protocol.ByteCount(n)
+
+	// when a RST_STREAM was received, the was already informed about the final byteOffset for this stream
+	if !s.resetRemotely.Get() {
+		s.flowControlManager.AddBytesRead(s.streamID, protocol.ByteCount(n))
+	}
+	s.onData() // so that a possible WINDOW_UPDATE is sent
+
+	if s.readPosInFrame < int(frame.DataLen()) {
+		return false
+	}
+	s.mutex.Lock()
+	s.frameQueue.Pop()
+	s.mutex.Unlock()
+	// all data was copied out of the frame, so its buffer can be reused
+	frame.PutBack()
+	if frame.FinBit {
+		s.finishedReading.Set(true)
+		return true
+	}
+	return false
 }
 
+// Write blocks until all data was packed into packets, but doesn't wait for it to be acknowledged.
+// Instead of copying p at once, it is copied in chunks of at most MaxStreamSendBufferSize, such that the memory used by a stream stays bounded.
 func (s *stream) Write(p []byte) (int, error) {
 	if s.resetLocally.Get() {
 		return 0, s.err
@@ -156,16 +274,75 @@ func (s *stream) Write(p []byte) (int, error) {
 		return 0, s.err
 	}
 
-	if len(p) == 0 {
-		return 0, nil
+	var written int
+	for len(p) > 0 {
+		data := make([]byte, utils.Min(len(p), protocol.MaxStreamSendBufferSize))
+		copy(data, p)
+		n, err := s.write(data)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[len(data):]
+	}
+	return written, nil
+}
+
+// ReadFrom implements io.ReaderFrom. It is not thread safe!
+// The buffers that r is read into are handed to the stream directly, instead of being copied as in Write.
+// It returns when r returns io.EOF, or when an error occurs.
+func (s *stream) ReadFrom(r io.Reader) (int64, error) {
+	var written int64
+	for {
+		if s.resetLocally.Get() {
+			return written, s.err
+		}
+		s.mutex.Lock()
+		err := s.err
+		s.mutex.Unlock()
+		if err != nil {
+			return written, err
+		}
+
+		buf := make([]byte, protocol.StreamReadFromBufferSize)
+		n, rerr := r.Read(buf)
+		if n > 0 {
+			s.mutex.Lock()
+			m, err := s.write(buf[:n])
+			s.mutex.Unlock()
+			written += int64(m)
+			if err != nil {
+				return written, err
+			}
+		}
+		if rerr == io.EOF {
+			return written, nil
+		}
+		if rerr != nil {
+			return written, rerr
+		}
+	}
+}
+
+// write hands data to the stream framer, and blocks until all of it was sent
+// the stream takes ownership of data, it must not be modified by the caller afterwards
+// it must be called with the mutex held
+func (s *stream) write(data []byte) (int, error) {
+	if s.writeDeadlineExceeded() {
+		return 0, errDeadline
 	}
 
-	s.dataForWriting = make([]byte, len(p))
-	copy(s.dataForWriting, p)
+	s.dataForWriting = data
 
 	s.onData()
 
 	for s.dataForWriting != nil && s.err == nil {
+		if s.writeDeadlineExceeded() {
+			// don't send the rest of the data, and report how much of it was sent already
+			bytesWritten := len(data) - len(s.dataForWriting)
+			s.dataForWriting = nil
+			return bytesWritten, errDeadline
+		}
 		s.doneWritingOrErrCond.Wait()
 	}
 
@@ -173,7 +350,12 @@ func (s *stream) Write(p []byte) (int, error) {
 		return 0, s.err
 	}
 
-	return len(p), nil
+	return len(data), nil
+}
+
+// writeDeadlineExceeded must be called with the mutex held
+func (s *stream) writeDeadlineExceeded() bool {
+	return !s.writeDeadline.IsZero() && !time.Now().Before(s.writeDeadline)
 }
 
 func (s *stream) lenOfDataForWriting() protocol.ByteCount {
@@ -210,13 +392,120 @@ func (s *stream) getDataForWriting(maxBytes protocol.ByteCount) []byte {
 	return ret
 }
 
+// SetReadDeadline implements net.Conn-like read deadlines
+func (s *stream) SetReadDeadline(t time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.readDeadline = t
+	s.stopReadDeadlineTimer()
+	if !t.IsZero() {
+		s.readDeadlineTimer = time.AfterFunc(t.Sub(time.Now()), func() {
+			s.mutex.Lock()
+			s.newFrameOrErrCond.Signal()
+			s.mutex.Unlock()
+		})
+	}
+	// wake up a blocked Read, so that it checks the new deadline
+	s.newFrameOrErrCond.Signal()
+	return nil
+}
+
+// SetWriteDeadline implements net.Conn-like write deadlines
+func (s *stream) SetWriteDeadline(t time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.writeDeadline = t
+	s.stopWriteDeadlineTimer()
+	if !t.IsZero() {
+		s.writeDeadlineTimer = time.AfterFunc(t.Sub(time.Now()), func() {
+			s.mutex.Lock()
+			s.doneWritingOrErrCond.Signal()
+			s.mutex.Unlock()
+		})
+	}
+	// wake up a blocked Write, so that it checks the new deadline
+	s.doneWritingOrErrCond.Signal()
+	return nil
+}
+
+// stopReadDeadlineTimer must be called with the mutex held
+func (s *stream) stopReadDeadlineTimer() {
+	if s.readDeadlineTimer != nil {
+		s.readDeadlineTimer.Stop()
+		s.readDeadlineTimer = nil
+	}
+}
+
+// stopWriteDeadlineTimer must be called with the mutex held
+func (s *stream) stopWriteDeadlineTimer() {
+	if s.writeDeadlineTimer != nil {
+		s.writeDeadlineTimer.Stop()
+		s.writeDeadlineTimer = nil
+	}
+}
+
+// SetDeadline sets both the read and the write deadline
+func (s *stream) SetDeadline(t time.Time) error {
+	_ = s.SetReadDeadline(t)  // SetReadDeadline never errors
+	_ = s.SetWriteDeadline(t) // SetWriteDeadline never errors
+	return nil
+}
+
 // Close implements io.Closer
+// It only closes the write direction of the stream.
 func (s *stream) Close() error {
+	return s.CloseWrite()
+}
+
+// CloseWrite closes the write direction of the stream, i.e. a FIN is sent after all data that was written
+func (s *stream) CloseWrite() error {
 	s.finishedWriting.Set(true)
+	s.mutex.Lock()
+	s.stopWriteDeadlineTimer()
+	s.mutex.Unlock()
 	s.onData()
 	return nil
 }
 
+// CloseRead closes the read direction of the stream
+// All data that is queued or received afterwards is discarded, but still counted as read, so that the peer can continue sending until it closes the stream.
+func (s *stream) CloseRead() error {
+	s.mutex.Lock()
+	if s.readClosed.Get() {
+		s.mutex.Unlock()
+		return nil
+	}
+	s.readClosed.Set(true)
+	s.newFrameOrErrCond.Signal()
+	s.discardReceivedData()
+	s.mutex.Unlock()
+	s.onData() // so that a possible WINDOW_UPDATE is sent
+	return nil
+}
+
+// discardReceivedData pops all frames that can be read from the frameQueue, and counts their data as read
+// it must be called with the mutex held
+func (s *stream) discardReceivedData() {
+	for {
+		frame := s.frameQueue.Pop()
+		if frame == nil {
+			return
+		}
+		n := frame.Offset + frame.DataLen() - s.readOffset
+		s.readOffset += n
+		s.readPosInFrame = 0
+		// when a RST_STREAM was received, the flow controller was already informed about the final byteOffset
+		if !s.resetRemotely.Get() {
+			s.flowControlManager.AddBytesRead(s.streamID, n)
+		}
+		frame.PutBack()
+		if frame.FinBit {
+			s.finishedReading.Set(true)
+			return
+		}
+	}
+}
+
 func (s *stream) shouldSendReset() bool {
 	if s.rstSent.Get() {
 		return false
@@ -242,14 +531,30 @@ func (s *stream) AddStreamFrame(frame *frames.StreamFrame) error {
 	if err != nil {
 		return err
 	}
+	// the data will never be read, but it still counts towards connection-level flow control
+	if s.resetLocally.Get() {
+		frame.PutBack()
+		return s.flowControlManager.DiscardUnreadData(s.streamID)
+	}
 
 	s.mutex.Lock()
-	defer s.mutex.Unlock()
 	err = s.frameQueue.Push(frame)
-	if err != nil && err != errDuplicateStreamData {
+	if err == errDuplicateStreamData {
+		frame.PutBack()
+	} else if err != nil {
+		s.mutex.Unlock()
 		return err
 	}
+	// the application won't read the data, but it still counts towards flow control
+	readClosed := s.readClosed.Get()
+	if readClosed {
+		s.discardReceivedData()
+	}
 	s.newFrameOrErrCond.Signal()
+	s.mutex.Unlock()
+	if readClosed {
+		s.onData() // so that a possible WINDOW_UPDATE is sent
+	}
 	return nil
 }
 
@@ -263,33 +568,57 @@ func (s *stream) CloseRemote(offset protocol.ByteCount) {
 func (s *stream) Cancel(err error) {
 	s.mutex.Lock()
 	s.cancelled.Set(true)
+	s.ctxCancel()
 	// errors must not be changed!
 	if s.err == nil {
 		s.err = err
 		s.newFrameOrErrCond.Signal()
 		s.doneWritingOrErrCond.Signal()
 	}
+	s.stopReadDeadlineTimer()
+	s.stopWriteDeadlineTimer()
 	s.mutex.Unlock()
 }
 
 // resets the stream locally
+// The RST_STREAM carries the error code of err, if err is a qerr.ErrorCode or a *qerr.QuicError.
 func (s *stream) Reset(err error) {
 	if s.resetLocally.Get() {
 		return
 	}
+	if err == nil {
+		err = errStreamResetLocally
+	}
 	s.mutex.Lock()
 	s.resetLocally.Set(true)
+	s.ctxCancel()
 	// errors must not be changed!
 	if s.err == nil {
 		s.err = err
 		s.newFrameOrErrCond.Signal()
 		s.doneWritingOrErrCond.Signal()
 	}
+	s.stopReadDeadlineTimer()
+	s.stopWriteDeadlineTimer()
+	// the application won't read the data that is still queued
+	s.frameQueue = newStreamFrameSorter()
 	if s.shouldSendReset() {
-		s.onReset(s.streamID, s.writeOffset)
+		s.onReset(s.streamID, s.writeOffset, rstErrorCode(err))
 		s.rstSent.Set(true)
 	}
 	s.mutex.Unlock()
+	s.flowControlManager.DiscardUnreadData(s.streamID)
+	s.onData() // so that a possible WINDOW_UPDATE is sent
+}
+
+func rstErrorCode(err error) qerr.ErrorCode {
+	switch e := err.(type) {
+	case qerr.ErrorCode:
+		return e
+	case *qerr.QuicError:
+		return e.ErrorCode
+	}
+	return 0
 }
 
 // resets the stream remotely
@@ -299,13 +628,17 @@ func (s *stream) RegisterRemoteError(err error) {
 	}
 	s.mutex.Lock()
 	s.resetRemotely.Set(true)
+	s.ctxCancel()
 	// errors must not be changed!
 	if s.err == nil {
 		s.err = err
+		s.newFrameOrErrCond.Signal()
 		s.doneWritingOrErrCond.Signal()
 	}
+	s.stopReadDeadlineTimer()
+	s.stopWriteDeadlineTimer()
 	if s.shouldSendReset() {
-		s.onReset(s.streamID, s.writeOffset)
+		s.onReset(s.streamID, s.writeOffset, 0)
 		s.rstSent.Set(true)
 	}
 	s.mutex.Unlock()
@@ -323,6 +656,23 @@ func (s *stream) finished() bool {
 		(s.finishedWriteAndSentFin() && s.resetRemotely.Get())
 }
 
+func (s *stream) Context() context.Context {
+	return s.ctx
+}
+
 func (s *stream) StreamID() protocol.StreamID {
 	return s.streamID
 }
+
+// SetPriority sets the priority used when scheduling the data of multiple streams
+func (s *stream) SetPriority(p Priority) {
+	s.mutex.Lock()
+	s.priority = p
+	s.mutex.Unlock()
+}
+
+func (s *stream) getPriority() Priority {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.priority
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/lucas-clemente/quic-go/frames"
 	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/qerr"
 	"github.com/lucas-clemente/quic-go/utils"
 )
 
@@ -12,12 +13,19 @@ type streamFrameSorter struct {
 	queuedFrames map[protocol.ByteCount]*frames.StreamFrame
 	readPosition protocol.ByteCount
 	gaps         *utils.ByteIntervalList
+
+	// the FIN bit is tracked separately from the queued frames, since the frame carrying it might be cut or dropped as a duplicate
+	finReceived bool
+	finalOffset protocol.ByteCount
+	// finFrame is returned by Head once all data up to the final offset was popped
+	finFrame *frames.StreamFrame
 }
 
 var (
 	errTooManyGapsInReceivedStreamData = errors.New("Too many gaps in received StreamFrame data")
 	errDuplicateStreamData             = errors.New("Duplicate Stream Data")
 	errEmptyStreamData                 = errors.New("Stream Data empty")
+	errStreamDataAfterFin              = qerr.Error(qerr.StreamDataAfterTermination, "received data after the final offset of the stream")
 )
 
 func newStreamFrameSorter() *streamFrameSorter {
@@ -30,11 +38,20 @@ func newStreamFrameSorter() *streamFrameSorter {
 }
 
 func (s *streamFrameSorter) Push(frame *frames.StreamFrame) error {
-	if frame.DataLen() == 0 {
-		if frame.FinBit {
-			s.queuedFrames[frame.Offset] = frame
+	maxOffset := frame.Offset + frame.DataLen()
+	if frame.FinBit {
+		if err := s.setFinalOffset(maxOffset); err != nil {
+			return err
+		}
+		if frame.DataLen() == 0 {
+			s.finFrame = frame
 			return nil
 		}
+		s.finFrame = &frames.StreamFrame{StreamID: frame.StreamID, Offset: maxOffset, FinBit: true}
+	} else if s.finReceived && maxOffset > s.finalOffset {
+		return errStreamDataAfterFin
+	}
+	if frame.DataLen() == 0 {
 		return errEmptyStreamData
 	}
 
@@ -88,8 +105,8 @@ func (s *streamFrameSorter) Push(frame *frames.StreamFrame) error {
 		if end <= nextEndGap.Value.Start {
 			break
 		}
-		// delete queued frames completely covered by the current frame
-		delete(s.queuedFrames, endGap.Value.End)
+		// the current frame completely covers the data received between the two gaps
+		s.replaceQueuedFrames(frame, endGap.Value.End, nextEndGap.Value.Start)
 		endGap = nextEndGap
 	}
 
@@ -143,6 +160,21 @@ func (s *streamFrameSorter) Push(frame *frames.StreamFrame) error {
 	return nil
 }
 
+// replaceQueuedFrames deletes the queued frames between start and end, which are covered by frame
+// The data that was received first is kept, by copying it into frame.
+func (s *streamFrameSorter) replaceQueuedFrames(frame *frames.StreamFrame, start, end protocol.ByteCount) {
+	for offset := start; offset < end; {
+		queuedFrame, ok := s.queuedFrames[offset]
+		if !ok {
+			return
+		}
+		copy(frame.Data[offset-frame.Offset:], queuedFrame.Data)
+		delete(s.queuedFrames, offset)
+		offset += queuedFrame.DataLen()
+		queuedFrame.PutBack()
+	}
+}
+
 func (s *streamFrameSorter) Pop() *frames.StreamFrame {
 	frame := s.Head()
 	if frame != nil {
@@ -152,10 +184,34 @@ func (s *streamFrameSorter) Pop() *frames.StreamFrame {
 	return frame
 }
 
+// Head returns the frame at the current read position
+// The FIN bit is set on the frame that ends at the final offset, no matter which frame carried the FIN bit when it was received.
 func (s *streamFrameSorter) Head() *frames.StreamFrame {
 	frame, ok := s.queuedFrames[s.readPosition]
 	if ok {
+		frame.FinBit = s.finReceived && s.readPosition+frame.DataLen() == s.finalOffset
 		return frame
 	}
+	if s.finReceived && s.readPosition == s.finalOffset {
+		return s.finFrame
+	}
+	return nil
+}
+
+// setFinalOffset is called when a frame with the FIN bit is received
+// the final offset must not change, and no data may have been received beyond it
+func (s *streamFrameSorter) setFinalOffset(offset protocol.ByteCount) error {
+	if s.finReceived {
+		if offset != s.finalOffset {
+			return errStreamDataAfterFin
+		}
+		return nil
+	}
+	// the last gap starts at the highest offset received so far
+	if offset < s.gaps.Back().Value.Start {
+		return errStreamDataAfterFin
+	}
+	s.finReceived = true
+	s.finalOffset = offset
 	return nil
 }
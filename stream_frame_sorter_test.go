@@ -94,6 +94,75 @@ var _ = Describe("StreamFrame sorter", func() {
 				Expect(s.Pop()).To(Equal(f1))
 				Expect(s.Pop()).To(Equal(f2))
 			})
+
+			It("sets the FinBit on the last frame, if the FIN was received before the data", func() {
+				err := s.Push(&frames.StreamFrame{Offset: 6, FinBit: true})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(s.Head()).To(BeNil())
+				err = s.Push(&frames.StreamFrame{Offset: 3, Data: []byte("bar")})
+				Expect(err).ToNot(HaveOccurred())
+				err = s.Push(&frames.StreamFrame{Data: []byte("foo")})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(s.Pop().FinBit).To(BeFalse())
+				f := s.Pop()
+				Expect(f.Data).To(Equal([]byte("bar")))
+				Expect(f.FinBit).To(BeTrue())
+			})
+
+			It("doesn't set the FinBit on a frame that was cut, if it doesn't end at the final offset anymore", func() {
+				err := s.Push(&frames.StreamFrame{Offset: 3, Data: []byte("bar"), FinBit: true})
+				Expect(err).ToNot(HaveOccurred())
+				err = s.Push(&frames.StreamFrame{Data: []byte("foobar"), FinBit: true})
+				Expect(err).ToNot(HaveOccurred())
+				f := s.Pop()
+				Expect(f.Data).To(Equal([]byte("foo")))
+				Expect(f.FinBit).To(BeFalse())
+				f = s.Pop()
+				Expect(f.Data).To(Equal([]byte("bar")))
+				Expect(f.FinBit).To(BeTrue())
+			})
+
+			It("doesn't lose the FinBit if it is received on a duplicate frame", func() {
+				err := s.Push(&frames.StreamFrame{Data: []byte("foobar")})
+				Expect(err).ToNot(HaveOccurred())
+				err = s.Push(&frames.StreamFrame{Offset: 3, Data: []byte("bar"), FinBit: true})
+				Expect(err).To(MatchError(errDuplicateStreamData))
+				Expect(s.Pop().FinBit).To(BeTrue())
+			})
+
+			It("returns a frame with the FinBit after all data was popped, if the FIN was received on a duplicate frame", func() {
+				err := s.Push(&frames.StreamFrame{Data: []byte("foobar")})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(s.Pop().FinBit).To(BeFalse())
+				err = s.Push(&frames.StreamFrame{Offset: 3, Data: []byte("bar"), FinBit: true})
+				Expect(err).To(MatchError(errDuplicateStreamData))
+				f := s.Head()
+				Expect(f).ToNot(BeNil())
+				Expect(f.Offset).To(Equal(protocol.ByteCount(6)))
+				Expect(f.FinBit).To(BeTrue())
+				Expect(f.Data).To(BeEmpty())
+			})
+
+			It("errors when receiving data after the final offset", func() {
+				err := s.Push(&frames.StreamFrame{Data: []byte("foo"), FinBit: true})
+				Expect(err).ToNot(HaveOccurred())
+				err = s.Push(&frames.StreamFrame{Offset: 3, Data: []byte("bar")})
+				Expect(err).To(MatchError(errStreamDataAfterFin))
+			})
+
+			It("errors when the final offset changes", func() {
+				err := s.Push(&frames.StreamFrame{Offset: 3, FinBit: true})
+				Expect(err).ToNot(HaveOccurred())
+				err = s.Push(&frames.StreamFrame{Offset: 4, FinBit: true})
+				Expect(err).To(MatchError(errStreamDataAfterFin))
+			})
+
+			It("errors when receiving a FIN below the highest offset received", func() {
+				err := s.Push(&frames.StreamFrame{Data: []byte("foobar")})
+				Expect(err).ToNot(HaveOccurred())
+				err = s.Push(&frames.StreamFrame{Offset: 3, FinBit: true})
+				Expect(err).To(MatchError(errStreamDataAfterFin))
+			})
 		})
 
 		Context("Gap handling", func() {
@@ -346,7 +415,8 @@ var _ = Describe("StreamFrame sorter", func() {
 					Expect(err).ToNot(HaveOccurred())
 					Expect(s.queuedFrames).ToNot(HaveKey(protocol.ByteCount(5)))
 					Expect(s.queuedFrames).To(HaveKey(protocol.ByteCount(2)))
-					Expect(s.queuedFrames[2].Data).To(Equal([]byte("1234567890")))
+					// the data received first is kept
+					Expect(s.queuedFrames[2].Data).To(Equal([]byte("1231234590")))
 					checkGaps([]utils.ByteInterval{
 						{Start: 0, End: 2},
 						{Start: 12, End: 15},
@@ -365,7 +435,7 @@ var _ = Describe("StreamFrame sorter", func() {
 					Expect(err).ToNot(HaveOccurred())
 					Expect(s.queuedFrames).ToNot(HaveKey(protocol.ByteCount(5)))
 					Expect(s.queuedFrames).To(HaveKey(protocol.ByteCount(2)))
-					Expect(s.queuedFrames[2].Data).To(Equal([]byte("1234567890123")))
+					Expect(s.queuedFrames[2].Data).To(Equal([]byte("1231234590123")))
 					Expect(s.queuedFrames[2].Data).To(HaveCap(13))
 					checkGaps([]utils.ByteInterval{
 						{Start: 0, End: 2},
@@ -404,7 +474,7 @@ var _ = Describe("StreamFrame sorter", func() {
 					Expect(s.queuedFrames).ToNot(HaveKey(protocol.ByteCount(15)))
 					Expect(s.queuedFrames).To(HaveKey(protocol.ByteCount(25)))
 					Expect(s.queuedFrames).To(HaveKey(protocol.ByteCount(2)))
-					Expect(s.queuedFrames[2].Data).To(Equal(bytes.Repeat([]byte{'e'}, 23)))
+					Expect(s.queuedFrames[2].Data).To(Equal([]byte("eee12345eeeee12345eeeee")))
 					Expect(s.queuedFrames[2].Data).To(HaveCap(23))
 					checkGaps([]utils.ByteInterval{
 						{Start: 0, End: 2},
@@ -424,7 +494,7 @@ var _ = Describe("StreamFrame sorter", func() {
 					Expect(s.queuedFrames).ToNot(HaveKey(protocol.ByteCount(15)))
 					Expect(s.queuedFrames).To(HaveKey(protocol.ByteCount(25)))
 					Expect(s.queuedFrames).To(HaveKey(protocol.ByteCount(10)))
-					Expect(s.queuedFrames[10].Data).To(Equal(bytes.Repeat([]byte{'d'}, 15)))
+					Expect(s.queuedFrames[10].Data).To(Equal([]byte("ddddd12345ddddd")))
 					Expect(s.queuedFrames[10].Data).To(HaveCap(15))
 					checkGaps([]utils.ByteInterval{
 						{Start: 0, End: 5},
@@ -443,7 +513,7 @@ var _ = Describe("StreamFrame sorter", func() {
 					Expect(s.queuedFrames).To(HaveKey(protocol.ByteCount(1)))
 					Expect(s.queuedFrames).To(HaveKey(protocol.ByteCount(15)))
 					Expect(s.queuedFrames).ToNot(HaveKey(protocol.ByteCount(5)))
-					Expect(s.queuedFrames[1].Data).To(Equal(f.Data))
+					Expect(s.queuedFrames[1].Data).To(Equal([]byte("ffff12345fffff")))
 					checkGaps([]utils.ByteInterval{
 						{Start: 0, End: 1},
 						{Start: 20, End: 25},
@@ -461,7 +531,7 @@ var _ = Describe("StreamFrame sorter", func() {
 					Expect(err).ToNot(HaveOccurred())
 					Expect(s.queuedFrames).To(HaveLen(1))
 					Expect(s.queuedFrames).To(HaveKey(protocol.ByteCount(0)))
-					Expect(s.queuedFrames[0].Data).To(Equal(f.Data))
+					Expect(s.queuedFrames[0].Data).To(Equal([]byte("fffff12345fffff12345fffff12345ff")))
 					checkGaps([]utils.ByteInterval{
 						{Start: 32, End: protocol.MaxByteCount},
 					})
@@ -503,6 +573,25 @@ var _ = Describe("StreamFrame sorter", func() {
 						{Start: 30, End: protocol.MaxByteCount},
 					})
 				})
+
+				It("deletes all frames covered by a frame", func() {
+					err := s.Push(&frames.StreamFrame{Offset: 30, Data: []byte("abc")})
+					Expect(err).ToNot(HaveOccurred())
+					err = s.Push(&frames.StreamFrame{Offset: 33, Data: []byte("def")})
+					Expect(err).ToNot(HaveOccurred())
+					// 20 to 40, covering the frames at 25, 30 and 33
+					err = s.Push(&frames.StreamFrame{Offset: 20, Data: bytes.Repeat([]byte{'f'}, 20)})
+					Expect(err).ToNot(HaveOccurred())
+					Expect(s.queuedFrames).ToNot(HaveKey(protocol.ByteCount(25)))
+					Expect(s.queuedFrames).ToNot(HaveKey(protocol.ByteCount(30)))
+					Expect(s.queuedFrames).ToNot(HaveKey(protocol.ByteCount(33)))
+					Expect(s.queuedFrames[20].Data).To(Equal([]byte("fffff12345abcdefffff")))
+					checkGaps([]utils.ByteInterval{
+						{Start: 0, End: 5},
+						{Start: 10, End: 15},
+						{Start: 40, End: protocol.MaxByteCount},
+					})
+				})
 			})
 
 			Context("duplicate data", func() {
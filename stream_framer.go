@@ -7,8 +7,13 @@ import (
 	"github.com/lucas-clemente/quic-go/utils"
 )
 
+// maxStreamFrameHeaderLen is the maximum length of the header of a STREAM frame with the data length present:
+// 1 byte type, 4 bytes stream ID, 8 bytes offset and 2 bytes data length
+const maxStreamFrameHeaderLen protocol.ByteCount = 1 + 4 + 8 + 2
+
 type streamFramer struct {
 	streamsMap *streamsMap
+	scheduling StreamScheduling
 
 	flowControlManager flowcontrol.FlowControlManager
 
@@ -16,9 +21,10 @@ type streamFramer struct {
 	blockedFrameQueue   []*frames.BlockedFrame
 }
 
-func newStreamFramer(streamsMap *streamsMap, flowControlManager flowcontrol.FlowControlManager) *streamFramer {
+func newStreamFramer(streamsMap *streamsMap, flowControlManager flowcontrol.FlowControlManager, scheduling StreamScheduling) *streamFramer {
 	return &streamFramer{
 		streamsMap:         streamsMap,
+		scheduling:         scheduling,
 		flowControlManager: flowControlManager,
 	}
 }
@@ -45,6 +51,35 @@ func (f *streamFramer) HasFramesForRetransmission() bool {
 	return len(f.retransmissionQueue) > 0
 }
 
+// HasCryptoStreamFrame says if there's data queued on the crypto stream
+func (f *streamFramer) HasCryptoStreamFrame() bool {
+	cryptoStream, _ := f.streamsMap.GetOrOpenStream(1)
+	return cryptoStream != nil && cryptoStream.lenOfDataForWriting() > 0
+}
+
+// PopCryptoStreamFrame pops a StreamFrame containing data queued on the crypto stream
+func (f *streamFramer) PopCryptoStreamFrame(maxLen protocol.ByteCount) *frames.StreamFrame {
+	cryptoStream, _ := f.streamsMap.GetOrOpenStream(1)
+	if cryptoStream == nil {
+		return nil
+	}
+	frame := &frames.StreamFrame{
+		StreamID: cryptoStream.streamID,
+		Offset:   cryptoStream.writeOffset,
+	}
+	frameHeaderBytes, _ := frame.MinLength(protocol.VersionWhatever) // can never error
+	if frameHeaderBytes >= maxLen {
+		return nil
+	}
+	sendWindowSize, _ := f.flowControlManager.SendWindowSize(cryptoStream.streamID)
+	frame.Data = cryptoStream.getDataForWriting(utils.MinByteCount(maxLen-frameHeaderBytes, sendWindowSize))
+	if len(frame.Data) == 0 {
+		return nil
+	}
+	f.flowControlManager.AddBytesSent(cryptoStream.streamID, frame.DataLen())
+	return frame
+}
+
 func (f *streamFramer) maybePopFramesForRetransmission(maxLen protocol.ByteCount) (res []*frames.StreamFrame, currentLen protocol.ByteCount) {
 	for len(f.retransmissionQueue) > 0 {
 		frame := f.retransmissionQueue[0]
@@ -75,6 +110,14 @@ func (f *streamFramer) maybePopNormalFrames(maxBytes protocol.ByteCount) (res []
 	frame := &frames.StreamFrame{DataLenPresent: true}
 	var currentLen protocol.ByteCount
 
+	// when interleaving, every stream gets an equal share of the packet
+	maxLenPerStream := maxBytes
+	if f.scheduling == StreamSchedulingInterleaved {
+		if n := f.numStreamsWithData(); n > 1 {
+			maxLenPerStream = utils.MaxByteCount(maxBytes/protocol.ByteCount(n), maxStreamFrameHeaderLen+1)
+		}
+	}
+
 	fn := func(s *stream) (bool, error) {
 		if s == nil {
 			return true, nil
@@ -87,7 +130,7 @@ func (f *streamFramer) maybePopNormalFrames(maxBytes protocol.ByteCount) (res []
 		if currentLen+frameHeaderBytes > maxBytes {
 			return false, nil // theoretically, we could find another stream that fits, but this is quite unlikely, so we stop here
 		}
-		maxLen := maxBytes - currentLen - frameHeaderBytes
+		maxLen := utils.MinByteCount(maxBytes-currentLen, maxLenPerStream) - frameHeaderBytes
 
 		var sendWindowSize protocol.ByteCount
 		if s.lenOfDataForWriting() != 0 {
@@ -116,18 +159,22 @@ func (f *streamFramer) maybePopNormalFrames(maxBytes protocol.ByteCount) (res []
 		f.flowControlManager.AddBytesSent(s.streamID, protocol.ByteCount(len(data)))
 
 		// Finally, check if we are now FC blocked and should queue a BLOCKED frame
-		if f.flowControlManager.RemainingConnectionWindowSize() == 0 {
+		// The flow controller only reports every offset once, so that we don't send a BLOCKED frame in every packet
+		if blocked, _ := f.flowControlManager.IsNewlyBlocked(0); blocked {
 			// We are now connection-level FC blocked
 			f.blockedFrameQueue = append(f.blockedFrameQueue, &frames.BlockedFrame{StreamID: 0})
-		} else if !frame.FinBit && sendWindowSize-frame.DataLen() == 0 {
-			// We are now stream-level FC blocked
-			f.blockedFrameQueue = append(f.blockedFrameQueue, &frames.BlockedFrame{StreamID: s.StreamID()})
+		} else if !frame.FinBit {
+			if blocked, _ := f.flowControlManager.IsNewlyBlocked(s.streamID); blocked {
+				// We are now stream-level FC blocked
+				f.blockedFrameQueue = append(f.blockedFrameQueue, &frames.BlockedFrame{StreamID: s.StreamID()})
+			}
 		}
 
 		res = append(res, frame)
 		currentLen += frameHeaderBytes + frame.DataLen()
 
-		if currentLen == maxBytes {
+		// stop before visiting the next stream if its data might not fit, so that it doesn't lose its turn
+		if maxBytes-currentLen <= maxStreamFrameHeaderLen {
 			return false, nil
 		}
 
@@ -140,6 +187,27 @@ func (f *streamFramer) maybePopNormalFrames(maxBytes protocol.ByteCount) (res []
 	return
 }
 
+// numStreamsWithData counts the streams with data (or a FIN) to send, among the streams with the highest priority
+func (f *streamFramer) numStreamsWithData() int {
+	var n int
+	var priority Priority
+	f.streamsMap.Iterate(func(s *stream) (bool, error) {
+		if s == nil || s.streamID == 1 || (s.lenOfDataForWriting() == 0 && !s.shouldSendFin()) {
+			return true, nil
+		}
+		p := s.getPriority()
+		if n == 0 || p > priority {
+			n = 0
+			priority = p
+		}
+		if p == priority {
+			n++
+		}
+		return true, nil
+	})
+	return n
+}
+
 // maybeSplitOffFrame removes the first n bytes and returns them as a separate frame. If n >= len(frame), nil is returned and nothing is modified.
 func maybeSplitOffFrame(frame *frames.StreamFrame, n protocol.ByteCount) *frames.StreamFrame {
 	if n >= frame.DataLen() {
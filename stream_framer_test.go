@@ -3,8 +3,11 @@ package quic
 import (
 	"bytes"
 
+	"github.com/lucas-clemente/quic-go/congestion"
+	"github.com/lucas-clemente/quic-go/flowcontrol"
 	"github.com/lucas-clemente/quic-go/frames"
 	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/utils"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -31,7 +34,7 @@ var _ = Describe("Stream Framer", func() {
 		stream1 = &stream{streamID: 10}
 		stream2 = &stream{streamID: 11}
 
-		streamsMap = newStreamsMap(nil, protocol.PerspectiveServer, &mockConnectionParametersManager{})
+		streamsMap = newStreamsMap(nil, nil, protocol.PerspectiveServer, &mockConnectionParametersManager{}, 0)
 		streamsMap.putStream(stream1)
 		streamsMap.putStream(stream2)
 
@@ -40,7 +43,7 @@ var _ = Describe("Stream Framer", func() {
 		fcm.sendWindowSizes[stream2.streamID] = protocol.MaxByteCount
 		fcm.sendWindowSizes[retransmittedFrame1.StreamID] = protocol.MaxByteCount
 		fcm.sendWindowSizes[retransmittedFrame2.StreamID] = protocol.MaxByteCount
-		framer = newStreamFramer(streamsMap, fcm)
+		framer = newStreamFramer(streamsMap, fcm, StreamSchedulingRoundRobin)
 	})
 
 	It("says if it has retransmissions", func() {
@@ -136,6 +139,29 @@ var _ = Describe("Stream Framer", func() {
 			Expect(fs[0].StreamID).ToNot(Equal(firstStreamID))
 		})
 
+		It("sends the data of the stream with the higher priority first", func() {
+			stream1.dataForWriting = bytes.Repeat([]byte("f"), 100)
+			stream2.dataForWriting = bytes.Repeat([]byte("e"), 100)
+			stream2.SetPriority(1)
+			var streamIDs []protocol.StreamID
+			var dataLen protocol.ByteCount
+			for {
+				fs := framer.PopStreamFrames(30)
+				if len(fs) == 0 {
+					break
+				}
+				for _, f := range fs {
+					if len(streamIDs) == 0 || streamIDs[len(streamIDs)-1] != f.StreamID {
+						streamIDs = append(streamIDs, f.StreamID)
+					}
+					dataLen += f.DataLen()
+				}
+			}
+			// all data of stream2 is sent before stream1 gets its turn
+			Expect(streamIDs).To(Equal([]protocol.StreamID{stream2.streamID, stream1.streamID}))
+			Expect(dataLen).To(BeEquivalentTo(200))
+		})
+
 		Context("splitting of frames", func() {
 			It("splits off nothing", func() {
 				f := &frames.StreamFrame{
@@ -270,6 +296,124 @@ var _ = Describe("Stream Framer", func() {
 				Expect(fs[0].FinBit).To(BeTrue())
 			})
 		})
+
+		Context("scheduling", func() {
+			var stream3 *stream
+
+			BeforeEach(func() {
+				stream3 = &stream{streamID: 12}
+				streamsMap.putStream(stream3)
+				fcm.sendWindowSizes[stream3.streamID] = protocol.MaxByteCount
+				for _, s := range []*stream{stream1, stream2, stream3} {
+					s.dataForWriting = bytes.Repeat([]byte("f"), 10000)
+				}
+			})
+
+			// popFrames pops frames for numPops send opportunities of maxBytes each, and returns how many bytes were sent on every stream
+			popFrames := func(numPops int, maxBytes protocol.ByteCount) map[protocol.StreamID]protocol.ByteCount {
+				bytesSent := make(map[protocol.StreamID]protocol.ByteCount)
+				for i := 0; i < numPops; i++ {
+					for _, f := range framer.PopStreamFrames(maxBytes) {
+						bytesSent[f.StreamID] += f.DataLen()
+					}
+				}
+				return bytesSent
+			}
+
+			expectFairShares := func(bytesSent map[protocol.StreamID]protocol.ByteCount) {
+				Expect(bytesSent).To(HaveLen(3))
+				var total protocol.ByteCount
+				for _, n := range bytesSent {
+					total += n
+				}
+				for _, n := range bytesSent {
+					Expect(n).To(BeNumerically("~", total/3, total/30))
+				}
+			}
+
+			It("sends roughly equal amounts of data on saturated streams, when using round-robin scheduling", func() {
+				expectFairShares(popFrames(30, 100))
+			})
+
+			It("sends roughly equal amounts of data on saturated streams, when using interleaved scheduling", func() {
+				framer.scheduling = StreamSchedulingInterleaved
+				expectFairShares(popFrames(30, 100))
+			})
+
+			It("sends data of all streams in every packet, when using interleaved scheduling", func() {
+				framer.scheduling = StreamSchedulingInterleaved
+				for i := 0; i < 10; i++ {
+					fs := framer.PopStreamFrames(100)
+					Expect(fs).To(HaveLen(3))
+					streamIDs := map[protocol.StreamID]bool{}
+					for _, f := range fs {
+						streamIDs[f.StreamID] = true
+					}
+					Expect(streamIDs).To(HaveLen(3))
+				}
+			})
+
+			It("only splits the packet between the streams with the highest priority, when using interleaved scheduling", func() {
+				framer.scheduling = StreamSchedulingInterleaved
+				stream3.SetPriority(1)
+				fs := framer.PopStreamFrames(100)
+				Expect(fs).To(HaveLen(1))
+				Expect(fs[0].StreamID).To(Equal(stream3.streamID))
+			})
+		})
+	})
+
+	Context("crypto stream", func() {
+		var cryptoStream *stream
+
+		BeforeEach(func() {
+			cryptoStream = &stream{streamID: 1}
+			streamsMap.putStream(cryptoStream)
+			fcm.sendWindowSizes[cryptoStream.streamID] = protocol.MaxByteCount
+		})
+
+		It("says if there's data queued on the crypto stream", func() {
+			Expect(framer.HasCryptoStreamFrame()).To(BeFalse())
+			cryptoStream.dataForWriting = []byte("foobar")
+			Expect(framer.HasCryptoStreamFrame()).To(BeTrue())
+		})
+
+		It("doesn't consider data queued on other streams", func() {
+			stream1.dataForWriting = []byte("foobar")
+			Expect(framer.HasCryptoStreamFrame()).To(BeFalse())
+		})
+
+		It("pops a frame for the crypto stream", func() {
+			cryptoStream.writeOffset = 10
+			cryptoStream.dataForWriting = []byte("foobar")
+			stream1.dataForWriting = []byte("raboof")
+			frame := framer.PopCryptoStreamFrame(1000)
+			Expect(frame.StreamID).To(Equal(protocol.StreamID(1)))
+			Expect(frame.Offset).To(Equal(protocol.ByteCount(10)))
+			Expect(frame.Data).To(Equal([]byte("foobar")))
+			Expect(fcm.bytesSent).To(Equal(protocol.ByteCount(6)))
+			Expect(framer.HasCryptoStreamFrame()).To(BeFalse())
+			Expect(stream1.dataForWriting).To(Equal([]byte("raboof")))
+		})
+
+		It("returns nil if there's no data queued on the crypto stream", func() {
+			Expect(framer.PopCryptoStreamFrame(1000)).To(BeNil())
+		})
+
+		It("respects the maximum length", func() {
+			cryptoStream.dataForWriting = bytes.Repeat([]byte{'f'}, 100)
+			frame := framer.PopCryptoStreamFrame(50)
+			frameHeaderLen, _ := frame.MinLength(protocol.VersionWhatever)
+			Expect(frameHeaderLen + frame.DataLen()).To(Equal(protocol.ByteCount(50)))
+			Expect(framer.HasCryptoStreamFrame()).To(BeTrue())
+		})
+
+		It("respects the flow control window", func() {
+			fcm.sendWindowSizes[cryptoStream.streamID] = 3
+			cryptoStream.dataForWriting = []byte("foobar")
+			frame := framer.PopCryptoStreamFrame(1000)
+			Expect(frame.Data).To(Equal([]byte("foo")))
+		})
 	})
 
 	Context("flow control", func() {
@@ -373,6 +517,34 @@ var _ = Describe("Stream Framer", func() {
 			fs := framer.PopStreamFrames(1000)
 			Expect(fs).To(BeEmpty())
 		})
+
+		It("blocks Write until the peer increases the flow control window", func(done Done) {
+			str, err := newStream(5, func() {}, nil, fcm)
+			Expect(err).ToNot(HaveOccurred())
+			streamsMap.putStream(str)
+			fcm.sendWindowSizes[str.streamID] = 1000
+			var writeReturned utils.AtomicBool
+			go func() {
+				defer GinkgoRecover()
+				n, err := str.Write(make([]byte, 3000))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(n).To(Equal(3000))
+				writeReturned.Set(true)
+			}()
+			Eventually(func() protocol.ByteCount { return str.lenOfDataForWriting() }).ShouldNot(BeZero())
+			fs := framer.PopStreamFrames(protocol.MaxByteCount)
+			Expect(fs).To(HaveLen(1))
+			Expect(fs[0].DataLen()).To(Equal(protocol.ByteCount(1000)))
+			// Write doesn't return while the remaining data can't be packed
+			Expect(framer.PopStreamFrames(protocol.MaxByteCount)).To(BeEmpty())
+			Consistently(func() bool { return writeReturned.Get() }).Should(BeFalse())
+			fcm.sendWindowSizes[str.streamID] = 2000
+			fs = framer.PopStreamFrames(protocol.MaxByteCount)
+			Expect(fs).To(HaveLen(1))
+			Expect(fs[0].DataLen()).To(Equal(protocol.ByteCount(2000)))
+			Eventually(func() bool { return writeReturned.Get() }).Should(BeTrue())
+			close(done)
+		})
 	})
 
 	Context("BLOCKED frames", func() {
@@ -428,6 +600,38 @@ var _ = Describe("Stream Framer", func() {
 			Expect(framer.PopBlockedFrame()).To(BeNil())
 		})
 
+		It("queues only one BLOCKED frame while the connection is blocked", func() {
+			fcm := flowcontrol.NewFlowControlManager(&mockConnectionParametersManager{}, &congestion.RTTStats{})
+			fcm.NewStream(stream1.StreamID(), true)
+			fcm.NewStream(stream2.StreamID(), false)
+			_, err := fcm.UpdateWindow(0, 6)
+			Expect(err).ToNot(HaveOccurred())
+			framer.flowControlManager = fcm
+			streamsMap.streams = map[protocol.StreamID]*stream{stream1.StreamID(): stream1, stream2.StreamID(): stream2}
+			streamsMap.openStreams = []protocol.StreamID{stream1.StreamID(), stream2.StreamID()}
+
+			// fill the connection window
+			stream1.dataForWriting = []byte("foobar")
+			Expect(framer.PopStreamFrames(1000)).To(HaveLen(1))
+			// keep sending on the stream that doesn't contribute to connection-level flow control
+			for i := 0; i < 5; i++ {
+				stream2.dataForWriting = []byte("foobar")
+				Expect(framer.PopStreamFrames(1000)).To(HaveLen(1))
+			}
+			blockedFrame := framer.PopBlockedFrame()
+			Expect(blockedFrame).ToNot(BeNil())
+			Expect(blockedFrame.StreamID).To(BeZero())
+			Expect(framer.PopBlockedFrame()).To(BeNil())
+
+			// after a WINDOW_UPDATE, we can get blocked again
+			_, err = fcm.UpdateWindow(0, 12)
+			Expect(err).ToNot(HaveOccurred())
+			stream1.dataForWriting = []byte("foobar")
+			Expect(framer.PopStreamFrames(1000)).To(HaveLen(1))
+			Expect(framer.PopBlockedFrame()).ToNot(BeNil())
+			Expect(framer.PopBlockedFrame()).To(BeNil())
+		})
+
 		It("does not queue BLOCKED frames twice", func() {
 			fcm.sendWindowSizes[stream1.StreamID()] = 3
 			stream1.dataForWriting = []byte("foobar")
@@ -1,14 +1,19 @@
 package quic
 
 import (
+	"bytes"
 	"errors"
 	"io"
+	"math/rand"
+	"net"
+	"runtime"
 	"time"
 
 	"github.com/lucas-clemente/quic-go/congestion"
 	"github.com/lucas-clemente/quic-go/flowcontrol"
 	"github.com/lucas-clemente/quic-go/frames"
 	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/qerr"
 	"github.com/lucas-clemente/quic-go/utils"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -21,6 +26,8 @@ type mockFlowControlHandler struct {
 	remainingConnectionWindowSize protocol.ByteCount
 	bytesReadForStream            protocol.StreamID
 	bytesRead                     protocol.ByteCount
+	totalBytesRead                protocol.ByteCount
+	discardedUnreadData           bool
 	bytesSent                     protocol.ByteCount
 
 	receiveWindow            protocol.ByteCount
@@ -30,6 +37,8 @@ type mockFlowControlHandler struct {
 
 	triggerStreamWindowUpdate     bool
 	triggerConnectionWindowUpdate bool
+
+	blockedReported map[protocol.StreamID]bool
 }
 
 var _ flowcontrol.FlowControlManager = &mockFlowControlHandler{}
@@ -65,6 +74,12 @@ func (m *mockFlowControlHandler) GetReceiveWindow(protocol.StreamID) (protocol.B
 func (m *mockFlowControlHandler) AddBytesRead(streamID protocol.StreamID, n protocol.ByteCount) error {
 	m.bytesReadForStream = streamID
 	m.bytesRead = n
+	m.totalBytesRead += n
+	return nil
+}
+
+func (m *mockFlowControlHandler) DiscardUnreadData(streamID protocol.StreamID) error {
+	m.discardedUnreadData = true
 	return nil
 }
 
@@ -108,10 +123,46 @@ func (m *mockFlowControlHandler) RemainingConnectionWindowSize() protocol.ByteCo
 	return m.remainingConnectionWindowSize
 }
 
+func (m *mockFlowControlHandler) IsNewlyBlocked(streamID protocol.StreamID) (bool, error) {
+	var blocked bool
+	if streamID == 0 {
+		blocked = m.remainingConnectionWindowSize == 0
+	} else {
+		blocked = m.sendWindowSizes[streamID] == 0
+	}
+	if !blocked || m.blockedReported[streamID] {
+		return false, nil
+	}
+	if m.blockedReported == nil {
+		m.blockedReported = make(map[protocol.StreamID]bool)
+	}
+	m.blockedReported[streamID] = true
+	return true, nil
+}
+
 func (m *mockFlowControlHandler) UpdateWindow(streamID protocol.StreamID, offset protocol.ByteCount) (bool, error) {
 	panic("not implemented")
 }
 
+// mockWriter accepts at most n bytes per Write, and returns err
+type mockWriter struct {
+	n   int
+	err error
+}
+
+func (w *mockWriter) Write(p []byte) (int, error) {
+	return utils.Min(w.n, len(p)), w.err
+}
+
+// mockReader returns err on every Read
+type mockReader struct {
+	err error
+}
+
+func (r *mockReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
 var _ = Describe("Stream", func() {
 	var (
 		str          *stream
@@ -120,14 +171,16 @@ var _ = Describe("Stream", func() {
 		resetCalled          bool
 		resetCalledForStream protocol.StreamID
 		resetCalledAtOffset  protocol.ByteCount
+		resetCalledWithCode  qerr.ErrorCode
 	)
 
 	onData := func() {
 		onDataCalled = true
 	}
 
-	onReset := func(id protocol.StreamID, offset protocol.ByteCount) {
+	onReset := func(id protocol.StreamID, offset protocol.ByteCount, code qerr.ErrorCode) {
 		resetCalled = true
+		resetCalledWithCode = code
 		resetCalledForStream = id
 		resetCalledAtOffset = offset
 	}
@@ -300,6 +353,81 @@ var _ = Describe("Stream", func() {
 			Expect(b).To(Equal([]byte("foobar")))
 		})
 
+		It("ignores retransmissions of data that was already received or read", func() {
+			fcm := newMockFlowControlHandler()
+			str.flowControlManager = fcm
+			err := str.AddStreamFrame(&frames.StreamFrame{Data: []byte("foo")})
+			Expect(err).ToNot(HaveOccurred())
+			b := make([]byte, 3)
+			_, err = io.ReadFull(str, b)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(b).To(Equal([]byte("foo")))
+			// a spurious retransmission of data that was already read, bundled with new data
+			err = str.AddStreamFrame(&frames.StreamFrame{Offset: 0, Data: []byte("xxxbar")})
+			Expect(err).ToNot(HaveOccurred())
+			// retransmissions of data that was received, but not read yet
+			err = str.AddStreamFrame(&frames.StreamFrame{Offset: 3, Data: []byte("ba")})
+			Expect(err).ToNot(HaveOccurred())
+			err = str.AddStreamFrame(&frames.StreamFrame{Offset: 4, Data: []byte("xxbaz")})
+			Expect(err).ToNot(HaveOccurred())
+			b = make([]byte, 9)
+			n, err := io.ReadFull(str, b[:6])
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n).To(Equal(6))
+			Expect(b[:6]).To(Equal([]byte("barbaz")))
+			Expect(str.frameQueue.Head()).To(BeNil())
+			// every byte is only counted once
+			Expect(fcm.totalBytesRead).To(Equal(protocol.ByteCount(9)))
+			Expect(str.readOffset).To(Equal(protocol.ByteCount(9)))
+		})
+
+		It("reassembles StreamFrames received in reverse order", func() {
+			data := make([]byte, 100*10)
+			rand.Read(data)
+			for offset := len(data) - 10; offset >= 0; offset -= 10 {
+				err := str.AddStreamFrame(&frames.StreamFrame{
+					Offset: protocol.ByteCount(offset),
+					Data:   data[offset : offset+10],
+				})
+				Expect(err).ToNot(HaveOccurred())
+				// no data can be read until the first frame was received
+				if offset > 0 {
+					Expect(str.frameQueue.Head()).To(BeNil())
+				}
+			}
+			b := make([]byte, len(data))
+			_, err := io.ReadFull(str, b)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(b).To(Equal(data))
+		})
+
+		It("keeps the data received first, if a StreamFrame overlaps data that was already received", func() {
+			err := str.AddStreamFrame(&frames.StreamFrame{Offset: 2, Data: []byte("ob")})
+			Expect(err).ToNot(HaveOccurred())
+			err = str.AddStreamFrame(&frames.StreamFrame{Data: []byte("foxxar")})
+			Expect(err).ToNot(HaveOccurred())
+			b := make([]byte, 6)
+			n, err := str.Read(b)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n).To(Equal(6))
+			Expect(b).To(Equal([]byte("foobar")))
+		})
+
+		It("puts back the data buffer of a frame once it was read completely", func() {
+			frame, err := frames.ParseStreamFrame(bytes.NewReader([]byte{0x80, 0x1, 'f', 'o', 'o', 'b', 'a', 'r'}))
+			Expect(err).ToNot(HaveOccurred())
+			err = str.AddStreamFrame(frame)
+			Expect(err).ToNot(HaveOccurred())
+			b := make([]byte, 4)
+			_, err = str.Read(b)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(frame.Data).ToNot(BeNil())
+			_, err = str.Read(b)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(b[:2]).To(Equal([]byte("ar")))
+			Expect(frame.Data).To(BeNil())
+		})
+
 		It("calls onData", func() {
 			frame := frames.StreamFrame{
 				Offset: 0,
@@ -312,6 +440,90 @@ var _ = Describe("Stream", func() {
 			Expect(onDataCalled).To(BeTrue())
 		})
 
+		Context("deadlines", func() {
+			It("returns an error when Read is called after the deadline", func() {
+				str.SetReadDeadline(time.Now().Add(-time.Second))
+				b := make([]byte, 6)
+				n, err := str.Read(b)
+				Expect(err).To(MatchError(errDeadline))
+				Expect(n).To(BeZero())
+			})
+
+			It("unblocks after the deadline", func() {
+				deadline := time.Now().Add(50 * time.Millisecond)
+				str.SetReadDeadline(deadline)
+				b := make([]byte, 6)
+				n, err := str.Read(b)
+				Expect(err).To(MatchError(errDeadline))
+				Expect(err.(net.Error).Timeout()).To(BeTrue())
+				Expect(n).To(BeZero())
+				Expect(time.Now()).To(BeTemporally("~", deadline, 20*time.Millisecond))
+			})
+
+			It("still reads data that is available when the deadline is set", func() {
+				str.SetReadDeadline(time.Now().Add(-time.Second))
+				err := str.AddStreamFrame(&frames.StreamFrame{Data: []byte("foobar")})
+				Expect(err).ToNot(HaveOccurred())
+				b := make([]byte, 6)
+				n, err := str.Read(b)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(n).To(Equal(6))
+			})
+
+			It("doesn't unblock if the deadline is changed before the first one expires", func() {
+				deadline1 := time.Now().Add(50 * time.Millisecond)
+				deadline2 := time.Now().Add(100 * time.Millisecond)
+				str.SetReadDeadline(deadline1)
+				go func() {
+					defer GinkgoRecover()
+					time.Sleep(20 * time.Millisecond)
+					str.SetReadDeadline(deadline2)
+					// make sure that this was actually executed before the deadline expires
+					Expect(time.Now()).To(BeTemporally("<", deadline1))
+				}()
+				runtime.Gosched()
+				b := make([]byte, 10)
+				n, err := str.Read(b)
+				Expect(err).To(MatchError(errDeadline))
+				Expect(n).To(BeZero())
+				Expect(time.Now()).To(BeTemporally("~", deadline2, 20*time.Millisecond))
+			})
+
+			It("unblocks earlier, when a new deadline is set", func() {
+				deadline1 := time.Now().Add(200 * time.Millisecond)
+				deadline2 := time.Now().Add(50 * time.Millisecond)
+				go func() {
+					defer GinkgoRecover()
+					time.Sleep(10 * time.Millisecond)
+					str.SetReadDeadline(deadline2)
+				}()
+				str.SetReadDeadline(deadline1)
+				runtime.Gosched()
+				b := make([]byte, 10)
+				_, err := str.Read(b)
+				Expect(err).To(MatchError(errDeadline))
+				Expect(time.Now()).To(BeTemporally("~", deadline2, 25*time.Millisecond))
+			})
+
+			It("doesn't time out if the deadline is reset to zero", func() {
+				str.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+				str.SetReadDeadline(time.Time{})
+				readReturned := make(chan struct{})
+				go func() {
+					defer GinkgoRecover()
+					b := make([]byte, 6)
+					n, err := str.Read(b)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(n).To(Equal(6))
+					close(readReturned)
+				}()
+				Consistently(readReturned, 100*time.Millisecond).ShouldNot(BeClosed())
+				err := str.AddStreamFrame(&frames.StreamFrame{Data: []byte("foobar")})
+				Expect(err).ToNot(HaveOccurred())
+				Eventually(readReturned).Should(BeClosed())
+			})
+		})
+
 		Context("closing", func() {
 			Context("with FIN bit", func() {
 				It("returns EOFs", func() {
@@ -385,6 +597,49 @@ var _ = Describe("Stream", func() {
 				})
 			})
 
+			It("returns EOFs if the FIN is received before the data", func() {
+				err := str.AddStreamFrame(&frames.StreamFrame{Offset: 6, FinBit: true})
+				Expect(err).ToNot(HaveOccurred())
+				err = str.AddStreamFrame(&frames.StreamFrame{Offset: 3, Data: []byte("bar")})
+				Expect(err).ToNot(HaveOccurred())
+				err = str.AddStreamFrame(&frames.StreamFrame{Data: []byte("foo")})
+				Expect(err).ToNot(HaveOccurred())
+				b := make([]byte, 6)
+				n, err := str.Read(b)
+				Expect(err).To(MatchError(io.EOF))
+				Expect(n).To(Equal(6))
+				Expect(b).To(Equal([]byte("foobar")))
+				n, err = str.Read(b)
+				Expect(n).To(BeZero())
+				Expect(err).To(MatchError(io.EOF))
+			})
+
+			It("returns EOF after the last byte, if the FIN arrives after a read consumed all data", func() {
+				err := str.AddStreamFrame(&frames.StreamFrame{Data: []byte("foobar")})
+				Expect(err).ToNot(HaveOccurred())
+				b := make([]byte, 6)
+				n, err := str.Read(b)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(n).To(Equal(6))
+				err = str.AddStreamFrame(&frames.StreamFrame{Offset: 6, FinBit: true})
+				Expect(err).ToNot(HaveOccurred())
+				n, err = str.Read(b)
+				Expect(n).To(BeZero())
+				Expect(err).To(MatchError(io.EOF))
+			})
+
+			It("keeps returning EOF after the stream was closed", func() {
+				err := str.AddStreamFrame(&frames.StreamFrame{Data: []byte("foobar"), FinBit: true})
+				Expect(err).ToNot(HaveOccurred())
+				n, err := str.Read(make([]byte, 10))
+				Expect(err).To(MatchError(io.EOF))
+				Expect(n).To(Equal(6))
+				str.Cancel(errors.New("session closed"))
+				n, err = str.Read(make([]byte, 10))
+				Expect(n).To(BeZero())
+				Expect(err).To(MatchError(io.EOF))
+			})
+
 			Context("when CloseRemote is called", func() {
 				It("closes", func() {
 					str.CloseRemote(0)
@@ -422,6 +677,139 @@ var _ = Describe("Stream", func() {
 				Expect(n).To(BeZero())
 				Expect(err).To(MatchError(testErr))
 			})
+
+			It("cancels the context", func() {
+				Expect(str.Context().Done()).ToNot(BeClosed())
+				str.Cancel(testErr)
+				Expect(str.Context().Done()).To(BeClosed())
+			})
+		})
+
+		Context("using WriteTo", func() {
+			BeforeEach(func() {
+				str.flowControlManager = &mockFlowControlHandler{}
+			})
+
+			// addFrames adds the data to the stream in StreamFrames of frameSize bytes, the last one with the FIN bit set
+			addFrames := func(data []byte, frameSize int) {
+				defer GinkgoRecover()
+				for offset := 0; offset < len(data); offset += frameSize {
+					end := utils.Min(offset+frameSize, len(data))
+					err := str.AddStreamFrame(&frames.StreamFrame{
+						Offset: protocol.ByteCount(offset),
+						Data:   data[offset:end],
+						FinBit: end == len(data),
+					})
+					Expect(err).ToNot(HaveOccurred())
+				}
+			}
+
+			It("writes all data to the writer, until the FIN", func() {
+				err := str.AddStreamFrame(&frames.StreamFrame{Data: []byte("foo")})
+				Expect(err).ToNot(HaveOccurred())
+				err = str.AddStreamFrame(&frames.StreamFrame{Offset: 3, Data: []byte("bar"), FinBit: true})
+				Expect(err).ToNot(HaveOccurred())
+				buf := &bytes.Buffer{}
+				n, err := str.WriteTo(buf)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(n).To(Equal(int64(6)))
+				Expect(buf.Bytes()).To(Equal([]byte("foobar")))
+				Expect(str.flowControlManager.(*mockFlowControlHandler).bytesRead).To(Equal(protocol.ByteCount(3)))
+				Expect(onDataCalled).To(BeTrue())
+			})
+
+			It("continues writing after a partial Read", func() {
+				err := str.AddStreamFrame(&frames.StreamFrame{Data: []byte("foobar"), FinBit: true})
+				Expect(err).ToNot(HaveOccurred())
+				b := make([]byte, 2)
+				_, err = str.Read(b)
+				Expect(err).ToNot(HaveOccurred())
+				buf := &bytes.Buffer{}
+				n, err := str.WriteTo(buf)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(n).To(Equal(int64(4)))
+				Expect(buf.Bytes()).To(Equal([]byte("obar")))
+			})
+
+			It("handles immediate FINs", func() {
+				err := str.AddStreamFrame(&frames.StreamFrame{FinBit: true})
+				Expect(err).ToNot(HaveOccurred())
+				n, err := str.WriteTo(&bytes.Buffer{})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(n).To(BeZero())
+			})
+
+			It("returns immediately if the stream was already read to the end", func() {
+				err := str.AddStreamFrame(&frames.StreamFrame{Data: []byte("foo"), FinBit: true})
+				Expect(err).ToNot(HaveOccurred())
+				_, err = str.Read(make([]byte, 10))
+				Expect(err).To(MatchError(io.EOF))
+				n, err := str.WriteTo(&bytes.Buffer{})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(n).To(BeZero())
+			})
+
+			It("pipes 10 MB through the writer", func() {
+				data := make([]byte, 10*(1<<20))
+				rand.Read(data)
+				go addFrames(data, int(protocol.MaxPacketSize))
+				buf := &bytes.Buffer{}
+				n, err := str.WriteTo(buf)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(n).To(Equal(int64(len(data))))
+				// this is *a lot* faster than Expect(buf.Bytes()).To(Equal(data))
+				Expect(bytes.Equal(buf.Bytes(), data)).To(BeTrue())
+			})
+
+			It("returns the error of the writer", func() {
+				err := str.AddStreamFrame(&frames.StreamFrame{Data: []byte("foobar")})
+				Expect(err).ToNot(HaveOccurred())
+				testErr := errors.New("write failed")
+				n, err := str.WriteTo(&mockWriter{n: 2, err: testErr})
+				Expect(err).To(MatchError(testErr))
+				Expect(n).To(Equal(int64(2)))
+				// the data that wasn't written is still available
+				b := make([]byte, 4)
+				m, err := str.Read(b)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(m).To(Equal(4))
+				Expect(b).To(Equal([]byte("obar")))
+			})
+
+			It("returns an error when the writer doesn't consume all data", func() {
+				err := str.AddStreamFrame(&frames.StreamFrame{Data: []byte("foobar")})
+				Expect(err).ToNot(HaveOccurred())
+				n, err := str.WriteTo(&mockWriter{n: 3})
+				Expect(err).To(MatchError(io.ErrShortWrite))
+				Expect(n).To(Equal(int64(3)))
+			})
+
+			It("unblocks when the stream is cancelled", func() {
+				testErr := errors.New("test error")
+				var writeToReturned bool
+				var err error
+				go func() {
+					_, err = str.WriteTo(&bytes.Buffer{})
+					writeToReturned = true
+				}()
+				Consistently(func() bool { return writeToReturned }).Should(BeFalse())
+				str.Cancel(testErr)
+				Eventually(func() bool { return writeToReturned }).Should(BeTrue())
+				Expect(err).To(MatchError(testErr))
+			})
+
+			Measure("piping data through WriteTo", func(b Benchmarker) {
+				data := make([]byte, 10*(1<<20))
+				rand.Read(data)
+				go addFrames(data, int(protocol.MaxPacketSize))
+				buf := &bytes.Buffer{}
+				transferTime := b.Time("transfer time", func() {
+					_, err := str.WriteTo(buf)
+					Expect(err).ToNot(HaveOccurred())
+				})
+				Expect(bytes.Equal(buf.Bytes(), data)).To(BeTrue())
+				b.RecordValue("transfer rate [MB/s]", float64(len(data))/1e6/transferTime.Seconds())
+			}, 5)
 		})
 	})
 
@@ -429,6 +817,12 @@ var _ = Describe("Stream", func() {
 		testErr := errors.New("testErr")
 
 		Context("reset by the peer", func() {
+			It("cancels the context", func() {
+				Expect(str.Context().Done()).ToNot(BeClosed())
+				str.RegisterRemoteError(testErr)
+				Expect(str.Context().Done()).To(BeClosed())
+			})
+
 			It("continues reading after receiving a remote error", func() {
 				frame := frames.StreamFrame{
 					Offset: 0,
@@ -456,6 +850,20 @@ var _ = Describe("Stream", func() {
 				Expect(n).To(Equal(4))
 			})
 
+			It("unblocks a Read when receiving a remote error", func() {
+				var readReturned bool
+				var err error
+				go func() {
+					defer GinkgoRecover()
+					_, err = str.Read(make([]byte, 4))
+					readReturned = true
+				}()
+				Consistently(func() bool { return readReturned }).Should(BeFalse())
+				str.RegisterRemoteError(testErr)
+				Eventually(func() bool { return readReturned }).Should(BeTrue())
+				Expect(err).To(MatchError(testErr))
+			})
+
 			It("returns the error if reading past the offset of the frame received", func() {
 				frame := frames.StreamFrame{
 					Offset: 0,
@@ -574,6 +982,12 @@ var _ = Describe("Stream", func() {
 		})
 
 		Context("reset locally", func() {
+			It("cancels the context", func() {
+				Expect(str.Context().Done()).ToNot(BeClosed())
+				str.Reset(testErr)
+				Expect(str.Context().Done()).To(BeClosed())
+			})
+
 			It("stops writing", func() {
 				var writeReturned bool
 				var n int
@@ -657,6 +1071,44 @@ var _ = Describe("Stream", func() {
 				str.Reset(testErr)
 				Expect(resetCalled).To(BeFalse())
 			})
+
+			It("sends the error code of a QUIC error", func() {
+				str.Reset(qerr.Error(0x42, "foobar"))
+				Expect(resetCalled).To(BeTrue())
+				Expect(resetCalledWithCode).To(Equal(qerr.ErrorCode(0x42)))
+			})
+
+			It("sends the error code 0 for other errors", func() {
+				str.Reset(testErr)
+				Expect(resetCalled).To(BeTrue())
+				Expect(resetCalledWithCode).To(BeZero())
+			})
+
+			It("returns an error from Read and Write when reset with a nil error", func() {
+				str.Reset(nil)
+				_, err := str.Read([]byte{0})
+				Expect(err).To(MatchError(errStreamResetLocally))
+				_, err = str.Write([]byte{0})
+				Expect(err).To(MatchError(errStreamResetLocally))
+			})
+
+			It("discards the data that wasn't read yet", func() {
+				str.flowControlManager = newMockFlowControlHandler()
+				str.AddStreamFrame(&frames.StreamFrame{Data: []byte("foobar")})
+				str.Reset(testErr)
+				Expect(str.flowControlManager.(*mockFlowControlHandler).discardedUnreadData).To(BeTrue())
+				Expect(str.frameQueue.Head()).To(BeNil())
+			})
+
+			It("discards data received after the reset", func() {
+				str.flowControlManager = newMockFlowControlHandler()
+				str.Reset(testErr)
+				str.flowControlManager.(*mockFlowControlHandler).discardedUnreadData = false
+				err := str.AddStreamFrame(&frames.StreamFrame{Data: []byte("foobar")})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(str.flowControlManager.(*mockFlowControlHandler).discardedUnreadData).To(BeTrue())
+				Expect(str.frameQueue.Head()).To(BeNil())
+			})
 		})
 	})
 
@@ -730,6 +1182,53 @@ var _ = Describe("Stream", func() {
 			Expect(str.getDataForWriting(3)).To(Equal([]byte("foo")))
 		})
 
+		It("bounds the amount of data buffered for sending", func(done Done) {
+			data := make([]byte, 10*protocol.MaxStreamSendBufferSize)
+			rand.Read(data)
+			var writeReturned utils.AtomicBool
+			go func() {
+				defer GinkgoRecover()
+				n, err := str.Write(data)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(n).To(Equal(len(data)))
+				writeReturned.Set(true)
+			}()
+			// as long as no data is packed, Write blocks, and only one chunk of the data is buffered
+			Eventually(func() protocol.ByteCount { return str.lenOfDataForWriting() }).Should(BeEquivalentTo(protocol.MaxStreamSendBufferSize))
+			Consistently(func() protocol.ByteCount { return str.lenOfDataForWriting() }).Should(BeEquivalentTo(protocol.MaxStreamSendBufferSize))
+			Expect(writeReturned.Get()).To(BeFalse())
+			// every time data is packed, the next chunk is copied
+			received := &bytes.Buffer{}
+			for received.Len() < len(data) {
+				Expect(str.lenOfDataForWriting()).To(BeNumerically("<=", protocol.MaxStreamSendBufferSize))
+				received.Write(str.getDataForWriting(protocol.MaxPacketSize))
+				runtime.Gosched()
+			}
+			Expect(bytes.Equal(received.Bytes(), data)).To(BeTrue())
+			Eventually(func() bool { return writeReturned.Get() }).Should(BeTrue())
+			close(done)
+		}, 10)
+
+		It("returns the number of bytes written, when an error occurs after sending a part of the data", func() {
+			data := make([]byte, 2*protocol.MaxStreamSendBufferSize)
+			testErr := errors.New("test")
+			var n int
+			var err error
+			var writeReturned utils.AtomicBool
+			go func() {
+				defer GinkgoRecover()
+				n, err = str.Write(data)
+				writeReturned.Set(true)
+			}()
+			Eventually(func() protocol.ByteCount { return str.lenOfDataForWriting() }).ShouldNot(BeZero())
+			Expect(str.getDataForWriting(protocol.MaxStreamSendBufferSize)).To(HaveLen(protocol.MaxStreamSendBufferSize))
+			Eventually(func() protocol.ByteCount { return str.lenOfDataForWriting() }).ShouldNot(BeZero())
+			str.Cancel(testErr)
+			Eventually(func() bool { return writeReturned.Get() }).Should(BeTrue())
+			Expect(err).To(MatchError(testErr))
+			Expect(n).To(Equal(protocol.MaxStreamSendBufferSize))
+		})
+
 		It("returns when given a nil input", func() {
 			n, err := str.Write(nil)
 			Expect(n).To(BeZero())
@@ -742,6 +1241,154 @@ var _ = Describe("Stream", func() {
 			Expect(err).ToNot(HaveOccurred())
 		})
 
+		Context("using ReadFrom", func() {
+			It("sends all data read from the reader", func(done Done) {
+				var readFromReturned bool
+				go func() {
+					defer GinkgoRecover()
+					n, err := str.ReadFrom(bytes.NewReader([]byte("foobar")))
+					Expect(err).ToNot(HaveOccurred())
+					Expect(n).To(Equal(int64(6)))
+					readFromReturned = true
+				}()
+				Eventually(func() protocol.ByteCount { return str.lenOfDataForWriting() }).Should(Equal(protocol.ByteCount(6)))
+				Expect(onDataCalled).To(BeTrue())
+				Expect(str.getDataForWriting(1000)).To(Equal([]byte("foobar")))
+				Eventually(func() bool { return readFromReturned }).Should(BeTrue())
+				close(done)
+			})
+
+			It("pipes 10 MB through the stream", func(done Done) {
+				data := make([]byte, 10*(1<<20))
+				rand.Read(data)
+				go func() {
+					defer GinkgoRecover()
+					n, err := str.ReadFrom(bytes.NewReader(data))
+					Expect(err).ToNot(HaveOccurred())
+					Expect(n).To(Equal(int64(len(data))))
+				}()
+				received := &bytes.Buffer{}
+				for received.Len() < len(data) {
+					received.Write(str.getDataForWriting(protocol.MaxPacketSize))
+					runtime.Gosched()
+				}
+				Expect(bytes.Equal(received.Bytes(), data)).To(BeTrue())
+				Expect(str.writeOffset).To(Equal(protocol.ByteCount(len(data))))
+				close(done)
+			}, 10)
+
+			It("returns the error of the reader", func() {
+				testErr := errors.New("read failed")
+				n, err := str.ReadFrom(&mockReader{err: testErr})
+				Expect(err).To(MatchError(testErr))
+				Expect(n).To(BeZero())
+			})
+
+			It("returns errors when the stream is cancelled", func() {
+				testErr := errors.New("test")
+				str.Cancel(testErr)
+				n, err := str.ReadFrom(bytes.NewReader([]byte("foobar")))
+				Expect(err).To(MatchError(testErr))
+				Expect(n).To(BeZero())
+				Expect(str.lenOfDataForWriting()).To(BeZero())
+			})
+		})
+
+		Context("deadlines", func() {
+			It("returns an error when Write is called after the deadline", func() {
+				str.SetWriteDeadline(time.Now().Add(-time.Second))
+				n, err := str.Write([]byte("foobar"))
+				Expect(err).To(MatchError(errDeadline))
+				Expect(n).To(BeZero())
+				Expect(str.lenOfDataForWriting()).To(BeZero())
+			})
+
+			It("unblocks after the deadline", func() {
+				deadline := time.Now().Add(50 * time.Millisecond)
+				str.SetWriteDeadline(deadline)
+				n, err := str.Write([]byte("foobar"))
+				Expect(err).To(MatchError(errDeadline))
+				Expect(err.(net.Error).Timeout()).To(BeTrue())
+				Expect(n).To(BeZero())
+				Expect(time.Now()).To(BeTemporally("~", deadline, 20*time.Millisecond))
+				Expect(str.lenOfDataForWriting()).To(BeZero())
+			})
+
+			It("returns the number of bytes written, when the deadline expires", func() {
+				deadline := time.Now().Add(50 * time.Millisecond)
+				str.SetWriteDeadline(deadline)
+				var writeReturned bool
+				var n int
+				var err error
+				go func() {
+					defer GinkgoRecover()
+					n, err = str.Write([]byte("foobar"))
+					writeReturned = true
+				}()
+				Eventually(func() protocol.ByteCount { return str.lenOfDataForWriting() }).ShouldNot(BeZero())
+				Expect(str.getDataForWriting(3)).To(Equal([]byte("foo")))
+				Eventually(func() bool { return writeReturned }).Should(BeTrue())
+				Expect(err).To(MatchError(errDeadline))
+				Expect(n).To(Equal(3))
+				Expect(str.getDataForWriting(3)).To(BeNil())
+			})
+
+			It("doesn't time out if the deadline is reset to zero", func() {
+				str.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+				str.SetWriteDeadline(time.Time{})
+				var writeReturned bool
+				go func() {
+					defer GinkgoRecover()
+					n, err := str.Write([]byte("foobar"))
+					Expect(err).ToNot(HaveOccurred())
+					Expect(n).To(Equal(6))
+					writeReturned = true
+				}()
+				Consistently(func() bool { return writeReturned }, 100*time.Millisecond).Should(BeFalse())
+				Expect(str.getDataForWriting(6)).To(Equal([]byte("foobar")))
+				Eventually(func() bool { return writeReturned }).Should(BeTrue())
+			})
+
+			It("sets the read and write deadlines with SetDeadline", func() {
+				deadline := time.Now().Add(50 * time.Millisecond)
+				str.SetDeadline(deadline)
+				Expect(str.readDeadline).To(Equal(deadline))
+				Expect(str.writeDeadline).To(Equal(deadline))
+			})
+
+			It("stops the deadline timers when the stream is reset", func() {
+				str.SetDeadline(time.Now().Add(time.Hour))
+				readTimer := str.readDeadlineTimer
+				writeTimer := str.writeDeadlineTimer
+				str.Reset(errors.New("reset"))
+				Expect(str.readDeadlineTimer).To(BeNil())
+				Expect(str.writeDeadlineTimer).To(BeNil())
+				// Stop returns false if the timer was already stopped
+				Expect(readTimer.Stop()).To(BeFalse())
+				Expect(writeTimer.Stop()).To(BeFalse())
+			})
+
+			It("stops the deadline timers when the stream is cancelled", func() {
+				str.SetDeadline(time.Now().Add(time.Hour))
+				readTimer := str.readDeadlineTimer
+				writeTimer := str.writeDeadlineTimer
+				str.Cancel(errors.New("cancelled"))
+				Expect(readTimer.Stop()).To(BeFalse())
+				Expect(writeTimer.Stop()).To(BeFalse())
+			})
+
+			It("stops the write deadline timer when the stream is closed", func() {
+				str.SetDeadline(time.Now().Add(time.Hour))
+				readTimer := str.readDeadlineTimer
+				writeTimer := str.writeDeadlineTimer
+				str.Close()
+				Expect(str.writeDeadlineTimer).To(BeNil())
+				Expect(writeTimer.Stop()).To(BeFalse())
+				// the stream can still be read from
+				Expect(readTimer.Stop()).To(BeTrue())
+			})
+		})
+
 		Context("closing", func() {
 			It("sets finishedWriting when calling Close", func() {
 				str.Close()
@@ -883,6 +1530,114 @@ var _ = Describe("Stream", func() {
 			str.Reset(testErr)
 			Expect(str.finished()).To(BeTrue())
 		})
+
+		It("still reads data after closing the write direction", func() {
+			Expect(str.CloseWrite()).To(Succeed())
+			Expect(str.shouldSendFin()).To(BeTrue())
+			err := str.AddStreamFrame(&frames.StreamFrame{Data: []byte("foobar"), FinBit: true})
+			Expect(err).ToNot(HaveOccurred())
+			b := make([]byte, 6)
+			n, err := str.Read(b)
+			Expect(err).To(MatchError(io.EOF))
+			Expect(n).To(Equal(6))
+			Expect(b).To(Equal([]byte("foobar")))
+		})
+
+		Context("closing the read direction", func() {
+			var fcm *mockFlowControlHandler
+
+			BeforeEach(func() {
+				fcm = newMockFlowControlHandler()
+				str.flowControlManager = fcm
+			})
+
+			It("returns an error when reading after CloseRead", func() {
+				err := str.AddStreamFrame(&frames.StreamFrame{Data: []byte("foobar")})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(str.CloseRead()).To(Succeed())
+				_, err = str.Read(make([]byte, 6))
+				Expect(err).To(MatchError(errStreamReadClosed))
+				_, err = str.WriteTo(&bytes.Buffer{})
+				Expect(err).To(MatchError(errStreamReadClosed))
+			})
+
+			It("unblocks Read", func(done Done) {
+				readReturned := make(chan struct{})
+				go func() {
+					defer GinkgoRecover()
+					_, err := str.Read(make([]byte, 6))
+					Expect(err).To(MatchError(errStreamReadClosed))
+					close(readReturned)
+				}()
+				Consistently(readReturned).ShouldNot(BeClosed())
+				Expect(str.CloseRead()).To(Succeed())
+				Eventually(readReturned).Should(BeClosed())
+				close(done)
+			})
+
+			It("counts queued data as read", func() {
+				err := str.AddStreamFrame(&frames.StreamFrame{Data: []byte("foobar")})
+				Expect(err).ToNot(HaveOccurred())
+				onDataCalled = false
+				Expect(str.CloseRead()).To(Succeed())
+				Expect(fcm.bytesReadForStream).To(Equal(str.streamID))
+				Expect(fcm.bytesRead).To(Equal(protocol.ByteCount(6)))
+				Expect(onDataCalled).To(BeTrue())
+			})
+
+			It("only counts the data of a partially read frame that wasn't read yet", func() {
+				err := str.AddStreamFrame(&frames.StreamFrame{Data: []byte("foobar")})
+				Expect(err).ToNot(HaveOccurred())
+				n, err := str.Read(make([]byte, 2))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(n).To(Equal(2))
+				Expect(str.CloseRead()).To(Succeed())
+				Expect(fcm.bytesRead).To(Equal(protocol.ByteCount(4)))
+				Expect(str.readOffset).To(Equal(protocol.ByteCount(6)))
+			})
+
+			It("discards data received after CloseRead, and counts it as read", func() {
+				Expect(str.CloseRead()).To(Succeed())
+				onDataCalled = false
+				err := str.AddStreamFrame(&frames.StreamFrame{Data: []byte("foobar")})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(fcm.bytesRead).To(Equal(protocol.ByteCount(6)))
+				Expect(onDataCalled).To(BeTrue())
+				Expect(str.frameQueue.Head()).To(BeNil())
+			})
+
+			It("waits for data that is received out of order", func() {
+				Expect(str.CloseRead()).To(Succeed())
+				err := str.AddStreamFrame(&frames.StreamFrame{Offset: 3, Data: []byte("bar")})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(fcm.bytesRead).To(BeZero())
+				err = str.AddStreamFrame(&frames.StreamFrame{Data: []byte("foo")})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(str.readOffset).To(Equal(protocol.ByteCount(6)))
+			})
+
+			It("is finished after closing both directions, once the FIN was received and sent", func() {
+				Expect(str.CloseRead()).To(Succeed())
+				Expect(str.CloseWrite()).To(Succeed())
+				str.sentFin()
+				Expect(str.finished()).To(BeFalse())
+				err := str.AddStreamFrame(&frames.StreamFrame{Data: []byte("foobar"), FinBit: true})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(str.finished()).To(BeTrue())
+			})
+
+			It("doesn't affect the write direction", func() {
+				Expect(str.CloseRead()).To(Succeed())
+				go func() {
+					defer GinkgoRecover()
+					n, err := str.Write([]byte("foobar"))
+					Expect(err).ToNot(HaveOccurred())
+					Expect(n).To(Equal(6))
+				}()
+				Eventually(func() protocol.ByteCount { return str.lenOfDataForWriting() }).Should(Equal(protocol.ByteCount(6)))
+				Expect(str.getDataForWriting(6)).To(Equal([]byte("foobar")))
+			})
+		})
 	})
 
 })
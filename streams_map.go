@@ -1,8 +1,10 @@
 package quic
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/lucas-clemente/quic-go/handshake"
@@ -29,26 +31,33 @@ type streamsMap struct {
 	closeErr           error
 	nextStreamToAccept protocol.StreamID
 
-	newStream newStreamLambda
+	newStream    newStreamLambda
+	refuseStream refuseStreamLambda
 
 	numOutgoingStreams uint32
 	numIncomingStreams uint32
+	// maxIncomingStreams is the maximum number of streams the peer may open, as configured by the application
+	// if 0, only the limit negotiated with the peer applies
+	maxIncomingStreams uint32
 }
 
 type streamLambda func(*stream) (bool, error)
 type newStreamLambda func(protocol.StreamID) (*stream, error)
+type refuseStreamLambda func(protocol.StreamID)
 
 var (
 	errMapAccess = errors.New("streamsMap: Error accessing the streams map")
 )
 
-func newStreamsMap(newStream newStreamLambda, pers protocol.Perspective, connectionParameters handshake.ConnectionParametersManager) *streamsMap {
+func newStreamsMap(newStream newStreamLambda, refuseStream refuseStreamLambda, pers protocol.Perspective, connectionParameters handshake.ConnectionParametersManager, maxIncomingStreams uint32) *streamsMap {
 	sm := streamsMap{
 		perspective:          pers,
 		streams:              map[protocol.StreamID]*stream{},
 		openStreams:          make([]protocol.StreamID, 0),
 		newStream:            newStream,
+		refuseStream:         refuseStream,
 		connectionParameters: connectionParameters,
+		maxIncomingStreams:   maxIncomingStreams,
 	}
 	sm.nextStreamOrErrCond.L = &sm.mutex
 	sm.openStreamOrErrCond.L = &sm.mutex
@@ -86,6 +95,10 @@ func (m *streamsMap) GetOrOpenStream(id protocol.StreamID) (*stream, error) {
 	if id <= m.highestStreamOpenedByPeer {
 		return nil, nil
 	}
+	// a stream that we opened, and that was already closed
+	if id%2 == m.nextStream%2 && id < m.nextStream {
+		return nil, nil
+	}
 
 	if m.perspective == protocol.PerspectiveServer && id%2 == 0 {
 		return nil, qerr.Error(qerr.InvalidStreamID, fmt.Sprintf("attempted to open stream %d from client-side", id))
@@ -119,6 +132,17 @@ func (m *streamsMap) openRemoteStream(id protocol.StreamID) (*stream, error) {
 	if id+protocol.MaxNewStreamIDDelta < m.highestStreamOpenedByPeer {
 		return nil, qerr.Error(qerr.InvalidStreamID, fmt.Sprintf("attempted to open stream %d, which is a lot smaller than the highest opened stream, %d", id, m.highestStreamOpenedByPeer))
 	}
+	// the crypto stream is never refused
+	if id != 1 && m.maxIncomingStreams > 0 && m.numDynamicStreamsOpenedByPeer() >= m.maxIncomingStreams {
+		// don't create the stream, but make sure that it is not opened later on
+		if id > m.highestStreamOpenedByPeer {
+			m.highestStreamOpenedByPeer = id
+		}
+		if m.refuseStream != nil {
+			m.refuseStream(id)
+		}
+		return nil, nil
+	}
 
 	s, err := m.newStream(id)
 	if err != nil {
@@ -139,6 +163,17 @@ func (m *streamsMap) openRemoteStream(id protocol.StreamID) (*stream, error) {
 	return s, nil
 }
 
+// numDynamicStreamsOpenedByPeer returns the number of open streams that were opened by the peer, not counting the crypto stream
+func (m *streamsMap) numDynamicStreamsOpenedByPeer() uint32 {
+	if m.perspective == protocol.PerspectiveClient {
+		return m.numOutgoingStreams
+	}
+	if _, ok := m.streams[1]; ok {
+		return m.numIncomingStreams - 1
+	}
+	return m.numIncomingStreams
+}
+
 func (m *streamsMap) openStreamImpl() (*stream, error) {
 	id := m.nextStream
 	if m.numOutgoingStreams >= m.connectionParameters.GetMaxOutgoingStreams() {
@@ -192,8 +227,23 @@ func (m *streamsMap) OpenStreamSync() (*stream, error) {
 }
 
 // AcceptStream returns the next stream opened by the peer
-// it blocks until a new stream is opened
-func (m *streamsMap) AcceptStream() (*stream, error) {
+// it blocks until a new stream is opened, or the context is cancelled
+func (m *streamsMap) AcceptStream(ctx context.Context) (*stream, error) {
+	if ctx.Done() != nil {
+		// a sync.Cond can't wait for a channel, so wake up the waiting loop when the context is cancelled
+		accepted := make(chan struct{})
+		defer close(accepted)
+		go func() {
+			select {
+			case <-ctx.Done():
+				m.mutex.Lock()
+				m.nextStreamOrErrCond.Broadcast()
+				m.mutex.Unlock()
+			case <-accepted:
+			}
+		}()
+	}
+
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 	var str *stream
@@ -206,6 +256,9 @@ func (m *streamsMap) AcceptStream() (*stream, error) {
 		if ok {
 			break
 		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		m.nextStreamOrErrCond.Wait()
 	}
 	m.nextStreamToAccept += 2
@@ -231,7 +284,7 @@ func (m *streamsMap) Iterate(fn streamLambda) error {
 }
 
 // RoundRobinIterate executes the streamLambda for every open stream, until the streamLambda returns false
-// It uses a round-robin-like scheduling to ensure that every stream is considered fairly
+// It iterates the streams with a higher priority first, and uses a round-robin-like scheduling to ensure that every stream of the same priority is considered fairly
 // It prioritizes the crypto- and the header-stream (StreamIDs 1 and 3)
 func (m *streamsMap) RoundRobinIterate(fn streamLambda) error {
 	m.mutex.Lock()
@@ -250,24 +303,52 @@ func (m *streamsMap) RoundRobinIterate(fn streamLambda) error {
 		}
 	}
 
-	for i := uint32(0); i < numStreams; i++ {
-		streamID := m.openStreams[(i+startIndex)%numStreams]
-		if streamID == 1 || streamID == 3 {
-			continue
-		}
+	for _, p := range m.priorities() {
+		for i := uint32(0); i < numStreams; i++ {
+			index := (i + startIndex) % numStreams
+			streamID := m.openStreams[index]
+			if streamID == 1 || streamID == 3 || m.priorityOf(streamID) != p {
+				continue
+			}
 
-		cont, err := m.iterateFunc(streamID, fn)
-		if err != nil {
-			return err
-		}
-		m.roundRobinIndex = (m.roundRobinIndex + 1) % numStreams
-		if !cont {
-			break
+			cont, err := m.iterateFunc(streamID, fn)
+			if err != nil {
+				return err
+			}
+			// continue after this stream in the next iteration, such that streams of the same priority take turns
+			m.roundRobinIndex = (index + 1) % numStreams
+			if !cont {
+				return nil
+			}
 		}
 	}
 	return nil
 }
 
+// priorities returns the priorities of the open streams, highest priority first
+func (m *streamsMap) priorities() []Priority {
+	var priorities []Priority
+	for _, streamID := range m.openStreams {
+		p := m.priorityOf(streamID)
+		i := sort.Search(len(priorities), func(i int) bool { return priorities[i] <= p })
+		if i < len(priorities) && priorities[i] == p {
+			continue
+		}
+		priorities = append(priorities, 0)
+		copy(priorities[i+1:], priorities[i:])
+		priorities[i] = p
+	}
+	return priorities
+}
+
+func (m *streamsMap) priorityOf(streamID protocol.StreamID) Priority {
+	str := m.streams[streamID]
+	if str == nil {
+		return DefaultPriority
+	}
+	return str.getPriority()
+}
+
 func (m *streamsMap) iterateFunc(streamID protocol.StreamID, fn streamLambda) (bool, error) {
 	str, ok := m.streams[streamID]
 	if !ok {
@@ -287,6 +368,13 @@ func (m *streamsMap) putStream(s *stream) error {
 	return nil
 }
 
+// wasAccepted says if a stream opened by the peer was already returned by AcceptStream.
+// Streams opened by us don't need to be accepted.
+// Attention: this function must only be called if a mutex has been acquired previously
+func (m *streamsMap) wasAccepted(id protocol.StreamID) bool {
+	return id%2 != m.nextStreamToAccept%2 || id < m.nextStreamToAccept
+}
+
 // Attention: this function must only be called if a mutex has been acquired previously
 func (m *streamsMap) RemoveStream(id protocol.StreamID) error {
 	s, ok := m.streams[id]
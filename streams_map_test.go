@@ -1,6 +1,7 @@
 package quic
 
 import (
+	"context"
 	"errors"
 	"math"
 	"time"
@@ -58,7 +59,7 @@ var _ = Describe("Streams Map", func() {
 	)
 
 	setNewStreamsMap := func(p protocol.Perspective) {
-		m = newStreamsMap(nil, p, cpm)
+		m = newStreamsMap(nil, nil, p, cpm, 0)
 		m.newStream = func(id protocol.StreamID) (*stream, error) {
 			return &stream{streamID: id}, nil
 		}
@@ -162,6 +163,61 @@ var _ = Describe("Streams Map", func() {
 						}
 					})
 				})
+
+				Context("limiting the number of incoming streams", func() {
+					var refusedStreams []protocol.StreamID
+
+					BeforeEach(func() {
+						refusedStreams = nil
+						m.maxIncomingStreams = 5
+						m.refuseStream = func(id protocol.StreamID) {
+							refusedStreams = append(refusedStreams, id)
+						}
+						_, err := m.GetOrOpenStream(1) // crypto stream
+						Expect(err).ToNot(HaveOccurred())
+					})
+
+					It("refuses a stream when too many streams are opened", func() {
+						for i := 1; i <= 5; i++ {
+							str, err := m.GetOrOpenStream(protocol.StreamID(i*2 + 1))
+							Expect(err).ToNot(HaveOccurred())
+							Expect(str).ToNot(BeNil())
+						}
+						str, err := m.GetOrOpenStream(13)
+						Expect(err).ToNot(HaveOccurred())
+						Expect(str).To(BeNil())
+						Expect(refusedStreams).To(Equal([]protocol.StreamID{13}))
+						Expect(m.streams).ToNot(HaveKey(protocol.StreamID(13)))
+					})
+
+					It("refuses streams that are opened implicitly", func() {
+						_, err := m.GetOrOpenStream(15)
+						Expect(err).ToNot(HaveOccurred())
+						Expect(refusedStreams).To(Equal([]protocol.StreamID{13, 15}))
+					})
+
+					It("doesn't open a refused stream later", func() {
+						_, err := m.GetOrOpenStream(13)
+						Expect(err).ToNot(HaveOccurred())
+						err = m.RemoveStream(3)
+						Expect(err).ToNot(HaveOccurred())
+						str, err := m.GetOrOpenStream(13)
+						Expect(err).ToNot(HaveOccurred())
+						Expect(str).To(BeNil())
+						Expect(refusedStreams).To(HaveLen(1))
+					})
+
+					It("accepts new streams after a stream was closed", func() {
+						_, err := m.GetOrOpenStream(11)
+						Expect(err).ToNot(HaveOccurred())
+						err = m.RemoveStream(3)
+						Expect(err).ToNot(HaveOccurred())
+						str, err := m.GetOrOpenStream(13)
+						Expect(err).ToNot(HaveOccurred())
+						Expect(str).ToNot(BeNil())
+						Expect(refusedStreams).To(BeEmpty())
+					})
+				})
 			})
 
 			Context("server-side streams", func() {
@@ -295,7 +351,7 @@ var _ = Describe("Streams Map", func() {
 				It("does nothing if no stream is opened", func() {
 					var accepted bool
 					go func() {
-						_, _ = m.AcceptStream()
+						_, _ = m.AcceptStream(context.Background())
 						accepted = true
 					}()
 					Consistently(func() bool { return accepted }).Should(BeFalse())
@@ -306,7 +362,7 @@ var _ = Describe("Streams Map", func() {
 					go func() {
 						defer GinkgoRecover()
 						var err error
-						str, err = m.AcceptStream()
+						str, err = m.AcceptStream(context.Background())
 						Expect(err).ToNot(HaveOccurred())
 					}()
 					_, err := m.GetOrOpenStream(1)
@@ -320,7 +376,7 @@ var _ = Describe("Streams Map", func() {
 					go func() {
 						defer GinkgoRecover()
 						var err error
-						str, err = m.AcceptStream()
+						str, err = m.AcceptStream(context.Background())
 						Expect(err).ToNot(HaveOccurred())
 					}()
 					_, err := m.GetOrOpenStream(5)
@@ -334,13 +390,13 @@ var _ = Describe("Streams Map", func() {
 					go func() {
 						defer GinkgoRecover()
 						var err error
-						str1, err = m.AcceptStream()
+						str1, err = m.AcceptStream(context.Background())
 						Expect(err).ToNot(HaveOccurred())
 					}()
 					go func() {
 						defer GinkgoRecover()
 						var err error
-						str2, err = m.AcceptStream()
+						str2, err = m.AcceptStream(context.Background())
 						Expect(err).ToNot(HaveOccurred())
 					}()
 					_, err := m.GetOrOpenStream(3) // opens stream 1 and 3
@@ -356,7 +412,7 @@ var _ = Describe("Streams Map", func() {
 					go func() {
 						defer GinkgoRecover()
 						var err error
-						str, err = m.AcceptStream()
+						str, err = m.AcceptStream(context.Background())
 						Expect(err).ToNot(HaveOccurred())
 					}()
 					Consistently(func() *stream { return str }).Should(BeNil())
@@ -371,14 +427,14 @@ var _ = Describe("Streams Map", func() {
 					go func() {
 						defer GinkgoRecover()
 						var err error
-						str, err = m.AcceptStream()
+						str, err = m.AcceptStream(context.Background())
 						Expect(err).ToNot(HaveOccurred())
 					}()
 					_, err := m.GetOrOpenStream(3)
 					Expect(err).ToNot(HaveOccurred())
 					Eventually(func() *stream { return str }).ShouldNot(BeNil())
 					Expect(str.StreamID()).To(Equal(protocol.StreamID(1)))
-					str, err = m.AcceptStream()
+					str, err = m.AcceptStream(context.Background())
 					Expect(err).ToNot(HaveOccurred())
 					Expect(str.StreamID()).To(Equal(protocol.StreamID(3)))
 				})
@@ -387,12 +443,12 @@ var _ = Describe("Streams Map", func() {
 					var accepted bool
 					_, err := m.GetOrOpenStream(1)
 					Expect(err).ToNot(HaveOccurred())
-					str, err := m.AcceptStream()
+					str, err := m.AcceptStream(context.Background())
 					Expect(err).ToNot(HaveOccurred())
 					Expect(str.StreamID()).To(Equal(protocol.StreamID(1)))
 					go func() {
 						defer GinkgoRecover()
-						_, _ = m.AcceptStream()
+						_, _ = m.AcceptStream(context.Background())
 						accepted = true
 					}()
 					Consistently(func() bool { return accepted }).Should(BeFalse())
@@ -402,17 +458,47 @@ var _ = Describe("Streams Map", func() {
 					testErr := errors.New("testErr")
 					var acceptErr error
 					go func() {
-						_, acceptErr = m.AcceptStream()
+						_, acceptErr = m.AcceptStream(context.Background())
 					}()
 					Consistently(func() error { return acceptErr }).ShouldNot(HaveOccurred())
 					m.CloseWithError(testErr)
 					Eventually(func() error { return acceptErr }).Should(MatchError(testErr))
 				})
 
+				It("stops waiting when the context is cancelled", func(done Done) {
+					ctx, cancel := context.WithCancel(context.Background())
+					errChan := make(chan error)
+					go func() {
+						_, err := m.AcceptStream(ctx)
+						errChan <- err
+					}()
+					Consistently(errChan).ShouldNot(Receive())
+					cancel()
+					Eventually(errChan).Should(Receive(MatchError(context.Canceled)))
+					close(done)
+				})
+
+				It("immediately returns when Accept is called with a cancelled context", func() {
+					ctx, cancel := context.WithCancel(context.Background())
+					cancel()
+					_, err := m.AcceptStream(ctx)
+					Expect(err).To(MatchError(context.Canceled))
+				})
+
+				It("returns an available stream, even if the context is cancelled", func() {
+					_, err := m.GetOrOpenStream(1)
+					Expect(err).ToNot(HaveOccurred())
+					ctx, cancel := context.WithCancel(context.Background())
+					cancel()
+					str, err := m.AcceptStream(ctx)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(str.StreamID()).To(Equal(protocol.StreamID(1)))
+				})
+
 				It("immediately returns when Accept is called after an error was registered", func() {
 					testErr := errors.New("testErr")
 					m.CloseWithError(testErr)
-					_, err := m.AcceptStream()
+					_, err := m.AcceptStream(context.Background())
 					Expect(err).To(MatchError(testErr))
 				})
 			})
@@ -429,6 +515,19 @@ var _ = Describe("Streams Map", func() {
 					Expect(err).To(MatchError("InvalidStreamID: attempted to open stream 5 from server-side"))
 				})
 
+				It("returns nil for closed streams that it opened itself", func() {
+					_, err := m.OpenStream()
+					Expect(err).NotTo(HaveOccurred())
+					s, err := m.OpenStream()
+					Expect(err).NotTo(HaveOccurred())
+					Expect(s.StreamID()).To(Equal(protocol.StreamID(3)))
+					err = m.RemoveStream(3)
+					Expect(err).NotTo(HaveOccurred())
+					s, err = m.GetOrOpenStream(3)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(s).To(BeNil())
+				})
+
 				It("gets new streams", func() {
 					s, err := m.GetOrOpenStream(2)
 					Expect(err).NotTo(HaveOccurred())
@@ -471,7 +570,7 @@ var _ = Describe("Streams Map", func() {
 					go func() {
 						defer GinkgoRecover()
 						var err error
-						str, err = m.AcceptStream()
+						str, err = m.AcceptStream(context.Background())
 						Expect(err).ToNot(HaveOccurred())
 					}()
 					_, err := m.GetOrOpenStream(2)
@@ -672,6 +771,35 @@ var _ = Describe("Streams Map", func() {
 				Expect(m.roundRobinIndex).To(Equal(uint32(3)))
 			})
 
+			Context("prioritizing streams", func() {
+				It("iterates the streams with a higher priority first", func() {
+					m.streams[6].SetPriority(2)
+					m.streams[8].SetPriority(1)
+					m.streams[4].SetPriority(-1)
+					fn := func(str *stream) (bool, error) {
+						lambdaCalledForStream = append(lambdaCalledForStream, str.StreamID())
+						return true, nil
+					}
+					err := m.RoundRobinIterate(fn)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(lambdaCalledForStream).To(Equal([]protocol.StreamID{6, 8, 5, 7, 4}))
+				})
+
+				It("uses round-robin scheduling for streams with the same priority", func() {
+					m.streams[5].SetPriority(1)
+					m.streams[7].SetPriority(1)
+					fn := func(str *stream) (bool, error) {
+						lambdaCalledForStream = append(lambdaCalledForStream, str.StreamID())
+						return false, nil
+					}
+					for i := 0; i < 3; i++ {
+						err := m.RoundRobinIterate(fn)
+						Expect(err).ToNot(HaveOccurred())
+					}
+					Expect(lambdaCalledForStream).To(Equal([]protocol.StreamID{5, 7, 5}))
+				})
+			})
+
 			Context("Prioritizing crypto- and header streams", func() {
 				BeforeEach(func() {
 					err := m.putStream(&stream{streamID: 1})
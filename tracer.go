@@ -0,0 +1,47 @@
+package quic
+
+import (
+	"github.com/lucas-clemente/quic-go/frames"
+	"github.com/lucas-clemente/quic-go/protocol"
+)
+
+// newFrameInfo describes a frame for the Tracer
+func newFrameInfo(frame frames.Frame, packetNumber protocol.PacketNumber) FrameInfo {
+	info := FrameInfo{PacketNumber: packetNumber}
+	switch f := frame.(type) {
+	case *frames.StreamFrame:
+		info.Type = "STREAM"
+		info.StreamID = f.StreamID
+	case *frames.AckFrame:
+		info.Type = "ACK"
+	case *frames.StopWaitingFrame:
+		info.Type = "STOP_WAITING"
+	case *frames.RstStreamFrame:
+		info.Type = "RST_STREAM"
+		info.StreamID = f.StreamID
+	case *frames.WindowUpdateFrame:
+		info.Type = "WINDOW_UPDATE"
+		info.StreamID = f.StreamID
+	case *frames.BlockedFrame:
+		info.Type = "BLOCKED"
+		info.StreamID = f.StreamID
+	case *frames.ConnectionCloseFrame:
+		info.Type = "CONNECTION_CLOSE"
+	case *frames.GoawayFrame:
+		info.Type = "GOAWAY"
+	case *frames.PingFrame:
+		info.Type = "PING"
+	default:
+		info.Type = "UNKNOWN"
+	}
+	return info
+}
+
+// frameTypes returns the types of the frames, as used in the FrameInfo
+func frameTypes(fs []frames.Frame) []string {
+	types := make([]string, len(fs))
+	for i, f := range fs {
+		types[i] = newFrameInfo(f, 0).Type
+	}
+	return types
+}
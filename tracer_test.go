@@ -0,0 +1,52 @@
+package quic
+
+import (
+	"github.com/lucas-clemente/quic-go/frames"
+	"github.com/lucas-clemente/quic-go/protocol"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type recordingTracer struct {
+	sent     []FrameInfo
+	received []FrameInfo
+}
+
+func (t *recordingTracer) SentFrame(f FrameInfo)     { t.sent = append(t.sent, f) }
+func (t *recordingTracer) ReceivedFrame(f FrameInfo) { t.received = append(t.received, f) }
+
+var _ Tracer = &recordingTracer{}
+
+var _ = Describe("Tracer", func() {
+	It("describes STREAM frames", func() {
+		info := newFrameInfo(&frames.StreamFrame{StreamID: 5, Data: []byte("foobar")}, 42)
+		Expect(info).To(Equal(FrameInfo{Type: "STREAM", StreamID: 5, PacketNumber: 42}))
+	})
+
+	It("describes ACK frames", func() {
+		info := newFrameInfo(&frames.AckFrame{LargestAcked: 10, LowestAcked: 1}, 42)
+		Expect(info).To(Equal(FrameInfo{Type: "ACK", PacketNumber: 42}))
+	})
+
+	It("sets the stream ID of stream-level control frames", func() {
+		Expect(newFrameInfo(&frames.RstStreamFrame{StreamID: 7}, 1).StreamID).To(Equal(protocol.StreamID(7)))
+		Expect(newFrameInfo(&frames.WindowUpdateFrame{StreamID: 9}, 1).StreamID).To(Equal(protocol.StreamID(9)))
+		Expect(newFrameInfo(&frames.BlockedFrame{StreamID: 11}, 1).StreamID).To(Equal(protocol.StreamID(11)))
+	})
+
+	It("names all frame types", func() {
+		fs := map[string]frames.Frame{
+			"STOP_WAITING":     &frames.StopWaitingFrame{},
+			"RST_STREAM":       &frames.RstStreamFrame{},
+			"WINDOW_UPDATE":    &frames.WindowUpdateFrame{},
+			"BLOCKED":          &frames.BlockedFrame{},
+			"CONNECTION_CLOSE": &frames.ConnectionCloseFrame{},
+			"GOAWAY":           &frames.GoawayFrame{},
+			"PING":             &frames.PingFrame{},
+		}
+		for name, f := range fs {
+			Expect(newFrameInfo(f, 1).Type).To(Equal(name))
+		}
+	})
+})
@@ -1,16 +1,16 @@
 package utils
 
 import (
-	"crypto/rand"
 	"encoding/binary"
+	"io"
 
 	"github.com/lucas-clemente/quic-go/protocol"
 )
 
-// GenerateConnectionID generates a connection ID using cryptographic random
-func GenerateConnectionID() (protocol.ConnectionID, error) {
+// GenerateConnectionID generates a connection ID, reading the random bytes from rand
+func GenerateConnectionID(rand io.Reader) (protocol.ConnectionID, error) {
 	b := make([]byte, 8)
-	_, err := rand.Read(b)
+	_, err := io.ReadFull(rand, b)
 	if err != nil {
 		return 0, err
 	}
@@ -1,17 +1,31 @@
 package utils
 
 import (
+	"bytes"
+	"crypto/rand"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
 
 var _ = Describe("Connection ID generation", func() {
 	It("generates random connection IDs", func() {
-		c1, err := GenerateConnectionID()
+		c1, err := GenerateConnectionID(rand.Reader)
 		Expect(err).ToNot(HaveOccurred())
 		Expect(c1).ToNot(BeZero())
-		c2, err := GenerateConnectionID()
+		c2, err := GenerateConnectionID(rand.Reader)
 		Expect(err).ToNot(HaveOccurred())
 		Expect(c1).ToNot(Equal(c2))
 	})
+
+	It("reads the connection ID from the random source", func() {
+		c, err := GenerateConnectionID(bytes.NewReader([]byte{1, 2, 3, 4, 5, 6, 7, 8}))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(c).To(BeEquivalentTo(0x0807060504030201))
+	})
+
+	It("errors if the random source doesn't return enough bytes", func() {
+		_, err := GenerateConnectionID(bytes.NewReader([]byte{1, 2, 3}))
+		Expect(err).To(HaveOccurred())
+	})
 })
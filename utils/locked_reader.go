@@ -0,0 +1,25 @@
+package utils
+
+import (
+	"io"
+	"sync"
+)
+
+// A LockedReader serializes the calls to Read of the underlying reader,
+// such that a reader that is not safe for concurrent use can be shared by multiple goroutines
+type LockedReader struct {
+	mutex sync.Mutex
+	r     io.Reader
+}
+
+// NewLockedReader creates a new LockedReader reading from r
+func NewLockedReader(r io.Reader) *LockedReader {
+	return &LockedReader{r: r}
+}
+
+// Read reads from the underlying reader, while holding the lock
+func (l *LockedReader) Read(p []byte) (int, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.r.Read(p)
+}
@@ -0,0 +1,41 @@
+package utils
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Locked Reader", func() {
+	It("reads from the underlying reader", func() {
+		r := NewLockedReader(bytes.NewReader([]byte("foobar")))
+		b := make([]byte, 6)
+		_, err := io.ReadFull(r, b)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(b).To(Equal([]byte("foobar")))
+	})
+
+	It("is safe for concurrent use", func() {
+		// run with -race to detect data races
+		// a *rand.Rand is not safe for concurrent use
+		r := NewLockedReader(rand.New(rand.NewSource(42)))
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				b := make([]byte, 8)
+				for j := 0; j < 100; j++ {
+					_, err := io.ReadFull(r, b)
+					Expect(err).ToNot(HaveOccurred())
+				}
+			}()
+		}
+		wg.Wait()
+	})
+})
@@ -1,9 +1,13 @@
 package utils
 
 import (
+	"fmt"
+	"io"
 	"log"
 	"os"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -23,55 +27,160 @@ const (
 	LogLevelNothing
 )
 
-var (
-	logLevel   = LogLevelNothing
-	timeFormat = ""
-)
+// A Logger logs messages, if they are of the log level of the Logger or above.
+// It is safe for concurrent use.
+type Logger struct {
+	*logSettings
+	prefix string
+}
+
+// logSettings are shared between a Logger and the Loggers created from it using WithPrefix
+type logSettings struct {
+	level uint32 // atomic, holds a LogLevel
+
+	mutex sync.RWMutex
+	// out is the logger the messages are written to
+	// if nil, the standard logger of the log package is used
+	out        *log.Logger
+	timeFormat string
+}
+
+// DefaultLogger is the Logger used by the package-level logging functions
+var DefaultLogger = NewLogger()
+
+// NewLogger creates a new Logger. It doesn't log anything until a log level is set.
+// It writes to the standard logger of the log package, unless a different writer is set.
+func NewLogger() *Logger {
+	return &Logger{logSettings: &logSettings{level: uint32(LogLevelNothing)}}
+}
+
+// WithPrefix creates a new Logger that prepends prefix to all messages.
+// It shares the log level, writer and time format with l: changing them on either Logger changes them for both.
+func (l *Logger) WithPrefix(prefix string) *Logger {
+	return &Logger{
+		logSettings: l.logSettings,
+		prefix:      l.prefix + prefix,
+	}
+}
 
 // SetLogLevel sets the log level
-func SetLogLevel(level LogLevel) {
-	logLevel = level
+func (l *Logger) SetLogLevel(level LogLevel) {
+	atomic.StoreUint32(&l.level, uint32(level))
+}
+
+// Level returns the log level
+func (l *Logger) Level() LogLevel {
+	return LogLevel(atomic.LoadUint32(&l.level))
+}
+
+// SetLogWriter sets the writer that messages are written to
+// nil restores the default, the standard logger of the log package
+func (l *Logger) SetLogWriter(w io.Writer) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if w == nil {
+		l.out = nil
+		return
+	}
+	// keep the flags of the current output, they are reset by SetLogTimeFormat
+	flags := log.Flags()
+	if l.out != nil {
+		flags = l.out.Flags()
+	}
+	l.out = log.New(w, "", flags)
 }
 
 // SetLogTimeFormat sets the format of the timestamp
 // an empty string disables the logging of timestamps
-func SetLogTimeFormat(format string) {
-	log.SetFlags(0) // disable timestamp logging done by the log package
-	timeFormat = format
+func (l *Logger) SetLogTimeFormat(format string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	// disable timestamp logging done by the log package
+	if l.out == nil {
+		log.SetFlags(0)
+	} else {
+		l.out.SetFlags(0)
+	}
+	l.timeFormat = format
 }
 
 // Debugf logs something
-func Debugf(format string, args ...interface{}) {
-	if logLevel == LogLevelDebug {
-		logMessage(format, args...)
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	if l.Level() == LogLevelDebug {
+		l.logMessage(format, args...)
 	}
 }
 
 // Infof logs something
-func Infof(format string, args ...interface{}) {
-	if logLevel <= LogLevelInfo {
-		logMessage(format, args...)
+func (l *Logger) Infof(format string, args ...interface{}) {
+	if l.Level() <= LogLevelInfo {
+		l.logMessage(format, args...)
 	}
 }
 
 // Errorf logs something
-func Errorf(format string, args ...interface{}) {
-	if logLevel <= LogLevelError {
-		logMessage(format, args...)
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	if l.Level() <= LogLevelError {
+		l.logMessage(format, args...)
 	}
 }
 
-func logMessage(format string, args ...interface{}) {
-	if len(timeFormat) > 0 {
-		log.Printf(time.Now().Format(timeFormat)+" "+format, args...)
+// Debug returns true if the log level is LogLevelDebug
+func (l *Logger) Debug() bool {
+	return l.Level() == LogLevelDebug
+}
+
+func (l *Logger) logMessage(format string, args ...interface{}) {
+	// the prefix and the time are not part of the format string, since they might contain a %
+	msg := l.prefix + fmt.Sprintf(format, args...)
+	l.mutex.RLock()
+	out := l.out
+	if len(l.timeFormat) > 0 {
+		msg = time.Now().Format(l.timeFormat) + " " + msg
+	}
+	l.mutex.RUnlock()
+	if out == nil {
+		log.Print(msg)
 	} else {
-		log.Printf(format, args...)
+		out.Print(msg)
 	}
 }
 
+// SetLogLevel sets the log level of the DefaultLogger
+func SetLogLevel(level LogLevel) {
+	DefaultLogger.SetLogLevel(level)
+}
+
+// SetLogWriter sets the writer of the DefaultLogger
+// nil restores the default, the standard logger of the log package
+func SetLogWriter(w io.Writer) {
+	DefaultLogger.SetLogWriter(w)
+}
+
+// SetLogTimeFormat sets the format of the timestamp of the DefaultLogger
+// an empty string disables the logging of timestamps
+func SetLogTimeFormat(format string) {
+	DefaultLogger.SetLogTimeFormat(format)
+}
+
+// Debugf logs something
+func Debugf(format string, args ...interface{}) {
+	DefaultLogger.Debugf(format, args...)
+}
+
+// Infof logs something
+func Infof(format string, args ...interface{}) {
+	DefaultLogger.Infof(format, args...)
+}
+
+// Errorf logs something
+func Errorf(format string, args ...interface{}) {
+	DefaultLogger.Errorf(format, args...)
+}
+
 // Debug returns true if the log level is LogLevelDebug
 func Debug() bool {
-	return logLevel == LogLevelDebug
+	return DefaultLogger.Debug()
 }
 
 func init() {
@@ -87,5 +196,5 @@ func readLoggingEnv() {
 	if err != nil {
 		return
 	}
-	logLevel = LogLevel(level)
+	DefaultLogger.SetLogLevel(LogLevel(level))
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"log"
 	"os"
+	"sync"
 	"time"
 
 	. "github.com/onsi/ginkgo"
@@ -20,13 +21,13 @@ var _ = Describe("Log", func() {
 	BeforeEach(func() {
 		b = bytes.NewBuffer([]byte{})
 		log.SetOutput(b)
-		initialTimeFormat = timeFormat
+		initialTimeFormat = DefaultLogger.timeFormat
 	})
 
 	AfterEach(func() {
 		log.SetOutput(os.Stdout)
 		SetLogLevel(LogLevelNothing)
-		timeFormat = initialTimeFormat
+		DefaultLogger.timeFormat = initialTimeFormat
 	})
 
 	It("log level nothing", func() {
@@ -91,19 +92,115 @@ var _ = Describe("Log", func() {
 	})
 
 	It("reads log level from env", func() {
-		Expect(logLevel).To(Equal(LogLevelNothing))
+		Expect(DefaultLogger.Level()).To(Equal(LogLevelNothing))
 		os.Setenv(logEnv, "1")
 		readLoggingEnv()
-		Expect(logLevel).To(Equal(LogLevelDebug))
+		Expect(DefaultLogger.Level()).To(Equal(LogLevelDebug))
 	})
 
 	It("does not error reading invalid log levels from env", func() {
-		Expect(logLevel).To(Equal(LogLevelNothing))
+		Expect(DefaultLogger.Level()).To(Equal(LogLevelNothing))
 		os.Setenv(logEnv, "")
 		readLoggingEnv()
-		Expect(logLevel).To(Equal(LogLevelNothing))
+		Expect(DefaultLogger.Level()).To(Equal(LogLevelNothing))
 		os.Setenv(logEnv, "asdf")
 		readLoggingEnv()
-		Expect(logLevel).To(Equal(LogLevelNothing))
+		Expect(DefaultLogger.Level()).To(Equal(LogLevelNothing))
+	})
+
+	It("writes to a custom writer", func() {
+		w := &bytes.Buffer{}
+		SetLogWriter(w)
+		defer SetLogWriter(nil)
+		SetLogTimeFormat("")
+		SetLogLevel(LogLevelInfo)
+		Infof("info")
+		Expect(w.String()).To(Equal("info\n"))
+		Expect(b.Len()).To(BeZero())
+	})
+
+	Context("Logger", func() {
+		var (
+			l *Logger
+			w *bytes.Buffer
+		)
+
+		BeforeEach(func() {
+			w = &bytes.Buffer{}
+			l = NewLogger()
+			l.SetLogWriter(w)
+			l.SetLogTimeFormat("")
+		})
+
+		It("doesn't log anything by default", func() {
+			l = NewLogger()
+			Expect(l.Level()).To(Equal(LogLevelNothing))
+			l.Errorf("err")
+			Expect(b.Len()).To(BeZero())
+		})
+
+		It("has its own log level", func() {
+			l.SetLogLevel(LogLevelDebug)
+			Expect(l.Debug()).To(BeTrue())
+			Expect(Debug()).To(BeFalse())
+			l.Debugf("debug")
+			Debugf("default")
+			Expect(w.String()).To(Equal("debug\n"))
+			Expect(b.Len()).To(BeZero())
+		})
+
+		It("adds a prefix", func() {
+			l.SetLogLevel(LogLevelInfo)
+			pl := l.WithPrefix("foo ")
+			Expect(pl.Level()).To(Equal(LogLevelInfo))
+			pl.Infof("info %d", 42)
+			pl.WithPrefix("bar ").Errorf("err")
+			Expect(w.String()).To(Equal("foo info 42\nfoo bar err\n"))
+		})
+
+		It("doesn't interpret the prefix as a format string", func() {
+			l.SetLogLevel(LogLevelInfo)
+			l.WithPrefix("100% ").Infof("info %d", 42)
+			Expect(w.String()).To(Equal("100% info 42\n"))
+		})
+
+		It("shares the settings with a prefixed logger", func() {
+			l.SetLogLevel(LogLevelInfo)
+			pl := l.WithPrefix("foo ")
+			l.SetLogLevel(LogLevelNothing)
+			Expect(pl.Level()).To(Equal(LogLevelNothing))
+			pl.Infof("info")
+			Expect(w.Len()).To(BeZero())
+			w2 := &bytes.Buffer{}
+			pl.SetLogWriter(w2)
+			pl.SetLogLevel(LogLevelInfo)
+			l.Infof("info")
+			Expect(w2.String()).To(Equal("info\n"))
+		})
+
+		It("is safe for concurrent use", func() {
+			// run with -race to detect data races
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer GinkgoRecover()
+					defer wg.Done()
+					for j := 0; j < 100; j++ {
+						l.SetLogLevel(LogLevel(j % 4))
+						SetLogLevel(LogLevel(j % 4))
+						if j%10 == 0 {
+							l.SetLogTimeFormat("15:04:05")
+						}
+						l.Infof("goroutine %d", i)
+						Errorf("goroutine %d", i)
+						l.WithPrefix("foo ").Debugf("goroutine %d", i)
+						_ = Debug()
+					}
+				}(i)
+			}
+			wg.Wait()
+			Expect(w.String()).To(ContainSubstring("goroutine"))
+		})
 	})
 })
@@ -72,11 +72,23 @@ var _ = Describe("Min / Max", func() {
 			Expect(MinByteCount(5, 7)).To(Equal(protocol.ByteCount(5)))
 		})
 
+		It("returns the maximum ByteCount", func() {
+			Expect(MaxByteCount(7, 5)).To(Equal(protocol.ByteCount(7)))
+			Expect(MaxByteCount(5, 7)).To(Equal(protocol.ByteCount(7)))
+		})
+
 		It("returns packet number min", func() {
 			Expect(MinPacketNumber(1, 2)).To(Equal(protocol.PacketNumber(1)))
 			Expect(MinPacketNumber(2, 1)).To(Equal(protocol.PacketNumber(1)))
 		})
 
+		It("returns the maximum time", func() {
+			a := time.Now()
+			b := a.Add(time.Second)
+			Expect(MaxTime(a, b)).To(Equal(b))
+			Expect(MaxTime(b, a)).To(Equal(b))
+		})
+
 		It("returns the minimum time", func() {
 			a := time.Now()
 			b := a.Add(time.Second)
@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+var (
+	structuredLogEnabled AtomicBool
+
+	structuredLogMutex  sync.Mutex
+	structuredLogWriter io.Writer
+)
+
+// SetStructuredLogWriter sets the writer for the structured log, a machine-readable stream of events (e.g. packets sent and received), one JSON object per line.
+// Every object contains the time and the name of the event, in addition to the fields of the event.
+// Passing nil disables the structured log. It is disabled by default.
+func SetStructuredLogWriter(w io.Writer) {
+	structuredLogMutex.Lock()
+	structuredLogWriter = w
+	structuredLogEnabled.Set(w != nil)
+	structuredLogMutex.Unlock()
+}
+
+// StructuredLogging returns true if a structured log writer is set
+// Callers should check it before collecting the fields for LogEvent, so that the structured log doesn't cause any allocations when it is disabled.
+func StructuredLogging() bool {
+	return structuredLogEnabled.Get()
+}
+
+// LogEvent writes an event to the structured log
+// the keys "time" and "event" are reserved, and are overwritten with the current time and the name of the event
+func LogEvent(name string, fields map[string]interface{}) {
+	if !StructuredLogging() {
+		return
+	}
+	event := make(map[string]interface{}, len(fields)+2)
+	for k, v := range fields {
+		event[k] = v
+	}
+	event["time"] = time.Now().Format(time.RFC3339Nano)
+	event["event"] = name
+	data, err := json.Marshal(event)
+	if err != nil {
+		Errorf("Error encoding event %s for the structured log: %s", name, err.Error())
+		return
+	}
+	data = append(data, '\n')
+
+	structuredLogMutex.Lock()
+	defer structuredLogMutex.Unlock()
+	if structuredLogWriter != nil {
+		structuredLogWriter.Write(data)
+	}
+}
@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Structured Log", func() {
+	var b *bytes.Buffer
+
+	// readEvents parses the JSON objects written to the buffer
+	readEvents := func() []map[string]interface{} {
+		var events []map[string]interface{}
+		scanner := bufio.NewScanner(b)
+		for scanner.Scan() {
+			var event map[string]interface{}
+			ExpectWithOffset(1, json.Unmarshal(scanner.Bytes(), &event)).To(Succeed())
+			events = append(events, event)
+		}
+		return events
+	}
+
+	BeforeEach(func() {
+		b = &bytes.Buffer{}
+	})
+
+	AfterEach(func() {
+		SetStructuredLogWriter(nil)
+	})
+
+	It("is disabled by default", func() {
+		Expect(StructuredLogging()).To(BeFalse())
+	})
+
+	It("doesn't write anything when no writer is set", func() {
+		LogEvent("packet_sent", map[string]interface{}{"packet_number": 1})
+		Expect(b.Len()).To(BeZero())
+	})
+
+	It("writes a JSON object for a packet sent event", func() {
+		SetStructuredLogWriter(b)
+		Expect(StructuredLogging()).To(BeTrue())
+		LogEvent("packet_sent", map[string]interface{}{
+			"packet_number": 0x1337,
+			"size":          1200,
+			"frames":        []string{"STREAM", "ACK"},
+		})
+		events := readEvents()
+		Expect(events).To(HaveLen(1))
+		event := events[0]
+		Expect(event).To(HaveKeyWithValue("event", "packet_sent"))
+		Expect(event).To(HaveKeyWithValue("packet_number", float64(0x1337)))
+		Expect(event).To(HaveKeyWithValue("size", float64(1200)))
+		Expect(event).To(HaveKeyWithValue("frames", []interface{}{"STREAM", "ACK"}))
+		Expect(event).To(HaveKey("time"))
+		t, err := time.Parse(time.RFC3339Nano, event["time"].(string))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(t).To(BeTemporally("~", time.Now(), time.Second))
+	})
+
+	It("writes one object per line", func() {
+		SetStructuredLogWriter(b)
+		LogEvent("foo", map[string]interface{}{"a": 1})
+		LogEvent("bar", nil)
+		events := readEvents()
+		Expect(events).To(HaveLen(2))
+		Expect(events[0]).To(HaveKeyWithValue("event", "foo"))
+		Expect(events[1]).To(HaveKeyWithValue("event", "bar"))
+	})
+
+	It("doesn't modify the fields passed in", func() {
+		SetStructuredLogWriter(b)
+		fields := map[string]interface{}{"event": "foobar"}
+		LogEvent("foo", fields)
+		Expect(fields).To(Equal(map[string]interface{}{"event": "foobar"}))
+		Expect(readEvents()[0]).To(HaveKeyWithValue("event", "foo"))
+	})
+
+	It("stops writing when the writer is unset", func() {
+		SetStructuredLogWriter(b)
+		SetStructuredLogWriter(nil)
+		Expect(StructuredLogging()).To(BeFalse())
+		LogEvent("foo", nil)
+		Expect(b.Len()).To(BeZero())
+	})
+})